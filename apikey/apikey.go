@@ -0,0 +1,159 @@
+// Package apikey provides a persistent store of API keys, each scoped to a subset of devices.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxName is the maximum length of the name of a key.
+const maxName = 64
+
+// ErrNotFound is returned when no key matches.
+var ErrNotFound = errors.New("key not found")
+
+// Key is an API key. Its secret is only known when the key is created, and is stored by its hash.
+type Key struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Hash    string    `json:"hash"`
+	Devices []string  `json:"devices"`
+	Created time.Time `json:"created"`
+	// AllowUnknown allows the key to wake MAC addresses that are not stored, in addition to its devices.
+	AllowUnknown bool `json:"allowUnknown,omitempty"`
+}
+
+type keys struct {
+	Keys []Key `json:"keys"`
+}
+
+// Store is a set of keys stored in a file.
+type Store struct {
+	name   string
+	mu     sync.Mutex
+	now    func() time.Time
+	random io.Reader
+}
+
+// Open opens the keys stored in file name, which is created when the first key is created.
+func Open(name string) *Store { return &Store{name: name, now: time.Now, random: rand.Reader} }
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Store) read() (keys, error) {
+	var ks keys
+	data, err := ioutil.ReadFile(s.name)
+	if os.IsNotExist(err) {
+		return ks, nil
+	} else if err != nil {
+		return ks, err
+	}
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return ks, err
+	}
+	return ks, nil
+}
+
+func (s *Store) write(ks keys) error {
+	data, err := json.Marshal(ks)
+	if err != nil {
+		return err
+	}
+	// Keys grant access to devices, so the file is only readable by its owner
+	return ioutil.WriteFile(s.name, data, 0600)
+}
+
+// List returns all keys, in order of creation.
+func (s *Store) List() ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ks, err := s.read()
+	return ks.Keys, err
+}
+
+// Create creates a key named name, which is scoped to the devices having the IDs in devices, and to unknown MAC
+// addresses if allowUnknown is true. The key is returned together with its secret.
+func (s *Store) Create(name string, devices []string, allowUnknown bool) (Key, string, error) {
+	if name == "" || len(name) > maxName {
+		return Key{}, "", fmt.Errorf("invalid name: %q", name)
+	}
+	if len(devices) == 0 {
+		return Key{}, "", fmt.Errorf("key %s must be scoped to at least one device", name)
+	}
+	b := make([]byte, 8+32)
+	if _, err := io.ReadFull(s.random, b); err != nil {
+		return Key{}, "", err
+	}
+	secret := hex.EncodeToString(b[8:])
+	key := Key{
+		ID:           hex.EncodeToString(b[:8]),
+		Name:         name,
+		Hash:         hash(secret),
+		Devices:      devices,
+		AllowUnknown: allowUnknown,
+		Created:      s.now().UTC(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ks, err := s.read()
+	if err != nil {
+		return Key{}, "", err
+	}
+	for _, k := range ks.Keys {
+		if k.Name == name {
+			return Key{}, "", fmt.Errorf("duplicate key: %s", name)
+		}
+	}
+	ks.Keys = append(ks.Keys, key)
+	if err := s.write(ks); err != nil {
+		return Key{}, "", err
+	}
+	return key, secret, nil
+}
+
+// Delete deletes the key having ID id, and returns ErrNotFound if there is no such key.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ks, err := s.read()
+	if err != nil {
+		return err
+	}
+	for i, k := range ks.Keys {
+		if k.ID == id {
+			ks.Keys = append(ks.Keys[:i:i], ks.Keys[i+1:]...)
+			return s.write(ks)
+		}
+	}
+	return ErrNotFound
+}
+
+// Authenticate returns the key having secret, or ErrNotFound if there is no such key.
+func (s *Store) Authenticate(secret string) (Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ks, err := s.read()
+	if err != nil {
+		return Key{}, err
+	}
+	h := hash(secret)
+	for _, k := range ks.Keys {
+		if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(h)) == 1 {
+			return k, nil
+		}
+	}
+	return Key{}, ErrNotFound
+}
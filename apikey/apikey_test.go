@@ -0,0 +1,79 @@
+package apikey
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := Open(filepath.Join(dir, "keys"))
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	s.random = bytes.NewReader(bytes.Repeat([]byte{1}, 80))
+
+	if keys, err := s.List(); err != nil || len(keys) != 0 {
+		t.Fatalf("want no keys, got %+v (%v)", keys, err)
+	}
+	guest, secret, err := s.Create("guest", []string{"1"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if guest.ID != "0101010101010101" || secret != "0101010101010101010101010101010101010101010101010101010101010101" ||
+		!guest.Created.Equal(now) {
+		t.Errorf("got key %+v and secret %s", guest, secret)
+	}
+	s.random = bytes.NewReader(bytes.Repeat([]byte{2}, 40))
+	if _, _, err := s.Create("family", []string{"1", "2"}, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		devices []string
+		err     string
+	}{
+		{"", []string{"1"}, `invalid name: ""`},
+		{"guest", nil, "key guest must be scoped to at least one device"},
+		{"guest", []string{"2"}, "duplicate key: guest"},
+	} {
+		s.random = bytes.NewReader(bytes.Repeat([]byte{3}, 40))
+		if _, _, err := s.Create(tt.name, tt.devices, false); err == nil || err.Error() != tt.err {
+			t.Errorf("Create(%q, %q): want error %q, got %v", tt.name, tt.devices, tt.err, err)
+		}
+	}
+
+	if k, err := s.Authenticate(secret); err != nil || k.Name != "guest" || len(k.Devices) != 1 || k.AllowUnknown {
+		t.Errorf("want key guest, got %+v (%v)", k, err)
+	}
+	if _, err := s.Authenticate(guest.Hash); err != ErrNotFound {
+		t.Errorf("want %v, got %v", ErrNotFound, err)
+	}
+	fi, err := os.Stat(s.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("want mode 0600, got %s", fi.Mode())
+	}
+
+	if err := s.Delete(guest.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(guest.ID); err != ErrNotFound {
+		t.Errorf("want %v, got %v", ErrNotFound, err)
+	}
+	if _, err := s.Authenticate(secret); err != ErrNotFound {
+		t.Errorf("want %v after deletion, got %v", ErrNotFound, err)
+	}
+	if keys, err := s.List(); err != nil || len(keys) != 1 || keys[0].Name != "family" || !keys[0].AllowUnknown {
+		t.Errorf("want key family, got %+v (%v)", keys, err)
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// RoleUser allows listing and waking devices.
+	RoleUser = "user"
+	// RoleAdmin allows everything, including removing devices and using admin endpoints.
+	RoleAdmin = "admin"
+)
+
+// ErrInvalidCredentials is returned when authentication fails.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// User is an authenticated user.
+type User struct {
+	Name   string
+	Roles  []string
+	Groups []string
+	// Scope restricts the user to the devices having these IDs, if not nil. Such users can only see and wake the
+	// devices in their scope.
+	Scope []string
+	// AllowUnknown allows users restricted to a scope, and users waking devices remotely, to wake MAC addresses that
+	// are not stored, without storing them.
+	AllowUnknown bool
+}
+
+// InGroup reports whether u is a member of group.
+func (u *User) InGroup(group string) bool {
+	for _, g := range u.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether u has role. Admins have all roles.
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator authenticates users by username and password.
+type Authenticator interface {
+	Authenticate(username, password string) (*User, error)
+}
+
+// ParseRoles parses role mappings on the form ROLE=VALUE.
+func ParseRoles(roles []string) (map[string]string, error) {
+	m := make(map[string]string, len(roles))
+	for _, s := range roles {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid role mapping: %s", s)
+		}
+		switch parts[0] {
+		case RoleUser, RoleAdmin:
+		default:
+			return nil, fmt.Errorf("invalid role: %s", parts[0])
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m, nil
+}
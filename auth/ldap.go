@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(*ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+type cachedUser struct {
+	user    *User
+	expires time.Time
+}
+
+// LDAP authenticates users against an LDAP or Active Directory server. The user is located by searching BaseDN with
+// UserFilter, and authenticated by binding as the user. Roles are granted by searching BaseDN with the group filter of
+// each role, restricted to groups having the user as a member. If there is no mapping for RoleUser, all authenticated
+// users are granted that role. The common name of all groups having the user as a member are added to the user.
+type LDAP struct {
+	URL          string
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter is the filter used to find users, where %s is replaced by the username, e.g. (uid=%s).
+	UserFilter string
+	// Roles maps roles to group filters, e.g. admin=(cn=wake-admins).
+	Roles map[string]string
+	// CacheTTL is the duration successful authentications are cached.
+	CacheTTL time.Duration
+	dial     func() (ldapConn, error)
+	mu       sync.Mutex
+	cache    map[[sha256.Size]byte]cachedUser
+	now      func() time.Time
+}
+
+// NewLDAP creates a new LDAP authenticator for the server at url.
+func NewLDAP(url, baseDN string) *LDAP {
+	l := &LDAP{
+		URL:        url,
+		BaseDN:     baseDN,
+		UserFilter: "(uid=%s)",
+		CacheTTL:   time.Minute,
+		cache:      make(map[[sha256.Size]byte]cachedUser),
+		now:        time.Now,
+	}
+	l.dial = func() (ldapConn, error) { return ldap.DialURL(l.URL) }
+	return l
+}
+
+// Authenticate authenticates username by binding to the LDAP server with password.
+func (l *LDAP) Authenticate(username, password string) (*User, error) {
+	// An empty password results in an unauthenticated bind, which most servers accept
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	key := sha256.Sum256([]byte(username + "\x00" + password))
+	l.mu.Lock()
+	c, ok := l.cache[key]
+	l.mu.Unlock()
+	if ok && l.now().Before(c.expires) {
+		return c.user, nil
+	}
+	u, err := l.authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	l.cache[key] = cachedUser{user: u, expires: l.now().Add(l.CacheTTL)}
+	l.mu.Unlock()
+	return u, nil
+}
+
+func (l *LDAP) authenticate(username, password string) (*User, error) {
+	conn, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if l.BindDN != "" {
+		if err := conn.Bind(l.BindDN, l.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap: service bind failed: %s", err)
+		}
+	}
+	res, err := l.search(conn, fmt.Sprintf(l.UserFilter, ldap.EscapeFilter(username)))
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	userDN := res.Entries[0].DN
+	if err := conn.Bind(userDN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	// Search for groups using the service account, as users may not be allowed to read group membership
+	if l.BindDN != "" {
+		if err := conn.Bind(l.BindDN, l.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap: service bind failed: %s", err)
+		}
+	}
+	u := &User{Name: username}
+	if _, ok := l.Roles[RoleUser]; !ok {
+		u.Roles = append(u.Roles, RoleUser)
+	}
+	res, err = l.search(conn, fmt.Sprintf("(member=%s)", ldap.EscapeFilter(userDN)), "cn")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range res.Entries {
+		if cn := e.GetAttributeValue("cn"); cn != "" {
+			u.Groups = append(u.Groups, cn)
+		}
+	}
+	for _, role := range []string{RoleUser, RoleAdmin} {
+		groupFilter, ok := l.Roles[role]
+		if !ok {
+			continue
+		}
+		res, err := l.search(conn, fmt.Sprintf("(&%s(member=%s))", groupFilter, ldap.EscapeFilter(userDN)))
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Entries) > 0 {
+			u.Roles = append(u.Roles, role)
+		}
+	}
+	return u, nil
+}
+
+func (l *LDAP) search(conn ldapConn, filter string, attributes ...string) (*ldap.SearchResult, error) {
+	if len(attributes) == 0 {
+		attributes = []string{"dn"}
+	}
+	return conn.Search(ldap.NewSearchRequest(l.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter, attributes, nil))
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+type mockConn struct {
+	passwords map[string]string
+	groups    map[string][]string
+	binds     int
+}
+
+func (c *mockConn) Bind(username, password string) error {
+	c.binds++
+	if p, ok := c.passwords[username]; !ok || p != password {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, nil)
+	}
+	return nil
+}
+
+func (c *mockConn) Search(r *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	var res ldap.SearchResult
+	switch {
+	case strings.HasPrefix(r.Filter, "(uid="):
+		uid := strings.TrimSuffix(strings.TrimPrefix(r.Filter, "(uid="), ")")
+		dn := "uid=" + uid + ",dc=example,dc=com"
+		if _, ok := c.passwords[dn]; ok {
+			res.Entries = append(res.Entries, &ldap.Entry{DN: dn})
+		}
+	default:
+		for group, members := range c.groups {
+			for _, m := range members {
+				if strings.Contains(r.Filter, group) && strings.Contains(r.Filter, "(member="+m+")") {
+					res.Entries = append(res.Entries, &ldap.Entry{DN: group})
+				}
+			}
+		}
+	}
+	return &res, nil
+}
+
+func (c *mockConn) Close() {}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	conn := &mockConn{
+		passwords: map[string]string{
+			"cn=svc,dc=example,dc=com":    "svc",
+			"uid=alice,dc=example,dc=com": "secret",
+			"uid=bob,dc=example,dc=com":   "hunter2",
+		},
+		groups: map[string][]string{"cn=wake-admins": {"uid=alice,dc=example,dc=com"}},
+	}
+	now := time.Now()
+	l := NewLDAP("ldap://localhost", "dc=example,dc=com")
+	l.BindDN = "cn=svc,dc=example,dc=com"
+	l.BindPassword = "svc"
+	l.Roles = map[string]string{RoleAdmin: "(cn=wake-admins)"}
+	l.dial = func() (ldapConn, error) { return conn, nil }
+	l.now = func() time.Time { return now }
+
+	var tests = []struct {
+		username string
+		password string
+		admin    bool
+		err      error
+	}{
+		{"alice", "secret", true, nil},
+		{"bob", "hunter2", false, nil},
+		{"bob", "", false, ErrInvalidCredentials},
+		{"bob", "wrong", false, ErrInvalidCredentials},
+		{"mallory", "secret", false, ErrInvalidCredentials},
+	}
+	for i, tt := range tests {
+		u, err := l.Authenticate(tt.username, tt.password)
+		if err != tt.err {
+			t.Errorf("#%d: want error %v, got %v", i, tt.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !u.HasRole(RoleUser) {
+			t.Errorf("#%d: want role %s", i, RoleUser)
+		}
+		if got := u.HasRole(RoleAdmin); got != tt.admin {
+			t.Errorf("#%d: want admin %t, got %t", i, tt.admin, got)
+		}
+	}
+
+	// Successful authentications are cached
+	binds := conn.binds
+	if _, err := l.Authenticate("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if conn.binds != binds {
+		t.Errorf("want cached authentication")
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := l.Authenticate("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if conn.binds == binds {
+		t.Errorf("want expired cache entry")
+	}
+}
+
+func TestParseRoles(t *testing.T) {
+	roles, err := ParseRoles([]string{"admin=(cn=wake-admins)", "user=(cn=staff)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := roles[RoleAdmin]; got != "(cn=wake-admins)" {
+		t.Errorf("want admin filter, got %q", got)
+	}
+	for _, in := range []string{"admin", "admin=", "foo=(cn=bar)"} {
+		if _, err := ParseRoles([]string{in}); err == nil {
+			t.Errorf("want error for %q", in)
+		}
+	}
+}
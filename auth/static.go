@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TokenAuthenticator authenticates clients by bearer token.
+type TokenAuthenticator interface {
+	AuthenticateToken(token string) (*User, error)
+}
+
+// Static authenticates users by passwords, and clients by bearer tokens, which are all given up front. Users and
+// clients are granted RoleUser.
+type Static struct {
+	// passwords holds the password of each user, which is either a bcrypt hash or plain text.
+	passwords map[string]string
+	// tokens holds the name of the client of each token, by its hash.
+	tokens map[[sha256.Size]byte]string
+}
+
+// NewStatic creates a static authenticator for users given as USER:PASSWORD and tokens given as NAME=TOKEN. A password
+// starting with $2 is a bcrypt hash, e.g. as created by htpasswd -B.
+func NewStatic(users, tokens []string) (*Static, error) {
+	s := &Static{passwords: make(map[string]string, len(users)), tokens: make(map[[sha256.Size]byte]string, len(tokens))}
+	for _, u := range users {
+		parts := strings.SplitN(u, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid user: %s", u)
+		}
+		if _, ok := s.passwords[parts[0]]; ok {
+			return nil, fmt.Errorf("duplicate user: %s", parts[0])
+		}
+		if isHash(parts[1]) {
+			if _, err := bcrypt.Cost([]byte(parts[1])); err != nil {
+				return nil, fmt.Errorf("invalid password hash of user %s: %s", parts[0], err)
+			}
+		}
+		s.passwords[parts[0]] = parts[1]
+	}
+	for _, t := range tokens {
+		parts := strings.SplitN(t, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			// Tokens are secret, so they are not included in the error
+			return nil, fmt.Errorf("invalid token: must be NAME=TOKEN")
+		}
+		key := sha256.Sum256([]byte(parts[1]))
+		if _, ok := s.tokens[key]; ok {
+			return nil, fmt.Errorf("duplicate token of client %s", parts[0])
+		}
+		s.tokens[key] = parts[0]
+	}
+	return s, nil
+}
+
+func isHash(password string) bool { return strings.HasPrefix(password, "$2") }
+
+// Authenticate authenticates username by password.
+func (s *Static) Authenticate(username, password string) (*User, error) {
+	want, ok := s.passwords[username]
+	if !ok || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	if isHash(want) {
+		if bcrypt.CompareHashAndPassword([]byte(want), []byte(password)) != nil {
+			return nil, ErrInvalidCredentials
+		}
+	} else if subtle.ConstantTimeCompare([]byte(want), []byte(password)) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return &User{Name: username, Roles: []string{RoleUser}}, nil
+}
+
+// AuthenticateToken authenticates the client having token.
+func (s *Static) AuthenticateToken(token string) (*User, error) {
+	// Tokens are looked up by their hash, which does not leak the token through timing
+	name, ok := s.tokens[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &User{Name: name, Roles: []string{RoleUser}}, nil
+}
+
+// Chain authenticates users with each of its authenticators in turn, until one of them accepts the credentials.
+type Chain []Authenticator
+
+// Authenticate authenticates username by password. An error other than ErrInvalidCredentials is returned if no
+// authenticator accepts the credentials, and any of them failed.
+func (c Chain) Authenticate(username, password string) (*User, error) {
+	return c.each(func(a Authenticator) (*User, error) { return a.Authenticate(username, password) })
+}
+
+// AuthenticateToken authenticates the client having token, using the authenticators supporting tokens.
+func (c Chain) AuthenticateToken(token string) (*User, error) {
+	return c.each(func(a Authenticator) (*User, error) {
+		if t, ok := a.(TokenAuthenticator); ok {
+			return t.AuthenticateToken(token)
+		}
+		return nil, ErrInvalidCredentials
+	})
+}
+
+func (c Chain) each(f func(Authenticator) (*User, error)) (*User, error) {
+	err := ErrInvalidCredentials
+	for _, a := range c {
+		u, aerr := f(a)
+		if aerr == nil {
+			return u, nil
+		}
+		if aerr != ErrInvalidCredentials {
+			err = aerr
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestStatic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStatic([]string{"alice:secret", "bob:" + string(hash), "carol:a:b"}, []string{"ci-bot=s3cr3t=="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		username, password string
+		ok                 bool
+	}{
+		{"alice", "secret", true},
+		{"alice", "Secret", false},
+		{"alice", "", false},
+		{"bob", "hunter2", true},
+		{"bob", string(hash), false},
+		{"carol", "a:b", true},
+		{"dave", "secret", false},
+	}
+	for _, tt := range tests {
+		u, err := s.Authenticate(tt.username, tt.password)
+		if tt.ok {
+			if err != nil || u.Name != tt.username || !u.HasRole(RoleUser) || u.HasRole(RoleAdmin) {
+				t.Errorf("Authenticate(%q, %q) = %+v, %v, want user %s", tt.username, tt.password, u, err, tt.username)
+			}
+		} else if err != ErrInvalidCredentials {
+			t.Errorf("Authenticate(%q, %q): want %v, got %v", tt.username, tt.password, ErrInvalidCredentials, err)
+		}
+	}
+	if u, err := s.AuthenticateToken("s3cr3t=="); err != nil || u.Name != "ci-bot" {
+		t.Errorf("want client ci-bot, got %+v (%v)", u, err)
+	}
+	if _, err := s.AuthenticateToken("s3cr3t"); err != ErrInvalidCredentials {
+		t.Errorf("want %v, got %v", ErrInvalidCredentials, err)
+	}
+
+	for _, tt := range []struct {
+		users, tokens []string
+		err           string
+	}{
+		{[]string{"alice"}, nil, "invalid user: alice"},
+		{[]string{":secret"}, nil, "invalid user: :secret"},
+		{[]string{"alice:a", "alice:b"}, nil, "duplicate user: alice"},
+		{[]string{"alice:$2a$10$foo"}, nil, "invalid password hash of user alice: crypto/bcrypt: hashedSecret too short to be a bcrypted password"},
+		{nil, []string{"s3cr3t"}, "invalid token: must be NAME=TOKEN"},
+		{nil, []string{"a=x", "b=x"}, "duplicate token of client b"},
+	} {
+		if _, err := NewStatic(tt.users, tt.tokens); err == nil || err.Error() != tt.err {
+			t.Errorf("NewStatic(%q, %q): want error %q, got %v", tt.users, tt.tokens, tt.err, err)
+		}
+	}
+}
+
+type failing struct{}
+
+func (failing) Authenticate(username, password string) (*User, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestChain(t *testing.T) {
+	s, err := NewStatic([]string{"alice:secret"}, []string{"ci-bot=s3cr3t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Chain{failing{}, s}
+	if u, err := c.Authenticate("alice", "secret"); err != nil || u.Name != "alice" {
+		t.Errorf("want user alice, got %+v (%v)", u, err)
+	}
+	if _, err := c.Authenticate("alice", "wrong"); err == nil || err.Error() != "unavailable" {
+		t.Errorf("want error of failing authenticator, got %v", err)
+	}
+	if u, err := c.AuthenticateToken("s3cr3t"); err != nil || u.Name != "ci-bot" {
+		t.Errorf("want client ci-bot, got %+v (%v)", u, err)
+	}
+	if _, err := (Chain{s}).Authenticate("alice", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("want %v, got %v", ErrInvalidCredentials, err)
+	}
+}
@@ -0,0 +1,130 @@
+// Package ban temporarily bans clients making suspicious requests, such as those scanning for endpoints or guessing
+// credentials.
+package ban
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ban is a ban of a client.
+type Ban struct {
+	Client string    `json:"client"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+}
+
+// List bans clients having made Threshold suspicious requests within Window for Duration.
+type List struct {
+	Threshold int
+	Window    time.Duration
+	Duration  time.Duration
+	mu        sync.Mutex
+	strikes   map[string][]time.Time
+	bans      map[string]Ban
+	pruned    time.Time
+	now       func() time.Time
+}
+
+// New creates a new list banning clients for duration after threshold suspicious requests within window. Clients are
+// only banned explicitly if threshold is zero.
+func New(threshold int, window, duration time.Duration) *List {
+	return &List{
+		Threshold: threshold,
+		Window:    window,
+		Duration:  duration,
+		strikes:   make(map[string][]time.Time),
+		bans:      make(map[string]Ban),
+		now:       time.Now,
+	}
+}
+
+// prune forgets strikes older than the window and bans that have expired.
+func (l *List) prune(now time.Time) {
+	if now.Sub(l.pruned) < l.Window {
+		return
+	}
+	l.pruned = now
+	for client, strikes := range l.strikes {
+		if !strikes[len(strikes)-1].After(now.Add(-l.Window)) {
+			delete(l.strikes, client)
+		}
+	}
+	for client, b := range l.bans {
+		if !now.Before(b.Until) {
+			delete(l.bans, client)
+		}
+	}
+}
+
+func (l *List) ban(client, reason string, now time.Time) Ban {
+	b := Ban{Client: client, Reason: reason, Since: now, Until: now.Add(l.Duration)}
+	l.bans[client] = b
+	delete(l.strikes, client)
+	return b
+}
+
+// Strike records a suspicious request made by client, for reason, and bans the client if it has made Threshold
+// suspicious requests within Window. The ban is returned if the client was banned by this request.
+func (l *List) Strike(client, reason string) (Ban, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.prune(now)
+	strikes := l.strikes[client]
+	i := 0
+	for i < len(strikes) && !strikes[i].After(now.Add(-l.Window)) {
+		i++
+	}
+	strikes = append(strikes[i:], now)
+	if l.Threshold > 0 && len(strikes) >= l.Threshold {
+		return l.ban(client, reason, now), true
+	}
+	l.strikes[client] = strikes
+	return Ban{}, false
+}
+
+// Ban bans client for reason immediately.
+func (l *List) Ban(client, reason string) Ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ban(client, reason, l.now())
+}
+
+// Banned returns the ban of client, if it is banned.
+func (l *List) Banned(client string) (Ban, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.bans[client]
+	if !ok || !l.now().Before(b.Until) {
+		return Ban{}, false
+	}
+	return b, true
+}
+
+// Unban lifts the ban of client, and reports whether it was banned.
+func (l *List) Unban(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.bans[client]
+	delete(l.bans, client)
+	delete(l.strikes, client)
+	return ok && l.now().Before(b.Until)
+}
+
+// Bans returns the current bans, ordered by client.
+func (l *List) Bans() []Ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	bans := make([]Ban, 0, len(l.bans))
+	for _, b := range l.bans {
+		if now.Before(b.Until) {
+			bans = append(bans, b)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Client < bans[j].Client })
+	return bans
+}
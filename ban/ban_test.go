@@ -0,0 +1,52 @@
+package ban
+
+import (
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := New(3, time.Minute, time.Hour)
+	l.now = func() time.Time { return now }
+
+	// Strikes outside the window are forgotten
+	l.Strike("192.0.2.1", "401")
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, banned := l.Strike("192.0.2.1", "401"); banned {
+			t.Fatalf("#%d: want client not banned", i)
+		}
+	}
+	b, banned := l.Strike("192.0.2.1", "404")
+	if !banned || b.Reason != "404" || !b.Until.Equal(now.Add(time.Hour)) {
+		t.Fatalf("want client banned for an hour, got %+v (%t)", b, banned)
+	}
+	if _, ok := l.Banned("192.0.2.1"); !ok {
+		t.Error("want client banned")
+	}
+	if _, ok := l.Banned("192.0.2.2"); ok {
+		t.Error("want other client not banned")
+	}
+
+	l.Ban("192.0.2.2", "trap")
+	if bans := l.Bans(); len(bans) != 2 || bans[0].Client != "192.0.2.1" || bans[1].Client != "192.0.2.2" {
+		t.Errorf("want 2 bans, got %+v", bans)
+	}
+	if !l.Unban("192.0.2.2") || l.Unban("192.0.2.2") {
+		t.Error("want client unbanned once")
+	}
+
+	// Bans expire
+	now = now.Add(time.Hour)
+	if _, ok := l.Banned("192.0.2.1"); ok {
+		t.Error("want ban expired")
+	}
+	if bans := l.Bans(); len(bans) != 0 {
+		t.Errorf("want no bans, got %+v", bans)
+	}
+	l.Strike("192.0.2.3", "401")
+	if len(l.bans) != 0 || len(l.strikes) != 1 {
+		t.Errorf("want expired bans and strikes pruned, got %d bans and %d strikes", len(l.bans), len(l.strikes))
+	}
+}
@@ -0,0 +1,249 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrExceeded is returned when sending a packet would exceed the budget.
+var ErrExceeded = errors.New("send budget exceeded")
+
+// Budget is a token bucket limiting the total number of magic packets sent per second. When the budget is exceeded a
+// circuit breaker trips, pausing automated wakes until the cooldown period has passed.
+type Budget struct {
+	rate        float64
+	burst       float64
+	cooldown    time.Duration
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	stats       Stats
+	now         func() time.Time
+	mu          sync.Mutex
+	queue       []*waiter
+	seq         uint64
+	changed     chan struct{}
+}
+
+// Priority is the priority of a packet waiting for the budget.
+type Priority int
+
+// Priorities, from highest to lowest.
+const (
+	// PriorityInteractive is used for wakes requested by a human.
+	PriorityInteractive Priority = iota
+	// PriorityScheduled is used for wakes triggered by a schedule.
+	PriorityScheduled
+	// PriorityRetry is used for wakes that are retried or resumed.
+	PriorityRetry
+)
+
+type waiter struct {
+	priority Priority
+	seq      uint64
+}
+
+// Stats contains counters for a budget.
+type Stats struct {
+	Allowed  uint64
+	Rejected uint64
+	Trips    uint64
+	Paused   bool
+}
+
+// New creates a new budget allowing rate packets per second, with bursts of up to burst packets. Automated wakes are
+// paused for cooldown after the budget is exceeded.
+func New(rate float64, burst int, cooldown time.Duration) *Budget {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Budget{
+		rate:     rate,
+		burst:    float64(burst),
+		cooldown: cooldown,
+		tokens:   float64(burst),
+		now:      time.Now,
+		changed:  make(chan struct{}),
+	}
+}
+
+func (b *Budget) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// Allow reports whether a packet can be sent now. Automated packets, i.e. those not sent on behalf of a human, are
+// rejected while the circuit breaker is open.
+func (b *Budget) Allow(automated bool) bool { return b.AllowN(automated, 1) }
+
+// AllowN reports whether n packets can be sent now, as Allow does for a single packet. Either all n packets are
+// allowed, or none are.
+func (b *Budget) AllowN(automated bool, n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if automated && now.Before(b.pausedUntil) {
+		b.stats.Rejected += uint64(n)
+		return false
+	}
+	b.refill(now)
+	if b.tokens < float64(n) || len(b.queue) > 0 {
+		b.stats.Rejected += uint64(n)
+		if !now.Before(b.pausedUntil) {
+			b.stats.Trips++
+		}
+		b.pausedUntil = now.Add(b.cooldown)
+		return false
+	}
+	b.tokens -= float64(n)
+	b.stats.Allowed += uint64(n)
+	return true
+}
+
+// head returns the waiter that is served next.
+func (b *Budget) head() *waiter {
+	var head *waiter
+	for _, w := range b.queue {
+		if head == nil || w.priority < head.priority || (w.priority == head.priority && w.seq < head.seq) {
+			head = w
+		}
+	}
+	return head
+}
+
+func (b *Budget) dequeue(w *waiter) {
+	for i, v := range b.queue {
+		if v == w {
+			b.queue = append(b.queue[:i], b.queue[i+1:]...)
+			break
+		}
+	}
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// Wait blocks until a packet having priority p can be sent, or ctx is done. Waiting packets are sent in order of
+// priority, so that a human waking a device is not queued behind a large scheduled wake. Unlike Allow, waiting for the
+// budget does not trip the circuit breaker, but automated packets are still rejected while it is open.
+func (b *Budget) Wait(ctx context.Context, p Priority) error { return b.WaitN(ctx, p, 1) }
+
+// WaitN blocks until n packets having priority p can be sent, as Wait does for a single packet. Packets exceeding the
+// burst of the budget can never be sent together, and are rejected without waiting.
+func (b *Budget) WaitN(ctx context.Context, p Priority, n int) error {
+	b.mu.Lock()
+	if (p != PriorityInteractive && b.now().Before(b.pausedUntil)) || float64(n) > b.burst {
+		b.stats.Rejected += uint64(n)
+		b.mu.Unlock()
+		return ErrExceeded
+	}
+	b.seq++
+	w := &waiter{priority: p, seq: b.seq}
+	b.queue = append(b.queue, w)
+	for {
+		now := b.now()
+		b.refill(now)
+		if b.head() == w && b.tokens >= float64(n) {
+			b.tokens -= float64(n)
+			b.stats.Allowed += uint64(n)
+			b.dequeue(w)
+			b.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((float64(n) - b.tokens) / b.rate * float64(time.Second))
+		if delay <= 0 {
+			delay = time.Millisecond
+		}
+		changed := b.changed
+		b.mu.Unlock()
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			b.mu.Lock()
+			b.stats.Rejected += uint64(n)
+			b.dequeue(w)
+			b.mu.Unlock()
+			return ErrExceeded
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+		b.mu.Lock()
+	}
+}
+
+// Waiting returns the number of packets waiting for the budget.
+func (b *Budget) Waiting() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.queue)
+}
+
+// Project reports which of the wakes sent at the given offsets from now would be allowed by the budget, assuming no
+// other packets are sent in the meantime. The wake at offsets[i] sends costs[i] packets together, as AllowN does.
+// Offsets must be in increasing order. The budget is not modified.
+func (b *Budget) Project(offsets []time.Duration, costs []int) []bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	tokens := b.tokens
+	if !b.last.IsZero() {
+		tokens += now.Sub(b.last).Seconds() * b.rate
+	}
+	allowed := make([]bool, len(offsets))
+	var prev time.Duration
+	for i, offset := range offsets {
+		tokens += (offset - prev).Seconds() * b.rate
+		if tokens > b.burst {
+			tokens = b.burst
+		}
+		prev = offset
+		if cost := float64(costs[i]); tokens >= cost {
+			tokens -= cost
+			allowed[i] = true
+		}
+	}
+	return allowed
+}
+
+// Remaining returns the size of the budget, the number of packets that can currently be sent, and the time until the
+// budget is full again.
+func (b *Budget) Remaining() (int, int, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tokens := b.tokens
+	if !b.last.IsZero() {
+		tokens += b.now().Sub(b.last).Seconds() * b.rate
+	}
+	if tokens > b.burst {
+		tokens = b.burst
+	}
+	var reset time.Duration
+	if b.rate > 0 {
+		reset = time.Duration((b.burst - tokens) / b.rate * float64(time.Second))
+	}
+	return int(b.burst), int(tokens), reset
+}
+
+// Paused reports whether automated wakes are currently paused.
+func (b *Budget) Paused() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.now().Before(b.pausedUntil)
+}
+
+// Stats returns the current counters of this budget.
+func (b *Budget) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.Paused = b.now().Before(b.pausedUntil)
+	return stats
+}
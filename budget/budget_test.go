@@ -0,0 +1,167 @@
+package budget
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Now()
+	b := New(1, 2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	// Burst is allowed
+	for i := 0; i < 2; i++ {
+		if !b.Allow(false) {
+			t.Fatalf("#%d: want packet allowed", i)
+		}
+	}
+	// Budget is exceeded, which trips the breaker
+	if b.Allow(false) {
+		t.Fatal("want packet rejected")
+	}
+	if !b.Paused() {
+		t.Fatal("want automation paused")
+	}
+
+	// Tokens are refilled, but automated packets are rejected until cooldown passes
+	now = now.Add(2 * time.Second)
+	if b.Allow(true) {
+		t.Fatal("want automated packet rejected")
+	}
+	if !b.Allow(false) {
+		t.Fatal("want interactive packet allowed")
+	}
+	now = now.Add(time.Minute)
+	if !b.Allow(true) {
+		t.Fatal("want automated packet allowed")
+	}
+
+	want := Stats{Allowed: 4, Rejected: 2, Trips: 1}
+	if got := b.Stats(); got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestAllowN(t *testing.T) {
+	now := time.Now()
+	b := New(1, 5, time.Minute)
+	b.now = func() time.Time { return now }
+
+	if !b.AllowN(false, 3) {
+		t.Fatal("want 3 packets allowed")
+	}
+	// Packets are allowed together or not at all
+	if b.AllowN(false, 3) {
+		t.Fatal("want 3 packets rejected")
+	}
+	now = now.Add(time.Minute)
+	if !b.AllowN(false, 5) {
+		t.Fatal("want 5 packets allowed")
+	}
+	// Packets exceeding the burst are rejected without waiting
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := b.WaitN(ctx, PriorityInteractive, 6); err != ErrExceeded {
+		t.Errorf("want %s, got %v", ErrExceeded, err)
+	}
+
+	want := Stats{Allowed: 8, Rejected: 9, Trips: 1}
+	if got := b.Stats(); got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestProject(t *testing.T) {
+	now := time.Now()
+	b := New(1, 2, time.Minute)
+	b.now = func() time.Time { return now }
+	b.Allow(false)
+
+	offsets := []time.Duration{0, 0, 500 * time.Millisecond, 2 * time.Second, 3 * time.Second, 4 * time.Second}
+	costs := []int{1, 1, 1, 1, 3, 2}
+	want := []bool{true, false, false, true, false, true}
+	got := b.Project(offsets, costs)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("#%d: want %t, got %t", i, want[i], got[i])
+		}
+	}
+	if stats := b.Stats(); stats.Allowed != 1 || stats.Rejected != 0 {
+		t.Errorf("want budget to be unmodified, got %+v", stats)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	now := time.Now()
+	b := New(0.5, 3, time.Minute)
+	b.now = func() time.Time { return now }
+	var tests = []struct {
+		allow     int
+		after     time.Duration
+		remaining int
+		reset     time.Duration
+	}{
+		{0, 0, 3, 0},
+		{2, 0, 1, 4 * time.Second},
+		{0, time.Second, 1, 3 * time.Second},
+		{1, time.Second, 1, 4 * time.Second},
+		{0, time.Minute, 3, 0},
+	}
+	for i, tt := range tests {
+		now = now.Add(tt.after)
+		for j := 0; j < tt.allow; j++ {
+			b.Allow(false)
+		}
+		limit, remaining, reset := b.Remaining()
+		if limit != 3 || remaining != tt.remaining || reset != tt.reset {
+			t.Errorf("#%d: want (3, %d, %s), got (%d, %d, %s)", i, tt.remaining, tt.reset, limit, remaining, reset)
+		}
+	}
+}
+
+func TestWaitPriority(t *testing.T) {
+	b := New(20, 1, time.Minute)
+	if !b.Allow(false) {
+		t.Fatal("want first packet allowed")
+	}
+	order := make(chan Priority, 3)
+	wait := func(p Priority) {
+		if err := b.Wait(context.Background(), p); err != nil {
+			t.Error(err)
+		}
+		order <- p
+	}
+	go wait(PriorityRetry)
+	go wait(PriorityScheduled)
+	for b.Waiting() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	go wait(PriorityInteractive)
+	for b.Waiting() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	want := []Priority{PriorityInteractive, PriorityScheduled, PriorityRetry}
+	for i, p := range want {
+		if got := <-order; got != p {
+			t.Errorf("#%d: want priority %d, got %d", i, p, got)
+		}
+	}
+
+	// Waiting is canceled with the context
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx, PriorityInteractive); err != ErrExceeded {
+		t.Errorf("want %v, got %v", ErrExceeded, err)
+	}
+	if b.Waiting() != 0 {
+		t.Errorf("want no waiting packets, got %d", b.Waiting())
+	}
+
+	// Automated packets are rejected while paused
+	b.Allow(false)
+	if err := b.Wait(context.Background(), PriorityScheduled); err != ErrExceeded {
+		t.Errorf("want %v while paused, got %v", ErrExceeded, err)
+	}
+}
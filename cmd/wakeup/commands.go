@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/mpolden/wakeup/history"
+	api "github.com/mpolden/wakeup/http"
+	"github.com/mpolden/wakeup/macaddr"
+	"github.com/mpolden/wakeup/wol"
+)
+
+// addCommands adds the commands of the binary to p. Commands other than serve use the state files configured by opts,
+// unless given the URL of a running server.
+func addCommands(p *flags.Parser, opts *options) {
+	p.AddCommand("serve", "Run the server", "Run the server. This is the default command.", &serveCommand{opts: opts})
+	p.AddCommand("wake", "Wake devices",
+		"Wake devices by MAC address, or by name or ID through the API of a running server. Without --api-url, magic packets are sent directly, as configured by --bind, --interface, --transport, --packets, --packet-interval and --retries.",
+		&wakeCommand{opts: opts})
+	p.AddCommand("list", "List devices", "List devices.", &listCommand{opts: opts})
+	p.AddCommand("add", "Add a device", "Add a device without waking it.", &addCommand{opts: opts})
+	p.AddCommand("remove", "Remove devices", "Remove devices by MAC address, name or ID.", &removeCommand{opts: opts})
+}
+
+// clientOptions configures how commands reach devices. Without the URL of a running server, state files are changed
+// directly, which must not be done while a server is using them.
+type clientOptions struct {
+	APIURL   string `long:"api-url" description:"URL of a running server whose API is used, e.g. http://localhost:8080, which may hold the user name and password of basic authentication (state files are used directly if unset)" value-name:"URL" env:"WAKEUP_API_URL"`
+	APIToken string `long:"api-token" description:"Token authenticating requests to the API of the running server" value-name:"TOKEN" env:"WAKEUP_API_TOKEN"`
+}
+
+// client makes requests to the API of a running server, or to a server handling them in-process.
+type client struct {
+	url    string
+	token  string
+	client *http.Client
+	close  func() error
+}
+
+// handlerTransport handles requests in-process by h.
+type handlerTransport struct{ h http.Handler }
+
+func (t handlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	req := r.Clone(r.Context())
+	req.RemoteAddr = "127.0.0.1:0"
+	req.RequestURI = r.URL.RequestURI()
+	// Requests received by servers always have a body
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+	w := httptest.NewRecorder()
+	t.h.ServeHTTP(w, req)
+	return w.Result(), nil
+}
+
+// newClient creates a client for the API at the URL of c, or for a server using the state files configured by opts.
+func newClient(c clientOptions, opts *options) (*client, error) {
+	if c.APIURL != "" {
+		u, err := url.Parse(c.APIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid url: %s", c.APIURL)
+		}
+		return &client{
+			url:    strings.TrimSuffix(c.APIURL, "/"),
+			token:  c.APIToken,
+			client: &http.Client{Timeout: time.Minute},
+			close:  func() error { return nil },
+		}, nil
+	}
+	if err := opts.resolveCache(); err != nil {
+		return nil, fmt.Errorf("%s, unless --api-url is set", err)
+	}
+	server, err := api.Open(opts.Store, opts.CacheFile, opts.storeFile())
+	if err != nil {
+		return nil, err
+	}
+	historyFile := opts.CacheFile + ".history"
+	if opts.HistoryFile != "" {
+		historyFile = statePath(opts.DataDir, opts.HistoryFile)
+	}
+	server.History = history.Open(historyFile)
+	server.History.Chain = opts.historyChain()
+	return &client{
+		url:    "http://localhost",
+		client: &http.Client{Transport: handlerTransport{server.Handler()}},
+		close:  server.Close,
+	}, nil
+}
+
+// do makes a request having the JSON body in, unless nil, and decodes the JSON response into out, unless nil.
+func (c *client) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json; envelope=false")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		var e api.Error
+		if err := json.NewDecoder(res.Body).Decode(&e); err != nil || e.Message == "" {
+			return fmt.Errorf("%s %s: got status %d", method, path, res.StatusCode)
+		}
+		if e.Cause != "" {
+			return fmt.Errorf("%s: %s", e.Message, e.Cause)
+		}
+		return errors.New(e.Message)
+	}
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func (c *client) devices() ([]*api.DeviceResource, error) {
+	var res api.DeviceResources
+	if err := c.do(http.MethodGet, "/api/v1/devices", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Devices, nil
+}
+
+// find returns the device having ref as its MAC address, ID or name.
+func (c *client) find(ref string) (*api.DeviceResource, error) {
+	devices, err := c.devices()
+	if err != nil {
+		return nil, err
+	}
+	if hwAddr, _, err := macaddr.Parse(ref); err == nil {
+		ref = macaddr.String(hwAddr)
+	}
+	var found []*api.DeviceResource
+	for _, d := range devices {
+		if d.MACAddress == ref || strings.EqualFold(d.ID, ref) {
+			return d, nil
+		}
+		for _, mac := range d.MACAddresses {
+			if mac == ref {
+				return d, nil
+			}
+		}
+		if strings.EqualFold(d.Name, ref) {
+			found = append(found, d)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("device not found: %s", ref)
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("device name %s is ambiguous, use its MAC address or ID", ref)
+}
+
+// deviceName returns the name of d, followed by its MAC address.
+func deviceName(d *api.DeviceResource) string {
+	if d.Name == "" {
+		return d.MACAddress
+	}
+	return fmt.Sprintf("%s (%s)", d.Name, d.MACAddress)
+}
+
+type serveCommand struct {
+	opts *options
+}
+
+func (c *serveCommand) Execute(args []string) error {
+	serve(c.opts)
+	return nil
+}
+
+type wakeCommand struct {
+	clientOptions
+	Args struct {
+		Devices []string `positional-arg-name:"DEVICE" description:"MAC address, name or ID of device"`
+	} `positional-args:"yes" required:"yes"`
+	opts *options
+}
+
+func (c *wakeCommand) Execute(args []string) error {
+	if c.APIURL == "" {
+		return c.send()
+	}
+	cl, err := newClient(c.clientOptions, c.opts)
+	if err != nil {
+		return err
+	}
+	defer cl.close()
+	for _, ref := range c.Args.Devices {
+		d, err := cl.find(ref)
+		if err == nil {
+			err = cl.do(http.MethodPost, "/api/v1/devices/"+d.ID+"/wake", struct{}{}, nil)
+		} else if hwAddr, _, perr := macaddr.Parse(ref); perr == nil {
+			// Unknown MAC addresses are woken, and stored, as by the wake endpoint
+			d = &api.DeviceResource{MACAddress: macaddr.String(hwAddr)}
+			err = cl.do(http.MethodPost, "/api/v1/wake", map[string]string{"macAddress": d.MACAddress}, nil)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Woke %s\n", deviceName(d))
+	}
+	return nil
+}
+
+// send sends magic packets directly to the MAC addresses of c.
+func (c *wakeCommand) send() error {
+	var hwAddrs []net.HardwareAddr
+	for _, ref := range c.Args.Devices {
+		hwAddr, _, err := macaddr.Parse(ref)
+		if err != nil {
+			return fmt.Errorf("invalid mac: %s: devices are woken by name or ID through --api-url", ref)
+		}
+		if macaddr.Multicast(hwAddr) {
+			return fmt.Errorf("invalid mac: %s: must not be a multicast or broadcast address", ref)
+		}
+		hwAddrs = append(hwAddrs, hwAddr)
+	}
+	sourceIP := net.ParseIP(c.opts.SourceIP)
+	if c.opts.SourceIP != "" && sourceIP == nil {
+		return fmt.Errorf("invalid ip: %s", c.opts.SourceIP)
+	}
+	wolOpts := wol.Options{
+		Source:    sourceIP,
+		Interface: c.opts.Interface,
+		Transport: c.opts.Transport,
+		Count:     c.opts.Packets,
+		Interval:  c.opts.PacketInterval,
+		Retries:   c.opts.Retries,
+	}
+	for _, hwAddr := range hwAddrs {
+		if err := wol.WakeWith(hwAddr, wolOpts); err != nil {
+			return fmt.Errorf("could not wake %s: %s", macaddr.String(hwAddr), err)
+		}
+		fmt.Printf("Sent magic packet to %s\n", macaddr.String(hwAddr))
+	}
+	return nil
+}
+
+type listCommand struct {
+	clientOptions
+	JSON bool `long:"json" description:"Print devices as JSON, as returned by the API"`
+	opts *options
+}
+
+func (c *listCommand) Execute(args []string) error {
+	cl, err := newClient(c.clientOptions, c.opts)
+	if err != nil {
+		return err
+	}
+	defer cl.close()
+	devices, err := cl.devices()
+	if err != nil {
+		return err
+	}
+	if c.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(api.DeviceResources{Devices: devices})
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMAC ADDRESS\tIP ADDRESS\tVENDOR\tID")
+	for _, d := range devices {
+		ip := d.IPAddress
+		if ip == "" {
+			ip = d.LastKnownIP
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", d.Name, d.MACAddress, ip, d.Vendor, d.ID)
+	}
+	return w.Flush()
+}
+
+type addCommand struct {
+	clientOptions
+	Name        string   `long:"name" description:"Name of device" value-name:"NAME"`
+	Description string   `long:"description" description:"Description of device" value-name:"TEXT"`
+	IPAddress   string   `long:"ip" description:"IP address of device, used to tell whether it is online" value-name:"IP"`
+	Groups      []string `long:"group" description:"Group of device (can be repeated)" value-name:"NAME"`
+	Args        struct {
+		MACAddress string `positional-arg-name:"MAC" description:"MAC address of device"`
+	} `positional-args:"yes" required:"yes"`
+	opts *options
+}
+
+func (c *addCommand) Execute(args []string) error {
+	cl, err := newClient(c.clientOptions, c.opts)
+	if err != nil {
+		return err
+	}
+	defer cl.close()
+	body := map[string]interface{}{"macAddress": c.Args.MACAddress, "name": c.Name, "description": c.Description, "ipAddress": c.IPAddress}
+	if len(c.Groups) > 0 {
+		body["groups"] = c.Groups
+	}
+	var d api.DeviceResource
+	if err := cl.do(http.MethodPost, "/api/v1/devices", body, &d); err != nil {
+		return err
+	}
+	fmt.Printf("Added %s with ID %s\n", deviceName(&d), d.ID)
+	return nil
+}
+
+type removeCommand struct {
+	clientOptions
+	Args struct {
+		Devices []string `positional-arg-name:"DEVICE" description:"MAC address, name or ID of device"`
+	} `positional-args:"yes" required:"yes"`
+	opts *options
+}
+
+func (c *removeCommand) Execute(args []string) error {
+	cl, err := newClient(c.clientOptions, c.opts)
+	if err != nil {
+		return err
+	}
+	defer cl.close()
+	for _, ref := range c.Args.Devices {
+		d, err := cl.find(ref)
+		if err != nil {
+			return err
+		}
+		if err := cl.do(http.MethodDelete, "/api/v1/devices/"+d.ID, nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s\n", deviceName(d))
+	}
+	return nil
+}
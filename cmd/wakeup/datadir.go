@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mpolden/wakeup/history"
+)
+
+// checkDataDir verifies that dir is an existing, writable directory, as state is written there while running.
+func checkDataDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("data directory %s does not exist", dir)
+	} else if err != nil {
+		return fmt.Errorf("data directory %s is not accessible: %s", dir, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("data directory %s is not a directory", dir)
+	}
+	f, err := ioutil.TempFile(dir, ".wakeup-check")
+	if err != nil {
+		return fmt.Errorf("data directory %s is not writable: %s", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// statePath returns the path of the state file at path, which is resolved against dataDir if it is relative and
+// dataDir is set.
+func statePath(dataDir, path string) string {
+	if dataDir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dataDir, path)
+}
+
+// resolveCache resolves the cache file of o against its data directory, which must be usable, defaulting to
+// cache.json in the data directory.
+func (o *options) resolveCache() error {
+	if o.DataDir != "" {
+		if err := checkDataDir(o.DataDir); err != nil {
+			return err
+		}
+		if o.CacheFile == "" {
+			o.CacheFile = "cache.json"
+		}
+	} else if o.CacheFile == "" {
+		return errors.New("--cache or --data-dir is required")
+	}
+	o.CacheFile = statePath(o.DataDir, o.CacheFile)
+	return nil
+}
+
+// storeFile returns the path of the database of the sqlite or bolt store.
+func (o *options) storeFile() string {
+	if o.StoreFile != "" {
+		return statePath(o.DataDir, o.StoreFile)
+	}
+	return o.CacheFile + ".db"
+}
+
+// stateFiles returns the paths of the state files written while running, given the resolved path of the OUI registry.
+func (o *options) stateFiles(ouiFile string) []string {
+	files := []string{o.CacheFile, o.HistoryFile, o.KeysFile, ouiFile, o.SSHHostKey, o.RecordTrace}
+	if o.Store == "sqlite" || o.Store == "bolt" {
+		files = append(files, o.storeFile())
+	}
+	if len(o.ACMEHosts) > 0 {
+		files = append(files, o.ACMEDir)
+	}
+	return files
+}
+
+// historyChain returns the chain linking entries of the history, or nil if they are not chained.
+func (o *options) historyChain() *history.Chain {
+	if !o.HistoryChain {
+		return nil
+	}
+	c := &history.Chain{}
+	if o.HistoryKey != "" {
+		c.Key = []byte(o.HistoryKey)
+	}
+	return c
+}
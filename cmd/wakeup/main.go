@@ -1,41 +1,729 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
 	"log"
 	"net"
+	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	flags "github.com/jessevdk/go-flags"
+	"github.com/mpolden/wakeup/apikey"
+	"github.com/mpolden/wakeup/auth"
+	"github.com/mpolden/wakeup/ban"
+	"github.com/mpolden/wakeup/budget"
+	"github.com/mpolden/wakeup/energy"
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/export"
+	"github.com/mpolden/wakeup/health"
+	"github.com/mpolden/wakeup/history"
 	"github.com/mpolden/wakeup/http"
+	"github.com/mpolden/wakeup/inventory"
+	"github.com/mpolden/wakeup/lmtp"
+	"github.com/mpolden/wakeup/logging"
+	"github.com/mpolden/wakeup/macaddr"
+	"github.com/mpolden/wakeup/mqtt"
+	"github.com/mpolden/wakeup/notify"
+	"github.com/mpolden/wakeup/power"
+	"github.com/mpolden/wakeup/quota"
+	"github.com/mpolden/wakeup/ratelimit"
+	"github.com/mpolden/wakeup/report"
+	"github.com/mpolden/wakeup/schedule"
+	"github.com/mpolden/wakeup/script"
+	"github.com/mpolden/wakeup/sshd"
+	"github.com/mpolden/wakeup/tlscert"
+	"github.com/mpolden/wakeup/trace"
+	"github.com/mpolden/wakeup/ups"
+	"github.com/mpolden/wakeup/wol"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
+// options configures the server. They are also the options of commands, which use the state files they configure
+// unless the command is given the URL of a running server.
+type options struct {
+	DataDir          string        `long:"data-dir" description:"Path to directory holding all state, against which relative paths of state files are resolved, allowing the rest of the filesystem to be read-only" value-name:"DIR" env:"WAKEUP_DATA_DIR"`
+	CacheFile        string        `short:"c" long:"cache" description:"Path to cache file (default: cache.json in data directory)" value-name:"FILE"`
+	Store            string        `long:"store" description:"Where devices are stored, where sqlite and bolt store them in a database populated from the cache file when it is created, and sqlite requires building with -tags sqlite" choice:"file" choice:"sqlite" choice:"bolt" default:"file"`
+	StoreFile        string        `long:"store-file" description:"Path to database of the sqlite or bolt store (default: cache file with .db suffix)" value-name:"FILE"`
+	HistoryFile      string        `long:"history" description:"Path to history file recording changes to the inventory and wakes of devices (default: cache file with .history suffix)" value-name:"FILE"`
+	HistoryChain     bool          `long:"history-chain" description:"Link entries appended to the history by their hashes, so that changes to the history can be detected"`
+	HistoryKey       string        `long:"history-key" description:"Key of the HMAC linking history entries, without which the chain cannot be recomputed (SHA-256 is used if unset)" value-name:"KEY" env:"WAKEUP_HISTORY_KEY"`
+	HistoryAnchor    time.Duration `long:"history-anchor-interval" description:"Interval at which the head of the history chain is recorded and logged as an anchor (disabled if zero)" value-name:"DURATION" default:"1h"`
+	KeysFile         string        `long:"keys" description:"Path to file storing API keys scoped to devices, which are required once any exists, even without other authentication (default: cache file with .keys suffix)" value-name:"FILE"`
+	OUIFile          string        `long:"oui" description:"Path to file storing the registry of MAC address prefixes when refreshed, replacing the registry embedded at build time (default: cache file with .oui suffix)" value-name:"FILE"`
+	User             string        `long:"user" description:"User to switch to once listening, when started as root" value-name:"NAME" env:"WAKEUP_USER"`
+	KeepCapabilities []string      `long:"keep-capability" description:"Capability to retain when switching user, e.g. net_raw needed by ICMP probes, or none (can be repeated)" value-name:"NAME" default:"net_raw"`
+	CompactInterval  time.Duration `long:"compact-interval" description:"Interval at which the journal of device changes is compacted into the cache file" value-name:"DURATION" default:"5m"`
+	SourceIP         string        `short:"b" long:"bind" description:"IP address to bind to when sending WOL packets. Packets are multicast to ff02::1 if this is an IPv6 address" value-name:"IP"`
+	Interface        string        `long:"interface" description:"Network interface to send WOL packets on, e.g. the macvlan interface of a container (requires CAP_NET_RAW on kernels older than 5.7)" value-name:"NAME"`
+	Listen           []string      `short:"l" long:"listen" description:"Listen address (can be repeated)" value-name:"ADDR" default:":8080"`
+	Network          string        `long:"listen-network" description:"Address family to listen on" choice:"tcp" choice:"tcp4" choice:"tcp6" default:"tcp"`
+	InternalListen   string        `long:"internal-listen" description:"Listen address for metrics, health, pprof and admin endpoints (these are served on the public address if unset)" value-name:"ADDR"`
+	TLSCert          string        `long:"tls-cert" description:"Path to certificate served on listen addresses, which are then HTTPS (reloaded when changed)" value-name:"FILE"`
+	TLSKey           string        `long:"tls-key" description:"Path to private key of --tls-cert" value-name:"FILE"`
+	ACMEHosts        []string      `long:"acme-host" description:"Host name for which certificates are obtained from Let's Encrypt, serving HTTPS on listen addresses (can be repeated, requires --tls-redirect-listen on port 80)" value-name:"NAME"`
+	ACMEEmail        string        `long:"acme-email" description:"Email address of the Let's Encrypt account, notified of expiring certificates" value-name:"EMAIL"`
+	ACMEDir          string        `long:"acme-cache" description:"Path to directory storing certificates from Let's Encrypt and the account key (default: cache file with .acme suffix)" value-name:"DIR"`
+	ACMEDirectory    string        `long:"acme-directory" description:"Directory URL of the ACME CA, e.g. the staging environment of Let's Encrypt" value-name:"URL" default:"https://acme-v02.api.letsencrypt.org/directory"`
+	RedirectListen   string        `long:"tls-redirect-listen" description:"Listen address redirecting HTTP to HTTPS, and answering the challenges of Let's Encrypt, e.g. :80" value-name:"ADDR"`
+	ReadTimeout      time.Duration `long:"read-timeout" description:"Time reading a request may take (disabled if zero)" value-name:"DURATION" default:"30s"`
+	WriteTimeout     time.Duration `long:"write-timeout" description:"Time writing a response may take, which must exceed the time wakes wait for devices to come online (disabled if zero)" value-name:"DURATION" default:"15m"`
+	IdleTimeout      time.Duration `long:"idle-timeout" description:"Time an idle connection is kept open (disabled if zero)" value-name:"DURATION" default:"2m"`
+	ShutdownTimeout  time.Duration `long:"shutdown-timeout" description:"Time requests in flight are given to complete on SIGTERM or SIGINT, which should be less than the time docker stop waits before killing the container" value-name:"DURATION" default:"9s"`
+	StaticDir        string        `short:"s" long:"static" description:"Path to directory containing static assets" value-name:"DIR"`
+	Routes           []string      `short:"r" long:"route" description:"Source IP or interface to use when waking devices in a subnet, e.g. 10.1.0.0/16=eth1 (can be repeated)" value-name:"SUBNET=SRC"`
+	MaxRate          float64       `long:"max-rate" description:"Maximum number of magic packets sent per second (0 disables limit)" value-name:"N" default:"0"`
+	MaxBurst         int           `long:"max-burst" description:"Maximum burst of magic packets" value-name:"N" default:"10"`
+	Cooldown         time.Duration `long:"cooldown" description:"Time to pause automated wakes after the rate is exceeded" value-name:"DURATION" default:"1m"`
+	BudgetWait       time.Duration `long:"budget-wait" description:"Time an interactive wake waits for the send budget before it is rejected" value-name:"DURATION" default:"5s"`
+	ClientRate       float64       `long:"client-rate" description:"Maximum number of requests per second to the API and public wake pages from each client address (0 disables limit)" value-name:"N" default:"0"`
+	ClientBurst      int           `long:"client-burst" description:"Maximum burst of requests from each client address" value-name:"N" default:"20"`
+	BanThreshold     int           `long:"ban-threshold" description:"Number of requests failing with 401 or 404 within --ban-window that bans a client, which is logged for fail2ban (0 disables bans, unless --trap-path is set)" value-name:"N" default:"0"`
+	BanWindow        time.Duration `long:"ban-window" description:"Window in which failing requests of a client are counted" value-name:"DURATION" default:"10m"`
+	BanTime          time.Duration `long:"ban-time" description:"Time a client is banned" value-name:"DURATION" default:"1h"`
+	TrapPaths        []string      `long:"trap-path" description:"Path that bans clients requesting it, e.g. /wp-login.php, or /.git/ to match all paths below it (can be repeated)" value-name:"PATH"`
+	WakeCooldown     time.Duration `long:"wake-cooldown" description:"Time a device cannot be woken again after a wake was attempted, which is rejected with 429 Too Many Requests (disabled if zero)" value-name:"DURATION" default:"0"`
+	QuotaPerHour     int           `long:"quota-per-hour" description:"Maximum number of wakes per hour for each user or client (0 disables limit)" value-name:"N" default:"0"`
+	QuotaPerDay      int           `long:"quota-per-day" description:"Maximum number of wakes per day for each user or client (0 disables limit)" value-name:"N" default:"0"`
+	Quotas           []string      `long:"quota" description:"Quota of a given user or client, e.g. ci-bot=10/50 (can be repeated)" value-name:"SUBJECT=HOUR/DAY"`
+	AdminToken       string        `long:"admin-token" description:"Bearer token required by admin endpoints (admin endpoints are disabled if unset)" value-name:"TOKEN" env:"WAKEUP_ADMIN_TOKEN"`
+	SkipIfOnline     bool          `long:"skip-if-online" description:"Skip wakes of devices that already respond to probes, unless overridden by the request or schedule"`
+	TrackIPs         time.Duration `long:"track-ips" description:"Interval at which the last known IP addresses of devices are updated from the ARP table and neighbour cache (disabled if zero)" value-name:"DURATION" default:"0"`
+	RefreshHostnames time.Duration `long:"refresh-hostnames" description:"Interval at which the hostnames of devices are resolved from their IP addresses (disabled if zero)" value-name:"DURATION" default:"0"`
+	Ping             bool          `long:"ping" description:"Ping devices, in addition to probing their TCP ports, to determine whether they are online (requires CAP_NET_RAW)"`
+	Transport        string        `long:"transport" description:"How magic packets are sent to devices not configuring a transport, where ethernet sends raw Ethernet frames on the interface of the bind address (requires CAP_NET_RAW)" choice:"udp" choice:"ethernet" default:"udp"`
+	Packets          int           `long:"packets" description:"Number of magic packets sent to each MAC address, as single packets are easily lost, e.g. by wireless bridges" value-name:"N" default:"1"`
+	PacketInterval   time.Duration `long:"packet-interval" description:"Delay between magic packets sent to a MAC address, and before sending them again" value-name:"DURATION" default:"100ms"`
+	Retries          int           `long:"retries" description:"Number of times magic packets are sent again if sending them fails" value-name:"N" default:"0"`
+	FeedSize         int           `long:"feed-size" description:"Number of recent events served by the Atom feed at /api/v1/events.atom (0 disables the feed)" value-name:"N" default:"100"`
+	Envelope         bool          `long:"envelope" description:"Wrap API responses in an envelope holding data and error, unless clients negotiate otherwise"`
+	MonitorInterval  time.Duration `long:"monitor-interval" description:"Interval at which devices are probed in the background to track whether they are online (disabled if zero)" value-name:"DURATION" default:"0"`
+	VerifyMAC        bool          `long:"verify-mac" description:"Report devices whose IP address is found with another MAC address in the ARP table or neighbour cache as a conflict"`
+	Stagger          time.Duration `long:"stagger" description:"Delay between each wake when waking a group of devices" value-name:"DURATION" default:"2s"`
+	BasicAuth        []string      `long:"basic-auth" description:"Username and password, or bcrypt hash of the password, of a user permitted to use the API (can be repeated)" value-name:"USER:PASSWORD" env:"WAKEUP_BASIC_AUTH" env-delim:","`
+	APITokens        []string      `long:"api-token" description:"Name and bearer token of a client permitted to use the API (can be repeated)" value-name:"NAME=TOKEN" env:"WAKEUP_API_TOKENS" env-delim:","`
+	AnonymousRead    bool          `long:"anonymous-read" description:"Allow GET requests without authentication, except to admin endpoints (anonymous users only see devices without an owner)"`
+	LDAPURL          string        `long:"ldap-url" description:"URL of LDAP server used to authenticate users, e.g. ldaps://ldap.example.com" value-name:"URL"`
+	LDAPBaseDN       string        `long:"ldap-base-dn" description:"Base DN used when searching for users and groups" value-name:"DN"`
+	LDAPBindDN       string        `long:"ldap-bind-dn" description:"DN used to bind before searching for users and groups" value-name:"DN"`
+	LDAPBindPassword string        `long:"ldap-bind-password" description:"Password of bind DN" value-name:"PASSWORD" env:"WAKEUP_LDAP_BIND_PASSWORD"`
+	LDAPUserFilter   string        `long:"ldap-user-filter" description:"Filter used to find users, where %s is replaced by the username" value-name:"FILTER" default:"(uid=%s)"`
+	LDAPRoles        []string      `long:"ldap-role" description:"Group filter granting a role to its members, e.g. admin=(cn=wake-admins) (can be repeated)" value-name:"ROLE=FILTER"`
+	NotifyConfig     string        `long:"notify-config" description:"Path to JSON file configuring notification sinks and policies" value-name:"FILE"`
+	ReportConfig     string        `long:"report-config" description:"Path to JSON file configuring periodic status reports sent by email" value-name:"FILE"`
+	ExportConfig     string        `long:"export-config" description:"Path to JSON file configuring external systems to export events to" value-name:"FILE"`
+	InventoryConfig  string        `long:"inventory-config" description:"Path to JSON file configuring external inventory sources to sync devices from" value-name:"FILE"`
+	NetBoxSecret     string        `long:"netbox-webhook-secret" description:"Secret used to verify NetBox webhooks (webhook endpoint is disabled if unset)" value-name:"SECRET" env:"WAKEUP_NETBOX_WEBHOOK_SECRET"`
+	HookKey          string        `long:"hook-key" description:"Key authenticating IFTTT and Zapier hooks (hook endpoints are disabled if unset)" value-name:"KEY" env:"WAKEUP_HOOK_KEY"`
+	TOTPKey          string        `long:"totp-key" description:"Key from which the TOTP secrets of public wake pages are derived (public wake pages are disabled if unset)" value-name:"KEY" env:"WAKEUP_TOTP_KEY"`
+	UPS              string        `long:"ups" description:"Address of NUT or apcupsd server reporting UPS status, e.g. nut://localhost:3493/ups or apcupsd://localhost:3551" value-name:"URL"`
+	UPSPolicy        string        `long:"ups-policy" description:"How to handle wakes of non-essential devices while the UPS is on battery" choice:"refuse" choice:"defer" default:"defer"`
+	EnergyPrice      float64       `long:"energy-price" description:"Price of electricity per kWh, used to estimate the cost of device energy usage" value-name:"PRICE" default:"0"`
+	ScheduleConfig   string        `long:"schedule-config" description:"Path to JSON file configuring wake schedules and the carbon or price sources they are optimized by" value-name:"FILE"`
+	TemplateConfig   string        `long:"template-config" description:"Path to JSON file configuring templates devices can be created from" value-name:"FILE"`
+	ConfigDir        string        `long:"config-dir" description:"Path to directory of declarative device definitions to watch, such as a mounted ConfigMap" value-name:"DIR"`
+	ConfigInterval   time.Duration `long:"config-interval" description:"Interval at which the config directory is checked for changes" value-name:"DURATION" default:"10s"`
+	LMTPListen       string        `long:"lmtp-listen" description:"Listen address for LMTP, where mail having a signed subject wakes a device" value-name:"ADDR"`
+	EmailWakeSecret  string        `long:"email-wake-secret" description:"Secret used to verify the subject of mail received over LMTP" value-name:"SECRET" env:"WAKEUP_EMAIL_WAKE_SECRET"`
+	SSHListen        string        `long:"ssh-listen" description:"Listen address for SSH, where authorized users wake devices with e.g. ssh wakeup@host wake nas" value-name:"ADDR"`
+	SSHHostKey       string        `long:"ssh-host-key" description:"Path to private host key of the SSH server (generated if missing)" value-name:"FILE" default:"ssh_host_ed25519_key"`
+	SSHAuthorizedKey string        `long:"ssh-authorized-keys" description:"Path to authorized_keys file of users permitted to wake devices over SSH, named by the comment of their key" value-name:"FILE"`
+	RemoteUnknown    bool          `long:"remote-allow-unknown" description:"Allow wakes over SSH, LMTP and MQTT of MAC addresses that are not stored. Devices are shared with these wakes through the user of the SSH key, and the users email and mqtt"`
+	RelayListen      []string      `long:"relay-listen" description:"Listen address for magic packets to relay (can be repeated)" value-name:"ADDR"`
+	RelayInterface   string        `long:"relay-interface" description:"Only relay magic packets arriving on this network interface" value-name:"NAME"`
+	RelayForward     string        `long:"relay-forward" description:"Address of interface where relayed magic packets are sent" value-name:"IP"`
+	RelayKnownOnly   bool          `long:"relay-known-only" description:"Only relay magic packets for stored devices"`
+	RelayWindow      time.Duration `long:"relay-window" description:"Time after relaying a magic packet during which further packets for the same MAC address are dropped" value-name:"DURATION" default:"1s"`
+	RelayMaxHops     int           `long:"relay-max-hops" description:"Maximum number of relays a magic packet passes" value-name:"N" default:"4"`
+	PreWakeScript    string        `long:"pre-wake-script" description:"Path to script run before a device is woken (the wake is refused if it fails)" value-name:"FILE"`
+	OnlineScript     string        `long:"post-online-script" description:"Path to script run after a device comes online" value-name:"FILE"`
+	OfflineScript    string        `long:"post-offline-script" description:"Path to script run after a device goes offline" value-name:"FILE"`
+	ScriptTimeout    time.Duration `long:"script-timeout" description:"Time a script may run before it is killed" value-name:"DURATION" default:"30s"`
+	LogFormat        string        `long:"log-format" description:"Format of log records" choice:"logfmt" choice:"json" default:"logfmt" env:"WAKEUP_LOG_FORMAT"`
+	Demo             bool          `long:"demo" description:"Serve demo devices whose state and wakes are simulated, without sending magic packets (state is kept in a temporary directory unless --cache or --data-dir is set)"`
+	PowerConfig      string        `long:"power-config" description:"Path to JSON file configuring the actions devices are powered off by, which admins assign to devices by name" value-name:"FILE"`
+	ShutdownKey      string        `long:"shutdown-ssh-key" description:"Path to private key authenticating SSH sessions that power off devices" value-name:"FILE"`
+	ShutdownHosts    string        `long:"shutdown-known-hosts" description:"Path to known_hosts file verifying devices powered off over SSH" value-name:"FILE"`
+	IPMIUser         string        `long:"ipmi-user" description:"User of BMCs powering off devices over IPMI" value-name:"NAME" env:"WAKEUP_IPMI_USER"`
+	IPMIPassword     string        `long:"ipmi-password" description:"Password of BMCs powering off devices over IPMI" value-name:"PASSWORD" env:"WAKEUP_IPMI_PASSWORD"`
+	IPMITool         string        `long:"ipmitool" description:"Path to ipmitool" value-name:"FILE" default:"ipmitool"`
+	MQTTBroker       string        `long:"mqtt-broker" description:"URL of MQTT broker where devices are woken and their state is published, e.g. mqtts://broker:8883" value-name:"URL" env:"WAKEUP_MQTT_BROKER"`
+	MQTTUsername     string        `long:"mqtt-username" description:"User name of MQTT broker" value-name:"NAME" env:"WAKEUP_MQTT_USERNAME"`
+	MQTTPassword     string        `long:"mqtt-password" description:"Password of MQTT broker" value-name:"PASSWORD" env:"WAKEUP_MQTT_PASSWORD"`
+	MQTTTopic        string        `long:"mqtt-topic" description:"Prefix of MQTT topics" value-name:"PREFIX" default:"wakeup"`
+	MQTTDiscovery    string        `long:"mqtt-discovery-prefix" description:"Prefix of Home Assistant discovery topics (empty disables discovery)" value-name:"PREFIX" default:"homeassistant"`
+	MQTTCAFile       string        `long:"mqtt-ca-file" description:"Path to CA certificates verifying the MQTT broker" value-name:"FILE"`
+	MQTTCertFile     string        `long:"mqtt-cert" description:"Path to client certificate presented to the MQTT broker" value-name:"FILE"`
+	MQTTKeyFile      string        `long:"mqtt-key" description:"Path to private key of the client certificate" value-name:"FILE"`
+	RecordTrace      string        `long:"record-trace" description:"Path to file where probes and events are recorded, for replaying them with --replay-trace" value-name:"FILE"`
+	ReplayTrace      string        `long:"replay-trace" description:"Path to recorded trace of probes and events to replay on startup" value-name:"FILE"`
+	ReplaySpeed      float64       `long:"replay-speed" description:"How many times faster than recorded the trace is replayed (0 replays without delay)" value-name:"N" default:"1"`
+	LogLevel         string        `long:"log-level" description:"Minimum level of logged records, where requests are logged at info, or warn and error if they fail" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"info" env:"WAKEUP_LOG_LEVEL"`
+}
+
 func main() {
-	var opts struct {
-		CacheFile string `short:"c" long:"cache" description:"Path to cache file" required:"true" value-name:"FILE"`
-		SourceIP  string `short:"b" long:"bind" description:"IP address to bind to when sending WOL packets" value-name:"IP"`
-		Listen    string `short:"l" long:"listen" description:"Listen address" value-name:"ADDR" default:":8080"`
-		StaticDir string `short:"s" long:"static" description:"Path to directory containing static assets" value-name:"DIR"`
+	var opts options
+	p := flags.NewParser(&opts, flags.Default)
+	p.SubcommandsOptional = true
+	addCommands(p, &opts)
+	if _, err := p.Parse(); err != nil {
+		os.Exit(1)
 	}
-	_, err := flags.ParseArgs(&opts, os.Args)
+	// Without a command, the server is run as before commands were added
+	if p.Active == nil {
+		serve(&opts)
+	}
+}
+
+// serve runs the server configured by opts.
+func serve(opts *options) {
+	if opts.Demo && opts.DataDir == "" && opts.CacheFile == "" {
+		dir, err := ioutil.TempDir("", "wakeup-demo")
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.DataDir = dir
+	}
+	if err := opts.resolveCache(); err != nil {
+		log.Fatal(err)
+	}
+	opts.SSHHostKey = statePath(opts.DataDir, opts.SSHHostKey)
+
+	level, err := logging.ParseLevel(opts.LogLevel)
 	if err != nil {
-		os.Exit(1)
+		log.Fatal(err)
 	}
+	logger, err := logging.New(os.Stderr, opts.LogFormat, level)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetFlags(0)
+	log.SetOutput(logger)
 
 	sourceIP := net.ParseIP(opts.SourceIP)
 	if opts.SourceIP != "" && sourceIP == nil {
 		log.Fatalf("invalid ip: %s", opts.SourceIP)
 	}
 
-	server := http.New(opts.CacheFile)
+	if opts.Interface != "" {
+		if _, err := net.InterfaceByName(opts.Interface); err != nil {
+			log.Fatalf("invalid interface: %s: %s", opts.Interface, err)
+		}
+	}
+
+	if opts.Packets < 1 || opts.Retries < 0 || opts.PacketInterval < 0 {
+		log.Fatalf("invalid burst: %d packets every %s, %d retries", opts.Packets, opts.PacketInterval, opts.Retries)
+	}
+
+	routes, err := wol.ParseRoutes(opts.Routes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	roles, err := auth.ParseRoles(opts.LDAPRoles)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server, err := http.Open(opts.Store, opts.CacheFile, opts.storeFile())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := server.Compact(); err != nil {
+		log.Fatal(err)
+	}
+	go server.RunCompaction(opts.CompactInterval)
+	if opts.HistoryFile == "" {
+		opts.HistoryFile = opts.CacheFile + ".history"
+	}
+	opts.HistoryFile = statePath(opts.DataDir, opts.HistoryFile)
+	server.History = history.Open(opts.HistoryFile)
+	if opts.HistoryKey != "" && !opts.HistoryChain {
+		log.Fatal("--history-key requires --history-chain")
+	}
+	server.History.Chain = opts.historyChain()
+	if server.History.Chain != nil && opts.HistoryAnchor > 0 {
+		go server.History.RunAnchors(opts.HistoryAnchor)
+	}
+	server.Logger = logger
+	if opts.KeysFile == "" {
+		opts.KeysFile = opts.CacheFile + ".keys"
+	}
+	opts.KeysFile = statePath(opts.DataDir, opts.KeysFile)
+	server.Keys = apikey.Open(opts.KeysFile)
+	if opts.OUIFile == "" {
+		opts.OUIFile = opts.CacheFile + ".oui"
+	}
+	server.OUIFile = statePath(opts.DataDir, opts.OUIFile)
+	if err := macaddr.DefaultRegistry.Load(server.OUIFile); err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	if opts.Demo {
+		if err := server.Simulate(time.Now().UnixNano(), 3*time.Second, 20*time.Second, time.Minute); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Serving demo devices from %s, no magic packets are sent", opts.CacheFile)
+	}
+	if opts.RecordTrace != "" {
+		opts.RecordTrace = statePath(opts.DataDir, opts.RecordTrace)
+		f, err := os.OpenFile(opts.RecordTrace, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		server.Trace = trace.NewWriter(f)
+	}
 	server.StaticDir = opts.StaticDir
+	server.InternalAddr = opts.InternalListen
+	if err := configureTLS(server, opts); err != nil {
+		log.Fatal(err)
+	}
+	server.ReadTimeout = opts.ReadTimeout
+	server.WriteTimeout = opts.WriteTimeout
+	server.IdleTimeout = opts.IdleTimeout
 	server.SourceIP = sourceIP
-	if strings.HasPrefix(opts.Listen, ":") {
-		log.Printf("Serving at http://0.0.0.0%s", opts.Listen)
-	} else {
-		log.Printf("Serving at http://%s", opts.Listen)
+	server.Routes = routes
+	server.Interface = opts.Interface
+	server.AdminToken = opts.AdminToken
+	server.Stagger = opts.Stagger
+	server.SkipIfOnline = opts.SkipIfOnline
+	server.VerifyMAC = opts.VerifyMAC
+	server.Ping = opts.Ping
+	server.Envelope = opts.Envelope
+	server.Transport = opts.Transport
+	server.Packets = opts.Packets
+	server.PacketInterval = opts.PacketInterval
+	server.Retries = opts.Retries
+	if opts.MonitorInterval > 0 {
+		server.MonitorInterval = opts.MonitorInterval
+		go server.Monitor()
+		keepAwakeEvents, _ := server.Events.Subscribe(100)
+		go server.KeepAwake(keepAwakeEvents)
+	}
+	if opts.TrackIPs > 0 {
+		go server.TrackIPs(opts.TrackIPs)
+	}
+	if opts.RefreshHostnames > 0 {
+		go server.RefreshHostnames(opts.RefreshHostnames)
+	}
+	server.RemoteAllowUnknown = opts.RemoteUnknown
+	server.NetBoxSecret = opts.NetBoxSecret
+	server.HookKey = opts.HookKey
+	server.TOTPKey = opts.TOTPKey
+	var authenticators auth.Chain
+	if len(opts.BasicAuth) > 0 || len(opts.APITokens) > 0 {
+		static, err := auth.NewStatic(opts.BasicAuth, opts.APITokens)
+		if err != nil {
+			log.Fatal(err)
+		}
+		authenticators = append(authenticators, static)
+	}
+	if opts.LDAPURL != "" {
+		ldap := auth.NewLDAP(opts.LDAPURL, opts.LDAPBaseDN)
+		ldap.BindDN = opts.LDAPBindDN
+		ldap.BindPassword = opts.LDAPBindPassword
+		ldap.UserFilter = opts.LDAPUserFilter
+		ldap.Roles = roles
+		authenticators = append(authenticators, ldap)
+	}
+	if len(authenticators) > 0 {
+		server.Auth = authenticators
+		server.AnonymousRead = opts.AnonymousRead
+	} else if opts.AnonymousRead {
+		log.Fatal("--anonymous-read requires --basic-auth, --api-token or --ldap-url")
+	}
+	if opts.MaxRate > 0 {
+		server.Budget = budget.New(opts.MaxRate, opts.MaxBurst, opts.Cooldown)
+		server.BudgetWait = opts.BudgetWait
+	}
+	if opts.ClientRate > 0 {
+		server.Limiter = ratelimit.New(opts.ClientRate, opts.ClientBurst)
+	}
+	server.WakeCooldown = opts.WakeCooldown
+	if opts.BanThreshold > 0 || len(opts.TrapPaths) > 0 {
+		server.Bans = ban.New(opts.BanThreshold, opts.BanWindow, opts.BanTime)
+		server.TrapPaths = opts.TrapPaths
+	}
+	server.Energy = energy.NewTracker()
+	server.EnergyPrice = opts.EnergyPrice
+	energyEvents, _ := server.Events.Subscribe(100)
+	go server.Energy.Run(energyEvents)
+	if opts.FeedSize > 0 {
+		server.Recent = event.NewRecorder(opts.FeedSize)
+		feedEvents, _ := server.Events.Subscribe(100)
+		go server.Recent.Run(feedEvents)
+	}
+	server.Health = health.NewTracker()
+	healthEvents, _ := server.Events.Subscribe(100)
+	go server.Health.Run(healthEvents)
+	if opts.PreWakeScript != "" || opts.OnlineScript != "" || opts.OfflineScript != "" {
+		server.Scripts = &script.Hooks{
+			PreWake:     opts.PreWakeScript,
+			PostOnline:  opts.OnlineScript,
+			PostOffline: opts.OfflineScript,
+			Timeout:     opts.ScriptTimeout,
+		}
+		scriptEvents, _ := server.Events.Subscribe(100)
+		go server.RunScripts(scriptEvents)
+	}
+	if opts.QuotaPerHour > 0 || opts.QuotaPerDay > 0 || len(opts.Quotas) > 0 {
+		server.Quotas = quota.New(quota.Limit{PerHour: opts.QuotaPerHour, PerDay: opts.QuotaPerDay})
+		for _, q := range opts.Quotas {
+			parts := strings.SplitN(q, "=", 2)
+			if len(parts) != 2 {
+				log.Fatalf("invalid quota: %s", q)
+			}
+			limit, err := quota.ParseLimit(parts[1])
+			if err != nil {
+				log.Fatal(err)
+			}
+			server.Quotas.Set(parts[0], limit)
+		}
+	}
+	if opts.NotifyConfig != "" {
+		notifier, err := notify.ReadConfig(opts.NotifyConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		events, _ := server.Events.Subscribe(100)
+		go notifier.Run(events, time.Minute)
+	}
+	if opts.ReportConfig != "" {
+		mailer, sched, err := report.ReadConfig(opts.ReportConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		collector := report.NewCollector()
+		events, _ := server.Events.Subscribe(100)
+		go collector.Run(events)
+		reporter := &report.Reporter{Collector: collector, Mailer: mailer, Schedule: sched, Devices: func() ([]report.Device, error) {
+			devices, err := server.Devices()
+			if err != nil {
+				return nil, err
+			}
+			var rs []report.Device
+			for _, d := range devices {
+				rs = append(rs, report.Device{Name: d.Name, MACAddress: d.MACAddress})
+			}
+			return rs, nil
+		}}
+		go reporter.Run()
+	}
+	if opts.ExportConfig != "" {
+		exporters, err := export.ReadConfig(opts.ExportConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, x := range exporters {
+			// Exported events record where events were lost
+			events, _ := server.Events.SubscribeMarked(1000)
+			go x.Run(events)
+		}
+	}
+	if opts.InventoryConfig != "" {
+		sources, err := inventory.ReadConfig(opts.InventoryConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, source := range sources {
+			go inventory.Run(source, server.Reconcile)
+		}
+	}
+	server.Power = power.NewController()
+	server.Power.IPMIUser = opts.IPMIUser
+	server.Power.IPMIPassword = opts.IPMIPassword
+	server.Power.IPMITool = opts.IPMITool
+	if opts.PowerConfig != "" {
+		if server.Power.Actions, err = power.ReadActions(opts.PowerConfig); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if opts.ShutdownKey != "" {
+		if opts.ShutdownHosts == "" {
+			log.Fatal("--shutdown-known-hosts is required when --shutdown-ssh-key is set")
+		}
+		pem, err := ioutil.ReadFile(opts.ShutdownKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if server.Power.Signer, err = ssh.ParsePrivateKey(pem); err != nil {
+			log.Fatalf("%s: %s", opts.ShutdownKey, err)
+		}
+		if server.Power.HostKeys, err = knownhosts.New(opts.ShutdownHosts); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if opts.MQTTBroker != "" {
+		client, err := mqtt.New(opts.MQTTBroker, server.WakeMQTT, server.MQTTDevices)
+		if err != nil {
+			log.Fatal(err)
+		}
+		client.Shutdown = server.ShutdownMQTT
+		if opts.MQTTUsername != "" {
+			client.Username = opts.MQTTUsername
+			client.Password = opts.MQTTPassword
+		}
+		client.Prefix = opts.MQTTTopic
+		client.DiscoveryPrefix = opts.MQTTDiscovery
+		if opts.MQTTCAFile != "" || opts.MQTTCertFile != "" {
+			if client.TLS == nil {
+				log.Fatal("--mqtt-ca-file and --mqtt-cert require a broker using TLS")
+			}
+			if opts.MQTTCAFile != "" {
+				pem, err := ioutil.ReadFile(opts.MQTTCAFile)
+				if err != nil {
+					log.Fatal(err)
+				}
+				client.TLS.RootCAs = x509.NewCertPool()
+				if !client.TLS.RootCAs.AppendCertsFromPEM(pem) {
+					log.Fatalf("no certificates found in %s", opts.MQTTCAFile)
+				}
+			}
+			if opts.MQTTCertFile != "" {
+				cert, err := tls.LoadX509KeyPair(opts.MQTTCertFile, opts.MQTTKeyFile)
+				if err != nil {
+					log.Fatal(err)
+				}
+				client.TLS.Certificates = []tls.Certificate{cert}
+			}
+		}
+		mqttEvents, _ := server.Events.Subscribe(100)
+		go client.Run(mqttEvents)
+		log.Printf("Connecting to MQTT broker at %s", client.Address)
+	}
+	if opts.UPS != "" {
+		u, err := url.Parse(opts.UPS)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var reader ups.Reader
+		switch u.Scheme {
+		case "nut":
+			name := strings.TrimPrefix(u.Path, "/")
+			if name == "" {
+				name = "ups"
+			}
+			reader = &ups.NUT{Address: u.Host, Name: name}
+		case "apcupsd":
+			reader = &ups.Apcupsd{Address: u.Host}
+		default:
+			log.Fatalf("invalid ups: %s", opts.UPS)
+		}
+		server.UPS = ups.NewMonitor(reader)
+		server.UPSPolicy = opts.UPSPolicy
+		server.UPS.Poll()
+		go server.UPS.Run(func(onBattery bool) {
+			if !onBattery {
+				server.ResumeDeferred()
+			}
+		})
+	}
+	server.Scheduler = schedule.New(nil, server.WakeScheduled)
+	server.Scheduler.Dynamic = server.StoredSchedules
+	if opts.ScheduleConfig != "" {
+		schedules, sources, err := schedule.ReadConfig(opts.ScheduleConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.Scheduler.Schedules = schedules
+		server.Scheduler.Sources = sources
 	}
-	if err := server.ListenAndServe(opts.Listen); err != nil {
+	go server.Scheduler.Run(time.Minute)
+	if opts.TemplateConfig != "" {
+		templates, err := http.ReadTemplates(opts.TemplateConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.Templates = templates
+	}
+	if opts.ConfigDir != "" {
+		server.ConfigMap = inventory.NewConfigMap(opts.ConfigDir)
+		server.ConfigMap.Interval = opts.ConfigInterval
+		go server.ConfigMap.Watch(server.Reconcile)
+	}
+	if opts.LMTPListen != "" {
+		if opts.EmailWakeSecret == "" {
+			log.Fatal("--email-wake-secret is required when --lmtp-listen is set")
+		}
+		l, err := net.Listen("tcp", opts.LMTPListen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Serving LMTP at %s", opts.LMTPListen)
+		go func() {
+			log.Fatal(lmtp.New(opts.EmailWakeSecret, server.WakeEmail).Serve(l))
+		}()
+	}
+	if opts.SSHListen != "" {
+		if opts.SSHAuthorizedKey == "" {
+			log.Fatal("--ssh-authorized-keys is required when --ssh-listen is set")
+		}
+		hostKey, err := sshd.ReadHostKey(opts.SSHHostKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys, err := sshd.ReadAuthorizedKeys(opts.SSHAuthorizedKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		l, err := net.Listen("tcp", opts.SSHListen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Serving SSH at %s", opts.SSHListen)
+		go func() {
+			log.Fatal(sshd.New(hostKey, keys, server.WakeRemote).Serve(l))
+		}()
+	}
+	if len(opts.RelayListen) > 0 {
+		forwardAddr := net.ParseIP(opts.RelayForward)
+		if forwardAddr == nil {
+			log.Fatalf("invalid --relay-forward ip: %q", opts.RelayForward)
+		}
+		b, err := wol.ListenInterface("udp4", opts.RelayInterface, opts.RelayListen...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b.Window = opts.RelayWindow
+		b.MaxHops = opts.RelayMaxHops
+		server.Relay = b
+		log.Printf("Relaying magic packets from %s to %s", strings.Join(opts.RelayListen, ", "), forwardAddr)
+		go func() {
+			log.Fatal(server.RunRelay(forwardAddr, opts.RelayKnownOnly))
+		}()
+	}
+	if opts.ReplayTrace != "" {
+		opts.ReplayTrace = statePath(opts.DataDir, opts.ReplayTrace)
+		f, err := os.Open(opts.ReplayTrace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		records, err := trace.Read(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Replaying %d records from %s", len(records), opts.ReplayTrace)
+		go func() {
+			if err := server.Replay(records, opts.ReplaySpeed); err != nil {
+				log.Printf("could not replay %s: %s", opts.ReplayTrace, err)
+				return
+			}
+			log.Printf("Replayed %s", opts.ReplayTrace)
+		}()
+	}
+	scheme := "http"
+	if server.TLSConfig != nil {
+		scheme = "https"
+	}
+	for _, addr := range opts.Listen {
+		if strings.HasPrefix(addr, ":") {
+			log.Printf("Serving at %s://0.0.0.0%s", scheme, addr)
+		} else {
+			log.Printf("Serving at %s://%s", scheme, addr)
+		}
+	}
+	if opts.RedirectListen != "" {
+		log.Printf("Redirecting HTTP to HTTPS at %s", opts.RedirectListen)
+	}
+	if opts.InternalListen != "" {
+		log.Printf("Serving internal endpoints at %s", opts.InternalListen)
+	}
+	if err := server.Listen(opts.Network, opts.Listen...); err != nil {
 		log.Fatal(err)
 	}
+	// Privileged sockets are all open, so privileges are no longer needed
+	if opts.User != "" {
+		if os.Geteuid() != 0 {
+			log.Printf("Not running as root, ignoring --user %s", opts.User)
+		} else {
+			retained, err := dropPrivileges(opts.User, opts.KeepCapabilities, opts.stateFiles(server.OUIFile))
+			if err != nil {
+				log.Fatal(err)
+			}
+			if len(retained) == 0 {
+				log.Printf("Switched to user %s, retaining no capabilities", opts.User)
+			} else {
+				log.Printf("Switched to user %s, retaining capabilities %s", opts.User, strings.Join(retained, ", "))
+			}
+			if opts.DataDir != "" {
+				if err := checkDataDir(opts.DataDir); err != nil {
+					log.Fatalf("%s as user %s", err, opts.User)
+				}
+			}
+		}
+	}
+	// Requests in flight are drained on SIGTERM, as sent by docker stop, or SIGINT. Receiving either again exits
+	// immediately.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	stopped := make(chan error, 1)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		log.Printf("Received %s, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+		defer cancel()
+		stopped <- server.Shutdown(ctx)
+	}()
+	if err := server.Serve(); err != nil {
+		log.Fatal(err)
+	}
+	if err := <-stopped; err != nil {
+		log.Printf("Closed connections still open after %s", opts.ShutdownTimeout)
+	}
+	if err := server.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Print("Stopped")
+}
+
+// configureTLS configures server to serve HTTPS with the certificate and key of opts, or certificates obtained from
+// Let's Encrypt.
+func configureTLS(server *http.Server, opts *options) error {
+	if (opts.TLSCert == "") != (opts.TLSKey == "") {
+		return errors.New("--tls-cert and --tls-key must be set together")
+	}
+	switch {
+	case opts.TLSCert != "" && len(opts.ACMEHosts) > 0:
+		return errors.New("--acme-host cannot be combined with --tls-cert")
+	case opts.TLSCert != "":
+		kp, err := tlscert.LoadKeyPair(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: kp.GetCertificate, MinVersion: tls.VersionTLS12}
+	case len(opts.ACMEHosts) > 0:
+		if opts.RedirectListen == "" {
+			return errors.New("--tls-redirect-listen is required when --acme-host is set, as Let's Encrypt validates hosts on port 80")
+		}
+		if opts.ACMEDir == "" {
+			opts.ACMEDir = opts.CacheFile + ".acme"
+		}
+		opts.ACMEDir = statePath(opts.DataDir, opts.ACMEDir)
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(opts.ACMEHosts...),
+			Cache:      autocert.DirCache(opts.ACMEDir),
+			Email:      opts.ACMEEmail,
+			Client:     &acme.Client{DirectoryURL: opts.ACMEDirectory},
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: m.GetCertificate, MinVersion: tls.VersionTLS12}
+		server.ACMEHandler = m.HTTPHandler
+	case opts.RedirectListen != "":
+		return errors.New("--tls-redirect-listen requires --tls-cert or --acme-host")
+	}
+	server.RedirectAddr = opts.RedirectListen
+	return nil
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// capabilityNames holds the names of Linux capabilities, indexed by number.
+var capabilityNames = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid", "kill", "setgid", "setuid", "setpcap",
+	"linux_immutable", "net_bind_service", "net_broadcast", "net_admin", "net_raw", "ipc_lock", "ipc_owner",
+	"sys_module", "sys_rawio", "sys_chroot", "sys_ptrace", "sys_pacct", "sys_admin", "sys_boot", "sys_nice",
+	"sys_resource", "sys_time", "sys_tty_config", "mknod", "lease", "audit_write", "audit_control", "setfcap",
+	"mac_override", "mac_admin", "syslog", "wake_alarm", "block_suspend", "audit_read", "perfmon", "bpf",
+	"checkpoint_restore",
+}
+
+const (
+	prSetKeepCaps       = 8
+	linuxCapabilityVer3 = 0x20080522
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective, permitted, inheritable uint32
+}
+
+// capabilityMask returns the capability set holding the capabilities named in names, e.g. net_raw or CAP_NET_RAW. The
+// name none is ignored, so that the default can be overridden by an empty set.
+func capabilityMask(names []string) (uint64, error) {
+	var mask uint64
+	for _, name := range names {
+		name = strings.TrimPrefix(strings.ToLower(name), "cap_")
+		if name == "none" {
+			continue
+		}
+		found := false
+		for n, v := range capabilityNames {
+			if v == name {
+				mask |= 1 << uint(n)
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid capability: %s", name)
+		}
+	}
+	return mask, nil
+}
+
+// capabilityList returns the names of the capabilities in mask.
+func capabilityList(mask uint64) []string {
+	var names []string
+	for n := uint(0); n < 64; n++ {
+		if mask&(1<<n) == 0 {
+			continue
+		}
+		if int(n) < len(capabilityNames) {
+			names = append(names, "CAP_"+strings.ToUpper(capabilityNames[n]))
+		} else {
+			names = append(names, fmt.Sprintf("CAP_%d", n))
+		}
+	}
+	return names
+}
+
+// effectiveCapabilities returns the effective capability set of the process, as reported by the kernel.
+func effectiveCapabilities() (uint64, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v := strings.TrimPrefix(scanner.Text(), "CapEff:"); v != scanner.Text() {
+			return strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no effective capabilities in /proc/self/status")
+}
+
+// lookupUser returns the user and group IDs of the user named name. Numeric IDs need not exist in the user database,
+// as is common in containers.
+func lookupUser(name string) (int, int, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		id, nerr := strconv.Atoi(name)
+		if nerr != nil {
+			return 0, 0, err
+		}
+		if u, err = user.LookupId(name); err != nil {
+			return id, id, nil
+		}
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
+
+// chownState gives the user and group uid and gid the state files at paths, along with the files beside them which
+// share their name as a prefix, such as journals and anchors. State directories are given with their contents, such as
+// the certificates of Let's Encrypt, which are written again when renewed. Missing files are ignored.
+func chownState(paths []string, uid, gid int) error {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		dir, base := filepath.Split(path)
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if name != base && !strings.HasPrefix(name, base+".") && !strings.HasPrefix(name, base+"-") {
+				continue
+			}
+			err := filepath.Walk(filepath.Join(dir, name), func(p string, _ os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				return os.Lchown(p, uid, gid)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dropPrivileges switches the process to the user named name, retaining the capabilities named in keep, and returns
+// the names of the capabilities effective afterwards. The state files at state, created while running as root, are
+// given to the user first. Capabilities are set on all threads, which the runtime only supports without cgo, so
+// retaining any fails in cgo builds.
+func dropPrivileges(name string, keep []string, state []string) ([]string, error) {
+	uid, gid, err := lookupUser(name)
+	if err != nil {
+		return nil, err
+	}
+	mask, err := capabilityMask(keep)
+	if err != nil {
+		return nil, err
+	}
+	if err := chownState(state, uid, gid); err != nil {
+		return nil, fmt.Errorf("could not give state files to user %d: %s", uid, err)
+	}
+	if mask != 0 {
+		if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetKeepCaps, 1, 0); errno == syscall.ENOTSUP {
+			return nil, fmt.Errorf("cannot retain capabilities %s in a cgo build, set --keep-capability none to retain none",
+				strings.Join(capabilityList(mask), ", "))
+		} else if errno != 0 {
+			return nil, fmt.Errorf("could not keep capabilities: %s", errno)
+		}
+	}
+	if err := syscall.Setgroups(nil); err != nil {
+		return nil, fmt.Errorf("could not clear supplementary groups: %s", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return nil, fmt.Errorf("could not switch to group %d: %s", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return nil, fmt.Errorf("could not switch to user %d: %s", uid, err)
+	}
+	if mask != 0 {
+		// The permitted set is kept across setuid, but the effective set is cleared
+		hdr := &capHeader{version: linuxCapabilityVer3}
+		data := &[2]capData{
+			{effective: uint32(mask), permitted: uint32(mask)},
+			{effective: uint32(mask >> 32), permitted: uint32(mask >> 32)},
+		}
+		_, _, errno := syscall.AllThreadsSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(hdr)), uintptr(unsafe.Pointer(data)), 0)
+		runtime.KeepAlive(hdr)
+		runtime.KeepAlive(data)
+		if errno != 0 {
+			return nil, fmt.Errorf("could not set capabilities: %s", errno)
+		}
+	}
+	eff, err := effectiveCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	return capabilityList(eff), nil
+}
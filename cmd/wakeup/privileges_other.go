@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// dropPrivileges is only supported on Linux.
+func dropPrivileges(name string, keep []string, state []string) ([]string, error) {
+	return nil, errors.New("switching user is only supported on Linux")
+}
@@ -1,19 +1,30 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"net"
 	"os"
 	"strings"
+	"time"
 
 	flags "github.com/jessevdk/go-flags"
+	"github.com/mpolden/wakeup/budget"
 	"github.com/mpolden/wakeup/wol"
 )
 
 func main() {
 	var opts struct {
-		ListenAddr  string `short:"l" long:"listen" description:"Listen address to use when listening for WOL packets" value-name:"IP" default:"0.0.0.0:9"`
-		ForwardAddr string `short:"o" long:"forward" description:"Address of interface where received WOL packets should be forwarded" required:"true" value-name:"IP"`
+		ListenAddrs []string      `short:"l" long:"listen" description:"Listen address to use when listening for WOL packets (can be repeated)" value-name:"IP" default:"0.0.0.0:9"`
+		Network     string        `long:"listen-network" description:"Address family to listen on" choice:"udp" choice:"udp4" choice:"udp6" default:"udp4"`
+		Interface   string        `long:"listen-interface" description:"Only forward WOL packets arriving on this network interface" value-name:"NAME"`
+		Allow       []string      `long:"allow" description:"Only forward WOL packets for this MAC address (can be repeated)" value-name:"MAC"`
+		ForwardAddr string        `short:"o" long:"forward" description:"Address of interface where received WOL packets should be forwarded" required:"true" value-name:"IP"`
+		MaxRate     float64       `long:"max-rate" description:"Maximum number of forwarded packets per second (0 disables limit)" value-name:"N" default:"0"`
+		MaxBurst    int           `long:"max-burst" description:"Maximum burst of forwarded packets" value-name:"N" default:"10"`
+		Cooldown    time.Duration `long:"cooldown" description:"Time to pause forwarding after the rate is exceeded" value-name:"DURATION" default:"1m"`
+		Window      time.Duration `long:"window" description:"Time after forwarding a WOL packet during which further packets for the same MAC address are dropped" value-name:"DURATION" default:"1s"`
+		MaxHops     int           `long:"max-hops" description:"Maximum number of bridges a WOL packet passes" value-name:"N" default:"4"`
 	}
 	_, err := flags.ParseArgs(&opts, os.Args)
 	if err != nil {
@@ -25,12 +36,41 @@ func main() {
 		log.Fatalf("invalid ip: %s", opts.ForwardAddr)
 	}
 
-	b, err := wol.Listen(opts.ListenAddr)
+	allowed := make(map[string]bool, len(opts.Allow))
+	for _, mac := range opts.Allow {
+		hwAddr, err := net.ParseMAC(mac)
+		if err != nil {
+			log.Fatalf("invalid mac: %s", mac)
+		}
+		allowed[hwAddr.String()] = true
+	}
+
+	b, err := wol.ListenInterface(opts.Network, opts.Interface, opts.ListenAddrs...)
 	if err != nil {
 		log.Fatal(err)
 	}
+	b.Window = opts.Window
+	b.MaxHops = opts.MaxHops
+	if len(allowed) > 0 {
+		b.Filter = func(hwAddr net.HardwareAddr) bool { return allowed[hwAddr.String()] }
+	}
+	if opts.MaxRate > 0 {
+		b.Budget = budget.New(opts.MaxRate, opts.MaxBurst, opts.Cooldown)
+	}
 	for {
 		sent, err := b.Forward(forwardAddr)
+		if err == budget.ErrExceeded || errors.Is(err, wol.ErrInvalidPacket) {
+			log.Print("Dropped magic packet: ", err)
+			continue
+		}
+		if err == wol.ErrFiltered {
+			log.Printf("Dropped magic packet for %s: not allowed", strings.ToUpper(sent.HardwareAddr().String()))
+			continue
+		}
+		if err == wol.ErrDuplicate || err == wol.ErrHopLimit {
+			log.Printf("Dropped magic packet for %s: %s", strings.ToUpper(sent.HardwareAddr().String()), err)
+			continue
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
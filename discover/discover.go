@@ -0,0 +1,208 @@
+// Package discover finds live hosts on the local network, together with their MAC address, hostname and vendor, so
+// that they can be added as devices without typing their MAC address.
+//
+// Hosts are found by sending a datagram to every address of a subnet, which makes this host resolve their link-layer
+// addresses, and then reading the ARP table. This requires no privileges, unlike sending ARP requests directly.
+// Hostnames are resolved through multicast DNS, falling back to reverse lookups in DNS.
+package discover
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mpolden/wakeup/macaddr"
+	"github.com/mpolden/wakeup/neigh"
+)
+
+// Host is a live host found on the network.
+type Host struct {
+	IPAddress  string `json:"ipAddress"`
+	MACAddress string `json:"macAddress"`
+	Hostname   string `json:"hostname,omitempty"`
+	Vendor     string `json:"vendor,omitempty"`
+}
+
+// DefaultMaxHosts is the default maximum number of addresses in a scanned subnet.
+const DefaultMaxHosts = 1024
+
+// Scanner scans subnets for live hosts.
+type Scanner struct {
+	// Wait is how long hosts are given to answer address resolution, and to answer multicast DNS queries.
+	Wait time.Duration
+	// MaxHosts is the maximum number of addresses in a scanned subnet.
+	MaxHosts int
+
+	probe      func(ip net.IP) error
+	neigh      func() (neigh.Table, error)
+	mdns       func(ctx context.Context, ips []net.IP) (map[string]string, error)
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+}
+
+// NewScanner creates a new scanner.
+func NewScanner() *Scanner {
+	return &Scanner{
+		Wait:       2 * time.Second,
+		MaxHosts:   DefaultMaxHosts,
+		probe:      probe,
+		neigh:      neigh.Read,
+		mdns:       lookupMDNS,
+		lookupAddr: net.DefaultResolver.LookupAddr,
+	}
+}
+
+// probe sends a datagram to the discard port of ip. The datagram itself is of no interest, only the address resolution
+// preceding it.
+func probe(ip net.IP) error {
+	conn, err := net.Dial("udp4", net.JoinHostPort(ip.String(), "9"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte{0})
+	return err
+}
+
+// Subnets returns the IPv4 subnets of the network interfaces of this host which are up, excluding loopback.
+func Subnets() ([]*net.IPNet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var subnets []*net.IPNet
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			n, ok := addr.(*net.IPNet)
+			if !ok || n.IP.To4() == nil {
+				continue
+			}
+			if ones, bits := n.Mask.Size(); bits-ones < 2 {
+				continue
+			}
+			subnets = append(subnets, n)
+		}
+	}
+	return subnets, nil
+}
+
+// Narrow returns the subnet of n holding at most max addresses around the address of n. Subnets of interfaces may be
+// too large to scan, while the hosts of interest are typically near the address of the interface.
+func Narrow(n *net.IPNet, max int) *net.IPNet {
+	ones, bits := n.Mask.Size()
+	for ones < bits && 1<<uint(bits-ones) > max {
+		ones++
+	}
+	mask := net.CIDRMask(ones, bits)
+	return &net.IPNet{IP: n.IP.Mask(mask), Mask: mask}
+}
+
+// hosts returns the addresses of hosts in the IPv4 subnet n, excluding its network and broadcast addresses.
+func hosts(n *net.IPNet, max int) ([]net.IP, error) {
+	network := n.IP.Mask(n.Mask).To4()
+	if network == nil || len(n.Mask) != net.IPv4len {
+		return nil, fmt.Errorf("invalid subnet: %s: must be IPv4", n)
+	}
+	ones, bits := n.Mask.Size()
+	size := 1 << uint(bits-ones)
+	if size > max {
+		return nil, fmt.Errorf("invalid subnet: %s: must have at most %d addresses", n, max)
+	}
+	start := uint32(network[0])<<24 | uint32(network[1])<<16 | uint32(network[2])<<8 | uint32(network[3])
+	var ips []net.IP
+	for i := 1; i < size-1; i++ {
+		v := start + uint32(i)
+		ips = append(ips, net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).To4())
+	}
+	return ips, nil
+}
+
+// Scan scans subnets, or the subnets of the network interfaces of this host if subnets is empty, and returns the hosts
+// found sorted by IP address. Subnets of network interfaces are narrowed to at most MaxHosts addresses.
+func (s *Scanner) Scan(ctx context.Context, subnets []*net.IPNet) ([]Host, error) {
+	if len(subnets) == 0 {
+		found, err := Subnets()
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range found {
+			subnets = append(subnets, Narrow(n, s.MaxHosts))
+		}
+	}
+	var ips []net.IP
+	for _, n := range subnets {
+		v, err := hosts(n, s.MaxHosts)
+		if err != nil {
+			return nil, err
+		}
+		ips = append(ips, v...)
+	}
+	for _, ip := range ips {
+		// Addresses without a route fail immediately, which is not an error
+		s.probe(ip)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(s.Wait):
+	}
+	t, err := s.neigh()
+	if err != nil {
+		return nil, err
+	}
+	var found []Host
+	var live []net.IP
+	for _, ip := range ips {
+		hw, ok := t.Lookup(ip)
+		if !ok {
+			continue
+		}
+		found = append(found, Host{IPAddress: ip.String(), MACAddress: macaddr.String(hw), Vendor: macaddr.Vendor(hw)})
+		live = append(live, ip)
+	}
+	s.resolve(ctx, found, live)
+	sort.Slice(found, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(found[i].IPAddress).To4(), net.ParseIP(found[j].IPAddress).To4()) < 0
+	})
+	return found, nil
+}
+
+// resolve sets the hostnames of hosts, whose addresses are ips, as answered through multicast DNS or found in DNS.
+func (s *Scanner) resolve(ctx context.Context, hosts []Host, ips []net.IP) {
+	if len(hosts) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.Wait)
+	defer cancel()
+	names, err := s.mdns(ctx, ips)
+	if err != nil {
+		names = nil
+	}
+	var wg sync.WaitGroup
+	for i := range hosts {
+		h := &hosts[i]
+		if name := names[h.IPAddress]; name != "" {
+			h.Hostname = name
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if names, err := s.lookupAddr(ctx, h.IPAddress); err == nil && len(names) > 0 {
+				h.Hostname = strings.TrimSuffix(names[0], ".")
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,102 @@
+package discover
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/mpolden/wakeup/neigh"
+)
+
+func TestHosts(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.0.0.0/30")
+	ips, err := hosts(n, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 || ips[0].String() != "10.0.0.1" || ips[1].String() != "10.0.0.2" {
+		t.Errorf("want 10.0.0.1 and 10.0.0.2, got %v", ips)
+	}
+	_, n, _ = net.ParseCIDR("10.0.0.0/16")
+	if _, err := hosts(n, 1024); err == nil || err.Error() != "invalid subnet: 10.0.0.0/16: must have at most 1024 addresses" {
+		t.Errorf("want error for large subnet, got %v", err)
+	}
+	_, n, _ = net.ParseCIDR("fe80::/120")
+	if _, err := hosts(n, 1024); err == nil {
+		t.Error("want error for IPv6 subnet")
+	}
+	if got := Narrow(&net.IPNet{IP: net.IPv4(10, 1, 2, 3).To4(), Mask: net.CIDRMask(16, 32)}, 256); got.String() != "10.1.2.0/24" {
+		t.Errorf("want 10.1.2.0/24, got %s", got)
+	}
+}
+
+func TestMDNS(t *testing.T) {
+	name := reverseName(net.IPv4(10, 0, 0, 2))
+	if name != "2.0.0.10.in-addr.arpa." {
+		t.Fatalf("got reverse name %s", name)
+	}
+	// An answer echoing the question, whose PTR record compresses its name by pointing to the question
+	msg := query(name)
+	binary.BigEndian.PutUint16(msg[6:], 1)
+	msg = append(msg, 0xC0, 12, 0, typePTR, 0, classIN, 0, 0, 0, 120, 0, 11)
+	msg = append(msg, 3, 'n', 'a', 's', 5, 'l', 'o', 'c', 'a', 'l', 0)
+	records, err := parseAnswers(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{name: "nas.local."}; !reflect.DeepEqual(records, want) {
+		t.Errorf("want %v, got %v", want, records)
+	}
+	if _, err := parseAnswers(msg[:len(msg)-3]); err != errMalformed {
+		t.Errorf("want error for truncated message, got %v", err)
+	}
+	loop := append(query(name)[:12], 0xC0, 12)
+	binary.BigEndian.PutUint16(loop[4:], 1)
+	if _, err := parseAnswers(loop); err != errMalformed {
+		t.Errorf("want error for compression loop, got %v", err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	var probed []string
+	s := NewScanner()
+	s.Wait = 0
+	s.probe = func(ip net.IP) error {
+		probed = append(probed, ip.String())
+		return nil
+	}
+	s.neigh = func() (neigh.Table, error) {
+		t := make(neigh.Table)
+		t["10.0.0.5"], _ = net.ParseMAC("b8:27:eb:12:34:56")
+		t["10.0.0.2"], _ = net.ParseMAC("ab:cd:ef:12:34:57")
+		t["192.168.1.2"], _ = net.ParseMAC("ab:cd:ef:12:34:58")
+		return t, nil
+	}
+	s.mdns = func(ctx context.Context, ips []net.IP) (map[string]string, error) {
+		return map[string]string{"10.0.0.5": "pi.local"}, nil
+	}
+	s.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		if addr == "10.0.0.2" {
+			return []string{"nas.example.com."}, nil
+		}
+		return nil, errors.New("not found")
+	}
+	_, n, _ := net.ParseCIDR("10.0.0.0/29")
+	found, err := s.Scan(context.Background(), []*net.IPNet{n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(probed) != 6 {
+		t.Errorf("want 6 addresses probed, got %v", probed)
+	}
+	want := []Host{
+		{IPAddress: "10.0.0.2", MACAddress: "AB:CD:EF:12:34:57", Hostname: "nas.example.com"},
+		{IPAddress: "10.0.0.5", MACAddress: "B8:27:EB:12:34:56", Hostname: "pi.local", Vendor: "Raspberry Pi Foundation"},
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("want %+v, got %+v", want, found)
+	}
+}
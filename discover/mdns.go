@@ -0,0 +1,159 @@
+package discover
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// mdnsAddr is the address multicast DNS queries are sent to.
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const (
+	typePTR = 12
+	classIN = 1
+	// unicastResponse is set in the class of a question to ask for the answer to be sent directly to the querier.
+	unicastResponse = 0x8000
+)
+
+// reverseName returns the name whose PTR record holds the hostname of the IPv4 address ip.
+func reverseName(ip net.IP) string {
+	v := ip.To4()
+	return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v[3], v[2], v[1], v[0])
+}
+
+// query returns a DNS query for the PTR record of name.
+func query(name string) []byte {
+	msg := make([]byte, 12, 12+len(name)+6)
+	binary.BigEndian.PutUint16(msg[4:], 1) // Number of questions
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, typePTR, byte((classIN|unicastResponse)>>8), byte(classIN))
+	return msg
+}
+
+var errMalformed = errors.New("malformed dns message")
+
+// readName reads the possibly compressed name at off in msg, returning the name and the offset following it.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errMalformed
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) || jumps > 10 {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+			jumps++
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// parseAnswers returns the PTR records answered in msg, mapping their names to the names they point to.
+func parseAnswers(msg []byte) (map[string]string, error) {
+	if len(msg) < 12 {
+		return nil, errMalformed
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+	off := 12
+	for i := 0; i < questions; i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4
+	}
+	records := make(map[string]string)
+	for i := 0; i < answers; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errMalformed
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		off = next + 10
+		if off+length > len(msg) {
+			return nil, errMalformed
+		}
+		if typ == typePTR {
+			target, _, err := readName(msg, off)
+			if err != nil {
+				return nil, err
+			}
+			records[strings.ToLower(name)] = target
+		}
+		off += length
+	}
+	return records, nil
+}
+
+// lookupMDNS asks for the hostnames of ips through multicast DNS, and returns the hostnames answered before ctx is
+// done, keyed by IP address.
+func lookupMDNS(ctx context.Context, ips []net.IP) (map[string]string, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	wanted := make(map[string]string, len(ips))
+	for _, ip := range ips {
+		name := reverseName(ip)
+		wanted[name] = ip.String()
+		if _, err := conn.WriteTo(query(name), mdnsAddr); err != nil {
+			return nil, err
+		}
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Second)
+	}
+	conn.SetReadDeadline(deadline)
+	names := make(map[string]string)
+	buf := make([]byte, 9000)
+	for len(names) < len(wanted) {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			// The deadline is reached
+			break
+		}
+		records, err := parseAnswers(buf[:n])
+		if err != nil {
+			continue
+		}
+		for name, target := range records {
+			if ip, ok := wanted[name]; ok {
+				names[ip] = strings.TrimSuffix(target, ".")
+			}
+		}
+	}
+	return names, nil
+}
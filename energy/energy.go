@@ -0,0 +1,82 @@
+// Package energy estimates the energy used by devices from the time they are online.
+package energy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+type uptime struct {
+	online bool
+	since  time.Time
+	total  time.Duration
+}
+
+// Tracker tracks the uptime of devices from online and offline events.
+type Tracker struct {
+	mu      sync.Mutex
+	start   time.Time
+	devices map[string]*uptime
+	now     func() time.Time
+}
+
+// NewTracker creates a new tracker.
+func NewTracker() *Tracker {
+	return &Tracker{start: time.Now(), devices: make(map[string]*uptime), now: time.Now}
+}
+
+// Since returns the time from which uptime is tracked.
+func (t *Tracker) Since() time.Time { return t.start }
+
+// Handle records event e.
+func (t *Tracker) Handle(e event.Event) {
+	if e.Type != event.Online && e.Type != event.Offline {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	at := e.Time
+	if at.IsZero() {
+		at = t.now()
+	}
+	u, ok := t.devices[e.MACAddress]
+	if !ok {
+		u = &uptime{}
+		t.devices[e.MACAddress] = u
+	}
+	switch {
+	case e.Type == event.Online && !u.online:
+		u.online, u.since = true, at
+	case e.Type == event.Offline && u.online:
+		u.online = false
+		u.total += at.Sub(u.since)
+	}
+}
+
+// Uptime returns the total time the device having macAddress has been online.
+func (t *Tracker) Uptime(macAddress string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u, ok := t.devices[macAddress]
+	if !ok {
+		return 0
+	}
+	if u.online {
+		return u.total + t.now().Sub(u.since)
+	}
+	return u.total
+}
+
+// Run records events received on events until it is closed.
+func (t *Tracker) Run(events <-chan event.Event) {
+	for e := range events {
+		t.Handle(e)
+	}
+}
+
+// KWh returns the energy in kilowatt-hours used by a device drawing watts during d.
+func KWh(watts float64, d time.Duration) float64 {
+	return watts * d.Hours() / 1000
+}
@@ -0,0 +1,40 @@
+package energy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr := NewTracker()
+	tr.now = func() time.Time { return now }
+	mac := "AB:CD:EF:12:34:56"
+
+	tr.Handle(event.Event{Type: event.Online, MACAddress: mac, Time: now})
+	// Repeated events do not restart tracking
+	tr.Handle(event.Event{Type: event.Online, MACAddress: mac, Time: now.Add(time.Hour)})
+	tr.Handle(event.Event{Type: event.Offline, MACAddress: mac, Time: now.Add(2 * time.Hour)})
+	tr.Handle(event.Event{Type: event.Offline, MACAddress: mac, Time: now.Add(3 * time.Hour)})
+	tr.Handle(event.Event{Type: event.Wake, MACAddress: mac, Time: now.Add(3 * time.Hour)})
+	if got, want := tr.Uptime(mac), 2*time.Hour; got != want {
+		t.Errorf("want uptime %s, got %s", want, got)
+	}
+
+	tr.Handle(event.Event{Type: event.Online, MACAddress: mac, Time: now.Add(4 * time.Hour)})
+	now = now.Add(5 * time.Hour)
+	if got, want := tr.Uptime(mac), 3*time.Hour; got != want {
+		t.Errorf("want uptime %s while online, got %s", want, got)
+	}
+	if got := tr.Uptime("11:22:33:44:55:66"); got != 0 {
+		t.Errorf("want no uptime for unknown device, got %s", got)
+	}
+}
+
+func TestKWh(t *testing.T) {
+	if got, want := KWh(250, 4*time.Hour), 1.0; got != want {
+		t.Errorf("want %f kWh, got %f", want, got)
+	}
+}
@@ -0,0 +1,199 @@
+package event
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// Wake is published when a magic packet has been sent to a device.
+	Wake = "wake"
+	// Online is published when a device comes online.
+	Online = "online"
+	// Offline is published when a device goes offline.
+	Offline = "offline"
+	// Failed is published when no magic packet could be sent to a device.
+	Failed = "failed"
+	// Unknown is published when a wake request or relayed magic packet references a MAC address that is not stored.
+	Unknown = "unknown"
+	// Added is published when a device is added to the inventory.
+	Added = "added"
+	// Removed is published when a device is removed from the inventory.
+	Removed = "removed"
+	// Lost is received by subscribers of marked subscriptions in place of the events dropped because they were not
+	// keeping up.
+	Lost = "lost"
+)
+
+// Event is something that happened to a device.
+type Event struct {
+	Type string `json:"type"`
+	// Device is the ID of the device, if the device is stored.
+	Device     string    `json:"device,omitempty"`
+	MACAddress string    `json:"macAddress"`
+	Name       string    `json:"name,omitempty"`
+	Time       time.Time `json:"time"`
+	Synthetic  bool      `json:"synthetic,omitempty"`
+	// Error is the reason a wake failed.
+	Error string `json:"error,omitempty"`
+	// Actor and Client are the user and address of the client requesting a wake of an unknown device, or adding or
+	// removing a device, if known.
+	Actor  string `json:"actor,omitempty"`
+	Client string `json:"client,omitempty"`
+	// Dropped is the number of events lost, if this is a lost event.
+	Dropped int `json:"dropped,omitempty"`
+}
+
+// BusStats holds the number of subscribers of a bus, and the events it published and dropped.
+type BusStats struct {
+	Subscribers int
+	// Lagging is the number of subscribers that have dropped events since they last received one.
+	Lagging   int
+	Published uint64
+	Dropped   uint64
+}
+
+type subscriber struct {
+	ch     chan Event
+	marked bool
+	// lost is the number of events dropped since the subscriber last received one.
+	lost int
+}
+
+// send sends e to s without blocking, preceded by a lost event if s is marked and events were dropped. It returns
+// whether e was sent.
+func (s *subscriber) send(e Event) bool {
+	if s.marked && s.lost > 0 {
+		select {
+		case s.ch <- Event{Type: Lost, Time: e.Time, Dropped: s.lost}:
+			s.lost = 0
+		default:
+			s.lost++
+			return false
+		}
+	}
+	select {
+	case s.ch <- e:
+		s.lost = 0
+		return true
+	default:
+		s.lost++
+		return false
+	}
+}
+
+// Bus distributes events to subscribers.
+type Bus struct {
+	mu     sync.Mutex
+	nextID int
+	subs   map[int]*subscriber
+	stats  BusStats
+}
+
+// NewBus creates a new event bus.
+func NewBus() *Bus { return &Bus{subs: make(map[int]*subscriber)} }
+
+// Subscribe returns a channel receiving published events, buffered by size. The returned function cancels the
+// subscription and closes the channel.
+func (b *Bus) Subscribe(size int) (<-chan Event, func()) { return b.subscribe(size, false) }
+
+// SubscribeMarked is like Subscribe, but events dropped because the subscriber is not keeping up are replaced by a lost
+// event, which is received before the next event delivered.
+func (b *Bus) SubscribeMarked(size int) (<-chan Event, func()) { return b.subscribe(size, true) }
+
+func (b *Bus) subscribe(size int, marked bool) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	s := &subscriber{ch: make(chan Event, size), marked: marked}
+	b.subs[id] = s
+	return s.ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[id]; ok {
+			delete(b.subs, id)
+			close(s.ch)
+		}
+	}
+}
+
+// Publish sends e to all subscribers. Publish never blocks: if a subscriber is not keeping up, the event is dropped
+// for that subscriber.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.Published++
+	for id, s := range b.subs {
+		if s.send(e) {
+			continue
+		}
+		b.stats.Dropped++
+		if s.lost == 1 {
+			log.Printf("event: subscriber %d is not keeping up, dropping events", id)
+		}
+	}
+}
+
+// Stats returns the number of subscribers of b, and the events it published and dropped.
+func (b *Bus) Stats() BusStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := b.stats
+	stats.Subscribers = len(b.subs)
+	for _, s := range b.subs {
+		if s.lost > 0 {
+			stats.Lagging++
+		}
+	}
+	return stats
+}
+
+// Recorder retains the most recent events.
+type Recorder struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+// NewRecorder creates a recorder retaining the last size events.
+func NewRecorder(size int) *Recorder { return &Recorder{events: make([]Event, size)} }
+
+// Handle records event e, replacing the oldest event if the recorder is full.
+func (r *Recorder) Handle(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.events) == 0 {
+		return
+	}
+	r.events[r.next] = e
+	r.next = (r.next + 1) % len(r.events)
+	r.full = r.full || r.next == 0
+}
+
+// Run records events until the channel is closed.
+func (r *Recorder) Run(events <-chan Event) {
+	for e := range events {
+		r.Handle(e)
+	}
+}
+
+// Events returns the recorded events, newest first.
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := r.next
+	if r.full {
+		n = len(r.events)
+	}
+	events := make([]Event, 0, n)
+	for j := 1; j <= n; j++ {
+		events = append(events, r.events[(r.next-j+len(r.events))%len(r.events)])
+	}
+	return events
+}
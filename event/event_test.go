@@ -0,0 +1,88 @@
+package event
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	ch1, cancel1 := b.Subscribe(1)
+	ch2, cancel2 := b.Subscribe(1)
+	defer cancel2()
+
+	b.Publish(Event{Type: Wake, MACAddress: "AB:CD:EF:12:34:56"})
+	for i, ch := range []<-chan Event{ch1, ch2} {
+		e := <-ch
+		if e.Type != Wake || e.Time.IsZero() {
+			t.Errorf("#%d: got unexpected event %+v", i, e)
+		}
+	}
+
+	// Slow subscriber does not block publisher
+	b.Publish(Event{Type: Online})
+	b.Publish(Event{Type: Offline})
+	if e := <-ch2; e.Type != Online {
+		t.Errorf("want %s, got %s", Online, e.Type)
+	}
+
+	cancel1()
+	cancel1()
+	n := 0
+	for range ch1 {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("want 1 buffered event after cancel, got %d", n)
+	}
+}
+
+func TestBusLost(t *testing.T) {
+	b := NewBus()
+	marked, cancel := b.SubscribeMarked(2)
+	defer cancel()
+	_, cancel = b.Subscribe(1)
+	defer cancel()
+
+	for _, typ := range []string{Wake, Online, Offline, Wake} {
+		b.Publish(Event{Type: typ})
+	}
+	if stats := b.Stats(); stats != (BusStats{Subscribers: 2, Lagging: 2, Published: 4, Dropped: 5}) {
+		t.Errorf("want 2 lagging subscribers, got %+v", stats)
+	}
+	<-marked
+	<-marked
+	b.Publish(Event{Type: Online})
+	if e := <-marked; e.Type != Lost || e.Dropped != 2 {
+		t.Errorf("want lost event, got %+v", e)
+	}
+	if e := <-marked; e.Type != Online {
+		t.Errorf("want %s, got %s", Online, e.Type)
+	}
+	if stats := b.Stats(); stats.Lagging != 1 || stats.Dropped != 6 {
+		t.Errorf("want 1 lagging subscriber, got %+v", stats)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder(3)
+	if got := r.Events(); len(got) != 0 {
+		t.Errorf("want no events, got %v", got)
+	}
+	for _, mac := range []string{"a", "b", "c", "d"} {
+		r.Handle(Event{Type: Wake, MACAddress: mac})
+		if mac == "b" {
+			if got := r.Events(); len(got) != 2 || got[0].MACAddress != "b" || got[1].MACAddress != "a" {
+				t.Errorf("want events of b and a, got %v", got)
+			}
+		}
+	}
+	var macs []string
+	for _, e := range r.Events() {
+		macs = append(macs, e.MACAddress)
+	}
+	if got, want := strings.Join(macs, ","), "d,c,b"; got != want {
+		t.Errorf("want events of %s, got %s", want, got)
+	}
+	NewRecorder(0).Handle(Event{})
+}
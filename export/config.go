@@ -0,0 +1,75 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+type exporterConfig struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	URL        string            `json:"url"`
+	Index      string            `json:"index"`
+	Labels     map[string]string `json:"labels"`
+	BufferSize int               `json:"bufferSize"`
+	BatchSize  int               `json:"batchSize"`
+	Interval   string            `json:"interval"`
+}
+
+// ReadConfig reads exporters from the JSON file at name.
+func ReadConfig(name string) ([]*Exporter, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var c struct {
+		Exporters []exporterConfig `json:"exporters"`
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	exporters := make([]*Exporter, 0, len(c.Exporters))
+	for i, ec := range c.Exporters {
+		if ec.URL == "" {
+			return nil, fmt.Errorf("exporter #%d: url is required", i)
+		}
+		var sink Sink
+		switch ec.Type {
+		case "loki":
+			sink = &Loki{URL: ec.URL, Labels: ec.Labels}
+		case "elasticsearch":
+			index := ec.Index
+			if index == "" {
+				index = "wakeup-events"
+			}
+			sink = &Elasticsearch{URL: ec.URL, Index: index}
+		case "ndjson":
+			sink = &NDJSON{URL: ec.URL}
+		default:
+			return nil, fmt.Errorf("exporter #%d: invalid type: %s", i, ec.Type)
+		}
+		name := ec.Name
+		if name == "" {
+			name = ec.Type
+		}
+		x := New(name, sink)
+		if ec.BufferSize > 0 {
+			x.BufferSize = ec.BufferSize
+		}
+		if ec.BatchSize > 0 {
+			x.BatchSize = ec.BatchSize
+		}
+		if ec.Interval != "" {
+			if x.Interval, err = time.ParseDuration(ec.Interval); err != nil {
+				return nil, fmt.Errorf("exporter #%d: %s", i, err)
+			}
+			if x.Interval <= 0 {
+				return nil, fmt.Errorf("exporter #%d: interval must be positive", i)
+			}
+		}
+		exporters = append(exporters, x)
+	}
+	return exporters, nil
+}
@@ -0,0 +1,133 @@
+// Package export ships events continuously to external systems.
+package export
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+// Sink is a destination for exported events.
+type Sink interface {
+	Send(events []event.Event) error
+}
+
+// Exporter buffers events and sends them to a sink in batches. Batches that fail are retried with exponential backoff,
+// and the oldest events are dropped if the buffer fills up while the sink is unavailable.
+type Exporter struct {
+	Name       string
+	Sink       Sink
+	BufferSize int
+	BatchSize  int
+	Interval   time.Duration
+	MaxBackoff time.Duration
+
+	mu       sync.Mutex
+	buf      []event.Event
+	dropped  int
+	failures int
+	retryAt  time.Time
+	now      func() time.Time
+}
+
+// New creates a new exporter sending events to sink.
+func New(name string, sink Sink) *Exporter {
+	return &Exporter{
+		Name:       name,
+		Sink:       sink,
+		BufferSize: 10000,
+		BatchSize:  500,
+		Interval:   5 * time.Second,
+		MaxBackoff: 5 * time.Minute,
+		now:        time.Now,
+	}
+}
+
+// Add buffers event e.
+func (x *Exporter) Add(e event.Event) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.buf = append(x.buf, e)
+	if n := len(x.buf) - x.BufferSize; n > 0 {
+		x.buf = x.buf[n:]
+		x.dropped += n
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer was full.
+func (x *Exporter) Dropped() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.dropped
+}
+
+func (x *Exporter) backoff() time.Duration {
+	d := x.Interval
+	for i := 1; i < x.failures && d < x.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > x.MaxBackoff {
+		d = x.MaxBackoff
+	}
+	return d
+}
+
+// Flush sends all buffered events, unless a previous failure is being backed off from.
+func (x *Exporter) Flush() error {
+	for {
+		x.mu.Lock()
+		if len(x.buf) == 0 || x.now().Before(x.retryAt) {
+			x.mu.Unlock()
+			return nil
+		}
+		n := len(x.buf)
+		if n > x.BatchSize {
+			n = x.BatchSize
+		}
+		batch := append([]event.Event(nil), x.buf[:n]...)
+		dropped := x.dropped
+		x.mu.Unlock()
+
+		err := x.Sink.Send(batch)
+
+		x.mu.Lock()
+		if err != nil {
+			x.failures++
+			x.retryAt = x.now().Add(x.backoff())
+			x.mu.Unlock()
+			return err
+		}
+		x.failures = 0
+		x.retryAt = time.Time{}
+		// Events may have been dropped from the front of the buffer while sending
+		if n = len(batch) - (x.dropped - dropped); n > 0 {
+			x.buf = x.buf[n:]
+		}
+		x.mu.Unlock()
+	}
+}
+
+// Run buffers events received on events, and flushes them every interval until events is closed.
+func (x *Exporter) Run(events <-chan event.Event) {
+	ticker := time.NewTicker(x.Interval)
+	defer ticker.Stop()
+	flush := func() {
+		if err := x.Flush(); err != nil {
+			log.Printf("export: %s: %s", x.Name, err)
+		}
+	}
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				flush()
+				return
+			}
+			x.Add(e)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
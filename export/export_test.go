@@ -0,0 +1,134 @@
+package export
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+type testSink struct {
+	fail bool
+	sent [][]event.Event
+}
+
+func (s *testSink) Send(events []event.Event) error {
+	if s.fail {
+		return errors.New("unavailable")
+	}
+	s.sent = append(s.sent, events)
+	return nil
+}
+
+func TestExporterRetry(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	sink := &testSink{fail: true}
+	x := New("test", sink)
+	x.BatchSize = 2
+	x.BufferSize = 3
+	x.Interval = time.Second
+	x.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		x.Add(event.Event{Type: event.Wake, MACAddress: "AB:CD:EF:12:34:56", Time: now.Add(time.Duration(i))})
+	}
+	if got := x.Dropped(); got != 1 {
+		t.Errorf("want 1 dropped event, got %d", got)
+	}
+	if err := x.Flush(); err == nil {
+		t.Fatal("want error")
+	}
+	if err := x.Flush(); err != nil {
+		t.Fatal("want no error while backing off")
+	}
+	now = now.Add(time.Second)
+	if err := x.Flush(); err == nil {
+		t.Fatal("want error after backoff")
+	}
+	sink.fail = false
+	now = now.Add(time.Second)
+	if err := x.Flush(); err != nil {
+		t.Fatal("want no error while backing off")
+	}
+	if len(sink.sent) != 0 {
+		t.Fatal("want no events sent while backing off")
+	}
+	now = now.Add(time.Second)
+	if err := x.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.sent) != 2 || len(sink.sent[0]) != 2 || len(sink.sent[1]) != 1 {
+		t.Fatalf("want events sent in 2 batches, got %v", sink.sent)
+	}
+	if got := sink.sent[0][0].Time; !got.Equal(now.Add(-3 * time.Second).Add(1)) {
+		t.Errorf("want oldest event dropped, got first event at %s", got)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		got = append(got, r.URL.Path+" "+string(body))
+		if r.URL.Path == "/_bulk" {
+			w.Write([]byte(`{"errors":false}`))
+		}
+	}))
+	defer server.Close()
+
+	events := []event.Event{
+		{Type: event.Wake, MACAddress: "AB:CD:EF:12:34:56", Time: time.Unix(1, 0).UTC()},
+		{Type: event.Online, MACAddress: "AB:CD:EF:12:34:56", Time: time.Unix(2, 0).UTC()},
+	}
+	sinks := []Sink{
+		&NDJSON{URL: server.URL + "/events"},
+		&Loki{URL: server.URL, Labels: map[string]string{"env": "test"}},
+		&Elasticsearch{URL: server.URL, Index: "wakeup"},
+	}
+	for _, s := range sinks {
+		if err := s.Send(events); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"/events " + `{"type":"wake","macAddress":"AB:CD:EF:12:34:56","time":"1970-01-01T00:00:01Z"}` + "\n" +
+			`{"type":"online","macAddress":"AB:CD:EF:12:34:56","time":"1970-01-01T00:00:02Z"}` + "\n",
+		"/loki/api/v1/push " + `{"streams":[` +
+			`{"stream":{"env":"test","job":"wakeup","type":"wake"},"values":[["1000000000","{\"type\":\"wake\",\"macAddress\":\"AB:CD:EF:12:34:56\",\"time\":\"1970-01-01T00:00:01Z\"}"]]},` +
+			`{"stream":{"env":"test","job":"wakeup","type":"online"},"values":[["2000000000","{\"type\":\"online\",\"macAddress\":\"AB:CD:EF:12:34:56\",\"time\":\"1970-01-01T00:00:02Z\"}"]]}]}`,
+		"/_bulk " + `{"index":{"_index":"wakeup"}}` + "\n" +
+			`{"type":"wake","macAddress":"AB:CD:EF:12:34:56","time":"1970-01-01T00:00:01Z"}` + "\n" +
+			`{"index":{"_index":"wakeup"}}` + "\n" +
+			`{"type":"online","macAddress":"AB:CD:EF:12:34:56","time":"1970-01-01T00:00:02Z"}` + "\n",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d requests, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("#%d: want %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"exporters":[{"type":"loki","url":"http://loki:3100","interval":"1s"},{"type":"elasticsearch","url":"http://es:9200"}]}`)
+	f.Close()
+	exporters, err := ReadConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exporters) != 2 || exporters[0].Interval != time.Second || exporters[1].Sink.(*Elasticsearch).Index != "wakeup-events" {
+		t.Errorf("unexpected exporters %+v", exporters)
+	}
+}
@@ -0,0 +1,139 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+func post(url, contentType string, body []byte) ([]byte, error) {
+	res, err := client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: got status %d", url, res.StatusCode)
+	}
+	return data, nil
+}
+
+func ndjson(events []event.Event, header []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		if header != nil {
+			buf.Write(header)
+			buf.WriteByte('\n')
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// NDJSON sends events as newline-delimited JSON to an URL.
+type NDJSON struct{ URL string }
+
+// Send posts events to the URL.
+func (n *NDJSON) Send(events []event.Event) error {
+	body, err := ndjson(events, nil)
+	if err != nil {
+		return err
+	}
+	_, err = post(n.URL, "application/x-ndjson", body)
+	return err
+}
+
+// Loki sends events to the push API of Grafana Loki. Each event is a log line in a stream labeled with the event type.
+type Loki struct {
+	URL    string
+	Labels map[string]string
+}
+
+// Send pushes events to Loki.
+func (l *Loki) Send(events []event.Event) error {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	streams := make(map[string]*stream)
+	var order []string
+	for _, e := range events {
+		s, ok := streams[e.Type]
+		if !ok {
+			labels := map[string]string{"job": "wakeup", "type": e.Type}
+			for k, v := range l.Labels {
+				labels[k] = v
+			}
+			s = &stream{Stream: labels}
+			streams[e.Type] = s
+			order = append(order, e.Type)
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), string(line)})
+	}
+	var push struct {
+		Streams []*stream `json:"streams"`
+	}
+	for _, t := range order {
+		push.Streams = append(push.Streams, streams[t])
+	}
+	body, err := json.Marshal(push)
+	if err != nil {
+		return err
+	}
+	_, err = post(strings.TrimSuffix(l.URL, "/")+"/loki/api/v1/push", "application/json", body)
+	return err
+}
+
+// Elasticsearch indexes events using the bulk API of Elasticsearch.
+type Elasticsearch struct {
+	URL   string
+	Index string
+}
+
+// Send indexes events in Elasticsearch.
+func (e *Elasticsearch) Send(events []event.Event) error {
+	header, err := json.Marshal(map[string]map[string]string{"index": {"_index": e.Index}})
+	if err != nil {
+		return err
+	}
+	body, err := ndjson(events, header)
+	if err != nil {
+		return err
+	}
+	data, err := post(strings.TrimSuffix(e.URL, "/")+"/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		return err
+	}
+	var res struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return err
+	}
+	if res.Errors {
+		return fmt.Errorf("%s: bulk request failed for some events", e.URL)
+	}
+	return nil
+}
@@ -1,5 +1,12 @@
 module github.com/mpolden/wakeup
 
-go 1.13
+go 1.16
 
-require github.com/jessevdk/go-flags v1.4.0
+require (
+	github.com/go-ldap/ldap/v3 v3.2.4
+	github.com/gosnmp/gosnmp v1.30.0
+	github.com/jessevdk/go-flags v1.4.0
+	github.com/mattn/go-sqlite3 v1.14.6
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
+)
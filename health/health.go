@@ -0,0 +1,233 @@
+// Package health scores how reliably devices come online when woken, from the events of their wakes.
+package health
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+const (
+	// Window is the time within which a woken device must come online for the wake to succeed.
+	Window = 5 * time.Minute
+	// Stable is the time a device must stay online after a wake to be considered consistent.
+	Stable = 10 * time.Minute
+	// fastOnline is the time to online regarded as perfect.
+	fastOnline = 30 * time.Second
+	// maxAttempts is the number of wakes of a device that are kept.
+	maxAttempts = 20
+	// minTrend is the number of wakes needed to compute a trend.
+	minTrend = 4
+	// LongAsleep is the time a device must have been offline when woken for the wake to count as a wake from a long
+	// sleep, e.g. from a deep power saving state.
+	LongAsleep = 4 * time.Hour
+)
+
+// Trends of scores.
+const (
+	TrendImproving = "improving"
+	TrendStable    = "stable"
+	TrendDeclining = "declining"
+)
+
+type attempt struct {
+	woken   time.Time
+	online  time.Time
+	offline time.Time
+	failed  bool
+	// asleep is how long the device had been offline when it was woken, if known.
+	asleep time.Duration
+}
+
+type device struct {
+	attempts []*attempt
+	// offline is when the device was last seen going offline.
+	offline time.Time
+}
+
+// Score is the health of a device. Scores range from 0 to 100, combining the rate of wakes after which the device came
+// online, how fast it came online and how consistently it stayed online.
+type Score struct {
+	Score int
+	// Trend compares the score of recent wakes to that of earlier wakes. It is empty if there are too few wakes.
+	Trend        string
+	Wakes        int
+	SuccessRate  float64
+	TimeToOnline time.Duration
+	Consistency  float64
+	// Failures is the number of consecutive wakes, up to the most recent one, after which the device did not come
+	// online.
+	Failures int
+	// FailuresAsleep and SuccessesAsleep are the numbers of failed and successful wakes of the device after it had been
+	// offline for at least LongAsleep.
+	FailuresAsleep  int
+	SuccessesAsleep int
+	// SuccessesAwake is the number of successful wakes of the device after it had been offline for a shorter time.
+	SuccessesAwake int
+}
+
+// Tracker tracks the wakes of devices from events.
+type Tracker struct {
+	mu      sync.Mutex
+	devices map[string]*device
+	now     func() time.Time
+}
+
+// NewTracker creates a new tracker.
+func NewTracker() *Tracker {
+	return &Tracker{devices: make(map[string]*device), now: time.Now}
+}
+
+// Handle records event e.
+func (t *Tracker) Handle(e event.Event) {
+	switch e.Type {
+	case event.Unknown, event.Added, event.Removed:
+		// Wakes of unknown devices are tracked by the wake events that follow them, while changes to the inventory
+		// do not affect health
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	at := e.Time
+	if at.IsZero() {
+		at = t.now()
+	}
+	d, ok := t.devices[e.MACAddress]
+	if !ok {
+		d = &device{}
+		t.devices[e.MACAddress] = d
+	}
+	var last *attempt
+	if len(d.attempts) > 0 {
+		last = d.attempts[len(d.attempts)-1]
+	}
+	switch e.Type {
+	case event.Wake, event.Failed:
+		a := &attempt{woken: at, failed: e.Type == event.Failed}
+		if !d.offline.IsZero() {
+			a.asleep = at.Sub(d.offline)
+		}
+		d.attempts = append(d.attempts, a)
+		if len(d.attempts) > maxAttempts {
+			d.attempts = d.attempts[len(d.attempts)-maxAttempts:]
+		}
+	case event.Online:
+		d.offline = time.Time{}
+		if last != nil && !last.failed && last.online.IsZero() && at.Sub(last.woken) <= Window {
+			last.online = at
+		}
+	case event.Offline:
+		d.offline = at
+		if last != nil && !last.online.IsZero() && last.offline.IsZero() {
+			last.offline = at
+		}
+	}
+}
+
+// Run records events received on events until it is closed.
+func (t *Tracker) Run(events <-chan event.Event) {
+	for e := range events {
+		t.Handle(e)
+	}
+}
+
+// LastWake returns when the device having macAddress was last woken, or a wake of it last failed. It returns false if
+// the device has not been woken since the tracker was created.
+func (t *Tracker) LastWake(macAddress string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.devices[macAddress]
+	if !ok || len(d.attempts) == 0 {
+		return time.Time{}, false
+	}
+	return d.attempts[len(d.attempts)-1].woken, true
+}
+
+// Score returns the score of the device having macAddress. It returns false if no wakes of the device have completed.
+func (t *Tracker) Score(macAddress string) (Score, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	d, ok := t.devices[macAddress]
+	if !ok {
+		return Score{}, false
+	}
+	var done []*attempt
+	for _, a := range d.attempts {
+		// Wakes are pending until the device comes online or the window passes
+		if a.failed || !a.online.IsZero() || now.Sub(a.woken) > Window {
+			done = append(done, a)
+		}
+	}
+	if len(done) == 0 {
+		return Score{}, false
+	}
+	s := score(done, now)
+	if len(done) >= minTrend {
+		half := len(done) / 2
+		before, after := score(done[:half], now), score(done[half:], now)
+		switch d := after.Score - before.Score; {
+		case d >= 10:
+			s.Trend = TrendImproving
+		case d <= -10:
+			s.Trend = TrendDeclining
+		default:
+			s.Trend = TrendStable
+		}
+	}
+	return s, true
+}
+
+func score(attempts []*attempt, now time.Time) Score {
+	var succeeded, settled, stable int
+	var total time.Duration
+	for _, a := range attempts {
+		if a.online.IsZero() {
+			continue
+		}
+		succeeded++
+		total += a.online.Sub(a.woken)
+		if !a.offline.IsZero() {
+			settled++
+			if a.offline.Sub(a.online) >= Stable {
+				stable++
+			}
+		} else if now.Sub(a.online) >= Stable {
+			settled++
+			stable++
+		}
+	}
+	s := Score{Wakes: len(attempts), SuccessRate: float64(succeeded) / float64(len(attempts))}
+	for _, a := range attempts {
+		if a.online.IsZero() {
+			s.Failures++
+		} else {
+			s.Failures = 0
+		}
+		switch {
+		case a.asleep >= LongAsleep && a.online.IsZero():
+			s.FailuresAsleep++
+		case a.asleep >= LongAsleep:
+			s.SuccessesAsleep++
+		case a.asleep > 0 && !a.online.IsZero():
+			s.SuccessesAwake++
+		}
+	}
+	speed := 0.0
+	if succeeded > 0 {
+		s.TimeToOnline = total / time.Duration(succeeded)
+		speed = 1 - float64(s.TimeToOnline-fastOnline)/float64(Window-fastOnline)
+		speed = math.Max(0, math.Min(1, speed))
+	}
+	// Devices which have not been online long enough to tell are given the benefit of the doubt
+	s.Consistency = 1
+	if settled > 0 {
+		s.Consistency = float64(stable) / float64(settled)
+	} else if succeeded == 0 {
+		s.Consistency = 0
+	}
+	s.Score = int(math.Round(100 * (0.6*s.SuccessRate + 0.2*speed + 0.2*s.Consistency)))
+	return s
+}
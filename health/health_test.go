@@ -0,0 +1,105 @@
+package health
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+func TestScore(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewTracker()
+	tracker.now = func() time.Time { return now }
+	mac := "AB:CD:EF:12:34:56"
+	at := func(d time.Duration) time.Time { return now.Add(-24 * time.Hour).Add(d) }
+	if _, ok := tracker.Score(mac); ok {
+		t.Fatal("want no score")
+	}
+
+	// Reliable wakes, coming online within 20 seconds and staying online
+	for i := 0; i < 4; i++ {
+		woken := time.Duration(i) * time.Hour
+		tracker.Handle(event.Event{Type: event.Wake, MACAddress: mac, Time: at(woken)})
+		tracker.Handle(event.Event{Type: event.Online, MACAddress: mac, Time: at(woken + 20*time.Second)})
+		tracker.Handle(event.Event{Type: event.Offline, MACAddress: mac, Time: at(woken + 30*time.Minute)})
+	}
+	s, ok := tracker.Score(mac)
+	if !ok {
+		t.Fatal("want score")
+	}
+	if s.Score != 100 || s.Trend != TrendStable || s.Wakes != 4 || s.TimeToOnline != 20*time.Second || s.Consistency != 1 {
+		t.Errorf("unexpected score %+v", s)
+	}
+
+	// Unreliable wakes: a wake that never came online, a failed wake, and a slow wake which went offline immediately
+	tracker.Handle(event.Event{Type: event.Wake, MACAddress: mac, Time: at(10 * time.Hour)})
+	tracker.Handle(event.Event{Type: event.Online, MACAddress: mac, Time: at(10*time.Hour + Window + time.Second)})
+	tracker.Handle(event.Event{Type: event.Failed, MACAddress: mac, Time: at(11 * time.Hour)})
+	tracker.Handle(event.Event{Type: event.Wake, MACAddress: mac, Time: at(12 * time.Hour)})
+	tracker.Handle(event.Event{Type: event.Online, MACAddress: mac, Time: at(12*time.Hour + 140*time.Second)})
+	tracker.Handle(event.Event{Type: event.Offline, MACAddress: mac, Time: at(12*time.Hour + 5*time.Minute)})
+	tracker.Handle(event.Event{Type: event.Wake, MACAddress: mac, Time: at(13 * time.Hour)})
+	tracker.Handle(event.Event{Type: event.Online, MACAddress: mac, Time: at(13*time.Hour + 20*time.Second)})
+	// A pending wake is not scored
+	tracker.Handle(event.Event{Type: event.Wake, MACAddress: mac, Time: now.Add(-time.Minute)})
+	s, _ = tracker.Score(mac)
+	// 6 of 8 wakes succeeded, taking 40s on average, and 5 of 6 stayed online
+	if s.Wakes != 8 || s.SuccessRate != 0.75 || s.TimeToOnline != 40*time.Second || s.Consistency != 5.0/6 {
+		t.Errorf("unexpected score %+v", s)
+	}
+	if s.Score != 81 || s.Trend != TrendDeclining {
+		t.Errorf("want score 81 and trend %s, got %d and %s", TrendDeclining, s.Score, s.Trend)
+	}
+	if last, ok := tracker.LastWake(mac); !ok || !last.Equal(now.Add(-time.Minute)) {
+		t.Errorf("want last wake at %s, got %s", now.Add(-time.Minute), last)
+	}
+	if _, ok := tracker.LastWake("AB:CD:EF:12:34:57"); ok {
+		t.Error("want no last wake")
+	}
+}
+
+func TestHints(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	mac := "AB:CD:EF:12:34:56"
+	at := func(d time.Duration) time.Time { return now.Add(-7 * 24 * time.Hour).Add(d) }
+	// wake records a wake of a device which has been offline for asleep, and which comes online if ok
+	wake := func(tracker *Tracker, start, asleep time.Duration, ok bool) {
+		tracker.Handle(event.Event{Type: event.Offline, MACAddress: mac, Time: at(start)})
+		tracker.Handle(event.Event{Type: event.Wake, MACAddress: mac, Time: at(start + asleep)})
+		if ok {
+			tracker.Handle(event.Event{Type: event.Online, MACAddress: mac, Time: at(start + asleep + 30*time.Second)})
+		}
+	}
+	var tests = []struct {
+		wakes    []bool
+		asleep   []time.Duration
+		platform string
+		hints    []string
+	}{
+		{[]bool{true, true, false}, nil, "", nil},
+		{[]bool{false, false}, nil, PlatformWindows, []string{"wol-disabled", "fast-startup", "windows-nic"}},
+		{[]bool{true, false, false}, nil, PlatformLinux, []string{"delivery", "ethtool"}},
+		{[]bool{true, true, false, false}, []time.Duration{time.Hour, time.Hour, 8 * time.Hour, 12 * time.Hour}, PlatformMacOS, []string{"erp", "macos-wake"}},
+	}
+	for i, tt := range tests {
+		tracker := NewTracker()
+		tracker.now = func() time.Time { return now }
+		for j, ok := range tt.wakes {
+			asleep := time.Hour
+			if tt.asleep != nil {
+				asleep = tt.asleep[j]
+			}
+			wake(tracker, time.Duration(j)*24*time.Hour, asleep, ok)
+		}
+		s, _ := tracker.Score(mac)
+		var ids []string
+		for _, h := range Hints(s, tt.platform) {
+			ids = append(ids, h.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tt.hints) {
+			t.Errorf("#%d: want hints %v, got %v", i, tt.hints, ids)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package health
+
+// Platforms of devices, deciding which hints apply.
+const (
+	PlatformWindows = "windows"
+	PlatformLinux   = "linux"
+	PlatformMacOS   = "macos"
+)
+
+// minFailures is the number of consecutive failed wakes after which hints are given.
+const minFailures = 2
+
+// Hint suggests a likely cause of wakes failing.
+type Hint struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+var (
+	hintDisabled = Hint{"wol-disabled", "The device has never come online after a wake. Check that Wake-on-LAN is enabled in the BIOS/UEFI and in the settings of the network card."}
+	hintErP      = Hint{"erp", "Wakes fail after the device has been off for a long time. Disable ErP/EuP or deep sleep in the BIOS/UEFI, which cuts power to the network card."}
+	hintDelivery = Hint{"delivery", "Some wakes succeed. Magic packets may not reach the device: check that broadcasts are forwarded to its subnet, and that Energy-Efficient Ethernet is disabled on its switch port."}
+	hintWindows  = []Hint{
+		{"fast-startup", "Disable Fast Startup in the Windows power options, as a device shut down with Fast Startup may not wake."},
+		{"windows-nic", "In Device Manager, enable \"Wake on Magic Packet\" and \"Allow this device to wake the computer\" for the network card."},
+	}
+	hintLinux = []Hint{
+		{"ethtool", "Enable Wake-on-LAN on the network interface with ethtool -s <interface> wol g, and make it persistent, e.g. in NetworkManager or systemd-networkd."},
+	}
+	hintMacOS = []Hint{
+		{"macos-wake", "Enable \"Wake for network access\" in the energy settings of macOS."},
+	}
+)
+
+// Hints returns hints on why wakes of a device running platform fail, from score s of its wakes. No hints are given
+// unless the most recent wakes failed.
+func Hints(s Score, platform string) []Hint {
+	if s.Failures < minFailures {
+		return nil
+	}
+	var hints []Hint
+	switch {
+	case s.SuccessRate == 0:
+		hints = append(hints, hintDisabled)
+	case s.FailuresAsleep > 0 && s.SuccessesAsleep == 0 && s.SuccessesAwake > 0:
+		hints = append(hints, hintErP)
+	default:
+		hints = append(hints, hintDelivery)
+	}
+	switch platform {
+	case PlatformWindows:
+		hints = append(hints, hintWindows...)
+	case PlatformLinux:
+		hints = append(hints, hintLinux...)
+	case PlatformMacOS:
+		hints = append(hints, hintMacOS...)
+	}
+	return hints
+}
@@ -0,0 +1,246 @@
+package history
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// ErrNotChained is returned when verifying a log that is not chained.
+var ErrNotChained = errors.New("history is not chained")
+
+// Chain makes a log tamper-evident by linking each entry to the previous one by a hash, which covers the entry and the
+// hash of the previous entry. Modifying, removing or inserting an entry breaks the links of all entries following it,
+// while removing the most recent entries is revealed by anchors recorded before they were removed.
+type Chain struct {
+	// Key keys hashes by HMAC-SHA256, so that the chain cannot be recomputed without the key. Hashes are SHA-256 if
+	// nil.
+	Key []byte
+}
+
+// hash returns the hash of e, linked to the previous entry having hash prev.
+func (c *Chain) hash(prev string, e Entry) (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	var h hash.Hash
+	if c.Key != nil {
+		h = hmac.New(sha256.New, c.Key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(prev))
+	h.Write([]byte{'\n'})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Anchor is the head of the chain at a point in time, i.e. the sequence number and hash of its last entry. Anchors
+// kept outside the log, e.g. in another system, prove that the entries they cover have not been changed since.
+type Anchor struct {
+	Seq  int64     `json:"seq"`
+	Hash string    `json:"hash"`
+	Time time.Time `json:"time"`
+}
+
+// Verification is the result of verifying a chained log.
+type Verification struct {
+	Valid bool `json:"valid"`
+	// Entries is the number of entries in the log, of which Chained are linked.
+	Entries int `json:"entries"`
+	Chained int `json:"chained"`
+	// Head is the last entry of the chain.
+	Head *Anchor `json:"head,omitempty"`
+	// Broken is the sequence number at which the chain is broken, and Reason why, if it is invalid.
+	Broken int64  `json:"broken,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// anchorFile returns the name of the file recording the anchors of l.
+func (l *Log) anchorFile() string { return l.name + ".anchors" }
+
+// readHead returns the head of the chain, reading it from the log unless already known.
+func (l *Log) readHead() (Anchor, error) {
+	if l.head != nil {
+		return *l.head, nil
+	}
+	var head Anchor
+	if err := l.read(func(e Entry) {
+		if e.Hash != "" {
+			head = Anchor{Seq: e.Seq, Hash: e.Hash, Time: e.Time}
+		}
+	}); err != nil {
+		return Anchor{}, err
+	}
+	l.head = &head
+	return head, nil
+}
+
+// Head returns the head of the chain, which has sequence number zero if no entry has been chained.
+func (l *Log) Head() (Anchor, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Chain == nil {
+		return Anchor{}, ErrNotChained
+	}
+	return l.readHead()
+}
+
+// Anchor records the head of the chain, unless no entry has been chained or the head is already recorded, and returns
+// it.
+func (l *Log) Anchor() (Anchor, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Chain == nil {
+		return Anchor{}, ErrNotChained
+	}
+	head, err := l.readHead()
+	if err != nil || head.Seq == 0 {
+		return head, err
+	}
+	anchors, err := l.anchors()
+	if err != nil {
+		return Anchor{}, err
+	}
+	if n := len(anchors); n > 0 && anchors[n-1].Seq == head.Seq {
+		return anchors[n-1], nil
+	}
+	head.Time = l.now().UTC()
+	data, err := json.Marshal(head)
+	if err != nil {
+		return Anchor{}, err
+	}
+	f, err := os.OpenFile(l.anchorFile(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return Anchor{}, err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return Anchor{}, err
+	}
+	return head, f.Sync()
+}
+
+func (l *Log) anchors() ([]Anchor, error) {
+	data, err := ioutil.ReadFile(l.anchorFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var anchors []Anchor
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		var a Anchor
+		if err := json.Unmarshal(line, &a); err != nil {
+			// Skip an anchor interrupted by a crash
+			continue
+		}
+		anchors = append(anchors, a)
+	}
+	return anchors, nil
+}
+
+// Anchors returns the recorded anchors, oldest first.
+func (l *Log) Anchors() ([]Anchor, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Chain == nil {
+		return nil, ErrNotChained
+	}
+	return l.anchors()
+}
+
+// Verify verifies the links of all chained entries, and that the chain matches the recorded anchors and the anchors in
+// extra, e.g. anchors exported earlier.
+func (l *Log) Verify(extra ...Anchor) (Verification, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Chain == nil {
+		return Verification{}, ErrNotChained
+	}
+	anchors, err := l.anchors()
+	if err != nil {
+		return Verification{}, err
+	}
+	anchors = append(anchors, extra...)
+	anchored := make(map[int64]bool, len(anchors))
+	for _, a := range anchors {
+		anchored[a.Seq] = true
+	}
+	var (
+		v       = Verification{Valid: true}
+		head    Anchor
+		hashes  = make(map[int64]string)
+		hashErr error
+	)
+	fail := func(seq int64, reason string) {
+		if v.Valid {
+			v.Valid, v.Broken, v.Reason = false, seq, reason
+		}
+	}
+	if err := l.read(func(e Entry) {
+		v.Entries++
+		if e.Hash == "" {
+			if head.Seq > 0 {
+				fail(head.Seq+1, "entry is not chained")
+			}
+			return
+		}
+		v.Chained++
+		if e.Seq != head.Seq+1 {
+			fail(head.Seq+1, fmt.Sprintf("entry %d follows entry %d", e.Seq, head.Seq))
+		}
+		h, err := l.Chain.hash(head.Hash, e)
+		if err != nil {
+			hashErr = err
+			return
+		}
+		if h != e.Hash {
+			fail(e.Seq, "hash does not match entry")
+		}
+		head = Anchor{Seq: e.Seq, Hash: e.Hash, Time: e.Time}
+		if anchored[e.Seq] {
+			hashes[e.Seq] = e.Hash
+		}
+	}); err != nil {
+		return Verification{}, err
+	}
+	if hashErr != nil {
+		return Verification{}, hashErr
+	}
+	if head.Seq > 0 {
+		v.Head = &head
+	}
+	for _, a := range anchors {
+		if a.Seq > head.Seq {
+			fail(head.Seq+1, fmt.Sprintf("anchor %d is missing from the chain", a.Seq))
+		} else if hashes[a.Seq] != a.Hash {
+			fail(a.Seq, fmt.Sprintf("anchor %d does not match entry", a.Seq))
+		}
+	}
+	return v, nil
+}
+
+// RunAnchors records the head of the chain at interval, and logs it so that anchors are also kept by the system
+// collecting logs.
+func (l *Log) RunAnchors(interval time.Duration) {
+	for range time.Tick(interval) {
+		a, err := l.Anchor()
+		if err != nil {
+			log.Printf("could not anchor history: %s", err)
+		} else if a.Seq > 0 {
+			log.Printf("History anchored at entry %d with hash %s", a.Seq, a.Hash)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// Package history provides a persistent, append-only log of changes made to the inventory and of wakes and shutdowns of
+// devices.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DeviceAdded is the action of an added device.
+	DeviceAdded = "device.added"
+	// DeviceChanged is the action of a changed device.
+	DeviceChanged = "device.changed"
+	// DeviceRemoved is the action of a removed device.
+	DeviceRemoved = "device.removed"
+	// DeviceWoken is the action of sending magic packets to a device.
+	DeviceWoken = "device.woken"
+	// DevicePoweredOff is the action of running the shutdown action of a device.
+	DevicePoweredOff = "device.powered-off"
+	// GroupChanged is the action of a group whose members changed.
+	GroupChanged = "group.changed"
+	// HookCalled is the action of calling a hook of a device.
+	HookCalled = "hook.called"
+	// ScriptRan is the action of running a script on an event of a device.
+	ScriptRan = "script.ran"
+	// KeepAwakeWoken is the action of waking a device which went offline while it is kept awake.
+	KeepAwakeWoken = "keepawake.woken"
+	// KeepAwakeSuspended is the action of no longer keeping a device awake, after it was woken too often.
+	KeepAwakeSuspended = "keepawake.suspended"
+)
+
+const (
+	// ResultOK is the result of an action that succeeded.
+	ResultOK = "ok"
+	// ResultFailed is the result of an action that failed.
+	ResultFailed = "failed"
+)
+
+// Change is the change of a single field.
+type Change struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// Entry is an entry in the history.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	// Actor is the user or integration which made the change, and Client the address it was made from, if known.
+	Actor      string `json:"actor,omitempty"`
+	Client     string `json:"client,omitempty"`
+	Device     string `json:"device,omitempty"`
+	MACAddress string `json:"macAddress,omitempty"`
+	Group      string `json:"group,omitempty"`
+	// Changes holds the changed fields of an edit. Before and After hold the full representation of what was removed
+	// and added, respectively.
+	Changes []Change        `json:"changes,omitempty"`
+	Before  json.RawMessage `json:"before,omitempty"`
+	After   json.RawMessage `json:"after,omitempty"`
+	// Result is the result of an action which may fail, and Message describes it.
+	Result  string `json:"result,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Output is the output of a script.
+	Output string `json:"output,omitempty"`
+	// Seq and Hash link the entry to the previous one, if the log is chained.
+	Seq  int64  `json:"seq,omitempty"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// Filter selects entries. Empty fields match all entries.
+type Filter struct {
+	// Action matches entries whose action is Action, or starts with Action if it ends with a dot.
+	Action string
+	Actor  string
+	// Device matches entries of the device having this ID or MAC address.
+	Device     string
+	MACAddress string
+	Since      time.Time
+	Until      time.Time
+	// Offset is the number of matching entries to skip, and Limit the maximum number of entries to return.
+	Offset int
+	Limit  int
+}
+
+func (f Filter) match(e Entry) bool {
+	if f.Action != "" && e.Action != f.Action && !(strings.HasSuffix(f.Action, ".") && strings.HasPrefix(e.Action, f.Action)) {
+		return false
+	}
+	if f.Actor != "" && e.Actor != f.Actor {
+		return false
+	}
+	if (f.Device != "" || f.MACAddress != "") && !(f.Device != "" && strings.EqualFold(e.Device, f.Device)) && !(f.MACAddress != "" && strings.EqualFold(e.MACAddress, f.MACAddress)) {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Log is a history stored in a file, with one JSON-encoded entry per line.
+type Log struct {
+	// Chain links appended entries by their hashes, if set. Entries appended before the chain was set are not linked.
+	Chain *Chain
+	name  string
+	mu    sync.Mutex
+	now   func() time.Time
+	head  *Anchor
+}
+
+// Open opens the history stored in file name, which is created when the first entry is appended.
+func Open(name string) *Log { return &Log{name: name, now: time.Now} }
+
+// Append appends entries to the log. Entries without a time are given the current time.
+func (l *Log) Append(entries ...Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now().UTC()
+	var head Anchor
+	if l.Chain != nil {
+		h, err := l.readHead()
+		if err != nil {
+			return err
+		}
+		head = h
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if e.Time.IsZero() {
+			e.Time = now
+		}
+		if l.Chain != nil {
+			e.Seq = head.Seq + 1
+			h, err := l.Chain.hash(head.Hash, e)
+			if err != nil {
+				return err
+			}
+			e.Hash = h
+			head = Anchor{Seq: e.Seq, Hash: h, Time: now}
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(l.name, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data := buf.Bytes()
+	if fi, err := f.Stat(); err != nil {
+		return err
+	} else if fi.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, fi.Size()-1); err != nil {
+			return err
+		}
+		// Terminate an entry interrupted by a crash, so that it does not corrupt the first appended entry
+		if last[0] != '\n' {
+			data = append([]byte{'\n'}, data...)
+		}
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if l.Chain != nil {
+		l.head = &head
+	}
+	return nil
+}
+
+// read calls fn with each entry of the log, in the order they were appended.
+func (l *Log) read(fn func(Entry)) error {
+	file, err := os.Open(l.name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// Skip an entry interrupted by a crash
+			continue
+		}
+		fn(e)
+	}
+	return scanner.Err()
+}
+
+// Query returns the entries matching f, most recently appended first.
+func (l *Log) Query(f Filter) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var entries []Entry
+	if err := l.read(func(e Entry) {
+		if f.match(e) {
+			entries = append(entries, e)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if f.Offset >= len(entries) {
+		entries = nil
+	} else if f.Offset > 0 {
+		entries = entries[f.Offset:]
+	}
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[:f.Limit]
+	}
+	return entries, nil
+}
+
+// Diff returns the fields that differ between the JSON objects before and after, in order of appearance.
+func Diff(before, after interface{}) ([]Change, error) {
+	b, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	seen := make(map[string]bool)
+	for _, fs := range [][]field{b, a} {
+		for _, f := range fs {
+			if seen[f.name] {
+				continue
+			}
+			seen[f.name] = true
+			bv, av := lookup(b, f.name), lookup(a, f.name)
+			if !bytes.Equal(bv, av) {
+				changes = append(changes, Change{Field: f.name, Before: bv, After: av})
+			}
+		}
+	}
+	return changes, nil
+}
+
+type field struct {
+	name  string
+	value json.RawMessage
+}
+
+func lookup(fs []field, name string) json.RawMessage {
+	for _, f := range fs {
+		if f.name == name {
+			return f.value
+		}
+	}
+	return nil
+}
+
+// fields returns the fields of v, which must encode to a JSON object, in order of appearance.
+func fields(v interface{}) ([]field, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var fs []field
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		fs = append(fs, field{name: t.(string), value: value})
+	}
+	return fs, nil
+}
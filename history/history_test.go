@@ -0,0 +1,174 @@
+package history
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	file, err := ioutil.TempFile("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	l := Open(file.Name())
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	entries := []Entry{
+		{Time: now.Add(-2 * time.Hour), Action: DeviceAdded, Actor: "alice", Device: "1", MACAddress: "AB:CD:EF:12:34:56"},
+		{Time: now.Add(-time.Hour), Action: DeviceChanged, Actor: "bob", Device: "1", MACAddress: "AB:CD:EF:12:34:56"},
+		{Action: GroupChanged, Actor: "alice", Group: "media"},
+		{Action: DeviceRemoved, Actor: "netbox", Device: "2", MACAddress: "AB:CD:EF:12:34:57"},
+	}
+	if err := l.Append(entries...); err != nil {
+		t.Fatal(err)
+	}
+	// An interrupted entry is skipped
+	if _, err := file.WriteString(`{"action":"device.`); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(Entry{Time: now.Add(-3 * time.Hour), Action: DeviceAdded, Actor: "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		filter  Filter
+		actions string
+	}{
+		{Filter{}, "device.added device.removed group.changed device.changed device.added"},
+		{Filter{Limit: 2}, "device.added device.removed"},
+		{Filter{Offset: 1, Limit: 2}, "device.removed group.changed"},
+		{Filter{Offset: 5}, ""},
+		{Filter{Action: "device."}, "device.added device.removed device.changed device.added"},
+		{Filter{Action: "device"}, ""},
+		{Filter{Action: GroupChanged}, "group.changed"},
+		{Filter{Actor: "alice"}, "group.changed device.added"},
+		{Filter{Device: "1"}, "device.changed device.added"},
+		{Filter{MACAddress: "ab:cd:ef:12:34:57"}, "device.removed"},
+		{Filter{Since: now.Add(-time.Hour)}, "device.removed group.changed device.changed"},
+		{Filter{Until: now.Add(-time.Hour)}, "device.added device.added"},
+		{Filter{Actor: "carol"}, "device.added"},
+	}
+	for i, tt := range tests {
+		got, err := l.Query(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actions []string
+		for _, e := range got {
+			actions = append(actions, e.Action)
+		}
+		if s := strings.Join(actions, " "); s != tt.actions {
+			t.Errorf("#%d: want %q, got %q", i, tt.actions, s)
+		}
+	}
+	if got, err := Open(file.Name() + ".missing").Query(Filter{}); err != nil || len(got) != 0 {
+		t.Errorf("want no entries for missing file, got %v (%v)", got, err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type device struct {
+		Name   string   `json:"name,omitempty"`
+		MAC    string   `json:"macAddress"`
+		Groups []string `json:"groups,omitempty"`
+	}
+	changes, err := Diff(device{Name: "foo", MAC: "AB:CD:EF:12:34:56"}, device{MAC: "AB:CD:EF:12:34:56", Groups: []string{"media"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"field":"name","before":"foo"},{"field":"groups","after":["media"]}]`; string(data) != want {
+		t.Errorf("want %s, got %s", want, data)
+	}
+	if changes, err := Diff(device{MAC: "foo"}, device{MAC: "foo"}); err != nil || len(changes) != 0 {
+		t.Errorf("want no changes, got %v (%v)", changes, err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	file, err := ioutil.TempFile("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".anchors")
+	l := Open(file.Name())
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	if _, err := l.Verify(); err != ErrNotChained {
+		t.Fatalf("want %v, got %v", ErrNotChained, err)
+	}
+	// Entries appended before the log is chained are not linked
+	if err := l.Append(Entry{Action: DeviceAdded, Actor: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	l.Chain = &Chain{Key: []byte("secret")}
+	if err := l.Append(Entry{Action: DeviceWoken, Message: "<b>"}, Entry{Action: DeviceRemoved}); err != nil {
+		t.Fatal(err)
+	}
+	anchor, err := l.Anchor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anchor.Seq != 2 || anchor.Hash == "" {
+		t.Fatalf("want anchor at entry 2, got %+v", anchor)
+	}
+	// The head of a reopened log is read from the file
+	l = Open(file.Name())
+	l.Chain = &Chain{Key: []byte("secret")}
+	l.now = func() time.Time { return now }
+	if err := l.Append(Entry{Action: DevicePoweredOff}); err != nil {
+		t.Fatal(err)
+	}
+	if anchors, err := l.Anchors(); err != nil || len(anchors) != 1 || anchors[0] != anchor {
+		t.Errorf("want anchor %+v, got %+v (%v)", anchor, anchors, err)
+	}
+	v, err := l.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Valid || v.Entries != 4 || v.Chained != 3 || v.Head == nil || v.Head.Seq != 3 {
+		t.Errorf("want valid chain of 3 entries, got %+v", v)
+	}
+	original, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(original), "\n")
+
+	var tests = []struct {
+		data   string
+		key    string
+		extra  []Anchor
+		broken int64
+		reason string
+	}{
+		{strings.Replace(string(original), "device.removed", "device.added", 1), "secret", nil, 2, "hash does not match entry"},
+		{lines[0] + lines[1] + lines[3], "secret", nil, 2, "entry 3 follows entry 1"},
+		{lines[0] + lines[1] + lines[2] + lines[0] + lines[3], "secret", nil, 3, "entry is not chained"},
+		{lines[0] + lines[1], "secret", nil, 2, "anchor 2 is missing from the chain"},
+		{string(original), "guess", nil, 1, "hash does not match entry"},
+		{string(original), "secret", []Anchor{{Seq: 3, Hash: "foo"}}, 3, "anchor 3 does not match entry"},
+	}
+	for i, tt := range tests {
+		if err := ioutil.WriteFile(file.Name(), []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		l := Open(file.Name())
+		l.Chain = &Chain{Key: []byte(tt.key)}
+		v, err := l.Verify(tt.extra...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Valid || v.Broken != tt.broken || v.Reason != tt.reason {
+			t.Errorf("#%d: want chain broken at %d: %s, got %+v", i, tt.broken, tt.reason, v)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mpolden/wakeup/auth"
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/trace"
+)
+
+// publish publishes e, and records it in the trace.
+func (s *Server) publish(e event.Event) {
+	s.record(trace.Record{Time: e.Time, Event: &e})
+	s.broadcast(e)
+}
+
+// broadcast publishes e without recording it, as it is derived from what is recorded or replayed from the trace.
+func (s *Server) broadcast(e event.Event) {
+	s.trackWaking(e)
+	if s.Events != nil {
+		s.Events.Publish(e)
+	}
+}
+
+// authorizeAdmin verifies that the request is made by an admin, or carries the admin token. Admin endpoints are
+// disabled unless an admin token or authenticator is configured.
+func (s *Server) authorizeAdmin(r *http.Request) *Error {
+	if s.AdminToken == "" && s.Auth == nil {
+		return &Error{Status: http.StatusNotFound, Message: "Resource not found"}
+	}
+	if u := userFrom(r.Context()); u != nil && u.HasRole(auth.RoleAdmin) {
+		return nil
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+		return &Error{Status: http.StatusUnauthorized, Message: "Invalid admin token"}
+	}
+	return nil
+}
+
+func (s *Server) eventsHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	if err := s.authorizeAdmin(r); err != nil {
+		return nil, err
+	}
+	if r.Method != http.MethodPost {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+		}
+	}
+	var e event.Event
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	switch e.Type {
+	case event.Wake, event.Online, event.Offline, event.Unknown:
+	default:
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid event type: %s", e.Type)}
+	}
+	if _, err := net.ParseMAC(e.MACAddress); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid MAC address: %s", e.MACAddress)}
+	}
+	e.Synthetic = true
+	s.publish(e)
+	w.WriteHeader(http.StatusAccepted)
+	return &e, nil
+}
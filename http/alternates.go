@@ -0,0 +1,194 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mpolden/wakeup/macaddr"
+	"github.com/mpolden/wakeup/neigh"
+)
+
+// maxAlternates is the number of alternate MAC addresses kept for a device. The oldest are forgotten first.
+const maxAlternates = 10
+
+// Alternate is a MAC address a device has been observed using instead of its own, e.g. because its operating system
+// randomizes its MAC address. Alternates are not woken, but can be adopted as the MAC address of the device.
+type Alternate struct {
+	MACAddress string `json:"macAddress"`
+	IPAddress  string `json:"ipAddress,omitempty"`
+	// Source is who observed the address, e.g. arp, discovery or the user of the agent reporting it.
+	Source   string    `json:"source,omitempty"`
+	Observed time.Time `json:"observed"`
+}
+
+// adoptRequest configures adopting an alternate MAC address.
+type adoptRequest struct {
+	// Keep keeps the replaced MAC address as an additional MAC address of the device.
+	Keep bool `json:"keep"`
+}
+
+func (d Device) hasAlternate(mac string) bool {
+	for _, a := range d.Alternates {
+		if a.MACAddress == mac {
+			return true
+		}
+	}
+	return false
+}
+
+// removeAlternate removes mac from the alternates of d, and returns whether it was an alternate.
+func (d *Device) removeAlternate(mac string) bool {
+	var keep []Alternate
+	for _, a := range d.Alternates {
+		if a.MACAddress != mac {
+			keep = append(keep, a)
+		}
+	}
+	removed := len(keep) != len(d.Alternates)
+	d.Alternates = keep
+	return removed
+}
+
+// observeAlternate records that the device having MAC address ref was observed using the normalized MAC address mac
+// at ip, on behalf of a. Addresses of any stored device, and alternates already recorded, are not recorded again.
+func (s *Server) observeAlternate(ref, mac, ip string, a actor) (Device, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return Device{}, false, err
+	}
+	device, ok := i.findMAC(ref)
+	if !ok {
+		return Device{}, false, fmt.Errorf("device not found: %s", ref)
+	}
+	if _, ok := i.findMAC(mac); ok || device.hasAlternate(mac) {
+		return device, false, nil
+	}
+	device.Alternates = append(device.Alternates, Alternate{MACAddress: mac, IPAddress: ip, Source: a.name, Observed: s.clock().Now().UTC()})
+	if n := len(device.Alternates); n > maxAlternates {
+		device.Alternates = device.Alternates[n-maxAlternates:]
+	}
+	i.update(device)
+	if err := s.writeCache(i, a); err != nil {
+		return Device{}, false, err
+	}
+	log.Printf("Device with address %s observed using alternate address %s", device.MACAddress, mac)
+	return device, true, nil
+}
+
+// trackAlternates records the MAC address found at the address of each device none of whose own MAC addresses are in
+// t, if it is locally administered. Such a device has most likely randomized its MAC address, while a universally
+// administered address is more likely another host given the address by DHCP.
+func (s *Server) trackAlternates(t neigh.Table, ips map[string]string) error {
+	devices, err := s.Devices()
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		ip := d.address()
+		if ip == "" {
+			continue
+		}
+		seen := false
+		for _, mac := range d.macAddresses() {
+			if _, ok := ips[mac]; ok {
+				seen = true
+				break
+			}
+		}
+		if seen {
+			continue
+		}
+		hw, ok := t[ip]
+		if !ok || !macaddr.LocallyAdministered(hw) || macaddr.Multicast(hw) {
+			continue
+		}
+		if _, _, err := s.observeAlternate(d.MACAddress, macaddr.String(hw), ip, actor{name: arpSource}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alternateHandler handles /api/v1/devices/{id}/alternates/{mac}. POST adopts the alternate MAC address as the MAC
+// address of the device, and DELETE dismisses it.
+func (s *Server) alternateHandler(w http.ResponseWriter, r *http.Request, id, alternate string) (interface{}, *Error) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s or %s", r.Method, http.MethodPost, http.MethodDelete),
+		}
+	}
+	ref, rerr := deviceRef(id)
+	if rerr != nil {
+		return nil, rerr
+	}
+	mac, ok := normalizeMAC(alternate)
+	if !ok {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid MAC address: %s", alternate)}
+	}
+	var body adoptRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+		}
+	}
+	u := userFrom(r.Context())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	device, ok := i.lookup(ref)
+	if !ok || access(u, device) == "" {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", ref)}
+	}
+	if !allows(access(u, device), AccessManage) {
+		return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+	}
+	prev := device
+	if !device.removeAlternate(mac) {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Alternate MAC address not found: %s", mac)}
+	}
+	oldMAC := device.MACAddress
+	if r.Method == http.MethodPost {
+		if err := validateTarget(mac); err != nil {
+			return nil, err
+		}
+		if other, ok := i.findMAC(mac); ok && !same(other, device) {
+			return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("MAC address %s belongs to device %s", mac, other.ID)}
+		}
+		var macs []string
+		for _, m := range device.MACAddresses {
+			if !strings.EqualFold(m, mac) {
+				macs = append(macs, m)
+			}
+		}
+		if body.Keep {
+			macs = append(macs, oldMAC)
+		}
+		device.MACAddress, device.MACAddresses = mac, macs
+	}
+	i.update(device)
+	if oldMAC != device.MACAddress {
+		i.recordRemoved(oldMAC, device)
+	}
+	if err := s.writeCache(i, requestActor(r)); err != nil {
+		return nil, storeFailure(err)
+	}
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return nil, nil
+	}
+	warnLocal(w, prev, device)
+	res := newDeviceResource(device)
+	w.Header().Set("ETag", etag(res))
+	return res, nil
+}
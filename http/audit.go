@@ -0,0 +1,312 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mpolden/wakeup/auth"
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/history"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// History holds history entries, newest first. Next is the URL of the next page of entries, if any.
+type History struct {
+	Entries []history.Entry `json:"entries"`
+	Next    string          `json:"next,omitempty"`
+}
+
+// actor is the user or integration changing the inventory.
+type actor struct {
+	name   string
+	client string
+}
+
+// requestActor returns the actor making request r.
+func requestActor(r *http.Request) actor {
+	a := actor{client: clientAddr(r)}
+	if u := userFrom(r.Context()); u != nil {
+		a.name = u.Name
+	}
+	return a
+}
+
+// members returns the IDs of the members of each group in devices.
+func members(devices []Device) map[string][]string {
+	groups := make(map[string][]string)
+	for _, d := range devices {
+		for _, g := range d.Groups {
+			groups[g] = append(groups[g], d.ID)
+		}
+	}
+	for _, ids := range groups {
+		sort.Strings(ids)
+	}
+	return groups
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// auditEntries returns the history entries of changing the inventory from prev into next.
+func auditEntries(a actor, prev, next *deviceCache) []history.Entry {
+	var entries []history.Entry
+	entry := func(action string, d Device) history.Entry {
+		return history.Entry{Action: action, Actor: a.name, Client: a.client, Device: d.ID, MACAddress: d.MACAddress}
+	}
+	old := make(map[string]Device, len(prev.Devices))
+	for _, d := range prev.Devices {
+		old[d.ID] = d
+	}
+	for _, d := range next.Devices {
+		before, ok := old[d.ID]
+		delete(old, d.ID)
+		if !ok {
+			e := entry(history.DeviceAdded, d)
+			e.After = mustMarshal(d)
+			entries = append(entries, e)
+			continue
+		}
+		changes, err := history.Diff(before, d)
+		if err != nil {
+			panic(err)
+		}
+		if len(changes) > 0 {
+			e := entry(history.DeviceChanged, d)
+			e.Changes = changes
+			entries = append(entries, e)
+		}
+	}
+	var removed []Device
+	for _, d := range old {
+		removed = append(removed, d)
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].ID < removed[j].ID })
+	for _, d := range removed {
+		e := entry(history.DeviceRemoved, d)
+		e.Before = mustMarshal(d)
+		entries = append(entries, e)
+	}
+	before, after := members(prev.Devices), members(next.Devices)
+	var groups []string
+	for g := range before {
+		groups = append(groups, g)
+	}
+	for g := range after {
+		if _, ok := before[g]; !ok {
+			groups = append(groups, g)
+		}
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		if equalStrings(before[g], after[g]) {
+			continue
+		}
+		change := history.Change{Field: "members"}
+		if ids := before[g]; ids != nil {
+			change.Before = mustMarshal(ids)
+		}
+		if ids := after[g]; ids != nil {
+			change.After = mustMarshal(ids)
+		}
+		entries = append(entries, history.Entry{Action: history.GroupChanged, Actor: a.name, Client: a.client, Group: g, Changes: []history.Change{change}})
+	}
+	return entries
+}
+
+// changeEvents returns the events of devices added and removed by a when changing the inventory from prev into next.
+func changeEvents(a actor, prev, next *deviceCache) []event.Event {
+	var events []event.Event
+	change := func(typ string, d Device) event.Event {
+		return event.Event{Type: typ, Device: d.ID, MACAddress: d.MACAddress, Name: d.Name, Actor: a.name, Client: a.client}
+	}
+	old := make(map[string]bool, len(prev.Devices))
+	for _, d := range prev.Devices {
+		old[d.ID] = true
+	}
+	current := make(map[string]bool, len(next.Devices))
+	for _, d := range next.Devices {
+		current[d.ID] = true
+		if !old[d.ID] {
+			events = append(events, change(event.Added, d))
+		}
+	}
+	for _, d := range prev.Devices {
+		if !current[d.ID] {
+			events = append(events, change(event.Removed, d))
+		}
+	}
+	return events
+}
+
+// audit publishes the devices added and removed by changing the inventory from prev into next, and records the change
+// in the history. Failing to record the change does not fail the change itself.
+func (s *Server) audit(a actor, prev, next *deviceCache) {
+	for _, e := range changeEvents(a, prev, next) {
+		s.publish(e)
+	}
+	if s.History == nil {
+		return
+	}
+	if err := s.History.Append(auditEntries(a, prev, next)...); err != nil {
+		log.Printf("could not record history: %s", err)
+	}
+}
+
+// publishWake publishes e, the result of waking a device on behalf of a, and records it in the history.
+func (s *Server) publishWake(a actor, e event.Event) {
+	s.publish(e)
+	if s.History == nil {
+		return
+	}
+	entry := history.Entry{Action: history.DeviceWoken, Actor: a.name, Client: a.client, Device: e.Device, MACAddress: e.MACAddress, Result: history.ResultOK}
+	if e.Type == event.Failed {
+		entry.Result, entry.Message = history.ResultFailed, e.Error
+	}
+	if err := s.History.Append(entry); err != nil {
+		log.Printf("could not record history: %s", err)
+	}
+}
+
+// publishUnknown publishes that a requested a wake of the unknown device having MAC address mac, which may reveal
+// abuse of exposed servers.
+func (s *Server) publishUnknown(a actor, mac string) {
+	s.publish(event.Event{Type: event.Unknown, MACAddress: mac, Actor: a.name, Client: a.client})
+}
+
+// visibleEntries returns the entries u has access to. Users can see their own changes, and changes of the devices they
+// currently have access to.
+func visibleEntries(u *auth.User, devices []Device, entries []history.Entry) []history.Entry {
+	if u == nil || u.HasRole(auth.RoleAdmin) {
+		return entries
+	}
+	visible := make(map[string]bool)
+	for _, d := range devices {
+		if access(u, d) != "" {
+			visible[d.ID] = true
+		}
+	}
+	keep := make([]history.Entry, 0, len(entries))
+	for _, e := range entries {
+		if e.Actor == u.Name || (e.Device != "" && visible[e.Device]) {
+			keep = append(keep, e)
+		}
+	}
+	return keep
+}
+
+func parseFilterTime(name, v string) (time.Time, *Error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for %s: %s", name, v)}
+	}
+	return t, nil
+}
+
+func (s *Server) historyHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if s.History == nil {
+		return notFoundHandler(w, r)
+	}
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	q := r.URL.Query()
+	f := history.Filter{Action: q.Get("action"), Actor: q.Get("actor"), Limit: defaultHistoryLimit}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxHistoryLimit {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for limit: %s", v)}
+		}
+		f.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for offset: %s", v)}
+		}
+		f.Offset = n
+	}
+	var e *Error
+	if f.Since, e = parseFilterTime("since", q.Get("since")); e != nil {
+		return nil, e
+	}
+	if f.Until, e = parseFilterTime("until", q.Get("until")); e != nil {
+		return nil, e
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	if v := q.Get("device"); v != "" {
+		ref, e := deviceRef(v)
+		if e != nil {
+			return nil, e
+		}
+		if isUUID(ref) {
+			f.Device = ref
+			// Wakes of a device before it was stored are found by its MAC address
+			if d, ok := i.findID(ref); ok {
+				f.MACAddress = d.MACAddress
+			}
+		} else {
+			// Removed devices are found by their MAC address
+			f.MACAddress = ref
+			if d, ok := i.findMAC(ref); ok {
+				f.Device = d.ID
+			}
+		}
+	}
+	u := userFrom(r.Context())
+	offset, limit := f.Offset, f.Limit
+	skip := 0
+	if u != nil && !u.HasRole(auth.RoleAdmin) {
+		// Entries are paged after removing those u cannot see
+		f.Offset, f.Limit = 0, 0
+		skip = offset
+	} else {
+		// One more entry is read to tell whether there is a next page
+		f.Limit++
+	}
+	entries, err := s.History.Query(f)
+	if err != nil {
+		return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not read history"}
+	}
+	entries = visibleEntries(u, i.Devices, entries)
+	if skip >= len(entries) {
+		entries = nil
+	} else {
+		entries = entries[skip:]
+	}
+	h := &History{Entries: entries}
+	if len(entries) > limit {
+		h.Entries = entries[:limit]
+		q.Set("offset", strconv.Itoa(offset+limit))
+		h.Next = r.URL.Path + "?" + q.Encode()
+	}
+	if h.Entries == nil {
+		h.Entries = make([]history.Entry, 0)
+	}
+	return h, nil
+}
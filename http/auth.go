@@ -0,0 +1,143 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/mpolden/wakeup/apikey"
+	"github.com/mpolden/wakeup/auth"
+)
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	envelopeKey
+	requestIDKey
+	requestLogKey
+)
+
+func userFrom(ctx context.Context) *auth.User {
+	u, _ := ctx.Value(userKey).(*auth.User)
+	return u
+}
+
+// requiredRole returns the role required to make request r.
+func requiredRole(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/api/v1/admin/") {
+		return auth.RoleAdmin
+	}
+	return auth.RoleUser
+}
+
+func (s *Server) authenticate(r *http.Request) (*auth.User, *Error) {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token := strings.TrimPrefix(h, "Bearer ")
+		if s.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1 {
+			return &auth.User{Name: "admin", Roles: []string{auth.RoleAdmin}}, nil
+		}
+		if s.Keys != nil {
+			k, err := s.Keys.Authenticate(token)
+			if err == nil {
+				return &auth.User{Name: "key:" + k.Name, Roles: []string{auth.RoleUser}, Scope: k.Devices, AllowUnknown: k.AllowUnknown}, nil
+			} else if err != apikey.ErrNotFound {
+				return nil, &Error{err: err, Status: http.StatusServiceUnavailable, Message: "Could not read API keys"}
+			}
+		}
+		if t, ok := s.Auth.(auth.TokenAuthenticator); ok {
+			u, err := t.AuthenticateToken(token)
+			if err == nil {
+				return u, nil
+			} else if err != auth.ErrInvalidCredentials {
+				return nil, &Error{err: err, Status: http.StatusServiceUnavailable, Message: "Authentication backend unavailable"}
+			}
+		}
+		return nil, &Error{Status: http.StatusUnauthorized, Message: "Invalid token"}
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok || s.Auth == nil {
+		return nil, &Error{Status: http.StatusUnauthorized, Message: "Authentication required"}
+	}
+	u, err := s.Auth.Authenticate(username, password)
+	if err == auth.ErrInvalidCredentials {
+		return nil, &Error{Status: http.StatusUnauthorized, Message: "Invalid username or password"}
+	} else if err != nil {
+		return nil, &Error{err: err, Status: http.StatusServiceUnavailable, Message: "Authentication backend unavailable"}
+	}
+	return u, nil
+}
+
+// scopeAllows reports whether a user restricted to a scope of devices can make request r. Such users can only read,
+// simulate schedules, validate MAC addresses and wake devices.
+func scopeAllows(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPost:
+		p := r.URL.Path
+		return p == "/api/v1/wake" || p == "/api/v1/schedules/simulate" || p == "/api/v1/validate/mac" || ((strings.HasPrefix(p, "/api/v1/devices/") || strings.HasPrefix(p, "/api/v1/groups/")) && strings.HasSuffix(p, "/wake"))
+	}
+	return false
+}
+
+// anonymous is the user making unauthenticated requests exempted by Server.AnonymousRead. They can only see devices
+// without an owner.
+var anonymous = &auth.User{Name: "anonymous", Roles: []string{auth.RoleUser}}
+
+// keysRequired reports whether requests must be authenticated without an authenticator being configured, which is the
+// case once any API key exists, as keys would otherwise restrict nothing.
+func (s *Server) keysRequired() (bool, *Error) {
+	if s.Auth != nil || s.Keys == nil {
+		return false, nil
+	}
+	keys, err := s.Keys.List()
+	if err != nil {
+		return false, &Error{err: err, Status: http.StatusServiceUnavailable, Message: "Could not read API keys"}
+	}
+	return len(keys) > 0, nil
+}
+
+// authFilter authenticates and authorizes all requests if an authenticator is configured, or API keys exist. Without
+// an authenticator, API keys and the admin token are then the only accepted credentials. Webhooks are exempt, as they
+// verify their own signatures, and so are /healthz, /readyz and /statusz which are used by cluster probes.
+func (s *Server) authFilter(next http.Handler) http.Handler {
+	if s.Auth == nil && s.Keys == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/v1/webhooks/") || strings.HasPrefix(r.URL.Path, "/api/v1/public/") || strings.HasPrefix(r.URL.Path, "/wake/") || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/statusz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		required, e := s.keysRequired()
+		if e == nil && s.Auth == nil && !required {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.AnonymousRead && (r.Method == http.MethodGet || r.Method == http.MethodHead) && r.Header.Get("Authorization") == "" && requiredRole(r) != auth.RoleAdmin {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey, anonymous)))
+			return
+		}
+		var u *auth.User
+		if e == nil {
+			u, e = s.authenticate(r)
+		}
+		if e == nil && (!u.HasRole(requiredRole(r)) || (u.Scope != nil && !scopeAllows(r))) {
+			e = &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+		}
+		if e != nil {
+			if e.Status == http.StatusUnauthorized && s.Auth != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="wakeup"`)
+			} else if e.Status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="wakeup"`)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			appHandler(func(http.ResponseWriter, *http.Request) (interface{}, *Error) { return nil, e }).ServeHTTP(w, r)
+			return
+		}
+		annotate(r.Context(), "user", u.Name)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey, u)))
+	})
+}
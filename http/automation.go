@@ -0,0 +1,227 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mpolden/wakeup/budget"
+	"github.com/mpolden/wakeup/event"
+)
+
+// hookDedupeWindow is how long the ID of a trigger is remembered, during which triggers of the same ID are not repeated.
+const hookDedupeWindow = 24 * time.Hour
+
+// hookTrigger is the body of a trigger, as sent by e.g. IFTTT Webhooks or Webhooks by Zapier.
+type hookTrigger struct {
+	// Device is the ID, MAC address or name of the device to wake.
+	Device string `json:"device"`
+	// ID identifies the trigger. A trigger repeating the ID of an earlier trigger is acknowledged without waking.
+	ID string `json:"id"`
+}
+
+// HookWake is the result of a trigger. It is flat, so that each field can be used directly in later steps of an
+// automation.
+type HookWake struct {
+	ID         string `json:"id"`
+	Device     string `json:"device"`
+	Name       string `json:"name"`
+	MACAddress string `json:"macAddress"`
+	Time       string `json:"time"`
+	Duplicate  bool   `json:"duplicate"`
+}
+
+// HookEvent is an event, as polled by e.g. Zapier triggers. Its ID is stable, so that pollers can deduplicate events.
+type HookEvent struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Device     string `json:"device"`
+	Name       string `json:"name"`
+	MACAddress string `json:"macAddress"`
+	Error      string `json:"error"`
+	Time       string `json:"time"`
+}
+
+// hookKey reports whether request r holds the hook key, either in the key parameter or the X-API-Key header.
+func (s *Server) hookKey(r *http.Request) bool {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = r.URL.Query().Get("key")
+	}
+	return key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(s.HookKey)) == 1
+}
+
+// seenTrigger returns the result of the earlier trigger having id, if it was seen within hookDedupeWindow. Otherwise
+// result is remembered as the result of id.
+func (s *Server) seenTrigger(id string, result HookWake, now time.Time) (HookWake, bool) {
+	s.hookMu.Lock()
+	defer s.hookMu.Unlock()
+	if s.hookTriggers == nil {
+		s.hookTriggers = make(map[string]hookResult)
+	}
+	for k, v := range s.hookTriggers {
+		if now.Sub(v.time) >= hookDedupeWindow {
+			delete(s.hookTriggers, k)
+		}
+	}
+	if v, ok := s.hookTriggers[id]; ok {
+		return v.wake, true
+	}
+	s.hookTriggers[id] = hookResult{wake: result, time: now}
+	return HookWake{}, false
+}
+
+// forgetTrigger forgets the trigger having id, so that it can be retried.
+func (s *Server) forgetTrigger(id string) {
+	s.hookMu.Lock()
+	defer s.hookMu.Unlock()
+	delete(s.hookTriggers, id)
+}
+
+type hookResult struct {
+	wake HookWake
+	time time.Time
+}
+
+// hookWakeHandler handles POST /api/v1/webhooks/wake, which wakes a device on behalf of a no-code automation. The
+// device is given by the device field of a flat JSON body, or the device parameter, and the trigger is authenticated
+// by the hook key. As the key is shared by all automations, the device is woken regardless of its owner.
+func (s *Server) hookWakeHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	if s.HookKey == "" {
+		return notFoundHandler(w, r)
+	}
+	if r.Method != http.MethodPost {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+		}
+	}
+	if !s.hookKey(r) {
+		return nil, &Error{Status: http.StatusUnauthorized, Message: "Invalid key"}
+	}
+	var req hookTrigger
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil && err != io.EOF {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	if req.Device == "" {
+		req.Device = r.URL.Query().Get("device")
+	}
+	if req.ID == "" {
+		req.ID = r.URL.Query().Get("id")
+	}
+	if req.Device == "" {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Missing device"}
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	device, err := i.resolve(req.Device)
+	if err != nil {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", req.Device), Cause: err.Error()}
+	}
+	annotate(r.Context(), "mac", device.MACAddress)
+	if device.ID == "" {
+		s.publishUnknown(requestActor(r), device.MACAddress)
+	}
+	now := time.Now()
+	result := HookWake{
+		ID:         req.ID,
+		Device:     device.ID,
+		Name:       device.Name,
+		MACAddress: device.MACAddress,
+		Time:       now.UTC().Format(time.RFC3339),
+	}
+	if result.ID == "" {
+		result.ID = nameID(fmt.Sprintf("%s/%d", device.MACAddress, now.UnixNano()))
+	} else if earlier, ok := s.seenTrigger(result.ID, result, now); ok {
+		earlier.Duplicate = true
+		return &earlier, nil
+	}
+	if err := s.wakeAutomated(r.Context(), requestActor(r), device, budget.PriorityInteractive); err != nil {
+		// A failed trigger can be retried with the same ID
+		s.forgetTrigger(req.ID)
+		if errors.Is(err, budget.ErrExceeded) {
+			return nil, &Error{Status: http.StatusTooManyRequests, Message: "Send budget exceeded"}
+		}
+		return nil, &Error{err: err, Status: wakeStatus(err), Message: fmt.Sprintf("Failed to wake %s", displayName(device))}
+	}
+	return &result, nil
+}
+
+func newHookEvent(e event.Event) HookEvent {
+	return HookEvent{
+		ID:         eventID(e),
+		Type:       e.Type,
+		Title:      eventTitle(e),
+		Device:     e.Device,
+		Name:       e.Name,
+		MACAddress: e.MACAddress,
+		Error:      e.Error,
+		Time:       e.Time.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// hookEventsHandler handles GET /api/v1/webhooks/events, which serves recent events as a flat JSON array, newest first,
+// for polling triggers. Events can be filtered by type with the type parameter, which can be repeated, and limited in
+// number by the limit parameter.
+func (s *Server) hookEventsHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if s.HookKey == "" || s.Recent == nil {
+		return notFoundHandler(w, r)
+	}
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	if !s.hookKey(r) {
+		return nil, &Error{Status: http.StatusUnauthorized, Message: "Invalid key"}
+	}
+	types, limit, e := parseEventFilter(r)
+	if e != nil {
+		return nil, e
+	}
+	events := make([]HookEvent, 0)
+	for _, ev := range s.Recent.Events() {
+		if len(events) == limit {
+			break
+		}
+		if len(types) > 0 && !types[ev.Type] {
+			continue
+		}
+		events = append(events, newHookEvent(ev))
+	}
+	return events, nil
+}
+
+// parseEventFilter parses the type and limit parameters of request r. A negative limit means no limit.
+func parseEventFilter(r *http.Request) (map[string]bool, int, *Error) {
+	types := make(map[string]bool)
+	for _, t := range r.URL.Query()["type"] {
+		switch t {
+		case event.Wake, event.Online, event.Offline, event.Failed, event.Unknown, event.Added, event.Removed:
+			types[t] = true
+		default:
+			return nil, 0, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid event type: %s", t)}
+		}
+	}
+	limit := -1
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, 0, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for limit: %s", v)}
+		}
+		limit = n
+	}
+	return types, limit, nil
+}
@@ -0,0 +1,81 @@
+package http
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// badgeColors are the colors of device statuses in badges.
+var badgeColors = map[string]string{
+	statusOnline:   "#4c1",
+	statusOffline:  "#e05d44",
+	statusWaking:   "#dfb317",
+	statusUnknown:  "#9f9f9f",
+	statusConflict: "#fe7d37",
+}
+
+// badgeSVG is a badge in the style of shields.io, showing the name of a device on the left and its status on the right.
+var badgeSVG = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Status}}">
+<title>{{.Label}}: {{.Status}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="{{.LabelWidth}}" height="20" fill="#555"/>
+<rect x="{{.LabelWidth}}" width="{{.StatusWidth}}" height="20" fill="{{.Color}}"/>
+<rect width="{{.Width}}" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="14">{{.Label}}</text>
+<text x="{{.StatusX}}" y="14">{{.Status}}</text>
+</g>
+</svg>
+`))
+
+type badge struct {
+	Label, Status, Color    string
+	LabelWidth, StatusWidth int
+}
+
+// textWidth approximates the width in pixels of s when rendered in the font of a badge.
+func textWidth(s string) int { return 7*len([]rune(s)) + 10 }
+
+func (b badge) Width() int   { return b.LabelWidth + b.StatusWidth }
+func (b badge) LabelX() int  { return b.LabelWidth / 2 }
+func (b badge) StatusX() int { return b.LabelWidth + b.StatusWidth/2 }
+
+func (s *Server) badgeHandler(w http.ResponseWriter, r *http.Request, id string) (interface{}, *Error) {
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	ref, rerr := deviceRef(id)
+	if rerr != nil {
+		return nil, rerr
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	device, ok := i.lookup(ref)
+	if !ok || access(userFrom(r.Context()), device) == "" {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", ref)}
+	}
+	fresh, ferr := parseFresh(r)
+	if ferr != nil {
+		return nil, ferr
+	}
+	status := s.status(r.Context(), device, fresh)
+	checked := s.checked(device)
+	label := displayName(device)
+	b := badge{Label: label, Status: status, Color: badgeColors[status], LabelWidth: textWidth(label), StatusWidth: textWidth(status)}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	// Badges are cached until the status is refreshed
+	s.cacheStatus(w, "", checked)
+	badgeSVG.Execute(w, b)
+	return nil, nil
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mpolden/wakeup/ban"
+)
+
+// trapped reports whether path is one of the trap paths, which no legitimate client requests.
+func (s *Server) trapped(path string) bool {
+	for _, p := range s.TrapPaths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// logBan logs ban b, in a format that can be matched by fail2ban.
+func logBan(b ban.Ban) {
+	log.Printf("Banned client %s until %s: %s", b.Client, b.Until.UTC().Format(time.RFC3339), b.Reason)
+}
+
+// banFilter rejects requests of banned clients with 403 Forbidden. Clients are banned when requesting a trap path, or
+// when too many of their requests fail with 401 Unauthorized or 404 Not Found, as when scanning for endpoints or
+// guessing credentials. Each such request is logged, in a format that can be matched by fail2ban. Loopback clients are
+// never banned, so that a local admin can always lift bans.
+func (s *Server) banFilter(next http.Handler) http.Handler {
+	if s.Bans == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientAddr(r)
+		if ip := net.ParseIP(client); ip != nil && ip.IsLoopback() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fail := func(e *Error) {
+			w.Header().Set("Content-Type", "application/json")
+			appHandler(func(http.ResponseWriter, *http.Request) (interface{}, *Error) { return nil, e }).ServeHTTP(w, r)
+		}
+		if b, ok := s.Bans.Banned(client); ok {
+			w.Header().Set("Retry-After", seconds(time.Until(b.Until)))
+			fail(&Error{Status: http.StatusForbidden, Message: fmt.Sprintf("Client %s is banned", client)})
+			return
+		}
+		if s.trapped(r.URL.Path) {
+			log.Printf("Suspicious request from %s: %s %s is a trap", client, r.Method, r.URL.Path)
+			logBan(s.Bans.Ban(client, "requested trap "+r.URL.Path))
+			fail(&Error{Status: http.StatusNotFound, Message: "Resource not found"})
+			return
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status != http.StatusUnauthorized && sw.status != http.StatusNotFound {
+			return
+		}
+		log.Printf("Suspicious request from %s: %s %s failed with %d", client, r.Method, r.URL.Path, sw.status)
+		if b, banned := s.Bans.Strike(client, fmt.Sprintf("too many requests failing with %d", sw.status)); banned {
+			logBan(b)
+		}
+	})
+}
+
+// bansHandler handles /api/v1/admin/bans, which lists the banned clients, and /api/v1/admin/bans/{client}, which
+// lifts the ban of client.
+func (s *Server) bansHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if err := s.authorizeAdmin(r); err != nil {
+		return nil, err
+	}
+	if s.Bans == nil {
+		return notFoundHandler(w, r)
+	}
+	client := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/bans"), "/")
+	if client == "" {
+		if r.Method != http.MethodGet {
+			return nil, &Error{
+				Status:  http.StatusMethodNotAllowed,
+				Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+			}
+		}
+		return s.Bans.Bans(), nil
+	}
+	if r.Method != http.MethodDelete {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodDelete),
+		}
+	}
+	if !s.Bans.Unban(client) {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Ban not found: %s", client)}
+	}
+	log.Printf("Lifted ban of client %s", client)
+	w.WriteHeader(http.StatusNoContent)
+	return nil, nil
+}
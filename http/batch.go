@@ -0,0 +1,72 @@
+package http
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// maxBatch is the maximum number of MAC addresses woken by a batch wake.
+const maxBatch = 256
+
+// batchWake handles POST /api/v1/wake having a JSON array of MAC addresses as its body, which wakes each device in
+// turn as a group wake does, including its simulate and stagger parameters. Stored devices are woken as stored, while
+// unknown MAC addresses are woken without being stored.
+func (s *Server) batchWake(w http.ResponseWriter, r *http.Request, macs []string) (interface{}, *Error) {
+	simulate, stagger, e := s.parseGroupWake(r)
+	if e != nil {
+		return nil, e
+	}
+	if len(macs) == 0 {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Missing MAC addresses"}
+	}
+	if len(macs) > maxBatch {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Too many MAC addresses: %d, must be at most %d", len(macs), maxBatch)}
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		// Waking by MAC address does not require the store
+		var ok bool
+		if i, ok = s.lastKnown(); !ok {
+			return nil, storeFailure(err)
+		}
+		log.Print(err)
+	}
+	u := userFrom(r.Context())
+	seen := make(map[string]bool, len(macs))
+	members := make([]Device, 0, len(macs))
+	for _, ref := range macs {
+		if err := validateTarget(ref); err != nil {
+			return nil, err
+		}
+		mac, _ := normalizeMAC(ref)
+		if seen[mac] {
+			continue
+		}
+		seen[mac] = true
+		device, ok := i.findMAC(mac)
+		if ok {
+			if !allows(access(u, device), AccessWake) {
+				return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+			}
+		} else {
+			s.publishUnknown(requestActor(r), mac)
+			if u != nil && u.Scope != nil && !u.AllowUnknown {
+				return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+			}
+			device = Device{MACAddress: mac}
+		}
+		members = append(members, device)
+	}
+	plan, err := s.planGroupWake("", members, stagger)
+	if err != nil {
+		return nil, &Error{err: err, Status: wakeStatus(err), Message: "Could not determine source address"}
+	}
+	plan.Simulated = simulate
+	if simulate {
+		return plan, nil
+	}
+	return s.runGroupWake(w, r, plan)
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltDevices = []byte("devices")
+	boltMeta    = []byte("meta")
+	boltCache   = []byte("cache")
+)
+
+// boltStore stores the cache in a BoltDB database, holding a bucket of devices keyed by their ID and a bucket holding
+// the rest of the cache.
+type boltStore struct{ db *bolt.DB }
+
+// openBolt opens the BoltDB database at path, which is created if it does not exist. The returned bool is true if the
+// database holds no cache yet.
+func openBolt(path string) (*boltStore, bool, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, false, err
+	}
+	created := false
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltDevices); err != nil {
+			return err
+		}
+		meta, err := tx.CreateBucketIfNotExists(boltMeta)
+		if err != nil {
+			return err
+		}
+		created = meta.Get(boltCache) == nil
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, false, err
+	}
+	return &boltStore{db: db}, created, nil
+}
+
+func (b *boltStore) load() (*deviceCache, error) {
+	var (
+		meta    storeMeta
+		devices []Device
+	)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(boltMeta).Get(boltCache); data != nil {
+			if err := json.Unmarshal(data, &meta); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(boltDevices).ForEach(func(k, v []byte) error {
+			var d Device
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			devices = append(devices, d)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meta.cache(devices), nil
+}
+
+// save writes the devices that changed from prev into next, and the rest of next, in a single transaction.
+func (b *boltStore) save(prev, next *deviceCache) error {
+	e, _ := prev.diff(next)
+	meta, err := json.Marshal(newStoreMeta(next))
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		devices := tx.Bucket(boltDevices)
+		for _, d := range e.Devices {
+			data, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			if err := devices.Put([]byte(d.ID), data); err != nil {
+				return err
+			}
+		}
+		for _, id := range e.Removed {
+			if err := devices.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(boltMeta).Put(boltCache, meta)
+	})
+}
+
+// compact does nothing, as BoltDB reuses the space of earlier versions of the cache.
+func (b *boltStore) compact() error { return nil }
+
+func (b *boltStore) close() error { return b.db.Close() }
@@ -0,0 +1,201 @@
+package http
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Bulk operations on the devices matching a filter.
+const (
+	bulkDelete = "bulkDelete"
+	bulkTag    = "bulkTag"
+	bulkGroup  = "bulkGroup"
+)
+
+// BulkChange is the change made to the devices matched by a bulk operation. Tags are added and removed by bulkTag,
+// while bulkGroup replaces the groups of devices with Groups.
+type BulkChange struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// BulkResult is the preview or result of a bulk operation. Devices are the IDs of the devices changed by the operation.
+// A preview holds the token confirming the operation, which is only accepted while the devices are unchanged.
+type BulkResult struct {
+	Operation string   `json:"operation"`
+	Filter    string   `json:"filter"`
+	Devices   []string `json:"devices"`
+	Token     string   `json:"token,omitempty"`
+	Applied   bool     `json:"applied"`
+}
+
+// bulkToken returns the token confirming operation op having change c on devices, as filtered from inventory revision.
+func bulkToken(op, filter string, c BulkChange, revision int64, devices []string) string {
+	data, err := json.Marshal(struct {
+		Operation string     `json:"operation"`
+		Filter    string     `json:"filter"`
+		Change    BulkChange `json:"change"`
+		Revision  int64      `json:"revision"`
+		Devices   []string   `json:"devices"`
+	}{op, filter, c, revision, devices})
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// validateBulkChange validates the change made by operation op.
+func validateBulkChange(op string, c BulkChange) *Error {
+	var names []string
+	switch op {
+	case bulkDelete:
+		if len(c.Add) > 0 || len(c.Remove) > 0 || len(c.Groups) > 0 {
+			return &Error{Status: http.StatusBadRequest, Message: "Devices are deleted without changes"}
+		}
+	case bulkTag:
+		if len(c.Add) == 0 && len(c.Remove) == 0 {
+			return &Error{Status: http.StatusBadRequest, Message: "Missing tags to add or remove"}
+		}
+		if len(c.Groups) > 0 {
+			return &Error{Status: http.StatusBadRequest, Message: "Groups are assigned by bulkGroup"}
+		}
+		names = append(append(names, c.Add...), c.Remove...)
+	case bulkGroup:
+		if len(c.Add) > 0 || len(c.Remove) > 0 {
+			return &Error{Status: http.StatusBadRequest, Message: "Tags are added and removed by bulkTag"}
+		}
+		names = c.Groups
+	}
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid group: %q", name)}
+		}
+	}
+	return nil
+}
+
+// bulkApply returns device with change c of operation op applied, and whether it changed.
+func bulkApply(op string, c BulkChange, device Device) (Device, bool) {
+	var groups []string
+	seen := make(map[string]bool)
+	add := func(g string) {
+		if !seen[g] {
+			seen[g] = true
+			groups = append(groups, g)
+		}
+	}
+	switch op {
+	case bulkDelete:
+		return device, true
+	case bulkTag:
+		removed := make(map[string]bool, len(c.Remove))
+		for _, g := range c.Remove {
+			removed[g] = true
+		}
+		for _, g := range append(append([]string(nil), device.Groups...), c.Add...) {
+			if !removed[g] {
+				add(g)
+			}
+		}
+	case bulkGroup:
+		for _, g := range c.Groups {
+			add(g)
+		}
+	}
+	changed := len(groups) != len(device.Groups)
+	for j := 0; !changed && j < len(groups); j++ {
+		changed = groups[j] != device.Groups[j]
+	}
+	device.Groups = groups
+	return device, changed
+}
+
+// bulkHandler handles POST /api/v1/admin/devices:{operation}, which deletes, tags or assigns groups to the devices
+// matching the search expression of the filter parameter, or all devices if unset. Operations require confirmation:
+// without the confirm parameter, the devices that would change are previewed together with a token, which confirms the
+// operation when given as the confirm parameter of the same request. The token is only accepted while the devices
+// matched and the inventory are unchanged, so that changes made since the preview are not applied unseen.
+func (s *Server) bulkHandler(op string) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+		defer r.Body.Close()
+		if err := s.authorizeAdmin(r); err != nil {
+			return nil, err
+		}
+		if r.Method != http.MethodPost {
+			return nil, &Error{
+				Status:  http.StatusMethodNotAllowed,
+				Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+			}
+		}
+		filter := r.URL.Query().Get("filter")
+		q, qerr := parseQuery(filter)
+		if qerr != nil {
+			return nil, qerr
+		}
+		var change BulkChange
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil && err != io.EOF {
+			return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+		}
+		if err := validateBulkChange(op, change); err != nil {
+			return nil, err
+		}
+		// Devices are searched without holding the lock, as searching may probe them
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		for _, name := range append(append(append([]string(nil), change.Add...), change.Remove...), change.Groups...) {
+			if _, ok := i.smartGroup(name); ok {
+				return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("Members of smart group %s are given by its query", name)}
+			}
+		}
+		var changed []Device
+		res := BulkResult{Operation: op, Filter: filter, Devices: make([]string, 0)}
+		for _, d := range s.search(r.Context(), q, i.Devices) {
+			if next, ok := bulkApply(op, change, d); ok {
+				changed = append(changed, next)
+				res.Devices = append(res.Devices, d.ID)
+			}
+		}
+		token := bulkToken(op, filter, change, i.Revision, res.Devices)
+		confirm := r.URL.Query().Get("confirm")
+		if confirm == "" {
+			res.Token = token
+			return &res, nil
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		revision := i.Revision
+		if i, err = s.readDevices(); err != nil {
+			return nil, storeFailure(err)
+		}
+		if subtle.ConstantTimeCompare([]byte(confirm), []byte(token)) != 1 || i.Revision != revision {
+			return nil, &Error{Status: http.StatusConflict, Message: "Invalid confirmation token, or devices changed since the preview"}
+		}
+		for _, d := range changed {
+			if op == bulkDelete {
+				i.remove(d)
+				i.recordRemoved(d.MACAddress, d)
+			} else {
+				i.update(d)
+			}
+		}
+		if len(changed) > 0 {
+			if err := s.writeCache(i, requestActor(r)); err != nil {
+				return nil, storeFailure(err)
+			}
+		}
+		res.Applied = true
+		return &res, nil
+	}
+}
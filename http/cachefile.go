@@ -0,0 +1,133 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The checksum of the cache file is kept next to it, in the format of sha256sum, together with a backup of the
+// previous cache file and its checksum.
+func (f fileStore) sumFile() string { return f.name + ".sha256" }
+
+func (f fileStore) backup() fileStore { return fileStore{f.name + ".bak"} }
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func checksumLine(data []byte) []byte { return []byte(checksum(data) + "\n") }
+
+// verify reads the cache file, and verifies it against its checksum. Cache files written before checksums were kept
+// are not verified.
+func (f fileStore) verify() ([]byte, error) {
+	data, err := ioutil.ReadFile(f.name)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := ioutil.ReadFile(f.sumFile())
+	if os.IsNotExist(err) {
+		return data, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if fields := strings.Fields(string(sum)); len(fields) == 0 || fields[0] != checksum(data) {
+		return nil, fmt.Errorf("%s: checksum mismatch", f.name)
+	}
+	return data, nil
+}
+
+// decode reads and decodes the cache file.
+func (f fileStore) decode() (deviceCache, error) {
+	var c deviceCache
+	data, err := f.verify()
+	if err != nil {
+		return c, err
+	}
+	if len(data) > 0 {
+		if err := decodeCache(data, &c); err != nil {
+			return c, fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	return c, nil
+}
+
+// read reads the cache file, falling back to its backup if it is corrupt, e.g. because the process died while it was
+// being written. An empty cache file is created if neither of them exists.
+func (f fileStore) read() (deviceCache, error) {
+	c, err := f.decode()
+	if err == nil {
+		return c, nil
+	}
+	b := f.backup()
+	if os.IsNotExist(err) {
+		if _, berr := os.Stat(b.name); os.IsNotExist(berr) {
+			return c, ioutil.WriteFile(f.name, nil, 0644)
+		}
+	}
+	backup, berr := b.decode()
+	if berr != nil {
+		return c, err
+	}
+	log.Printf("%s, reading backup %s", err, b.name)
+	return backup, nil
+}
+
+// write replaces the cache file by data. The previous cache file is kept as a backup, and files are replaced
+// atomically, so that a crash leaves either the cache file or its backup intact.
+func (f fileStore) write(data []byte) error {
+	// Only an intact cache file is kept as a backup, so that a corrupt one does not replace a good backup
+	if prev, err := f.verify(); err == nil && len(prev) > 0 {
+		b := f.backup()
+		if err := writeAtomic(b.sumFile(), checksumLine(prev)); err != nil {
+			return err
+		}
+		if err := writeAtomic(b.name, prev); err != nil {
+			return err
+		}
+	}
+	// The checksum is replaced first, so that a crash before the cache file is replaced falls back to the backup
+	if err := writeAtomic(f.sumFile(), checksumLine(data)); err != nil {
+		return err
+	}
+	return writeAtomic(f.name, data)
+}
+
+// writeAtomic writes data to a temporary file, and renames it to name once it is synced to disk.
+func writeAtomic(name string, data []byte) error {
+	dir := filepath.Dir(name)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	// The rename is only durable once the directory is synced
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
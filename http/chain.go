@@ -0,0 +1,88 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mpolden/wakeup/history"
+)
+
+// HistoryAnchors holds the head of the history chain, and the anchors recorded of it.
+type HistoryAnchors struct {
+	Head    history.Anchor   `json:"head"`
+	Anchors []history.Anchor `json:"anchors"`
+}
+
+// parseAnchor parses an anchor in the format SEQ:HASH.
+func parseAnchor(v string) (history.Anchor, *Error) {
+	parts := strings.SplitN(v, ":", 2)
+	if len(parts) == 2 {
+		if seq, err := strconv.ParseInt(parts[0], 10, 64); err == nil && seq > 0 && parts[1] != "" {
+			return history.Anchor{Seq: seq, Hash: parts[1]}, nil
+		}
+	}
+	return history.Anchor{}, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid anchor: %s, must be SEQ:HASH", v)}
+}
+
+// historyChainHandler handles /api/v1/admin/history/anchors, which lists the anchors of the history chain on GET and
+// records its head as an anchor on POST, and GET /api/v1/admin/history/verify, which verifies the chain against the
+// recorded anchors and those given by the anchor parameter, which can be repeated.
+func (s *Server) historyChainHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if err := s.authorizeAdmin(r); err != nil {
+		return nil, err
+	}
+	if s.History == nil || s.History.Chain == nil {
+		return nil, &Error{Status: http.StatusNotFound, Message: "History is not chained"}
+	}
+	switch strings.TrimPrefix(r.URL.Path, "/api/v1/admin/history/") {
+	case "anchors":
+		switch r.Method {
+		case http.MethodGet:
+			head, err := s.History.Head()
+			if err != nil {
+				return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not read history"}
+			}
+			anchors, err := s.History.Anchors()
+			if err != nil {
+				return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not read anchors"}
+			}
+			if anchors == nil {
+				anchors = make([]history.Anchor, 0)
+			}
+			return &HistoryAnchors{Head: head, Anchors: anchors}, nil
+		case http.MethodPost:
+			a, err := s.History.Anchor()
+			if err != nil {
+				return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not anchor history"}
+			}
+			return &a, nil
+		}
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s or %s", r.Method, http.MethodGet, http.MethodPost),
+		}
+	case "verify":
+		if r.Method != http.MethodGet {
+			return nil, &Error{
+				Status:  http.StatusMethodNotAllowed,
+				Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+			}
+		}
+		var anchors []history.Anchor
+		for _, v := range r.URL.Query()["anchor"] {
+			a, e := parseAnchor(v)
+			if e != nil {
+				return nil, e
+			}
+			anchors = append(anchors, a)
+		}
+		v, err := s.History.Verify(anchors...)
+		if err != nil {
+			return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not verify history"}
+		}
+		return &v, nil
+	}
+	return notFoundHandler(w, r)
+}
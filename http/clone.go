@@ -0,0 +1,89 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Clone describes the device created by cloning another device, which differs from it only by these fields.
+type Clone struct {
+	MACAddress string `json:"macAddress"`
+	Name       string `json:"name"`
+	IPAddress  string `json:"ipAddress"`
+}
+
+// clone returns a copy of the settings of device, having the MAC address, name and IP address of c. Fields identifying
+// the device or observed from it are not copied, and the copy belongs to whoever creates it.
+func (c *Clone) clone(device Device) Device {
+	d := device
+	d.ID, d.Name, d.MACAddress, d.IPAddress = "", c.Name, c.MACAddress, c.IPAddress
+	d.MACAddresses, d.LastKnownIP, d.Hostname, d.Alternates = nil, "", "", nil
+	d.Source, d.PublicWake, d.Owner = "", 0, ""
+	d.Groups = append([]string(nil), device.Groups...)
+	d.Shares = append([]Share(nil), device.Shares...)
+	return d
+}
+
+// cloneHandler handles POST /api/v1/devices/{id}/clone, which creates a device having the settings of another device.
+func (s *Server) cloneHandler(w http.ResponseWriter, r *http.Request, id string) (interface{}, *Error) {
+	if r.Method != http.MethodPost {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+		}
+	}
+	ref, rerr := deviceRef(id)
+	if rerr != nil {
+		return nil, rerr
+	}
+	var body Clone
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	if body.MACAddress == "" {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Missing MAC address"}
+	}
+	mac, ok := normalizeMAC(body.MACAddress)
+	if !ok {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid MAC address: %s", body.MACAddress)}
+	}
+	if err := validateTarget(mac); err != nil {
+		return nil, err
+	}
+	body.MACAddress = mac
+	if body.IPAddress != "" && net.ParseIP(body.IPAddress) == nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid IP address: %s", body.IPAddress)}
+	}
+	u := userFrom(r.Context())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	device, ok := i.lookup(ref)
+	if !ok || access(u, device) == "" {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", ref)}
+	}
+	if other, ok := i.findMAC(mac); ok {
+		return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("MAC address %s belongs to device %s", mac, other.ID)}
+	}
+	clone := body.clone(device)
+	if err := validateSharing(u, &clone.Sharing); err != nil {
+		return nil, err
+	}
+	i.add(clone)
+	clone, _ = i.findMAC(mac)
+	i.record(clone.MACAddress)
+	if err := s.writeCache(i, requestActor(r)); err != nil {
+		return nil, storeFailure(err)
+	}
+	warnLocal(w, Device{}, clone)
+	res := newDeviceResource(clone)
+	w.Header().Set("ETag", etag(res))
+	w.Header().Set("Location", "/api/v1/devices/"+clone.ID)
+	w.WriteHeader(http.StatusCreated)
+	return res, nil
+}
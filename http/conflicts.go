@@ -0,0 +1,256 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+)
+
+const (
+	// ConflictMAC is a conflict between devices having the same normalized MAC address.
+	ConflictMAC = "macAddress"
+	// ConflictIP is a conflict between devices having different MAC addresses, but the same IP address.
+	ConflictIP = "ipAddress"
+)
+
+// Conflict is a set of stored devices which appear to be the same device.
+type Conflict struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+	// Devices holds the IDs of the conflicting devices.
+	Devices []string `json:"devices"`
+}
+
+// Conflicts holds the conflicts between the devices a user has access to.
+type Conflicts struct {
+	Conflicts []Conflict `json:"conflicts"`
+}
+
+// Merge holds the IDs or MAC addresses of devices to merge into another device.
+type Merge struct {
+	From []string `json:"from"`
+}
+
+// conflicts returns the conflicts between devices.
+func conflicts(devices []Device) []Conflict {
+	byMAC := make(map[string][]string)
+	byIP := make(map[string]map[string]bool)
+	ipDevices := make(map[string][]string)
+	for _, d := range devices {
+		mac, ok := normalizeMAC(d.MACAddress)
+		if !ok {
+			mac = d.MACAddress
+		}
+		for _, v := range d.macAddresses() {
+			m, ok := normalizeMAC(v)
+			if !ok {
+				m = v
+			}
+			byMAC[m] = append(byMAC[m], d.ID)
+		}
+		if d.IPAddress == "" {
+			continue
+		}
+		ip := d.IPAddress
+		if parsed := net.ParseIP(ip); parsed != nil {
+			ip = parsed.String()
+		}
+		if byIP[ip] == nil {
+			byIP[ip] = make(map[string]bool)
+		}
+		byIP[ip][mac] = true
+		ipDevices[ip] = append(ipDevices[ip], d.ID)
+	}
+	cs := make([]Conflict, 0)
+	for mac, macs := range byMAC {
+		if len(macs) > 1 {
+			cs = append(cs, Conflict{Kind: ConflictMAC, Value: mac, Devices: macs})
+		}
+	}
+	for ip, macs := range byIP {
+		if len(macs) > 1 {
+			cs = append(cs, Conflict{Kind: ConflictIP, Value: ip, Devices: ipDevices[ip]})
+		}
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		if cs[i].Kind != cs[j].Kind {
+			return cs[i].Kind > cs[j].Kind
+		}
+		return cs[i].Value < cs[j].Value
+	})
+	return cs
+}
+
+// merge combines the metadata of device src into device dst. Values of dst take precedence, while lists are joined.
+func merge(dst, src Device) Device {
+	if dst.Name == "" {
+		dst.Name = src.Name
+	}
+	if dst.Description == "" {
+		dst.Description = src.Description
+	}
+	if dst.IPAddress == "" {
+		dst.IPAddress = src.IPAddress
+	}
+	if dst.Platform == "" {
+		dst.Platform = src.Platform
+	}
+	if dst.KeepAwake == nil {
+		dst.KeepAwake = src.KeepAwake
+	}
+	if dst.Shutdown == "" {
+		dst.Shutdown = src.Shutdown
+	}
+	if dst.SecureOnPassword == "" {
+		dst.SecureOnPassword = src.SecureOnPassword
+	}
+	if dst.WakeAddress == "" {
+		dst.WakeAddress, dst.WakePort = src.WakeAddress, src.WakePort
+	}
+	if dst.Transport == "" {
+		dst.Transport = src.Transport
+	}
+	if dst.WakeInterface == "" {
+		dst.WakeInterface = src.WakeInterface
+	}
+	// The MAC addresses of src become additional MAC addresses of dst
+	for _, v := range src.macAddresses() {
+		if !containsMAC(dst.macAddresses(), v) {
+			dst.MACAddresses = append(dst.MACAddresses, v)
+		}
+	}
+	for _, a := range src.Alternates {
+		if !containsMAC(dst.macAddresses(), a.MACAddress) && !dst.hasAlternate(a.MACAddress) {
+			dst.Alternates = append(dst.Alternates, a)
+		}
+	}
+	for _, g := range src.Groups {
+		if !contains(dst.Groups, g) {
+			dst.Groups = append(dst.Groups, g)
+		}
+	}
+	if dst.Notes == "" {
+		dst.Notes = src.Notes
+	} else if src.Notes != "" && src.Notes != dst.Notes {
+		dst.Notes += "\n\n" + src.Notes
+	}
+	for _, c := range src.Prerequisites {
+		found := false
+		for _, v := range dst.Prerequisites {
+			found = found || v.Name == c.Name
+		}
+		if !found {
+			dst.Prerequisites = append(dst.Prerequisites, c)
+		}
+	}
+	dst.Essential = dst.Essential || src.Essential
+	if dst.Watts == 0 {
+		dst.Watts = src.Watts
+	}
+	if dst.Owner == "" {
+		dst.Owner = src.Owner
+	}
+	for _, sh := range src.Shares {
+		found := false
+		for _, v := range dst.Shares {
+			found = found || v == sh
+		}
+		if !found {
+			dst.Shares = append(dst.Shares, sh)
+		}
+	}
+	return dst
+}
+
+func (s *Server) conflictsHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	return &Conflicts{Conflicts: conflicts(visible(userFrom(r.Context()), i.Devices))}, nil
+}
+
+// mergeHandler merges devices into the device identified by id, and removes the merged devices.
+func (s *Server) mergeHandler(w http.ResponseWriter, r *http.Request, id string) (interface{}, *Error) {
+	if r.Method != http.MethodPost {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+		}
+	}
+	ref, rerr := deviceRef(id)
+	if rerr != nil {
+		return nil, rerr
+	}
+	var body Merge
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	if len(body.From) == 0 {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "No devices to merge"}
+	}
+	u := userFrom(r.Context())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	device, ok := i.lookup(ref)
+	if !ok || access(u, device) == "" {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", ref)}
+	}
+	if !allows(access(u, device), AccessManage) {
+		return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+	}
+	var merged []Device
+	for _, from := range body.From {
+		src, ok := i.lookup(from)
+		if !ok || access(u, src) == "" {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Device not found: %s", from)}
+		}
+		if same(src, device) {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Cannot merge device %s into itself", from)}
+		}
+		if !allows(access(u, src), AccessManage) {
+			return nil, &Error{Status: http.StatusForbidden, Message: fmt.Sprintf("Forbidden to change device %s", from)}
+		}
+		device = merge(device, src)
+		merged = append(merged, src)
+	}
+	if err := validateNotes(device.Notes); err != nil {
+		return nil, err
+	}
+	i.update(device)
+	for _, d := range merged {
+		if i.remove(d) {
+			i.recordRemoved(d.MACAddress, d)
+		}
+	}
+	if err := s.writeCache(i, requestActor(r)); err != nil {
+		return nil, storeFailure(err)
+	}
+	res := newDeviceResource(device)
+	w.Header().Set("ETag", etag(res))
+	return res, nil
+}
+
+func containsMAC(macs []string, mac string) bool {
+	m, _ := normalizeMAC(mac)
+	for _, v := range macs {
+		if n, _ := normalizeMAC(v); n == m {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errCooldown matches errors of wakes refused because the device was woken within the wake cooldown, which fail
+// requests with 429 Too Many Requests.
+var errCooldown = errors.New("wake cooldown has not passed")
+
+// cooldownError refuses a wake of the device having MAC address mac, having a wake cooldown of cooldown, until retry
+// has passed.
+type cooldownError struct {
+	mac      string
+	cooldown time.Duration
+	retry    time.Duration
+}
+
+func (e *cooldownError) Error() string {
+	return fmt.Sprintf("device with address %s was woken less than %s ago", e.mac, e.cooldown)
+}
+
+func (e *cooldownError) Is(target error) bool { return target == errCooldown }
+
+// cooldown attempts a wake of the device having MAC address mac, and returns an error if a wake was attempted within
+// the wake cooldown. Attempts count whether or not they succeed, so that a client cannot flood the network by retrying
+// a wake that fails.
+func (s *Server) cooldown(mac string) error {
+	if s.WakeCooldown <= 0 {
+		return nil
+	}
+	s.cooldownMu.Lock()
+	defer s.cooldownMu.Unlock()
+	now := time.Now()
+	if t, ok := s.attempted[mac]; ok && now.Sub(t) < s.WakeCooldown {
+		return &cooldownError{mac: mac, cooldown: s.WakeCooldown, retry: s.WakeCooldown - now.Sub(t)}
+	}
+	if s.attempted == nil {
+		s.attempted = make(map[string]time.Time)
+	}
+	for k, t := range s.attempted {
+		if now.Sub(t) >= s.WakeCooldown {
+			delete(s.attempted, k)
+		}
+	}
+	s.attempted[mac] = now
+	return nil
+}
@@ -0,0 +1,200 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mpolden/wakeup/wait"
+	"github.com/mpolden/wakeup/wol"
+)
+
+const demoSource = "demo"
+
+// demoDevices are the devices stored by Simulate if the inventory is empty. Their addresses are reserved for
+// documentation, so they cannot be mistaken for real devices.
+var demoDevices = []Device{
+	{Name: "nas", Description: "File server in the basement", MACAddress: "02:00:5E:10:00:01", IPAddress: "192.0.2.10", Groups: []string{"storage"}, Platform: "linux", Watts: 45, Essential: true},
+	{Name: "backup", Description: "Offsite backup target", MACAddress: "02:00:5E:10:00:02", IPAddress: "192.0.2.11", Groups: []string{"storage"}, Platform: "linux", Watts: 30},
+	{Name: "workstation", Description: "Desk in the office", MACAddress: "02:00:5E:10:00:03", MACAddresses: []string{"02:00:5E:10:00:13"}, IPAddress: "192.0.2.20", Groups: []string{"office"}, Platform: "windows", Watts: 120},
+	{Name: "laptop", MACAddress: "02:00:5E:10:00:04", IPAddress: "192.0.2.21", Groups: []string{"office"}, Platform: "macos", Watts: 20},
+	{Name: "media-center", Description: "Living room", MACAddress: "02:00:5E:10:00:05", IPAddress: "192.0.2.30", Groups: []string{"media"}, Platform: "linux", Watts: 60},
+	{Name: "gaming-pc", MACAddress: "02:00:5E:10:00:06", IPAddress: "192.0.2.31", Groups: []string{"media"}, Platform: "windows", Watts: 350},
+	{Name: "build-server", Description: "CI runner", MACAddress: "02:00:5E:10:00:07", IPAddress: "192.0.2.40", Groups: []string{"lab"}, Platform: "linux", Watts: 200, ProbePorts: []int{22}},
+	{Name: "lab-node", MACAddress: "02:00:5E:10:00:08", Groups: []string{"lab"}, Watts: 150},
+}
+
+// simulation simulates the devices of the server in demo mode. Woken devices come online after a random latency, unless
+// the magic packet is lost, and devices go offline or come online by themselves at random.
+type simulation struct {
+	mu         sync.Mutex
+	rand       *rand.Rand
+	online     map[string]bool
+	booting    map[string]time.Time
+	minLatency time.Duration
+	maxLatency time.Duration
+	// lossRate is the fraction of wakes after which a device does not come online.
+	lossRate float64
+	interval time.Duration
+	now      func() time.Time
+	address  func(mac string) string
+}
+
+// latency returns a random latency between minLatency and maxLatency.
+func (s *simulation) latency() time.Duration {
+	if s.maxLatency <= s.minLatency {
+		return s.minLatency
+	}
+	return s.minLatency + time.Duration(s.rand.Int63n(int64(s.maxLatency-s.minLatency)))
+}
+
+// wake simulates sending a magic packet to hwAddr. The device having this MAC address starts booting, if it is offline
+// and has a known IP address.
+func (s *simulation) wake(hwAddr net.HardwareAddr, opts wol.Options) error {
+	ip := s.address(hwAddr.String())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ip == "" || s.online[ip] {
+		return nil
+	}
+	if _, ok := s.booting[ip]; ok {
+		return nil
+	}
+	if s.rand.Float64() < s.lossRate {
+		return nil
+	}
+	s.booting[ip] = s.now().Add(s.latency())
+	return nil
+}
+
+// up reports whether the device at ip is online, completing its boot if its latency has passed.
+func (s *simulation) up(ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.booting[ip]; ok && !s.now().Before(t) {
+		delete(s.booting, ip)
+		s.online[ip] = true
+	}
+	return s.online[ip]
+}
+
+// wait simulates waiting for probes, until the device they probe is online or ctx is done.
+func (s *simulation) wait(ctx context.Context, probes []wait.Probe) wait.Result {
+	start := s.now()
+	r := wait.Result{Probes: probes}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		r.Attempts++
+		for _, p := range probes {
+			host := p.Address
+			if h, _, err := net.SplitHostPort(p.Address); err == nil {
+				host = h
+			}
+			if s.up(host) {
+				r.Status = wait.StatusOnline
+			}
+		}
+		if r.Status == wait.StatusOnline {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+			continue
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			r.Status = wait.StatusTimeout
+		} else {
+			r.Status = wait.StatusCanceled
+		}
+		break
+	}
+	r.Waited = s.now().Sub(start)
+	return r
+}
+
+// step changes the state of devices at random: online devices are shut down, and offline devices are turned on by hand,
+// each with probability p.
+func (s *simulation) step(ips []string, p float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ip := range ips {
+		if _, ok := s.booting[ip]; ok || s.rand.Float64() >= p {
+			continue
+		}
+		s.online[ip] = !s.online[ip]
+	}
+}
+
+// Simulate puts the server in demo mode, where the state of devices is simulated instead of probed, and wakes are
+// simulated instead of sending magic packets. Devices come online between minLatency and maxLatency after they are
+// woken, and change state by themselves every interval at random. An empty inventory is filled with demo devices.
+func (s *Server) Simulate(seed int64, minLatency, maxLatency, interval time.Duration) error {
+	sim := &simulation{
+		rand:       rand.New(rand.NewSource(seed)),
+		online:     make(map[string]bool),
+		booting:    make(map[string]time.Time),
+		minLatency: minLatency,
+		maxLatency: maxLatency,
+		lossRate:   0.1,
+		interval:   time.Second,
+		now:        time.Now,
+		address: func(mac string) string {
+			devices, err := s.Devices()
+			if err != nil {
+				return ""
+			}
+			for _, d := range devices {
+				for _, m := range d.macAddresses() {
+					if v, ok := normalizeMAC(m); ok && strings.EqualFold(v, mac) {
+						return d.address()
+					}
+				}
+			}
+			return ""
+		},
+	}
+	s.mu.Lock()
+	i, err := s.readDevices()
+	if err == nil && len(i.Devices) == 0 {
+		for _, d := range demoDevices {
+			i.upsert(demoSource, d)
+		}
+		err = s.writeCache(i, actor{name: demoSource})
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.wakeFunc = sim.wake
+	s.waitFunc = sim.wait
+	// Half of the demo devices start out online
+	for j, d := range demoDevices {
+		if ip := d.address(); ip != "" {
+			sim.online[ip] = j%2 == 0
+		}
+	}
+	if interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				devices, err := s.Devices()
+				if err != nil {
+					continue
+				}
+				var ips []string
+				for _, d := range devices {
+					if ip := d.address(); ip != "" {
+						ips = append(ips, ip)
+					}
+				}
+				sim.step(ips, 0.05)
+			}
+		}()
+	}
+	return nil
+}
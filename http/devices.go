@@ -0,0 +1,623 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mpolden/wakeup/auth"
+	"github.com/mpolden/wakeup/macaddr"
+	"github.com/mpolden/wakeup/power"
+	"github.com/mpolden/wakeup/prereq"
+)
+
+// DeviceResource is the representation of a device in the management API. All fields are always present, so that
+// declarative clients can diff resources reliably. The device is identified by its ID, but can also be referred to by
+// its MAC address.
+type DeviceResource struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	MACAddress  string `json:"macAddress"`
+	// MACAddresses holds additional MAC addresses, woken after MACAddress.
+	MACAddresses []string `json:"macAddresses"`
+	IPAddress    string   `json:"ipAddress"`
+	Groups       []string `json:"groups"`
+	Notes        string   `json:"notes"`
+	// Prerequisites are checked before the device is woken.
+	Prerequisites []prereq.Check `json:"prerequisites"`
+	Essential     bool           `json:"essential"`
+	Watts         float64        `json:"watts"`
+	// OnOnline is a URL called once the device is confirmed online after being woken.
+	OnOnline   string     `json:"onOnline"`
+	ProbePorts []int      `json:"probePorts"`
+	Platform   string     `json:"platform"`
+	KeepAwake  *KeepAwake `json:"keepAwake"`
+	// Shutdown is the name of the action the device is powered off by, if it can be. Actions are configured by the
+	// operator, and assigned to devices by admins.
+	Shutdown string `json:"shutdown"`
+	// SecureOnPassword is sent with magic packets to the device, if set.
+	SecureOnPassword string `json:"secureOnPassword"`
+	WakeAddress      string `json:"wakeAddress"`
+	WakePort         int    `json:"wakePort"`
+	Transport        string `json:"transport"`
+	WakeInterface    string `json:"wakeInterface"`
+	// LastKnownIP is the IP address the device was last observed at. It is read-only, and cleared when IPAddress
+	// changes.
+	LastKnownIP string `json:"lastKnownIP"`
+	// Hostname is the name the IP address of the device resolves to. It is read-only.
+	Hostname string `json:"hostname"`
+	// Vendor is the manufacturer assigned the prefix of MACAddress, as found in the OUI registry. It is read-only.
+	Vendor string `json:"vendor"`
+	// Alternates are MAC addresses the device has been observed using instead of its own, which can be adopted through
+	// /api/v1/devices/{id}/alternates/{mac}. They are read-only.
+	Alternates []Alternate `json:"alternates"`
+}
+
+// GroupResource is the representation of a group in the management API.
+type GroupResource struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+	// Query is the query of a smart group, whose members cannot be changed.
+	Query string `json:"query,omitempty"`
+}
+
+func newDeviceResource(d Device) *DeviceResource {
+	groups := d.Groups
+	if groups == nil {
+		groups = make([]string, 0)
+	}
+	checks := d.Prerequisites
+	if checks == nil {
+		checks = make([]prereq.Check, 0)
+	}
+	macs := d.MACAddresses
+	if macs == nil {
+		macs = make([]string, 0)
+	}
+	ports := d.ProbePorts
+	if ports == nil {
+		ports = make([]int, 0)
+	}
+	alternates := d.Alternates
+	if alternates == nil {
+		alternates = make([]Alternate, 0)
+	}
+	var vendor string
+	if hwAddr, err := net.ParseMAC(d.MACAddress); err == nil {
+		vendor = macaddr.Vendor(hwAddr)
+	}
+	return &DeviceResource{
+		ID:               d.ID,
+		Name:             d.Name,
+		Description:      d.Description,
+		MACAddress:       d.MACAddress,
+		MACAddresses:     macs,
+		IPAddress:        d.IPAddress,
+		Groups:           groups,
+		Notes:            d.Notes,
+		Prerequisites:    checks,
+		Essential:        d.Essential,
+		Watts:            d.Watts,
+		OnOnline:         d.OnOnline,
+		ProbePorts:       ports,
+		Platform:         d.Platform,
+		KeepAwake:        d.KeepAwake,
+		Shutdown:         string(d.Shutdown),
+		SecureOnPassword: d.SecureOnPassword,
+		WakeAddress:      d.WakeAddress,
+		WakePort:         d.WakePort,
+		Transport:        d.Transport,
+		WakeInterface:    d.WakeInterface,
+		LastKnownIP:      d.LastKnownIP,
+		Hostname:         d.Hostname,
+		Vendor:           vendor,
+		Alternates:       alternates,
+	}
+}
+
+func etag(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// findMAC returns the device having the normalized MAC address mac, either as its MAC address or as one of its
+// additional MAC addresses.
+func (d *deviceCache) findMAC(mac string) (Device, bool) {
+	for _, v := range d.Devices {
+		if m, ok := normalizeMAC(v.MACAddress); ok && m == mac {
+			return v, true
+		}
+	}
+	for _, v := range d.Devices {
+		for _, a := range v.MACAddresses {
+			if m, ok := normalizeMAC(a); ok && m == mac {
+				return v, true
+			}
+		}
+	}
+	return Device{}, false
+}
+
+func (s *Server) devicesHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/devices/"), "/")
+	if parts[0] == "" {
+		if len(parts) == 1 {
+			return s.deviceListHandler(w, r)
+		}
+		return notFoundHandler(w, r)
+	}
+	switch {
+	case len(parts) == 1:
+		return s.deviceHandler(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "wake":
+		return s.deviceWakeHandler(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "sharing":
+		return s.sharingHandler(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "merge":
+		return s.mergeHandler(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "clone":
+		return s.cloneHandler(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "totp":
+		return s.publicWakeHandler(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "address":
+		return s.addressHandler(w, r, parts[0])
+	case len(parts) == 3 && parts[1] == "alternates":
+		return s.alternateHandler(w, r, parts[0], parts[2])
+	case len(parts) == 2 && parts[1] == "power":
+		return s.powerHandler(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "status":
+		return s.statusHandler(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "badge.svg":
+		return s.badgeHandler(w, r, parts[0])
+	}
+	return notFoundHandler(w, r)
+}
+
+// deviceRef returns the canonical form of ref, which is either a device ID or a MAC address.
+func deviceRef(ref string) (string, *Error) {
+	if isUUID(ref) {
+		return strings.ToLower(ref), nil
+	}
+	if mac, ok := normalizeMAC(ref); ok {
+		return mac, nil
+	}
+	return "", &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid device ID or MAC address: %s", ref)}
+}
+
+func (s *Server) deviceHandler(w http.ResponseWriter, r *http.Request, id string) (interface{}, *Error) {
+	ref, rerr := deviceRef(id)
+	if rerr != nil {
+		return nil, rerr
+	}
+	u := userFrom(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		i, err := s.readDevices()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		device, ok := i.lookup(ref)
+		if !ok || access(u, device) == "" {
+			return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", ref)}
+		}
+		res := newDeviceResource(device)
+		w.Header().Set("ETag", etag(res))
+		return res, nil
+	case http.MethodPut:
+		var body DeviceResource
+		// Fields omitted from the body are taken from the template, if any
+		var preset string
+		if name := r.URL.Query().Get("template"); name != "" {
+			t, err := s.template(name)
+			if err != nil {
+				return nil, err
+			}
+			body = t.resource()
+			preset = t.Shutdown
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+		}
+		mac, _ := normalizeMAC(body.MACAddress)
+		if body.MACAddress != "" && mac == "" {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid MAC address: %s", body.MACAddress)}
+		}
+		// The MAC address can only be changed when the device is referred to by its ID
+		if !isUUID(ref) && mac != "" && mac != ref {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("MAC address %s does not match %s", body.MACAddress, ref)}
+		}
+		if body.IPAddress != "" && net.ParseIP(body.IPAddress) == nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid IP address: %s", body.IPAddress)}
+		}
+		if err := validateNotes(body.Notes); err != nil {
+			return nil, err
+		}
+		if err := validateDescription(body.Description); err != nil {
+			return nil, err
+		}
+		if err := validateProbePorts(body.ProbePorts); err != nil {
+			return nil, err
+		}
+		if err := validatePlatform(body.Platform); err != nil {
+			return nil, err
+		}
+		if err := validateKeepAwake(body.KeepAwake); err != nil {
+			return nil, err
+		}
+		if err := s.validateShutdown(body.Shutdown); err != nil {
+			return nil, err
+		}
+		if err := validateSecureOnPassword(body.SecureOnPassword); err != nil {
+			return nil, err
+		}
+		if err := validateWakeAddress(body.WakeAddress, body.WakePort); err != nil {
+			return nil, err
+		}
+		if err := validateTransport(body.Transport, body.WakeInterface); err != nil {
+			return nil, err
+		}
+		if err := validatePrerequisites(body.Prerequisites); err != nil {
+			return nil, err
+		}
+		if err := validateHook(body.OnOnline); err != nil {
+			return nil, err
+		}
+		if body.Watts < 0 {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid watts: %v", body.Watts)}
+		}
+		var macs []string
+		for _, v := range body.MACAddresses {
+			m, ok := normalizeMAC(v)
+			if !ok {
+				return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid MAC address: %s", v)}
+			}
+			macs = append(macs, m)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		i, err := s.readDevices()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		device, exists := i.lookup(ref)
+		ifMatch := r.Header.Get("If-Match")
+		if exists {
+			if !allows(access(u, device), AccessManage) {
+				return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+			}
+			if ifMatch != "" && ifMatch != "*" && ifMatch != etag(newDeviceResource(device)) {
+				return nil, &Error{Status: http.StatusPreconditionFailed, Message: "Device has been modified"}
+			}
+			// If-None-Match: * only creates the device
+			if r.Header.Get("If-None-Match") == "*" {
+				return nil, &Error{Status: http.StatusPreconditionFailed, Message: fmt.Sprintf("Device already exists: %s", device.ID)}
+			}
+		} else {
+			if ifMatch != "" {
+				return nil, &Error{Status: http.StatusPreconditionFailed, Message: fmt.Sprintf("Device not found: %s", ref)}
+			}
+			if isUUID(ref) {
+				if mac == "" {
+					return nil, &Error{Status: http.StatusBadRequest, Message: "Missing MAC address"}
+				}
+				device.ID = ref
+			} else {
+				mac = ref
+			}
+			if err := validateSharing(u, &device.Sharing); err != nil {
+				return nil, err
+			}
+		}
+		// Shutdown actions run with the credentials of the server, so only admins assign them, unless given by a template
+		if body.Shutdown != string(device.Shutdown) && body.Shutdown != preset && u != nil && !u.HasRole(auth.RoleAdmin) {
+			return nil, &Error{Status: http.StatusForbidden, Message: "Shutdown actions are assigned by admins"}
+		}
+		before := *newDeviceResource(device)
+		oldMAC := device.MACAddress
+		prev := device
+		if m, _ := normalizeMAC(oldMAC); mac != "" && mac != m {
+			if err := validateTarget(mac); err != nil {
+				return nil, err
+			}
+			if other, ok := i.findMAC(mac); ok && !same(other, device) {
+				return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("MAC address %s belongs to device %s", mac, other.ID)}
+			}
+			device.MACAddress = mac
+		}
+		if device.IPAddress != body.IPAddress {
+			device.LastKnownIP = ""
+		}
+		device.Name, device.IPAddress, device.Groups, device.Notes = body.Name, body.IPAddress, body.Groups, body.Notes
+		device.Prerequisites, device.Essential, device.Watts = body.Prerequisites, body.Essential, body.Watts
+		device.OnOnline, device.Description, device.ProbePorts = body.OnOnline, body.Description, body.ProbePorts
+		device.MACAddresses, device.Platform, device.KeepAwake = macs, body.Platform, body.KeepAwake
+		device.SecureOnPassword, device.WakeAddress, device.WakePort = body.SecureOnPassword, body.WakeAddress, body.WakePort
+		device.Transport, device.WakeInterface, device.Shutdown = body.Transport, body.WakeInterface, power.Preset(body.Shutdown)
+		if err := validateMACAddresses(device); err != nil {
+			return nil, err
+		}
+		for _, m := range macs {
+			if other, ok := i.findMAC(m); ok && !same(other, device) {
+				return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("MAC address %s belongs to device %s", m, other.ID)}
+			}
+		}
+		device.Source = ""
+		res := newDeviceResource(device)
+		if !exists {
+			i.add(device)
+			device, _ = i.findMAC(mac)
+			res = newDeviceResource(device)
+			i.record(device.MACAddress)
+		} else if etag(&before) != etag(res) {
+			i.update(device)
+			if oldMAC != device.MACAddress {
+				i.recordRemoved(oldMAC, device)
+			}
+		}
+		if !exists || etag(&before) != etag(res) {
+			if err := s.writeCache(i, requestActor(r)); err != nil {
+				return nil, storeFailure(err)
+			}
+		}
+		warnLocal(w, prev, device)
+		w.Header().Set("ETag", etag(res))
+		if !exists {
+			w.Header().Set("Location", "/api/v1/devices/"+device.ID)
+			w.WriteHeader(http.StatusCreated)
+		}
+		return res, nil
+	case http.MethodDelete:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		i, err := s.readDevices()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		// Deleting a device that does not exist succeeds, which makes the operation idempotent
+		if device, ok := i.lookup(ref); ok {
+			if !allows(access(u, device), AccessManage) {
+				return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+			}
+			i.remove(device)
+			i.recordRemoved(device.MACAddress, device)
+			if err := s.writeCache(i, requestActor(r)); err != nil {
+				return nil, storeFailure(err)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil, nil
+	}
+	return nil, &Error{
+		Status:  http.StatusMethodNotAllowed,
+		Message: fmt.Sprintf("Invalid method %s, must be %s, %s or %s", r.Method, http.MethodGet, http.MethodPut, http.MethodDelete),
+	}
+}
+
+func groupResource(name string, devices []Device) *GroupResource {
+	g := GroupResource{ID: name, Name: name, Members: make([]string, 0)}
+	for _, d := range devices {
+		for _, group := range d.Groups {
+			if group == name {
+				g.Members = append(g.Members, d.ID)
+				break
+			}
+		}
+	}
+	return &g
+}
+
+// groupResourceHandler manages group membership. Groups exist implicitly, a group without members is empty rather than
+// missing.
+func (s *Server) groupResourceHandler(w http.ResponseWriter, r *http.Request, name string) (interface{}, *Error) {
+	u := userFrom(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		members, smart, err := s.members(r.Context(), u, i, name)
+		if err != nil {
+			return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: fmt.Sprintf("Invalid smart group: %s", name)}
+		}
+		if smart == nil {
+			return groupResource(name, members), nil
+		}
+		g := GroupResource{ID: name, Name: name, Members: make([]string, 0, len(members)), Query: smart.Query}
+		for _, d := range members {
+			g.Members = append(g.Members, d.ID)
+		}
+		return &g, nil
+	case http.MethodPut, http.MethodDelete:
+		var body GroupResource
+		if r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+			}
+		}
+		refs := make([]string, 0, len(body.Members))
+		for _, m := range body.Members {
+			ref, err := deviceRef(m)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, ref)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		i, err := s.readDevices()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		if _, ok := i.smartGroup(name); ok {
+			return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("Members of smart group %s are given by its query", name)}
+		}
+		// Members are referred to by their ID or MAC address, and stored by their ID
+		members := make(map[string]bool, len(refs))
+		for _, ref := range refs {
+			d, ok := i.lookup(ref)
+			if !ok {
+				return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Device not found: %s", ref)}
+			}
+			members[d.ID] = true
+		}
+		var changed []Device
+		for _, d := range i.Devices {
+			var groups []string
+			isMember := false
+			for _, g := range d.Groups {
+				if g == name {
+					isMember = true
+				} else {
+					groups = append(groups, g)
+				}
+			}
+			if isMember == members[d.ID] {
+				continue
+			}
+			if members[d.ID] {
+				groups = append(d.Groups, name)
+			}
+			if !allows(access(u, d), AccessManage) {
+				return nil, &Error{Status: http.StatusForbidden, Message: fmt.Sprintf("Forbidden to change device %s", d.ID)}
+			}
+			d.Groups = groups
+			changed = append(changed, d)
+		}
+		for _, d := range changed {
+			i.update(d)
+		}
+		if len(changed) > 0 {
+			if err := s.writeCache(i, requestActor(r)); err != nil {
+				return nil, storeFailure(err)
+			}
+		}
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return nil, nil
+		}
+		return groupResource(name, visible(u, i.Devices)), nil
+	}
+	return nil, &Error{
+		Status:  http.StatusMethodNotAllowed,
+		Message: fmt.Sprintf("Invalid method %s, must be %s, %s or %s", r.Method, http.MethodGet, http.MethodPut, http.MethodDelete),
+	}
+}
+
+// DeviceResources lists devices in the management API.
+type DeviceResources struct {
+	Devices []*DeviceResource `json:"devices"`
+}
+
+// deviceListHandler handles /api/v1/devices, which lists devices, optionally matching the search of the q parameter,
+// and creates devices without waking them.
+func (s *Server) deviceListHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	switch r.Method {
+	case http.MethodGet:
+		q, qerr := parseSearch(r)
+		if qerr != nil {
+			return nil, qerr
+		}
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		devices := s.search(r.Context(), q, visible(userFrom(r.Context()), i.Devices))
+		res := DeviceResources{Devices: make([]*DeviceResource, 0, len(devices))}
+		for _, d := range devices {
+			res.Devices = append(res.Devices, newDeviceResource(d))
+		}
+		return &res, nil
+	case http.MethodPost:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+		}
+		var body DeviceResource
+		if err := json.Unmarshal(data, &body); err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+		}
+		if body.MACAddress == "" {
+			return nil, &Error{Status: http.StatusBadRequest, Message: "Missing MAC address"}
+		}
+		mac, ok := normalizeMAC(body.MACAddress)
+		if !ok {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid MAC address: %s", body.MACAddress)}
+		}
+		// The device is created as by a PUT of its MAC address, which fails if it exists
+		put := r.WithContext(r.Context())
+		put.Method = http.MethodPut
+		put.Header = r.Header.Clone()
+		put.Header.Del("If-Match")
+		put.Header.Set("If-None-Match", "*")
+		put.Body = ioutil.NopCloser(bytes.NewReader(data))
+		res, rerr := s.deviceHandler(w, put, mac)
+		if rerr != nil && rerr.Status == http.StatusPreconditionFailed {
+			rerr.Status = http.StatusConflict
+		}
+		return res, rerr
+	}
+	return nil, &Error{
+		Status:  http.StatusMethodNotAllowed,
+		Message: fmt.Sprintf("Invalid method %s, must be %s or %s", r.Method, http.MethodGet, http.MethodPost),
+	}
+}
+
+// deviceWakeHandler handles POST /api/v1/devices/{id}/wake, which wakes a stored device without changing it. The body
+// is optional, and holds the same options as a wake through /api/v1/wake, such as wait and timeout.
+func (s *Server) deviceWakeHandler(w http.ResponseWriter, r *http.Request, id string) (interface{}, *Error) {
+	if r.Method != http.MethodPost {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+		}
+	}
+	ref, rerr := deviceRef(id)
+	if rerr != nil {
+		return nil, rerr
+	}
+	var req wakeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		// Stored devices can be woken while the store is unavailable
+		var ok bool
+		if i, ok = s.lastKnown(); !ok {
+			return nil, storeFailure(err)
+		}
+		log.Print(err)
+	}
+	device, ok := i.lookup(ref)
+	if !ok || access(userFrom(r.Context()), device) == "" {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", ref)}
+	}
+	// Devices are woken as stored, so settings of the device in the body are ignored
+	req.Device = Device{MACAddress: device.MACAddress, Name: device.Name}
+	req.Template, req.known = "", true
+	return s.wake(w, r, req, false)
+}
@@ -0,0 +1,185 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mpolden/wakeup/discover"
+)
+
+// discoverySource is the actor recording MAC addresses of devices found by scanning the network.
+const discoverySource = "discovery"
+
+// DiscoveredHost is a live host found on the network by /api/v1/discover.
+type DiscoveredHost struct {
+	IPAddress  string `json:"ipAddress"`
+	MACAddress string `json:"macAddress"`
+	Hostname   string `json:"hostname,omitempty"`
+	Vendor     string `json:"vendor,omitempty"`
+	// Device is the ID of the device having the MAC address of the host, if it has been added.
+	Device string `json:"device,omitempty"`
+}
+
+// Discovery holds the hosts found by scanning the network.
+type Discovery struct {
+	Hosts []DiscoveredHost `json:"hosts"`
+}
+
+// addDiscoveredRequest overrides the name of a device added from a discovered host, which defaults to its hostname.
+type addDiscoveredRequest struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) scan(ctx context.Context, subnets []*net.IPNet) ([]discover.Host, error) {
+	if s.scanFunc != nil {
+		return s.scanFunc(ctx, subnets)
+	}
+	return discover.NewScanner().Scan(ctx, subnets)
+}
+
+// discovered returns the host having mac found by the most recent scan.
+func (s *Server) discovered(mac string) (discover.Host, bool) {
+	s.discoverMu.Lock()
+	defer s.discoverMu.Unlock()
+	h, ok := s.hosts[mac]
+	return h, ok
+}
+
+// discoverHandler handles GET /api/v1/discover, which scans the subnets given by the subnet parameter, or those of the
+// network interfaces of the server, and POST /api/v1/discover/{mac}, which adds a host found by the most recent scan as
+// a device. Users restricted to a scope cannot add devices, and may therefore not scan either.
+func (s *Server) discoverHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	u := userFrom(r.Context())
+	if u != nil && u.Scope != nil {
+		return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+	}
+	mac := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/discover"), "/")
+	if mac == "" {
+		if r.Method != http.MethodGet {
+			return nil, &Error{
+				Status:  http.StatusMethodNotAllowed,
+				Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+			}
+		}
+		return s.discover(r)
+	}
+	if r.Method != http.MethodPost {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+		}
+	}
+	return s.addDiscovered(w, r, mac)
+}
+
+func (s *Server) discover(r *http.Request) (interface{}, *Error) {
+	var subnets []*net.IPNet
+	for _, v := range r.URL.Query()["subnet"] {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil || n.IP.To4() == nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for subnet: %s", v)}
+		}
+		if ones, bits := n.Mask.Size(); 1<<uint(bits-ones) > discover.DefaultMaxHosts {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for subnet: %s, must have at most %d addresses", v, discover.DefaultMaxHosts)}
+		}
+		subnets = append(subnets, n)
+	}
+	hosts, err := s.scan(r.Context(), subnets)
+	if err != nil {
+		return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not scan network", Cause: err.Error()}
+	}
+	s.discoverMu.Lock()
+	s.hosts = make(map[string]discover.Host, len(hosts))
+	for _, h := range hosts {
+		s.hosts[h.MACAddress] = h
+	}
+	s.discoverMu.Unlock()
+	if err := s.discoverAlternates(hosts); err != nil {
+		return nil, storeFailure(err)
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	u := userFrom(r.Context())
+	res := Discovery{Hosts: make([]DiscoveredHost, 0, len(hosts))}
+	for _, h := range hosts {
+		d := DiscoveredHost{IPAddress: h.IPAddress, MACAddress: h.MACAddress, Hostname: h.Hostname, Vendor: h.Vendor}
+		if device, ok := i.findMAC(h.MACAddress); ok && access(u, device) != "" {
+			d.Device = device.ID
+		}
+		res.Hosts = append(res.Hosts, d)
+	}
+	return &res, nil
+}
+
+// discoverAlternates records the MAC address of each discovered host having the hostname of a device, but none of its
+// MAC addresses, as an alternate MAC address of the device.
+func (s *Server) discoverAlternates(hosts []discover.Host) error {
+	devices, err := s.Devices()
+	if err != nil {
+		return err
+	}
+	for _, h := range hosts {
+		if h.Hostname == "" {
+			continue
+		}
+		for _, d := range devices {
+			if !strings.EqualFold(d.Hostname, h.Hostname) || containsMAC(d.macAddresses(), h.MACAddress) {
+				continue
+			}
+			if _, _, err := s.observeAlternate(d.MACAddress, h.MACAddress, h.IPAddress, actor{name: discoverySource}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) addDiscovered(w http.ResponseWriter, r *http.Request, ref string) (interface{}, *Error) {
+	mac, ok := normalizeMAC(ref)
+	if !ok {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid MAC address: %s", ref)}
+	}
+	var body addDiscoveredRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	host, ok := s.discovered(mac)
+	if !ok {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Host not discovered: %s", mac), Hint: "Scan the network with GET /api/v1/discover first"}
+	}
+	device := Device{Name: body.Name, MACAddress: host.MACAddress, IPAddress: host.IPAddress, Hostname: host.Hostname}
+	if device.Name == "" {
+		device.Name = strings.SplitN(host.Hostname, ".", 2)[0]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	if other, ok := i.findMAC(mac); ok {
+		return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("MAC address %s belongs to device %s", mac, other.ID)}
+	}
+	i.add(device)
+	device, _ = i.findMAC(mac)
+	i.record(device.MACAddress)
+	if err := s.writeCache(i, requestActor(r)); err != nil {
+		return nil, storeFailure(err)
+	}
+	warnLocal(w, Device{}, device)
+	res := newDeviceResource(device)
+	w.Header().Set("ETag", etag(res))
+	w.Header().Set("Location", "/api/v1/devices/"+device.ID)
+	w.WriteHeader(http.StatusCreated)
+	return res, nil
+}
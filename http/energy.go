@@ -0,0 +1,118 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mpolden/wakeup/energy"
+)
+
+// Usage is an amount of energy and its cost.
+type Usage struct {
+	KWh  float64 `json:"kWh"`
+	Cost float64 `json:"cost"`
+}
+
+func (u *Usage) add(kWh, price float64) {
+	u.KWh += kWh
+	u.Cost += kWh * price
+}
+
+func (u Usage) rounded() Usage {
+	return Usage{KWh: math.Round(u.KWh*1000) / 1000, Cost: math.Round(u.Cost*100) / 100}
+}
+
+// DeviceEnergy is the estimated energy usage of a device.
+type DeviceEnergy struct {
+	MACAddress string  `json:"macAddress"`
+	Name       string  `json:"name,omitempty"`
+	Watts      float64 `json:"watts"`
+	Uptime     string  `json:"uptime"`
+	Usage
+}
+
+// GroupEnergy is the estimated energy usage of all devices in a group.
+type GroupEnergy struct {
+	Name string `json:"name"`
+	Usage
+}
+
+// EnergyReport is the estimated energy usage of devices since energy tracking started.
+type EnergyReport struct {
+	Since       string         `json:"since"`
+	PricePerKWh float64        `json:"pricePerKWh"`
+	Devices     []DeviceEnergy `json:"devices"`
+	Groups      []GroupEnergy  `json:"groups"`
+	Total       Usage          `json:"total"`
+}
+
+func (s *Server) energyReport(devices []Device, group string) *EnergyReport {
+	report := EnergyReport{
+		Since:       s.Energy.Since().UTC().Format(time.RFC3339),
+		PricePerKWh: s.EnergyPrice,
+		Devices:     make([]DeviceEnergy, 0),
+		Groups:      make([]GroupEnergy, 0),
+	}
+	groups := make(map[string]*Usage)
+	for _, d := range devices {
+		if d.Watts <= 0 {
+			continue
+		}
+		if group != "" && !contains(d.Groups, group) {
+			continue
+		}
+		uptime := s.Energy.Uptime(d.MACAddress)
+		kWh := energy.KWh(d.Watts, uptime)
+		de := DeviceEnergy{MACAddress: d.MACAddress, Name: d.Name, Watts: d.Watts, Uptime: uptime.Round(time.Second).String()}
+		de.add(kWh, s.EnergyPrice)
+		de.Usage = de.rounded()
+		report.Devices = append(report.Devices, de)
+		report.Total.add(kWh, s.EnergyPrice)
+		for _, g := range d.Groups {
+			if group != "" && g != group {
+				continue
+			}
+			if groups[g] == nil {
+				groups[g] = &Usage{}
+			}
+			groups[g].add(kWh, s.EnergyPrice)
+		}
+	}
+	for name, u := range groups {
+		report.Groups = append(report.Groups, GroupEnergy{Name: name, Usage: u.rounded()})
+	}
+	sort.Slice(report.Groups, func(i, j int) bool { return report.Groups[i].Name < report.Groups[j].Name })
+	report.Total = report.Total.rounded()
+	return &report
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) energyHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if s.Energy == nil {
+		return notFoundHandler(w, r)
+	}
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	return s.energyReport(visible(userFrom(r.Context()), i.Devices), r.URL.Query().Get("group")), nil
+}
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// envelope wraps the data or error of an API response. Exactly one of Data and Error is set, but both are always
+// present.
+type envelope struct {
+	Data  interface{} `json:"data"`
+	Error *Error      `json:"error"`
+}
+
+func enveloped(ctx context.Context) bool {
+	v, _ := ctx.Value(envelopeKey).(bool)
+	return v
+}
+
+// wantEnvelope returns whether a client wants an enveloped response, as negotiated by the envelope parameter of a JSON
+// media type in its Accept header, e.g. "application/json; envelope=true". Otherwise def is returned.
+func wantEnvelope(r *http.Request, def bool) bool {
+	for _, accept := range r.Header["Accept"] {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(part)
+			if err != nil {
+				continue
+			}
+			if mediaType != "application/json" && mediaType != "application/*" && mediaType != "*/*" {
+				continue
+			}
+			if v, ok := params["envelope"]; ok {
+				if b, err := strconv.ParseBool(v); err == nil {
+					return b
+				}
+			}
+		}
+	}
+	return def
+}
+
+func (s *Server) envelopeFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		ctx := context.WithValue(r.Context(), envelopeKey, wantEnvelope(r, s.Envelope))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// flat serves responses of next without an envelope, for clients that cannot negotiate one.
+func flat(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), envelopeKey, false)))
+	})
+}
@@ -0,0 +1,155 @@
+package http
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mpolden/wakeup/auth"
+	"github.com/mpolden/wakeup/event"
+)
+
+const atomNS = "http://www.w3.org/2005/Atom"
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	NS      string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	Title    string       `xml:"title"`
+	ID       string       `xml:"id"`
+	Updated  string       `xml:"updated"`
+	Category atomCategory `xml:"category"`
+	Link     *atomLink    `xml:"link,omitempty"`
+	Summary  string       `xml:"summary,omitempty"`
+}
+
+// eventTitle returns the title of the feed entry of e.
+func eventTitle(e event.Event) string {
+	name := e.Name
+	if name == "" {
+		name = e.MACAddress
+	}
+	switch e.Type {
+	case event.Wake:
+		return fmt.Sprintf("Woke %s", name)
+	case event.Online:
+		return fmt.Sprintf("%s is online", name)
+	case event.Offline:
+		return fmt.Sprintf("%s is offline", name)
+	case event.Failed:
+		return fmt.Sprintf("Failed to wake %s", name)
+	case event.Unknown:
+		return fmt.Sprintf("Wake of unknown device %s requested", name)
+	case event.Added:
+		return fmt.Sprintf("Added %s", name)
+	case event.Removed:
+		return fmt.Sprintf("Removed %s", name)
+	}
+	return fmt.Sprintf("%s: %s", name, e.Type)
+}
+
+// eventID returns the ID of e. Events have no identity of their own, but are unique by their type, device and time.
+func eventID(e event.Event) string {
+	return nameID(fmt.Sprintf("%s/%s/%d", e.Type, e.MACAddress, e.Time.UnixNano()))
+}
+
+func newAtomEntry(e event.Event) atomEntry {
+	entry := atomEntry{
+		Title:    eventTitle(e),
+		ID:       "urn:uuid:" + eventID(e),
+		Updated:  e.Time.UTC().Format(time.RFC3339Nano),
+		Category: atomCategory{Term: e.Type},
+		Summary:  e.Error,
+	}
+	if e.Device != "" {
+		entry.Link = &atomLink{Href: "/api/v1/devices/" + e.Device}
+	}
+	return entry
+}
+
+// feedHandler handles GET /api/v1/events.atom, which serves recent events as an Atom feed, newest first. Events can be
+// filtered by type with the type parameter, which can be repeated, and limited in number by the limit parameter. Users
+// only see events of the devices they have access to.
+func (s *Server) feedHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if s.Recent == nil {
+		return notFoundHandler(w, r)
+	}
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	types, limit, e := parseEventFilter(r)
+	if e != nil {
+		return nil, e
+	}
+	var ids map[string]bool
+	if u := userFrom(r.Context()); u != nil && !u.HasRole(auth.RoleAdmin) {
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		ids = make(map[string]bool)
+		for _, d := range visible(u, i.Devices) {
+			ids[d.ID] = true
+		}
+	}
+	feed := atomFeed{
+		NS:     atomNS,
+		Title:  "wakeup events",
+		ID:     "urn:uuid:" + nameID("events"),
+		Author: atomAuthor{Name: "wakeup"},
+		Link:   atomLink{Rel: "self", Href: r.URL.RequestURI()},
+	}
+	// The feed is updated by its newest entry, and an empty feed is always considered updated
+	updated := time.Now()
+	for _, e := range s.Recent.Events() {
+		if len(feed.Entries) == limit {
+			break
+		}
+		if len(types) > 0 && !types[e.Type] {
+			continue
+		}
+		if ids != nil && !ids[e.Device] {
+			continue
+		}
+		if len(feed.Entries) == 0 {
+			updated = e.Time
+		}
+		feed.Entries = append(feed.Entries, newAtomEntry(e))
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339Nano)
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not marshal feed"}
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+	return nil, nil
+}
@@ -0,0 +1,236 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mpolden/wakeup/budget"
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/prereq"
+	"github.com/mpolden/wakeup/script"
+	"github.com/mpolden/wakeup/wol"
+)
+
+// maxStagger is the longest delay between wakes requested by the stagger parameter.
+const maxStagger = time.Minute
+
+// GroupWake describes the wake of all devices in a group, or of a batch of devices, which has no group.
+type GroupWake struct {
+	Group     string        `json:"group,omitempty"`
+	Simulated bool          `json:"simulated"`
+	Wakes     []PlannedWake `json:"wakes"`
+}
+
+// PlannedWake describes the wake of a single device in a group, at the given offset from the start of the group wake.
+type PlannedWake struct {
+	MACAddress string `json:"macAddress"`
+	Name       string `json:"name,omitempty"`
+	Offset     string `json:"offset"`
+	Source     string `json:"source,omitempty"`
+	Interface  string `json:"interface,omitempty"`
+	OverBudget bool   `json:"overBudget,omitempty"`
+	Deferred   bool   `json:"deferred,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Sent holds the MAC addresses magic packets were sent to.
+	Sent     []string `json:"sent,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	offset   time.Duration
+	src      net.IP
+	checks   []prereq.Check
+	device   Device
+}
+
+func (d *deviceCache) group(name string) []Device {
+	var members []Device
+	for _, device := range d.Devices {
+		for _, g := range device.Groups {
+			if g == name {
+				members = append(members, device)
+				break
+			}
+		}
+	}
+	return members
+}
+
+// planGroupWake plans the wake of members, delaying each wake by stagger from the previous one.
+func (s *Server) planGroupWake(group string, members []Device, stagger time.Duration) (*GroupWake, error) {
+	plan := GroupWake{Group: group, Wakes: make([]PlannedWake, 0, len(members))}
+	offsets := make([]time.Duration, 0, len(members))
+	costs := make([]int, 0, len(members))
+	b := s.burst()
+	for i, device := range members {
+		offset := time.Duration(i) * stagger
+		src, err := s.sourceIP(device.address())
+		if err != nil {
+			return nil, err
+		}
+		pw := PlannedWake{
+			MACAddress: device.MACAddress,
+			Name:       device.Name,
+			Offset:     offset.String(),
+			offset:     offset,
+			src:        src,
+			checks:     device.Prerequisites,
+			device:     device,
+		}
+		if src != nil {
+			pw.Source = src.String()
+		}
+		if device.WakeInterface != "" {
+			pw.Interface = device.WakeInterface
+		} else if route, ok := s.Routes.Lookup(net.ParseIP(device.address())); ok {
+			pw.Interface = route.Interface
+		}
+		plan.Wakes = append(plan.Wakes, pw)
+		offsets = append(offsets, offset)
+		costs = append(costs, b.packetsTo(device))
+	}
+	if s.Budget != nil {
+		for i, ok := range s.Budget.Project(offsets, costs) {
+			plan.Wakes[i].OverBudget = !ok
+		}
+	}
+	return &plan, nil
+}
+
+func (s *Server) groupHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/groups/"), "/")
+	if parts[0] == "" {
+		return notFoundHandler(w, r)
+	}
+	switch {
+	case len(parts) == 1:
+		return s.groupResourceHandler(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "wake":
+		return s.groupWakeHandler(w, r, parts[0])
+	}
+	return notFoundHandler(w, r)
+}
+
+func (s *Server) groupWakeHandler(w http.ResponseWriter, r *http.Request, name string) (interface{}, *Error) {
+	if r.Method != http.MethodPost {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+		}
+	}
+	annotate(r.Context(), "group", name)
+	simulate, stagger, e := s.parseGroupWake(r)
+	if e != nil {
+		return nil, e
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	u := userFrom(r.Context())
+	members, smart, err := s.members(r.Context(), u, i, name)
+	if err != nil {
+		return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: fmt.Sprintf("Invalid smart group: %s", name)}
+	}
+	keep := members[:0]
+	for _, d := range members {
+		if allows(access(u, d), AccessWake) {
+			keep = append(keep, d)
+		}
+	}
+	members = keep
+	// A smart group exists even if no device currently matches it
+	if len(members) == 0 && smart == nil {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Group not found: %s", name)}
+	}
+	plan, err := s.planGroupWake(name, members, stagger)
+	if err != nil {
+		return nil, &Error{err: err, Status: wakeStatus(err), Message: "Could not determine source address"}
+	}
+	plan.Simulated = simulate
+	if simulate {
+		return plan, nil
+	}
+	return s.runGroupWake(w, r, plan)
+}
+
+// parseGroupWake parses the simulate and stagger parameters of a group or batch wake. The stagger parameter overrides
+// the delay between wakes of the server.
+func (s *Server) parseGroupWake(r *http.Request) (bool, time.Duration, *Error) {
+	simulate := false
+	if v := r.URL.Query().Get("simulate"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, 0, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for simulate: %s", v)}
+		}
+		simulate = b
+	}
+	stagger := s.Stagger
+	if v := r.URL.Query().Get("stagger"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxStagger {
+			return false, 0, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid stagger: %s, must be between 0s and %s", v, maxStagger)}
+		}
+		stagger = d
+	}
+	return simulate, stagger, nil
+}
+
+// runGroupWake wakes the devices of plan at their planned offsets.
+func (s *Server) runGroupWake(w http.ResponseWriter, r *http.Request, plan *GroupWake) (interface{}, *Error) {
+	start := time.Now()
+	for j := range plan.Wakes {
+		pw := &plan.Wakes[j]
+		select {
+		case <-time.After(pw.offset - time.Since(start)):
+		case <-r.Context().Done():
+			return nil, &Error{Status: http.StatusServiceUnavailable, Message: "Group wake canceled"}
+		}
+		refused, warned := s.checkPrerequisites(r.Context(), pw.checks)
+		for _, f := range warned {
+			pw.Warnings = append(pw.Warnings, warning(f))
+		}
+		if len(refused) > 0 {
+			pw.Error = "Prerequisites failed: " + names(refused)
+			continue
+		}
+		if s.onBattery(pw.device) {
+			if s.UPSPolicy == UPSDefer {
+				s.deferWake(pw.device, pw.src)
+				pw.Deferred = true
+			} else {
+				pw.Error = reasonOnBattery
+			}
+			continue
+		}
+		if err := s.runScript(r.Context(), script.PreWake, pw.device); err != nil {
+			pw.Error = "Pre-wake script failed: " + err.Error()
+			continue
+		}
+		if err := s.checkQuota(w, r); err != nil {
+			pw.Error = err.Message
+			continue
+		}
+		if err := s.cooldown(pw.MACAddress); err != nil {
+			pw.Error = "Wake cooldown has not passed"
+			continue
+		}
+		if !s.allow(r.Context(), budget.PriorityInteractive, s.burst().packetsTo(pw.device)) {
+			pw.Error = "Send budget exceeded"
+			continue
+		}
+		sent, err := s.wakeAll(pw.src, pw.device)
+		if err != nil {
+			s.publishWake(requestActor(r), event.Event{Type: event.Failed, Device: pw.device.ID, MACAddress: pw.MACAddress, Name: pw.Name, Error: err.Error()})
+			pw.Error = wol.Diagnose(err).Hint
+			continue
+		}
+		pw.Sent = sent
+		s.publishWake(requestActor(r), event.Event{Type: event.Wake, Device: pw.device.ID, MACAddress: pw.MACAddress, Name: pw.Name})
+	}
+	return plan, nil
+}
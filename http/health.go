@@ -0,0 +1,81 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/mpolden/wakeup/health"
+)
+
+// DeviceHealth is the health score of a device, telling how reliably it comes online when woken.
+type DeviceHealth struct {
+	ID          string  `json:"id"`
+	MACAddress  string  `json:"macAddress"`
+	Name        string  `json:"name,omitempty"`
+	Score       int     `json:"score"`
+	Trend       string  `json:"trend,omitempty"`
+	Wakes       int     `json:"wakes"`
+	SuccessRate float64 `json:"successRate"`
+	// TimeToOnline is the average time the device takes to come online.
+	TimeToOnline string  `json:"timeToOnline"`
+	Consistency  float64 `json:"consistency"`
+	// Hints suggest fixes when the most recent wakes of the device failed.
+	Hints []health.Hint `json:"hints,omitempty"`
+}
+
+// HealthReport holds the health of devices having completed wakes.
+type HealthReport struct {
+	Devices []DeviceHealth `json:"devices"`
+}
+
+func round2(f float64) float64 { return math.Round(f*100) / 100 }
+
+func (s *Server) deviceHealth(d Device) (DeviceHealth, bool) {
+	score, ok := s.Health.Score(d.MACAddress)
+	if !ok {
+		return DeviceHealth{}, false
+	}
+	return DeviceHealth{
+		ID:           d.ID,
+		MACAddress:   d.MACAddress,
+		Name:         d.Name,
+		Score:        score.Score,
+		Trend:        score.Trend,
+		Wakes:        score.Wakes,
+		SuccessRate:  round2(score.SuccessRate),
+		TimeToOnline: score.TimeToOnline.Round(time.Second).String(),
+		Consistency:  round2(score.Consistency),
+		Hints:        health.Hints(score, d.Platform),
+	}, true
+}
+
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if s.Health == nil {
+		return notFoundHandler(w, r)
+	}
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	q, qerr := parseSearch(r)
+	if qerr != nil {
+		return nil, qerr
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	report := HealthReport{Devices: make([]DeviceHealth, 0)}
+	for _, d := range s.search(r.Context(), q, visible(userFrom(r.Context()), i.Devices)) {
+		if h, ok := s.deviceHealth(d); ok {
+			report.Devices = append(report.Devices, h)
+		}
+	}
+	return &report, nil
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/history"
+	"github.com/mpolden/wakeup/wait"
+)
+
+const (
+	// maxHookAttempts is the number of times a hook is called before giving up.
+	maxHookAttempts = 3
+	hookTimeout     = 10 * time.Second
+)
+
+func validateHook(hook string) *Error {
+	if hook == "" {
+		return nil
+	}
+	u, err := url.Parse(hook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid onOnline URL: %s", hook)}
+	}
+	return nil
+}
+
+func postHook(ctx context.Context, hook string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, hook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		// The error of the client holds the URL, which is redacted by the caller
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("got status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// redact returns the scheme and host of hook, as its path and query may hold credentials.
+func redact(hook string) string {
+	u, err := url.Parse(hook)
+	if err != nil || u.Host == "" {
+		return "<invalid>"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// callHook posts event e to hook, retrying with exponential backoff if it fails. The result is recorded in the history,
+// which only holds the host of hook.
+func (s *Server) callHook(hook string, e event.Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	attempts := 0
+	for attempts < maxHookAttempts {
+		if attempts > 0 {
+			time.Sleep(s.hookDelay << uint(attempts-1))
+		}
+		attempts++
+		if err = postHook(context.Background(), hook, body); err == nil {
+			break
+		}
+	}
+	entry := history.Entry{Action: history.HookCalled, Device: e.Device, MACAddress: e.MACAddress, Result: history.ResultOK}
+	if err != nil {
+		entry.Result = history.ResultFailed
+		entry.Message = fmt.Sprintf("POST %s failed after %d attempts: %s", redact(hook), attempts, err)
+		log.Print(entry.Message)
+	} else {
+		entry.Message = fmt.Sprintf("POST %s succeeded after %d attempts", redact(hook), attempts)
+	}
+	if s.History != nil {
+		if err := s.History.Append(entry); err != nil {
+			log.Printf("could not record history: %s", err)
+		}
+	}
+}
+
+// waitForHook waits in the background for device to come online, and calls hook once it is.
+func (s *Server) waitForHook(hook string, probes []wait.Probe, device Device) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultWaitTimeout)
+	defer cancel()
+	if s.waitFunc(ctx, probes).Status != wait.StatusOnline {
+		log.Printf("Not calling onOnline hook of device with address %s: device did not come online", device.MACAddress)
+		return
+	}
+	s.callHook(hook, event.Event{Type: event.Online, Device: device.ID, MACAddress: device.MACAddress, Name: device.Name})
+}
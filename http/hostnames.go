@@ -0,0 +1,59 @@
+package http
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// dnsSource is the actor recording hostnames resolved from the IP addresses of devices.
+const dnsSource = "dns"
+
+// lookupTimeout is how long a reverse lookup of a single device may take.
+const lookupTimeout = 5 * time.Second
+
+// RefreshHostnames resolves the hostnames of devices having a known IP address at every interval.
+func (s *Server) RefreshHostnames(interval time.Duration) {
+	for {
+		if err := s.refreshHostnames(); err != nil {
+			log.Printf("could not refresh hostnames: %s", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (s *Server) refreshHostnames() error {
+	devices, err := s.Devices()
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		ip := d.address()
+		if ip == "" {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		names, err := s.lookupAddr(ctx, ip)
+		cancel()
+		if err != nil || len(names) == 0 {
+			// Keep the previous hostname, as the lookup may fail temporarily
+			continue
+		}
+		hostname := strings.TrimSuffix(names[0], ".")
+		_, changed, err := s.updateDevice(d.MACAddress, actor{name: dnsSource}, func(d *Device) bool {
+			if d.Hostname == hostname {
+				return false
+			}
+			d.Hostname = hostname
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if changed {
+			log.Printf("Device with address %s resolved to %s", d.MACAddress, hostname)
+		}
+	}
+	return nil
+}
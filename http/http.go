@@ -1,28 +1,194 @@
 package http
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
-	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
+	"github.com/mpolden/wakeup/apikey"
+	"github.com/mpolden/wakeup/auth"
+	"github.com/mpolden/wakeup/ban"
+	"github.com/mpolden/wakeup/budget"
+	"github.com/mpolden/wakeup/discover"
+	"github.com/mpolden/wakeup/energy"
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/health"
+	"github.com/mpolden/wakeup/history"
+	"github.com/mpolden/wakeup/inventory"
+	"github.com/mpolden/wakeup/logging"
+	"github.com/mpolden/wakeup/neigh"
+	"github.com/mpolden/wakeup/power"
+	"github.com/mpolden/wakeup/prereq"
+	"github.com/mpolden/wakeup/quota"
+	"github.com/mpolden/wakeup/ratelimit"
+	"github.com/mpolden/wakeup/schedule"
+	"github.com/mpolden/wakeup/script"
+	"github.com/mpolden/wakeup/trace"
+	"github.com/mpolden/wakeup/ups"
+	"github.com/mpolden/wakeup/wait"
 	"github.com/mpolden/wakeup/wol"
 )
 
-type wakeFunc func(net.IP, net.HardwareAddr) error
+type wakeFunc func(net.HardwareAddr, wol.Options) error
 
 type Server struct {
-	SourceIP  net.IP
-	StaticDir string
-	cacheFile string
-	mu        sync.RWMutex
+	SourceIP net.IP
+	Routes   wol.Routes
+	Budget   *budget.Budget
+	// BudgetWait is how long an interactive wake waits for the send budget before it is rejected. Waiting wakes are
+	// sent in order of priority.
+	BudgetWait time.Duration
+	Events     *event.Bus
+	AdminToken string
+	// Logger logs each request, together with its ID and outcome, if set.
+	Logger *logging.Logger
+	// Auth authenticates users by basic auth, and clients by bearer token if it is an auth.TokenAuthenticator. All
+	// requests are authenticated if set.
+	Auth auth.Authenticator
+	// RemoteAllowUnknown allows wakes through SSH, signed email and MQTT to wake MAC addresses that are not stored.
+	RemoteAllowUnknown bool
+	// Keys are the API keys scoped to a subset of devices, which are accepted as bearer tokens if set. Once a key
+	// exists, requests must be authenticated even if Auth is unset.
+	Keys *apikey.Store
+	// AnonymousRead allows GET requests without credentials, except to admin endpoints.
+	AnonymousRead bool
+	// NetBoxSecret is the secret used to verify NetBox webhooks. The webhook endpoint is disabled if empty.
+	NetBoxSecret string
+	// HookKey is the key authenticating the trigger and polling endpoints used by no-code automations, such as IFTTT
+	// and Zapier. These endpoints are disabled if empty.
+	HookKey string
+	// TOTPKey is the key from which the TOTP secrets of public wake pages are derived. Public wake pages are disabled
+	// if empty.
+	TOTPKey string
+	// InternalAddr is the address serving metrics, health, pprof and admin endpoints. If empty, these are served together
+	// with the public API, except pprof which is only served on an internal address.
+	InternalAddr string
+	// ConfigMap is the directory of declarative device definitions reported by /statusz, if any.
+	ConfigMap *inventory.ConfigMap
+	// UPS is the UPS powering devices, if any. While it is on battery, wakes of non-essential devices are handled
+	// according to UPSPolicy.
+	UPS       *ups.Monitor
+	UPSPolicy string
+	// Energy tracks the uptime of devices, from which their energy usage is estimated using their wattage and
+	// EnergyPrice per kWh.
+	Energy      *energy.Tracker
+	EnergyPrice float64
+	// Relay receives the magic packets relayed by RunRelay, if set.
+	Relay *wol.Bridge
+	// Recent retains the events served by the event feed, which is disabled if nil.
+	Recent *event.Recorder
+	// Health scores how reliably devices come online when woken.
+	Health *health.Tracker
+	// Quotas limits the number of wakes each user or client can make.
+	Quotas *quota.Quotas
+	// Limiter limits the rate of requests to the API and public wake pages made by each client address, if set.
+	Limiter *ratelimit.Limiter
+	// WakeCooldown is the time a device cannot be woken again after a wake was attempted, if positive.
+	WakeCooldown time.Duration
+	// Bans bans clients making suspicious requests, if set.
+	Bans *ban.List
+	// TrapPaths are paths that ban clients requesting them. Paths ending in a slash match all paths below them.
+	TrapPaths []string
+	// History records changes made to the inventory, if set.
+	History *history.Log
+	// Trace records the results of probing devices and the events published, if set.
+	Trace *trace.Writer
+	// Scripts are run before devices are woken and after they come online or go offline, if set.
+	Scripts *script.Hooks
+	// Scheduler runs schedules, whose planned wakes are reported by /statusz.
+	Scheduler *schedule.Scheduler
+	// Power powers off devices having a shutdown action, if set.
+	Power *power.Controller
+	// Clock tells the current time, from which schedules are simulated. Defaults to the system clock.
+	Clock schedule.Clock
+	// Stagger is the delay between each wake when waking several devices, such as a group.
+	Stagger time.Duration
+	// SkipIfOnline skips wakes of devices that are already online, unless overridden by the request or schedule.
+	SkipIfOnline bool
+	// VerifyMAC verifies that the MAC address of an online device, as found in the ARP table or neighbour cache,
+	// matches the stored MAC address. A device whose IP address is used by another host is reported as a conflict.
+	VerifyMAC bool
+	// Ping pings devices, in addition to probing their TCP ports, when determining whether they are online.
+	Ping bool
+	// MonitorInterval is the interval at which the monitor probes devices. Statuses are cached for twice the interval.
+	MonitorInterval time.Duration
+	// Interface is the network interface magic packets are sent on, unless configured by the device or sent from the
+	// source address of a route.
+	Interface string
+	// Transport is how magic packets are sent to devices not configuring a transport. Defaults to wol.TransportUDP.
+	Transport string
+	// Packets is the number of magic packets sent to each MAC address of a device, PacketInterval the delay between
+	// them, and Retries the number of times they are sent again if sending fails. Requests may override these.
+	Packets        int
+	PacketInterval time.Duration
+	Retries        int
+	// Envelope wraps API responses in an envelope holding their data or error, unless a client negotiates otherwise.
+	Envelope bool
+	// Templates are the templates devices can be created from.
+	Templates []Template
+	// TLSConfig configures HTTPS, which is served on all listen addresses but the internal address if set.
+	TLSConfig *tls.Config
+	// RedirectAddr is the address redirecting HTTP requests to HTTPS, if set. ACMEHandler, if set, handles requests
+	// there before they are redirected, answering the challenges of an ACME CA validating the host.
+	RedirectAddr string
+	ACMEHandler  func(http.Handler) http.Handler
+	// ReadTimeout, WriteTimeout and IdleTimeout bound the time reading a request, writing its response and keeping an
+	// idle connection open. A zero timeout is no timeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// OUIFile is where the registry of MAC address prefixes is stored when refreshed through /api/v1/admin/oui.
+	OUIFile       string
+	StaticDir     string
+	cacheFile     string
+	store         store
+	mu            sync.RWMutex
+	waitFunc      func(context.Context, []wait.Probe) wait.Result
+	checkFunc     func(context.Context, []prereq.Check) []prereq.Result
+	hookDelay     time.Duration
+	neighFunc     func() (neigh.Table, error)
+	lookupAddr    func(context.Context, string) ([]string, error)
+	scanFunc      func(context.Context, []*net.IPNet) ([]discover.Host, error)
+	registryURLs  []string
+	discoverMu    sync.Mutex
+	hosts         map[string]discover.Host
+	storeMu       sync.Mutex
+	storeErr      error
+	storeErrSince time.Time
+	last          *deviceCache
+	deferMu       sync.Mutex
+	deferred      []DeferredWake
+	codeMu        sync.Mutex
+	usedCodes     map[string]int64
+	codeFailures  map[string][]time.Time
+	wakingMu      sync.Mutex
+	statusMu      sync.Mutex
+	statuses      map[string]probeResult
+	probing       map[string]chan bool
+	waking        map[string]time.Time
+	keepAwakeMu   sync.Mutex
+	keepAwake     map[string]*keepAwake
+	hookMu        sync.Mutex
+	hookTriggers  map[string]hookResult
+	listeners     []net.Listener
+	internal      net.Listener
+	redirect      net.Listener
+	serveMu       sync.Mutex
+	servers       []*http.Server
+	closed        bool
+	cooldownMu    sync.Mutex
+	attempted     map[string]time.Time
 	wakeFunc
 }
 
@@ -30,121 +196,554 @@ type Error struct {
 	err     error
 	Status  int    `json:"status"`
 	Message string `json:"message"`
+	Cause   string `json:"cause,omitempty"`
+	Hint    string `json:"hint,omitempty"`
+	// Prerequisites contains the prerequisites that failed, if a wake was refused because of them.
+	Prerequisites []prereq.Result `json:"prerequisites,omitempty"`
 }
 
+func (e *Error) Error() string { return e.Message }
+
+// Unwrap returns the cause of e, if any, which is logged but not part of the response.
+func (e *Error) Unwrap() error { return e.err }
+
 type Devices struct {
 	Devices []Device `json:"devices"`
 }
 
-type Device struct {
-	Name       string `json:"name,omitempty"`
+type wakeRequest struct {
+	Device
+	Wait    bool   `json:"wait,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+	// Resend is the interval at which the wake is resent while waiting for the device to come online, if set.
+	Resend string `json:"resend,omitempty"`
+	// Ping also waits for the device to respond to ICMP echo requests, even if Server.Ping is not set.
+	Ping bool `json:"ping,omitempty"`
+	// SkipIfOnline overrides Server.SkipIfOnline, if set.
+	SkipIfOnline *bool `json:"skipIfOnline,omitempty"`
+	// Template is the name of the template a new device is created from, if any.
+	Template string `json:"template,omitempty"`
+	// Packets, PacketInterval and Retries override those of the server, if set.
+	Packets        int    `json:"packets,omitempty"`
+	PacketInterval string `json:"packetInterval,omitempty"`
+	Retries        *int   `json:"retries,omitempty"`
+	// known is true if the device must already be stored, in which case it is woken as stored rather than created.
+	known bool
+}
+
+// WaitResult is the outcome of waiting for a device to come online after waking it.
+type WaitResult struct {
+	Status   string   `json:"status"`
+	Waited   string   `json:"waited"`
+	Attempts int      `json:"attempts"`
+	Resends  int      `json:"resends,omitempty"`
+	Probes   []string `json:"probes"`
+}
+
+const (
+	defaultWaitTimeout = time.Minute
+	maxWaitTimeout     = 10 * time.Minute
+	minResendInterval  = time.Second
+)
+
+// maxChanges is the number of device changes retained in the cache for delta sync.
+const maxChanges = 1000
+
+type change struct {
+	Revision   int64  `json:"revision"`
 	MACAddress string `json:"macAddress"`
+	// ID and Sharing are those of the device which no longer has the MAC address, deciding who is told of its removal.
+	ID string `json:"id,omitempty"`
+	Sharing
+}
+
+type deviceCache struct {
+	Devices  []Device `json:"devices"`
+	Revision int64    `json:"revision,omitempty"`
+	Changes  []change `json:"changes,omitempty"`
+	// SmartGroups are the saved searches which behave as groups.
+	SmartGroups []SmartGroup `json:"smartGroups,omitempty"`
+	// Schedules are the wake schedules of devices and groups.
+	Schedules []Schedule `json:"schedules,omitempty"`
+}
+
+// Sync holds the devices added, changed or removed since a given revision. If Reset is true, the client revision was
+// too old (or unknown) and Devices holds the full inventory.
+type Sync struct {
+	Revision int64    `json:"revision"`
+	Reset    bool     `json:"reset"`
+	Devices  []Device `json:"devices"`
+	Removed  []string `json:"removed"`
+}
+
+type Device struct {
+	// ID identifies the device, while its MAC address may change, e.g. when its network card is replaced.
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// Description is a short, plain text description of the device, such as its location or purpose.
+	Description string `json:"description,omitempty"`
+	MACAddress  string `json:"macAddress"`
+	// MACAddresses holds additional MAC addresses of the device, e.g. of a wireless interface, which are woken after
+	// MACAddress in the given order.
+	MACAddresses []string `json:"macAddresses,omitempty"`
+	IPAddress    string   `json:"ipAddress,omitempty"`
+	// LastKnownIP is the IP address the device was last observed at, if it differs from IPAddress. It is kept up to
+	// date from the ARP table and agent reports, so that the device can be probed when DHCP hands out a new address.
+	LastKnownIP string `json:"lastKnownIP,omitempty"`
+	// Hostname is the name the IP address of the device resolves to, which names the device if it has no name.
+	Hostname string `json:"hostname,omitempty"`
+	// Alternates are MAC addresses the device has been observed using instead of its own.
+	Alternates []Alternate `json:"alternates,omitempty"`
+	Groups     []string    `json:"groups,omitempty"`
+	Source     string      `json:"source,omitempty"`
+	// Notes is free-form Markdown, returned as is. Clients are responsible for rendering it safely.
+	Notes         string         `json:"notes,omitempty"`
+	Prerequisites []prereq.Check `json:"prerequisites,omitempty"`
+	// Essential devices are woken even if the UPS is on battery.
+	Essential bool `json:"essential,omitempty"`
+	// Watts is the nominal power draw of the device while it is online.
+	Watts float64 `json:"watts,omitempty"`
+	// OnOnline is a URL called once the device is confirmed online after being woken.
+	OnOnline string `json:"onOnline,omitempty"`
+	// ProbePorts are the TCP ports probed to determine whether the device is online. Default ports are probed if empty.
+	ProbePorts []int `json:"probePorts,omitempty"`
+	// Platform is the operating system of the device, one of windows, linux or macos, used to suggest fixes when it
+	// fails to come online after being woken.
+	Platform string `json:"platform,omitempty"`
+	// KeepAwake is the daily window during which the device is woken whenever it goes offline, if any.
+	KeepAwake *KeepAwake `json:"keepAwake,omitempty"`
+	// Shutdown is the action the device is powered off by, if it can be.
+	Shutdown power.Preset `json:"shutdown,omitempty"`
+	// SecureOnPassword is sent with magic packets to devices whose network card requires a SecureOn password, e.g.
+	// 01:23:45:67:89:ab. It only protects against wakes by hosts not knowing it, so it is shown to anyone who can see
+	// the device.
+	SecureOnPassword string `json:"secureOnPassword,omitempty"`
+	// WakeAddress is the address magic packets are sent to, either an IP address or a subnet whose directed broadcast
+	// address is used, and WakePort the UDP port. An IPv6 address may name the interface to send on, e.g. ff02::1%eth0.
+	// Packets are broadcast on the local network to port 9 by default, or multicast to ff02::1 from an IPv6 source.
+	WakeAddress string `json:"wakeAddress,omitempty"`
+	WakePort    int    `json:"wakePort,omitempty"`
+	// Transport is how magic packets are sent, either udp or ethernet for devices only woken by raw Ethernet frames.
+	// Server.Transport applies if empty. WakeInterface is the network interface packets are sent on, if set.
+	Transport     string `json:"transport,omitempty"`
+	WakeInterface string `json:"wakeInterface,omitempty"`
+	// PublicWake is the generation of the TOTP secret protecting the public wake page of the device. The page is
+	// disabled if zero.
+	PublicWake int `json:"publicWake,omitempty"`
+	Sharing
+}
+
+// maxNotesSize is the maximum size of device notes, in bytes.
+const maxNotesSize = 4096
+
+// maxDescriptionSize is the maximum size of a device description, in bytes.
+const maxDescriptionSize = 256
+
+func validateDescription(description string) *Error {
+	if len(description) > maxDescriptionSize {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Description exceeds %d bytes", maxDescriptionSize)}
+	}
+	if !utf8.ValidString(description) || strings.ContainsAny(description, "\r\n") {
+		return &Error{Status: http.StatusBadRequest, Message: "Description must be a single line of valid UTF-8"}
+	}
+	return nil
+}
+
+func validateProbePorts(ports []int) *Error {
+	for _, p := range ports {
+		if p < 1 || p > 65535 {
+			return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid probe port: %d", p)}
+		}
+	}
+	return nil
 }
 
-func (d *Devices) add(device Device) {
+func validatePlatform(platform string) *Error {
+	switch platform {
+	case "", health.PlatformWindows, health.PlatformLinux, health.PlatformMacOS:
+		return nil
+	}
+	return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid platform %q, must be %s, %s or %s", platform, health.PlatformWindows, health.PlatformLinux, health.PlatformMacOS)}
+}
+
+func validateSecureOnPassword(password string) *Error {
+	if password == "" {
+		return nil
+	}
+	if _, err := wol.ParsePassword(password); err != nil {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid SecureOn password: %s", password)}
+	}
+	return nil
+}
+
+func validateWakeAddress(address string, port int) *Error {
+	if address != "" {
+		if _, _, err := wol.ParseDestination(address); err != nil {
+			return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid wake address: %s", address)}
+		}
+	}
+	if port < 0 || port > 65535 {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid wake port: %d", port)}
+	}
+	return nil
+}
+
+// maxInterfaceLen is the maximum length of a network interface name.
+const maxInterfaceLen = 15
+
+func validateTransport(transport, iface string) *Error {
+	if transport != "" {
+		if _, err := wol.ParseTransport(transport); err != nil {
+			return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid transport %q, must be %s or %s", transport, wol.TransportUDP, wol.TransportEthernet)}
+		}
+	}
+	if len(iface) > maxInterfaceLen || strings.ContainsAny(iface, "/% \t\r\n") {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid wake interface: %s", iface)}
+	}
+	return nil
+}
+
+func validateNotes(notes string) *Error {
+	if len(notes) > maxNotesSize {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Notes exceed %d bytes", maxNotesSize)}
+	}
+	if !utf8.ValidString(notes) {
+		return &Error{Status: http.StatusBadRequest, Message: "Notes must be valid UTF-8"}
+	}
+	return nil
+}
+
+func (d *deviceCache) add(device Device) bool {
 	for _, v := range d.Devices {
-		if device.MACAddress == v.MACAddress {
-			return
+		if device.MACAddress == v.MACAddress || (device.ID != "" && same(device, v)) {
+			return false
 		}
 	}
+	if device.ID == "" {
+		device.ID = d.newID(device.MACAddress)
+	}
 	d.Devices = append(d.Devices, device)
+	return true
 }
 
-func (d *Devices) remove(device Device) {
+func (d *deviceCache) remove(device Device) bool {
 	var keep []Device
 	for _, v := range d.Devices {
-		if device.MACAddress == v.MACAddress {
+		if same(device, v) {
 			continue
 		}
 		keep = append(keep, v)
 	}
+	removed := len(keep) != len(d.Devices)
 	d.Devices = keep
+	return removed
+}
+
+// update replaces the stored device which is the same as device.
+func (d *deviceCache) update(device Device) bool {
+	for j, v := range d.Devices {
+		if same(device, v) {
+			d.Devices[j] = device
+			d.record(device.MACAddress)
+			return true
+		}
+	}
+	return false
+}
+
+func (d *deviceCache) find(macAddress string) (Device, bool) {
+	for _, v := range d.Devices {
+		if v.MACAddress == macAddress {
+			return v, true
+		}
+	}
+	return Device{}, false
+}
+
+func (c *deviceCache) record(macAddress string) {
+	c.append(change{MACAddress: macAddress})
+}
+
+// recordRemoved records that macAddress no longer belongs to device, which was removed or given another MAC address.
+func (c *deviceCache) recordRemoved(macAddress string, device Device) {
+	c.append(change{MACAddress: macAddress, ID: device.ID, Sharing: device.Sharing})
+}
+
+func (c *deviceCache) append(ch change) {
+	c.Revision++
+	ch.Revision = c.Revision
+	c.Changes = append(c.Changes, ch)
+	if n := len(c.Changes); n > maxChanges {
+		c.Changes = c.Changes[n-maxChanges:]
+	}
+}
+
+// since returns the changes after revision to devices visible to u.
+func (c *deviceCache) since(u *auth.User, revision int64) Sync {
+	sync := Sync{Revision: c.Revision, Removed: make([]string, 0)}
+	oldest := c.Revision
+	if len(c.Changes) > 0 {
+		oldest = c.Changes[0].Revision - 1
+	}
+	if revision <= 0 || revision < oldest || revision > c.Revision {
+		sync.Reset = true
+		sync.Devices = visible(u, c.Devices)
+		return sync
+	}
+	sync.Devices = make([]Device, 0)
+	seen := make(map[string]bool)
+	for _, ch := range c.Changes {
+		if ch.Revision <= revision || seen[ch.MACAddress] {
+			continue
+		}
+		seen[ch.MACAddress] = true
+		if device, ok := c.find(ch.MACAddress); ok {
+			if access(u, device) != "" {
+				sync.Devices = append(sync.Devices, device)
+			}
+		} else if access(u, Device{ID: ch.ID, Sharing: ch.Sharing}) != "" {
+			sync.Removed = append(sync.Removed, ch.MACAddress)
+		}
+	}
+	sort.Slice(sync.Devices, func(i, j int) bool { return sync.Devices[i].MACAddress < sync.Devices[j].MACAddress })
+	sort.Strings(sync.Removed)
+	return sync
 }
 
-func New(cacheFile string) *Server { return &Server{cacheFile: cacheFile, wakeFunc: wol.Wake} }
+func New(cacheFile string) *Server {
+	return &Server{cacheFile: cacheFile, wakeFunc: wol.WakeWith, waitFunc: wait.New(time.Second).Wait,
+		checkFunc: prereq.New(5 * time.Second).Run, hookDelay: time.Second, neighFunc: neigh.Read, lookupAddr: net.DefaultResolver.LookupAddr, Events: event.NewBus()}
+}
 
-func (s *Server) readDevices() (*Devices, error) {
-	f, err := os.OpenFile(s.cacheFile, os.O_CREATE|os.O_RDONLY, 0644)
+// Devices returns the stored devices.
+func (s *Server) Devices() ([]Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, err := s.readDevices()
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	data, err := ioutil.ReadAll(f)
+	return i.Devices, nil
+}
+
+func (s *Server) readDevices() (*deviceCache, error) {
+	i, err := s.loadDevices()
+	s.storeResult(i, err)
+	return i, unavailable(err)
+}
+
+// decodeCache decodes the cache file data into c. Besides the current format, the legacy format of a list of devices
+// or MAC addresses is accepted. It is converted to the current format when the cache is next written.
+func decodeCache(data []byte, c *deviceCache) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '[' {
+		return json.Unmarshal(data, c)
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		var d Device
+		var mac string
+		if err := json.Unmarshal(e, &mac); err == nil {
+			d.MACAddress = mac
+		} else if err := json.Unmarshal(e, &d); err != nil {
+			return err
+		}
+		if m, ok := normalizeMAC(d.MACAddress); ok {
+			d.MACAddress = m
+		}
+		c.Devices = append(c.Devices, d)
+	}
+	return nil
+}
+
+func (s *Server) loadDevices() (*deviceCache, error) {
+	i, err := s.backend().load()
 	if err != nil {
 		return nil, err
 	}
-	var i Devices
-	if len(data) == 0 {
-		i.Devices = make([]Device, 0)
-		return &i, nil
-	}
-	if err := json.Unmarshal(data, &i); err != nil {
+	sort.Slice(i.Devices, func(j, k int) bool { return i.Devices[j].MACAddress < i.Devices[k].MACAddress })
+	return i, nil
+}
+
+// load reads the cache file, and replays the journal on top of it.
+func (f fileStore) load() (*deviceCache, error) {
+	i, err := f.read()
+	if err != nil {
 		return nil, err
 	}
 	if i.Devices == nil {
 		i.Devices = make([]Device, 0)
 	}
-	sort.Slice(i.Devices, func(j, k int) bool { return i.Devices[j].MACAddress < i.Devices[k].MACAddress })
+	byMAC := func(j, k int) bool { return i.Devices[j].MACAddress < i.Devices[k].MACAddress }
+	// Devices stored before devices had IDs are identified by an ID derived from their MAC address
+	sort.Slice(i.Devices, byMAC)
+	i.assignIDs()
+	if err := f.replay(&i); err != nil {
+		return nil, err
+	}
 	return &i, nil
 }
 
-func (s *Server) writeDevice(device Device, add bool) error {
+func (s *Server) writeDevice(device Device, add bool, a actor) error {
 	i, err := s.readDevices()
 	if err != nil {
 		return err
 	}
-	f, err := os.OpenFile(s.cacheFile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
 	if add {
-		i.add(device)
+		if i.add(device) {
+			i.record(device.MACAddress)
+		}
 	} else {
-		i.remove(device)
+		stored, _ := i.find(device.MACAddress)
+		if i.remove(device) {
+			i.recordRemoved(device.MACAddress, stored)
+		}
 	}
-	enc := json.NewEncoder(f)
-	if err := enc.Encode(i); err != nil && err != io.EOF {
+	return s.writeCache(i, a)
+}
+
+func (s *Server) writeCache(i *deviceCache, a actor) error {
+	prev, err := s.loadDevices()
+	if err == nil {
+		err = s.backend().save(prev, i)
+	}
+	s.storeResult(i, err)
+	if err == nil {
+		s.audit(a, prev, i)
+	}
+	return unavailable(err)
+}
+
+// store writes i to the cache file.
+func (f fileStore) store(i *deviceCache) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(i); err != nil {
 		return err
 	}
-	return nil
+	return f.write(buf.Bytes())
+}
+
+// ipAddress returns the IP address of device, either from the device itself or from the cache.
+func (s *Server) ipAddress(device Device) (string, error) {
+	if device.IPAddress != "" {
+		return device.IPAddress, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, err := s.readDevices()
+	if err != nil {
+		// Fall back to the last known devices, so that devices can still be woken while the store is unavailable
+		var ok bool
+		if i, ok = s.lastKnown(); !ok {
+			return "", nil
+		}
+	}
+	if d, ok := i.find(device.MACAddress); ok {
+		return d.address(), nil
+	}
+	return "", nil
+}
+
+// sourceIP returns the source address to use when waking a device with the given IP address. If the address is known
+// the most specific route matching that address is used. Otherwise the default source is returned.
+func (s *Server) sourceIP(ipAddress string) (net.IP, error) {
+	if ip := net.ParseIP(ipAddress); ip != nil {
+		src, err := s.Routes.Source(ip)
+		if err != nil {
+			return nil, err
+		}
+		if src != nil {
+			return src, nil
+		}
+	}
+	return s.SourceIP, nil
+}
+
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultWaitTimeout, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 || d > maxWaitTimeout {
+		return 0, fmt.Errorf("timeout must be between 0 and %s", maxWaitTimeout)
+	}
+	return d, nil
+}
+
+func parseResend(s string, timeout time.Duration) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < minResendInterval || d >= timeout {
+		return 0, fmt.Errorf("resend interval must be at least %s and less than the timeout", minResendInterval)
+	}
+	return d, nil
+}
+
+func newWaitResult(r wait.Result) *WaitResult {
+	probes := make([]string, 0, len(r.Probes))
+	for _, p := range r.Probes {
+		probes = append(probes, p.String())
+	}
+	return &WaitResult{
+		Status:   r.Status,
+		Waited:   r.Waited.Round(time.Millisecond).String(),
+		Attempts: r.Attempts,
+		Resends:  r.Resends,
+		Probes:   probes,
+	}
 }
 
 func (s *Server) defaultHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
 	defer r.Body.Close()
 	if r.Method == http.MethodGet {
+		q, qerr := parseSearch(r)
+		if qerr != nil {
+			return nil, qerr
+		}
 		s.mu.RLock()
-		defer s.mu.RUnlock()
 		i, err := s.readDevices()
 		if err != nil {
-			return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not unmarshal JSON"}
+			var ok bool
+			if i, ok = s.lastKnown(); !ok {
+				s.mu.RUnlock()
+				return nil, storeFailure(err)
+			}
+			log.Print(err)
+			w.Header().Set("Warning", staleWarning)
 		}
-		return i, nil
+		s.mu.RUnlock()
+		// Devices are searched without holding the lock, as their state may be probed
+		return &Devices{Devices: s.search(r.Context(), q, visible(userFrom(r.Context()), i.Devices))}, nil
 	}
 	add := r.Method == http.MethodPost
 	remove := r.Method == http.MethodDelete
 	if add || remove {
-		dec := json.NewDecoder(r.Body)
-		var device Device
-		if err := dec.Decode(&device); err != nil {
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
 		}
-		if add {
-			macAddress, err := net.ParseMAC(device.MACAddress)
-			if err != nil {
-				return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid MAC address: %s", device.MACAddress)}
-			}
-			if err := s.wakeFunc(s.SourceIP, macAddress); err != nil {
-				return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Failed to wake device with address %s", device.MACAddress)}
+		if add && body[0] == '[' {
+			var macs []string
+			if err := json.Unmarshal(body, &macs); err != nil {
+				return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
 			}
+			return s.batchWake(w, r, macs)
 		}
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		if err := s.writeDevice(device, add); err != nil {
-			return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not unmarshal JSON"}
+		var req wakeRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
 		}
-		w.WriteHeader(http.StatusNoContent)
-		return nil, nil
+		return s.wake(w, r, req, remove)
 	}
 	return nil, &Error{
 		Status:  http.StatusMethodNotAllowed,
@@ -152,6 +751,336 @@ func (s *Server) defaultHandler(w http.ResponseWriter, r *http.Request) (interfa
 	}
 }
 
+// wake wakes the device of req, and stores it if it is new, or removes it if remove is true.
+func (s *Server) wake(w http.ResponseWriter, r *http.Request, req wakeRequest, remove bool) (interface{}, *Error) {
+	add := !remove
+	save := !req.known
+	device := req.Device
+	// Public wake pages, shutdown actions and prerequisites are only configured through the management API, while
+	// alternate MAC addresses are only observed
+	device.PublicWake = 0
+	device.Shutdown, device.Alternates, device.Prerequisites = "", nil, nil
+	user := userFrom(r.Context())
+	annotate(r.Context(), "mac", device.MACAddress)
+	stored, exists, err := s.findDevice(device.MACAddress)
+	if err != nil {
+		if remove {
+			return nil, storeFailure(err)
+		}
+		// Waking by MAC address does not require the store
+		log.Print(err)
+		stored, exists = s.findLastKnown(device.MACAddress)
+	}
+	if add && !exists && validateTarget(device.MACAddress) == nil {
+		s.publishUnknown(requestActor(r), device.MACAddress)
+	}
+	if req.known && !exists {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", device.MACAddress)}
+	}
+	if exists {
+		required := AccessWake
+		if remove {
+			required = AccessManage
+		}
+		if !allows(access(user, stored), required) {
+			return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+		}
+	} else if user != nil && user.Scope != nil {
+		// Users restricted to a scope cannot add devices, nor wake devices outside it unless allowed to wake unknown
+		// devices, which are then woken without being stored
+		if !add || !user.AllowUnknown {
+			return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+		}
+		save = false
+	} else if add {
+		if err := validateSharing(user, &device.Sharing); err != nil {
+			return nil, err
+		}
+		if req.Template != "" {
+			t, err := s.template(req.Template)
+			if err != nil {
+				return nil, err
+			}
+			t.apply(&device)
+		}
+	}
+	if err := validateNotes(device.Notes); err != nil {
+		return nil, err
+	}
+	if err := validateDescription(device.Description); err != nil {
+		return nil, err
+	}
+	if err := validateProbePorts(device.ProbePorts); err != nil {
+		return nil, err
+	}
+	if err := validatePlatform(device.Platform); err != nil {
+		return nil, err
+	}
+	if err := validateKeepAwake(device.KeepAwake); err != nil {
+		return nil, err
+	}
+	if err := validateSecureOnPassword(device.SecureOnPassword); err != nil {
+		return nil, err
+	}
+	if err := validateWakeAddress(device.WakeAddress, device.WakePort); err != nil {
+		return nil, err
+	}
+	if err := validateTransport(device.Transport, device.WakeInterface); err != nil {
+		return nil, err
+	}
+	if err := validateHook(device.OnOnline); err != nil {
+		return nil, err
+	}
+	if device.Watts < 0 {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid watts: %v", device.Watts)}
+	}
+	if err := validateMACAddresses(device); err != nil {
+		return nil, err
+	}
+	var checks []prereq.Check
+	essential, macs := device.Essential, device.MACAddresses
+	if exists {
+		checks, essential, macs = stored.Prerequisites, stored.Essential, stored.MACAddresses
+	}
+	// The hook of the request overrides the hook of the stored device. Only users managing the device may change it, as
+	// the server would otherwise post to any URL for everyone allowed to wake
+	hook := device.OnOnline
+	if hook != "" && (!exists || hook != stored.OnOnline) && !managesDevice(user, stored, exists) {
+		return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+	}
+	if hook == "" && exists {
+		hook = stored.OnOnline
+	}
+	// Magic packets are sent to the MAC addresses of the stored device, while settings of how they are sent are
+	// taken from the request if given
+	target := Device{
+		MACAddress:       device.MACAddress,
+		MACAddresses:     macs,
+		SecureOnPassword: device.SecureOnPassword,
+		WakeAddress:      device.WakeAddress,
+		WakePort:         device.WakePort,
+		Transport:        device.Transport,
+		WakeInterface:    device.WakeInterface,
+	}
+	if exists {
+		// Only users managing the device may change where magic packets are sent
+		if overrides(device, stored) && !managesDevice(user, stored, exists) {
+			return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+		}
+		if target.SecureOnPassword == "" {
+			target.SecureOnPassword = stored.SecureOnPassword
+		}
+		if target.WakeAddress == "" {
+			target.WakeAddress = stored.WakeAddress
+		}
+		if target.WakePort == 0 {
+			target.WakePort = stored.WakePort
+		}
+		if target.Transport == "" {
+			target.Transport = stored.Transport
+		}
+		if target.WakeInterface == "" {
+			target.WakeInterface = stored.WakeInterface
+		}
+	}
+	var sent []string
+	var (
+		ipAddress string
+		timeout   time.Duration
+		resend    time.Duration
+		wake      func() error
+		deferred  *DeferredWake
+		skipped   bool
+	)
+	if add {
+		if err := validateTarget(device.MACAddress); err != nil {
+			return nil, err
+		}
+		if device.IPAddress != "" && net.ParseIP(device.IPAddress) == nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid IP address: %s", device.IPAddress)}
+		}
+		ipAddress, err = s.ipAddress(device)
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		b, berr := req.burst(s.burst())
+		if berr != nil {
+			return nil, berr
+		}
+		if req.Wait {
+			timeout, err = parseTimeout(req.Timeout)
+			if err != nil {
+				return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid timeout: %s", req.Timeout)}
+			}
+			resend, err = parseResend(req.Resend, timeout)
+			if err != nil {
+				return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid resend interval: %s", req.Resend)}
+			}
+			if ipAddress == "" {
+				return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Cannot wait for device with address %s: IP address is unknown", device.MACAddress)}
+			}
+		}
+		src, err := s.sourceIP(ipAddress)
+		if err != nil {
+			return nil, &Error{err: err, Status: wakeStatus(err), Message: "Could not determine source address"}
+		}
+		skipped = s.skipIfOnline(req.SkipIfOnline) && s.online(r.Context(), stored, ipAddress)
+		var refused, warned []prereq.Result
+		if !skipped {
+			refused, warned = s.checkPrerequisites(r.Context(), checks)
+		}
+		if len(refused) > 0 {
+			return nil, &Error{
+				Status:        http.StatusPreconditionFailed,
+				Message:       fmt.Sprintf("Prerequisites failed for device with address %s: %s", device.MACAddress, names(refused)),
+				Prerequisites: refused,
+			}
+		}
+		for _, f := range warned {
+			w.Header().Add("Warning", fmt.Sprintf("199 wakeup %q", warning(f)))
+		}
+		if skipped {
+			log.Printf("Skipping wake of device with address %s: device is online", device.MACAddress)
+		} else if s.onBattery(Device{Essential: essential}) {
+			if s.UPSPolicy != UPSDefer {
+				return nil, &Error{
+					Status:  http.StatusServiceUnavailable,
+					Message: fmt.Sprintf("Refusing to wake non-essential device with address %s: %s", device.MACAddress, reasonOnBattery),
+				}
+			}
+			wake := device
+			wake.MACAddresses, wake.SecureOnPassword = macs, target.SecureOnPassword
+			wake.WakeAddress, wake.WakePort = target.WakeAddress, target.WakePort
+			wake.Transport, wake.WakeInterface = target.Transport, target.WakeInterface
+			deferred = s.deferWake(wake, src)
+		} else {
+			if err := s.runScript(r.Context(), script.PreWake, Device{ID: stored.ID, Name: device.Name, MACAddress: device.MACAddress, IPAddress: ipAddress}); err != nil {
+				return nil, &Error{
+					Status:  http.StatusPreconditionFailed,
+					Message: fmt.Sprintf("Pre-wake script failed for device with address %s: %s", device.MACAddress, err),
+				}
+			}
+			if err := s.checkQuota(w, r); err != nil {
+				return nil, err
+			}
+			if err := s.cooldown(device.MACAddress); err != nil {
+				return nil, &Error{err: err, Status: wakeStatus(err), Message: fmt.Sprintf("Wake cooldown of device with address %s has not passed", device.MACAddress)}
+			}
+			if !s.allow(r.Context(), budget.PriorityInteractive, b.packetsTo(target)) {
+				return nil, &Error{Status: http.StatusTooManyRequests, Message: "Send budget exceeded"}
+			}
+			sent, err = s.wakeBurst(src, target, b)
+			if err != nil {
+				s.publishWake(requestActor(r), event.Event{Type: event.Failed, Device: stored.ID, MACAddress: device.MACAddress, Name: device.Name, Error: err.Error()})
+				d := wol.Diagnose(err)
+				return nil, &Error{
+					err:     err,
+					Status:  wakeStatus(err),
+					Message: fmt.Sprintf("Failed to wake device with address %s", device.MACAddress),
+					Cause:   d.Cause,
+					Hint:    d.Hint,
+				}
+			}
+			s.publishWake(requestActor(r), event.Event{Type: event.Wake, Device: stored.ID, MACAddress: device.MACAddress, Name: device.Name})
+			wake = func() error {
+				if !s.allow(r.Context(), budget.PriorityRetry, b.packetsTo(target)) {
+					return budget.ErrExceeded
+				}
+				_, err := s.wakeBurst(src, target, b)
+				return err
+			}
+		}
+	}
+	if save {
+		s.mu.Lock()
+		err := s.writeDevice(device, add, requestActor(r))
+		s.mu.Unlock()
+		if err == nil && add && !exists {
+			warnLocal(w, Device{}, device)
+		}
+		if err != nil {
+			if remove {
+				return nil, storeFailure(err)
+			}
+			// The device has been woken, so the wake succeeds even if the device could not be saved
+			log.Print(err)
+			w.Header().Add("Warning", fmt.Sprintf("199 wakeup %q", "Device was not saved: store unavailable"))
+		}
+	}
+	if deferred != nil {
+		w.WriteHeader(http.StatusAccepted)
+		return deferred, nil
+	}
+	if skipped {
+		return &WakeResult{Sent: make([]string, 0), Skipped: true}, nil
+	}
+	online := Device{ID: stored.ID, Name: device.Name, MACAddress: device.MACAddress}
+	if add && req.Wait {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		probes := s.probes(stored, ipAddress)
+		if req.Ping && !s.Ping {
+			probes = append(probes, wait.ICMPProbe(ipAddress))
+		}
+		var result wait.Result
+		if resend > 0 && wake != nil {
+			result = wait.Retry(ctx, s.waitFunc, probes, resend, wake)
+			if result.ResendErr != nil {
+				log.Printf("Could not resend wake to device with address %s: %s", device.MACAddress, result.ResendErr)
+			}
+		} else {
+			result = s.waitFunc(ctx, probes)
+		}
+		if result.Status != wait.StatusOnline {
+			w.WriteHeader(http.StatusGatewayTimeout)
+		} else {
+			e := event.Event{Type: event.Online, Device: online.ID, MACAddress: online.MACAddress, Name: online.Name}
+			s.publish(e)
+			if hook != "" {
+				go s.callHook(hook, e)
+			}
+		}
+		return newWaitResult(result), nil
+	}
+	if add && hook != "" {
+		if ipAddress == "" {
+			log.Printf("Not calling onOnline hook of device with address %s: IP address is unknown", device.MACAddress)
+		} else {
+			go s.waitForHook(hook, s.probes(stored, ipAddress), online)
+		}
+	}
+	if len(macs) > 0 {
+		return &WakeResult{Sent: sent}, nil
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil, nil
+}
+
+func (s *Server) syncHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	var revision int64
+	if v := r.URL.Query().Get("since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid revision: %s", v)}
+		}
+		revision = n
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	sync := i.since(userFrom(r.Context()), revision)
+	return &sync, nil
+}
+
 func notFoundHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
 	return nil, &Error{
 		Status:  http.StatusNotFound,
@@ -164,16 +1093,25 @@ type appHandler func(http.ResponseWriter, *http.Request) (interface{}, *Error)
 func (fn appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	data, e := fn(w, r)
 	if e != nil { // e is *Error, not os.Error.
-		if e.err != nil {
+		annotate(r.Context(), "error", e.Message)
+		if e.err != nil && !annotate(r.Context(), "cause", e.err) {
 			log.Print(e.err)
 		}
-		out, err := json.Marshal(e)
+		setRetryAfter(w, e.err)
+		var v interface{} = e
+		if enveloped(r.Context()) {
+			v = &envelope{Error: e}
+		}
+		out, err := json.Marshal(v)
 		if err != nil {
 			panic(err)
 		}
 		w.WriteHeader(e.Status)
 		w.Write(out)
 	} else if data != nil {
+		if enveloped(r.Context()) {
+			data = &envelope{Data: data}
+		}
 		out, err := json.Marshal(data)
 		if err != nil {
 			panic(err)
@@ -194,15 +1132,165 @@ func requestFilter(next http.Handler) http.Handler {
 func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.Handle("/api/v1/wake", appHandler(s.defaultHandler))
+	mux.Handle("/api/v1/sync", appHandler(s.syncHandler))
+	mux.Handle("/api/v1/devices", appHandler(s.deviceListHandler))
+	mux.Handle("/api/v1/devices/", appHandler(s.devicesHandler))
+	mux.Handle("/api/v1/groups/", appHandler(s.groupHandler))
+	mux.Handle("/api/v1/smart-groups", appHandler(s.smartGroupsHandler))
+	mux.Handle("/api/v1/smart-groups/", appHandler(s.smartGroupsHandler))
+	mux.Handle("/api/v1/schedules", appHandler(s.schedulesHandler))
+	mux.Handle("/api/v1/schedules/", appHandler(s.schedulesHandler))
+	mux.Handle("/api/v1/schedules/simulate", appHandler(s.simulateHandler))
+	mux.Handle("/api/v1/discover", appHandler(s.discoverHandler))
+	mux.Handle("/api/v1/discover/", appHandler(s.discoverHandler))
+	mux.Handle("/api/v1/validate/mac", appHandler(s.validateMACHandler))
+	mux.Handle("/api/v1/import", appHandler(s.importHandler))
+	mux.Handle("/api/v1/templates", appHandler(s.templatesHandler))
+	mux.Handle("/api/v1/conflicts", appHandler(s.conflictsHandler))
+	mux.Handle("/api/v1/history", appHandler(s.historyHandler))
+	mux.Handle("/api/v1/energy", appHandler(s.energyHandler))
+	mux.Handle("/api/v1/health", appHandler(s.healthHandler))
+	mux.Handle("/api/v1/events", appHandler(s.streamHandler))
+	mux.Handle("/api/v1/events.atom", appHandler(s.feedHandler))
+	mux.Handle("/api/v1/quota", appHandler(s.quotaHandler))
+	mux.Handle("/api/v1/webhooks/netbox", appHandler(s.netboxHandler))
+	mux.Handle("/api/v1/webhooks/wake", flat(appHandler(s.hookWakeHandler)))
+	mux.Handle("/api/v1/webhooks/events", flat(appHandler(s.hookEventsHandler)))
+	mux.Handle("/api/v1/public/devices/", appHandler(s.publicAPIHandler))
+	mux.HandleFunc("/wake/", s.publicPageHandler)
+	mux.HandleFunc("/widget/devices/", s.widgetHandler)
+	if s.InternalAddr == "" {
+		s.handleInternal(mux)
+	}
 	// Return 404 in JSON for all unknown requests under /api/
 	mux.Handle("/api/", appHandler(notFoundHandler))
 	if s.StaticDir != "" {
 		fs := http.FileServer(http.Dir(s.StaticDir))
 		mux.Handle("/", fs)
 	}
-	return requestFilter(mux)
+	return s.requestLogFilter(requestFilter(s.envelopeFilter(s.banFilter(s.clientLimitFilter(s.authFilter(s.rateLimitFilter(mux)))))))
 }
 
 func (s *Server) ListenAndServe(addr string) error {
-	return http.ListenAndServe(addr, s.Handler())
+	return s.ListenAndServeAll("tcp", addr)
+}
+
+// ListenAndServeAll listens on all addrs using network, which must be one of "tcp", "tcp4" or "tcp6", and serves
+// requests until one of the listeners fails.
+func (s *Server) ListenAndServeAll(network string, addrs ...string) error {
+	if err := s.Listen(network, addrs...); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Listen opens listeners on all addrs, and the internal and redirect addresses if set, using network, which must be one
+// of "tcp", "tcp4" or "tcp6". Listening before serving allows binding privileged ports before dropping privileges.
+func (s *Server) Listen(network string, addrs ...string) error {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return fmt.Errorf("invalid network: %s", network)
+	}
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	for _, addr := range []struct {
+		addr string
+		l    *net.Listener
+	}{{s.InternalAddr, &s.internal}, {s.RedirectAddr, &s.redirect}} {
+		if addr.addr == "" {
+			continue
+		}
+		l, err := net.Listen(network, addr.addr)
+		if err != nil {
+			for _, l := range append(listeners, s.internal) {
+				if l != nil {
+					l.Close()
+				}
+			}
+			return err
+		}
+		*addr.l = l
+	}
+	s.listeners = listeners
+	return nil
+}
+
+// Serve serves requests on the listeners opened by Listen until one of them fails, or until Shutdown is called, in which
+// case nil is returned.
+func (s *Server) Serve() error {
+	s.serveMu.Lock()
+	if s.closed {
+		s.serveMu.Unlock()
+		return nil
+	}
+	errs := make(chan error, len(s.listeners)+2)
+	serve := func(l net.Listener, handler http.Handler, config *tls.Config) {
+		srv := &http.Server{Handler: handler, ReadTimeout: s.ReadTimeout, WriteTimeout: s.WriteTimeout, IdleTimeout: s.IdleTimeout}
+		s.servers = append(s.servers, srv)
+		if config == nil {
+			go func() { errs <- srv.Serve(l) }()
+			return
+		}
+		srv.TLSConfig = config.Clone()
+		go func() { errs <- srv.ServeTLS(l, "", "") }()
+	}
+	handler := s.Handler()
+	for _, l := range s.listeners {
+		serve(l, handler, s.TLSConfig)
+	}
+	if s.internal != nil {
+		serve(s.internal, s.InternalHandler(), nil)
+	}
+	if s.redirect != nil {
+		serve(s.redirect, s.redirectHandler(), nil)
+	}
+	s.serveMu.Unlock()
+	if err := <-errs; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for requests in flight, such as wakes waiting for devices to come
+// online, to complete. Connections still open when ctx is done are closed, and the error of ctx is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.serveMu.Lock()
+	s.closed = true
+	servers := s.servers
+	if len(servers) == 0 {
+		// Not serving yet, so only the listeners are open
+		for _, l := range append(s.listeners, s.internal, s.redirect) {
+			if l != nil {
+				l.Close()
+			}
+		}
+	}
+	s.serveMu.Unlock()
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			err := srv.Shutdown(ctx)
+			if err != nil {
+				srv.Close()
+			}
+			errs <- err
+		}(srv)
+	}
+	var err error
+	for range servers {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
 }
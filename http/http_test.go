@@ -1,14 +1,59 @@
 package http
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/mpolden/wakeup/apikey"
+	"github.com/mpolden/wakeup/auth"
+	"github.com/mpolden/wakeup/ban"
+	"github.com/mpolden/wakeup/budget"
+	"github.com/mpolden/wakeup/discover"
+	"github.com/mpolden/wakeup/energy"
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/health"
+	"github.com/mpolden/wakeup/history"
+	"github.com/mpolden/wakeup/inventory"
+	"github.com/mpolden/wakeup/logging"
+	"github.com/mpolden/wakeup/macaddr"
+	"github.com/mpolden/wakeup/neigh"
+	"github.com/mpolden/wakeup/power"
+	"github.com/mpolden/wakeup/prereq"
+	"github.com/mpolden/wakeup/quota"
+	"github.com/mpolden/wakeup/ratelimit"
+	"github.com/mpolden/wakeup/schedule"
+	"github.com/mpolden/wakeup/script"
+	"github.com/mpolden/wakeup/totp"
+	"github.com/mpolden/wakeup/trace"
+	"github.com/mpolden/wakeup/ups"
+	"github.com/mpolden/wakeup/wait"
+	"github.com/mpolden/wakeup/wol"
 )
 
 func httpGet(url string) (string, int, error) {
@@ -41,6 +86,24 @@ func httpRequest(method, url, body string) (string, int, error) {
 	return string(data), res.StatusCode, nil
 }
 
+func httpRequestAs(method, url, body, username, password string) (string, int, error) {
+	r, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+	r.SetBasicAuth(username, password)
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return "", 0, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	return string(data), res.StatusCode, nil
+}
+
 func httpPost(url, body string) (string, int, error) {
 	return httpRequest(http.MethodPost, url, body)
 }
@@ -55,7 +118,7 @@ func testServer() (*httptest.Server, string) {
 		panic(err)
 	}
 	api := Server{
-		wakeFunc:  func(net.IP, net.HardwareAddr) error { return nil },
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
 		cacheFile: file.Name(),
 	}
 	log.SetOutput(ioutil.Discard)
@@ -81,26 +144,35 @@ func TestRequests(t *testing.T) {
 		{"POST", "", "/api/v1/wake", `{"status":400,"message":"Malformed JSON"}`, 400},
 		// Invalid MAC address
 		{"POST", `{"macAddress":"foo"}`, "/api/v1/wake", `{"status":400,"message":"Invalid MAC address: foo"}`, 400},
+		// Invalid IP address
+		{"POST", `{"macAddress":"AC:CD:EF:12:34:56","ipAddress":"foo"}`, "/api/v1/wake", `{"status":400,"message":"Invalid IP address: foo"}`, 400},
 		// List devices
 		{"GET", "", "/api/v1/wake", `{"devices":[]}`, 200},
 		// Wake device
-		{"POST", `{"macAddress":"AB:CD:EF:12:34:56"}`, "/api/v1/wake", "", 204},
-		{"GET", "", "/api/v1/wake", `{"devices":[{"macAddress":"AB:CD:EF:12:34:56"}]}`, 200},
+		{"POST", `{"macAddress":"AC:CD:EF:12:34:56"}`, "/api/v1/wake", "", 204},
+		{"GET", "", "/api/v1/wake", `{"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","macAddress":"AC:CD:EF:12:34:56"}]}`, 200},
 		// Waking same device does not result in duplicates
-		{"POST", `{"macAddress":"AB:CD:EF:12:34:56"}`, "/api/v1/wake", "", 204},
-		{"GET", "", "/api/v1/wake", `{"devices":[{"macAddress":"AB:CD:EF:12:34:56"}]}`, 200},
+		{"POST", `{"macAddress":"AC:CD:EF:12:34:56"}`, "/api/v1/wake", "", 204},
+		{"GET", "", "/api/v1/wake", `{"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","macAddress":"AC:CD:EF:12:34:56"}]}`, 200},
 		// Delete
-		{"DELETE", `{"macAddress":"AB:CD:EF:12:34:56"}`, "/api/v1/wake", "", 204},
+		{"DELETE", `{"macAddress":"AC:CD:EF:12:34:56"}`, "/api/v1/wake", "", 204},
 		{"GET", "", "/api/v1/wake", `{"devices":[]}`, 200},
 		// Add multiple devices
-		{"POST", `{"macAddress":"AB:CD:EF:12:34:56"}`, "/api/v1/wake", "", 204},
+		{"POST", `{"macAddress":"AC:CD:EF:12:34:56"}`, "/api/v1/wake", "", 204},
 		{"POST", `{"macAddress":"12:34:56:AB:CD:EF"}`, "/api/v1/wake", "", 204},
-		{"GET", "", "/api/v1/wake", `{"devices":[{"macAddress":"12:34:56:AB:CD:EF"},{"macAddress":"AB:CD:EF:12:34:56"}]}`, 200},
-		{"DELETE", `{"macAddress":"AB:CD:EF:12:34:56"}`, "/api/v1/wake", "", 204},
+		{"GET", "", "/api/v1/wake", `{"devices":[{"id":"2fd84d14-c38f-589d-ad2c-ec7c874166da","macAddress":"12:34:56:AB:CD:EF"},{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","macAddress":"AC:CD:EF:12:34:56"}]}`, 200},
+		{"DELETE", `{"macAddress":"AC:CD:EF:12:34:56"}`, "/api/v1/wake", "", 204},
 		{"DELETE", `{"macAddress":"12:34:56:AB:CD:EF"}`, "/api/v1/wake", "", 204},
 		// Add device with name
-		{"POST", `{"name":"foo","macAddress":"AB:CD:EF:12:34:56"}`, "/api/v1/wake", "", 204},
-		{"GET", "", "/api/v1/wake", `{"devices":[{"name":"foo","macAddress":"AB:CD:EF:12:34:56"}]}`, 200},
+		{"POST", `{"name":"foo","macAddress":"AC:CD:EF:12:34:56"}`, "/api/v1/wake", "", 204},
+		{"GET", "", "/api/v1/wake", `{"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"foo","macAddress":"AC:CD:EF:12:34:56"}]}`, 200},
+		// Delta sync
+		{"GET", "", "/api/v1/sync", `{"revision":7,"reset":true,"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"foo","macAddress":"AC:CD:EF:12:34:56"}],"removed":[]}`, 200},
+		{"GET", "", "/api/v1/sync?since=7", `{"revision":7,"reset":false,"devices":[],"removed":[]}`, 200},
+		{"GET", "", "/api/v1/sync?since=4", `{"revision":7,"reset":false,"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"foo","macAddress":"AC:CD:EF:12:34:56"}],"removed":["12:34:56:AB:CD:EF"]}`, 200},
+		{"GET", "", "/api/v1/sync?since=8", `{"revision":7,"reset":true,"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"foo","macAddress":"AC:CD:EF:12:34:56"}],"removed":[]}`, 200},
+		{"GET", "", "/api/v1/sync?since=foo", `{"status":400,"message":"Invalid revision: foo"}`, 400},
+		{"POST", "", "/api/v1/sync", `{"status":405,"message":"Invalid method POST, must be GET"}`, 405},
 	}
 
 	for _, tt := range tests {
@@ -130,3 +202,5541 @@ func TestRequests(t *testing.T) {
 		}
 	}
 }
+
+func TestSyncChangesTruncated(t *testing.T) {
+	var c deviceCache
+	for i := 0; i < maxChanges+10; i++ {
+		c.record("AC:CD:EF:12:34:56")
+	}
+	if got := len(c.Changes); got != maxChanges {
+		t.Errorf("want %d changes, got %d", maxChanges, got)
+	}
+	if !c.since(nil, 1).Reset {
+		t.Errorf("want reset for revision older than retained changes")
+	}
+	if c.since(nil, c.Revision-1).Reset {
+		t.Errorf("want no reset for recent revision")
+	}
+}
+
+func TestWakeUsesRoute(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	routes, err := wol.ParseRoutes([]string{"10.1.0.0/16=10.1.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var src net.IP
+	api := Server{
+		SourceIP:  net.ParseIP("192.168.1.1"),
+		Routes:    routes,
+		wakeFunc:  func(_ net.HardwareAddr, opts wol.Options) error { src = opts.Source; return nil },
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		body string
+		src  string
+	}{
+		{`{"macAddress":"AC:CD:EF:12:34:56"}`, "192.168.1.1"},
+		{`{"macAddress":"AC:CD:EF:12:34:57","ipAddress":"10.1.2.3"}`, "10.1.0.1"},
+		// IP address of stored device is used when omitted from request
+		{`{"macAddress":"AC:CD:EF:12:34:57"}`, "10.1.0.1"},
+		{`{"macAddress":"AC:CD:EF:12:34:58","ipAddress":"10.2.2.3"}`, "192.168.1.1"},
+	}
+	for i, tt := range tests {
+		if _, _, err := httpPost(server.URL+"/api/v1/wake", tt.body); err != nil {
+			t.Fatal(err)
+		}
+		if got := src.String(); got != tt.src {
+			t.Errorf("#%d: want source %s, got %s", i, tt.src, got)
+		}
+	}
+}
+
+func TestBudget(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+		Budget:    budget.New(0, 3, time.Minute),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	// Each packet of a burst is taken from the budget
+	body := `{"macAddress":"AC:CD:EF:12:34:56","packets":2}`
+	if _, status, err := httpPost(server.URL+"/api/v1/wake", body); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	data, status, err := httpPost(server.URL+"/api/v1/wake", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"status":429,"message":"Send budget exceeded"}`; status != 429 || data != want {
+		t.Errorf("want %q, got %q (%d)", want, data, status)
+	}
+	data, _, err = httpGet(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"wakeup_budget_allowed_total 2\n", "wakeup_budget_rejected_total 2\n", "wakeup_budget_paused 1\n"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("want metrics to contain %q, got %q", want, data)
+		}
+	}
+}
+
+func TestWakeAndWait(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	woken := 0
+	api := Server{
+		wakeFunc: func(mac net.HardwareAddr, _ wol.Options) error {
+			if mac.String() == "ac:cd:ef:12:34:58" {
+				woken++
+			}
+			return nil
+		},
+		cacheFile: file.Name(),
+		waitFunc: func(ctx context.Context, probes []wait.Probe) wait.Result {
+			// The device at 192.0.2.2 only comes online after the wake is resent
+			if probes[0].Address == "127.0.0.1:22" || (probes[0].Address == "192.0.2.2:22" && woken > 1) {
+				return wait.Result{Status: wait.StatusOnline, Attempts: 1, Probes: probes}
+			}
+			<-ctx.Done()
+			return wait.Result{Status: wait.StatusTimeout, Probes: probes}
+		},
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		body     string
+		response string
+		status   int
+	}{
+		{`{"macAddress":"AC:CD:EF:12:34:56","wait":true}`, `{"status":400,"message":"Cannot wait for device with address AC:CD:EF:12:34:56: IP address is unknown"}`, 400},
+		{`{"macAddress":"AC:CD:EF:12:34:56","ipAddress":"127.0.0.1","wait":true,"timeout":"foo"}`, `{"status":400,"message":"Invalid timeout: foo"}`, 400},
+		{`{"macAddress":"AC:CD:EF:12:34:56","ipAddress":"127.0.0.1","wait":true,"timeout":"1h"}`, `{"status":400,"message":"Invalid timeout: 1h"}`, 400},
+		{`{"macAddress":"AC:CD:EF:12:34:56","ipAddress":"127.0.0.1","wait":true,"timeout":"1s"}`, `"status":"online"`, 200},
+		{`{"macAddress":"AC:CD:EF:12:34:57","ipAddress":"192.0.2.1","wait":true,"timeout":"50ms"}`, `"status":"timeout"`, 504},
+		{`{"macAddress":"AC:CD:EF:12:34:56","ipAddress":"127.0.0.1","wait":true,"ping":true}`, `"icmp:127.0.0.1"`, 200},
+		{`{"macAddress":"AC:CD:EF:12:34:58","ipAddress":"192.0.2.2","wait":true,"timeout":"5s","resend":"10ms"}`, `{"status":400,"message":"Invalid resend interval: 10ms"}`, 400},
+		{`{"macAddress":"AC:CD:EF:12:34:58","ipAddress":"192.0.2.2","wait":true,"timeout":"5s","resend":"5s"}`, `{"status":400,"message":"Invalid resend interval: 5s"}`, 400},
+		{`{"macAddress":"AC:CD:EF:12:34:58","ipAddress":"192.0.2.2","wait":true,"timeout":"5s","resend":"1s"}`, `"attempts":1,"resends":1,`, 200},
+	}
+	for i, tt := range tests {
+		data, status, err := httpPost(server.URL+"/api/v1/wake", tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if !strings.Contains(data, tt.response) {
+			t.Errorf("#%d: want response containing %q, got %q", i, tt.response, data)
+		}
+	}
+}
+
+func TestSkipIfOnline(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	var woken []string
+	api := Server{
+		SkipIfOnline: true,
+		wakeFunc: func(hwAddr net.HardwareAddr, _ wol.Options) error {
+			woken = append(woken, strings.ToUpper(hwAddr.String()))
+			return nil
+		},
+		cacheFile: file.Name(),
+		waitFunc: func(ctx context.Context, probes []wait.Probe) wait.Result {
+			if probes[0].Address == "127.0.0.1:22" {
+				return wait.Result{Status: wait.StatusOnline, Probes: probes}
+			}
+			<-ctx.Done()
+			return wait.Result{Status: wait.StatusTimeout, Probes: probes}
+		},
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		body     string
+		response string
+		status   int
+	}{
+		{`{"macAddress":"AC:CD:EF:12:34:56","ipAddress":"127.0.0.1"}`, `{"sent":[],"skipped":true}`, 200},
+		{`{"macAddress":"AC:CD:EF:12:34:56","skipIfOnline":false}`, "", 204},
+		{`{"macAddress":"AC:CD:EF:12:34:57","ipAddress":"192.0.2.1"}`, "", 204},
+		// Devices without an IP address are always woken
+		{`{"macAddress":"AC:CD:EF:12:34:58"}`, "", 204},
+	}
+	for i, tt := range tests {
+		data, status, err := httpPost(server.URL+"/api/v1/wake", tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if data != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+	}
+	// Skipped devices are still stored
+	if _, status, err := httpGet(server.URL + "/api/v1/devices/AC:CD:EF:12:34:56"); err != nil || status != 200 {
+		t.Errorf("want status 200, got %d (%v)", status, err)
+	}
+	if err := api.WakeScheduled(schedule.Schedule{MACAddress: "AC:CD:EF:12:34:56"}); err != nil {
+		t.Fatal(err)
+	}
+	skip := false
+	if err := api.WakeScheduled(schedule.Schedule{MACAddress: "AC:CD:EF:12:34:56", SkipIfOnline: &skip}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"AC:CD:EF:12:34:56", "AC:CD:EF:12:34:57", "AC:CD:EF:12:34:58", "AC:CD:EF:12:34:56"}; fmt.Sprint(woken) != fmt.Sprint(want) {
+		t.Errorf("want %v woken, got %v", want, woken)
+	}
+}
+
+func TestOnOnlineHook(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".history")
+	calls := make(chan event.Event, 10)
+	failures := 1
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e event.Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		if r.URL.Path == "/fail" || failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		calls <- e
+	}))
+	defer hook.Close()
+	api := Server{
+		History:   history.Open(file.Name() + ".history"),
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+		waitFunc: func(ctx context.Context, probes []wait.Probe) wait.Result {
+			if probes[0].Address == "127.0.0.1:22" {
+				return wait.Result{Status: wait.StatusOnline, Probes: probes}
+			}
+			<-ctx.Done()
+			return wait.Result{Status: wait.StatusTimeout, Probes: probes}
+		},
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"ipAddress":"127.0.0.1","onOnline":"ftp://example.com"}`); err != nil || status != 400 {
+		t.Fatalf("want status 400 for invalid hook, got %d (%v)", status, err)
+	}
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"ipAddress":"127.0.0.1","onOnline":"`+hook.URL+`/online"}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	// The stored hook is called once the device is online, and retried if it fails
+	if _, status, err := httpPost(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56","wait":true}`); err != nil || status != 200 {
+		t.Fatalf("want status 200, got %d (%v)", status, err)
+	}
+	select {
+	case e := <-calls:
+		if e.Type != event.Online || e.Device != "fc2b1229-4d81-5601-88f3-99f11ef28aa6" || e.MACAddress != "AC:CD:EF:12:34:56" {
+			t.Errorf("unexpected event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want hook called")
+	}
+	// The hook of a request overrides the stored hook, and is called without waiting in the request
+	if _, status, err := httpPost(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56","onOnline":"`+hook.URL+`/fail"}`); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	var entries []history.Entry
+	for len(entries) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		entries, err = api.History.Query(history.Filter{Action: history.HookCalled})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(entries) != 2 {
+		t.Fatalf("want 2 hook calls recorded, got %+v", entries)
+	}
+	// Both hooks are retried concurrently, so either may be recorded first
+	if entries[0].Result == history.ResultOK {
+		entries[0], entries[1] = entries[1], entries[0]
+	}
+	if e := entries[0]; e.Result != history.ResultFailed || e.Message != "POST "+hook.URL+" failed after 3 attempts: got status 500" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := entries[1]; e.Result != history.ResultOK || e.Message != "POST "+hook.URL+" succeeded after 2 attempts" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
+
+func TestScripts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	preWake := filepath.Join(dir, "pre-wake")
+	online := filepath.Join(dir, "online")
+	if err := ioutil.WriteFile(preWake, []byte("#!/bin/sh\necho refusing $WAKEUP_MAC_ADDRESS\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(online, []byte("#!/bin/sh\necho $WAKEUP_EVENT $WAKEUP_IP_ADDRESS\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	woken := 0
+	api := Server{
+		History:   history.Open(filepath.Join(dir, "history")),
+		Scripts:   &script.Hooks{PreWake: preWake, PostOnline: online},
+		Events:    event.NewBus(),
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { woken++; return nil },
+		cacheFile: filepath.Join(dir, "cache"),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"ipAddress":"10.0.0.2"}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	res, status, err := httpPost(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"status":412,"message":"Pre-wake script failed for device with address AC:CD:EF:12:34:56: exit status 1"}`
+	if status != 412 || res != want {
+		t.Errorf("want status 412 and %s, got %d and %s", want, status, res)
+	}
+	if woken != 0 {
+		t.Errorf("want no packets sent, got %d", woken)
+	}
+
+	events, cancel := api.Events.Subscribe(10)
+	go api.RunScripts(events)
+	api.publish(event.Event{Type: event.Online, MACAddress: "AC:CD:EF:12:34:56"})
+	deadline := time.Now().Add(5 * time.Second)
+	var entries []history.Entry
+	for len(entries) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		entries, err = api.History.Query(history.Filter{Action: history.ScriptRan})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	if len(entries) != 2 {
+		t.Fatalf("want 2 scripts recorded, got %+v", entries)
+	}
+	if e := entries[0]; e.Result != history.ResultOK || e.Message != "post-online script "+online+" succeeded" || e.Output != "post-online 10.0.0.2\n" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e := entries[1]; e.Result != history.ResultFailed || e.Output != "refusing AC:CD:EF:12:34:56\n" || e.Device == "" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
+
+func TestWidget(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{
+		Auth:      testAuth{"alice": "alice", "bob": "bob"},
+		cacheFile: file.Name(),
+		waitFunc: func(ctx context.Context, probes []wait.Probe) wait.Result {
+			if probes[0].Address == "10.0.0.2:22" {
+				return wait.Result{Status: wait.StatusOnline, Probes: probes}
+			}
+			return wait.Result{Status: wait.StatusTimeout, Probes: probes}
+		},
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for mac, body := range map[string]string{
+		"AC:CD:EF:12:34:55": `{"name":"nas","ipAddress":"10.0.0.2"}`,
+		"AC:CD:EF:12:34:56": `{"ipAddress":"10.0.0.3"}`,
+		"AC:CD:EF:12:34:57": `{}`,
+	} {
+		if _, status, err := httpRequestAs(http.MethodPut, server.URL+"/api/v1/devices/"+mac, body, "alice", "alice"); err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d (%v)", status, err)
+		}
+	}
+	var tests = []struct {
+		url, username string
+		status        int
+		contains      []string
+	}{
+		{"/widget/devices/AC:CD:EF:12:34:55", "alice", 200, []string{`<title>nas</title>`, `class="status online"`, `<button id="wake" disabled>`, `{macAddress: "AC:CD:EF:12:34:55"}`}},
+		{"/widget/devices/ac-cd-ef-12-34-56", "alice", 200, []string{`<title>AC:CD:EF:12:34:56</title>`, `class="status offline"`, `<button id="wake">`}},
+		{"/widget/devices/AC:CD:EF:12:34:57", "alice", 200, []string{`class="status unknown"`}},
+		{"/widget/devices/AC:CD:EF:12:34:55", "bob", 404, []string{"Device not found"}},
+		{"/widget/devices/AC:CD:EF:12:34:55", "", 401, nil},
+		{"/widget/devices/foo", "alice", 400, []string{"Invalid device ID or MAC address: foo"}},
+	}
+	for i, tt := range tests {
+		res, status, err := httpRequestAs(http.MethodGet, server.URL+tt.url, "", tt.username, tt.username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		for _, s := range tt.contains {
+			if !strings.Contains(res, s) {
+				t.Errorf("#%d: want %q in %s", i, s, res)
+			}
+		}
+	}
+}
+
+func TestBadge(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{
+		VerifyMAC: true,
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+		waitFunc: func(ctx context.Context, probes []wait.Probe) wait.Result {
+			if probes[0].Address == "10.0.0.2:22" || probes[0].Address == "10.0.0.5:22" {
+				return wait.Result{Status: wait.StatusOnline, Probes: probes}
+			}
+			return wait.Result{Status: wait.StatusTimeout, Probes: probes}
+		},
+		neighFunc: func() (neigh.Table, error) {
+			return neigh.Table{
+				"10.0.0.2": net.HardwareAddr{0xac, 0xcd, 0xef, 0x12, 0x34, 0x55},
+				"10.0.0.5": net.HardwareAddr{0x10, 0x22, 0x33, 0x44, 0x55, 0x66},
+			}, nil
+		},
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for mac, body := range map[string]string{
+		"AC:CD:EF:12:34:55": `{"name":"nas","ipAddress":"10.0.0.2"}`,
+		"AC:CD:EF:12:34:56": `{"name":"desktop","ipAddress":"10.0.0.3"}`,
+		"AC:CD:EF:12:34:57": `{"ipAddress":"10.0.0.4"}`,
+		"AC:CD:EF:12:34:58": `{}`,
+		"AC:CD:EF:12:34:5A": `{"name":"printer","ipAddress":"10.0.0.5"}`,
+	} {
+		if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/"+mac, body); err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d (%v)", status, err)
+		}
+	}
+	if _, status, err := httpPost(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:57"}`); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	var tests = []struct {
+		ref    string
+		status int
+		want   []string
+	}{
+		{"AC:CD:EF:12:34:55", 200, []string{`aria-label="nas: online"`, `fill="#4c1"`, `width="83"`}},
+		{"ac-cd-ef-12-34-56", 200, []string{`<title>desktop: offline</title>`, `fill="#e05d44"`}},
+		{"AC:CD:EF:12:34:57", 200, []string{`<text x="155" y="14">waking</text>`, `fill="#dfb317"`}},
+		{"AC:CD:EF:12:34:58", 200, []string{`>unknown</text>`}},
+		// Another host responds at the IP address of the device
+		{"AC:CD:EF:12:34:5A", 200, []string{`<title>printer: conflict</title>`, `fill="#fe7d37"`}},
+		{"AC:CD:EF:12:34:59", 404, []string{`"message":"Device not found: AC:CD:EF:12:34:59"`}},
+	}
+	for i, tt := range tests {
+		res, err := http.Get(server.URL + "/api/v1/devices/" + tt.ref + "/badge.svg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, res.StatusCode)
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(string(data), s) {
+				t.Errorf("#%d: want %q in %s", i, s, data)
+			}
+		}
+		if tt.status == 200 {
+			if got := res.Header.Get("Content-Type"); got != "image/svg+xml" {
+				t.Errorf("#%d: want Content-Type image/svg+xml, got %s", i, got)
+			}
+			if got := res.Header.Get("Cache-Control"); got != "max-age=30" {
+				t.Errorf("#%d: want Cache-Control max-age=30, got %s", i, got)
+			}
+		}
+	}
+}
+
+func TestTrackIPs(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".history")
+	table := neigh.Table{
+		"10.0.0.9":    net.HardwareAddr{0xac, 0xcd, 0xef, 0x12, 0x34, 0x56},
+		"10.0.0.10":   net.HardwareAddr{0xac, 0xcd, 0xef, 0x12, 0x34, 0x56},
+		"fe80::1":     net.HardwareAddr{0xac, 0xcd, 0xef, 0x12, 0x34, 0x56},
+		"10.0.0.3":    net.HardwareAddr{0xac, 0xcd, 0xef, 0x12, 0x34, 0x57},
+		"192.168.0.2": net.HardwareAddr{0x10, 0x22, 0x33, 0x44, 0x55, 0x66},
+	}
+	api := Server{
+		History:   history.Open(file.Name() + ".history"),
+		cacheFile: file.Name(),
+		neighFunc: func() (neigh.Table, error) { return table, nil },
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for mac, body := range map[string]string{
+		"AC:CD:EF:12:34:56": `{"ipAddress":"10.0.0.2"}`,
+		"AC:CD:EF:12:34:57": `{"ipAddress":"10.0.0.3"}`,
+	} {
+		if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/"+mac, body); err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d (%v)", status, err)
+		}
+	}
+	lastKnownIP := func(mac string) string {
+		res, _, err := httpGet(server.URL + "/api/v1/devices/" + mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d DeviceResource
+		if err := json.Unmarshal([]byte(res), &d); err != nil {
+			t.Fatal(err)
+		}
+		return d.LastKnownIP
+	}
+	if err := api.trackIPs(); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastKnownIP("AC:CD:EF:12:34:56"); got != "10.0.0.10" {
+		t.Errorf("want lastKnownIP 10.0.0.10, got %q", got)
+	}
+	// Devices observed at their configured address are unchanged
+	if got := lastKnownIP("AC:CD:EF:12:34:57"); got != "" {
+		t.Errorf("want no lastKnownIP, got %q", got)
+	}
+	entries, err := api.History.Query(history.Filter{Action: history.DeviceChanged})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Actor != "arp" || len(entries[0].Changes) != 1 || entries[0].Changes[0].Field != "lastKnownIP" {
+		t.Fatalf("unexpected history %+v", entries)
+	}
+
+	// Agents report addresses
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56/address", `{"ipAddress":"foo"}`); err != nil || status != 400 {
+		t.Errorf("want status 400, got %d (%v)", status, err)
+	}
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:58/address", `{"ipAddress":"10.0.0.4"}`); err != nil || status != 404 {
+		t.Errorf("want status 404, got %d (%v)", status, err)
+	}
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:57/address", `{"ipAddress":"10.0.0.4"}`); err != nil || status != 200 {
+		t.Errorf("want status 200, got %d (%v)", status, err)
+	}
+	if got := lastKnownIP("AC:CD:EF:12:34:57"); got != "10.0.0.4" {
+		t.Errorf("want lastKnownIP 10.0.0.4, got %q", got)
+	}
+	// Changing the configured address clears the last known address
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:57", `{"ipAddress":"10.0.0.5"}`); err != nil || status != 200 {
+		t.Errorf("want status 200, got %d (%v)", status, err)
+	}
+	if got := lastKnownIP("AC:CD:EF:12:34:57"); got != "" {
+		t.Errorf("want no lastKnownIP, got %q", got)
+	}
+}
+
+func TestRefreshHostnames(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".history")
+	names := map[string]string{"10.0.0.2": "nas.lan.", "10.0.0.3": "desktop.lan."}
+	api := Server{
+		History:   history.Open(file.Name() + ".history"),
+		cacheFile: file.Name(),
+		lookupAddr: func(ctx context.Context, addr string) ([]string, error) {
+			if name, ok := names[addr]; ok {
+				return []string{name}, nil
+			}
+			return nil, fmt.Errorf("lookup %s: no such host", addr)
+		},
+		waitFunc: func(ctx context.Context, probes []wait.Probe) wait.Result {
+			return wait.Result{Status: wait.StatusTimeout, Probes: probes}
+		},
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for mac, body := range map[string]string{
+		"AC:CD:EF:12:34:56": `{"ipAddress":"10.0.0.2"}`,
+		"AC:CD:EF:12:34:57": `{"name":"workstation","ipAddress":"10.0.0.3"}`,
+		"AC:CD:EF:12:34:58": `{}`,
+	} {
+		if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/"+mac, body); err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d (%v)", status, err)
+		}
+	}
+	hostname := func(mac string) string {
+		res, _, err := httpGet(server.URL + "/api/v1/devices/" + mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d DeviceResource
+		if err := json.Unmarshal([]byte(res), &d); err != nil {
+			t.Fatal(err)
+		}
+		return d.Hostname
+	}
+	if err := api.refreshHostnames(); err != nil {
+		t.Fatal(err)
+	}
+	for mac, want := range map[string]string{"AC:CD:EF:12:34:56": "nas.lan", "AC:CD:EF:12:34:57": "desktop.lan", "AC:CD:EF:12:34:58": ""} {
+		if got := hostname(mac); got != want {
+			t.Errorf("want hostname %q of %s, got %q", want, mac, got)
+		}
+	}
+	// Devices without a name are displayed by their hostname
+	if res, _, err := httpGet(server.URL + "/api/v1/devices/AC:CD:EF:12:34:56/badge.svg"); err != nil || !strings.Contains(res, "<title>nas.lan: offline</title>") {
+		t.Errorf("want badge of nas.lan, got %s (%v)", res, err)
+	}
+	// Failed lookups keep the previous hostname
+	delete(names, "10.0.0.2")
+	if err := api.refreshHostnames(); err != nil {
+		t.Fatal(err)
+	}
+	if got := hostname("AC:CD:EF:12:34:56"); got != "nas.lan" {
+		t.Errorf("want hostname nas.lan, got %q", got)
+	}
+	entries, err := api.History.Query(history.Filter{Actor: "dns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("want 2 hostname changes recorded, got %+v", entries)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{Health: health.NewTracker(), cacheFile: file.Name()}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"nas"}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	woken := time.Now().Add(-2 * time.Hour)
+	for _, e := range []event.Event{
+		{Type: event.Wake, MACAddress: "AC:CD:EF:12:34:56", Time: woken},
+		{Type: event.Online, MACAddress: "AC:CD:EF:12:34:56", Time: woken.Add(20 * time.Second)},
+		{Type: event.Wake, MACAddress: "AC:CD:EF:12:34:56", Time: woken.Add(time.Hour)},
+		// Devices which are not stored are not reported
+		{Type: event.Wake, MACAddress: "AC:CD:EF:12:34:57", Time: woken},
+	} {
+		api.Health.Handle(e)
+	}
+	res, status, err := httpGet(server.URL + "/api/v1/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","macAddress":"AC:CD:EF:12:34:56","name":"nas","score":70,"wakes":2,"successRate":0.5,"timeToOnline":"20s","consistency":1}]}`
+	if status != 200 || res != want {
+		t.Errorf("want status 200 and %s, got %d and %s", want, status, res)
+	}
+	res, _, err = httpGet(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metric := `wakeup_device_health_score{mac_address="AC:CD:EF:12:34:56",name="nas"} 70`; !strings.Contains(res, metric) {
+		t.Errorf("want %s in %s", metric, res)
+	}
+
+	// Devices failing to come online get hints for their platform
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:58", `{"platform":"amiga"}`); err != nil || status != 400 {
+		t.Fatalf("want status 400, got %d (%v)", status, err)
+	}
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:58", `{"platform":"windows"}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	api.Health.Handle(event.Event{Type: event.Wake, MACAddress: "AC:CD:EF:12:34:58", Time: woken})
+	api.Health.Handle(event.Event{Type: event.Wake, MACAddress: "AC:CD:EF:12:34:58", Time: woken.Add(time.Hour)})
+	res, _, err = httpGet(server.URL + "/api/v1/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report HealthReport
+	if err := json.Unmarshal([]byte(res), &report); err != nil {
+		t.Fatal(err)
+	}
+	var hints []string
+	for _, d := range report.Devices {
+		if d.MACAddress == "AC:CD:EF:12:34:56" && len(d.Hints) > 0 {
+			t.Errorf("want no hints for %s, got %v", d.MACAddress, d.Hints)
+		}
+		if d.MACAddress == "AC:CD:EF:12:34:58" {
+			for _, h := range d.Hints {
+				hints = append(hints, h.ID)
+			}
+		}
+	}
+	if want := "[wol-disabled fast-startup windows-nic]"; fmt.Sprint(hints) != want {
+		t.Errorf("want hints %s, got %v", want, hints)
+	}
+}
+
+func TestLegacyCache(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	if err := ioutil.WriteFile(file.Name(), []byte(`["ac:cd:ef:12:34:56",{"name":"foo","macAddress":"AC:CD:EF:12:34:57"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	api := Server{cacheFile: file.Name()}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	res, _, err := httpGet(server.URL + "/api/v1/wake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","macAddress":"AC:CD:EF:12:34:56"},{"id":"9a18bd6c-44a4-5ed1-9ea6-139be112e3a6","name":"foo","macAddress":"AC:CD:EF:12:34:57"}]}`
+	if res != want {
+		t.Errorf("want %s, got %s", want, res)
+	}
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"description":"Rack 1\nShelf 2"}`); err != nil || status != 400 {
+		t.Errorf("want status 400, got %d (%v)", status, err)
+	}
+	res, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"nas","description":"Rack 1","ipAddress":"10.0.0.2"}`)
+	if err != nil || status != 200 {
+		t.Fatalf("want status 200, got %d (%v)", status, err)
+	}
+	if !strings.Contains(res, `"name":"nas","description":"Rack 1"`) {
+		t.Errorf("want description in %s", res)
+	}
+	// The cache is converted to the current format when compacted
+	if err := api.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), `{"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas","description":"Rack 1",`) {
+		t.Errorf("want cache in current format, got %s", data)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	var mu sync.Mutex
+	online := true
+	var probed [][]wait.Probe
+	api := Server{
+		Ping:      true,
+		Events:    event.NewBus(),
+		cacheFile: file.Name(),
+		waitFunc: func(ctx context.Context, probes []wait.Probe) wait.Result {
+			mu.Lock()
+			defer mu.Unlock()
+			probed = append(probed, probes)
+			if online {
+				return wait.Result{Status: wait.StatusOnline, Probes: probes}
+			}
+			return wait.Result{Status: wait.StatusTimeout, Probes: probes}
+		},
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for mac, body := range map[string]string{
+		"AC:CD:EF:12:34:56": `{"ipAddress":"10.0.0.2","probePorts":[8080]}`,
+		"AC:CD:EF:12:34:57": `{}`,
+	} {
+		if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/"+mac, body); err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d (%v)", status, err)
+		}
+	}
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:57", `{"probePorts":[0]}`); err != nil || status != 400 {
+		t.Errorf("want status 400, got %d (%v)", status, err)
+	}
+	status := func(mac string) DeviceStatus {
+		res, code, err := httpGet(server.URL + "/api/v1/devices/" + mac + "/status")
+		if err != nil || code != 200 {
+			t.Fatalf("want status 200, got %d (%v)", code, err)
+		}
+		var s DeviceStatus
+		if err := json.Unmarshal([]byte(res), &s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	if s := status("AC:CD:EF:12:34:56"); s.Status != "online" || s.IPAddress != "10.0.0.2" || s.Checked == "" || s.Since == "" {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if s := status("AC:CD:EF:12:34:57"); s.Status != "unknown" || s.Checked != "" {
+		t.Errorf("unexpected status %+v", s)
+	}
+	// Statuses are cached
+	status("AC:CD:EF:12:34:56")
+	if len(probed) != 1 {
+		t.Fatalf("want 1 probe, got %d", len(probed))
+	}
+	if got, want := fmt.Sprint(probed[0]), "[tcp:10.0.0.2:8080 icmp:10.0.0.2]"; got != want {
+		t.Errorf("want probes %s, got %s", want, got)
+	}
+	// The monitor publishes changes
+	events, cancel := api.Events.Subscribe(10)
+	defer cancel()
+	mu.Lock()
+	online = false
+	mu.Unlock()
+	api.probeAll()
+	select {
+	case e := <-events:
+		if e.Type != event.Offline || e.MACAddress != "AC:CD:EF:12:34:56" {
+			t.Errorf("unexpected event %+v", e)
+		}
+	default:
+		t.Error("want offline event")
+	}
+	if s := status("AC:CD:EF:12:34:56"); s.Status != "offline" {
+		t.Errorf("unexpected status %+v", s)
+	}
+	if res, code, err := httpGet(server.URL + "/api/v1/devices/AC:CD:EF:12:34:58/status"); err != nil || code != 404 {
+		t.Errorf("want status 404, got %d (%v): %s", code, err, res)
+	}
+}
+
+func TestStatusCache(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	var mu sync.Mutex
+	probes := 0
+	release := make(chan bool)
+	api := Server{
+		cacheFile: file.Name(),
+		waitFunc: func(ctx context.Context, probe []wait.Probe) wait.Result {
+			mu.Lock()
+			probes++
+			mu.Unlock()
+			<-release
+			return wait.Result{Status: wait.StatusOnline, Probes: probe}
+		},
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"ipAddress":"10.0.0.2"}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	url := server.URL + "/api/v1/devices/AC:CD:EF:12:34:56/status"
+
+	// Concurrent requests share a probe
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			httpGet(url)
+		}()
+	}
+	for {
+		mu.Lock()
+		n := probes
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got, want := res.Header.Get("Cache-Control"), "private, max-age=30"; got != want {
+		t.Errorf("want Cache-Control %s, got %s", want, got)
+	}
+	if got := res.Header.Get("Age"); got != "0" {
+		t.Errorf("want Age 0, got %s", got)
+	}
+	if probes != 1 {
+		t.Errorf("want 1 probe, got %d", probes)
+	}
+
+	// Cached statuses can be bypassed
+	if _, status, err := httpGet(url + "?fresh=true"); err != nil || status != 200 {
+		t.Fatalf("want status 200, got %d (%v)", status, err)
+	}
+	if probes != 2 {
+		t.Errorf("want 2 probes, got %d", probes)
+	}
+	if _, status, err := httpGet(url + "?fresh=foo"); err != nil || status != 400 {
+		t.Errorf("want status 400, got %d (%v)", status, err)
+	}
+}
+
+func TestKeepAwake(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	woken := 0
+	api := Server{
+		History:   history.Open(filepath.Join(dir, "history")),
+		Events:    event.NewBus(),
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { woken++; return nil },
+		cacheFile: filepath.Join(dir, "cache"),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"keepAwake":{"from":"22:00","to":"25:00"}}`); err != nil || status != 400 {
+		t.Fatalf("want status 400, got %d (%v)", status, err)
+	}
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"keepAwake":{"from":"22:00","to":"06:00"}}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+
+	night := time.Date(2020, 1, 1, 23, 0, 0, 0, time.Local)
+	events := make(chan event.Event, 10)
+	for _, at := range []time.Time{
+		night,
+		night.Add(-12 * time.Hour), // Outside the window
+		night.Add(10 * time.Minute),
+		night.Add(20 * time.Minute),
+		night.Add(30 * time.Minute), // Woken too often
+		night.Add(40 * time.Minute),
+		night.Add(23 * time.Hour), // In the next window
+	} {
+		events <- event.Event{Type: event.Offline, MACAddress: "AC:CD:EF:12:34:56", Time: at}
+	}
+	events <- event.Event{Type: event.Online, MACAddress: "AC:CD:EF:12:34:56", Time: night}
+	close(events)
+	api.KeepAwake(events)
+	if want := 4; woken != want {
+		t.Errorf("want %d packets sent, got %d", want, woken)
+	}
+	entries, err := api.History.Query(history.Filter{Action: "keepawake."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	sort.Strings(actions)
+	if want := "[keepawake.suspended keepawake.woken keepawake.woken keepawake.woken keepawake.woken]"; fmt.Sprint(actions) != want {
+		t.Errorf("want actions %s, got %v", want, actions)
+	}
+}
+
+func TestEnvelope(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{cacheFile: file.Name()}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	get := func(path, accept string) (string, int) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data), res.StatusCode
+	}
+	var tests = []struct {
+		envelope bool
+		path     string
+		accept   string
+		status   int
+		response string
+	}{
+		{false, "/api/v1/wake", "", 200, `{"devices":[]}`},
+		{false, "/api/v1/wake", "text/html, application/json; envelope=true", 200, `{"data":{"devices":[]},"error":null}`},
+		{false, "/api/v1/foo", "application/json; envelope=1", 404, `{"data":null,"error":{"status":404,"message":"Resource not found"}}`},
+		{true, "/api/v1/wake", "", 200, `{"data":{"devices":[]},"error":null}`},
+		{true, "/api/v1/wake", "application/json; envelope=false", 200, `{"devices":[]}`},
+		{true, "/api/v1/wake", "application/json; envelope=foo", 200, `{"data":{"devices":[]},"error":null}`},
+	}
+	for i, tt := range tests {
+		api.Envelope = tt.envelope
+		res, status := get(tt.path, tt.accept)
+		if status != tt.status || res != tt.response {
+			t.Errorf("#%d: want status %d and %s, got %d and %s", i, tt.status, tt.response, status, res)
+		}
+	}
+}
+
+func TestSecureOnPassword(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	var passwords []string
+	api := Server{
+		wakeFunc: func(_ net.HardwareAddr, opts wol.Options) error {
+			passwords = append(passwords, net.HardwareAddr(opts.Password).String())
+			return nil
+		},
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"secureOnPassword":"01:02:03"}`); err != nil || status != 400 {
+		t.Fatalf("want status 400, got %d (%v)", status, err)
+	}
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"secureOnPassword":"01:02:03:04:05:06"}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	for _, body := range []string{
+		`{"macAddress":"AC:CD:EF:12:34:56"}`,
+		// The password of the request overrides the stored password
+		`{"macAddress":"AC:CD:EF:12:34:56","secureOnPassword":"0a-0b-0c-0d-0e-0f"}`,
+		`{"macAddress":"AC:CD:EF:12:34:57"}`,
+	} {
+		if _, status, err := httpPost(server.URL+"/api/v1/wake", body); err != nil || status != 204 {
+			t.Fatalf("want status 204, got %d (%v)", status, err)
+		}
+	}
+	if want := "[01:02:03:04:05:06 0a:0b:0c:0d:0e:0f ]"; fmt.Sprint(passwords) != want {
+		t.Errorf("want passwords %s, got %v", want, passwords)
+	}
+	res, status, err := httpPost(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:57","secureOnPassword":"foo"}`)
+	if want := `{"status":400,"message":"Invalid SecureOn password: foo"}`; err != nil || status != 400 || res != want {
+		t.Errorf("want status 400 and %s, got %d and %s (%v)", want, status, res, err)
+	}
+}
+
+func TestWakeAddress(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	var sent []string
+	api := Server{
+		wakeFunc: func(_ net.HardwareAddr, opts wol.Options) error {
+			dst := opts.Destination.String()
+			if opts.Interface != "" {
+				dst += "%" + opts.Interface
+			}
+			sent = append(sent, fmt.Sprintf("%s:%d", dst, opts.Port))
+			return nil
+		},
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for _, body := range []string{`{"wakeAddress":"foo"}`, `{"wakePort":65536}`} {
+		if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", body); err != nil || status != 400 {
+			t.Fatalf("want status 400, got %d (%v)", status, err)
+		}
+	}
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"wakeAddress":"192.168.2.0/24","wakePort":7}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	for _, body := range []string{
+		`{"macAddress":"AC:CD:EF:12:34:56"}`,
+		`{"macAddress":"AC:CD:EF:12:34:56","wakeAddress":"10.0.0.2"}`,
+		`{"macAddress":"AC:CD:EF:12:34:56","wakeAddress":"ff02::1%eth0"}`,
+		`{"macAddress":"AC:CD:EF:12:34:57"}`,
+	} {
+		if _, status, err := httpPost(server.URL+"/api/v1/wake", body); err != nil || status != 204 {
+			t.Fatalf("want status 204, got %d (%v)", status, err)
+		}
+	}
+	if want := "[192.168.2.255:7 10.0.0.2:7 ff02::1%eth0:7 <nil>:0]"; fmt.Sprint(sent) != want {
+		t.Errorf("want packets sent to %s, got %v", want, sent)
+	}
+}
+
+func TestImport(t *testing.T) {
+	server, cacheFile := testServer()
+	defer os.Remove(cacheFile)
+	defer os.Remove(cacheFile + ".journal")
+	defer server.Close()
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"foo"}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	for _, tt := range []struct {
+		body string
+		want string
+	}{
+		{"", `{"status":400,"message":"Missing CSV header"}`},
+		{"name\nfoo\n", `{"status":400,"message":"Missing column \"macAddress\""}`},
+		{"macAddress,foo\n", `{"status":400,"message":"Unknown column \"foo\", must be one of macAddress, name, description, ipAddress, groups, platform"}`},
+		{"macAddress,macAddress\n", `{"status":400,"message":"Duplicate column \"macAddress\""}`},
+	} {
+		res, status, err := httpPost(server.URL+"/api/v1/import", tt.body)
+		if err != nil || status != 400 || res != tt.want {
+			t.Errorf("want status 400 and %s, got %d and %s (%v)", tt.want, status, res, err)
+		}
+	}
+	csv := "macAddress,name,ipAddress,groups\n" +
+		"ac:cd:ef:12:34:56,bar,,office;lab\n" +
+		"AC:CD:EF:12:34:56,baz,,\n" +
+		"AC:CD:EF:12:34:57,,10.0.0.2,\n" +
+		"foo,,,\n" +
+		"AC:CD:EF:12:34:58,,10.0.0,\n" +
+		"AC:CD:EF:12:34:59\n"
+	report := `{"dryRun":%t,"created":1,"updated":1,"skipped":0,"errors":4,"rows":[` +
+		`{"row":2,"macAddress":"AC:CD:EF:12:34:56","result":"updated"},` +
+		`{"row":3,"macAddress":"AC:CD:EF:12:34:56","result":"error","reason":"Duplicate of row 2"},` +
+		`{"row":4,"macAddress":"AC:CD:EF:12:34:57","result":"created"},` +
+		`{"row":5,"macAddress":"foo","result":"error","reason":"Invalid MAC address: foo"},` +
+		`{"row":6,"macAddress":"AC:CD:EF:12:34:58","result":"error","reason":"Invalid IP address: 10.0.0"},` +
+		`{"row":7,"result":"error","reason":"Row has 1 fields, want 4"}]}`
+	res, status, err := httpPost(server.URL+"/api/v1/import?dryRun=true", csv)
+	if want := fmt.Sprintf(report, true); err != nil || status != 200 || res != want {
+		t.Fatalf("want status 200 and %s, got %d and %s (%v)", want, status, res, err)
+	}
+	res, _, _ = httpGet(server.URL + "/api/v1/wake")
+	if strings.Contains(res, "AC:CD:EF:12:34:57") || strings.Contains(res, "office") {
+		t.Fatalf("dry run changed devices: %s", res)
+	}
+	res, status, err = httpPost(server.URL+"/api/v1/import", csv)
+	if want := fmt.Sprintf(report, false); err != nil || status != 200 || res != want {
+		t.Fatalf("want status 200 and %s, got %d and %s (%v)", want, status, res, err)
+	}
+	res, _, _ = httpGet(server.URL + "/api/v1/wake")
+	for _, want := range []string{`"name":"bar"`, `"groups":["office","lab"]`, `"macAddress":"AC:CD:EF:12:34:57"`, `"ipAddress":"10.0.0.2"`} {
+		if !strings.Contains(res, want) {
+			t.Errorf("want %s in %s", want, res)
+		}
+	}
+	// Importing the same rows again leaves devices unchanged
+	res, status, err = httpPost(server.URL+"/api/v1/import", "macAddress,name\nAC:CD:EF:12:34:56,bar\n")
+	if want := `{"dryRun":false,"created":0,"updated":0,"skipped":1,"errors":0,"rows":[{"row":2,"macAddress":"AC:CD:EF:12:34:56","result":"skipped","reason":"Device is unchanged"}]}`; err != nil || status != 200 || res != want {
+		t.Errorf("want status 200 and %s, got %d and %s (%v)", want, status, res, err)
+	}
+	if _, status, err := httpGet(server.URL + "/api/v1/import"); err != nil || status != 405 {
+		t.Errorf("want status 405, got %d (%v)", status, err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	config := file.Name() + ".templates"
+	defer os.Remove(config)
+	for _, tt := range []struct {
+		config string
+		err    string
+	}{
+		{`{"templates":[{}]}`, "template #0: missing name"},
+		{`{"templates":[{"name":"a"},{"name":"a"}]}`, "template a: duplicate name"},
+		{`{"templates":[{"name":"a","probePorts":[0]}]}`, "template a: Invalid probe port: 0"},
+		{`{"templates":[{"name":"OptiPlex","platform":"windows","probePorts":[3389],"wakePort":7,"groups":["lab"]}]}`, ""},
+	} {
+		if err := ioutil.WriteFile(config, []byte(tt.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ReadTemplates(config)
+		if got := fmt.Sprint(err); (tt.err == "" && err != nil) || (tt.err != "" && got != tt.err) {
+			t.Errorf("ReadTemplates(%s): want error %q, got %q", tt.config, tt.err, got)
+		}
+	}
+	templates, err := ReadTemplates(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ports []int
+	api := Server{
+		wakeFunc:  func(_ net.HardwareAddr, opts wol.Options) error { ports = append(ports, opts.Port); return nil },
+		cacheFile: file.Name(),
+		Templates: templates,
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	res, status, err := httpGet(server.URL + "/api/v1/templates")
+	if want := `{"templates":[{"name":"OptiPlex","groups":["lab"],"probePorts":[3389],"platform":"windows","wakePort":7}]}`; err != nil || status != 200 || res != want {
+		t.Errorf("want status 200 and %s, got %d and %s (%v)", want, status, res, err)
+	}
+	res, status, err = httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56?template=foo", `{}`)
+	if want := `{"status":400,"message":"Template not found: foo"}`; err != nil || status != 400 || res != want {
+		t.Errorf("want status 400 and %s, got %d and %s (%v)", want, status, res, err)
+	}
+	// Fields of the body take precedence over those of the template
+	res, status, err = httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56?template=OptiPlex", `{"name":"pc1","wakePort":9}`)
+	if err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d and %s (%v)", status, res, err)
+	}
+	for _, want := range []string{`"name":"pc1"`, `"groups":["lab"]`, `"probePorts":[3389]`, `"platform":"windows"`, `"wakePort":9`} {
+		if !strings.Contains(res, want) {
+			t.Errorf("want %s in %s", want, res)
+		}
+	}
+	if _, status, err := httpPost(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:57","name":"pc2","template":"OptiPlex"}`); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	res, _, _ = httpGet(server.URL + "/api/v1/devices/AC:CD:EF:12:34:57")
+	for _, want := range []string{`"name":"pc2"`, `"groups":["lab"]`, `"platform":"windows"`, `"wakePort":7`} {
+		if !strings.Contains(res, want) {
+			t.Errorf("want %s in %s", want, res)
+		}
+	}
+	// Templates only apply to new devices
+	if _, status, err := httpPost(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56","template":"OptiPlex"}`); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	if want := "[7 9]"; fmt.Sprint(ports) != want {
+		t.Errorf("want packets sent to ports %s, got %v", want, ports)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	var sent []string
+	api := Server{
+		wakeFunc: func(_ net.HardwareAddr, opts wol.Options) error {
+			sent = append(sent, opts.Transport+"/"+opts.Interface)
+			return nil
+		},
+		cacheFile: file.Name(),
+		Transport: wol.TransportUDP,
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for _, body := range []string{`{"transport":"foo"}`, `{"wakeInterface":"eth 0"}`} {
+		if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", body); err != nil || status != 400 {
+			t.Fatalf("want status 400, got %d (%v)", status, err)
+		}
+	}
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"transport":"ethernet","wakeInterface":"eth1"}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	for _, body := range []string{
+		`{"macAddress":"AC:CD:EF:12:34:56"}`,
+		`{"macAddress":"AC:CD:EF:12:34:56","transport":"udp"}`,
+		`{"macAddress":"AC:CD:EF:12:34:57"}`,
+	} {
+		if _, status, err := httpPost(server.URL+"/api/v1/wake", body); err != nil || status != 204 {
+			t.Fatalf("want status 204, got %d (%v)", status, err)
+		}
+	}
+	if want := "[ethernet/eth1 udp/eth1 udp/]"; fmt.Sprint(sent) != want {
+		t.Errorf("want packets sent by %s, got %v", want, sent)
+	}
+}
+
+func TestClone(t *testing.T) {
+	server, cacheFile := testServer()
+	defer os.Remove(cacheFile)
+	defer os.Remove(cacheFile + ".journal")
+	defer server.Close()
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"lab1","ipAddress":"10.0.0.1","groups":["lab"],"probePorts":[3389],"wakePort":7,"macAddresses":["AC:CD:EF:12:34:60"]}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	var tests = []struct {
+		url    string
+		body   string
+		status int
+		res    string
+	}{
+		{"/api/v1/devices/AC:CD:EF:12:34:56/clone", `{}`, 400, `{"status":400,"message":"Missing MAC address"}`},
+		{"/api/v1/devices/AC:CD:EF:12:34:56/clone", `{"macAddress":"foo"}`, 400, `{"status":400,"message":"Invalid MAC address: foo"}`},
+		{"/api/v1/devices/AC:CD:EF:12:34:57/clone", `{"macAddress":"AC:CD:EF:12:34:58"}`, 404, `{"status":404,"message":"Device not found: AC:CD:EF:12:34:57"}`},
+		{"/api/v1/devices/AC:CD:EF:12:34:56/clone", `{"macAddress":"ac:cd:ef:12:34:60"}`, 409, ""},
+		{"/api/v1/devices/AC:CD:EF:12:34:56/clone", `{"macAddress":"ac:cd:ef:12:34:58","name":"lab2","ipAddress":"10.0.0.2"}`, 201, ""},
+	}
+	for _, tt := range tests {
+		res, status, err := httpPost(server.URL+tt.url, tt.body)
+		if err != nil || status != tt.status || (tt.res != "" && res != tt.res) {
+			t.Errorf("POST %s %s: want status %d and %s, got %d and %s (%v)", tt.url, tt.body, tt.status, tt.res, status, res, err)
+		}
+	}
+	res, _, _ := httpGet(server.URL + "/api/v1/devices/AC:CD:EF:12:34:58")
+	for _, want := range []string{`"name":"lab2"`, `"macAddress":"AC:CD:EF:12:34:58"`, `"macAddresses":[]`, `"ipAddress":"10.0.0.2"`, `"groups":["lab"]`, `"probePorts":[3389]`, `"wakePort":7`} {
+		if !strings.Contains(res, want) {
+			t.Errorf("want %s in %s", want, res)
+		}
+	}
+	if strings.Contains(res, `"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6"`) {
+		t.Errorf("want clone to have a new ID, got %s", res)
+	}
+}
+
+func TestValidateMAC(t *testing.T) {
+	server, cacheFile := testServer()
+	defer os.Remove(cacheFile)
+	defer server.Close()
+	var tests = []struct {
+		method string
+		body   string
+		status int
+		res    string
+	}{
+		{http.MethodPost, `{"macAddress":"b8-27-eb-12-34-56"}`, 200,
+			`{"input":"b8-27-eb-12-34-56","valid":true,"macAddress":"B8:27:EB:12:34:56","format":"hyphen","vendor":"Raspberry Pi Foundation","locallyAdministered":false,"multicast":false,"wakeable":true}`},
+		{http.MethodPost, `{"macAddress":"００１ｂ．２１１２．３４５６"}`, 200,
+			`{"input":"００１ｂ．２１１２．３４５６","valid":true,"macAddress":"00:1B:21:12:34:56","format":"dot","vendor":"Intel Corporate","locallyAdministered":false,"multicast":false,"wakeable":true}`},
+		{http.MethodPost, `{"macAddress":"02:00:00:12:34:56"}`, 200,
+			`{"input":"02:00:00:12:34:56","valid":true,"macAddress":"02:00:00:12:34:56","format":"colon","locallyAdministered":true,"multicast":false,"wakeable":true}`},
+		{http.MethodPost, `{"macAddress":"ff:ff:ff:ff:ff:ff"}`, 200,
+			`{"input":"ff:ff:ff:ff:ff:ff","valid":true,"macAddress":"FF:FF:FF:FF:FF:FF","format":"colon","locallyAdministered":true,"multicast":true,"wakeable":false}`},
+		{http.MethodPost, `{"macAddress":"AC:CD:EF"}`, 200,
+			`{"input":"AC:CD:EF","valid":false,"message":"Invalid MAC address: AC:CD:EF","locallyAdministered":false,"multicast":false,"wakeable":false}`},
+		{http.MethodPost, `{}`, 400, `{"status":400,"message":"Missing MAC address"}`},
+		{http.MethodGet, ``, 405, `{"status":405,"message":"Invalid method GET, must be POST"}`},
+	}
+	for _, tt := range tests {
+		res, status, err := httpRequest(tt.method, server.URL+"/api/v1/validate/mac", tt.body)
+		if err != nil || status != tt.status || res != tt.res {
+			t.Errorf("%s %s: want status %d and %s, got %d and %s (%v)", tt.method, tt.body, tt.status, tt.res, status, res, err)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	var scanned []string
+	api := Server{
+		cacheFile: file.Name(),
+		scanFunc: func(ctx context.Context, subnets []*net.IPNet) ([]discover.Host, error) {
+			scanned = nil
+			for _, n := range subnets {
+				scanned = append(scanned, n.String())
+			}
+			return []discover.Host{
+				{IPAddress: "10.0.0.2", MACAddress: "AC:CD:EF:12:34:56"},
+				{IPAddress: "10.0.0.3", MACAddress: "B8:27:EB:12:34:56", Hostname: "pi.local", Vendor: "Raspberry Pi Foundation"},
+			}, nil
+		},
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"nas"}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	var tests = []struct {
+		method string
+		url    string
+		status int
+		res    string
+	}{
+		{http.MethodPost, "/api/v1/discover/B8:27:EB:12:34:56", 404,
+			`{"status":404,"message":"Host not discovered: B8:27:EB:12:34:56","hint":"Scan the network with GET /api/v1/discover first"}`},
+		{http.MethodGet, "/api/v1/discover?subnet=10.0.0.0/24", 200,
+			`{"hosts":[{"ipAddress":"10.0.0.2","macAddress":"AC:CD:EF:12:34:56","device":"fc2b1229-4d81-5601-88f3-99f11ef28aa6"},` +
+				`{"ipAddress":"10.0.0.3","macAddress":"B8:27:EB:12:34:56","hostname":"pi.local","vendor":"Raspberry Pi Foundation"}]}`},
+		{http.MethodGet, "/api/v1/discover?subnet=10.0.0.0/16", 400, `{"status":400,"message":"Invalid value for subnet: 10.0.0.0/16, must have at most 1024 addresses"}`},
+		{http.MethodGet, "/api/v1/discover?subnet=fe80::/64", 400, `{"status":400,"message":"Invalid value for subnet: fe80::/64"}`},
+		{http.MethodPost, "/api/v1/discover", 405, `{"status":405,"message":"Invalid method POST, must be GET"}`},
+		{http.MethodPost, "/api/v1/discover/foo", 400, `{"status":400,"message":"Invalid MAC address: foo"}`},
+		{http.MethodPost, "/api/v1/discover/ac:cd:ef:12:34:56", 409, `{"status":409,"message":"MAC address AC:CD:EF:12:34:56 belongs to device fc2b1229-4d81-5601-88f3-99f11ef28aa6"}`},
+		{http.MethodPost, "/api/v1/discover/b8:27:eb:12:34:56", 201, ""},
+	}
+	for _, tt := range tests {
+		res, status, err := httpRequest(tt.method, server.URL+tt.url, "")
+		if err != nil || status != tt.status || (tt.res != "" && res != tt.res) {
+			t.Errorf("%s %s: want status %d and %s, got %d and %s (%v)", tt.method, tt.url, tt.status, tt.res, status, res, err)
+		}
+	}
+	if len(scanned) != 1 || scanned[0] != "10.0.0.0/24" {
+		t.Errorf("want 10.0.0.0/24 scanned, got %v", scanned)
+	}
+	// The added device is named by its hostname
+	res, _, _ := httpGet(server.URL + "/api/v1/devices/B8:27:EB:12:34:56")
+	for _, want := range []string{`"name":"pi"`, `"ipAddress":"10.0.0.3"`, `"hostname":"pi.local"`} {
+		if !strings.Contains(res, want) {
+			t.Errorf("want %s in %s", want, res)
+		}
+	}
+}
+
+func TestInterface(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	routes, err := wol.ParseRoutes([]string{"10.1.0.0/16=10.1.0.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []string
+	api := Server{
+		wakeFunc: func(_ net.HardwareAddr, opts wol.Options) error {
+			sent = append(sent, fmt.Sprintf("%s/%s", opts.Source, opts.Interface))
+			return nil
+		},
+		cacheFile: file.Name(),
+		Routes:    routes,
+		Interface: "macvlan0",
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for _, body := range []string{
+		`{"macAddress":"AC:CD:EF:12:34:56"}`,
+		`{"macAddress":"AC:CD:EF:12:34:57","ipAddress":"10.1.2.3"}`,
+		`{"macAddress":"AC:CD:EF:12:34:58","wakeInterface":"eth1"}`,
+	} {
+		if _, status, err := httpPost(server.URL+"/api/v1/wake", body); err != nil || status != 204 {
+			t.Fatalf("want status 204, got %d (%v)", status, err)
+		}
+	}
+	if want := "[<nil>/macvlan0 10.1.0.5/ <nil>/eth1]"; fmt.Sprint(sent) != want {
+		t.Errorf("want packets sent from %s, got %v", want, sent)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	probed := 0
+	api := Server{
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+		Health:    health.NewTracker(),
+		waitFunc: func(ctx context.Context, probes []wait.Probe) wait.Result {
+			probed++
+			if strings.Contains(probes[0].Address, "10.0.0.1") {
+				return wait.Result{Status: wait.StatusOnline}
+			}
+			return wait.Result{Status: wait.StatusTimeout}
+		},
+	}
+	api.Health.Handle(event.Event{Type: event.Wake, MACAddress: "AC:CD:EF:12:34:57", Time: time.Now().Add(-time.Hour)})
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for mac, body := range map[string]string{
+		"AC:CD:EF:12:34:56": `{"name":"nas","ipAddress":"10.0.0.1","groups":["server"]}`,
+		"AC:CD:EF:12:34:57": `{"name":"build","ipAddress":"10.0.0.2","groups":["server"]}`,
+		"AC:CD:EF:12:34:58": `{"name":"desktop","ipAddress":"10.0.0.3"}`,
+	} {
+		if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/"+mac, body); err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d (%v)", status, err)
+		}
+	}
+	var tests = []struct {
+		q     string
+		names string
+	}{
+		{"tag=server", "nas build"},
+		{"name~s OR name=desktop", "nas desktop"},
+		{"NOT group=server", "desktop"},
+		{"lastWake<24h", "build"},
+		{"state=offline AND tag=server AND lastWake<24h", "build"},
+		{"state=online", "nas"},
+	}
+	for _, tt := range tests {
+		res, status, err := httpGet(server.URL + "/api/v1/wake?q=" + url.QueryEscape(tt.q))
+		if err != nil || status != 200 {
+			t.Fatalf("%s: want status 200, got %d (%v)", tt.q, status, err)
+		}
+		var devices Devices
+		if err := json.Unmarshal([]byte(res), &devices); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, d := range devices.Devices {
+			names = append(names, d.Name)
+		}
+		sort.Strings(names)
+		want := strings.Fields(tt.names)
+		sort.Strings(want)
+		if fmt.Sprint(names) != fmt.Sprint(want) {
+			t.Errorf("%s: want devices %v, got %v", tt.q, want, names)
+		}
+	}
+	// Only searches by state probe devices, whose statuses are cached
+	if probed != 3 {
+		t.Errorf("want 3 devices probed, got %d", probed)
+	}
+	res, status, err := httpGet(server.URL + "/api/v1/wake?q=" + url.QueryEscape("foo=bar"))
+	if want := `{"status":400,"message":"Invalid query: unknown field: foo"}`; err != nil || status != 400 || res != want {
+		t.Errorf("want status 400 and %s, got %d and %s (%v)", want, status, res, err)
+	}
+}
+
+func TestBurst(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	var sent []string
+	api := Server{
+		wakeFunc: func(_ net.HardwareAddr, opts wol.Options) error {
+			sent = append(sent, fmt.Sprintf("%d/%s/%d", opts.Count, opts.Interval, opts.Retries))
+			return nil
+		},
+		cacheFile:      file.Name(),
+		Packets:        1,
+		PacketInterval: 100 * time.Millisecond,
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	var tests = []struct {
+		body   string
+		status int
+		sent   string
+	}{
+		{`{"macAddress":"AC:CD:EF:12:34:56"}`, 204, "1/100ms/0"},
+		{`{"macAddress":"AC:CD:EF:12:34:56","packets":3}`, 204, "3/100ms/0"},
+		{`{"macAddress":"AC:CD:EF:12:34:56","packets":5,"packetInterval":"1s","retries":2}`, 204, "5/1s/2"},
+		{`{"macAddress":"AC:CD:EF:12:34:56","retries":0}`, 204, "1/100ms/0"},
+		{`{"macAddress":"AC:CD:EF:12:34:56","packets":11}`, 400, ""},
+		{`{"macAddress":"AC:CD:EF:12:34:56","packets":-1}`, 400, ""},
+		{`{"macAddress":"AC:CD:EF:12:34:56","packetInterval":"1m"}`, 400, ""},
+		{`{"macAddress":"AC:CD:EF:12:34:56","packetInterval":"foo"}`, 400, ""},
+		{`{"macAddress":"AC:CD:EF:12:34:56","retries":6}`, 400, ""},
+	}
+	for i, tt := range tests {
+		sent = nil
+		if _, status, err := httpPost(server.URL+"/api/v1/wake", tt.body); err != nil || status != tt.status {
+			t.Errorf("#%d: want status %d, got %d (%v)", i, tt.status, status, err)
+		}
+		if got := strings.Join(sent, ","); got != tt.sent {
+			t.Errorf("#%d: want burst %q, got %q", i, tt.sent, got)
+		}
+	}
+}
+
+func TestBulk(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	api := Server{cacheFile: file.Name()}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	ids := make(map[string]string)
+	for _, body := range []string{
+		`{"name":"nas","macAddress":"AC:CD:EF:12:34:56","groups":["server"]}`,
+		`{"name":"build","macAddress":"AC:CD:EF:12:34:57","groups":["server","ci"]}`,
+		`{"name":"desktop","macAddress":"AC:CD:EF:12:34:58"}`,
+	} {
+		data, status, err := httpPost(server.URL+"/api/v1/devices", body)
+		if err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d (%v)", status, err)
+		}
+		var d DeviceResource
+		if err := json.Unmarshal([]byte(data), &d); err != nil {
+			t.Fatal(err)
+		}
+		ids[d.Name] = d.ID
+	}
+	bulk := func(op, filter, confirm, token, body string) (BulkResult, string, int) {
+		u := server.URL + "/api/v1/admin/devices:" + op + "?filter=" + url.QueryEscape(filter)
+		if confirm != "" {
+			u += "&confirm=" + confirm
+		}
+		r, err := http.NewRequest(http.MethodPost, u, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result BulkResult
+		if res.StatusCode == 200 {
+			if err := json.Unmarshal(data, &result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return result, string(data), res.StatusCode
+	}
+	groups := func() map[string][]string {
+		data, status, err := httpGet(server.URL + "/api/v1/devices")
+		if err != nil || status != 200 {
+			t.Fatalf("want status 200, got %d (%v)", status, err)
+		}
+		var res DeviceResources
+		if err := json.Unmarshal([]byte(data), &res); err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string][]string)
+		for _, d := range res.Devices {
+			m[d.Name] = d.Groups
+		}
+		return m
+	}
+
+	// Disabled without admin token
+	if _, _, status := bulk(bulkDelete, "", "", "", ""); status != 404 {
+		t.Fatalf("want status 404, got %d", status)
+	}
+	api.AdminToken = "secret"
+	var tests = []struct {
+		op       string
+		filter   string
+		token    string
+		body     string
+		response string
+		status   int
+	}{
+		{bulkDelete, "", "foo", "", `{"status":401,"message":"Invalid admin token"}`, 401},
+		{bulkDelete, "name=", "secret", "", `{"status":400,"message":"Invalid query: `, 400},
+		{bulkDelete, "", "secret", `{"add":["foo"]}`, `{"status":400,"message":"Devices are deleted without changes"}`, 400},
+		{bulkTag, "", "secret", `{}`, `{"status":400,"message":"Missing tags to add or remove"}`, 400},
+		{bulkTag, "", "secret", `{"add":[" "]}`, `{"status":400,"message":"Invalid group: \" \""}`, 400},
+		{bulkGroup, "", "secret", `{"add":["foo"]}`, `{"status":400,"message":"Tags are added and removed by bulkTag"}`, 400},
+		{bulkTag, "", "secret", `[`, `{"status":400,"message":"Malformed JSON"}`, 400},
+	}
+	for i, tt := range tests {
+		_, data, status := bulk(tt.op, tt.filter, "", tt.token, tt.body)
+		if status != tt.status || !strings.HasPrefix(data, tt.response) {
+			t.Errorf("#%d: want %d %q, got %d %q", i, tt.status, tt.response, status, data)
+		}
+	}
+
+	// Operations are previewed without changing devices
+	preview, _, status := bulk(bulkTag, "tag=server", "", "secret", `{"add":["rack"],"remove":["ci"]}`)
+	if want := []string{ids["nas"], ids["build"]}; status != 200 || preview.Applied || preview.Token == "" || !reflect.DeepEqual(preview.Devices, want) {
+		t.Fatalf("want preview of %v, got %d %+v", want, status, preview)
+	}
+	if want := map[string][]string{"nas": {"server"}, "build": {"server", "ci"}, "desktop": {}}; !reflect.DeepEqual(groups(), want) {
+		t.Errorf("want %v, got %v", want, groups())
+	}
+	// Tokens only confirm the operation they were previewed for
+	if _, data, status := bulk(bulkTag, "tag=server", "foo", "secret", `{"add":["rack"],"remove":["ci"]}`); status != 409 {
+		t.Errorf("want status 409, got %d %q", status, data)
+	}
+	if _, data, status := bulk(bulkTag, "", preview.Token, "secret", `{"add":["rack"],"remove":["ci"]}`); status != 409 {
+		t.Errorf("want status 409, got %d %q", status, data)
+	}
+	result, _, status := bulk(bulkTag, "tag=server", preview.Token, "secret", `{"add":["rack"],"remove":["ci"]}`)
+	if status != 200 || !result.Applied || result.Token != "" || !reflect.DeepEqual(result.Devices, preview.Devices) {
+		t.Errorf("want applied %v, got %d %+v", preview.Devices, status, result)
+	}
+	if want := map[string][]string{"nas": {"server", "rack"}, "build": {"server", "rack"}, "desktop": {}}; !reflect.DeepEqual(groups(), want) {
+		t.Errorf("want %v, got %v", want, groups())
+	}
+	// Tokens are not accepted once devices changed
+	if _, data, status := bulk(bulkTag, "tag=server", preview.Token, "secret", `{"add":["rack"],"remove":["ci"]}`); status != 409 {
+		t.Errorf("want status 409, got %d %q", status, data)
+	}
+
+	// Groups are assigned to all devices without a filter, skipping devices already having them
+	preview, _, _ = bulk(bulkGroup, "", "", "secret", `{"groups":["rack","server"]}`)
+	if want := []string{ids["nas"], ids["build"], ids["desktop"]}; !reflect.DeepEqual(preview.Devices, want) {
+		t.Errorf("want %v, got %v", want, preview.Devices)
+	}
+	if _, _, status := bulk(bulkGroup, "", preview.Token, "secret", `{"groups":["rack","server"]}`); status != 200 {
+		t.Errorf("want status 200, got %d", status)
+	}
+	if want := map[string][]string{"nas": {"rack", "server"}, "build": {"rack", "server"}, "desktop": {"rack", "server"}}; !reflect.DeepEqual(groups(), want) {
+		t.Errorf("want %v, got %v", want, groups())
+	}
+
+	// Deleting devices
+	preview, _, _ = bulk(bulkDelete, "name=build OR name=desktop", "", "secret", "")
+	if want := []string{ids["build"], ids["desktop"]}; !reflect.DeepEqual(preview.Devices, want) {
+		t.Errorf("want %v, got %v", want, preview.Devices)
+	}
+	if _, _, status := bulk(bulkDelete, "name=build OR name=desktop", preview.Token, "secret", ""); status != 200 {
+		t.Errorf("want status 200, got %d", status)
+	}
+	if want := map[string][]string{"nas": {"rack", "server"}}; !reflect.DeepEqual(groups(), want) {
+		t.Errorf("want %v, got %v", want, groups())
+	}
+}
+
+func TestSmartGroups(t *testing.T) {
+	server, cacheFile := testServer()
+	defer os.Remove(cacheFile)
+	defer os.Remove(cacheFile + ".journal")
+	defer server.Close()
+	for mac, body := range map[string]string{
+		"AC:CD:EF:12:34:56": `{"name":"nas","groups":["server"],"watts":40}`,
+		"AC:CD:EF:12:34:57": `{"name":"build","groups":["server"],"watts":200}`,
+		"AC:CD:EF:12:34:58": `{"name":"desktop","watts":150,"groups":["office"]}`,
+	} {
+		if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/"+mac, body); err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d (%v)", status, err)
+		}
+	}
+	var tests = []struct {
+		method string
+		url    string
+		body   string
+		status int
+		out    string
+	}{
+		{http.MethodGet, "/api/v1/smart-groups", "", 200, `{"smartGroups":[]}`},
+		{http.MethodPut, "/api/v1/smart-groups/hungry", `{"query":"watts>100"}`, 201, `{"name":"hungry","query":"watts\u003e100"}`},
+		{http.MethodPut, "/api/v1/smart-groups/hungry", `{"query":"watts>=150"}`, 200, `{"name":"hungry","query":"watts\u003e=150"}`},
+		{http.MethodPut, "/api/v1/smart-groups/bad", `{"query":"foo=bar"}`, 400, `{"status":400,"message":"Invalid query: unknown field: foo"}`},
+		{http.MethodPut, "/api/v1/smart-groups/bad", `{"query":""}`, 400, `{"status":400,"message":"Invalid query: empty query"}`},
+		{http.MethodPut, "/api/v1/smart-groups/a%20b", `{"query":"watts>1"}`, 400, `{"status":400,"message":"Invalid smart group name: a b"}`},
+		{http.MethodGet, "/api/v1/smart-groups/hungry", "", 200, `{"name":"hungry","query":"watts\u003e=150"}`},
+		{http.MethodGet, "/api/v1/smart-groups/foo", "", 404, `{"status":404,"message":"Smart group not found: foo"}`},
+		{http.MethodGet, "/api/v1/smart-groups", "", 200, `{"smartGroups":[{"name":"hungry","query":"watts\u003e=150"}]}`},
+		{http.MethodGet, "/api/v1/groups/hungry", "", 200, `{"id":"hungry","name":"hungry","members":["9a18bd6c-44a4-5ed1-9ea6-139be112e3a6","5a498c7c-40ee-524d-98e9-de282f913dbf"],"query":"watts\u003e=150"}`},
+		{http.MethodPut, "/api/v1/groups/hungry", `{"members":[]}`, 409, `{"status":409,"message":"Members of smart group hungry are given by its query"}`},
+		{http.MethodPut, "/api/v1/smart-groups/empty", `{"query":"watts>1000"}`, 201, `{"name":"empty","query":"watts\u003e1000"}`},
+		{http.MethodPost, "/api/v1/groups/empty/wake", "", 200, `{"group":"empty","simulated":false,"wakes":[]}`},
+		{http.MethodDelete, "/api/v1/smart-groups/empty", "", 204, ""},
+		{http.MethodPost, "/api/v1/groups/empty/wake", "", 404, `{"status":404,"message":"Group not found: empty"}`},
+		{http.MethodPost, "/api/v1/smart-groups", "", 405, `{"status":405,"message":"Invalid method POST, must be GET"}`},
+	}
+	for _, tt := range tests {
+		out, status, err := httpRequest(tt.method, server.URL+tt.url, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("%s %s: want status %d, got %d", tt.method, tt.url, tt.status, status)
+		}
+		if out != tt.out {
+			t.Errorf("%s %s: want response %s, got %s", tt.method, tt.url, tt.out, out)
+		}
+	}
+	res, status, err := httpRequest(http.MethodPost, server.URL+"/api/v1/groups/hungry/wake?simulate=true", "")
+	if err != nil || status != 200 {
+		t.Fatalf("want status 200, got %d (%v)", status, err)
+	}
+	var plan GroupWake
+	if err := json.Unmarshal([]byte(res), &plan); err != nil {
+		t.Fatal(err)
+	}
+	var macs []string
+	for _, w := range plan.Wakes {
+		macs = append(macs, w.MACAddress)
+	}
+	if want := "[AC:CD:EF:12:34:57 AC:CD:EF:12:34:58]"; fmt.Sprint(macs) != want {
+		t.Errorf("want wakes of %s, got %v", want, macs)
+	}
+}
+
+func TestPower(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var off []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var target power.Target
+		json.NewDecoder(r.Body).Decode(&target)
+		if target.Name == "broken" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		off = append(off, target.MACAddress)
+	}))
+	defer hook.Close()
+	woken := 0
+	api := Server{
+		Auth:      testAuth{"admin": "admin", "alice": "secret"},
+		History:   history.Open(filepath.Join(dir, "history")),
+		Power:     power.NewController(),
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { woken++; return nil },
+		cacheFile: filepath.Join(dir, "cache"),
+	}
+	api.Power.Actions = map[string]power.Action{"hook": {Type: power.TypeHTTP, URL: hook.URL}}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	shutdown := `"shutdown":"hook"`
+	var tests = []struct {
+		method string
+		url    string
+		body   string
+		status int
+		out    string
+	}{
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"nas",` + shutdown + `}`, 201, ""},
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:57", `{"name":"broken",` + shutdown + `}`, 201, ""},
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:58", `{"name":"pc"}`, 201, ""},
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:59", `{"shutdown":"rack"}`, 400,
+			`{"status":400,"message":"Invalid shutdown: unknown action rack"}`},
+		{http.MethodGet, "/api/v1/devices/AC:CD:EF:12:34:56/power", "", 200,
+			`{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","macAddress":"AC:CD:EF:12:34:56","state":"unknown","status":"unknown","powerOff":true}`},
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:56/power", `{"state":"on"}`, 204, ""},
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:56/power", `{"state":"off"}`, 204, ""},
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:57/power", `{"state":"off"}`, 502,
+			`{"status":502,"message":"Failed to power off device with address AC:CD:EF:12:34:57","cause":"got status 503"}`},
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:58/power", `{"state":"off"}`, 409,
+			`{"status":409,"message":"Device 5a498c7c-40ee-524d-98e9-de282f913dbf has no shutdown action"}`},
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:58/power", `{"state":"reboot"}`, 400, `{"status":400,"message":"Invalid value for state: reboot"}`},
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:59/power", `{"state":"on"}`, 404, `{"status":404,"message":"Device not found: AC:CD:EF:12:34:59"}`},
+		{http.MethodPost, "/api/v1/devices/AC:CD:EF:12:34:56/power", "", 405, `{"status":405,"message":"Invalid method POST, must be GET or PUT"}`},
+	}
+	for _, tt := range tests {
+		out, status, err := httpRequestAs(tt.method, server.URL+tt.url, tt.body, "admin", "admin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("%s %s: want status %d, got %d", tt.method, tt.url, tt.status, status)
+		}
+		if tt.out != "" && out != tt.out {
+			t.Errorf("%s %s: want response %s, got %s", tt.method, tt.url, tt.out, out)
+		}
+	}
+	// Users managing their devices cannot assign shutdown actions
+	for _, body := range []string{`{"name":"desktop",` + shutdown + `}`, `{"name":"desktop"}`, `{"name":"desktop",` + shutdown + `}`} {
+		want := 403
+		if body == `{"name":"desktop"}` {
+			want = 201
+		}
+		if out, status, err := httpRequestAs(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:5B", body, "alice", "secret"); err != nil || status != want {
+			t.Errorf("%s: want status %d, got %d: %s (%v)", body, want, status, out, err)
+		}
+	}
+	if woken != 1 {
+		t.Errorf("want 1 wake, got %d", woken)
+	}
+	if fmt.Sprint(off) != "[AC:CD:EF:12:34:56]" {
+		t.Errorf("want nas powered off, got %v", off)
+	}
+	// Shutdown actions cannot be set when waking
+	if _, status, err := httpRequestAs(http.MethodPost, server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:5A",`+shutdown+`}`, "admin", "admin"); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	if d, _, _ := api.findDevice("AC:CD:EF:12:34:5A"); d.Shutdown != "" {
+		t.Errorf("want no shutdown action of woken device, got %+v", d.Shutdown)
+	}
+	entries, err := api.History.Query(history.Filter{Action: history.DevicePoweredOff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Entries are returned newest first
+	if len(entries) != 2 || entries[1].Result != history.ResultOK || entries[0].Result != history.ResultFailed || entries[0].Message != "got status 503" {
+		t.Errorf("unexpected history %+v", entries)
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	var sent []string
+	api := &Server{
+		wakeFunc: func(hwAddr net.HardwareAddr, opts wol.Options) error {
+			sent = append(sent, hwAddr.String())
+			return nil
+		},
+		cacheFile: file.Name(),
+		Clock:     schedule.ClockFunc(func() time.Time { return time.Date(2019, 1, 2, 7, 45, 0, 0, time.UTC) }),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"office-pc"}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	var tests = []struct {
+		method string
+		url    string
+		body   string
+		status int
+		out    string
+	}{
+		{http.MethodGet, "/api/v1/schedules", "", 200, `{"schedules":[]}`},
+		{http.MethodPut, "/api/v1/schedules/office", `{"device":"office-pc","at":"07:30","days":"weekdays","timeZone":"UTC"}`, 201,
+			`{"name":"office","device":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","cron":"30 7 * * mon-fri","timeZone":"UTC"}`},
+		{http.MethodPut, "/api/v1/schedules/office", `{"device":"AC:CD:EF:12:34:56","cron":"0 8 * * *"}`, 200,
+			`{"name":"office","device":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","cron":"0 8 * * *"}`},
+		{http.MethodPut, "/api/v1/schedules/rigs", `{"group":"rigs","cron":"0 22 * * *","skipIfOnline":false}`, 201,
+			`{"name":"rigs","group":"rigs","cron":"0 22 * * *","skipIfOnline":false}`},
+		{http.MethodPut, "/api/v1/schedules/bad", `{"device":"foo","at":"07:30"}`, 400, `{"status":400,"message":"Device not found: foo"}`},
+		{http.MethodPut, "/api/v1/schedules/bad", `{"group":"rigs"}`, 400, `{"status":400,"message":"Invalid schedule: invalid time: "}`},
+		{http.MethodPut, "/api/v1/schedules/bad", `{"group":"rigs","cron":"* * *"}`, 400,
+			`{"status":400,"message":"Invalid schedule: invalid cron expression: * * *: must have 5 fields"}`},
+		{http.MethodPut, "/api/v1/schedules/bad", `{"group":"rigs","at":"07:30","timeZone":"Mars/Olympus"}`, 400,
+			`{"status":400,"message":"Invalid schedule: invalid time zone: Mars/Olympus"}`},
+		{http.MethodPut, "/api/v1/schedules/bad", `{"at":"07:30"}`, 400, `{"status":400,"message":"Schedule must have one of device or group"}`},
+		{http.MethodPut, "/api/v1/schedules/%230", `{"group":"rigs","at":"07:30"}`, 400, `{"status":400,"message":"Invalid schedule name: #0"}`},
+		{http.MethodGet, "/api/v1/schedules/foo", "", 404, `{"status":404,"message":"Schedule not found: foo"}`},
+		{http.MethodDelete, "/api/v1/schedules/foo", "", 404, `{"status":404,"message":"Schedule not found: foo"}`},
+		{http.MethodDelete, "/api/v1/schedules/rigs", "", 204, ""},
+		{http.MethodGet, "/api/v1/schedules", "", 200, `{"schedules":[{"name":"office","device":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","cron":"0 8 * * *"}]}`},
+		{http.MethodPost, "/api/v1/schedules", "", 405, `{"status":405,"message":"Invalid method POST, must be GET"}`},
+		// 2019-01-02 is a Wednesday, and the clock of the server is UTC
+		{http.MethodPost, "/api/v1/schedules/simulate", `{"at":"07:30","days":"weekdays","timeZone":"Europe/Oslo","count":3}`, 200,
+			`{"cron":"30 7 * * mon-fri","timeZone":"Europe/Oslo","times":["2019-01-03T07:30:00+01:00","2019-01-04T07:30:00+01:00","2019-01-07T07:30:00+01:00"]}`},
+		{http.MethodPost, "/api/v1/schedules/simulate", `{"cron":"*/30 * * * *","count":2}`, 200,
+			`{"cron":"*/30 * * * *","timeZone":"UTC","times":["2019-01-02T08:00:00Z","2019-01-02T08:30:00Z"]}`},
+		{http.MethodPost, "/api/v1/schedules/simulate", `{"cron":"0 0 1 1 *","from":"2020-06-01T00:00:00Z","count":1}`, 200,
+			`{"cron":"0 0 1 1 *","timeZone":"UTC","times":["2021-01-01T00:00:00Z"]}`},
+		{http.MethodPost, "/api/v1/schedules/simulate", `{"cron":"0 12 30 2 *"}`, 200, `{"cron":"0 12 30 2 *","timeZone":"UTC","times":[]}`},
+		{http.MethodPost, "/api/v1/schedules/simulate", `{"cron":"0 8 * * *","count":0}`, 400, `{"status":400,"message":"Invalid value for count: 0"}`},
+		{http.MethodPost, "/api/v1/schedules/simulate", `{"cron":"0 8 * *"}`, 400,
+			`{"status":400,"message":"Invalid schedule: invalid cron expression: 0 8 * *: must have 5 fields"}`},
+		{http.MethodPut, "/api/v1/schedules/simulate", `{"group":"rigs","at":"07:30"}`, 405, `{"status":405,"message":"Invalid method PUT, must be POST"}`},
+	}
+	for _, tt := range tests {
+		out, status, err := httpRequest(tt.method, server.URL+tt.url, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("%s %s: want status %d, got %d", tt.method, tt.url, tt.status, status)
+		}
+		if out != tt.out {
+			t.Errorf("%s %s: want response %s, got %s", tt.method, tt.url, tt.out, out)
+		}
+	}
+	schedules, err := api.StoredSchedules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 1 || schedules[0].Name != "office" || schedules[0].Cron.String() != "0 8 * * *" {
+		t.Fatalf("unexpected schedules %+v", schedules)
+	}
+	if err := api.WakeScheduled(schedules[0]); err != nil || fmt.Sprint(sent) != "[ac:cd:ef:12:34:56]" {
+		t.Errorf("want scheduled wake of office-pc, got %v (%v)", sent, err)
+	}
+}
+
+func TestDeviceCollection(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	var sent []string
+	api := Server{
+		wakeFunc: func(hwAddr net.HardwareAddr, opts wol.Options) error {
+			sent = append(sent, fmt.Sprintf("%s:%d", hwAddr, opts.Port))
+			return nil
+		},
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	nas := `{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas","description":"","macAddress":"AC:CD:EF:12:34:56","macAddresses":[],"ipAddress":"","groups":["server"],"notes":"","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":7,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`
+	var tests = []struct {
+		method string
+		url    string
+		body   string
+		status int
+		out    string
+	}{
+		{http.MethodGet, "/api/v1/devices", "", 200, `{"devices":[]}`},
+		{http.MethodPost, "/api/v1/devices", `{"name":"nas","macAddress":"ac-cd-ef-12-34-56","groups":["server"],"wakePort":7}`, 201, nas},
+		{http.MethodPost, "/api/v1/devices", `{"name":"nas","macAddress":"AC:CD:EF:12:34:56"}`, 409, `{"status":409,"message":"Device already exists: fc2b1229-4d81-5601-88f3-99f11ef28aa6"}`},
+		{http.MethodPost, "/api/v1/devices", `{"name":"nas"}`, 400, `{"status":400,"message":"Missing MAC address"}`},
+		{http.MethodPost, "/api/v1/devices", `{"macAddress":"foo"}`, 400, `{"status":400,"message":"Invalid MAC address: foo"}`},
+		{http.MethodPost, "/api/v1/devices", `{`, 400, `{"status":400,"message":"Malformed JSON"}`},
+		{http.MethodGet, "/api/v1/devices", "", 200, `{"devices":[` + nas + `]}`},
+		{http.MethodGet, "/api/v1/devices/", "", 200, `{"devices":[` + nas + `]}`},
+		{http.MethodGet, "/api/v1/devices?q=name%3Ddesktop", "", 200, `{"devices":[]}`},
+		{http.MethodDelete, "/api/v1/devices", "", 405, `{"status":405,"message":"Invalid method DELETE, must be GET or POST"}`},
+		{http.MethodPost, "/api/v1/devices/AC:CD:EF:12:34:56/wake", "", 204, ""},
+		{http.MethodPost, "/api/v1/devices/fc2b1229-4d81-5601-88f3-99f11ef28aa6/wake", `{"wakePort":9,"name":"foo"}`, 204, ""},
+		{http.MethodPost, "/api/v1/devices/AC:CD:EF:12:34:56/wake", `{"packets":11}`, 400, `{"status":400,"message":"Invalid number of packets: 11, must be between 1 and 10"}`},
+		{http.MethodPost, "/api/v1/devices/AC:CD:EF:12:34:57/wake", "", 404, `{"status":404,"message":"Device not found: AC:CD:EF:12:34:57"}`},
+		{http.MethodGet, "/api/v1/devices/AC:CD:EF:12:34:56/wake", "", 405, `{"status":405,"message":"Invalid method GET, must be POST"}`},
+		{http.MethodGet, "/api/v1/devices/AC:CD:EF:12:34:56", "", 200, nas},
+	}
+	for _, tt := range tests {
+		out, status, err := httpRequest(tt.method, server.URL+tt.url, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("%s %s: want status %d, got %d", tt.method, tt.url, tt.status, status)
+		}
+		if out != tt.out {
+			t.Errorf("%s %s: want response %s, got %s", tt.method, tt.url, tt.out, out)
+		}
+	}
+	// Devices are woken as stored, and are neither created nor changed by waking them
+	if want := "[ac:cd:ef:12:34:56:7 ac:cd:ef:12:34:56:7]"; fmt.Sprint(sent) != want {
+		t.Errorf("want packets sent to %s, got %v", want, sent)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	wakes := 0
+	api := Server{
+		HookKey:   "s3cr3t",
+		Envelope:  true,
+		Recent:    event.NewRecorder(10),
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { wakes++; return nil },
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	if _, status, err := httpPost(server.URL+"/api/v1/wake", `{"name":"nas","macAddress":"AC:CD:EF:12:34:56"}`); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	wakes = 0
+
+	var tests = []struct {
+		method, url, body string
+		status            int
+		response          string
+		wakes             int
+	}{
+		{http.MethodPost, "/api/v1/webhooks/wake", `{"device":"nas"}`, 401, `{"status":401,"message":"Invalid key"}`, 0},
+		{http.MethodPost, "/api/v1/webhooks/wake?key=wrong", `{"device":"nas"}`, 401, `{"status":401,"message":"Invalid key"}`, 0},
+		{http.MethodGet, "/api/v1/webhooks/wake?key=s3cr3t", "", 405, `{"status":405,"message":"Invalid method GET, must be POST"}`, 0},
+		{http.MethodPost, "/api/v1/webhooks/wake?key=s3cr3t", `{"device":1}`, 400, `{"status":400,"message":"Malformed JSON"}`, 0},
+		{http.MethodPost, "/api/v1/webhooks/wake?key=s3cr3t", "", 400, `{"status":400,"message":"Missing device"}`, 0},
+		{http.MethodPost, "/api/v1/webhooks/wake?key=s3cr3t", `{"device":"printer"}`, 404, `{"status":404,"message":"Device not found: printer","cause":"device not found: printer"}`, 0},
+		{http.MethodPost, "/api/v1/webhooks/wake?key=s3cr3t", `{"device":"NAS","id":"zap-1"}`, 200, `{"id":"zap-1","device":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas","macAddress":"AC:CD:EF:12:34:56","time":"","duplicate":false}`, 1},
+		{http.MethodPost, "/api/v1/webhooks/wake?key=s3cr3t&id=zap-1", `{"device":"ac:cd:ef:12:34:56"}`, 200, `{"id":"zap-1","device":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas","macAddress":"AC:CD:EF:12:34:56","time":"","duplicate":true}`, 1},
+		{http.MethodPost, "/api/v1/webhooks/wake?key=s3cr3t&device=fc2b1229-4d81-5601-88f3-99f11ef28aa6&id=zap-2", "", 200, `{"id":"zap-2","device":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas","macAddress":"AC:CD:EF:12:34:56","time":"","duplicate":false}`, 2},
+		{http.MethodGet, "/api/v1/webhooks/events?key=s3cr3t&type=sleep", "", 400, `{"status":400,"message":"Invalid event type: sleep"}`, 2},
+	}
+	for i, tt := range tests {
+		r, err := http.NewRequest(tt.method, server.URL+tt.url, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Responses are compared without their time, by their fields
+		var v map[string]interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := v["time"]; ok {
+			v["time"] = ""
+		}
+		data, _ = json.Marshal(v)
+		if res.StatusCode != tt.status {
+			t.Errorf("#%d: %s %s: want status %d, got %d", i, tt.method, tt.url, tt.status, res.StatusCode)
+		}
+		var want map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.response), &want); err != nil {
+			t.Fatal(err)
+		}
+		if wantData, _ := json.Marshal(want); string(data) != string(wantData) {
+			t.Errorf("#%d: %s %s: want response %s, got %s", i, tt.method, tt.url, wantData, data)
+		}
+		if wakes != tt.wakes {
+			t.Errorf("#%d: want %d wakes, got %d", i, tt.wakes, wakes)
+		}
+	}
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	api.Recent.Handle(event.Event{Type: event.Online, Device: "fc2b1229-4d81-5601-88f3-99f11ef28aa6", MACAddress: "AC:CD:EF:12:34:56", Name: "nas", Time: now})
+	api.Recent.Handle(event.Event{Type: event.Failed, MACAddress: "AC:CD:EF:12:34:57", Time: now.Add(time.Minute), Error: "network is down"})
+	var events []HookEvent
+	data, status, err := httpGet(server.URL + "/api/v1/webhooks/events?key=s3cr3t&type=online&type=failed")
+	if err != nil || status != 200 {
+		t.Fatalf("want status 200, got %d (%v)", status, err)
+	}
+	if err := json.Unmarshal([]byte(data), &events); err != nil {
+		t.Fatal(err)
+	}
+	want := []HookEvent{
+		{Type: event.Failed, Title: "Failed to wake AC:CD:EF:12:34:57", MACAddress: "AC:CD:EF:12:34:57", Error: "network is down", Time: "2020-06-01T12:01:00Z"},
+		{Type: event.Online, Title: "nas is online", Device: "fc2b1229-4d81-5601-88f3-99f11ef28aa6", Name: "nas", MACAddress: "AC:CD:EF:12:34:56", Time: "2020-06-01T12:00:00Z"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("want %d events, got %d: %s", len(want), len(events), data)
+	}
+	for i := range want {
+		if events[i].ID == "" || events[i].ID == events[1-i].ID {
+			t.Errorf("#%d: want unique ID, got %q", i, events[i].ID)
+		}
+		want[i].ID = events[i].ID
+		if events[i] != want[i] {
+			t.Errorf("#%d: want %+v, got %+v", i, want[i], events[i])
+		}
+	}
+	if data, _, _ := httpGet(server.URL + "/api/v1/webhooks/events?key=s3cr3t&limit=1"); strings.Count(data, `"id"`) != 1 {
+		t.Errorf("want 1 event, got %s", data)
+	}
+
+	api.HookKey = ""
+	for _, url := range []string{"/api/v1/webhooks/wake?key=s3cr3t", "/api/v1/webhooks/events?key=s3cr3t"} {
+		if _, status, _ := httpRequest(http.MethodPost, server.URL+url, `{"device":"nas"}`); status != 404 {
+			t.Errorf("%s: want status 404 without key, got %d", url, status)
+		}
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	defer os.Remove(file.Name() + ".keys")
+	wakes := 0
+	api := Server{
+		Auth:      testAuth{"admin": "admin"},
+		Keys:      apikey.Open(file.Name() + ".keys"),
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { wakes++; return nil },
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for _, body := range []string{`{"name":"hypervisor","macAddress":"AC:CD:EF:12:34:56"}`, `{"name":"media","macAddress":"AC:CD:EF:12:34:57"}`} {
+		if _, status, err := httpRequestAs(http.MethodPost, server.URL+"/api/v1/wake", body, "admin", "admin"); err != nil || status != 204 {
+			t.Fatalf("want status 204, got %d (%v)", status, err)
+		}
+	}
+	media, _, err := api.findDevice("AC:CD:EF:12:34:57")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, status, err := httpRequestAs(http.MethodPost, server.URL+"/api/v1/admin/keys", `{"name":"guest","devices":["printer"]}`, "admin", "admin")
+	if err != nil || status != 400 || data != `{"status":400,"message":"Device not found: printer"}` {
+		t.Errorf("want status 400, got %d: %s (%v)", status, data, err)
+	}
+	data, status, err = httpRequestAs(http.MethodPost, server.URL+"/api/v1/admin/keys", `{"name":"guest","devices":["media"]}`, "admin", "admin")
+	if err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d: %s (%v)", status, data, err)
+	}
+	var key KeyResource
+	if err := json.Unmarshal([]byte(data), &key); err != nil {
+		t.Fatal(err)
+	}
+	if key.Secret == "" || len(key.Devices) != 1 || key.Devices[0] != media.ID {
+		t.Fatalf("want key scoped to %s, got %s", media.ID, data)
+	}
+	if data, _, _ := httpRequestAs(http.MethodGet, server.URL+"/api/v1/admin/keys", "", "admin", "admin"); strings.Contains(data, key.Secret) || !strings.Contains(data, key.ID) {
+		t.Errorf("want key listed without its secret, got %s", data)
+	}
+
+	request := func(method, url, body, token string) (string, int) {
+		r, err := http.NewRequest(method, server.URL+url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data), res.StatusCode
+	}
+	wakes = 0
+	var tests = []struct {
+		method, url, body string
+		status            int
+		wakes             int
+	}{
+		{http.MethodPost, "/api/v1/devices/" + media.ID + "/wake", "", 204, 1},
+		{http.MethodPost, "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:57"}`, 204, 2},
+		{http.MethodPost, "/api/v1/devices/fc2b1229-4d81-5601-88f3-99f11ef28aa6/wake", "", 404, 2},
+		{http.MethodPost, "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`, 403, 2},
+		{http.MethodPost, "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:58"}`, 403, 2},
+		{http.MethodDelete, "/api/v1/devices/" + media.ID, "", 403, 2},
+		{http.MethodPut, "/api/v1/devices/" + media.ID, `{"name":"tv","macAddress":"AC:CD:EF:12:34:57"}`, 403, 2},
+		{http.MethodGet, "/api/v1/admin/keys", "", 403, 2},
+	}
+	for i, tt := range tests {
+		if data, status := request(tt.method, tt.url, tt.body, key.Secret); status != tt.status {
+			t.Errorf("#%d: %s %s: want status %d, got %d: %s", i, tt.method, tt.url, tt.status, status, data)
+		}
+		if wakes != tt.wakes {
+			t.Errorf("#%d: want %d wakes, got %d", i, tt.wakes, wakes)
+		}
+	}
+	var devices DeviceResources
+	data, _ = request(http.MethodGet, "/api/v1/devices", "", key.Secret)
+	if err := json.Unmarshal([]byte(data), &devices); err != nil {
+		t.Fatal(err)
+	}
+	if len(devices.Devices) != 1 || devices.Devices[0].ID != media.ID {
+		t.Errorf("want only device %s, got %s", media.ID, data)
+	}
+
+	if _, status, err := httpRequestAs(http.MethodDelete, server.URL+"/api/v1/admin/keys/"+key.ID, "", "admin", "admin"); err != nil || status != 204 {
+		t.Errorf("want status 204, got %d (%v)", status, err)
+	}
+	if _, status, err := httpRequestAs(http.MethodDelete, server.URL+"/api/v1/admin/keys/"+key.ID, "", "admin", "admin"); err != nil || status != 404 {
+		t.Errorf("want status 404, got %d (%v)", status, err)
+	}
+	if data, status := request(http.MethodGet, "/api/v1/devices", "", key.Secret); status != 401 {
+		t.Errorf("want status 401 after deleting key, got %d: %s", status, data)
+	}
+}
+
+func TestAPIKeysWithoutAuth(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	defer os.Remove(file.Name() + ".keys")
+	api := Server{
+		AdminToken: "secret",
+		Keys:       apikey.Open(file.Name() + ".keys"),
+		wakeFunc:   func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile:  file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	request := func(method, url, body, token string) (string, int) {
+		r, err := http.NewRequest(method, server.URL+url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data), res.StatusCode
+	}
+	// The API is open until a key is created
+	for _, body := range []string{`{"name":"hypervisor","macAddress":"AC:CD:EF:12:34:56"}`, `{"name":"media","macAddress":"AC:CD:EF:12:34:57"}`} {
+		if data, status := request(http.MethodPost, "/api/v1/wake", body, ""); status != 204 {
+			t.Fatalf("want status 204, got %d: %s", status, data)
+		}
+	}
+	data, status := request(http.MethodPost, "/api/v1/admin/keys", `{"name":"guest","devices":["media"]}`, "secret")
+	if status != 201 {
+		t.Fatalf("want status 201, got %d: %s", status, data)
+	}
+	var key KeyResource
+	if err := json.Unmarshal([]byte(data), &key); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		method, url, body, token string
+		status                   int
+	}{
+		{http.MethodPost, "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:57"}`, "", 401},
+		{http.MethodGet, "/api/v1/devices", "", "foo", 401},
+		{http.MethodPost, "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:57"}`, key.Secret, 204},
+		{http.MethodPost, "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`, key.Secret, 403},
+		{http.MethodPost, "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:58"}`, key.Secret, 403},
+		{http.MethodGet, "/api/v1/admin/keys", "", key.Secret, 403},
+		{http.MethodPost, "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`, "secret", 204},
+	}
+	for i, tt := range tests {
+		if data, status := request(tt.method, tt.url, tt.body, tt.token); status != tt.status {
+			t.Errorf("#%d: %s %s: want status %d, got %d: %s", i, tt.method, tt.url, tt.status, status, data)
+		}
+	}
+	if _, status, err := httpRequestAs(http.MethodGet, server.URL+"/api/v1/devices", "", "admin", "admin"); err != nil || status != 401 {
+		t.Errorf("want status 401 for basic auth, got %d (%v)", status, err)
+	}
+}
+
+func TestUnknownDevices(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	defer os.Remove(file.Name() + ".keys")
+	wakes := 0
+	api := Server{
+		Auth:      testAuth{"admin": "admin"},
+		Keys:      apikey.Open(file.Name() + ".keys"),
+		Events:    event.NewBus(),
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { wakes++; return nil },
+		cacheFile: file.Name(),
+	}
+	events, cancel := api.Events.Subscribe(10)
+	defer cancel()
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	if _, status, err := httpRequestAs(http.MethodPost, server.URL+"/api/v1/wake", `{"name":"media","macAddress":"AC:CD:EF:12:34:57"}`, "admin", "admin"); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	if e := <-events; e.Type != event.Unknown || e.MACAddress != "AC:CD:EF:12:34:57" || e.Actor != "admin" || e.Client != "127.0.0.1" {
+		t.Errorf("want unknown event for device added by admin, got %+v", e)
+	}
+	if e := <-events; e.Type != event.Wake {
+		t.Errorf("want wake event, got %+v", e)
+	}
+	if e := <-events; e.Type != event.Added || e.Name != "media" || e.Actor != "admin" {
+		t.Errorf("want added event for stored device, got %+v", e)
+	}
+	secrets := make(map[string]string)
+	for _, body := range []string{`{"name":"guest","devices":["media"]}`, `{"name":"roaming","devices":["media"],"allowUnknown":true}`} {
+		data, status, err := httpRequestAs(http.MethodPost, server.URL+"/api/v1/admin/keys", body, "admin", "admin")
+		if err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d: %s (%v)", status, data, err)
+		}
+		var key KeyResource
+		if err := json.Unmarshal([]byte(data), &key); err != nil {
+			t.Fatal(err)
+		}
+		secrets[key.Name] = key.Secret
+	}
+
+	wakes = 0
+	for i, tt := range []struct {
+		key    string
+		method string
+		status int
+		wakes  int
+	}{
+		{"guest", http.MethodPost, 403, 0},
+		{"roaming", http.MethodPost, 204, 1},
+		{"roaming", http.MethodDelete, 403, 1},
+	} {
+		r, err := http.NewRequest(tt.method, server.URL+"/api/v1/wake", strings.NewReader(`{"macAddress":"AC:CD:EF:12:34:58"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Authorization", "Bearer "+secrets[tt.key])
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, res.StatusCode)
+		}
+		if wakes != tt.wakes {
+			t.Errorf("#%d: want %d wakes, got %d", i, tt.wakes, wakes)
+		}
+		if tt.method != http.MethodPost {
+			continue
+		}
+		if e := <-events; e.Type != event.Unknown || e.MACAddress != "AC:CD:EF:12:34:58" || e.Actor != "key:"+tt.key {
+			t.Errorf("#%d: want unknown event requested by %s, got %+v", i, tt.key, e)
+		}
+		if tt.status == 204 {
+			if e := <-events; e.Type != event.Wake {
+				t.Errorf("#%d: want wake event, got %+v", i, e)
+			}
+		}
+	}
+	// Unknown devices woken by keys are not stored
+	if _, ok, err := api.findDevice("AC:CD:EF:12:34:58"); err != nil || ok {
+		t.Errorf("want unknown device to not be stored, got %t (%v)", ok, err)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("want no more events, got %+v", e)
+	default:
+	}
+}
+
+func TestChangeEvents(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{Auth: testAuth{"admin": "admin"}, Events: event.NewBus(), cacheFile: file.Name()}
+	events, cancel := api.Events.Subscribe(10)
+	defer cancel()
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	data, status, err := httpRequestAs(http.MethodPost, server.URL+"/api/v1/devices", `{"name":"nas","macAddress":"AC:CD:EF:12:34:56"}`, "admin", "admin")
+	if err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d: %s (%v)", status, data, err)
+	}
+	var d DeviceResource
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		t.Fatal(err)
+	}
+	if e := <-events; e.Type != event.Added || e.Device != d.ID || e.Name != "nas" || e.Actor != "admin" || e.Client != "127.0.0.1" {
+		t.Errorf("want added event, got %+v", e)
+	}
+	if _, status, err := httpRequestAs(http.MethodPut, server.URL+"/api/v1/devices/"+d.ID, `{"name":"nas","macAddress":"AC:CD:EF:12:34:56","description":"storage"}`, "admin", "admin"); err != nil || status != 200 {
+		t.Fatalf("want status 200, got %d (%v)", status, err)
+	}
+	if _, status, err := httpRequestAs(http.MethodDelete, server.URL+"/api/v1/devices/"+d.ID, "", "admin", "admin"); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	// Changing a device publishes no event
+	if e := <-events; e.Type != event.Removed || e.Device != d.ID || e.MACAddress != "AC:CD:EF:12:34:56" {
+		t.Errorf("want removed event, got %+v", e)
+	}
+	data, _, err = httpRequestAs(http.MethodGet, server.URL+"/metrics", "", "admin", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"wakeup_event_subscribers 1\n", "wakeup_events_published_total 2\n", "wakeup_events_dropped_total 0\n"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("want metrics to contain %q, got %q", want, data)
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{
+		Auth:      testAuth{"admin": "admin", "alice": "secret", "bob": "secret"},
+		Events:    event.NewBus(),
+		Recent:    event.NewRecorder(10),
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	stream := func(query, username, lastEventID string) (*bufio.Reader, func()) {
+		r, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/events"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastEventID != "" {
+			r.Header.Set("Last-Event-ID", lastEventID)
+		}
+		r.SetBasicAuth(username, "secret")
+		if username == "admin" {
+			r.SetBasicAuth(username, "admin")
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != 200 || res.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("want event stream, got %d (%s)", res.StatusCode, res.Header.Get("Content-Type"))
+		}
+		return bufio.NewReader(res.Body), func() { res.Body.Close() }
+	}
+	next := func(br *bufio.Reader) (string, event.Event) {
+		var (
+			typ string
+			e   event.Event
+		)
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				return typ, e
+			case strings.HasPrefix(line, "event: "):
+				typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	alice, closeAlice := stream("?type=added&type=online", "alice", "")
+	defer closeAlice()
+	admin, closeAdmin := stream("", "admin", "")
+	defer closeAdmin()
+
+	var ids []string
+	for _, tt := range []struct{ user, mac string }{{"bob", "AC:CD:EF:12:34:57"}, {"alice", "AC:CD:EF:12:34:56"}} {
+		data, status, err := httpRequestAs(http.MethodPost, server.URL+"/api/v1/devices", `{"macAddress":"`+tt.mac+`"}`, tt.user, "secret")
+		if err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d: %s (%v)", status, data, err)
+		}
+		var d DeviceResource
+		if err := json.Unmarshal([]byte(data), &d); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, d.ID)
+	}
+	for _, id := range ids {
+		api.publish(event.Event{Type: event.Offline, Device: id})
+		api.publish(event.Event{Type: event.Online, Device: id})
+	}
+	for i, want := range []struct {
+		typ    string
+		device string
+	}{{event.Added, ids[1]}, {event.Online, ids[1]}} {
+		if typ, e := next(alice); typ != want.typ || e.Device != want.device {
+			t.Errorf("#%d: want %s event of %s, got %s event %+v", i, want.typ, want.device, typ, e)
+		}
+	}
+	var types []string
+	for range []int{0, 1, 2, 3, 4, 5} {
+		typ, _ := next(admin)
+		types = append(types, typ)
+	}
+	if got, want := strings.Join(types, ","), "added,added,offline,online,offline,online"; got != want {
+		t.Errorf("want events %s, got %s", want, got)
+	}
+
+	e := event.Event{Type: event.Wake, MACAddress: "AC:CD:EF:12:34:56", Time: time.Now()}
+	for _, typ := range []string{event.Wake, event.Online, event.Offline} {
+		e.Type, e.Time = typ, e.Time.Add(time.Second)
+		api.Recent.Handle(e)
+	}
+	missed := api.missedEvents(eventID(api.Recent.Events()[2]))
+	if len(missed) != 2 || missed[0].Type != event.Online || missed[1].Type != event.Offline {
+		t.Errorf("want online and offline events missed, got %+v", missed)
+	}
+	if missed := api.missedEvents("foo"); len(missed) != 0 {
+		t.Errorf("want no events missed, got %+v", missed)
+	}
+	// Replayed events are not sent again when received live, while other events happening at the same time are
+	resumed, closeResumed := stream("", "admin", eventID(api.Recent.Events()[2]))
+	defer closeResumed()
+	api.publish(missed[1])
+	api.publish(event.Event{Type: event.Wake, MACAddress: "AC:CD:EF:12:34:57", Time: missed[1].Time})
+	types = nil
+	for range []int{0, 1, 2} {
+		typ, _ := next(resumed)
+		types = append(types, typ)
+	}
+	if got, want := strings.Join(types, ","), "online,offline,wake"; got != want {
+		t.Errorf("want events %s, got %s", want, got)
+	}
+	if _, status, err := httpRequestAs(http.MethodPost, server.URL+"/api/v1/events", "", "admin", "admin"); err != nil || status != 405 {
+		t.Errorf("want status 405, got %d (%v)", status, err)
+	}
+}
+
+func TestRelayFilter(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	api := Server{
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	if _, status, err := httpPost(server.URL+"/api/v1/wake", `{"name":"nas","macAddress":"AC:CD:EF:12:34:56","macAddresses":["AC:CD:EF:12:34:57"]}`); err != nil || status != 200 {
+		t.Fatalf("want status 200, got %d (%v)", status, err)
+	}
+	for _, tt := range []struct {
+		mac    string
+		stored bool
+	}{
+		{"ac:cd:ef:12:34:56", true},
+		{"ac:cd:ef:12:34:57", true},
+		{"ac:cd:ef:12:34:58", false},
+	} {
+		hwAddr, err := net.ParseMAC(tt.mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := api.stored(hwAddr); got != tt.stored {
+			t.Errorf("stored(%s) = %t, want %t", tt.mac, got, tt.stored)
+		}
+	}
+	b, err := wol.ListenAll("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wol.Close(b)
+	api.Relay = b
+	data, _, err := httpGet(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"wakeup_relay_forwarded_total 0\n", "wakeup_relay_dropped_total{reason=\"duplicate\"} 0\n"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("want metrics to contain %q, got %q", want, data)
+		}
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.FormatLogfmt, logging.Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := Server{
+		Logger:    logger,
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+	}
+	handler := api.Handler()
+	var tests = []struct {
+		method, url, body, id string
+		wantID                bool
+		log                   []string
+	}{
+		{http.MethodPost, "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`, "abc-123", true,
+			[]string{"level=info msg=request request_id=abc-123 method=POST path=/api/v1/wake remote_ip=192.0.2.1 status=204 outcome=ok", "mac=AC:CD:EF:12:34:56"}},
+		{http.MethodGet, "/api/v1/devices/foo", "", "not valid", false,
+			[]string{"level=warn msg=request", "status=400 outcome=rejected", `error="Invalid device ID or MAC address: foo"`}},
+	}
+	for i, tt := range tests {
+		buf.Reset()
+		r := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+		r.Header.Set("X-Request-ID", tt.id)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		id := w.Header().Get("X-Request-ID")
+		if (id == tt.id) != tt.wantID || id == "" {
+			t.Errorf("#%d: got request ID %q for %q", i, id, tt.id)
+		}
+		got := buf.String()
+		for _, want := range append(tt.log, "request_id="+id) {
+			if !strings.Contains(got, want) {
+				t.Errorf("#%d: want log to contain %q, got %q", i, want, got)
+			}
+		}
+	}
+}
+
+func TestWakeFailureDiagnostics(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	var wakeErr error
+	api := Server{
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return wakeErr },
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		err      error
+		response string
+		status   int
+	}{
+		{&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPERM)},
+			`{"status":500,"message":"Failed to wake device with address AC:CD:EF:12:34:56","cause":"permission_denied","hint":"Sending broadcast packets was denied. Check firewall rules for outgoing UDP broadcasts"}`, 500},
+		{fmt.Errorf("%w: eth1", wol.ErrNoRoute),
+			`{"status":502,"message":"Failed to wake device with address AC:CD:EF:12:34:56","cause":"no_route","hint":"No interface or source address is configured for the destination. Configure the interface or bind address magic packets are sent from"}`, 502},
+		{fmt.Errorf("%w: password too long", wol.ErrInvalidOptions),
+			`{"status":400,"message":"Failed to wake device with address AC:CD:EF:12:34:56","cause":"invalid_options","hint":"Check the MAC address, SecureOn password, wake address and transport of the device"}`, 400},
+	}
+	for i, tt := range tests {
+		wakeErr = tt.err
+		data, status, err := httpPost(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status || data != tt.response {
+			t.Errorf("#%d: want %q (%d), got %q (%d)", i, tt.response, tt.status, data, status)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	started, release := make(chan bool), make(chan bool)
+	api := Server{
+		wakeFunc: func(net.HardwareAddr, wol.Options) error {
+			started <- true
+			<-release
+			return nil
+		},
+		cacheFile: file.Name(),
+	}
+	if err := api.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + api.listeners[0].Addr().String()
+	served := make(chan error, 1)
+	go func() { served <- api.Serve() }()
+
+	// Requests in flight complete
+	type response struct {
+		status int
+		err    error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		_, status, err := httpPost(url+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`)
+		responses <- response{status, err}
+	}()
+	<-started
+	stopped := make(chan error, 1)
+	go func() { stopped <- api.Shutdown(context.Background()) }()
+	if err := <-served; err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	if _, _, err := httpGet(url + "/api/v1/devices"); err == nil {
+		t.Error("want error for request after shutdown")
+	}
+	close(release)
+	if res := <-responses; res.err != nil || res.status != 204 {
+		t.Errorf("want status 204, got %d (%v)", res.status, res.err)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+
+	// Requests still in flight when the context is done are aborted
+	api = Server{
+		wakeFunc: func(net.HardwareAddr, wol.Options) error {
+			started <- true
+			<-release
+			return nil
+		},
+		cacheFile: file.Name(),
+	}
+	release = make(chan bool)
+	defer close(release)
+	if err := api.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	url = "http://" + api.listeners[0].Addr().String()
+	go api.Serve()
+	go func() {
+		_, status, err := httpPost(url+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`)
+		responses <- response{status, err}
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := api.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("want %v, got %v", context.DeadlineExceeded, err)
+	}
+	if res := <-responses; res.err == nil {
+		t.Errorf("want error, got status %d", res.status)
+	}
+
+	// Shutting down before serving closes listeners
+	api = Server{cacheFile: file.Name()}
+	if err := api.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Serve(); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	// The test server provides a certificate for 127.0.0.1, and a client trusting it
+	ts := httptest.NewTLSServer(nil)
+	client := ts.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	api := Server{
+		cacheFile:    file.Name(),
+		TLSConfig:    &tls.Config{Certificates: ts.TLS.Certificates},
+		RedirectAddr: "127.0.0.1:0",
+		ACMEHandler: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/.well-known/acme-challenge/foo" {
+					w.Write([]byte("foo.bar"))
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		},
+	}
+	ts.Close()
+	if err := api.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	go api.Serve()
+	defer api.Shutdown(context.Background())
+	addr := api.listeners[0].Addr().String()
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Get("https://" + addr + "/api/v1/wake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Errorf("want status 200, got %d", res.StatusCode)
+	}
+	redirectURL := "http://" + api.redirect.Addr().String()
+	var tests = []struct {
+		method   string
+		path     string
+		host     string
+		status   int
+		location string
+	}{
+		{http.MethodGet, "/api/v1/wake?q=name%3Dnas", "wakeup.example.com", 301, "https://wakeup.example.com:" + port + "/api/v1/wake?q=name%3Dnas"},
+		{http.MethodPost, "/api/v1/wake", "wakeup.example.com:80", 308, "https://wakeup.example.com:" + port + "/api/v1/wake"},
+		{http.MethodGet, "/", "[::1]:80", 301, "https://[::1]:" + port + "/"},
+		{http.MethodGet, "/.well-known/acme-challenge/foo", "wakeup.example.com", 200, ""},
+	}
+	for i, tt := range tests {
+		r, err := http.NewRequest(tt.method, redirectURL+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Host = tt.host
+		res, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.status || res.Header.Get("Location") != tt.location {
+			t.Errorf("#%d: want %d redirecting to %q, got %d redirecting to %q", i, tt.status, tt.location, res.StatusCode, res.Header.Get("Location"))
+		}
+	}
+}
+
+func TestSyntheticEvents(t *testing.T) {
+	api := Server{Events: event.NewBus()}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	url := server.URL + "/api/v1/admin/events"
+
+	// Disabled without admin token
+	if _, status, err := httpPost(url, `{"type":"online","macAddress":"AC:CD:EF:12:34:56"}`); err != nil || status != 404 {
+		t.Fatalf("want status 404, got %d (%v)", status, err)
+	}
+
+	api.AdminToken = "secret"
+	events, cancel := api.Events.Subscribe(1)
+	defer cancel()
+	var tests = []struct {
+		token    string
+		body     string
+		response string
+		status   int
+	}{
+		{"", `{"type":"online","macAddress":"AC:CD:EF:12:34:56"}`, `{"status":401,"message":"Invalid admin token"}`, 401},
+		{"foo", `{"type":"online","macAddress":"AC:CD:EF:12:34:56"}`, `{"status":401,"message":"Invalid admin token"}`, 401},
+		{"secret", `{"type":"foo","macAddress":"AC:CD:EF:12:34:56"}`, `{"status":400,"message":"Invalid event type: foo"}`, 400},
+		{"secret", `{"type":"online","macAddress":"foo"}`, `{"status":400,"message":"Invalid MAC address: foo"}`, 400},
+		{"secret", `{"type":"online","macAddress":"AC:CD:EF:12:34:56","time":"2019-01-01T00:00:00Z"}`, `{"type":"online","macAddress":"AC:CD:EF:12:34:56","time":"2019-01-01T00:00:00Z","synthetic":true}`, 202},
+	}
+	for i, tt := range tests {
+		r, err := http.NewRequest(http.MethodPost, url, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, res.StatusCode)
+		}
+		if got := string(data); got != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, got)
+		}
+	}
+	if e := <-events; !e.Synthetic || e.Type != event.Online {
+		t.Errorf("got unexpected event %+v", e)
+	}
+}
+
+func TestGroupWake(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	routes, err := wol.ParseRoutes([]string{"10.1.0.0/16=10.1.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var woken []string
+	api := Server{
+		Routes:  routes,
+		Stagger: 2 * time.Second,
+		Budget:  budget.New(0, 4, time.Minute),
+		wakeFunc: func(hwAddr net.HardwareAddr, _ wol.Options) error {
+			woken = append(woken, hwAddr.String())
+			return nil
+		},
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	for _, body := range []string{
+		`{"name":"foo","macAddress":"AC:CD:EF:12:34:56","ipAddress":"10.1.2.3","groups":["lab"]}`,
+		`{"macAddress":"AC:CD:EF:12:34:57","groups":["lab","render"]}`,
+		`{"macAddress":"AC:CD:EF:12:34:58"}`,
+	} {
+		if _, _, err := httpPost(server.URL+"/api/v1/wake", body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	woken = nil
+
+	var tests = []struct {
+		url      string
+		response string
+		status   int
+	}{
+		{"/api/v1/groups/foo/wake", `{"status":404,"message":"Group not found: foo"}`, 404},
+		{"/api/v1/groups/lab", `{"status":405,"message":"Invalid method POST, must be GET, PUT or DELETE"}`, 405},
+		{"/api/v1/groups/lab/wake?simulate=foo", `{"status":400,"message":"Invalid value for simulate: foo"}`, 400},
+		{"/api/v1/groups/lab/wake?simulate=true", `{"group":"lab","simulated":true,"wakes":[{"macAddress":"AC:CD:EF:12:34:56","name":"foo","offset":"0s","source":"10.1.0.1"},{"macAddress":"AC:CD:EF:12:34:57","offset":"2s","overBudget":true}]}`, 200},
+		{"/api/v1/groups/lab/wake?simulate=true&stagger=5s", `{"group":"lab","simulated":true,"wakes":[{"macAddress":"AC:CD:EF:12:34:56","name":"foo","offset":"0s","source":"10.1.0.1"},{"macAddress":"AC:CD:EF:12:34:57","offset":"5s","overBudget":true}]}`, 200},
+		{"/api/v1/groups/lab/wake?stagger=2m", `{"status":400,"message":"Invalid stagger: 2m, must be between 0s and 1m0s"}`, 400},
+	}
+	for i, tt := range tests {
+		data, status, err := httpPost(server.URL+tt.url, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if data != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+	}
+	if len(woken) != 0 {
+		t.Errorf("want no devices woken by simulation, got %v", woken)
+	}
+
+	// Simulation charges every packet of the burst
+	api.Budget = budget.New(0, 4, time.Minute)
+	api.Packets = 3
+	want := `{"group":"lab","simulated":true,"wakes":[{"macAddress":"AC:CD:EF:12:34:56","name":"foo","offset":"0s","source":"10.1.0.1"},{"macAddress":"AC:CD:EF:12:34:57","offset":"2s","overBudget":true}]}`
+	if data, _, err := httpPost(server.URL+"/api/v1/groups/lab/wake?simulate=true", ""); err != nil {
+		t.Fatal(err)
+	} else if data != want {
+		t.Errorf("want response %q, got %q", want, data)
+	}
+	api.Budget = budget.New(0, 4, time.Minute)
+	api.Packets = 0
+
+	api.Stagger = 0
+	if _, _, err := httpPost(server.URL+"/api/v1/groups/render/wake", ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := "ac:cd:ef:12:34:57"; len(woken) != 1 || woken[0] != want {
+		t.Errorf("want %s woken, got %v", want, woken)
+	}
+}
+
+func TestGroupWakeInterface(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	routes, err := wol.ParseRoutes([]string{"127.0.0.0/8=lo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := Server{
+		Routes:    routes,
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for _, body := range []string{
+		`{"macAddress":"AC:CD:EF:12:34:56","ipAddress":"127.0.0.2","groups":["lab"]}`,
+		`{"macAddress":"AC:CD:EF:12:34:57","ipAddress":"127.0.0.3","wakeInterface":"eth1","groups":["lab"]}`,
+	} {
+		if _, status, err := httpPost(server.URL+"/api/v1/wake", body); err != nil || status != 204 {
+			t.Fatalf("want status 204, got %d (%v)", status, err)
+		}
+	}
+	data, _, err := httpPost(server.URL+"/api/v1/groups/lab/wake?simulate=true", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plan GroupWake
+	if err := json.Unmarshal([]byte(data), &plan); err != nil {
+		t.Fatal(err)
+	}
+	var interfaces []string
+	for _, pw := range plan.Wakes {
+		interfaces = append(interfaces, pw.Interface)
+	}
+	if want := "[lo eth1]"; fmt.Sprint(interfaces) != want {
+		t.Errorf("want interfaces %s, got %v", want, interfaces)
+	}
+}
+
+func TestBatchWake(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	var woken []string
+	api := Server{
+		Stagger: 2 * time.Second,
+		wakeFunc: func(hwAddr net.HardwareAddr, _ wol.Options) error {
+			woken = append(woken, hwAddr.String())
+			return nil
+		},
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	if _, _, err := httpPost(server.URL+"/api/v1/wake", `{"name":"foo","macAddress":"AC:CD:EF:12:34:56"}`); err != nil {
+		t.Fatal(err)
+	}
+	woken = nil
+
+	var tests = []struct {
+		url      string
+		body     string
+		response string
+		status   int
+	}{
+		{"/api/v1/wake", `[]`, `{"status":400,"message":"Missing MAC addresses"}`, 400},
+		{"/api/v1/wake", `["foo"]`, `{"status":400,"message":"Invalid MAC address: foo"}`, 400},
+		{"/api/v1/wake", `[1]`, `{"status":400,"message":"Malformed JSON"}`, 400},
+		{"/api/v1/wake?simulate=true", `["ac:cd:ef:12:34:56","AC:CD:EF:12:34:57","AC:CD:EF:12:34:56"]`, `{"simulated":true,"wakes":[{"macAddress":"AC:CD:EF:12:34:56","name":"foo","offset":"0s"},{"macAddress":"AC:CD:EF:12:34:57","offset":"2s"}]}`, 200},
+		{"/api/v1/wake?stagger=0s", `["AC:CD:EF:12:34:56","AC:CD:EF:12:34:57"]`, `{"simulated":false,"wakes":[{"macAddress":"AC:CD:EF:12:34:56","name":"foo","offset":"0s","sent":["AC:CD:EF:12:34:56"]},{"macAddress":"AC:CD:EF:12:34:57","offset":"0s","sent":["AC:CD:EF:12:34:57"]}]}`, 200},
+	}
+	for i, tt := range tests {
+		data, status, err := httpPost(server.URL+tt.url, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if data != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+	}
+	if want := []string{"ac:cd:ef:12:34:56", "ac:cd:ef:12:34:57"}; !reflect.DeepEqual(woken, want) {
+		t.Errorf("want %v woken, got %v", want, woken)
+	}
+	// Unknown devices in a batch are not stored
+	if _, ok, err := api.findDevice("AC:CD:EF:12:34:57"); err != nil || ok {
+		t.Errorf("want device to not be stored, got %t (%v)", ok, err)
+	}
+}
+
+type testAuth map[string]string
+
+func (a testAuth) Authenticate(username, password string) (*auth.User, error) {
+	if p, ok := a[username]; !ok || p != password {
+		return nil, auth.ErrInvalidCredentials
+	}
+	u := &auth.User{Name: username, Roles: []string{auth.RoleUser}, Groups: []string{"family"}}
+	if username == "admin" {
+		u.Roles = append(u.Roles, auth.RoleAdmin)
+	}
+	return u, nil
+}
+
+func TestAuthentication(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{
+		Auth:       testAuth{"alice": "secret", "admin": "admin"},
+		AdminToken: "token",
+		wakeFunc:   func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile:  file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		method   string
+		username string
+		password string
+		token    string
+		body     string
+		response string
+		status   int
+	}{
+		{"GET", "", "", "", "", `{"status":401,"message":"Authentication required"}`, 401},
+		{"GET", "alice", "wrong", "", "", `{"status":401,"message":"Invalid username or password"}`, 401},
+		{"GET", "", "", "wrong", "", `{"status":401,"message":"Invalid token"}`, 401},
+		{"GET", "alice", "secret", "", "", `{"devices":[]}`, 200},
+		// Devices owned by others are hidden
+		{"POST", "admin", "admin", "", `{"macAddress":"AC:CD:EF:12:34:56"}`, "", 204},
+		{"GET", "alice", "secret", "", "", `{"devices":[]}`, 200},
+		{"POST", "alice", "secret", "", `{"macAddress":"AC:CD:EF:12:34:56"}`, `{"status":403,"message":"Forbidden"}`, 403},
+		{"DELETE", "alice", "secret", "", `{"macAddress":"AC:CD:EF:12:34:56"}`, `{"status":403,"message":"Forbidden"}`, 403},
+		// New devices are owned by the user adding them
+		{"POST", "alice", "secret", "", `{"macAddress":"12:34:56:AB:CD:EF"}`, "", 204},
+		{"POST", "alice", "secret", "", `{"macAddress":"12:34:56:AB:CD:EE","owner":"bob"}`, `{"status":403,"message":"Only admins can assign devices to other users"}`, 403},
+		{"GET", "alice", "secret", "", "", `{"devices":[{"id":"2fd84d14-c38f-589d-ad2c-ec7c874166da","macAddress":"12:34:56:AB:CD:EF","owner":"alice"}]}`, 200},
+		{"DELETE", "admin", "admin", "", `{"macAddress":"AC:CD:EF:12:34:56"}`, "", 204},
+		{"GET", "", "", "token", "", `{"devices":[{"id":"2fd84d14-c38f-589d-ad2c-ec7c874166da","macAddress":"12:34:56:AB:CD:EF","owner":"alice"}]}`, 200},
+	}
+	for i, tt := range tests {
+		r, err := http.NewRequest(tt.method, server.URL+"/api/v1/wake", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.username != "" {
+			r.SetBasicAuth(tt.username, tt.password)
+		}
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, res.StatusCode)
+		}
+		if got := string(data); got != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, got)
+		}
+		if res.StatusCode == 401 && res.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("#%d: want WWW-Authenticate header", i)
+		}
+	}
+}
+
+func TestTokenAuthentication(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	if err := ioutil.WriteFile(file.Name(), []byte(`{"devices":[{"macAddress":"AC:CD:EF:12:34:58"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	static, err := auth.NewStatic([]string{"alice:secret"}, []string{"ci-bot=s3cr3t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := Server{
+		Auth:          static,
+		AdminToken:    "token",
+		AnonymousRead: true,
+		wakeFunc:      func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile:     file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		method   string
+		url      string
+		username string
+		token    string
+		body     string
+		response string
+		status   int
+	}{
+		{"POST", "/api/v1/wake", "", "", `{"macAddress":"AC:CD:EF:12:34:56"}`, `{"status":401,"message":"Authentication required"}`, 401},
+		{"POST", "/api/v1/wake", "", "wrong", `{"macAddress":"AC:CD:EF:12:34:56"}`, `{"status":401,"message":"Invalid token"}`, 401},
+		{"POST", "/api/v1/wake", "", "s3cr3t", `{"macAddress":"AC:CD:EF:12:34:56"}`, "", 204},
+		{"POST", "/api/v1/wake", "alice", "", `{"macAddress":"AC:CD:EF:12:34:57"}`, "", 204},
+		{"POST", "/api/v1/wake", "", "token", `{"macAddress":"AC:CD:EF:12:34:59"}`, "", 204},
+		// Anonymous users can read devices without an owner
+		{"GET", "/api/v1/wake", "", "", "", `{"devices":[{"id":"5a498c7c-40ee-524d-98e9-de282f913dbf","macAddress":"AC:CD:EF:12:34:58"}]}`, 200},
+		{"GET", "/api/v1/wake", "", "s3cr3t", "", `{"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","macAddress":"AC:CD:EF:12:34:56","owner":"ci-bot"},{"id":"5a498c7c-40ee-524d-98e9-de282f913dbf","macAddress":"AC:CD:EF:12:34:58"}]}`, 200},
+		// Credentials are verified if given, and admin endpoints always require them
+		{"GET", "/api/v1/wake", "", "wrong", "", `{"status":401,"message":"Invalid token"}`, 401},
+		{"GET", "/api/v1/admin/events", "", "", "", `{"status":401,"message":"Authentication required"}`, 401},
+		{"GET", "/api/v1/admin/events", "", "s3cr3t", "", `{"status":403,"message":"Forbidden"}`, 403},
+	}
+	for i, tt := range tests {
+		r, err := http.NewRequest(tt.method, server.URL+tt.url, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.username != "" {
+			r.SetBasicAuth(tt.username, "secret")
+		}
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, res.StatusCode)
+		}
+		if got := string(data); got != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, got)
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	api := Server{
+		Auth:      testAuth{"alice": "secret", "admin": "admin"},
+		Recent:    event.NewRecorder(10),
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	request := func(url, username, password string) (string, int) {
+		r, err := http.NewRequest(http.MethodGet, server.URL+url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetBasicAuth(username, password)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode == 200 && res.Header.Get("Content-Type") != "application/atom+xml; charset=utf-8" {
+			t.Errorf("%s: want Atom content type, got %s", url, res.Header.Get("Content-Type"))
+		}
+		return string(data), res.StatusCode
+	}
+	r, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/wake", strings.NewReader(`{"name":"nas","macAddress":"AC:CD:EF:12:34:56"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetBasicAuth("alice", "secret")
+	if res, err := http.DefaultClient.Do(r); err != nil || res.StatusCode != 204 {
+		t.Fatalf("want status 204, got %v (%v)", res, err)
+	}
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	api.Recent.Handle(event.Event{Type: event.Online, Device: "fc2b1229-4d81-5601-88f3-99f11ef28aa6", MACAddress: "AC:CD:EF:12:34:56", Name: "nas", Time: now})
+	api.Recent.Handle(event.Event{Type: event.Failed, MACAddress: "AC:CD:EF:12:34:57", Time: now.Add(time.Minute), Error: "network is down"})
+
+	var feed struct {
+		Title   string `xml:"title"`
+		Updated string `xml:"updated"`
+		Entries []struct {
+			Title    string `xml:"title"`
+			ID       string `xml:"id"`
+			Updated  string `xml:"updated"`
+			Summary  string `xml:"summary"`
+			Category struct {
+				Term string `xml:"term,attr"`
+			} `xml:"category"`
+			Link struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+	data, status := request("/api/v1/events.atom", "admin", "admin")
+	if status != 200 {
+		t.Fatalf("want status 200, got %d: %s", status, data)
+	}
+	if err := xml.Unmarshal([]byte(data), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if feed.Title != "wakeup events" || feed.Updated != "2020-06-01T12:01:00Z" || len(feed.Entries) != 2 {
+		t.Fatalf("got unexpected feed %+v", feed)
+	}
+	if e := feed.Entries[0]; e.Title != "Failed to wake AC:CD:EF:12:34:57" || e.Summary != "network is down" || e.Category.Term != "failed" || e.Link.Href != "" {
+		t.Errorf("got unexpected entry %+v", e)
+	}
+	if e := feed.Entries[1]; e.Title != "nas is online" || e.Updated != "2020-06-01T12:00:00Z" || e.Link.Href != "/api/v1/devices/fc2b1229-4d81-5601-88f3-99f11ef28aa6" || !strings.HasPrefix(e.ID, "urn:uuid:") {
+		t.Errorf("got unexpected entry %+v", e)
+	}
+
+	// Users only see events of their devices
+	feed.Entries = nil
+	data, _ = request("/api/v1/events.atom", "alice", "secret")
+	if err := xml.Unmarshal([]byte(data), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != 1 || feed.Entries[0].Category.Term != "online" {
+		t.Errorf("want online event, got %+v", feed.Entries)
+	}
+
+	feed.Entries = nil
+	data, _ = request("/api/v1/events.atom?type=wake&type=failed&limit=1", "admin", "admin")
+	if err := xml.Unmarshal([]byte(data), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != 1 || feed.Entries[0].Category.Term != "failed" {
+		t.Errorf("want failed event, got %+v", feed.Entries)
+	}
+	for _, url := range []string{"/api/v1/events.atom?type=foo", "/api/v1/events.atom?limit=0"} {
+		if _, status := request(url, "admin", "admin"); status != 400 {
+			t.Errorf("%s: want status 400, got %d", url, status)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".history")
+	api := Server{
+		Auth:      testAuth{"alice": "alice", "bob": "bob", "admin": "admin"},
+		History:   history.Open(file.Name() + ".history"),
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for _, req := range []struct{ method, url, username, body string }{
+		{"PUT", "/api/v1/devices/AC:CD:EF:12:34:56", "alice", `{"name":"foo","groups":["media"]}`},
+		{"PUT", "/api/v1/devices/AC:CD:EF:12:34:56", "alice", `{"name":"bar","groups":["media"]}`},
+		// Unchanged devices are not recorded
+		{"PUT", "/api/v1/devices/AC:CD:EF:12:34:56", "alice", `{"name":"bar","groups":["media"]}`},
+		{"POST", "/api/v1/wake", "bob", `{"macAddress":"AC:CD:EF:12:34:57"}`},
+		{"DELETE", "/api/v1/devices/AC:CD:EF:12:34:56", "alice", ""},
+	} {
+		if _, status, err := httpRequestAs(req.method, server.URL+req.url, req.body, req.username, req.username); err != nil || status >= 300 {
+			t.Fatalf("%s %s: got status %d (%v)", req.method, req.url, status, err)
+		}
+	}
+	summary := func(h History) string {
+		var lines []string
+		for _, e := range h.Entries {
+			line := e.Action + " " + e.Actor + " " + e.Client + " " + e.Device + e.Group
+			if e.Result != "" {
+				line += " " + e.Result
+			}
+			for _, c := range e.Changes {
+				line += " " + c.Field + ":" + string(c.Before) + "->" + string(c.After)
+			}
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n")
+	}
+	var tests = []struct {
+		query    string
+		username string
+		want     string
+	}{
+		{"", "admin", `group.changed alice 127.0.0.1 media members:["fc2b1229-4d81-5601-88f3-99f11ef28aa6"]->
+device.removed alice 127.0.0.1 fc2b1229-4d81-5601-88f3-99f11ef28aa6
+device.added bob 127.0.0.1 9a18bd6c-44a4-5ed1-9ea6-139be112e3a6
+device.woken bob 127.0.0.1  ok
+device.changed alice 127.0.0.1 fc2b1229-4d81-5601-88f3-99f11ef28aa6 name:"foo"->"bar"
+group.changed alice 127.0.0.1 media members:->["fc2b1229-4d81-5601-88f3-99f11ef28aa6"]
+device.added alice 127.0.0.1 fc2b1229-4d81-5601-88f3-99f11ef28aa6`},
+		{"?limit=1", "admin", `group.changed alice 127.0.0.1 media members:["fc2b1229-4d81-5601-88f3-99f11ef28aa6"]->`},
+		{"?action=device.&actor=alice&limit=2", "admin", `device.removed alice 127.0.0.1 fc2b1229-4d81-5601-88f3-99f11ef28aa6
+device.changed alice 127.0.0.1 fc2b1229-4d81-5601-88f3-99f11ef28aa6 name:"foo"->"bar"`},
+		// Removed devices are found by MAC address
+		{"?device=ac-cd-ef-12-34-56&action=device.added", "admin", `device.added alice 127.0.0.1 fc2b1229-4d81-5601-88f3-99f11ef28aa6`},
+		// Wakes of devices not yet stored are found by MAC address
+		{"?device=9a18bd6c-44a4-5ed1-9ea6-139be112e3a6", "admin", `device.added bob 127.0.0.1 9a18bd6c-44a4-5ed1-9ea6-139be112e3a6
+device.woken bob 127.0.0.1  ok`},
+		{"?action=device.woken", "admin", `device.woken bob 127.0.0.1  ok`},
+		{"?offset=2&limit=2", "admin", `device.added bob 127.0.0.1 9a18bd6c-44a4-5ed1-9ea6-139be112e3a6
+device.woken bob 127.0.0.1  ok`},
+		{"?offset=7", "admin", ""},
+		{"?since=2000-01-01T00:00:00Z&until=2001-01-01T00:00:00Z", "admin", ""},
+		// Users only see their own changes, and changes of devices they have access to
+		{"", "bob", `device.added bob 127.0.0.1 9a18bd6c-44a4-5ed1-9ea6-139be112e3a6
+device.woken bob 127.0.0.1  ok`},
+		{"?offset=1", "bob", `device.woken bob 127.0.0.1  ok`},
+	}
+	for i, tt := range tests {
+		data, status, err := httpRequestAs("GET", server.URL+"/api/v1/history"+tt.query, "", tt.username, tt.username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != 200 {
+			t.Fatalf("#%d: want status 200, got %d: %s", i, status, data)
+		}
+		var h History
+		if err := json.Unmarshal([]byte(data), &h); err != nil {
+			t.Fatal(err)
+		}
+		if got := summary(h); got != tt.want {
+			t.Errorf("#%d: want\n%s\ngot\n%s", i, tt.want, got)
+		}
+	}
+	data, _, err := httpRequestAs("GET", server.URL+"/api/v1/history?action=device.&limit=2", "", "admin", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page History
+	if err := json.Unmarshal([]byte(data), &page); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/api/v1/history?action=device.&limit=2&offset=2"; page.Next != want {
+		t.Errorf("want next page %s, got %s", want, page.Next)
+	}
+	for _, query := range []string{"?limit=0", "?limit=foo", "?offset=-1", "?since=yesterday", "?device=foo"} {
+		if _, status, err := httpRequestAs("GET", server.URL+"/api/v1/history"+query, "", "admin", "admin"); err != nil || status != 400 {
+			t.Errorf("%s: want status 400, got %d (%v)", query, status, err)
+		}
+	}
+	h, err := api.History.Query(history.Filter{Action: history.DeviceRemoved})
+	if err != nil || len(h) != 1 || !strings.Contains(string(h[0].Before), `"name":"bar"`) {
+		t.Errorf("want removed device recorded, got %+v (%v)", h, err)
+	}
+}
+
+func TestSharing(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{
+		Auth:      testAuth{"alice": "alice", "bob": "bob", "admin": "admin"},
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		method   string
+		url      string
+		username string
+		body     string
+		response string
+		status   int
+	}{
+		{"POST", "/api/v1/wake", "alice", `{"macAddress":"AC:CD:EF:12:34:56","groups":["media"]}`, "", 204},
+		{"GET", "/api/v1/devices/AC:CD:EF:12:34:56/sharing", "bob", "", `{"status":404,"message":"Device not found: AC:CD:EF:12:34:56"}`, 404},
+		{"PUT", "/api/v1/devices/AC:CD:EF:12:34:56/sharing", "alice", `{"shares":[{"user":"bob","access":"foo"}]}`, `{"status":400,"message":"Invalid access: foo"}`, 400},
+		{"PUT", "/api/v1/devices/AC:CD:EF:12:34:56/sharing", "alice", `{"shares":[{"access":"wake"}]}`, `{"status":400,"message":"Share must have either user or group"}`, 400},
+		{"PUT", "/api/v1/devices/AC:CD:EF:12:34:56/sharing", "alice", `{"shares":[{"user":"bob","access":"wake"}]}`, `{"owner":"alice","shares":[{"user":"bob","access":"wake"}]}`, 200},
+		// Bob can wake, but not manage
+		{"GET", "/api/v1/wake", "bob", "", `{"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","macAddress":"AC:CD:EF:12:34:56","groups":["media"],"owner":"alice","shares":[{"user":"bob","access":"wake"}]}]}`, 200},
+		{"POST", "/api/v1/wake", "bob", `{"macAddress":"AC:CD:EF:12:34:56"}`, "", 204},
+		{"POST", "/api/v1/wake", "bob", `{"macAddress":"AC:CD:EF:12:34:56","onOnline":"http://192.0.2.1/hook"}`, `{"status":403,"message":"Forbidden"}`, 403},
+		{"POST", "/api/v1/wake", "bob", `{"macAddress":"AC:CD:EF:12:34:56","wakeAddress":"192.0.2.1"}`, `{"status":403,"message":"Forbidden"}`, 403},
+		{"POST", "/api/v1/groups/media/wake", "bob", "", `{"group":"media","simulated":false,"wakes":[{"macAddress":"AC:CD:EF:12:34:56","offset":"0s","sent":["AC:CD:EF:12:34:56"]}]}`, 200},
+		{"PUT", "/api/v1/devices/AC:CD:EF:12:34:56/sharing", "bob", `{"shares":[]}`, `{"status":403,"message":"Forbidden"}`, 403},
+		{"DELETE", "/api/v1/wake", "bob", `{"macAddress":"AC:CD:EF:12:34:56"}`, `{"status":403,"message":"Forbidden"}`, 403},
+		// Group members can manage
+		{"PUT", "/api/v1/devices/AC:CD:EF:12:34:56/sharing", "alice", `{"shares":[{"group":"family","access":"manage"}]}`, `{"owner":"alice","shares":[{"group":"family","access":"manage"}]}`, 200},
+		{"DELETE", "/api/v1/wake", "bob", `{"macAddress":"AC:CD:EF:12:34:56"}`, "", 204},
+	}
+	for i, tt := range tests {
+		data, status, err := httpRequestAs(tt.method, server.URL+tt.url, tt.body, tt.username, tt.username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if data != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+	}
+}
+
+func TestSyncSharing(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{
+		Auth:      testAuth{"alice": "alice", "bob": "bob"},
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		method   string
+		url      string
+		username string
+		body     string
+		response string
+		status   int
+	}{
+		{"POST", "/api/v1/wake", "alice", `{"macAddress":"AC:CD:EF:12:34:56","secureOnPassword":"01:23:45:67:89:AB"}`, "", 204},
+		{"GET", "/api/v1/sync?since=0", "alice", "", `{"revision":1,"reset":true,"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","macAddress":"AC:CD:EF:12:34:56","secureOnPassword":"01:23:45:67:89:AB","owner":"alice"}],"removed":[]}`, 200},
+		// Devices of other owners are neither synced nor reported as removed
+		{"GET", "/api/v1/sync?since=0", "bob", "", `{"revision":1,"reset":true,"devices":[],"removed":[]}`, 200},
+		{"DELETE", "/api/v1/wake", "alice", `{"macAddress":"AC:CD:EF:12:34:56"}`, "", 204},
+		{"GET", "/api/v1/sync?since=1", "bob", "", `{"revision":2,"reset":false,"devices":[],"removed":[]}`, 200},
+		{"GET", "/api/v1/sync?since=1", "alice", "", `{"revision":2,"reset":false,"devices":[],"removed":["AC:CD:EF:12:34:56"]}`, 200},
+	}
+	for i, tt := range tests {
+		data, status, err := httpRequestAs(tt.method, server.URL+tt.url, tt.body, tt.username, tt.username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if data != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	server, cacheFile := testServer()
+	defer os.Remove(cacheFile)
+	defer server.Close()
+	api := Server{cacheFile: cacheFile}
+
+	// Manually added device is left alone
+	if _, _, err := httpPost(server.URL+"/api/v1/wake", `{"name":"manual","macAddress":"AC:CD:EF:12:34:58"}`); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		records []inventory.Record
+		result  inventory.Result
+		devices string
+	}{
+		{
+			[]inventory.Record{{Name: "nas", MACAddress: "AC:CD:EF:12:34:56"}, {Name: "pc", MACAddress: "AC:CD:EF:12:34:57"}, {Name: "other", MACAddress: "AC:CD:EF:12:34:58"}},
+			inventory.Result{Added: 2},
+			`{"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas","macAddress":"AC:CD:EF:12:34:56","source":"netbox"},{"id":"9a18bd6c-44a4-5ed1-9ea6-139be112e3a6","name":"pc","macAddress":"AC:CD:EF:12:34:57","source":"netbox"},{"id":"5a498c7c-40ee-524d-98e9-de282f913dbf","name":"manual","macAddress":"AC:CD:EF:12:34:58"}]}`,
+		},
+		{
+			[]inventory.Record{{Name: "nas", MACAddress: "AC:CD:EF:12:34:56", IPAddress: "10.0.0.2"}},
+			inventory.Result{Updated: 1, Removed: 1},
+			`{"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas","macAddress":"AC:CD:EF:12:34:56","ipAddress":"10.0.0.2","source":"netbox"},{"id":"5a498c7c-40ee-524d-98e9-de282f913dbf","name":"manual","macAddress":"AC:CD:EF:12:34:58"}]}`,
+		},
+		{
+			[]inventory.Record{{Name: "nas", MACAddress: "AC:CD:EF:12:34:56", IPAddress: "10.0.0.2"}},
+			inventory.Result{},
+			`{"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas","macAddress":"AC:CD:EF:12:34:56","ipAddress":"10.0.0.2","source":"netbox"},{"id":"5a498c7c-40ee-524d-98e9-de282f913dbf","name":"manual","macAddress":"AC:CD:EF:12:34:58"}]}`,
+		},
+	}
+	for i, tt := range tests {
+		r, err := api.Reconcile("netbox", tt.records)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != tt.result {
+			t.Errorf("#%d: want %+v, got %+v", i, tt.result, r)
+		}
+		data, _, err := httpGet(server.URL + "/api/v1/wake")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data != tt.devices {
+			t.Errorf("#%d: want %s, got %s", i, tt.devices, data)
+		}
+	}
+}
+
+func TestNetBoxWebhook(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{NetBoxSecret: "secret", cacheFile: file.Name()}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	sign := func(body string) string {
+		mac := hmac.New(sha512.New, []byte("secret"))
+		mac.Write([]byte(body))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	created := `{"event":"created","model":"interface","data":{"mac_address":"ac:cd:ef:12:34:56","device":{"name":"nas"}}}`
+	updated := `{"event":"updated","model":"interface","data":{"mac_address":"ac:cd:ef:12:34:57","device":{"name":"nas"}},"snapshots":{"prechange":{"mac_address":"ac:cd:ef:12:34:56","device":1}}}`
+	deleted := `{"event":"deleted","model":"interface","data":{"mac_address":"ac:cd:ef:12:34:57","device":{"name":"nas"}}}`
+	var tests = []struct {
+		url       string
+		body      string
+		signature string
+		response  string
+		status    int
+	}{
+		{"/api/v1/webhooks/netbox", created, "foo", `{"status":401,"message":"Invalid signature"}`, 401},
+		{"/api/v1/webhooks/netbox?dryRun=true", created, sign(created), `{"dryRun":true,"changes":[{"action":"add","macAddress":"AC:CD:EF:12:34:56","name":"nas"}]}`, 200},
+		{"/api/v1/webhooks/netbox", created, sign(created), `{"dryRun":false,"changes":[{"action":"add","macAddress":"AC:CD:EF:12:34:56","name":"nas"}]}`, 200},
+		{"/api/v1/webhooks/netbox", updated, sign(updated), `{"dryRun":false,"changes":[{"action":"remove","macAddress":"AC:CD:EF:12:34:56"},{"action":"add","macAddress":"AC:CD:EF:12:34:57","name":"nas"}]}`, 200},
+		{"/api/v1/webhooks/netbox", `{"model":"device"}`, sign(`{"model":"device"}`), `{"dryRun":false,"changes":[]}`, 200},
+		{"/api/v1/webhooks/netbox", deleted, sign(deleted), `{"dryRun":false,"changes":[{"action":"remove","macAddress":"AC:CD:EF:12:34:57"}]}`, 200},
+	}
+	for i, tt := range tests {
+		r, err := http.NewRequest(http.MethodPost, server.URL+tt.url, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("X-Hook-Signature", tt.signature)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, res.StatusCode)
+		}
+		if got := string(data); got != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, got)
+		}
+	}
+}
+
+func TestDeviceResources(t *testing.T) {
+	server, _ := testServer()
+	defer server.Close()
+
+	nas := `{"name":"nas","macAddress":"ac:cd:ef:12:34:56","ipAddress":"10.0.0.2","groups":["lab"]}`
+	var tests = []struct {
+		method   string
+		url      string
+		body     string
+		response string
+		status   int
+	}{
+		{"GET", "/api/v1/devices/foo", "", `{"status":400,"message":"Invalid device ID or MAC address: foo"}`, 400},
+		{"GET", "/api/v1/devices/ac:cd:ef:12:34:56", "", `{"status":404,"message":"Device not found: AC:CD:EF:12:34:56"}`, 404},
+		{"PUT", "/api/v1/devices/ac:cd:ef:12:34:57", nas, `{"status":400,"message":"MAC address ac:cd:ef:12:34:56 does not match AC:CD:EF:12:34:57"}`, 400},
+		{"PUT", "/api/v1/devices/ac:cd:ef:12:34:56", nas, `{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas","description":"","macAddress":"AC:CD:EF:12:34:56","macAddresses":[],"ipAddress":"10.0.0.2","groups":["lab"],"notes":"","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":0,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`, 201},
+		{"PUT", "/api/v1/devices/ac:cd:ef:12:34:56", nas, `{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas","description":"","macAddress":"AC:CD:EF:12:34:56","macAddresses":[],"ipAddress":"10.0.0.2","groups":["lab"],"notes":"","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":0,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`, 200},
+		{"PUT", "/api/v1/devices/AC-CD-EF-12-34-56", `{"name":"nas2"}`, `{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas2","description":"","macAddress":"AC:CD:EF:12:34:56","macAddresses":[],"ipAddress":"","groups":[],"notes":"","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":0,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`, 200},
+		{"GET", "/api/v1/devices/ac:cd:ef:12:34:56", "", `{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas2","description":"","macAddress":"AC:CD:EF:12:34:56","macAddresses":[],"ipAddress":"","groups":[],"notes":"","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":0,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`, 200},
+		{"GET", "/api/v1/sync?since=0", "", `{"revision":2,"reset":true,"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"nas2","macAddress":"AC:CD:EF:12:34:56"}],"removed":[]}`, 200},
+		{"PUT", "/api/v1/devices/ac:cd:ef:12:34:57", `{}`, `{"id":"9a18bd6c-44a4-5ed1-9ea6-139be112e3a6","name":"","description":"","macAddress":"AC:CD:EF:12:34:57","macAddresses":[],"ipAddress":"","groups":[],"notes":"","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":0,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`, 201},
+		{"GET", "/api/v1/groups/lab", "", `{"id":"lab","name":"lab","members":[]}`, 200},
+		{"PUT", "/api/v1/groups/lab", `{"members":["10:22:33:44:55:66"]}`, `{"status":400,"message":"Device not found: 10:22:33:44:55:66"}`, 400},
+		{"PUT", "/api/v1/groups/lab", `{"members":["ac:cd:ef:12:34:56","ac:cd:ef:12:34:57"]}`, `{"id":"lab","name":"lab","members":["fc2b1229-4d81-5601-88f3-99f11ef28aa6","9a18bd6c-44a4-5ed1-9ea6-139be112e3a6"]}`, 200},
+		{"PUT", "/api/v1/groups/lab", `{"members":["ac:cd:ef:12:34:57"]}`, `{"id":"lab","name":"lab","members":["9a18bd6c-44a4-5ed1-9ea6-139be112e3a6"]}`, 200},
+		{"DELETE", "/api/v1/groups/lab", "", "", 204},
+		{"GET", "/api/v1/groups/lab", "", `{"id":"lab","name":"lab","members":[]}`, 200},
+		{"PUT", "/api/v1/devices/ac:cd:ef:12:34:56", `{"notes":"` + strings.Repeat("x", 4097) + `"}`, `{"status":400,"message":"Notes exceed 4096 bytes"}`, 400},
+		{"PUT", "/api/v1/devices/ac:cd:ef:12:34:56", `{"notes":"Hold **F12**, WOL setting under *Power*"}`, `{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"","description":"","macAddress":"AC:CD:EF:12:34:56","macAddresses":[],"ipAddress":"","groups":[],"notes":"Hold **F12**, WOL setting under *Power*","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":0,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`, 200},
+		{"DELETE", "/api/v1/devices/ac:cd:ef:12:34:56", "", "", 204},
+		{"DELETE", "/api/v1/devices/ac:cd:ef:12:34:56", "", "", 204},
+		{"PUT", "/api/v1/devices/9a18bd6c-44a4-5ed1-9ea6-139be112e3a6", `{"name":"pc","description":"","macAddress":"10:22:33:44:55:66"}`, `{"id":"9a18bd6c-44a4-5ed1-9ea6-139be112e3a6","name":"pc","description":"","macAddress":"10:22:33:44:55:66","macAddresses":[],"ipAddress":"","groups":[],"notes":"","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":0,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`, 200},
+		{"GET", "/api/v1/devices/10:22:33:44:55:66", "", `{"id":"9a18bd6c-44a4-5ed1-9ea6-139be112e3a6","name":"pc","description":"","macAddress":"10:22:33:44:55:66","macAddresses":[],"ipAddress":"","groups":[],"notes":"","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":0,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`, 200},
+		{"GET", "/api/v1/devices/ac:cd:ef:12:34:57", "", `{"status":404,"message":"Device not found: AC:CD:EF:12:34:57"}`, 404},
+		{"PUT", "/api/v1/devices/0e6dc0f2-0000-4000-8000-000000000001", `{}`, `{"status":400,"message":"Missing MAC address"}`, 400},
+		{"PUT", "/api/v1/devices/0e6dc0f2-0000-4000-8000-000000000001", `{"macAddress":"10:22:33:44:55:66"}`, `{"status":409,"message":"MAC address 10:22:33:44:55:66 belongs to device 9a18bd6c-44a4-5ed1-9ea6-139be112e3a6"}`, 409},
+		{"PUT", "/api/v1/devices/0e6dc0f2-0000-4000-8000-000000000001", `{"name":"new","description":"","macAddress":"ac:cd:ef:12:34:56"}`, `{"id":"0e6dc0f2-0000-4000-8000-000000000001","name":"new","description":"","macAddress":"AC:CD:EF:12:34:56","macAddresses":[],"ipAddress":"","groups":[],"notes":"","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":0,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`, 201},
+		{"POST", "/api/v1/devices/ac:cd:ef:12:34:56", "", `{"status":405,"message":"Invalid method POST, must be GET, PUT or DELETE"}`, 405},
+	}
+	for i, tt := range tests {
+		data, status, err := httpRequest(tt.method, server.URL+tt.url, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if data != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+	}
+}
+
+func TestDeviceResourceIfMatch(t *testing.T) {
+	server, _ := testServer()
+	defer server.Close()
+
+	url := server.URL + "/api/v1/devices/AC:CD:EF:12:34:56"
+	put := func(body, ifMatch string) *http.Response {
+		r, err := http.NewRequest(http.MethodPut, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+	if res := put(`{"name":"foo"}`, `"foo"`); res.StatusCode != 412 {
+		t.Errorf("want status 412, got %d", res.StatusCode)
+	}
+	res := put(`{"name":"foo"}`, "")
+	if got := res.Header.Get("Location"); got != "/api/v1/devices/fc2b1229-4d81-5601-88f3-99f11ef28aa6" {
+		t.Errorf("want Location header, got %q", got)
+	}
+	tag := res.Header.Get("ETag")
+	if tag == "" {
+		t.Fatal("want ETag header")
+	}
+	if res := put(`{"name":"bar"}`, `"foo"`); res.StatusCode != 412 {
+		t.Errorf("want status 412, got %d", res.StatusCode)
+	}
+	if res := put(`{"name":"bar"}`, tag); res.StatusCode != 200 {
+		t.Errorf("want status 200, got %d", res.StatusCode)
+	}
+	if res := put(`{"name":"baz"}`, tag); res.StatusCode != 412 {
+		t.Errorf("want status 412 for stale ETag, got %d", res.StatusCode)
+	}
+}
+
+func TestStatusz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{ConfigMap: inventory.NewConfigMap(dir), Auth: testAuth{}, cacheFile: file.Name()}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	device := `{"apiVersion":"wakeup/v1","kind":"Device","metadata":{"name":"nas"},"spec":{"macAddress":"ac:cd:ef:12:34:56"}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "nas.json"), []byte(device), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.ConfigMap.Sync(api.Reconcile); err != nil {
+		t.Fatal(err)
+	}
+	data, status, err := httpGet(server.URL + "/statusz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 200 {
+		t.Errorf("want status 200, got %d", status)
+	}
+	prefix := `{"status":"ok","revision":1,"devices":1,"configMap":{"dir":"` + dir + `","checksum":`
+	if !strings.HasPrefix(data, prefix) {
+		t.Errorf("want response starting with %q, got %q", prefix, data)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "nas.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	api.ConfigMap.Sync(api.Reconcile)
+	data, status, err = httpGet(server.URL + "/statusz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 503 {
+		t.Errorf("want status 503, got %d", status)
+	}
+	if !strings.HasPrefix(data, `{"status":"error","revision":1,"devices":1,`) {
+		t.Errorf("unexpected response %q", data)
+	}
+}
+
+func TestInternalHandler(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{InternalAddr: "127.0.0.1:0", AdminToken: "secret", cacheFile: file.Name()}
+	public := httptest.NewServer(api.Handler())
+	defer public.Close()
+	internal := httptest.NewServer(api.InternalHandler())
+	defer internal.Close()
+
+	var tests = []struct {
+		server *httptest.Server
+		url    string
+		status int
+	}{
+		{public, "/api/v1/wake", 200},
+		{public, "/healthz", 404},
+		{public, "/statusz", 404},
+		{public, "/metrics", 404},
+		{public, "/debug/pprof/", 404},
+		{public, "/api/v1/admin/events", 404},
+		{internal, "/healthz", 200},
+		{internal, "/statusz", 200},
+		{internal, "/metrics", 200},
+		{internal, "/debug/pprof/", 200},
+		{internal, "/api/v1/admin/events", 401},
+		{internal, "/api/v1/wake", 404},
+	}
+	for i, tt := range tests {
+		_, status, err := httpGet(tt.server.URL + tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: %s: want status %d, got %d", i, tt.url, tt.status, status)
+		}
+	}
+}
+
+func TestPrerequisites(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	up := map[string]bool{"switch": true, "ups": true}
+	api := Server{
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+		checkFunc: func(ctx context.Context, checks []prereq.Check) []prereq.Result {
+			results := make([]prereq.Result, 0, len(checks))
+			for _, c := range checks {
+				r := prereq.Result{Name: c.Name, Mode: c.Mode, OK: up[c.Name]}
+				if !r.OK {
+					r.Error = "down"
+				}
+				results = append(results, r)
+			}
+			return results
+		},
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	device := `{"macAddress":"AC:CD:EF:12:34:56","prerequisites":[` +
+		`{"name":"switch","type":"tcp","target":"10.0.0.1:22","mode":"refuse"},` +
+		`{"name":"ups","type":"http","target":"http://ups","mode":"warn"}]}`
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"prerequisites":[{"name":"switch","type":"ping"}]}`); err != nil || status != 400 {
+		t.Fatalf("want status 400 for invalid prerequisite, got %d (%v)", status, err)
+	}
+
+	// Prerequisites of a request are ignored
+	up["switch"] = false
+	if _, status, err := httpPost(server.URL+"/api/v1/wake", device); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	up["switch"] = true
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", device); err != nil || status != 200 {
+		t.Fatalf("want status 200, got %d (%v)", status, err)
+	}
+
+	// Prerequisites of the stored device are used for subsequent wakes
+	up["ups"] = false
+	r, err := http.Post(server.URL+"/api/v1/wake", "application/json", strings.NewReader(`{"macAddress":"AC:CD:EF:12:34:56"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if want := `199 wakeup "Prerequisite ups failed"`; r.StatusCode != 204 || r.Header.Get("Warning") != want {
+		t.Errorf("want status 204 with warning %q, got %d with %q", want, r.StatusCode, r.Header.Get("Warning"))
+	}
+
+	up["switch"] = false
+	data, status, err := httpPost(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"status":412,"message":"Prerequisites failed for device with address AC:CD:EF:12:34:56: switch","prerequisites":[{"name":"switch","mode":"refuse","ok":false}]}`
+	if status != 412 || data != want {
+		t.Errorf("want status 412 and response %q, got %d and %q", want, status, data)
+	}
+}
+
+type testUPS struct{ onBattery bool }
+
+func (u *testUPS) Status() (ups.Status, error) { return ups.Status{OnBattery: u.onBattery}, nil }
+
+func TestUPS(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	var woken []string
+	reader := &testUPS{onBattery: true}
+	api := Server{
+		wakeFunc: func(hwAddr net.HardwareAddr, _ wol.Options) error {
+			woken = append(woken, strings.ToUpper(hwAddr.String()))
+			return nil
+		},
+		cacheFile: file.Name(),
+		UPS:       ups.NewMonitor(reader),
+	}
+	api.UPS.Poll()
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		policy   string
+		body     string
+		response string
+		status   int
+	}{
+		{UPSRefuse, `{"macAddress":"AC:CD:EF:12:34:56"}`, `{"status":503,"message":"Refusing to wake non-essential device with address AC:CD:EF:12:34:56: UPS is on battery"}`, 503},
+		{UPSRefuse, `{"macAddress":"AC:CD:EF:12:34:57","essential":true}`, "", 204},
+		{UPSDefer, `{"macAddress":"AC:CD:EF:12:34:56"}`, `{"macAddress":"AC:CD:EF:12:34:56","reason":"UPS is on battery"}`, 202},
+		{UPSDefer, `{"macAddress":"AC:CD:EF:12:34:56"}`, `{"macAddress":"AC:CD:EF:12:34:56","reason":"UPS is on battery"}`, 202},
+	}
+	for i, tt := range tests {
+		api.UPSPolicy = tt.policy
+		data, status, err := httpPost(server.URL+"/api/v1/wake", tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if data != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+	}
+	if len(woken) != 1 || woken[0] != "AC:CD:EF:12:34:57" {
+		t.Fatalf("want only essential device woken, got %v", woken)
+	}
+	data, _, err := httpGet(server.URL + "/statusz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"ups":{"onBattery":true,"raw":"","deferred":1}`; !strings.Contains(data, want) {
+		t.Errorf("want status to contain %q, got %q", want, data)
+	}
+
+	reader.onBattery = false
+	api.UPS.Poll()
+	api.ResumeDeferred()
+	if len(woken) != 2 || woken[1] != "AC:CD:EF:12:34:56" {
+		t.Errorf("want deferred device woken once, got %v", woken)
+	}
+	if _, status, _ := httpPost(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`); status != 204 {
+		t.Errorf("want status 204 on line power, got %d", status)
+	}
+}
+
+func TestEnergy(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{cacheFile: file.Name(), Energy: energy.NewTracker(), EnergyPrice: 0.3}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	devices := map[string]string{
+		"AC:CD:EF:12:34:56": `{"name":"workstation","watts":250,"groups":["office"]}`,
+		"AC:CD:EF:12:34:57": `{"name":"nas","watts":50,"groups":["office","storage"]}`,
+		"AC:CD:EF:12:34:58": `{"name":"printer"}`,
+	}
+	for mac, body := range devices {
+		if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/"+mac, body); err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d (%v)", status, err)
+		}
+	}
+	now := time.Now()
+	for _, mac := range []string{"AC:CD:EF:12:34:56", "AC:CD:EF:12:34:57"} {
+		api.Energy.Handle(event.Event{Type: event.Online, MACAddress: mac, Time: now.Add(-6 * time.Hour)})
+		api.Energy.Handle(event.Event{Type: event.Offline, MACAddress: mac, Time: now.Add(-2 * time.Hour)})
+	}
+
+	data, status, err := httpGet(server.URL + "/api/v1/energy?group=storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `"pricePerKWh":0.3,"devices":[{"macAddress":"AC:CD:EF:12:34:57","name":"nas","watts":50,"uptime":"4h0m0s","kWh":0.2,"cost":0.06}],` +
+		`"groups":[{"name":"storage","kWh":0.2,"cost":0.06}],"total":{"kWh":0.2,"cost":0.06}}`
+	if status != 200 || !strings.HasSuffix(data, want) {
+		t.Errorf("want status 200 and response ending with %q, got %d and %q", want, status, data)
+	}
+	data, _, err = httpGet(server.URL + "/api/v1/energy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `"groups":[{"name":"office","kWh":1.2,"cost":0.36},{"name":"storage","kWh":0.2,"cost":0.06}],"total":{"kWh":1.2,"cost":0.36}}`
+	if !strings.HasSuffix(data, want) {
+		t.Errorf("want response ending with %q, got %q", want, data)
+	}
+	data, _, err = httpGet(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`wakeup_device_uptime_seconds_total{mac_address="AC:CD:EF:12:34:56",name="workstation"} 14400`,
+		`wakeup_device_energy_kwh_total{mac_address="AC:CD:EF:12:34:56",name="workstation"} 1`,
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("want metrics to contain %q, got %q", want, data)
+		}
+	}
+}
+
+func TestWakeScheduled(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	var woken []string
+	api := Server{
+		wakeFunc: func(hwAddr net.HardwareAddr, _ wol.Options) error {
+			woken = append(woken, strings.ToUpper(hwAddr.String()))
+			return nil
+		},
+		Budget:    budget.New(1, 2, time.Minute),
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for _, mac := range []string{"AC:CD:EF:12:34:56", "AC:CD:EF:12:34:57"} {
+		if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/"+mac, `{"groups":["rigs"]}`); err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d (%v)", status, err)
+		}
+	}
+	if err := api.WakeScheduled(schedule.Schedule{Group: "rigs"}); err != nil {
+		t.Fatal(err)
+	}
+	// Trip the circuit breaker, pausing scheduled wakes
+	api.Budget.Allow(false)
+	if err := api.WakeScheduled(schedule.Schedule{MACAddress: "10:22:33:44:55:66"}); err == nil {
+		t.Fatal("want error when budget is paused")
+	}
+	if err := api.WakeScheduled(schedule.Schedule{Group: "foo"}); err == nil {
+		t.Fatal("want error for empty group")
+	}
+	if err := api.WakeScheduled(schedule.Schedule{Device: "0e6dc0f2-0000-4000-8000-000000000001"}); err == nil {
+		t.Fatal("want error for unknown device")
+	}
+	if want := []string{"AC:CD:EF:12:34:56", "AC:CD:EF:12:34:57"}; fmt.Sprint(woken) != fmt.Sprint(want) {
+		t.Errorf("want %v woken, got %v", want, woken)
+	}
+}
+
+func TestPublicWake(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	var woken []string
+	api := Server{
+		Auth:    testAuth{"alice": "alice", "bob": "bob"},
+		TOTPKey: "key",
+		wakeFunc: func(hwAddr net.HardwareAddr, _ wol.Options) error {
+			woken = append(woken, strings.ToUpper(hwAddr.String()))
+			return nil
+		},
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	id := "fc2b1229-4d81-5601-88f3-99f11ef28aa6"
+	if _, status, err := httpRequestAs("POST", server.URL+"/api/v1/wake", `{"name":"media","macAddress":"AC:CD:EF:12:34:56","publicWake":1,"shares":[{"user":"bob","access":"wake"}]}`, "alice", "alice"); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	woken = nil
+	// Public wakes must be enabled explicitly
+	if data, status, err := httpRequestAs("GET", server.URL+"/api/v1/devices/"+id+"/totp", "", "alice", "alice"); err != nil || status != 200 || data != `{"enabled":false}` {
+		t.Fatalf("want public wake disabled, got %d %s (%v)", status, data, err)
+	}
+	if _, status, err := httpGet(server.URL + "/wake/" + id); err != nil || status != 404 {
+		t.Fatalf("want status 404, got %d (%v)", status, err)
+	}
+	if data, status, err := httpRequestAs("PUT", server.URL+"/api/v1/devices/"+id+"/totp", "", "bob", "bob"); err != nil || status != 403 {
+		t.Fatalf("want status 403 for user who cannot manage device, got %d %s (%v)", status, data, err)
+	}
+	data, status, err := httpRequestAs("PUT", server.URL+"/api/v1/devices/"+id+"/totp", "", "alice", "alice")
+	if err != nil || status != 200 {
+		t.Fatalf("want status 200, got %d %s (%v)", status, data, err)
+	}
+	var res PublicWakeResource
+	if err := json.Unmarshal([]byte(data), &res); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/wake/" + id; !res.Enabled || res.URL != want || !strings.HasPrefix(res.URI, "otpauth://totp/wakeup:media?") {
+		t.Fatalf("want public wake enabled at %s, got %+v", want, res)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(res.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := totp.Code(secret, time.Now())
+
+	if data, status, err := httpGet(server.URL + "/wake/" + id); err != nil || status != 200 || !strings.Contains(data, "<title>Wake media</title>") {
+		t.Fatalf("want wake page, got %d %s (%v)", status, data, err)
+	}
+	var tests = []struct {
+		url      string
+		body     string
+		response string
+		status   int
+	}{
+		{"/api/v1/public/devices/" + id + "/wake", `{"code":"000000"}`, `{"status":401,"message":"Invalid code"}`, 401},
+		{"/api/v1/public/devices/" + id + "/wake", `{"code":"` + code + `"}`, "", 204},
+		// Codes cannot be reused
+		{"/api/v1/public/devices/" + id + "/wake", `{"code":"` + code + `"}`, `{"status":401,"message":"Invalid code"}`, 401},
+		{"/api/v1/public/devices/9a18bd6c-44a4-5ed1-9ea6-139be112e3a6/wake", `{"code":"` + code + `"}`, `{"status":404,"message":"Device not found: 9a18bd6c-44a4-5ed1-9ea6-139be112e3a6"}`, 404},
+		{"/api/v1/public/devices/" + id + "/wake", `{"code":"000000"}`, `{"status":401,"message":"Invalid code"}`, 401},
+		{"/api/v1/public/devices/" + id + "/wake", `{"code":"000000"}`, `{"status":401,"message":"Invalid code"}`, 401},
+		{"/api/v1/public/devices/" + id + "/wake", `{"code":"000000"}`, `{"status":401,"message":"Invalid code"}`, 401},
+		// Too many invalid codes locks the device
+		{"/api/v1/public/devices/" + id + "/wake", `{"code":"000000"}`, `{"status":429,"message":"Too many invalid codes, try again later"}`, 429},
+	}
+	for i, tt := range tests {
+		data, status, err := httpPost(server.URL+tt.url, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if data != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+	}
+	if want := []string{"AC:CD:EF:12:34:56"}; fmt.Sprint(woken) != fmt.Sprint(want) {
+		t.Errorf("want %v woken, got %v", want, woken)
+	}
+	// The secret is never stored with the device, and disabling public wakes invalidates it
+	if data, _, err := httpRequestAs("GET", server.URL+"/api/v1/wake", "", "alice", "alice"); err != nil || strings.Contains(data, res.Secret) {
+		t.Errorf("want secret excluded from devices, got %s (%v)", data, err)
+	}
+	if _, status, err := httpRequestAs("DELETE", server.URL+"/api/v1/devices/"+id+"/totp", "", "alice", "alice"); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	if data, status, err := httpPost(server.URL+"/wake/"+id, ""); err != nil || status != 404 {
+		t.Errorf("want status 404 after disabling public wake, got %d %s (%v)", status, data, err)
+	}
+}
+
+func TestWakeRemote(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	var woken []string
+	api := Server{
+		wakeFunc: func(hwAddr net.HardwareAddr, _ wol.Options) error {
+			woken = append(woken, strings.ToUpper(hwAddr.String()))
+			return nil
+		},
+		cacheFile:          file.Name(),
+		RemoteAllowUnknown: true,
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"NAS","macAddresses":["AC:CD:EF:12:34:57"]}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	for _, mac := range []string{"12:34:56:AB:CD:EF", "AC:CD:EF:12:34:58"} {
+		if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/"+mac, `{"name":"rig"}`); err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d (%v)", status, err)
+		}
+	}
+	var tests = []struct {
+		ref string
+		ok  bool
+	}{
+		{"fc2b1229-4d81-5601-88f3-99f11ef28aa6", true},
+		{"nas", true},
+		{"rig", false},
+		{"ac-cd-ef-12-34-56", true},
+		{"10:22:33:44:55:66", true},
+		{"0e6dc0f2-0000-4000-8000-000000000001", false},
+		{"foo", false},
+		{"FF:FF:FF:FF:FF:FF", false},
+		{"01:00:5E:00:00:01", false},
+	}
+	for i, tt := range tests {
+		if err := api.WakeRemote("alice", tt.ref); (err == nil) != tt.ok {
+			t.Errorf("#%d: WakeRemote(%q) = %v, want ok = %t", i, tt.ref, err, tt.ok)
+		}
+	}
+	// Group addresses are refused by every automated wake
+	if err := api.WakeMQTT("FF:FF:FF:FF:FF:FF"); !errors.Is(err, wol.ErrInvalidMAC) {
+		t.Errorf("want %s, got %v", wol.ErrInvalidMAC, err)
+	}
+	if err := api.WakeScheduled(schedule.Schedule{MACAddress: "01:00:5E:00:00:01"}); err == nil || !strings.Contains(err.Error(), wol.ErrInvalidMAC.Error()) {
+		t.Errorf("want %s, got %v", wol.ErrInvalidMAC, err)
+	}
+	want := []string{"AC:CD:EF:12:34:56", "AC:CD:EF:12:34:57", "AC:CD:EF:12:34:56", "AC:CD:EF:12:34:57", "AC:CD:EF:12:34:56", "AC:CD:EF:12:34:57", "10:22:33:44:55:66"}
+	if fmt.Sprint(woken) != fmt.Sprint(want) {
+		t.Errorf("want %v woken, got %v", want, woken)
+	}
+}
+
+func TestRemoteSharing(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	var woken []string
+	api := Server{
+		Auth: testAuth{"alice": "alice"},
+		wakeFunc: func(hwAddr net.HardwareAddr, _ wol.Options) error {
+			woken = append(woken, strings.ToUpper(hwAddr.String()))
+			return nil
+		},
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for _, req := range []struct{ method, url, body string }{
+		{"POST", "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56","name":"nas"}`},
+		{"POST", "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:57","name":"tv"}`},
+		{"PUT", "/api/v1/devices/AC:CD:EF:12:34:57/sharing", `{"shares":[{"user":"mqtt","access":"wake"}]}`},
+	} {
+		if data, status, err := httpRequestAs(req.method, server.URL+req.url, req.body, "alice", "alice"); err != nil || status >= 300 {
+			t.Fatalf("%s %s: got status %d: %s (%v)", req.method, req.url, status, data, err)
+		}
+	}
+	woken = nil
+	var tests = []struct {
+		wake func(string) error
+		ref  string
+		ok   bool
+	}{
+		// Users of SSH keys wake the devices they own, or which are shared with them
+		{func(ref string) error { return api.WakeRemote("alice", ref) }, "nas", true},
+		{func(ref string) error { return api.WakeRemote("bob", ref) }, "nas", false},
+		{func(ref string) error { return api.WakeRemote("bob", ref) }, "tv", false},
+		{api.WakeMQTT, "tv", true},
+		{api.WakeMQTT, "nas", false},
+		{api.WakeEmail, "tv", false},
+		// Unknown MAC addresses are only woken if allowed
+		{api.WakeMQTT, "AC:CD:EF:12:34:58", false},
+	}
+	for i, tt := range tests {
+		if err := tt.wake(tt.ref); (err == nil) != tt.ok {
+			t.Errorf("#%d: wake %q = %v, want ok = %t", i, tt.ref, err, tt.ok)
+		}
+	}
+	api.RemoteAllowUnknown = true
+	if err := api.WakeMQTT("AC:CD:EF:12:34:58"); err != nil {
+		t.Errorf("want unknown device woken, got %v", err)
+	}
+	want := []string{"AC:CD:EF:12:34:56", "AC:CD:EF:12:34:57", "AC:CD:EF:12:34:58"}
+	if fmt.Sprint(woken) != fmt.Sprint(want) {
+		t.Errorf("want %v woken, got %v", want, woken)
+	}
+	// Shutting down requires managing the device
+	if err := api.ShutdownMQTT("tv"); !errors.Is(err, errForbidden) {
+		t.Errorf("want %v, got %v", errForbidden, err)
+	}
+	if err := api.ShutdownMQTT("nas"); err == nil || errors.Is(err, errForbidden) {
+		t.Errorf("want device not found, got %v", err)
+	}
+}
+
+func TestBudgetWait(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{
+		wakeFunc:   func(net.HardwareAddr, wol.Options) error { return nil },
+		Budget:     budget.New(20, 1, time.Minute),
+		BudgetWait: time.Second,
+		cacheFile:  file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for i := 0; i < 3; i++ {
+		if _, status, err := httpPost(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`); err != nil || status != 204 {
+			t.Fatalf("#%d: want status 204 after waiting for budget, got %d (%v)", i, status, err)
+		}
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{
+		Auth:      testAuth{"alice": "secret", "bob": "secret", "admin": "admin"},
+		Quotas:    quota.New(quota.Limit{PerHour: 1}),
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+	}
+	api.Quotas.Set("bob", quota.Limit{PerHour: 2, PerDay: 10})
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		method   string
+		url      string
+		user     string
+		body     string
+		response string
+		status   int
+	}{
+		{"POST", "/api/v1/wake", "alice", `{"macAddress":"AC:CD:EF:12:34:56"}`, "", 204},
+		{"POST", "/api/v1/wake", "alice", `{"macAddress":"AC:CD:EF:12:34:56"}`, `{"status":429,"message":"Quota exceeded for alice"}`, 429},
+		{"POST", "/api/v1/wake", "bob", `{"macAddress":"AC:CD:EF:12:34:57"}`, "", 204},
+		{"POST", "/api/v1/wake", "bob", `{"macAddress":"AC:CD:EF:12:34:57"}`, "", 204},
+		{"GET", "/api/v1/quota", "alice", "", `{"subject":"alice","hour":1,"day":1,"limit":{"perHour":1,"perDay":0}}`, 200},
+		{"GET", "/api/v1/admin/quotas", "alice", "", `{"status":403,"message":"Forbidden"}`, 403},
+		{"GET", "/api/v1/admin/quotas", "admin", "", `[{"subject":"alice","hour":1,"day":1,"limit":{"perHour":1,"perDay":0}},{"subject":"bob","hour":2,"day":2,"limit":{"perHour":2,"perDay":10}}]`, 200},
+	}
+	for i, tt := range tests {
+		password := "secret"
+		if tt.user == "admin" {
+			password = "admin"
+		}
+		data, status, err := httpRequestAs(tt.method, server.URL+tt.url, tt.body, tt.user, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if data != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+	}
+}
+
+func TestWakeCooldown(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	wakes := 0
+	api := Server{
+		WakeCooldown: time.Minute,
+		wakeFunc:     func(net.HardwareAddr, wol.Options) error { wakes++; return nil },
+		cacheFile:    file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		body       string
+		response   string
+		status     int
+		retryAfter string
+	}{
+		{`{"macAddress":"AC:CD:EF:12:34:56"}`, "", 204, ""},
+		{`{"macAddress":"AC:CD:EF:12:34:56"}`, `{"status":429,"message":"Wake cooldown of device with address AC:CD:EF:12:34:56 has not passed"}`, 429, "60"},
+		{`{"macAddress":"AC:CD:EF:12:34:57"}`, "", 204, ""},
+	}
+	for i, tt := range tests {
+		res, err := http.Post(server.URL+"/api/v1/wake", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, res.StatusCode)
+		}
+		if string(data) != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+		if got := res.Header.Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("#%d: want Retry-After %q, got %q", i, tt.retryAfter, got)
+		}
+	}
+	if wakes != 2 {
+		t.Errorf("want 2 wakes, got %d", wakes)
+	}
+
+	// Automated wakes are subject to the same cooldown
+	if err := api.WakeRemote("alice", "AC:CD:EF:12:34:57"); !errors.Is(err, errCooldown) {
+		t.Errorf("want %v, got %v", errCooldown, err)
+	}
+}
+
+func TestClientLimit(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{
+		Limiter:   ratelimit.New(0.1, 2),
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for i, tt := range []struct {
+		status  int
+		headers string
+	}{{200, "2/1/10"}, {204, "2/0/20"}, {429, "//"}} {
+		status := tt.status
+		method, body := http.MethodGet, ""
+		if i > 0 {
+			method, body = http.MethodPost, `{"macAddress":"AC:CD:EF:12:34:56"}`
+		}
+		r, err := http.NewRequest(method, server.URL+"/api/v1/wake", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != status {
+			t.Errorf("#%d: want status %d, got %d: %s", i, status, res.StatusCode, data)
+		}
+		h := res.Header
+		if got := h.Get("X-RateLimit-Limit") + "/" + h.Get("X-RateLimit-Remaining") + "/" + h.Get("X-RateLimit-Reset"); got != tt.headers {
+			t.Errorf("#%d: want headers %q, got %q", i, tt.headers, got)
+		}
+		if status != 429 {
+			continue
+		}
+		if want := `{"status":429,"message":"Rate limit exceeded for 127.0.0.1"}`; string(data) != want {
+			t.Errorf("#%d: want response %q, got %q", i, want, data)
+		}
+		if got := res.Header.Get("Retry-After"); got != "10" {
+			t.Errorf("#%d: want Retry-After 10, got %q", i, got)
+		}
+	}
+}
+
+func TestBans(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{
+		Auth:      testAuth{"admin": "admin"},
+		Bans:      ban.New(2, time.Minute, time.Hour),
+		TrapPaths: []string{"/wp-login.php", "/.git/"},
+		cacheFile: file.Name(),
+	}
+	h := api.Handler()
+	request := func(method, path, client string, admin bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = client + ":1234"
+		if admin {
+			r.SetBasicAuth("admin", "admin")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	var tests = []struct {
+		path   string
+		client string
+		status int
+	}{
+		{"/api/v1/devices", "192.0.2.1", 401},
+		{"/api/v1/devices", "192.0.2.1", 401},
+		{"/api/v1/devices", "192.0.2.1", 403},
+		{"/.git/config", "192.0.2.2", 404},
+		{"/api/v1/devices", "192.0.2.2", 403},
+		{"/wp-login.php", "127.0.0.1", 401},
+		{"/api/v1/devices", "127.0.0.1", 401},
+	}
+	for i, tt := range tests {
+		if w := request(http.MethodGet, tt.path, tt.client, false); w.Code != tt.status {
+			t.Errorf("#%d: want status %d, got %d: %s", i, tt.status, w.Code, w.Body.String())
+		}
+	}
+	w := request(http.MethodGet, "/api/v1/devices", "192.0.2.1", true)
+	if w.Code != 403 || w.Body.String() != `{"status":403,"message":"Client 192.0.2.1 is banned"}` || w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("want banned client rejected, got %d: %s (Retry-After %q)", w.Code, w.Body.String(), w.Header().Get("Retry-After"))
+	}
+
+	w = request(http.MethodGet, "/api/v1/admin/bans", "127.0.0.1", true)
+	var bans []ban.Ban
+	if err := json.Unmarshal(w.Body.Bytes(), &bans); err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 2 || bans[0].Client != "192.0.2.1" || bans[1].Reason != "requested trap /.git/config" {
+		t.Errorf("want 2 bans, got %s", w.Body.String())
+	}
+	if w := request(http.MethodDelete, "/api/v1/admin/bans/192.0.2.2", "127.0.0.1", true); w.Code != 204 {
+		t.Errorf("want status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodDelete, "/api/v1/admin/bans/192.0.2.2", "127.0.0.1", true); w.Code != 404 {
+		t.Errorf("want status 404, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(http.MethodGet, "/api/v1/devices", "192.0.2.2", true); w.Code != 200 {
+		t.Errorf("want status 200 after lifting ban, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHistoryChain(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".history")
+	defer os.Remove(file.Name() + ".history.anchors")
+	api := Server{
+		Auth:      testAuth{"admin": "admin"},
+		History:   history.Open(file.Name() + ".history"),
+		cacheFile: file.Name(),
+	}
+	h := api.Handler()
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetBasicAuth("admin", "admin")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	if w := request(http.MethodGet, "/api/v1/admin/history/verify", ""); w.Code != 404 {
+		t.Errorf("want status 404 for unchained history, got %d: %s", w.Code, w.Body.String())
+	}
+	api.History.Chain = &history.Chain{Key: []byte("secret")}
+	for _, mac := range []string{"00:11:22:33:44:55", "66:77:88:99:aa:bb"} {
+		if w := request(http.MethodPost, "/api/v1/devices", `{"macAddress":"`+mac+`"}`); w.Code != 201 {
+			t.Fatalf("want status 201, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	w := request(http.MethodPost, "/api/v1/admin/history/anchors", "")
+	var anchor history.Anchor
+	if err := json.Unmarshal(w.Body.Bytes(), &anchor); err != nil {
+		t.Fatal(err)
+	}
+	if anchor.Seq != 2 || anchor.Hash == "" {
+		t.Fatalf("want anchor at entry 2, got %d: %s", w.Code, w.Body.String())
+	}
+	var anchors HistoryAnchors
+	w = request(http.MethodGet, "/api/v1/admin/history/anchors", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &anchors); err != nil {
+		t.Fatal(err)
+	}
+	if len(anchors.Anchors) != 1 || anchors.Head.Hash != anchor.Hash {
+		t.Errorf("want 1 anchor at head, got %s", w.Body.String())
+	}
+
+	verify := func(query string) history.Verification {
+		w := request(http.MethodGet, "/api/v1/admin/history/verify"+query, "")
+		var v history.Verification
+		if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+			t.Fatalf("%s: %s", err, w.Body.String())
+		}
+		return v
+	}
+	if v := verify("?anchor=2:" + anchor.Hash); !v.Valid || v.Entries != 2 || v.Chained != 2 {
+		t.Errorf("want valid chain, got %+v", v)
+	}
+	if v := verify("?anchor=2:0000"); v.Valid || v.Broken != 2 {
+		t.Errorf("want chain not matching anchor, got %+v", v)
+	}
+	if w := request(http.MethodGet, "/api/v1/admin/history/verify?anchor=foo", ""); w.Code != 400 {
+		t.Errorf("want status 400, got %d: %s", w.Code, w.Body.String())
+	}
+
+	data, err := ioutil.ReadFile(file.Name() + ".history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte("66:77:88:99:AA:BB"), []byte("66:77:88:99:AA:CC"), -1)
+	if err := ioutil.WriteFile(file.Name()+".history", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if v := verify(""); v.Valid || v.Broken != 2 || v.Reason != "hash does not match entry" {
+		t.Errorf("want tampered entry detected, got %+v", v)
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{
+		Auth:      testAuth{"alice": "secret", "bob": "secret"},
+		Quotas:    quota.New(quota.Limit{}),
+		Budget:    budget.New(1, 5, time.Minute),
+		wakeFunc:  func(net.HardwareAddr, wol.Options) error { return nil },
+		cacheFile: file.Name(),
+	}
+	api.Quotas.Set("bob", quota.Limit{PerHour: 2, PerDay: 10})
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		method  string
+		url     string
+		user    string
+		body    string
+		headers string
+	}{
+		{"GET", "/api/v1/wake", "bob", "", "2/2/0 10/10/0 2/2/0"},
+		{"POST", "/api/v1/wake", "bob", `{"macAddress":"AC:CD:EF:12:34:56"}`, "2/1/3600 10/9/86400 2/1/3600"},
+		{"GET", "/api/v1/devices/AC:CD:EF:12:34:56", "bob", "", "2/1/3600 10/9/86400 2/1/3600"},
+		{"POST", "/api/v1/wake", "bob", `{"macAddress":"AC:CD:EF:12:34:56"}`, "2/0/3600 10/8/86400 2/0/3600"},
+		{"POST", "/api/v1/wake", "bob", `{"macAddress":"AC:CD:EF:12:34:56"}`, "2/0/3600 10/8/86400 2/0/3600"},
+		// Clients without a quota are limited by the send budget
+		{"POST", "/api/v1/wake", "alice", `{"macAddress":"AC:CD:EF:12:34:57"}`, "// // 5/2/3"},
+		// Static files do not have rate limit headers
+		{"GET", "/", "alice", "", "// // //"},
+	}
+	for i, tt := range tests {
+		r, err := http.NewRequest(tt.method, server.URL+tt.url, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetBasicAuth(tt.user, "secret")
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		h := res.Header
+		got := strings.Join([]string{
+			h.Get("X-Quota-Limit-Hour") + "/" + h.Get("X-Quota-Remaining-Hour") + "/" + h.Get("X-Quota-Reset-Hour"),
+			h.Get("X-Quota-Limit-Day") + "/" + h.Get("X-Quota-Remaining-Day") + "/" + h.Get("X-Quota-Reset-Day"),
+			h.Get("X-RateLimit-Limit") + "/" + h.Get("X-RateLimit-Remaining") + "/" + h.Get("X-RateLimit-Reset"),
+		}, " ")
+		if got != tt.headers {
+			t.Errorf("#%d: want headers %q, got %q", i, tt.headers, got)
+		}
+	}
+}
+
+func TestStoreUnavailable(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	dir, err := ioutil.TempDir("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	api := Server{wakeFunc: func(net.HardwareAddr, wol.Options) error { return nil }, cacheFile: file.Name()}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	if _, status, err := httpPost(server.URL+"/api/v1/wake", `{"name":"foo","macAddress":"AC:CD:EF:12:34:56"}`); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	data, status, err := httpGet(server.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"status":"ok"}`; status != 200 || data != want {
+		t.Errorf("want %d %q, got %d %q", 200, want, status, data)
+	}
+
+	// Opening a directory as the cache file fails
+	api.cacheFile = dir
+	res, err := http.Get(server.URL + "/api/v1/wake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if want := staleWarning; res.StatusCode != 200 || res.Header.Get("Warning") != want {
+		t.Errorf("want status 200 and warning %q, got %d %q", want, res.StatusCode, res.Header.Get("Warning"))
+	}
+	for _, body := range []string{`{"macAddress":"AC:CD:EF:12:34:56"}`, `{"macAddress":"AC:CD:EF:12:34:57"}`} {
+		if _, status, err := httpPost(server.URL+"/api/v1/wake", body); err != nil || status != 204 {
+			t.Errorf("want status 204 for %s, got %d (%v)", body, status, err)
+		}
+	}
+	if _, status, err := httpDelete(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`); err != nil || status != 503 {
+		t.Errorf("want status 503, got %d (%v)", status, err)
+	}
+	// Clients are asked to retry requests failing because of the store
+	res, err = http.Post(server.URL+"/api/v1/devices", "application/json", strings.NewReader(`{"macAddress":"AC:CD:EF:12:34:58"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"status":503,"message":"Store unavailable"}`; res.StatusCode != 503 || string(body) != want || res.Header.Get("Retry-After") != "10" {
+		t.Errorf("want status 503, %q and Retry-After 10, got %d %q and Retry-After %q", want, res.StatusCode, body, res.Header.Get("Retry-After"))
+	}
+	data, status, err = httpGet(server.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"status":"unavailable","error":`; status != 503 || !strings.HasPrefix(data, want) {
+		t.Errorf("want %d and response starting with %q, got %d %q", 503, want, status, data)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := Server{wakeFunc: func(net.HardwareAddr, wol.Options) error { return nil }, cacheFile: file.Name()}
+	defer os.Remove(api.journalFile())
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	for _, body := range []string{`{"name":"foo","macAddress":"AC:CD:EF:12:34:56"}`, `{"name":"bar","macAddress":"AC:CD:EF:12:34:57"}`} {
+		if _, status, err := httpPost(server.URL+"/api/v1/wake", body); err != nil || status != 204 {
+			t.Fatalf("want status 204, got %d (%v)", status, err)
+		}
+	}
+	if _, status, err := httpDelete(server.URL+"/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	// Changes are only written to the journal
+	if data, err := ioutil.ReadFile(file.Name()); err != nil || len(data) != 0 {
+		t.Errorf("want empty cache file, got %q (%v)", data, err)
+	}
+	journal, err := ioutil.ReadFile(api.journalFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(journal), "\n"); got != 3 {
+		t.Errorf("want 3 journal entries, got %d", got)
+	}
+	want := `{"devices":[{"id":"9a18bd6c-44a4-5ed1-9ea6-139be112e3a6","name":"bar","macAddress":"AC:CD:EF:12:34:57"}]}`
+	if data, _, _ := httpGet(server.URL + "/api/v1/wake"); data != want {
+		t.Errorf("want %q, got %q", want, data)
+	}
+
+	// An interrupted entry is ignored, and truncated by the next write
+	if err := ioutil.WriteFile(api.journalFile(), append(journal, `{"revision":4,"devi`...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _, _ := httpGet(server.URL + "/api/v1/wake"); data != want {
+		t.Errorf("want %q, got %q", want, data)
+	}
+	if _, status, err := httpPost(server.URL+"/api/v1/wake", `{"name":"baz","macAddress":"AC:CD:EF:12:34:58"}`); err != nil || status != 204 {
+		t.Fatalf("want status 204, got %d (%v)", status, err)
+	}
+	want = `{"devices":[{"id":"9a18bd6c-44a4-5ed1-9ea6-139be112e3a6","name":"bar","macAddress":"AC:CD:EF:12:34:57"},{"id":"5a498c7c-40ee-524d-98e9-de282f913dbf","name":"baz","macAddress":"AC:CD:EF:12:34:58"}]}`
+	if data, _, _ := httpGet(server.URL + "/api/v1/wake"); data != want {
+		t.Errorf("want %q, got %q", want, data)
+	}
+
+	// Compaction writes the journal into the cache file
+	journal, _ = ioutil.ReadFile(api.journalFile())
+	if err := api.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(api.journalFile()); !os.IsNotExist(err) {
+		t.Errorf("want journal removed, got %v", err)
+	}
+	if data, _, _ := httpGet(server.URL + "/api/v1/wake"); data != want {
+		t.Errorf("want %q, got %q", want, data)
+	}
+	// Replaying a journal which has already been compacted gives the same devices
+	if err := ioutil.WriteFile(api.journalFile(), journal, 0644); err != nil {
+		t.Fatal(err)
+	}
+	i, err := api.readDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(i.Devices) != 2 || i.Revision != 4 || len(i.Changes) != 4 {
+		t.Errorf("want 2 devices at revision 4 with 4 changes, got %+v", i)
+	}
+}
+
+func TestCacheRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheFile := filepath.Join(dir, "cache.json")
+	api := Server{wakeFunc: func(net.HardwareAddr, wol.Options) error { return nil }, cacheFile: cacheFile}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	names := func() string {
+		devices, err := api.Devices()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, d := range devices {
+			names = append(names, d.Name)
+		}
+		return strings.Join(names, " ")
+	}
+	for _, body := range []string{`{"name":"foo","macAddress":"AC:CD:EF:12:34:56"}`, `{"name":"bar","macAddress":"AC:CD:EF:12:34:57"}`} {
+		if _, status, err := httpPost(server.URL+"/api/v1/wake", body); err != nil || status != 204 {
+			t.Fatalf("want status 204, got %d (%v)", status, err)
+		}
+		if err := api.Compact(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(cacheFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := ioutil.ReadFile(cacheFile + ".sha256")
+	if err != nil || string(sum) != checksum(data)+"\n" {
+		t.Errorf("want checksum of cache file, got %q (%v)", sum, err)
+	}
+	// A truncated cache file is replaced by the backup holding the previous cache
+	if err := ioutil.WriteFile(cacheFile, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(), "foo"; got != want {
+		t.Errorf("want devices %q from backup, got %q", want, got)
+	}
+	// A cache file not matching its checksum is also replaced, even if it decodes
+	if err := ioutil.WriteFile(cacheFile, []byte(`{"devices":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(), "foo"; got != want {
+		t.Errorf("want devices %q from backup, got %q", want, got)
+	}
+	// Compacting keeps a corrupt cache file out of the backup
+	if err := api.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(), "foo"; got != want {
+		t.Errorf("want devices %q after compaction, got %q", want, got)
+	}
+	if err := os.Remove(cacheFile + ".bak"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cacheFile, []byte(`{"devi`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.Devices(); err == nil {
+		t.Error("want error without backup")
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, ".*tmp*"))
+	if err != nil || len(matches) > 0 {
+		t.Errorf("want no temporary files, got %v (%v)", matches, err)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	server, _ := testServer()
+	defer server.Close()
+
+	var tests = []struct {
+		method   string
+		url      string
+		body     string
+		response string
+		status   int
+	}{
+		{"POST", "/api/v1/wake", `{"name":"nas","macAddress":"ac:cd:ef:12:34:56","ipAddress":"10.0.0.2","groups":["lab"]}`, "", 204},
+		{"POST", "/api/v1/wake", `{"macAddress":"AC-CD-EF-12-34-56","notes":"Hold F12"}`, "", 204},
+		{"POST", "/api/v1/wake", `{"name":"old-nas","macAddress":"10:22:33:44:55:66","ipAddress":"10.0.0.2"}`, "", 204},
+		{"GET", "/api/v1/conflicts", "", `{"conflicts":[{"kind":"macAddress","value":"AC:CD:EF:12:34:56","devices":["ecf50f72-a051-5e3b-8f64-f01e46e049ea","fc2b1229-4d81-5601-88f3-99f11ef28aa6"]},{"kind":"ipAddress","value":"10.0.0.2","devices":["38db4318-c009-53ce-9a3f-7f3bd3ff7b41","fc2b1229-4d81-5601-88f3-99f11ef28aa6"]}]}`, 200},
+		{"GET", "/api/v1/devices/ac:cd:ef:12:34:56/merge", "", `{"status":405,"message":"Invalid method GET, must be POST"}`, 405},
+		{"POST", "/api/v1/devices/ac:cd:ef:12:34:56/merge", `{}`, `{"status":400,"message":"No devices to merge"}`, 400},
+		{"POST", "/api/v1/devices/ac:cd:ef:12:34:56/merge", `{"from":["AC-CD-EF-12-34-56"]}`, `{"status":400,"message":"Cannot merge device AC-CD-EF-12-34-56 into itself"}`, 400},
+		{"POST", "/api/v1/devices/ac:cd:ef:12:34:56/merge", `{"from":["10:22:33:44:55:67"]}`, `{"status":400,"message":"Device not found: 10:22:33:44:55:67"}`, 400},
+		{"POST", "/api/v1/devices/ac:cd:ef:12:34:56/merge", `{"from":["ac:cd:ef:12:34:56","10:22:33:44:55:66"]}`, `{"id":"ecf50f72-a051-5e3b-8f64-f01e46e049ea","name":"nas","description":"","macAddress":"AC-CD-EF-12-34-56","macAddresses":["10:22:33:44:55:66"],"ipAddress":"10.0.0.2","groups":["lab"],"notes":"Hold F12","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":0,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`, 200},
+		{"GET", "/api/v1/conflicts", "", `{"conflicts":[]}`, 200},
+		{"GET", "/api/v1/wake", "", `{"devices":[{"id":"ecf50f72-a051-5e3b-8f64-f01e46e049ea","name":"nas","macAddress":"AC-CD-EF-12-34-56","macAddresses":["10:22:33:44:55:66"],"ipAddress":"10.0.0.2","groups":["lab"],"notes":"Hold F12"}]}`, 200},
+	}
+	for i, tt := range tests {
+		data, status, err := httpRequest(tt.method, server.URL+tt.url, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if data != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+	}
+}
+
+func TestDeviceIDs(t *testing.T) {
+	server, cacheFile := testServer()
+	defer os.Remove(cacheFile)
+	defer os.Remove(cacheFile + ".journal")
+	defer server.Close()
+
+	// Devices stored without an ID are identified by the ID of their MAC address
+	if err := ioutil.WriteFile(cacheFile, []byte(`{"devices":[{"macAddress":"AC:CD:EF:12:34:56"},{"macAddress":"ac-cd-ef-12-34-56"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		method   string
+		url      string
+		body     string
+		response string
+		status   int
+	}{
+		{"GET", "/api/v1/wake", "", `{"devices":[{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","macAddress":"AC:CD:EF:12:34:56"},{"id":"f67cf1bd-a2f4-5af0-86fd-028865f52c58","macAddress":"ac-cd-ef-12-34-56"}]}`, 200},
+		{"DELETE", "/api/v1/devices/f67cf1bd-a2f4-5af0-86fd-028865f52c58", "", "", 204},
+		{"PUT", "/api/v1/devices/FC2B1229-4D81-5601-88F3-99F11EF28AA6", `{"macAddress":"AC:CD:EF:12:34:57"}`, `{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"","description":"","macAddress":"AC:CD:EF:12:34:57","macAddresses":[],"ipAddress":"","groups":[],"notes":"","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":0,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`, 200},
+		// A new device having the original MAC address is given another ID
+		{"POST", "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`, "", 204},
+		{"GET", "/api/v1/wake", "", `{"devices":[{"id":"e41085c1-bcac-5058-949d-c7e3d211ccb4","macAddress":"AC:CD:EF:12:34:56"},{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","macAddress":"AC:CD:EF:12:34:57"}]}`, 200},
+	}
+	for i, tt := range tests {
+		data, status, err := httpRequest(tt.method, server.URL+tt.url, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if data != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+	}
+}
+
+func TestMultipleMACs(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".journal")
+	var woken []string
+	api := Server{
+		wakeFunc: func(hwAddr net.HardwareAddr, _ wol.Options) error {
+			mac := strings.ToUpper(hwAddr.String())
+			if mac == "AC:CD:EF:12:34:58" {
+				return fmt.Errorf("no route to host")
+			}
+			woken = append(woken, mac)
+			return nil
+		},
+		cacheFile: file.Name(),
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+
+	var tests = []struct {
+		method   string
+		url      string
+		body     string
+		response string
+		status   int
+	}{
+		{"POST", "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56","macAddresses":["AC:CD:EF:12:34:57","ac-cd-ef-12-34-56"]}`, `{"status":400,"message":"Duplicate MAC address: ac-cd-ef-12-34-56"}`, 400},
+		{"POST", "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56","macAddresses":["AC:CD:EF:12:34:58","AC:CD:EF:12:34:57"]}`, `{"sent":["AC:CD:EF:12:34:56","AC:CD:EF:12:34:57"]}`, 200},
+		// Additional MAC addresses of a stored device are woken when waking it by its MAC address
+		{"POST", "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:56"}`, `{"sent":["AC:CD:EF:12:34:56","AC:CD:EF:12:34:57"]}`, 200},
+		{"GET", "/api/v1/devices/ac:cd:ef:12:34:57", "", `{"id":"fc2b1229-4d81-5601-88f3-99f11ef28aa6","name":"","description":"","macAddress":"AC:CD:EF:12:34:56","macAddresses":["AC:CD:EF:12:34:58","AC:CD:EF:12:34:57"],"ipAddress":"","groups":[],"notes":"","prerequisites":[],"essential":false,"watts":0,"onOnline":"","probePorts":[],"platform":"","keepAwake":null,"shutdown":"","secureOnPassword":"","wakeAddress":"","wakePort":0,"transport":"","wakeInterface":"","lastKnownIP":"","hostname":"","vendor":"","alternates":[]}`, 200},
+		{"PUT", "/api/v1/devices/10:22:33:44:55:66", `{"macAddresses":["ac:cd:ef:12:34:57"]}`, `{"status":409,"message":"MAC address AC:CD:EF:12:34:57 belongs to device fc2b1229-4d81-5601-88f3-99f11ef28aa6"}`, 409},
+		{"PUT", "/api/v1/devices/10:22:33:44:55:66", `{"macAddresses":["foo"]}`, `{"status":400,"message":"Invalid MAC address: foo"}`, 400},
+	}
+	for i, tt := range tests {
+		data, status, err := httpRequest(tt.method, server.URL+tt.url, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+		if data != tt.response {
+			t.Errorf("#%d: want response %q, got %q", i, tt.response, data)
+		}
+	}
+	want := []string{"AC:CD:EF:12:34:56", "AC:CD:EF:12:34:57", "AC:CD:EF:12:34:56", "AC:CD:EF:12:34:57"}
+	if fmt.Sprint(woken) != fmt.Sprint(want) {
+		t.Errorf("want %v woken, got %v", want, woken)
+	}
+}
+
+func TestTargetMACs(t *testing.T) {
+	server, cacheFile := testServer()
+	defer os.Remove(cacheFile)
+	defer server.Close()
+	var tests = []struct {
+		method  string
+		url     string
+		body    string
+		status  int
+		res     string
+		warning string
+	}{
+		{http.MethodPost, "/api/v1/wake", `{"macAddress":"01:00:5E:00:00:01"}`, 400, `{"status":400,"message":"Invalid MAC address: 01:00:5E:00:00:01, must not be a multicast or broadcast address"}`, ""},
+		{http.MethodPut, "/api/v1/devices/FF:FF:FF:FF:FF:FF", `{}`, 400, `{"status":400,"message":"Invalid MAC address: FF:FF:FF:FF:FF:FF, must not be a multicast or broadcast address"}`, ""},
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:56", `{"macAddresses":["33:33:00:00:00:01"]}`, 400, `{"status":400,"message":"Invalid MAC address: 33:33:00:00:00:01, must not be a multicast or broadcast address"}`, ""},
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"nas"}`, 201, "", ""},
+		{http.MethodPost, "/api/v1/devices/AC:CD:EF:12:34:56/clone", `{"macAddress":"AB:CD:EF:12:34:57"}`, 400, `{"status":400,"message":"Invalid MAC address: AB:CD:EF:12:34:57, must not be a multicast or broadcast address"}`, ""},
+		// Storing a locally administered address succeeds with a warning, which is only given when it is added
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"nas","macAddresses":["DA:A1:19:12:34:56"]}`, 200, "",
+			`199 wakeup "MAC address DA:A1:19:12:34:56 is locally administered and may change, e.g. if randomized by the operating system"`},
+		{http.MethodPut, "/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"nas","macAddresses":["DA:A1:19:12:34:56"],"notes":"Rack 2"}`, 200, "", ""},
+		{http.MethodPost, "/api/v1/wake", `{"macAddress":"02:00:00:12:34:56"}`, 204, "",
+			`199 wakeup "MAC address 02:00:00:12:34:56 is locally administered and may change, e.g. if randomized by the operating system"`},
+	}
+	for i, tt := range tests {
+		req, err := http.NewRequest(tt.method, server.URL+tt.url, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.status || (tt.res != "" && string(data) != tt.res) {
+			t.Errorf("#%d: want status %d and %s, got %d and %s", i, tt.status, tt.res, res.StatusCode, data)
+		}
+		if got := res.Header.Get("Warning"); got != tt.warning {
+			t.Errorf("#%d: want warning %q, got %q", i, tt.warning, got)
+		}
+	}
+	res, _, _ := httpPost(server.URL+"/api/v1/import", "macAddress,name\nFF:FF:FF:FF:FF:FF,all\n06:00:00:12:34:56,vm\n")
+	for _, want := range []string{`"reason":"Invalid MAC address: FF:FF:FF:FF:FF:FF, must not be a multicast or broadcast address"`, `"warning":"MAC address 06:00:00:12:34:56 is locally administered`} {
+		if !strings.Contains(res, want) {
+			t.Errorf("want %s in %s", want, res)
+		}
+	}
+}
+
+func TestAlternates(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".history")
+	table := neigh.Table{
+		// The device has randomized its address, while the address of another device is given to a new host
+		"10.0.0.2": net.HardwareAddr{0xda, 0xa1, 0x19, 0x12, 0x34, 0x56},
+		"10.0.0.3": net.HardwareAddr{0x10, 0x22, 0x33, 0x44, 0x55, 0x66},
+	}
+	api := Server{
+		Clock:     schedule.ClockFunc(func() time.Time { return time.Date(2019, 1, 2, 7, 45, 0, 0, time.UTC) }),
+		History:   history.Open(file.Name() + ".history"),
+		cacheFile: file.Name(),
+		neighFunc: func() (neigh.Table, error) { return table, nil },
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	for mac, body := range map[string]string{
+		"AC:CD:EF:12:34:56": `{"name":"laptop","ipAddress":"10.0.0.2"}`,
+		"AC:CD:EF:12:34:57": `{"name":"nas","ipAddress":"10.0.0.3"}`,
+	} {
+		if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/"+mac, body); err != nil || status != 201 {
+			t.Fatalf("want status 201, got %d (%v)", status, err)
+		}
+	}
+	alternates := func(mac string) []Alternate {
+		res, _, err := httpGet(server.URL + "/api/v1/devices/" + mac)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d DeviceResource
+		if err := json.Unmarshal([]byte(res), &d); err != nil {
+			t.Fatal(err)
+		}
+		return d.Alternates
+	}
+	for i := 0; i < 2; i++ {
+		if err := api.trackIPs(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	observed := time.Date(2019, 1, 2, 7, 45, 0, 0, time.UTC)
+	want := []Alternate{{MACAddress: "DA:A1:19:12:34:56", IPAddress: "10.0.0.2", Source: "arp", Observed: observed}}
+	if got := alternates("AC:CD:EF:12:34:56"); !reflect.DeepEqual(got, want) {
+		t.Errorf("want alternates %+v, got %+v", want, got)
+	}
+	if got := alternates("AC:CD:EF:12:34:57"); len(got) != 0 {
+		t.Errorf("want no alternates, got %+v", got)
+	}
+
+	// Agents report the address they are using
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56/address", `{"ipAddress":"10.0.0.2","macAddress":"FF:FF:FF:FF:FF:FF"}`); err != nil || status != 400 {
+		t.Errorf("want status 400, got %d (%v)", status, err)
+	}
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56/address", `{"ipAddress":"10.0.0.2","macAddress":"06:00:00:12:34:56"}`); err != nil || status != 200 {
+		t.Errorf("want status 200, got %d (%v)", status, err)
+	}
+	if got := alternates("AC:CD:EF:12:34:56"); len(got) != 2 || got[1].MACAddress != "06:00:00:12:34:56" {
+		t.Errorf("want alternate 06:00:00:12:34:56, got %+v", got)
+	}
+
+	var tests = []struct {
+		method string
+		url    string
+		body   string
+		status int
+		res    string
+	}{
+		{http.MethodGet, "/api/v1/devices/AC:CD:EF:12:34:56/alternates/06:00:00:12:34:56", "", 405, `{"status":405,"message":"Invalid method GET, must be POST or DELETE"}`},
+		{http.MethodPost, "/api/v1/devices/AC:CD:EF:12:34:56/alternates/foo", "", 400, `{"status":400,"message":"Invalid MAC address: foo"}`},
+		{http.MethodPost, "/api/v1/devices/AC:CD:EF:12:34:56/alternates/AC:CD:EF:12:34:57", "", 404, `{"status":404,"message":"Alternate MAC address not found: AC:CD:EF:12:34:57"}`},
+		{http.MethodDelete, "/api/v1/devices/AC:CD:EF:12:34:56/alternates/06:00:00:12:34:56", "", 204, ""},
+		{http.MethodDelete, "/api/v1/devices/AC:CD:EF:12:34:56/alternates/06:00:00:12:34:56", "", 404, `{"status":404,"message":"Alternate MAC address not found: 06:00:00:12:34:56"}`},
+		{http.MethodPost, "/api/v1/devices/AC:CD:EF:12:34:56/alternates/da-a1-19-12-34-56", `{"keep":true}`, 200, ""},
+	}
+	for i, tt := range tests {
+		res, status, err := httpRequest(tt.method, server.URL+tt.url, tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != tt.status || (tt.res != "" && res != tt.res) {
+			t.Errorf("#%d: want status %d and %s, got %d and %s", i, tt.status, tt.res, status, res)
+		}
+	}
+
+	// The adopted address is woken, and the device can be found by both addresses
+	res, _, err := httpGet(server.URL + "/api/v1/devices/DA:A1:19:12:34:56")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d DeviceResource
+	if err := json.Unmarshal([]byte(res), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.MACAddress != "DA:A1:19:12:34:56" || !reflect.DeepEqual(d.MACAddresses, []string{"AC:CD:EF:12:34:56"}) || len(d.Alternates) != 0 {
+		t.Errorf("unexpected device %+v", d)
+	}
+	if got := alternates("AC:CD:EF:12:34:56"); len(got) != 0 {
+		t.Errorf("want no alternates, got %+v", got)
+	}
+	// Addresses of the device are not recorded as alternates again
+	if err := api.trackIPs(); err != nil {
+		t.Fatal(err)
+	}
+	if got := alternates("DA:A1:19:12:34:56"); len(got) != 0 {
+		t.Errorf("want no alternates, got %+v", got)
+	}
+}
+
+func TestOUI(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer os.Remove(file.Name() + ".oui")
+	defer macaddr.DefaultRegistry.Reset()
+	csv := "Registry,Assignment,Organization Name,Organization Address\nMA-L,ACCDEF,\"Acme, Inc.\",Somewhere\n"
+	failing := false
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, csv)
+	}))
+	defer registry.Close()
+	api := Server{
+		AdminToken:   "secret",
+		Clock:        schedule.ClockFunc(func() time.Time { return time.Date(2019, 1, 2, 7, 45, 0, 0, time.UTC) }),
+		OUIFile:      file.Name() + ".oui",
+		cacheFile:    file.Name(),
+		registryURLs: []string{registry.URL},
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	if _, status, err := httpRequest(http.MethodPut, server.URL+"/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"nas"}`); err != nil || status != 201 {
+		t.Fatalf("want status 201, got %d (%v)", status, err)
+	}
+	vendor := func() string {
+		res, _, err := httpGet(server.URL + "/api/v1/devices/AC:CD:EF:12:34:56")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d DeviceResource
+		if err := json.Unmarshal([]byte(res), &d); err != nil {
+			t.Fatal(err)
+		}
+		return d.Vendor
+	}
+	embedded := fmt.Sprintf(`{"prefixes":%d,"embedded":true}`, macaddr.DefaultRegistry.Len())
+	refreshed := `{"prefixes":1,"embedded":false,"updated":"2019-01-02T07:45:00Z"}`
+	var tests = []struct {
+		method   string
+		token    string
+		body     string
+		fail     bool
+		response string
+		status   int
+		vendor   string
+	}{
+		{http.MethodGet, "", "", false, `{"status":401,"message":"Invalid admin token"}`, 401, ""},
+		{http.MethodGet, "secret", "", false, embedded, 200, ""},
+		{http.MethodPut, "secret", "", false, `{"status":405,"message":"Invalid method PUT, must be GET, POST or DELETE"}`, 405, ""},
+		{http.MethodPost, "secret", "foo,bar\n", false, `{"status":400,"message":"Malformed registry","cause":"invalid registry: missing Assignment or Organization Name column"}`, 400, ""},
+		{http.MethodPost, "secret", "", true, `{"status":502,"message":"Could not download registry","cause":"` + registry.URL + `: got status 503"}`, 502, ""},
+		// The registry is downloaded unless given in the body
+		{http.MethodPost, "secret", "", false, refreshed, 200, "Acme, Inc."},
+		{http.MethodDelete, "secret", "", false, embedded, 200, ""},
+		{http.MethodPost, "secret", "Assignment,Organization Name\nACCDEF,Uploaded\n", false, refreshed, 200, "Uploaded"},
+	}
+	for i, tt := range tests {
+		failing = tt.fail
+		req, err := http.NewRequest(tt.method, server.URL+"/api/v1/admin/oui", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.status || string(data) != tt.response {
+			t.Errorf("#%d: want status %d and %s, got %d and %s", i, tt.status, tt.response, res.StatusCode, data)
+		}
+		if got := vendor(); got != tt.vendor {
+			t.Errorf("#%d: want vendor %q, got %q", i, tt.vendor, got)
+		}
+	}
+	// The refreshed registry is loaded again at startup
+	r := macaddr.NewRegistry()
+	if err := r.Load(api.OUIFile); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Lookup(net.HardwareAddr{0xac, 0xcd, 0xef, 0x12, 0x34, 0x56}); got != "Uploaded" {
+		t.Errorf("want vendor Uploaded, got %q", got)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	api := New(file.Name())
+	if err := api.Simulate(1, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(api.Handler())
+	defer server.Close()
+	devices, err := api.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != len(demoDevices) || devices[0].Source != demoSource {
+		t.Fatalf("want %d demo devices, got %+v", len(demoDevices), devices)
+	}
+	for _, tt := range []struct{ name, mac, status string }{
+		{"nas", "02:00:5E:10:00:01", statusOnline},
+		{"backup", "02:00:5E:10:00:02", statusOffline},
+		{"lab-node", "02:00:5E:10:00:08", statusUnknown},
+	} {
+		data, status, err := httpRequest("GET", server.URL+"/api/v1/devices/"+tt.mac+"/status", "")
+		if err != nil || status != 200 {
+			t.Fatalf("%s: got status %d (%v)", tt.name, status, err)
+		}
+		var s DeviceStatus
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			t.Fatal(err)
+		}
+		if s.Status != tt.status {
+			t.Errorf("%s: want status %s, got %s", tt.name, tt.status, s.Status)
+		}
+	}
+	// Demo devices are only stored in an empty inventory
+	if err := api.Simulate(1, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if devices, err := api.Devices(); err != nil || len(devices) != len(demoDevices) {
+		t.Errorf("want %d devices, got %d (%v)", len(demoDevices), len(devices), err)
+	}
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	sim := &simulation{
+		rand:       rand.New(rand.NewSource(1)),
+		online:     make(map[string]bool),
+		booting:    make(map[string]time.Time),
+		minLatency: 10 * time.Second,
+		maxLatency: 10 * time.Second,
+		interval:   time.Millisecond,
+		now:        func() time.Time { return now },
+		address:    func(string) string { return "192.0.2.1" },
+	}
+	probes := wait.TCPProbes("192.0.2.1", []int{22})
+	hwAddr, _ := net.ParseMAC("02:00:5E:10:00:01")
+	if err := sim.wake(hwAddr, wol.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if r := sim.wait(ctx, probes); r.Status != wait.StatusTimeout {
+		t.Errorf("want %s before latency passes, got %s", wait.StatusTimeout, r.Status)
+	}
+	now = now.Add(10 * time.Second)
+	if r := sim.wait(context.Background(), probes); r.Status != wait.StatusOnline {
+		t.Errorf("want %s after latency passes, got %s", wait.StatusOnline, r.Status)
+	}
+	sim.step([]string{"192.0.2.1"}, 1)
+	if sim.up("192.0.2.1") {
+		t.Error("want device offline after step")
+	}
+}
+
+func TestStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheFile := filepath.Join(dir, "cache.json")
+	cache := `[{"macAddress":"AC:CD:EF:12:34:56","name":"foo"}]`
+	if err := ioutil.WriteFile(cacheFile, []byte(cache), 0644); err != nil {
+		t.Fatal(err)
+	}
+	names := func(api *Server) string {
+		devices, err := api.Devices()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, d := range devices {
+			names = append(names, d.Name)
+		}
+		return strings.Join(names, " ")
+	}
+	kinds := []string{StoreBolt}
+	if sqliteSupported {
+		kinds = append([]string{StoreSQLite}, kinds...)
+	}
+	for _, kind := range kinds {
+		path := filepath.Join(dir, "cache."+kind)
+		open := func() *Server {
+			api, err := Open(kind, cacheFile, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			api.wakeFunc = func(net.HardwareAddr, wol.Options) error { return nil }
+			return api
+		}
+		api := open()
+		// Devices are migrated from the cache file
+		if got, want := names(api), "foo"; got != want {
+			t.Errorf("%s: want devices %q, got %q", kind, want, got)
+		}
+		server := httptest.NewServer(api.Handler())
+		for _, req := range []struct{ method, url, body string }{
+			{"POST", "/api/v1/wake", `{"macAddress":"AC:CD:EF:12:34:57","name":"bar"}`},
+			{"PUT", "/api/v1/devices/AC:CD:EF:12:34:56", `{"name":"baz"}`},
+			{"PUT", "/api/v1/schedules/nightly", `{"device":"bar","cron":"0 3 * * *"}`},
+		} {
+			if data, status, err := httpRequest(req.method, server.URL+req.url, req.body); err != nil || status >= 300 {
+				t.Fatalf("%s: %s %s: got status %d: %s (%v)", kind, req.method, req.url, status, data, err)
+			}
+		}
+		server.Close()
+		if err := api.Close(); err != nil {
+			t.Fatal(err)
+		}
+		api = open()
+		if got, want := names(api), "baz bar"; got != want {
+			t.Errorf("%s: want devices %q after reopening, got %q", kind, want, got)
+		}
+		i, err := api.readDevices()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(i.Schedules) != 1 || i.Revision == 0 {
+			t.Errorf("%s: want schedule and revision stored, got %+v", kind, i)
+		}
+		// Devices are only migrated when the database is created
+		i.Devices = nil
+		if err := api.writeCache(i, actor{}); err != nil {
+			t.Fatal(err)
+		}
+		api.Close()
+		api = open()
+		if got := names(api); got != "" {
+			t.Errorf("%s: want no devices, got %q", kind, got)
+		}
+		api.Close()
+	}
+	if data, err := ioutil.ReadFile(cacheFile); err != nil || string(data) != cache {
+		t.Errorf("want cache file unchanged, got %s (%v)", data, err)
+	}
+	if _, err := Open("foo", cacheFile, ""); err == nil {
+		t.Error("want error for invalid store")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	file, err := ioutil.TempFile("", "wakeonlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if err := ioutil.WriteFile(file.Name(), []byte(`[{"macAddress":"AC:CD:EF:12:34:56","name":"nas","ipAddress":"10.0.0.2"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	recording := New(file.Name())
+	recording.Trace = trace.NewWriter(&buf)
+	devices, err := recording.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nas := devices[0]
+	recording.observe(nas, "10.0.0.2", false)
+	recording.publish(event.Event{Type: event.Wake, Device: nas.ID, MACAddress: nas.MACAddress, Name: nas.Name})
+	recording.observe(nas, "10.0.0.2", true)
+	recording.observe(nas, "10.0.0.2", true)
+	recording.observe(nas, "10.0.0.2", false)
+	records, err := trace.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Online and offline events are not recorded, as they are derived from probes
+	if len(records) != 5 {
+		t.Fatalf("want 5 records, got %d", len(records))
+	}
+
+	api := New(file.Name())
+	events, cancel := api.Events.Subscribe(len(records))
+	defer cancel()
+	if err := api.Replay(records, 0); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for n := 0; n < 3; n++ {
+		e := <-events
+		if e.Device != nas.ID {
+			t.Errorf("want event of %s, got %+v", nas.ID, e)
+		}
+		got = append(got, e.Type+"@"+e.Time.Format(time.RFC3339Nano))
+	}
+	want := []string{
+		event.Wake + "@" + records[1].Time.Format(time.RFC3339Nano),
+		event.Online + "@" + records[2].Time.Format(time.RFC3339Nano),
+		event.Offline + "@" + records[4].Time.Format(time.RFC3339Nano),
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("want events %v, got %v", want, got)
+	}
+	p := api.statuses[nas.MACAddress]
+	if p.online || !p.checked.Equal(records[4].Time) || !p.since.Equal(records[4].Time) {
+		t.Errorf("want status of last probe, got %+v", p)
+	}
+}
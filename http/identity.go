@@ -0,0 +1,131 @@
+package http
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strings"
+)
+
+// idNamespace is the namespace of name-based device IDs.
+var idNamespace = [16]byte{0x6b, 0xa7, 0xb8, 0x14, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// nameID returns the name-based (version 5) UUID of name. Devices are initially identified by the UUID of their MAC
+// address, which makes IDs of devices stored before devices had IDs stable.
+func nameID(name string) string {
+	h := sha1.New()
+	h.Write(idNamespace[:])
+	h.Write([]byte(name))
+	b := h.Sum(nil)[:16]
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+	return formatUUID(b)
+}
+
+// isUUID returns whether s is a UUID in its canonical form.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// newID returns an unused ID for a device having MAC address macAddress. The ID is derived from the MAC address, and
+// made unique if a device having that ID already exists, e.g. because a device has changed its MAC address.
+func (c *deviceCache) newID(macAddress string) string {
+	mac, ok := normalizeMAC(macAddress)
+	if !ok {
+		mac = macAddress
+	}
+	names := []string{mac}
+	if macAddress != mac {
+		names = append(names, macAddress)
+	}
+	for n := 1; ; n++ {
+		for _, name := range names {
+			if id := nameID(name); !c.hasID(id) {
+				return id
+			}
+		}
+		names = []string{fmt.Sprintf("%s#%d", mac, n)}
+	}
+}
+
+func (c *deviceCache) hasID(id string) bool {
+	_, ok := c.findID(id)
+	return ok
+}
+
+// assignIDs sets the ID of devices which do not have one.
+func (c *deviceCache) assignIDs() {
+	for j := range c.Devices {
+		if c.Devices[j].ID == "" {
+			c.Devices[j].ID = c.newID(c.Devices[j].MACAddress)
+		}
+	}
+}
+
+// findID returns the device having ID id.
+func (c *deviceCache) findID(id string) (Device, bool) {
+	for _, v := range c.Devices {
+		if v.ID != "" && strings.EqualFold(v.ID, id) {
+			return v, true
+		}
+	}
+	return Device{}, false
+}
+
+// lookup returns the device referred to by ref, which is either the ID of the device or its MAC address.
+func (c *deviceCache) lookup(ref string) (Device, bool) {
+	if isUUID(ref) {
+		return c.findID(ref)
+	}
+	if d, ok := c.find(ref); ok {
+		return d, true
+	}
+	if mac, ok := normalizeMAC(ref); ok {
+		return c.findMAC(mac)
+	}
+	return Device{}, false
+}
+
+// same returns whether a and b are the same device. Devices are identified by their ID, or by their MAC address if
+// either is lacking an ID.
+func same(a, b Device) bool {
+	if a.ID != "" && b.ID != "" {
+		return strings.EqualFold(a.ID, b.ID)
+	}
+	return a.MACAddress == b.MACAddress
+}
+
+// findName returns the only device named name, ignoring case.
+func (c *deviceCache) findName(name string) (Device, error) {
+	var found []Device
+	for _, d := range c.Devices {
+		if d.Name != "" && strings.EqualFold(d.Name, name) {
+			found = append(found, d)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Device{}, fmt.Errorf("device not found: %s", name)
+	case 1:
+		return found[0], nil
+	}
+	return Device{}, fmt.Errorf("%d devices are named %s", len(found), name)
+}
@@ -0,0 +1,291 @@
+package http
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Results of importing a row.
+const (
+	importCreated = "created"
+	importUpdated = "updated"
+	importSkipped = "skipped"
+	importError   = "error"
+)
+
+const (
+	// maxImportSize is the maximum size of an imported CSV file, in bytes.
+	maxImportSize = 10 << 20
+	// maxImportRows is the maximum number of rows in an imported CSV file.
+	maxImportRows = 10000
+	// importWorkers is the number of rows validated at once.
+	importWorkers = 8
+)
+
+// importColumns are the columns of an imported CSV file. Only macAddress is required. Groups are separated by
+// semicolons.
+var importColumns = []string{"macAddress", "name", "description", "ipAddress", "groups", "platform"}
+
+// ImportRow is the outcome of importing a row of devices.
+type ImportRow struct {
+	// Row is the line number of the row, counting the header as the first line.
+	Row        int    `json:"row"`
+	MACAddress string `json:"macAddress,omitempty"`
+	Result     string `json:"result"`
+	Reason     string `json:"reason,omitempty"`
+	// Warning is a warning about a device created or updated, e.g. that its MAC address is locally administered.
+	Warning string `json:"warning,omitempty"`
+}
+
+// ImportReport is the outcome of importing devices.
+type ImportReport struct {
+	DryRun  bool        `json:"dryRun"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Skipped int         `json:"skipped"`
+	Errors  int         `json:"errors"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+func (r *ImportReport) add(row ImportRow) {
+	switch row.Result {
+	case importCreated:
+		r.Created++
+	case importUpdated:
+		r.Updated++
+	case importSkipped:
+		r.Skipped++
+	case importError:
+		r.Errors++
+	}
+	r.Rows = append(r.Rows, row)
+}
+
+// importRecord is a validated row.
+type importRecord struct {
+	row    int
+	device Device
+	err    string
+}
+
+// parseImportRow validates record, whose fields are named by columns.
+func parseImportRow(row int, columns, record []string) importRecord {
+	r := importRecord{row: row}
+	for j, v := range record {
+		v = strings.TrimSpace(v)
+		switch columns[j] {
+		case "macAddress":
+			r.device.MACAddress = v
+		case "name":
+			r.device.Name = v
+		case "description":
+			r.device.Description = v
+		case "ipAddress":
+			r.device.IPAddress = v
+		case "groups":
+			for _, g := range strings.Split(v, ";") {
+				if g = strings.TrimSpace(g); g != "" && !contains(r.device.Groups, g) {
+					r.device.Groups = append(r.device.Groups, g)
+				}
+			}
+		case "platform":
+			r.device.Platform = v
+		}
+	}
+	mac, ok := normalizeMAC(r.device.MACAddress)
+	if !ok {
+		r.err = fmt.Sprintf("Invalid MAC address: %s", r.device.MACAddress)
+		return r
+	}
+	if err := validateTarget(mac); err != nil {
+		r.err = err.Message
+		return r
+	}
+	r.device.MACAddress = mac
+	if r.device.IPAddress != "" && net.ParseIP(r.device.IPAddress) == nil {
+		r.err = fmt.Sprintf("Invalid IP address: %s", r.device.IPAddress)
+		return r
+	}
+	if err := validateDescription(r.device.Description); err != nil {
+		r.err = err.Message
+		return r
+	}
+	if err := validatePlatform(r.device.Platform); err != nil {
+		r.err = err.Message
+	}
+	return r
+}
+
+// readImport reads and validates the rows of a CSV file of devices. Rows are validated concurrently, and returned in
+// the order they were read.
+func readImport(r io.Reader) ([]importRecord, *Error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Missing CSV header"}
+	} else if err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Malformed CSV: %s", err)}
+	}
+	columns := make([]string, len(header))
+	hasMAC := false
+	for j, c := range header {
+		c = strings.TrimSpace(c)
+		if !contains(importColumns, c) {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Unknown column %q, must be one of %s", c, strings.Join(importColumns, ", "))}
+		}
+		if contains(columns[:j], c) {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Duplicate column %q", c)}
+		}
+		hasMAC = hasMAC || c == "macAddress"
+		columns[j] = c
+	}
+	if !hasMAC {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Missing column \"macAddress\""}
+	}
+	var rows [][]string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Malformed CSV: %s", err)}
+		}
+		if len(rows) == maxImportRows {
+			return nil, &Error{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("CSV exceeds %d rows", maxImportRows)}
+		}
+		rows = append(rows, record)
+	}
+	records := make([]importRecord, len(rows))
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for w := 0; w < importWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range next {
+				row := j + 2
+				if len(rows[j]) != len(columns) {
+					records[j] = importRecord{row: row, err: fmt.Sprintf("Row has %d fields, want %d", len(rows[j]), len(columns))}
+					continue
+				}
+				records[j] = parseImportRow(row, columns, rows[j])
+			}
+		}()
+	}
+	for j := range rows {
+		next <- j
+	}
+	close(next)
+	wg.Wait()
+	return records, nil
+}
+
+// importDevice applies the validated record to the cache, and returns the outcome. Empty fields of the record leave the
+// fields of an existing device unchanged.
+func importDevice(i *deviceCache, r importRecord) ImportRow {
+	row := ImportRow{Row: r.row, MACAddress: r.device.MACAddress, Result: importError}
+	if r.err != "" {
+		row.Reason = r.err
+		return row
+	}
+	device, exists := i.findMAC(r.device.MACAddress)
+	if !exists {
+		i.add(r.device)
+		i.record(r.device.MACAddress)
+		row.Result = importCreated
+		row.Warning = localWarning(r.device.MACAddress)
+		return row
+	}
+	before := etag(newDeviceResource(device))
+	if r.device.Name != "" {
+		device.Name = r.device.Name
+	}
+	if r.device.Description != "" {
+		device.Description = r.device.Description
+	}
+	if r.device.IPAddress != "" && r.device.IPAddress != device.IPAddress {
+		device.IPAddress, device.LastKnownIP = r.device.IPAddress, ""
+	}
+	for _, g := range r.device.Groups {
+		if !contains(device.Groups, g) {
+			device.Groups = append(device.Groups, g)
+		}
+	}
+	if r.device.Platform != "" {
+		device.Platform = r.device.Platform
+	}
+	if etag(newDeviceResource(device)) == before {
+		row.Result = importSkipped
+		row.Reason = "Device is unchanged"
+		return row
+	}
+	device.Source = ""
+	i.update(device)
+	row.Result = importUpdated
+	return row
+}
+
+// importHandler handles POST /api/v1/import, which creates or updates the devices of a CSV file. Each row is imported
+// on its own, and the outcome of every row is reported. No changes are made if the dryRun parameter is true.
+func (s *Server) importHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	if r.Method != http.MethodPost {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+		}
+	}
+	report := ImportReport{Rows: make([]ImportRow, 0)}
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for dryRun: %s", v)}
+		}
+		report.DryRun = b
+	}
+	records, rerr := readImport(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if rerr != nil {
+		return nil, rerr
+	}
+	u := userFrom(r.Context())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	seen := make(map[string]int, len(records))
+	for _, rec := range records {
+		if rec.err == "" {
+			if prev, ok := seen[rec.device.MACAddress]; ok {
+				rec.err = fmt.Sprintf("Duplicate of row %d", prev)
+			} else {
+				seen[rec.device.MACAddress] = rec.row
+			}
+		}
+		if rec.err == "" {
+			if d, ok := i.findMAC(rec.device.MACAddress); ok {
+				if !allows(access(u, d), AccessManage) {
+					rec.err = "Forbidden"
+				}
+			} else if err := validateSharing(u, &rec.device.Sharing); err != nil {
+				rec.err = err.Message
+			}
+		}
+		report.add(importDevice(i, rec))
+	}
+	if report.DryRun || report.Created+report.Updated == 0 {
+		return &report, nil
+	}
+	if err := s.writeCache(i, requestActor(r)); err != nil {
+		return nil, storeFailure(err)
+	}
+	return &report, nil
+}
@@ -0,0 +1,45 @@
+package http
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	return struct {
+		Status string `json:"status"`
+	}{"ok"}, nil
+}
+
+// handleInternal registers the endpoints intended for operators, rather than users, on mux.
+func (s *Server) handleInternal(mux *http.ServeMux) {
+	mux.Handle("/api/v1/admin/events", appHandler(s.eventsHandler))
+	mux.Handle("/api/v1/admin/quotas", appHandler(s.quotasHandler))
+	mux.Handle("/api/v1/admin/keys", appHandler(s.keysHandler))
+	mux.Handle("/api/v1/admin/keys/", appHandler(s.keysHandler))
+	mux.Handle("/api/v1/admin/bans", appHandler(s.bansHandler))
+	mux.Handle("/api/v1/admin/bans/", appHandler(s.bansHandler))
+	mux.Handle("/api/v1/admin/history/", appHandler(s.historyChainHandler))
+	mux.Handle("/api/v1/admin/oui", appHandler(s.ouiHandler))
+	mux.Handle("/api/v1/admin/devices:"+bulkDelete, s.bulkHandler(bulkDelete))
+	mux.Handle("/api/v1/admin/devices:"+bulkTag, s.bulkHandler(bulkTag))
+	mux.Handle("/api/v1/admin/devices:"+bulkGroup, s.bulkHandler(bulkGroup))
+	mux.HandleFunc("/metrics", s.metricsHandler)
+	mux.Handle("/healthz", appHandler(healthzHandler))
+	mux.Handle("/readyz", appHandler(s.readyzHandler))
+	mux.Handle("/statusz", appHandler(s.statuszHandler))
+}
+
+// InternalHandler returns the handler serving metrics, health, pprof and admin endpoints on InternalAddr.
+func (s *Server) InternalHandler() http.Handler {
+	mux := http.NewServeMux()
+	s.handleInternal(mux)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/api/", appHandler(notFoundHandler))
+	return s.requestLogFilter(requestFilter(s.envelopeFilter(s.authFilter(mux))))
+}
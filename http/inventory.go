@@ -0,0 +1,137 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/mpolden/wakeup/inventory"
+	"github.com/mpolden/wakeup/schedule"
+	"github.com/mpolden/wakeup/ups"
+)
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	actionAdd    = "add"
+	actionUpdate = "update"
+	actionRemove = "remove"
+)
+
+// upsert adds or updates device as imported from source, and returns the action taken, if any. Devices added manually,
+// or imported from other sources, are never modified.
+func (d *deviceCache) upsert(source string, device Device) string {
+	existing, ok := d.find(device.MACAddress)
+	if !ok {
+		device.Source = source
+		d.add(device)
+		d.record(device.MACAddress)
+		return actionAdd
+	}
+	if existing.Source != source {
+		return ""
+	}
+	if existing.Name == device.Name && existing.IPAddress == device.IPAddress && equalStrings(existing.Groups, device.Groups) {
+		return ""
+	}
+	existing.Name, existing.IPAddress, existing.Groups = device.Name, device.IPAddress, device.Groups
+	d.update(existing)
+	return actionUpdate
+}
+
+// removeFrom removes the device having macAddress if it was imported from source.
+func (d *deviceCache) removeFrom(source, macAddress string) bool {
+	existing, ok := d.find(macAddress)
+	if !ok || existing.Source != source {
+		return false
+	}
+	d.remove(existing)
+	d.recordRemoved(macAddress, existing)
+	return true
+}
+
+// Reconcile makes the devices imported from source match records. Devices added manually, or imported from other
+// sources, are never modified.
+func (s *Server) Reconcile(source string, records []inventory.Record) (inventory.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var r inventory.Result
+	i, err := s.readDevices()
+	if err != nil {
+		return r, err
+	}
+	seen := make(map[string]bool, len(records))
+	for _, rec := range records {
+		seen[rec.MACAddress] = true
+		switch i.upsert(source, Device{Name: rec.Name, MACAddress: rec.MACAddress, IPAddress: rec.IPAddress, Groups: rec.Groups}) {
+		case actionAdd:
+			r.Added++
+		case actionUpdate:
+			r.Updated++
+		}
+	}
+	for _, d := range append([]Device(nil), i.Devices...) {
+		if !seen[d.MACAddress] && i.removeFrom(source, d.MACAddress) {
+			r.Removed++
+		}
+	}
+	if r.Added == 0 && r.Updated == 0 && r.Removed == 0 {
+		return r, nil
+	}
+	return r, s.writeCache(i, actor{name: source})
+}
+
+// Status is the state of the server, as reported by /statusz.
+type Status struct {
+	Status    string                     `json:"status"`
+	Revision  int64                      `json:"revision"`
+	Devices   int                        `json:"devices"`
+	ConfigMap *inventory.ConfigMapStatus `json:"configMap,omitempty"`
+	UPS       *UPSStatus                 `json:"ups,omitempty"`
+	Planned   []schedule.Planned         `json:"planned,omitempty"`
+}
+
+// UPSStatus is the state of the UPS, as reported by /statusz.
+type UPSStatus struct {
+	ups.Status
+	Error    string `json:"error,omitempty"`
+	Deferred int    `json:"deferred"`
+}
+
+func (s *Server) statuszHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, &Error{err: err, Status: http.StatusServiceUnavailable, Message: "Could not read devices"}
+	}
+	status := Status{Status: "ok", Revision: i.Revision, Devices: len(i.Devices)}
+	if s.ConfigMap != nil {
+		cs := s.ConfigMap.Status()
+		status.ConfigMap = &cs
+		if cs.Error != "" {
+			status.Status = "error"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}
+	if s.UPS != nil {
+		us, err := s.UPS.Status()
+		status.UPS = &UPSStatus{Status: us, Deferred: s.deferredCount()}
+		if err != nil {
+			status.UPS.Error = err.Error()
+		}
+	}
+	if s.Scheduler != nil {
+		status.Planned = s.Scheduler.Planned()
+	}
+	return status, nil
+}
@@ -0,0 +1,215 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// maxJournalSize is the size of the journal at which it is compacted into the cache file.
+const maxJournalSize = 1 << 20
+
+// journalEntry is a mutation of the cache. Entries are appended to the journal, and replayed on top of the cache file
+// when the cache is read. Replaying an entry more than once yields the same cache, so a crash during compaction loses
+// nothing.
+type journalEntry struct {
+	Revision int64    `json:"revision"`
+	Devices  []Device `json:"devices,omitempty"`
+	// Removed holds the IDs of removed devices.
+	Removed []string `json:"removed,omitempty"`
+	Changes []change `json:"changes,omitempty"`
+	// SmartGroups replaces the smart groups, if set.
+	SmartGroups *[]SmartGroup `json:"smartGroups,omitempty"`
+	// Schedules replaces the schedules, if set.
+	Schedules *[]Schedule `json:"schedules,omitempty"`
+}
+
+func (s *Server) journalFile() string { return fileStore{s.cacheFile}.journal() }
+
+func (f fileStore) journal() string { return f.name + ".journal" }
+
+// diff returns the entry which turns cache c into cache next. The returned bool is false if there is no difference.
+func (c *deviceCache) diff(next *deviceCache) (journalEntry, bool) {
+	e := journalEntry{Revision: next.Revision}
+	old := make(map[string][]byte, len(c.Devices))
+	for _, d := range c.Devices {
+		data, _ := json.Marshal(d)
+		old[d.ID] = data
+	}
+	for _, d := range next.Devices {
+		data, _ := json.Marshal(d)
+		if prev, ok := old[d.ID]; !ok || !bytes.Equal(prev, data) {
+			e.Devices = append(e.Devices, d)
+		}
+		delete(old, d.ID)
+	}
+	for id := range old {
+		e.Removed = append(e.Removed, id)
+	}
+	sort.Strings(e.Removed)
+	for _, ch := range next.Changes {
+		if ch.Revision > c.Revision {
+			e.Changes = append(e.Changes, ch)
+		}
+	}
+	if !sameSmartGroups(c.SmartGroups, next.SmartGroups) {
+		groups := append(make([]SmartGroup, 0, len(next.SmartGroups)), next.SmartGroups...)
+		e.SmartGroups = &groups
+	}
+	if !sameSchedules(c.Schedules, next.Schedules) {
+		schedules := append(make([]Schedule, 0, len(next.Schedules)), next.Schedules...)
+		e.Schedules = &schedules
+	}
+	return e, len(e.Devices) > 0 || len(e.Removed) > 0 || e.SmartGroups != nil || e.Schedules != nil || e.Revision != c.Revision
+}
+
+func (c *deviceCache) apply(e journalEntry) {
+	for _, d := range e.Devices {
+		found := false
+		for j, v := range c.Devices {
+			if same(v, d) {
+				c.Devices[j], found = d, true
+			}
+		}
+		if !found {
+			c.Devices = append(c.Devices, d)
+		}
+	}
+	for _, id := range e.Removed {
+		if d, ok := c.findID(id); ok {
+			c.remove(d)
+		}
+	}
+	// Skip changes already compacted into the cache
+	for _, ch := range e.Changes {
+		if ch.Revision > c.Revision {
+			c.Changes = append(c.Changes, ch)
+		}
+	}
+	if n := len(c.Changes); n > maxChanges {
+		c.Changes = c.Changes[n-maxChanges:]
+	}
+	if e.SmartGroups != nil {
+		c.SmartGroups = *e.SmartGroups
+	}
+	if e.Schedules != nil {
+		c.Schedules = *e.Schedules
+	}
+	if e.Revision > c.Revision {
+		c.Revision = e.Revision
+	}
+	if c.Devices == nil {
+		c.Devices = make([]Device, 0)
+	}
+}
+
+// replay applies the journal to cache c.
+func (f fileStore) replay(c *deviceCache) error {
+	data, err := ioutil.ReadFile(f.journal())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// An entry not ending in a newline was interrupted while being written, and is ignored
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+	for n, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("%s:%d: %w", f.journal(), n+1, err)
+		}
+		c.apply(e)
+	}
+	return nil
+}
+
+// save appends the mutation of the cache from prev into i to the journal, which is compacted once it grows large.
+func (f fileStore) save(prev, i *deviceCache) error {
+	e, changed := prev.diff(i)
+	if !changed {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.journal(), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	size, err := complete(file)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(append(data, '\n'), size); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if size+int64(len(data)) >= maxJournalSize {
+		return f.compact()
+	}
+	return nil
+}
+
+// complete truncates any interrupted entry at the end of journal f, and returns the resulting size of f.
+func complete(f *os.File) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 {
+		return 0, err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, fi.Size()-1); err != nil {
+		return 0, err
+	}
+	if last[0] == '\n' {
+		return fi.Size(), nil
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	size := int64(bytes.LastIndexByte(data, '\n') + 1)
+	return size, f.Truncate(size)
+}
+
+// Compact compacts the store, e.g. by writing the journal into the cache file and truncating the journal.
+func (s *Server) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backend().compact()
+}
+
+// compact writes the journal into the cache file and truncates the journal.
+func (f fileStore) compact() error {
+	i, err := f.load()
+	if err != nil {
+		return err
+	}
+	if err := f.store(i); err != nil {
+		return err
+	}
+	if err := os.Remove(f.journal()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RunCompaction compacts the journal at interval.
+func (s *Server) RunCompaction(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.Compact(); err != nil {
+			log.Print(err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mpolden/wakeup/budget"
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/history"
+)
+
+const keepAwakeSource = "keepawake"
+
+// maxKeepAwakeWakes is the number of times a device is woken within keepAwakePeriod before keeping it awake is
+// suspended for the rest of the window, e.g. because it is being shut down on purpose or fails to stay online.
+const (
+	maxKeepAwakeWakes = 3
+	keepAwakePeriod   = time.Hour
+)
+
+// KeepAwake is a daily window during which a device is kept awake, by waking it whenever the monitor sees it go
+// offline.
+type KeepAwake struct {
+	// From and To are the times of day, in the format HH:MM, at which the window starts and ends. The window spans
+	// midnight if To is before From, and the whole day if they are equal.
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func parseMinutes(v string) (int, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %s", v)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (k *KeepAwake) bounds() (int, int, error) {
+	from, err := parseMinutes(k.From)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := parseMinutes(k.To)
+	if err != nil {
+		return 0, 0, err
+	}
+	return from, to, nil
+}
+
+// contains returns whether t is within the window.
+func (k *KeepAwake) contains(t time.Time) bool {
+	from, to, err := k.bounds()
+	if err != nil {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	if from < to {
+		return m >= from && m < to
+	}
+	if from > to {
+		return m >= from || m < to
+	}
+	return true
+}
+
+// end returns the time at which the window containing t ends.
+func (k *KeepAwake) end(t time.Time) time.Time {
+	_, to, _ := k.bounds()
+	end := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(time.Duration(to) * time.Minute)
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+func validateKeepAwake(k *KeepAwake) *Error {
+	if k == nil {
+		return nil
+	}
+	if _, _, err := k.bounds(); err != nil {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid keep awake window: %s", err)}
+	}
+	return nil
+}
+
+// keepAwake is the state of keeping a device awake.
+type keepAwake struct {
+	wakes     []time.Time
+	suspended time.Time
+}
+
+// rewake decides whether device should be woken after going offline at t. It returns false and the time until which
+// keeping the device awake is suspended, if it has been woken too often recently.
+func (s *Server) rewake(mac string, k *KeepAwake, t time.Time) (bool, time.Time) {
+	s.keepAwakeMu.Lock()
+	defer s.keepAwakeMu.Unlock()
+	if s.keepAwake == nil {
+		s.keepAwake = make(map[string]*keepAwake)
+	}
+	ka, ok := s.keepAwake[mac]
+	if !ok {
+		ka = &keepAwake{}
+		s.keepAwake[mac] = ka
+	}
+	if t.Before(ka.suspended) {
+		return false, time.Time{}
+	}
+	var wakes []time.Time
+	for _, w := range ka.wakes {
+		if t.Sub(w) < keepAwakePeriod {
+			wakes = append(wakes, w)
+		}
+	}
+	if len(wakes) >= maxKeepAwakeWakes {
+		ka.wakes = nil
+		ka.suspended = k.end(t)
+		return false, ka.suspended
+	}
+	ka.wakes = append(wakes, t)
+	return true, time.Time{}
+}
+
+func (s *Server) recordKeepAwake(entry history.Entry) {
+	log.Print(entry.Message)
+	if s.History == nil {
+		return
+	}
+	if err := s.History.Append(entry); err != nil {
+		log.Printf("could not record history: %s", err)
+	}
+}
+
+// KeepAwake wakes devices that go offline within their keep awake window, on events received from events. Devices are
+// seen going offline by the monitor.
+func (s *Server) KeepAwake(events <-chan event.Event) {
+	for e := range events {
+		if e.Type != event.Offline {
+			continue
+		}
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			continue
+		}
+		device, ok := i.lookup(e.MACAddress)
+		if !ok || device.KeepAwake == nil {
+			continue
+		}
+		t := e.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+		if !device.KeepAwake.contains(t) {
+			continue
+		}
+		entry := history.Entry{Device: device.ID, MACAddress: device.MACAddress}
+		ok, until := s.rewake(device.MACAddress, device.KeepAwake, t)
+		if !ok {
+			if !until.IsZero() {
+				entry.Action = history.KeepAwakeSuspended
+				entry.Message = fmt.Sprintf("Not keeping device with address %s awake until %s: woken %d times within %s",
+					device.MACAddress, until.Format("15:04"), maxKeepAwakeWakes, keepAwakePeriod)
+				s.recordKeepAwake(entry)
+			}
+			continue
+		}
+		entry.Action, entry.Result = history.KeepAwakeWoken, history.ResultOK
+		entry.Message = fmt.Sprintf("Woke device with address %s after it went offline", device.MACAddress)
+		if err := s.wakeAutomated(context.Background(), actor{name: keepAwakeSource}, device, budget.PriorityRetry); err != nil {
+			entry.Result = history.ResultFailed
+			entry.Message = fmt.Sprintf("Could not wake device with address %s after it went offline: %s", device.MACAddress, err)
+		}
+		s.recordKeepAwake(entry)
+	}
+}
@@ -0,0 +1,113 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mpolden/wakeup/apikey"
+)
+
+// KeyResource is an API key. Its secret is only included when the key is created.
+type KeyResource struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Devices []string  `json:"devices"`
+	Created time.Time `json:"created"`
+	Secret  string    `json:"secret,omitempty"`
+	// AllowUnknown reports whether the key can wake MAC addresses that are not stored.
+	AllowUnknown bool `json:"allowUnknown"`
+}
+
+// KeyResources lists the API keys.
+type KeyResources struct {
+	Keys []KeyResource `json:"keys"`
+}
+
+type keyRequest struct {
+	Name string `json:"name"`
+	// Devices are the IDs, MAC addresses or names of the devices the key is scoped to.
+	Devices []string `json:"devices"`
+	// AllowUnknown allows the key to wake MAC addresses that are not stored, without storing them. Wakes of unknown
+	// MAC addresses publish an unknown event whether they are allowed or not.
+	AllowUnknown bool `json:"allowUnknown"`
+}
+
+func newKeyResource(k apikey.Key) KeyResource {
+	return KeyResource{ID: k.ID, Name: k.Name, Devices: k.Devices, Created: k.Created, AllowUnknown: k.AllowUnknown}
+}
+
+// keysHandler handles /api/v1/admin/keys, which lists and creates API keys, and /api/v1/admin/keys/{id}, which
+// deletes the key having ID id.
+func (s *Server) keysHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	if err := s.authorizeAdmin(r); err != nil {
+		return nil, err
+	}
+	if s.Keys == nil {
+		return notFoundHandler(w, r)
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/keys"), "/")
+	if id != "" {
+		if r.Method != http.MethodDelete {
+			return nil, &Error{
+				Status:  http.StatusMethodNotAllowed,
+				Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodDelete),
+			}
+		}
+		if err := s.Keys.Delete(id); err == apikey.ErrNotFound {
+			return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Key not found: %s", id)}
+		} else if err != nil {
+			return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not delete key"}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil, nil
+	}
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := s.Keys.List()
+		if err != nil {
+			return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not read keys"}
+		}
+		res := KeyResources{Keys: make([]KeyResource, 0, len(keys))}
+		for _, k := range keys {
+			res.Keys = append(res.Keys, newKeyResource(k))
+		}
+		return &res, nil
+	case http.MethodPost:
+		var req keyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+		}
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		// Keys are scoped to stored devices, by ID, so that they keep their scope when a device is renamed
+		ids := make([]string, 0, len(req.Devices))
+		for _, ref := range req.Devices {
+			d, err := i.resolve(ref)
+			if err != nil || d.ID == "" {
+				return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Device not found: %s", ref)}
+			}
+			ids = append(ids, d.ID)
+		}
+		k, secret, err := s.Keys.Create(req.Name, ids, req.AllowUnknown)
+		if err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid key: %s", err)}
+		}
+		res := newKeyResource(k)
+		res.Secret = secret
+		w.Header().Set("Location", "/api/v1/admin/keys/"+k.ID)
+		w.WriteHeader(http.StatusCreated)
+		return &res, nil
+	}
+	return nil, &Error{
+		Status:  http.StatusMethodNotAllowed,
+		Message: fmt.Sprintf("Invalid method %s, must be %s or %s", r.Method, http.MethodGet, http.MethodPost),
+	}
+}
@@ -0,0 +1,229 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mpolden/wakeup/budget"
+	"github.com/mpolden/wakeup/macaddr"
+	"github.com/mpolden/wakeup/wol"
+)
+
+// wakeStatus returns the status of a failed wake. Invalid MAC addresses and options are errors of the request, and
+// refused wakes are reported as such, while a network that cannot be reached is an error of the server as a gateway to
+// it, and failing to send packets otherwise an error of the server.
+func wakeStatus(err error) int {
+	switch {
+	case errors.Is(err, wol.ErrInvalidMAC), errors.Is(err, wol.ErrInvalidOptions):
+		return http.StatusBadRequest
+	case errors.Is(err, wol.ErrNoRoute):
+		return http.StatusBadGateway
+	case errors.Is(err, errPrerequisites), errors.Is(err, errPreWake):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, errOnBattery), errors.Is(err, errStoreUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, budget.ErrExceeded), errors.Is(err, errCooldown):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// WakeResult holds the MAC addresses magic packets were sent to, when waking a device having several MAC addresses.
+type WakeResult struct {
+	Sent []string `json:"sent"`
+	// Skipped is true if no magic packet was sent because the device is already online.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// macAddresses returns the MAC addresses of device, in the order they are woken.
+func (d Device) macAddresses() []string {
+	return append([]string{d.MACAddress}, d.MACAddresses...)
+}
+
+// validateTarget verifies that mac is a MAC address which can be woken. Group addresses, i.e. multicast and broadcast
+// addresses, belong to no device.
+func validateTarget(mac string) *Error {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid MAC address: %s", mac)}
+	}
+	if macaddr.Multicast(hwAddr) {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid MAC address: %s, must not be a multicast or broadcast address", mac)}
+	}
+	return nil
+}
+
+// localWarning returns a warning about mac if it is locally administered, or an empty string if it is not. Such
+// addresses are typically randomized by the operating system, which makes the device unwakeable once it changes.
+func localWarning(mac string) string {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil || !macaddr.LocallyAdministered(hwAddr) {
+		return ""
+	}
+	return fmt.Sprintf("MAC address %s is locally administered and may change, e.g. if randomized by the operating system", mac)
+}
+
+// warnLocal adds a warning to the response for each locally administered MAC address of next which prev does not have.
+func warnLocal(w http.ResponseWriter, prev, next Device) {
+	known := prev.macAddresses()
+	for _, mac := range next.macAddresses() {
+		if containsMAC(known, mac) {
+			continue
+		}
+		if warning := localWarning(mac); warning != "" {
+			w.Header().Add("Warning", fmt.Sprintf("199 wakeup %q", warning))
+		}
+	}
+}
+
+// validateMACAddresses verifies that the additional MAC addresses of device are valid, distinct and can be woken.
+func validateMACAddresses(device Device) *Error {
+	seen := make(map[string]bool)
+	if mac, ok := normalizeMAC(device.MACAddress); ok {
+		seen[mac] = true
+	}
+	for _, v := range device.MACAddresses {
+		mac, ok := normalizeMAC(v)
+		if !ok {
+			return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid MAC address: %s", v)}
+		}
+		if err := validateTarget(v); err != nil {
+			return err
+		}
+		if seen[mac] {
+			return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Duplicate MAC address: %s", v)}
+		}
+		seen[mac] = true
+	}
+	return nil
+}
+
+// wakeOptions returns the options of magic packets sent from src to device.
+func (d Device) wakeOptions(src net.IP) (wol.Options, error) {
+	opts := wol.Options{Source: src, Port: d.WakePort, Transport: d.Transport, Interface: d.WakeInterface}
+	if d.SecureOnPassword != "" {
+		p, err := wol.ParsePassword(d.SecureOnPassword)
+		if err != nil {
+			return wol.Options{}, err
+		}
+		opts.Password = p
+	}
+	if d.WakeAddress != "" {
+		ip, zone, err := wol.ParseDestination(d.WakeAddress)
+		if err != nil {
+			return wol.Options{}, err
+		}
+		opts.Destination, opts.Interface = ip, zone
+	}
+	return opts, nil
+}
+
+// overrides returns true if the request to wake device changes where magic packets are sent to the stored device.
+func overrides(device, stored Device) bool {
+	return (device.WakeAddress != "" && device.WakeAddress != stored.WakeAddress) ||
+		(device.WakePort != 0 && device.WakePort != stored.WakePort) ||
+		(device.Transport != "" && device.Transport != stored.Transport) ||
+		(device.WakeInterface != "" && device.WakeInterface != stored.WakeInterface)
+}
+
+// Limits of the bursts of magic packets a request may ask for.
+const (
+	maxPackets        = 10
+	maxPacketInterval = 5 * time.Second
+	maxRetries        = 5
+)
+
+// burst configures how many magic packets are sent to each MAC address, and how often sending them is retried.
+type burst struct {
+	packets  int
+	interval time.Duration
+	retries  int
+}
+
+// packetsTo returns the number of packets sent to device by a burst, if every attempt is retried. This is what the burst
+// takes from the send budget.
+func (b burst) packetsTo(device Device) int {
+	packets := b.packets
+	if packets < 1 {
+		// A single packet is sent by default
+		packets = 1
+	}
+	return packets * len(device.macAddresses()) * (b.retries + 1)
+}
+
+// burst returns the burst of packets sent by default.
+func (s *Server) burst() burst {
+	return burst{packets: s.Packets, interval: s.PacketInterval, retries: s.Retries}
+}
+
+// burst returns b overridden by the fields of the request which are set.
+func (req *wakeRequest) burst(b burst) (burst, *Error) {
+	if req.Packets != 0 {
+		if req.Packets < 0 || req.Packets > maxPackets {
+			return b, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid number of packets: %d, must be between 1 and %d", req.Packets, maxPackets)}
+		}
+		b.packets = req.Packets
+	}
+	if req.PacketInterval != "" {
+		d, err := time.ParseDuration(req.PacketInterval)
+		if err != nil || d < 0 || d > maxPacketInterval {
+			return b, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid packet interval: %s, must be at most %s", req.PacketInterval, maxPacketInterval)}
+		}
+		b.interval = d
+	}
+	if req.Retries != nil {
+		if *req.Retries < 0 || *req.Retries > maxRetries {
+			return b, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid number of retries: %d, must be between 0 and %d", *req.Retries, maxRetries)}
+		}
+		b.retries = *req.Retries
+	}
+	return b, nil
+}
+
+// wakeAll sends magic packets from src to each MAC address of device, as configured by the server, and returns the
+// addresses packets were sent to. The wake fails only if no packet could be sent.
+func (s *Server) wakeAll(src net.IP, device Device) ([]string, error) {
+	return s.wakeBurst(src, device, s.burst())
+}
+
+// wakeBurst sends a burst of magic packets from src to each MAC address of device, as wakeAll does.
+func (s *Server) wakeBurst(src net.IP, device Device, b burst) ([]string, error) {
+	var (
+		sent  []string
+		first error
+	)
+	opts, err := device.wakeOptions(src)
+	if err != nil {
+		return nil, err
+	}
+	opts.Count, opts.Interval, opts.Retries = b.packets, b.interval, b.retries
+	if opts.Transport == "" {
+		opts.Transport = s.Transport
+	}
+	// The interface of the server only applies to packets sent from its source address, which routes override
+	if opts.Interface == "" && (src == nil || src.Equal(s.SourceIP)) {
+		opts.Interface = s.Interface
+	}
+	for _, mac := range device.macAddresses() {
+		hwAddr, err := net.ParseMAC(mac)
+		if err != nil {
+			err = fmt.Errorf("%w: %s", wol.ErrInvalidMAC, mac)
+		} else {
+			err = s.wakeFunc(hwAddr, opts)
+		}
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		sent = append(sent, mac)
+	}
+	if len(sent) == 0 {
+		return nil, first
+	}
+	return sent, nil
+}
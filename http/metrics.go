@@ -0,0 +1,98 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/mpolden/wakeup/energy"
+)
+
+func writeMetric(w io.Writer, name, kind, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+// sample is a value of a metric having labels.
+type sample struct {
+	labels string
+	value  interface{}
+}
+
+func writeSamples(w io.Writer, name, kind, help string, samples []sample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s{%s} %v\n", name, s.labels, s.value)
+	}
+}
+
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if s.Budget != nil {
+		stats := s.Budget.Stats()
+		paused := 0
+		if stats.Paused {
+			paused = 1
+		}
+		writeMetric(w, "wakeup_budget_allowed_total", "counter", "Magic packets allowed by the send budget.", stats.Allowed)
+		writeMetric(w, "wakeup_budget_rejected_total", "counter", "Magic packets rejected by the send budget.", stats.Rejected)
+		writeMetric(w, "wakeup_budget_trips_total", "counter", "Number of times the send budget circuit breaker tripped.", stats.Trips)
+		writeMetric(w, "wakeup_budget_paused", "gauge", "Whether automated wakes are paused.", paused)
+		writeMetric(w, "wakeup_budget_waiting", "gauge", "Magic packets waiting for the send budget.", s.Budget.Waiting())
+	}
+	if s.Events != nil {
+		stats := s.Events.Stats()
+		writeMetric(w, "wakeup_event_subscribers", "gauge", "Subscribers of events.", stats.Subscribers)
+		writeMetric(w, "wakeup_event_subscribers_lagging", "gauge", "Subscribers dropping events because they are not keeping up.", stats.Lagging)
+		writeMetric(w, "wakeup_events_published_total", "counter", "Events published to subscribers.", stats.Published)
+		writeMetric(w, "wakeup_events_dropped_total", "counter", "Events dropped for subscribers not keeping up.", stats.Dropped)
+	}
+	if s.Relay != nil {
+		stats := s.Relay.Stats()
+		writeMetric(w, "wakeup_relay_forwarded_total", "counter", "Magic packets forwarded by the relay.", stats.Forwarded)
+		writeSamples(w, "wakeup_relay_dropped_total", "counter", "Magic packets dropped by the relay.", []sample{
+			{`reason="invalid"`, stats.Invalid},
+			{`reason="filtered"`, stats.Filtered},
+			{`reason="duplicate"`, stats.Duplicates},
+			{`reason="hop_limit"`, stats.HopLimited},
+			{`reason="rate_limit"`, stats.RateLimited},
+		})
+	}
+	if s.Health != nil {
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		var scores []sample
+		for _, d := range i.Devices {
+			if h, ok := s.deviceHealth(d); ok {
+				scores = append(scores, sample{fmt.Sprintf("mac_address=%q,name=%q", d.MACAddress, d.Name), h.Score})
+			}
+		}
+		writeSamples(w, "wakeup_device_health_score", "gauge", "How reliably the device comes online when woken, from 0 to 100.", scores)
+	}
+	if s.Energy != nil {
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		var uptime, usage []sample
+		for _, d := range i.Devices {
+			if d.Watts <= 0 {
+				continue
+			}
+			labels := fmt.Sprintf("mac_address=%q,name=%q", d.MACAddress, d.Name)
+			u := s.Energy.Uptime(d.MACAddress)
+			uptime = append(uptime, sample{labels, u.Seconds()})
+			usage = append(usage, sample{labels, energy.KWh(d.Watts, u)})
+		}
+		writeSamples(w, "wakeup_device_uptime_seconds_total", "counter", "Time the device has been online.", uptime)
+		writeSamples(w, "wakeup_device_energy_kwh_total", "counter", "Estimated energy used by the device.", usage)
+	}
+}
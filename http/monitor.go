@@ -0,0 +1,234 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/trace"
+)
+
+// maxConcurrentProbes is the number of devices the monitor probes at once.
+const maxConcurrentProbes = 16
+
+// probeResult is the last result of probing a device.
+type probeResult struct {
+	ipAddress string
+	online    bool
+	checked   time.Time
+	// since is when the device was last observed changing between online and offline.
+	since time.Time
+}
+
+// DeviceStatus is the status of a device.
+type DeviceStatus struct {
+	ID         string `json:"id"`
+	MACAddress string `json:"macAddress"`
+	IPAddress  string `json:"ipAddress,omitempty"`
+	// Status is online, offline, waking, conflict, or unknown if the device has no IP address.
+	Status  string `json:"status"`
+	Checked string `json:"checked,omitempty"`
+	Since   string `json:"since,omitempty"`
+}
+
+// statusInterval returns the interval at which the statuses of devices are refreshed, either by the monitor or by
+// probing devices whose cached status has expired.
+func (s *Server) statusInterval() time.Duration {
+	if s.MonitorInterval > 0 {
+		return s.MonitorInterval
+	}
+	return defaultStatusInterval
+}
+
+// statusTTL returns for how long statuses are cached. Statuses refreshed by the monitor are cached for twice its
+// interval, so that they do not expire before the next refresh.
+func (s *Server) statusTTL() time.Duration {
+	if s.MonitorInterval > 0 {
+		return 2 * s.MonitorInterval
+	}
+	return defaultStatusInterval
+}
+
+// cacheStatus tells clients that a status checked at checked may be cached until it is refreshed.
+func (s *Server) cacheStatus(w http.ResponseWriter, directive string, checked time.Time) {
+	age := 0
+	if !checked.IsZero() {
+		age = int(time.Since(checked).Seconds())
+		w.Header().Set("Age", strconv.Itoa(age))
+	}
+	maxAge := int(s.statusInterval().Seconds()) - age
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	if directive != "" {
+		directive += ", "
+	}
+	w.Header().Set("Cache-Control", directive+"max-age="+strconv.Itoa(maxAge))
+}
+
+// checked returns when the cached status of device was checked, if it has one.
+func (s *Server) checked(device Device) time.Time {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if p, ok := s.statuses[device.MACAddress]; ok && p.ipAddress == device.address() {
+		return p.checked
+	}
+	return time.Time{}
+}
+
+func parseFresh(r *http.Request) (bool, *Error) {
+	v := r.URL.Query().Get("fresh")
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for fresh: %s", v)}
+	}
+	return b, nil
+}
+
+// observe records whether device was online at ipAddress, and records the probe in the trace.
+func (s *Server) observe(device Device, ipAddress string, online bool) probeResult {
+	now := time.Now()
+	s.record(trace.Record{Time: now.UTC(), Probe: &trace.Probe{MACAddress: device.MACAddress, IPAddress: ipAddress, Online: online}})
+	return s.observeAt(device, ipAddress, online, now)
+}
+
+// observeAt records whether device was online at ipAddress at time now. Online and offline events are published when
+// a device changes between the two.
+func (s *Server) observeAt(device Device, ipAddress string, online bool, now time.Time) probeResult {
+	s.statusMu.Lock()
+	if s.statuses == nil {
+		s.statuses = make(map[string]probeResult)
+	}
+	prev, known := s.statuses[device.MACAddress]
+	r := probeResult{ipAddress: ipAddress, online: online, checked: now, since: prev.since}
+	changed := known && prev.online != online
+	if changed || !known {
+		r.since = now
+	}
+	s.statuses[device.MACAddress] = r
+	s.statusMu.Unlock()
+	if changed {
+		e := event.Event{Type: event.Offline, Device: device.ID, MACAddress: device.MACAddress, Name: device.Name, Time: now}
+		if online {
+			e.Type = event.Online
+		}
+		s.broadcast(e)
+	}
+	return r
+}
+
+// probe returns whether device is online, from its cached status if it has not expired, or else by probing it. The
+// cache is bypassed if fresh is true. Concurrent requests for the status of a device share a single probe.
+func (s *Server) probe(ctx context.Context, device Device, fresh bool) probeResult {
+	ip := device.address()
+	if ip == "" {
+		return probeResult{}
+	}
+	for {
+		s.statusMu.Lock()
+		r, ok := s.statuses[device.MACAddress]
+		if !fresh && ok && r.ipAddress == ip && time.Since(r.checked) < s.statusTTL() {
+			s.statusMu.Unlock()
+			return r
+		}
+		done, probing := s.probing[device.MACAddress]
+		if !probing {
+			if s.probing == nil {
+				s.probing = make(map[string]chan bool)
+			}
+			s.probing[device.MACAddress] = make(chan bool)
+			s.statusMu.Unlock()
+			break
+		}
+		s.statusMu.Unlock()
+		select {
+		case <-done:
+			// The probe in progress started after this request, so its result is fresh
+			fresh = false
+		case <-ctx.Done():
+			return r
+		}
+	}
+	defer func() {
+		s.statusMu.Lock()
+		close(s.probing[device.MACAddress])
+		delete(s.probing, device.MACAddress)
+		s.statusMu.Unlock()
+	}()
+	return s.observe(device, ip, s.online(ctx, device, ip))
+}
+
+// Monitor probes all devices having a known IP address at every MonitorInterval.
+func (s *Server) Monitor() {
+	for {
+		s.probeAll()
+		time.Sleep(s.statusInterval())
+	}
+}
+
+func (s *Server) probeAll() {
+	devices, err := s.Devices()
+	if err != nil {
+		return
+	}
+	var wg sync.WaitGroup
+	sem := make(chan bool, maxConcurrentProbes)
+	for _, d := range devices {
+		ip := d.address()
+		if ip == "" {
+			continue
+		}
+		wg.Add(1)
+		sem <- true
+		go func(d Device) {
+			defer wg.Done()
+			s.observe(d, ip, s.online(context.Background(), d, ip))
+			<-sem
+		}(d)
+	}
+	wg.Wait()
+}
+
+func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request, id string) (interface{}, *Error) {
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	ref, rerr := deviceRef(id)
+	if rerr != nil {
+		return nil, rerr
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	device, ok := i.lookup(ref)
+	if !ok || access(userFrom(r.Context()), device) == "" {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", ref)}
+	}
+	fresh, ferr := parseFresh(r)
+	if ferr != nil {
+		return nil, ferr
+	}
+	status := DeviceStatus{ID: device.ID, MACAddress: device.MACAddress, IPAddress: device.address(), Status: s.status(r.Context(), device, fresh)}
+	s.statusMu.Lock()
+	p, ok := s.statuses[device.MACAddress]
+	s.statusMu.Unlock()
+	if ok && p.ipAddress == status.IPAddress {
+		status.Checked = p.checked.UTC().Format(time.RFC3339)
+		status.Since = p.since.UTC().Format(time.RFC3339)
+	}
+	s.cacheStatus(w, "private", s.checked(device))
+	return &status, nil
+}
@@ -0,0 +1,32 @@
+package http
+
+import "github.com/mpolden/wakeup/mqtt"
+
+const mqttSource = "mqtt"
+
+// WakeMQTT wakes the device identified by ref, i.e. its ID, MAC address or name, on behalf of a message published to
+// its wake topic, whose publisher is the user named mqtt.
+func (s *Server) WakeMQTT(ref string) error {
+	return s.wakeRef(actor{name: mqttSource}, s.remoteUser(mqttSource), ref)
+}
+
+// MQTTDevices returns the stored devices, together with their last observed status, for announcing them to an MQTT
+// broker. Devices are not probed.
+func (s *Server) MQTTDevices() ([]mqtt.Device, error) {
+	devices, err := s.Devices()
+	if err != nil {
+		return nil, err
+	}
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	ds := make([]mqtt.Device, 0, len(devices))
+	for _, d := range devices {
+		md := mqtt.Device{ID: d.ID, Name: d.Name, MACAddress: d.MACAddress}
+		if p, ok := s.statuses[d.MACAddress]; ok && p.ipAddress == d.address() {
+			md.Observed = true
+			md.Online = p.online
+		}
+		ds = append(ds, md)
+	}
+	return ds, nil
+}
@@ -0,0 +1,129 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// netboxSource is the source of devices created by NetBox webhooks.
+const netboxSource = "netbox"
+
+type netboxWebhook struct {
+	Event string `json:"event"`
+	Model string `json:"model"`
+	Data  struct {
+		MACAddress string `json:"mac_address"`
+		Device     struct {
+			Name string `json:"name"`
+		} `json:"device"`
+	} `json:"data"`
+	Snapshots struct {
+		Prechange *struct {
+			MACAddress string `json:"mac_address"`
+		} `json:"prechange"`
+	} `json:"snapshots"`
+}
+
+// Change is a change made to a device.
+type Change struct {
+	Action     string `json:"action"`
+	MACAddress string `json:"macAddress"`
+	Name       string `json:"name,omitempty"`
+}
+
+// WebhookResult lists the changes made, or that would be made in a dry run, when handling a webhook.
+type WebhookResult struct {
+	DryRun  bool     `json:"dryRun"`
+	Changes []Change `json:"changes"`
+}
+
+func normalizeMAC(s string) (string, bool) {
+	hwAddr, err := net.ParseMAC(s)
+	if err != nil {
+		return "", false
+	}
+	return strings.ToUpper(hwAddr.String()), true
+}
+
+func validSignature(body []byte, signature, secret string) bool {
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write(body)
+	want := mac.Sum(nil)
+	got, err := hex.DecodeString(signature)
+	return err == nil && hmac.Equal(got, want)
+}
+
+func (s *Server) netboxHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	if s.NetBoxSecret == "" {
+		return notFoundHandler(w, r)
+	}
+	if r.Method != http.MethodPost {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+		}
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Could not read request body"}
+	}
+	if !validSignature(body, r.Header.Get("X-Hook-Signature"), s.NetBoxSecret) {
+		return nil, &Error{Status: http.StatusUnauthorized, Message: "Invalid signature"}
+	}
+	result := WebhookResult{Changes: make([]Change, 0)}
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		result.DryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for dryRun: %s", v)}
+		}
+	}
+	var hook netboxWebhook
+	if err := json.Unmarshal(body, &hook); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	if hook.Model != "interface" {
+		return &result, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	remove := func(mac string) {
+		if mac, ok := normalizeMAC(mac); ok && i.removeFrom(netboxSource, mac) {
+			result.Changes = append(result.Changes, Change{Action: actionRemove, MACAddress: mac})
+		}
+	}
+	switch hook.Event {
+	case "created", "updated":
+		if pre := hook.Snapshots.Prechange; pre != nil && pre.MACAddress != hook.Data.MACAddress {
+			remove(pre.MACAddress)
+		}
+		if mac, ok := normalizeMAC(hook.Data.MACAddress); ok {
+			name := hook.Data.Device.Name
+			if action := i.upsert(netboxSource, Device{Name: name, MACAddress: mac}); action != "" {
+				result.Changes = append(result.Changes, Change{Action: action, MACAddress: mac, Name: name})
+			}
+		}
+	case "deleted":
+		remove(hook.Data.MACAddress)
+	default:
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid event: %s", hook.Event)}
+	}
+	if !result.DryRun && len(result.Changes) > 0 {
+		if err := s.writeCache(i, actor{name: netboxSource, client: clientAddr(r)}); err != nil {
+			return nil, storeFailure(err)
+		}
+	}
+	return &result, nil
+}
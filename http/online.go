@@ -0,0 +1,129 @@
+package http
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/wait"
+)
+
+// onlineTimeout is how long a device is probed before it is considered offline.
+const onlineTimeout = time.Second
+
+// defaultStatusInterval is the interval at which device statuses are refreshed, unless MonitorInterval is set.
+const defaultStatusInterval = 30 * time.Second
+
+// Device statuses.
+const (
+	statusOnline  = "online"
+	statusOffline = "offline"
+	statusWaking  = "waking"
+	statusUnknown = "unknown"
+	// statusConflict is the status of a device whose IP address responds with another MAC address.
+	statusConflict = "conflict"
+)
+
+// skipIfOnline reports whether wakes of devices that are already online are skipped, given the override of a request
+// or schedule.
+func (s *Server) skipIfOnline(override *bool) bool {
+	if override != nil {
+		return *override
+	}
+	return s.SkipIfOnline
+}
+
+// probes returns the probes determining whether device is online at ipAddress. Devices are probed on their configured
+// ports, or the default ports, and pinged if Ping is set.
+func (s *Server) probes(device Device, ipAddress string) []wait.Probe {
+	ports := device.ProbePorts
+	if len(ports) == 0 {
+		ports = wait.DefaultPorts
+	}
+	probes := wait.TCPProbes(ipAddress, ports)
+	if s.Ping {
+		probes = append(probes, wait.ICMPProbe(ipAddress))
+	}
+	return probes
+}
+
+// online reports whether device responds to probes at ipAddress. Devices without a known IP address are never
+// considered online.
+func (s *Server) online(ctx context.Context, device Device, ipAddress string) bool {
+	if ipAddress == "" {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, onlineTimeout)
+	defer cancel()
+	return s.waitFunc(ctx, s.probes(device, ipAddress)).Status == wait.StatusOnline
+}
+
+// trackWaking records devices that have been woken, until they come online or defaultWaitTimeout passes.
+func (s *Server) trackWaking(e event.Event) {
+	s.wakingMu.Lock()
+	defer s.wakingMu.Unlock()
+	switch e.Type {
+	case event.Wake:
+		if s.waking == nil {
+			s.waking = make(map[string]time.Time)
+		}
+		s.waking[e.MACAddress] = time.Now()
+	case event.Online, event.Offline:
+		delete(s.waking, e.MACAddress)
+	}
+}
+
+// status returns the status of device. A device which is not responding is waking if it was recently woken, and its
+// status is unknown if it has no IP address.
+func (s *Server) status(ctx context.Context, device Device, fresh bool) string {
+	if s.probe(ctx, device, fresh).online {
+		if s.conflicts(device) {
+			return statusConflict
+		}
+		return statusOnline
+	}
+	s.wakingMu.Lock()
+	woken, ok := s.waking[device.MACAddress]
+	if ok && time.Since(woken) > defaultWaitTimeout {
+		delete(s.waking, device.MACAddress)
+		ok = false
+	}
+	s.wakingMu.Unlock()
+	if ok {
+		return statusWaking
+	}
+	if device.address() == "" {
+		return statusUnknown
+	}
+	return statusOffline
+}
+
+// conflicts reports whether the IP address of device is known to belong to a different MAC address, such as after IP
+// reuse or DHCP churn. Devices whose neighbour entry is missing are not in conflict.
+func (s *Server) conflicts(device Device) bool {
+	if !s.VerifyMAC || s.neighFunc == nil {
+		return false
+	}
+	ip := net.ParseIP(device.address())
+	if ip == nil {
+		return false
+	}
+	t, err := s.neighFunc()
+	if err != nil {
+		log.Printf("could not read neighbours: %s", err)
+		return false
+	}
+	hw, ok := t.Lookup(ip)
+	if !ok {
+		return false
+	}
+	mac, _ := normalizeMAC(hw.String())
+	for _, m := range device.macAddresses() {
+		if m == mac {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mpolden/wakeup/macaddr"
+)
+
+// maxRegistrySize is the maximum size of a registry uploaded to /api/v1/admin/oui.
+const maxRegistrySize = 32 << 20
+
+// OUIRegistry describes the registry of MAC address prefixes the vendors of devices and discovered hosts are found in.
+type OUIRegistry struct {
+	Prefixes int `json:"prefixes"`
+	// Embedded is whether the registry is the one embedded at build time.
+	Embedded bool `json:"embedded"`
+	// Updated is when the registry was refreshed, unless it is embedded.
+	Updated string `json:"updated,omitempty"`
+}
+
+func newOUIRegistry(r *macaddr.Registry) *OUIRegistry {
+	res := OUIRegistry{Prefixes: r.Len(), Embedded: r.Updated().IsZero()}
+	if !res.Embedded {
+		res.Updated = r.Updated().UTC().Format(time.RFC3339)
+	}
+	return &res
+}
+
+// fetchRegistry downloads the registries of the IEEE registration authority, and returns the prefixes they assign.
+func (s *Server) fetchRegistry(ctx context.Context) (map[string]string, error) {
+	urls := s.registryURLs
+	if urls == nil {
+		urls = macaddr.RegistryURLs
+	}
+	client := http.Client{Timeout: time.Minute}
+	prefixes := make(map[string]string)
+	for _, url := range urls {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", "wakeup")
+		res, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		v, err := macaddr.ParseRegistry(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: got status %d", url, res.StatusCode)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", url, err)
+		}
+		for prefix, name := range v {
+			prefixes[prefix] = name
+		}
+	}
+	return prefixes, nil
+}
+
+// ouiHandler handles /api/v1/admin/oui. GET describes the registry of MAC address prefixes, POST refreshes it,
+// either from the registry in CSV format in the request body, or by downloading the registries of the IEEE
+// registration authority if the body is empty, and DELETE reverts to the registry embedded at build time. A refreshed
+// registry is stored in OUIFile, if set, so that it is loaded again at startup.
+func (s *Server) ouiHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	if err := s.authorizeAdmin(r); err != nil {
+		return nil, err
+	}
+	registry := macaddr.DefaultRegistry
+	switch r.Method {
+	case http.MethodGet:
+		return newOUIRegistry(registry), nil
+	case http.MethodPost:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRegistrySize))
+		if err != nil {
+			return nil, &Error{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("Registry exceeds %d bytes", maxRegistrySize)}
+		}
+		var prefixes map[string]string
+		if len(bytes.TrimSpace(body)) == 0 {
+			prefixes, err = s.fetchRegistry(r.Context())
+			if err != nil {
+				return nil, &Error{err: err, Status: http.StatusBadGateway, Message: "Could not download registry", Cause: err.Error()}
+			}
+		} else {
+			prefixes, err = macaddr.ParseRegistry(bytes.NewReader(body))
+			if err != nil {
+				return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed registry", Cause: err.Error()}
+			}
+		}
+		if s.OUIFile != "" {
+			var buf bytes.Buffer
+			if err := macaddr.WriteRegistry(&buf, prefixes); err != nil {
+				return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not write registry"}
+			}
+			if err := writeAtomic(s.OUIFile, buf.Bytes()); err != nil {
+				return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not write registry"}
+			}
+		}
+		registry.Replace(prefixes, s.clock().Now())
+		return newOUIRegistry(registry), nil
+	case http.MethodDelete:
+		if s.OUIFile != "" {
+			if err := os.Remove(s.OUIFile); err != nil && !os.IsNotExist(err) {
+				return nil, &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not remove registry"}
+			}
+		}
+		registry.Reset()
+		return newOUIRegistry(registry), nil
+	}
+	return nil, &Error{
+		Status:  http.StatusMethodNotAllowed,
+		Message: fmt.Sprintf("Invalid method %s, must be %s, %s or %s", r.Method, http.MethodGet, http.MethodPost, http.MethodDelete),
+	}
+}
@@ -0,0 +1,165 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/mpolden/wakeup/history"
+	"github.com/mpolden/wakeup/power"
+)
+
+// Power states of devices.
+const (
+	powerOn      = "on"
+	powerOff     = "off"
+	powerUnknown = "unknown"
+)
+
+// PowerState is the power state of a device, presenting the device as a switch.
+type PowerState struct {
+	ID         string `json:"id"`
+	MACAddress string `json:"macAddress"`
+	// State is on if the device is online, off if it is offline or waking, and unknown if it cannot be probed.
+	State string `json:"state"`
+	// Status is the status of the device, as reported by /api/v1/devices/{id}/status.
+	Status string `json:"status"`
+	// PowerOff is whether the device can be powered off.
+	PowerOff bool `json:"powerOff"`
+}
+
+type powerRequest struct {
+	State string `json:"state"`
+}
+
+func powerState(status string) string {
+	switch status {
+	case statusOnline, statusConflict:
+		return powerOn
+	case statusOffline, statusWaking:
+		return powerOff
+	}
+	return powerUnknown
+}
+
+func (s *Server) power() *power.Controller {
+	if s.Power == nil {
+		return power.NewController()
+	}
+	return s.Power
+}
+
+// validateShutdown returns an error if name is not the name of a configured action.
+func (s *Server) validateShutdown(name string) *Error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := s.power().Action(power.Preset(name)); !ok {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid shutdown: unknown action %s", name)}
+	}
+	return nil
+}
+
+// powerOff powers off device on behalf of a, recording the result in the history.
+func (s *Server) powerOff(ctx context.Context, a actor, device Device) error {
+	action, ok := s.power().Action(device.Shutdown)
+	if !ok {
+		return fmt.Errorf("device %s cannot be powered off", device.MACAddress)
+	}
+	t := power.Target{Name: device.Name, MACAddress: device.MACAddress, IPAddress: device.address(), Platform: device.Platform}
+	err := s.power().Off(ctx, action, t)
+	if s.History != nil {
+		entry := history.Entry{Action: history.DevicePoweredOff, Actor: a.name, Client: a.client, Device: device.ID, MACAddress: device.MACAddress, Result: history.ResultOK}
+		if err != nil {
+			entry.Result, entry.Message = history.ResultFailed, err.Error()
+		}
+		if err := s.History.Append(entry); err != nil {
+			log.Printf("could not record history: %s", err)
+		}
+	}
+	return err
+}
+
+// ShutdownMQTT powers off the device identified by ref, i.e. its ID, MAC address or name, on behalf of a message
+// turning off its switch. As through the API, this requires the user named mqtt to manage the device.
+func (s *Server) ShutdownMQTT(ref string) error {
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	device, err := i.resolve(ref)
+	if err != nil {
+		return err
+	}
+	if granted := access(s.remoteUser(mqttSource), device); granted == "" {
+		return fmt.Errorf("device not found: %s", ref)
+	} else if !allows(granted, AccessManage) {
+		return fmt.Errorf("%w: %s does not manage %s", errForbidden, mqttSource, ref)
+	}
+	return s.powerOff(context.Background(), actor{name: mqttSource}, device)
+}
+
+// powerHandler handles /api/v1/devices/{id}/power, which presents the device as a switch. Switching it on wakes the
+// device, while switching it off runs its shutdown action, which requires managing the device.
+func (s *Server) powerHandler(w http.ResponseWriter, r *http.Request, id string) (interface{}, *Error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s or %s", r.Method, http.MethodGet, http.MethodPut),
+		}
+	}
+	ref, rerr := deviceRef(id)
+	if rerr != nil {
+		return nil, rerr
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	u := userFrom(r.Context())
+	device, ok := i.lookup(ref)
+	if !ok || access(u, device) == "" {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", ref)}
+	}
+	if r.Method == http.MethodGet {
+		fresh, ferr := parseFresh(r)
+		if ferr != nil {
+			return nil, ferr
+		}
+		status := s.status(r.Context(), device, fresh)
+		_, off := s.power().Action(device.Shutdown)
+		return &PowerState{ID: device.ID, MACAddress: device.MACAddress, State: powerState(status), Status: status, PowerOff: off}, nil
+	}
+	var body powerRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	switch body.State {
+	case powerOn:
+		return s.wake(w, r, wakeRequest{Device: Device{MACAddress: device.MACAddress, Name: device.Name}, known: true}, false)
+	case powerOff:
+		if !allows(access(u, device), AccessManage) {
+			return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+		}
+		if _, ok := s.power().Action(device.Shutdown); !ok {
+			return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("Device %s has no shutdown action", device.ID)}
+		}
+		if err := s.powerOff(r.Context(), requestActor(r), device); err != nil {
+			return nil, &Error{
+				err:     err,
+				Status:  http.StatusBadGateway,
+				Message: fmt.Sprintf("Failed to power off device with address %s", device.MACAddress),
+				Cause:   err.Error(),
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil, nil
+	}
+	return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for state: %s", body.State)}
+}
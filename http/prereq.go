@@ -0,0 +1,48 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/mpolden/wakeup/prereq"
+)
+
+func validatePrerequisites(checks []prereq.Check) *Error {
+	for _, c := range checks {
+		if err := c.Validate(); err != nil {
+			return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid prerequisite: %s", err)}
+		}
+	}
+	return nil
+}
+
+// checkPrerequisites evaluates checks, and returns the failed checks that refuse and warn about a wake respectively.
+// The value and error of a failed check is only logged, as its target may not be visible to the client.
+func (s *Server) checkPrerequisites(ctx context.Context, checks []prereq.Check) ([]prereq.Result, []prereq.Result) {
+	if len(checks) == 0 {
+		return nil, nil
+	}
+	results := s.checkFunc(ctx, checks)
+	for i, r := range results {
+		if !r.OK {
+			log.Printf("Prerequisite %s failed: %s", r.Name, r.Error)
+		}
+		results[i].Value, results[i].Error = "", ""
+	}
+	return prereq.Failed(results, prereq.ModeRefuse), prereq.Failed(results, prereq.ModeWarn)
+}
+
+func warning(r prereq.Result) string {
+	return fmt.Sprintf("Prerequisite %s failed", r.Name)
+}
+
+func names(results []prereq.Result) string {
+	names := make([]string, 0, len(results))
+	for _, r := range results {
+		names = append(names, r.Name)
+	}
+	return strings.Join(names, ", ")
+}
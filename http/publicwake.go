@@ -0,0 +1,270 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mpolden/wakeup/budget"
+	"github.com/mpolden/wakeup/totp"
+)
+
+const (
+	// maxCodeFailures is the number of invalid codes accepted for a device within codeFailureWindow, after which
+	// public wakes of the device are refused until the window has passed.
+	maxCodeFailures   = 5
+	codeFailureWindow = 5 * time.Minute
+)
+
+// PublicWakeResource is the TOTP secret protecting the public wake page of a device.
+type PublicWakeResource struct {
+	Enabled bool `json:"enabled"`
+	// Secret is the base32-encoded secret, and URI its otpauth URI, to add to an authenticator app.
+	Secret string `json:"secret,omitempty"`
+	URI    string `json:"uri,omitempty"`
+	// URL is the path of the public wake page.
+	URL string `json:"url,omitempty"`
+}
+
+type codeRequest struct {
+	Code string `json:"code"`
+}
+
+var publicWakePage = template.Must(template.New("wake").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Wake {{.Name}}</title>
+</head>
+<body>
+<h1>Wake {{.Name}}</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+<form method="post">
+<input name="code" inputmode="numeric" autocomplete="one-time-code" pattern="[0-9]*" placeholder="Code" autofocus required>
+<button type="submit">Wake</button>
+</form>
+</body>
+</html>
+`))
+
+// totpSecret returns the TOTP secret of device. Secrets are derived from TOTPKey, so that they are never stored, and
+// are rotated by incrementing PublicWake.
+func (s *Server) totpSecret(device Device) []byte {
+	mac := hmac.New(sha256.New, []byte(s.TOTPKey))
+	mac.Write([]byte(device.ID + "/" + strconv.Itoa(device.PublicWake)))
+	return mac.Sum(nil)[:20]
+}
+
+// displayName returns the name of device, or its hostname or MAC address if it has no name.
+func displayName(device Device) string {
+	if device.Name != "" {
+		return device.Name
+	}
+	if device.Hostname != "" {
+		return device.Hostname
+	}
+	return device.MACAddress
+}
+
+func (s *Server) newPublicWakeResource(device Device) *PublicWakeResource {
+	if device.PublicWake == 0 {
+		return &PublicWakeResource{}
+	}
+	secret := s.totpSecret(device)
+	account := displayName(device)
+	return &PublicWakeResource{
+		Enabled: true,
+		Secret:  totp.Encode(secret),
+		URI:     totp.URI(secret, "wakeup", account),
+		URL:     "/wake/" + device.ID,
+	}
+}
+
+func (s *Server) publicWakeHandler(w http.ResponseWriter, r *http.Request, ref string) (interface{}, *Error) {
+	if s.TOTPKey == "" {
+		return notFoundHandler(w, r)
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s, %s or %s", r.Method, http.MethodGet, http.MethodPut, http.MethodDelete),
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	device, ok := i.lookup(ref)
+	granted := access(userFrom(r.Context()), device)
+	if !ok || granted == "" {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", ref)}
+	}
+	// Anyone knowing the secret can wake the device, so only those managing it can see the secret
+	if granted != AccessManage {
+		return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+	}
+	switch r.Method {
+	case http.MethodPut:
+		// Each PUT rotates the secret, invalidating the previous one
+		device.PublicWake++
+	case http.MethodDelete:
+		if device.PublicWake == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return nil, nil
+		}
+		device.PublicWake = 0
+	default:
+		return s.newPublicWakeResource(device), nil
+	}
+	i.update(device)
+	if err := s.writeCache(i, requestActor(r)); err != nil {
+		return nil, storeFailure(err)
+	}
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return nil, nil
+	}
+	return s.newPublicWakeResource(device), nil
+}
+
+// acceptCode reports whether code is a valid TOTP code for device. Each code is accepted once, and devices are locked
+// after too many invalid codes.
+func (s *Server) acceptCode(device Device, code string) *Error {
+	now := time.Now()
+	s.codeMu.Lock()
+	defer s.codeMu.Unlock()
+	if s.usedCodes == nil {
+		s.usedCodes = make(map[string]int64)
+		s.codeFailures = make(map[string][]time.Time)
+	}
+	var failures []time.Time
+	for _, t := range s.codeFailures[device.ID] {
+		if now.Sub(t) < codeFailureWindow {
+			failures = append(failures, t)
+		}
+	}
+	s.codeFailures[device.ID] = failures
+	if len(failures) >= maxCodeFailures {
+		return &Error{Status: http.StatusTooManyRequests, Message: "Too many invalid codes, try again later"}
+	}
+	step, ok := totp.Verify(s.totpSecret(device), strings.TrimSpace(code), now)
+	if !ok || step <= s.usedCodes[device.ID] {
+		s.codeFailures[device.ID] = append(failures, now)
+		return &Error{Status: http.StatusUnauthorized, Message: "Invalid code"}
+	}
+	s.usedCodes[device.ID] = step
+	return nil
+}
+
+// publicDevice returns the device having ID id, if its public wake page is enabled.
+func (s *Server) publicDevice(id string) (Device, *Error) {
+	notFound := &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", id)}
+	if s.TOTPKey == "" || !isUUID(id) {
+		return Device{}, notFound
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return Device{}, storeFailure(err)
+	}
+	device, ok := i.findID(id)
+	if !ok || device.PublicWake == 0 {
+		return Device{}, notFound
+	}
+	return device, nil
+}
+
+// wakePublic wakes the device having ID id on behalf of a, if code is valid.
+func (s *Server) wakePublic(a actor, id, code string) (Device, *Error) {
+	device, e := s.publicDevice(id)
+	if e != nil {
+		return device, e
+	}
+	if e := s.acceptCode(device, code); e != nil {
+		return device, e
+	}
+	if err := s.wakeAutomated(context.Background(), a, device, budget.PriorityInteractive); err != nil {
+		if errors.Is(err, budget.ErrExceeded) {
+			return device, &Error{Status: http.StatusTooManyRequests, Message: "Send budget exceeded"}
+		}
+		return device, &Error{err: err, Status: wakeStatus(err), Message: fmt.Sprintf("Failed to wake %s", displayName(device))}
+	}
+	return device, nil
+}
+
+// publicAPIHandler handles POST /api/v1/public/devices/{id}/wake, which wakes a device given a valid TOTP code
+// without requiring authentication.
+func (s *Server) publicAPIHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/public/devices/"), "/")
+	if len(parts) != 2 || parts[1] != "wake" {
+		return notFoundHandler(w, r)
+	}
+	if r.Method != http.MethodPost {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+		}
+	}
+	var req codeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	if _, e := s.wakePublic(requestActor(r), parts[0], req.Code); e != nil {
+		return nil, e
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil, nil
+}
+
+// publicPageHandler serves the public wake page of a device at /wake/{id}.
+func (s *Server) publicPageHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/wake/")
+	var (
+		device  Device
+		e       *Error
+		message string
+	)
+	switch r.Method {
+	case http.MethodGet:
+		device, e = s.publicDevice(id)
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<10)
+		device, e = s.wakePublic(requestActor(r), id, r.PostFormValue("code"))
+		if e == nil {
+			message = fmt.Sprintf("Woke %s.", displayName(device))
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := http.StatusOK
+	if e != nil {
+		if e.err != nil {
+			log.Print(e.err)
+		}
+		if e.Status == http.StatusNotFound || e.Status == http.StatusServiceUnavailable {
+			http.Error(w, e.Message, e.Status)
+			return
+		}
+		status, message = e.Status, e.Message+"."
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	publicWakePage.Execute(w, struct{ Name, Message string }{displayName(device), message})
+}
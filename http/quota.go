@@ -0,0 +1,69 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// subject returns the subject whose quota a request counts against, which is the authenticated user or the client
+// address.
+func subject(r *http.Request) string {
+	if u := userFrom(r.Context()); u != nil {
+		return u.Name
+	}
+	return clientAddr(r)
+}
+
+// clientAddr returns the address of the client making request r.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkQuota records a wake against the quota of the subject making request r.
+func (s *Server) checkQuota(w http.ResponseWriter, r *http.Request) *Error {
+	if s.Quotas == nil {
+		return nil
+	}
+	ok, retry := s.Quotas.Allow(subject(r))
+	if ok {
+		return nil
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	return &Error{Status: http.StatusTooManyRequests, Message: fmt.Sprintf("Quota exceeded for %s", subject(r))}
+}
+
+func (s *Server) quotaHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if s.Quotas == nil {
+		return notFoundHandler(w, r)
+	}
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	return s.Quotas.Usage(subject(r)), nil
+}
+
+func (s *Server) quotasHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if err := s.authorizeAdmin(r); err != nil {
+		return nil, err
+	}
+	if s.Quotas == nil {
+		return notFoundHandler(w, r)
+	}
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	return s.Quotas.All(), nil
+}
@@ -0,0 +1,133 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mpolden/wakeup/quota"
+)
+
+// rateLimit is a limit reported in rate limit headers.
+type rateLimit struct {
+	limit     int
+	remaining int
+	reset     time.Duration
+}
+
+func seconds(d time.Duration) string { return strconv.Itoa(int(math.Ceil(d.Seconds()))) }
+
+// rateLimitWriter sets rate limit headers before the response is written, so that the headers include the wakes made
+// by the request.
+type rateLimitWriter struct {
+	http.ResponseWriter
+	set     func(http.Header)
+	written bool
+}
+
+func (w *rateLimitWriter) WriteHeader(status int) {
+	if !w.written {
+		w.written = true
+		w.set(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *rateLimitWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *rateLimitWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// rateLimitHeaders sets the rate limit headers of request r. X-RateLimit-* headers report the most restrictive of the
+// quota of the client, the request limit of its address and the send budget, while X-Quota-* headers report each window
+// of the quota.
+func (s *Server) rateLimitHeaders(h http.Header, r *http.Request) {
+	var limits []rateLimit
+	if s.Quotas != nil {
+		hour, day := s.Quotas.Windows(subject(r))
+		for _, q := range []struct {
+			name string
+			w    quota.Window
+		}{{"Hour", hour}, {"Day", day}} {
+			if q.w.Limit == 0 {
+				continue
+			}
+			h.Set("X-Quota-Limit-"+q.name, strconv.Itoa(q.w.Limit))
+			h.Set("X-Quota-Remaining-"+q.name, strconv.Itoa(q.w.Remaining))
+			h.Set("X-Quota-Reset-"+q.name, seconds(q.w.Reset))
+			limits = append(limits, rateLimit{q.w.Limit, q.w.Remaining, q.w.Reset})
+		}
+	}
+	if s.Limiter != nil {
+		limit, remaining, reset := s.Limiter.Remaining(clientAddr(r))
+		limits = append(limits, rateLimit{limit, remaining, reset})
+	}
+	if s.Budget != nil {
+		limit, remaining, reset := s.Budget.Remaining()
+		limits = append(limits, rateLimit{limit, remaining, reset})
+	}
+	if len(limits) == 0 {
+		return
+	}
+	min := limits[0]
+	for _, l := range limits[1:] {
+		if l.remaining < min.remaining || (l.remaining == min.remaining && l.reset > min.reset) {
+			min = l
+		}
+	}
+	h.Set("X-RateLimit-Limit", strconv.Itoa(min.limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(min.remaining))
+	h.Set("X-RateLimit-Reset", seconds(min.reset))
+}
+
+// rateLimitFilter adds rate limit headers to all API responses, allowing clients to throttle themselves before their
+// wakes are rejected.
+func (s *Server) rateLimitFilter(next http.Handler) http.Handler {
+	if s.Quotas == nil && s.Budget == nil && s.Limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rw := &rateLimitWriter{ResponseWriter: w, set: func(h http.Header) { s.rateLimitHeaders(h, r) }}
+		next.ServeHTTP(rw, r)
+		if !rw.written {
+			rw.set(w.Header())
+		}
+	})
+}
+
+// clientLimitFilter rejects requests to the API and public wake pages made by clients exceeding the rate of the
+// limiter, before they are authenticated.
+func (s *Server) clientLimitFilter(next http.Handler) http.Handler {
+	if s.Limiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") && !strings.HasPrefix(r.URL.Path, "/wake/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, retry := s.Limiter.Allow(clientAddr(r)); !ok {
+			w.Header().Set("Retry-After", seconds(retry))
+			w.Header().Set("Content-Type", "application/json")
+			e := &Error{Status: http.StatusTooManyRequests, Message: fmt.Sprintf("Rate limit exceeded for %s", clientAddr(r))}
+			appHandler(func(http.ResponseWriter, *http.Request) (interface{}, *Error) { return nil, e }).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
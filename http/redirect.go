@@ -0,0 +1,43 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// redirectHandler redirects requests to HTTPS, on the port of the first listen address unless it is the default port.
+func (s *Server) redirectHandler() http.Handler {
+	port := ""
+	if len(s.listeners) > 0 {
+		if _, p, err := net.SplitHostPort(s.listeners[0].Addr().String()); err == nil && p != "443" {
+			port = p
+		}
+	}
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" {
+			http.Error(w, "Missing host", http.StatusBadRequest)
+			return
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		// Methods other than GET and HEAD are kept by permanent redirects
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+	if s.ACMEHandler != nil {
+		h = s.ACMEHandler(h)
+	}
+	return h
+}
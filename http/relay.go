@@ -0,0 +1,64 @@
+package http
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/mpolden/wakeup/budget"
+	"github.com/mpolden/wakeup/wol"
+)
+
+// relaySource is the actor of magic packets received by the relay.
+const relaySource = "relay"
+
+// stored reports whether hwAddr is the MAC address, or one of the MAC addresses, of a stored device. Unreadable
+// devices are considered unknown.
+func (s *Server) stored(hwAddr net.HardwareAddr) bool {
+	mac, ok := normalizeMAC(hwAddr.String())
+	if !ok {
+		return false
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		log.Print(err)
+		return false
+	}
+	_, ok = i.findMAC(mac)
+	return ok
+}
+
+// RunRelay forwards the magic packets received by Relay onto the network of the interface having address src, e.g. to
+// bridge Wake-on-LAN across Docker networks or VLANs. Relayed packets count against the send budget, and if knownOnly
+// is true, only magic packets for stored devices are relayed, while packets for unknown devices publish an unknown event.
+// RunRelay returns when receiving fails.
+func (s *Server) RunRelay(src net.IP, knownOnly bool) error {
+	b := s.Relay
+	b.Budget = s.Budget
+	if knownOnly {
+		b.Filter = s.stored
+	}
+	for {
+		mp, err := b.Forward(src)
+		switch {
+		case errors.Is(err, wol.ErrInvalidPacket):
+			log.Print("Dropped packet: ", err)
+		case err == wol.ErrFiltered:
+			log.Printf("Dropped magic packet for unknown device %s", strings.ToUpper(mp.HardwareAddr().String()))
+			s.publishUnknown(actor{name: relaySource}, strings.ToUpper(mp.HardwareAddr().String()))
+		case err == wol.ErrHopLimit:
+			log.Printf("Dropped magic packet for %s: %s", strings.ToUpper(mp.HardwareAddr().String()), err)
+		case err == wol.ErrDuplicate:
+			// Duplicates are expected from bursts, so they are only counted
+		case err == budget.ErrExceeded:
+			log.Print("Dropped magic packet: ", err)
+		case err != nil:
+			return err
+		case mp != nil:
+			log.Printf("Relayed magic packet for %s to %s", strings.ToUpper(mp.HardwareAddr().String()), src)
+		}
+	}
+}
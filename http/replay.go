@@ -0,0 +1,48 @@
+package http
+
+import (
+	"log"
+
+	"github.com/mpolden/wakeup/trace"
+)
+
+// record writes r to the trace, if any.
+func (s *Server) record(r trace.Record) {
+	if s.Trace == nil {
+		return
+	}
+	if err := s.Trace.Write(r); err != nil {
+		log.Printf("could not record trace: %s", err)
+	}
+}
+
+// Replay feeds records through the server as if they happened at their recorded times, waiting between them as given
+// by speed, like trace.Player. Probes are observed as if the monitor made them, publishing online and offline events
+// when devices change between the two, and events are published as they were recorded. Replayed records are not
+// recorded again. Subscribers receive every event only if they keep up, so traces played without delay should be
+// received with buffers holding all of their events.
+func (s *Server) Replay(records []trace.Record, speed float64) error {
+	p := trace.Player{Speed: speed}
+	return p.Play(records, func(r trace.Record) error {
+		if r.Event != nil {
+			e := *r.Event
+			if e.Time.IsZero() {
+				e.Time = r.Time
+			}
+			s.broadcast(e)
+			return nil
+		}
+		device := Device{MACAddress: r.Probe.MACAddress}
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		if d, ok := i.find(device.MACAddress); ok {
+			device = d
+		}
+		s.observeAt(device, r.Probe.IPAddress, r.Probe.Online, r.Time)
+		return nil
+	})
+}
@@ -0,0 +1,126 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mpolden/wakeup/logging"
+)
+
+// requestIDHeader is the header holding the ID of a request, which is taken from the request if valid, and set in the
+// response for correlation.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestID is the maximum length of a request ID given by a client.
+const maxRequestID = 128
+
+// requestLog holds the fields added to the log record of a request by its handlers.
+type requestLog struct {
+	mu     sync.Mutex
+	fields []interface{}
+}
+
+// annotate adds the fields given as alternating keys and values in kv to the log record of the request of ctx, and
+// reports whether the request is logged.
+func annotate(ctx context.Context, kv ...interface{}) bool {
+	l, ok := ctx.Value(requestLogKey).(*requestLog)
+	if !ok {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fields = append(l.fields, kv...)
+	return true
+}
+
+// requestID returns the ID of the request of ctx.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// requestLogFilter assigns an ID to each request, and logs the request once it is served if a logger is configured.
+// Failed requests are logged at level warn, or error if the server failed.
+func (s *Server) requestLogFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		if s.Logger == nil {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		l := &requestLog{}
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(ctx, requestLogKey, l)))
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		level, outcome := logging.Info, "ok"
+		if sw.status >= 500 {
+			level, outcome = logging.Error, "error"
+		} else if sw.status >= 400 {
+			level, outcome = logging.Warn, "rejected"
+		}
+		kv := []interface{}{"request_id", id, "method", r.Method, "path", r.URL.Path, "remote_ip", clientAddr(r),
+			"status", sw.status, "outcome", outcome, "duration", time.Since(start)}
+		l.mu.Lock()
+		kv = append(kv, l.fields...)
+		l.mu.Unlock()
+		s.Logger.Log(level, "request", kv...)
+	})
+}
@@ -0,0 +1,193 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mpolden/wakeup/auth"
+	"github.com/mpolden/wakeup/budget"
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/schedule"
+	"github.com/mpolden/wakeup/script"
+	"github.com/mpolden/wakeup/wol"
+)
+
+// maxAutomatedWait is the maximum time an automated wake waits for the send budget.
+const maxAutomatedWait = 10 * time.Minute
+
+// Errors of automated wakes refused before magic packets are sent.
+var (
+	errPrerequisites = errors.New("prerequisites failed")
+	errPreWake       = errors.New("pre-wake script failed")
+	errForbidden     = errors.New("forbidden")
+)
+
+// Actors of automated wakes, recorded in the history.
+const (
+	scheduleSource = "schedule"
+	emailSource    = "email"
+)
+
+// allow reports whether n packets having priority p can be sent. Interactive packets wait up to BudgetWait for the send
+// budget, while automated packets wait up to maxAutomatedWait.
+func (s *Server) allow(ctx context.Context, p budget.Priority, n int) bool {
+	if s.Budget == nil {
+		return true
+	}
+	timeout := maxAutomatedWait
+	if p == budget.PriorityInteractive {
+		if s.BudgetWait <= 0 {
+			return s.Budget.AllowN(false, n)
+		}
+		timeout = s.BudgetWait
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return s.Budget.WaitN(ctx, p, n) == nil
+}
+
+// wakeAutomated wakes device on behalf of a, such as a schedule, using priority p. Automated wakes are paused while the
+// send budget is tripped.
+func (s *Server) wakeAutomated(ctx context.Context, a actor, device Device, p budget.Priority) error {
+	// Group addresses are refused as invalid, as they belong to no device
+	if err := validateTarget(device.MACAddress); err != nil {
+		return fmt.Errorf("%w: %s", wol.ErrInvalidMAC, device.MACAddress)
+	}
+	src, err := s.sourceIP(device.address())
+	if err != nil {
+		return err
+	}
+	if s.onBattery(device) {
+		if s.UPSPolicy == UPSDefer {
+			s.deferWake(device, src)
+			return nil
+		}
+		return errOnBattery
+	}
+	if refused, _ := s.checkPrerequisites(ctx, device.Prerequisites); len(refused) > 0 {
+		return fmt.Errorf("%w: %s", errPrerequisites, names(refused))
+	}
+	if err := s.runScript(ctx, script.PreWake, device); err != nil {
+		return fmt.Errorf("%w: %s", errPreWake, err)
+	}
+	if err := s.cooldown(device.MACAddress); err != nil {
+		return err
+	}
+	if !s.allow(ctx, p, s.burst().packetsTo(device)) {
+		return budget.ErrExceeded
+	}
+	if _, err := s.wakeAll(src, device); err != nil {
+		s.publishWake(a, event.Event{Type: event.Failed, Device: device.ID, MACAddress: device.MACAddress, Name: device.Name, Error: err.Error()})
+		return err
+	}
+	s.publishWake(a, event.Event{Type: event.Wake, Device: device.ID, MACAddress: device.MACAddress, Name: device.Name})
+	return nil
+}
+
+// WakeScheduled wakes the device or group of schedule sc.
+func (s *Server) WakeScheduled(sc schedule.Schedule) error {
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	var devices []Device
+	if sc.Group != "" {
+		devices = i.group(sc.Group)
+	} else if sc.Device != "" {
+		if d, ok := i.findID(sc.Device); ok {
+			devices = []Device{d}
+		}
+	} else if mac, ok := normalizeMAC(sc.MACAddress); ok {
+		if d, ok := i.findMAC(mac); ok {
+			devices = []Device{d}
+		} else {
+			// Devices of a schedule do not have to be stored
+			devices = []Device{{MACAddress: sc.MACAddress}}
+		}
+	}
+	if len(devices) == 0 {
+		return fmt.Errorf("no devices found")
+	}
+	var errs []error
+	for j, d := range devices {
+		if j > 0 {
+			time.Sleep(s.Stagger)
+		}
+		if s.skipIfOnline(sc.SkipIfOnline) && s.online(context.Background(), d, d.address()) {
+			log.Printf("Skipping scheduled wake of device with address %s: device is online", d.MACAddress)
+			continue
+		}
+		if err := s.wakeAutomated(context.Background(), actor{name: scheduleSource}, d, budget.PriorityScheduled); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", d.MACAddress, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to wake %d of %d devices: %v", len(errs), len(devices), errs)
+	}
+	return nil
+}
+
+// remoteUser returns the user named name, who wakes devices through a remote trigger rather than the API. Devices are
+// shared with them like with users of the API, and unknown MAC addresses can only be woken if RemoteAllowUnknown is
+// set, as they are woken without being stored.
+func (s *Server) remoteUser(name string) *auth.User {
+	return &auth.User{Name: name, Roles: []string{auth.RoleUser}, AllowUnknown: s.RemoteAllowUnknown}
+}
+
+// WakeRemote wakes the device identified by ref, i.e. its ID, MAC address or name, on behalf of the user named user,
+// such as the user of an SSH key.
+func (s *Server) WakeRemote(user, ref string) error {
+	return s.wakeRef(actor{name: user}, s.remoteUser(user), ref)
+}
+
+// WakeEmail wakes the device identified by ref on behalf of a signed email, whose sender is the user named email.
+func (s *Server) WakeEmail(ref string) error {
+	return s.wakeRef(actor{name: emailSource}, s.remoteUser(emailSource), ref)
+}
+
+// wakeRef wakes the device identified by ref on behalf of a, as an automated wake, if user u may wake it.
+func (s *Server) wakeRef(a actor, u *auth.User, ref string) error {
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	device, err := i.resolve(ref)
+	if err != nil {
+		return err
+	}
+	if device.ID == "" {
+		s.publishUnknown(a, device.MACAddress)
+		if !u.AllowUnknown {
+			return fmt.Errorf("%w: %s is not allowed to wake unknown devices", errForbidden, u.Name)
+		}
+	} else if access(u, device) == "" {
+		return fmt.Errorf("device not found: %s", ref)
+	}
+	return s.wakeAutomated(context.Background(), a, device, budget.PriorityInteractive)
+}
+
+// resolve returns the device identified by ref, i.e. its ID, MAC address or name. A device holding only the MAC address
+// is returned for unknown MAC addresses, unless they cannot be woken.
+func (c *deviceCache) resolve(ref string) (Device, error) {
+	id, err := deviceRef(ref)
+	if err != nil {
+		return c.findName(ref)
+	}
+	d, ok := c.lookup(id)
+	if !ok && isUUID(id) {
+		return Device{}, fmt.Errorf("device not found: %s", ref)
+	} else if !ok {
+		if err := validateTarget(id); err != nil {
+			return Device{}, fmt.Errorf("%w: %s", wol.ErrInvalidMAC, id)
+		}
+		d = Device{MACAddress: id}
+	}
+	return d, nil
+}
@@ -0,0 +1,388 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mpolden/wakeup/auth"
+	"github.com/mpolden/wakeup/schedule"
+)
+
+const (
+	// maxScheduleName is the maximum length of the name of a schedule.
+	maxScheduleName = 64
+	// defaultSimulated and maxSimulated are the default and maximum number of firing times of a simulated schedule.
+	defaultSimulated = 10
+	maxSimulated     = 1000
+)
+
+// Schedule is a wake schedule stored with the devices, which wakes a device or all devices in a group as given by its
+// cron expression.
+type Schedule struct {
+	Name string `json:"name"`
+	// Device is the ID of the device to wake.
+	Device string `json:"device,omitempty"`
+	Group  string `json:"group,omitempty"`
+	Cron   string `json:"cron"`
+	// TimeZone is the name of the time zone the cron expression is evaluated in, defaulting to that of the server.
+	TimeZone     string `json:"timeZone,omitempty"`
+	SkipIfOnline *bool  `json:"skipIfOnline,omitempty"`
+}
+
+// Schedules lists the stored schedules.
+type Schedules struct {
+	Schedules []Schedule `json:"schedules"`
+}
+
+// scheduleRequest changes a schedule. Device is any reference to a stored device, and the schedule is due either at
+// the time of day At on Days, or as given by Cron.
+type scheduleRequest struct {
+	Device       string `json:"device"`
+	Group        string `json:"group"`
+	At           string `json:"at"`
+	Days         string `json:"days"`
+	Cron         string `json:"cron"`
+	TimeZone     string `json:"timeZone"`
+	SkipIfOnline *bool  `json:"skipIfOnline"`
+}
+
+func (a Schedule) equal(b Schedule) bool {
+	if (a.SkipIfOnline == nil) != (b.SkipIfOnline == nil) || (a.SkipIfOnline != nil && *a.SkipIfOnline != *b.SkipIfOnline) {
+		return false
+	}
+	a.SkipIfOnline, b.SkipIfOnline = nil, nil
+	return a == b
+}
+
+// sameSchedules reports whether schedules a and b are equal.
+func sameSchedules(a, b []Schedule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for j := range a {
+		if !a[j].equal(b[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// schedule returns the schedule named name.
+func (c *deviceCache) schedule(name string) (Schedule, bool) {
+	for _, sc := range c.Schedules {
+		if sc.Name == name {
+			return sc, true
+		}
+	}
+	return Schedule{}, false
+}
+
+// setSchedule adds or replaces schedule sc, and reports whether it changed.
+func (c *deviceCache) setSchedule(sc Schedule) bool {
+	for j, v := range c.Schedules {
+		if v.Name == sc.Name {
+			c.Schedules[j] = sc
+			return !v.equal(sc)
+		}
+	}
+	c.Schedules = append(c.Schedules, sc)
+	return true
+}
+
+// removeSchedule removes the schedule named name, and reports whether it existed.
+func (c *deviceCache) removeSchedule(name string) bool {
+	for j, sc := range c.Schedules {
+		if sc.Name == name {
+			c.Schedules = append(c.Schedules[:j:j], c.Schedules[j+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// toSchedule converts sc to a schedule run by the scheduler.
+func (sc Schedule) toSchedule() (schedule.Schedule, error) {
+	s := schedule.Schedule{Name: sc.Name, Device: sc.Device, Group: sc.Group, SkipIfOnline: sc.SkipIfOnline}
+	var err error
+	if s.Cron, err = schedule.ParseCron(sc.Cron); err != nil {
+		return s, err
+	}
+	if sc.TimeZone != "" {
+		if s.Location, err = time.LoadLocation(sc.TimeZone); err != nil {
+			return s, fmt.Errorf("invalid time zone: %s", sc.TimeZone)
+		}
+	}
+	return s, nil
+}
+
+// StoredSchedules returns the schedules stored with the devices, for running by the scheduler.
+func (s *Server) StoredSchedules() ([]schedule.Schedule, error) {
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	schedules := make([]schedule.Schedule, 0, len(i.Schedules))
+	for _, sc := range i.Schedules {
+		v, err := sc.toSchedule()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", sc.Name, err)
+		}
+		schedules = append(schedules, v)
+	}
+	return schedules, nil
+}
+
+// configured reports whether a schedule named name is read from the schedule config, which cannot be changed here.
+func (s *Server) configured(name string) bool {
+	if s.Scheduler == nil {
+		return false
+	}
+	for _, sc := range s.Scheduler.Schedules {
+		if sc.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func validateScheduleName(name string) *Error {
+	// The name simulate is taken by the simulation of schedules
+	if len(name) > maxScheduleName || strings.ContainsAny(name, "/ ") || strings.HasPrefix(name, "#") || name == "simulate" {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid schedule name: %s", name)}
+	}
+	return nil
+}
+
+// newSchedule returns the schedule named name changed by request body.
+func newSchedule(i *deviceCache, name string, body scheduleRequest) (Schedule, *Error) {
+	sc := Schedule{Name: name, Group: body.Group, TimeZone: body.TimeZone, SkipIfOnline: body.SkipIfOnline}
+	if (body.Device == "") == (body.Group == "") {
+		return sc, &Error{Status: http.StatusBadRequest, Message: "Schedule must have one of device or group"}
+	}
+	if body.Device != "" {
+		d, err := i.resolve(body.Device)
+		if err != nil || d.ID == "" {
+			return sc, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Device not found: %s", body.Device)}
+		}
+		sc.Device = d.ID
+	}
+	c, err := parseWhen(body.At, body.Days, body.Cron)
+	if err != nil {
+		return sc, err
+	}
+	sc.Cron = c.String()
+	if _, err := sc.toSchedule(); err != nil {
+		return sc, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid schedule: %s", err)}
+	}
+	return sc, nil
+}
+
+// parseWhen returns the cron expression of a schedule due either at the time of day at on days, or as given by cron.
+func parseWhen(at, days, cron string) (*schedule.Cron, *Error) {
+	var c *schedule.Cron
+	var err error
+	if cron != "" && (at != "" || days != "") {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Schedule must have one of cron or at"}
+	} else if cron != "" {
+		c, err = schedule.ParseCron(cron)
+	} else {
+		c, err = schedule.At(at, days)
+	}
+	if err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid schedule: %s", err)}
+	}
+	return c, nil
+}
+
+// simulateRequest is a schedule whose firing times are simulated. Count firing times after From are returned, where From
+// defaults to the current time.
+type simulateRequest struct {
+	At       string    `json:"at"`
+	Days     string    `json:"days"`
+	Cron     string    `json:"cron"`
+	TimeZone string    `json:"timeZone"`
+	Count    int       `json:"count"`
+	From     time.Time `json:"from"`
+}
+
+// Simulation holds the next firing times of a schedule.
+type Simulation struct {
+	Cron     string      `json:"cron"`
+	TimeZone string      `json:"timeZone"`
+	Times    []time.Time `json:"times"`
+}
+
+func (s *Server) clock() schedule.Clock {
+	if s.Clock == nil {
+		return schedule.SystemClock
+	}
+	return s.Clock
+}
+
+// simulateHandler handles /api/v1/schedules/simulate, which returns the next firing times of a schedule, so that it
+// can be verified before it is saved.
+func (s *Server) simulateHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	if r.Method != http.MethodPost {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+		}
+	}
+	body := simulateRequest{Count: defaultSimulated}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	if body.Count < 1 || body.Count > maxSimulated {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid value for count: %d", body.Count)}
+	}
+	c, e := parseWhen(body.At, body.Days, body.Cron)
+	if e != nil {
+		return nil, e
+	}
+	sc, err := Schedule{Cron: c.String(), TimeZone: body.TimeZone}.toSchedule()
+	if err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid schedule: %s", err)}
+	}
+	t := body.From
+	if t.IsZero() {
+		t = s.clock().Now()
+	}
+	if sc.Location == nil {
+		sc.Location = t.Location()
+	}
+	sim := &Simulation{Cron: c.String(), TimeZone: sc.Location.String(), Times: make([]time.Time, 0, body.Count)}
+	for len(sim.Times) < body.Count {
+		if t = sc.Next(t); t.IsZero() {
+			break
+		}
+		sim.Times = append(sim.Times, t)
+	}
+	return sim, nil
+}
+
+// manages reports whether u can change schedule sc. Schedules of a device can be changed by its managers, while group
+// schedules can only be changed by admins.
+func manages(u *auth.User, i *deviceCache, sc Schedule) bool {
+	if u == nil || u.HasRole(auth.RoleAdmin) {
+		return true
+	}
+	d, ok := i.findID(sc.Device)
+	return sc.Device != "" && ok && allows(access(u, d), AccessManage)
+}
+
+// visibleSchedules returns the schedules of devices u has access to, and all group schedules.
+func visibleSchedules(u *auth.User, i *deviceCache) []Schedule {
+	keep := make([]Schedule, 0, len(i.Schedules))
+	for _, sc := range i.Schedules {
+		if sc.Device != "" {
+			if d, ok := i.findID(sc.Device); !ok || access(u, d) == "" {
+				continue
+			}
+		}
+		keep = append(keep, sc)
+	}
+	return keep
+}
+
+// schedulesHandler handles /api/v1/schedules/, which lists the stored schedules, and manages the schedule named by the
+// rest of the path. Changes take effect on the next tick of the scheduler.
+func (s *Server) schedulesHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/schedules")
+	name = strings.TrimPrefix(name, "/")
+	u := userFrom(r.Context())
+	if name == "" {
+		if r.Method != http.MethodGet {
+			return nil, &Error{
+				Status:  http.StatusMethodNotAllowed,
+				Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+			}
+		}
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		return &Schedules{Schedules: visibleSchedules(u, i)}, nil
+	}
+	if err := validateScheduleName(name); err != nil {
+		return nil, err
+	}
+	notFound := &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Schedule not found: %s", name)}
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		for _, sc := range visibleSchedules(u, i) {
+			if sc.Name == name {
+				return &sc, nil
+			}
+		}
+		return nil, notFound
+	case http.MethodPut, http.MethodDelete:
+		if s.configured(name) {
+			return nil, &Error{Status: http.StatusConflict, Message: fmt.Sprintf("Schedule %s is configured by file", name)}
+		}
+		var body scheduleRequest
+		if r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+			}
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		i, err := s.readDevices()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		old, exists := i.schedule(name)
+		if exists && !manages(u, i, old) {
+			return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+		}
+		var sc Schedule
+		changed := false
+		if r.Method == http.MethodPut {
+			var err *Error
+			if sc, err = newSchedule(i, name, body); err != nil {
+				return nil, err
+			}
+			if !manages(u, i, sc) {
+				return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+			}
+			changed = i.setSchedule(sc)
+		} else if !exists {
+			return nil, notFound
+		} else {
+			changed = i.removeSchedule(name)
+		}
+		if changed {
+			if err := s.writeCache(i, requestActor(r)); err != nil {
+				return nil, storeFailure(err)
+			}
+		}
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return nil, nil
+		}
+		if !exists {
+			w.Header().Set("Location", "/api/v1/schedules/"+name)
+			w.WriteHeader(http.StatusCreated)
+		}
+		return &sc, nil
+	}
+	return nil, &Error{
+		Status:  http.StatusMethodNotAllowed,
+		Message: fmt.Sprintf("Invalid method %s, must be %s, %s or %s", r.Method, http.MethodGet, http.MethodPut, http.MethodDelete),
+	}
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/history"
+	"github.com/mpolden/wakeup/script"
+)
+
+// runScript runs the script of given kind for device, if any, and records the result in the history.
+func (s *Server) runScript(ctx context.Context, kind string, device Device) error {
+	if s.Scripts == nil {
+		return nil
+	}
+	r, ok := s.Scripts.Run(ctx, kind, script.Device{
+		ID:         device.ID,
+		Name:       device.Name,
+		MACAddress: device.MACAddress,
+		IPAddress:  device.address(),
+	})
+	if !ok {
+		return nil
+	}
+	entry := history.Entry{
+		Action:     history.ScriptRan,
+		Device:     device.ID,
+		MACAddress: device.MACAddress,
+		Result:     history.ResultOK,
+		Message:    fmt.Sprintf("%s script %s succeeded", kind, r.Path),
+		Output:     r.Output,
+	}
+	if r.Err != nil {
+		entry.Result = history.ResultFailed
+		entry.Message = fmt.Sprintf("%s script %s failed: %s", kind, r.Path, r.Err)
+		log.Print(entry.Message)
+	}
+	if s.History != nil {
+		if err := s.History.Append(entry); err != nil {
+			log.Printf("could not record history: %s", err)
+		}
+	}
+	return r.Err
+}
+
+// RunScripts runs the post-online and post-offline scripts of devices on events received from events.
+func (s *Server) RunScripts(events <-chan event.Event) {
+	for e := range events {
+		var kind string
+		switch e.Type {
+		case event.Online:
+			kind = script.PostOnline
+		case event.Offline:
+			kind = script.PostOffline
+		default:
+			continue
+		}
+		device := Device{ID: e.Device, Name: e.Name, MACAddress: e.MACAddress}
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err == nil {
+			if d, ok := i.lookup(e.MACAddress); ok {
+				device = d
+			}
+		}
+		go s.runScript(context.Background(), kind, device)
+	}
+}
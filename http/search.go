@@ -0,0 +1,111 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mpolden/wakeup/query"
+)
+
+// deviceSchema holds the fields devices are searched by. Tags are the groups of a device.
+var deviceSchema = query.Schema{
+	"id":          query.String,
+	"name":        query.String,
+	"description": query.String,
+	"macAddress":  query.Strings,
+	"ipAddress":   query.String,
+	"hostname":    query.String,
+	"group":       query.Strings,
+	"tag":         query.Strings,
+	"platform":    query.String,
+	"transport":   query.String,
+	"owner":       query.String,
+	"source":      query.String,
+	"essential":   query.Bool,
+	"watts":       query.Number,
+	"state":       query.String,
+	"lastWake":    query.Time,
+}
+
+// deviceRecord is a device matched by a search. Its state is only probed if the search refers to it.
+type deviceRecord struct {
+	ctx    context.Context
+	server *Server
+	device Device
+}
+
+func (r *deviceRecord) Field(name string) interface{} {
+	d := r.device
+	switch name {
+	case "id":
+		return d.ID
+	case "name":
+		return d.Name
+	case "description":
+		return d.Description
+	case "macAddress":
+		return d.macAddresses()
+	case "ipAddress":
+		return d.address()
+	case "hostname":
+		return d.Hostname
+	case "group", "tag":
+		return d.Groups
+	case "platform":
+		return d.Platform
+	case "transport":
+		return d.Transport
+	case "owner":
+		return d.Owner
+	case "source":
+		return d.Source
+	case "essential":
+		return d.Essential
+	case "watts":
+		return d.Watts
+	case "state":
+		return r.server.status(r.ctx, d, false)
+	case "lastWake":
+		if r.server.Health != nil {
+			if t, ok := r.server.Health.LastWake(d.MACAddress); ok {
+				return t
+			}
+		}
+		return time.Time{}
+	}
+	return nil
+}
+
+// parseSearch parses the search expression given by the q parameter of r, if any.
+func parseSearch(r *http.Request) (*query.Query, *Error) {
+	return parseQuery(r.URL.Query().Get("q"))
+}
+
+// parseQuery parses the search expression v, if any.
+func parseQuery(v string) (*query.Query, *Error) {
+	if v == "" {
+		return nil, nil
+	}
+	q, err := query.Parse(v, deviceSchema)
+	if err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid query: %s", err)}
+	}
+	return q, nil
+}
+
+// search returns the devices matching q. All devices match if q is nil.
+func (s *Server) search(ctx context.Context, q *query.Query, devices []Device) []Device {
+	if q == nil {
+		return devices
+	}
+	now := time.Now()
+	keep := make([]Device, 0, len(devices))
+	for _, d := range devices {
+		if q.Match(&deviceRecord{ctx: ctx, server: s, device: d}, now) {
+			keep = append(keep, d)
+		}
+	}
+	return keep
+}
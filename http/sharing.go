@@ -0,0 +1,155 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mpolden/wakeup/auth"
+)
+
+const (
+	// AccessWake allows listing and waking a device.
+	AccessWake = "wake"
+	// AccessManage allows everything, including changing and removing a device.
+	AccessManage = "manage"
+)
+
+// Share grants a user, or members of a group, access to a device.
+type Share struct {
+	User   string `json:"user,omitempty"`
+	Group  string `json:"group,omitempty"`
+	Access string `json:"access"`
+}
+
+// Sharing holds the owner and shares of a device.
+type Sharing struct {
+	Owner  string  `json:"owner,omitempty"`
+	Shares []Share `json:"shares,omitempty"`
+}
+
+// access returns the access u has to device. If u is nil, authentication is disabled and everyone can manage all
+// devices. Devices without an owner can be woken by all users, but only managed by admins. Users restricted to a scope
+// can only wake the devices in it.
+func access(u *auth.User, device Device) string {
+	if u != nil && u.Scope != nil {
+		for _, id := range u.Scope {
+			if id == device.ID {
+				return AccessWake
+			}
+		}
+		return ""
+	}
+	if u == nil || u.HasRole(auth.RoleAdmin) || (device.Owner != "" && device.Owner == u.Name) {
+		return AccessManage
+	}
+	if device.Owner == "" {
+		return AccessWake
+	}
+	granted := ""
+	for _, s := range device.Shares {
+		if (s.User != "" && s.User == u.Name) || (s.Group != "" && u.InGroup(s.Group)) {
+			if s.Access == AccessManage {
+				return AccessManage
+			}
+			granted = AccessWake
+		}
+	}
+	return granted
+}
+
+func allows(granted, required string) bool {
+	return granted == AccessManage || (granted == AccessWake && required == AccessWake)
+}
+
+// managesDevice returns true if u may manage the device stored, or add it if it does not exist. Users restricted to a scope
+// cannot add devices.
+func managesDevice(u *auth.User, stored Device, exists bool) bool {
+	if exists {
+		return allows(access(u, stored), AccessManage)
+	}
+	return u == nil || u.Scope == nil
+}
+
+// visible returns the devices u has access to.
+func visible(u *auth.User, devices []Device) []Device {
+	keep := make([]Device, 0, len(devices))
+	for _, d := range devices {
+		if access(u, d) != "" {
+			keep = append(keep, d)
+		}
+	}
+	return keep
+}
+
+func validateSharing(u *auth.User, sharing *Sharing) *Error {
+	for _, s := range sharing.Shares {
+		if s.Access != AccessWake && s.Access != AccessManage {
+			return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid access: %s", s.Access)}
+		}
+		if (s.User == "") == (s.Group == "") {
+			return &Error{Status: http.StatusBadRequest, Message: "Share must have either user or group"}
+		}
+	}
+	if u == nil {
+		return nil
+	}
+	if sharing.Owner == "" {
+		sharing.Owner = u.Name
+	}
+	if sharing.Owner != u.Name && !u.HasRole(auth.RoleAdmin) {
+		return &Error{Status: http.StatusForbidden, Message: "Only admins can assign devices to other users"}
+	}
+	return nil
+}
+
+func (s *Server) findDevice(macAddress string) (Device, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return Device{}, false, err
+	}
+	d, ok := i.find(macAddress)
+	return d, ok, nil
+}
+
+func (s *Server) sharingHandler(w http.ResponseWriter, r *http.Request, ref string) (interface{}, *Error) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s or %s", r.Method, http.MethodGet, http.MethodPut),
+		}
+	}
+	u := userFrom(r.Context())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	device, ok := i.lookup(ref)
+	granted := access(u, device)
+	if !ok || granted == "" {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", ref)}
+	}
+	if r.Method == http.MethodGet {
+		return &device.Sharing, nil
+	}
+	if granted != AccessManage {
+		return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+	}
+	var sharing Sharing
+	if err := json.NewDecoder(r.Body).Decode(&sharing); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	if err := validateSharing(u, &sharing); err != nil {
+		return nil, err
+	}
+	device.Sharing = sharing
+	i.update(device)
+	if err := s.writeCache(i, requestActor(r)); err != nil {
+		return nil, storeFailure(err)
+	}
+	return &device.Sharing, nil
+}
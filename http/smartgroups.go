@@ -0,0 +1,184 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mpolden/wakeup/auth"
+	"github.com/mpolden/wakeup/query"
+)
+
+// maxSmartGroupName is the maximum length of the name of a smart group.
+const maxSmartGroupName = 64
+
+// SmartGroup is a saved search, which behaves as a group whose members are the devices matching its query when the
+// group is listed or woken.
+type SmartGroup struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+// SmartGroups lists the smart groups.
+type SmartGroups struct {
+	SmartGroups []SmartGroup `json:"smartGroups"`
+}
+
+// smartGroup returns the smart group named name.
+func (c *deviceCache) smartGroup(name string) (SmartGroup, bool) {
+	for _, g := range c.SmartGroups {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return SmartGroup{}, false
+}
+
+// setSmartGroup adds or replaces smart group g, and reports whether it changed.
+func (c *deviceCache) setSmartGroup(g SmartGroup) bool {
+	for j, v := range c.SmartGroups {
+		if v.Name == g.Name {
+			c.SmartGroups[j] = g
+			return v != g
+		}
+	}
+	c.SmartGroups = append(c.SmartGroups, g)
+	return true
+}
+
+// removeSmartGroup removes the smart group named name, and reports whether it existed.
+func (c *deviceCache) removeSmartGroup(name string) bool {
+	for j, g := range c.SmartGroups {
+		if g.Name == name {
+			c.SmartGroups = append(c.SmartGroups[:j:j], c.SmartGroups[j+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// sameSmartGroups reports whether smart groups a and b are equal.
+func sameSmartGroups(a, b []SmartGroup) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for j := range a {
+		if a[j] != b[j] {
+			return false
+		}
+	}
+	return true
+}
+
+// members returns the devices in group name visible to u, and the smart group of that name, if any. A smart group
+// shadows any group of the same name, and its members are the devices matching its query.
+func (s *Server) members(ctx context.Context, u *auth.User, i *deviceCache, name string) ([]Device, *SmartGroup, error) {
+	g, ok := i.smartGroup(name)
+	if !ok {
+		return visible(u, i.group(name)), nil, nil
+	}
+	q, err := query.Parse(g.Query, deviceSchema)
+	if err != nil {
+		return nil, &g, fmt.Errorf("smart group %s: %w", name, err)
+	}
+	return s.search(ctx, q, visible(u, i.Devices)), &g, nil
+}
+
+func validateSmartGroupName(name string) *Error {
+	if len(name) > maxSmartGroupName || strings.ContainsAny(name, "/ ") {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid smart group name: %s", name)}
+	}
+	return nil
+}
+
+// smartGroupsHandler handles /api/v1/smart-groups/, which lists the smart groups, and manages the smart group named by
+// the rest of the path. Only admins can change smart groups, as they apply to all users.
+func (s *Server) smartGroupsHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/smart-groups")
+	name = strings.TrimPrefix(name, "/")
+	if name == "" {
+		if r.Method != http.MethodGet {
+			return nil, &Error{
+				Status:  http.StatusMethodNotAllowed,
+				Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+			}
+		}
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		groups := i.SmartGroups
+		if groups == nil {
+			groups = make([]SmartGroup, 0)
+		}
+		return &SmartGroups{SmartGroups: groups}, nil
+	}
+	if err := validateSmartGroupName(name); err != nil {
+		return nil, err
+	}
+	u := userFrom(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		g, ok := i.smartGroup(name)
+		if !ok {
+			return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Smart group not found: %s", name)}
+		}
+		return &g, nil
+	case http.MethodPut, http.MethodDelete:
+		if u != nil && !u.HasRole(auth.RoleAdmin) {
+			return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+		}
+		var body SmartGroup
+		if r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+			}
+			if _, err := query.Parse(body.Query, deviceSchema); err != nil {
+				return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid query: %s", err)}
+			}
+			body.Name = name
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		i, err := s.readDevices()
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+		_, exists := i.smartGroup(name)
+		changed := false
+		if r.Method == http.MethodPut {
+			changed = i.setSmartGroup(body)
+		} else {
+			changed = i.removeSmartGroup(name)
+		}
+		if changed {
+			if err := s.writeCache(i, requestActor(r)); err != nil {
+				return nil, storeFailure(err)
+			}
+		}
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return nil, nil
+		}
+		if !exists {
+			w.Header().Set("Location", "/api/v1/smart-groups/"+name)
+			w.WriteHeader(http.StatusCreated)
+		}
+		return &body, nil
+	}
+	return nil, &Error{
+		Status:  http.StatusMethodNotAllowed,
+		Message: fmt.Sprintf("Invalid method %s, must be %s, %s or %s", r.Method, http.MethodGet, http.MethodPut, http.MethodDelete),
+	}
+}
@@ -0,0 +1,123 @@
+//go:build sqlite
+// +build sqlite
+
+package http
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	// Registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSupported is true as the SQLite store is built in.
+const sqliteSupported = true
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS devices (
+  id   TEXT PRIMARY KEY,
+  mac  TEXT NOT NULL,
+  data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS meta (
+  id   INTEGER PRIMARY KEY CHECK (id = 1),
+  data TEXT NOT NULL
+);
+`
+
+// sqliteStore stores the cache in a SQLite database, holding a table of devices and a table holding the rest of the
+// cache in a single row.
+type sqliteStore struct{ db *sql.DB }
+
+// openSQLite opens the SQLite database at path, which is created if it does not exist. The returned bool is true if the
+// database holds no cache yet.
+func openSQLite(path string) (*sqliteStore, bool, error) {
+	// The write-ahead log keeps the database consistent if the process crashes while writing
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, false, err
+	}
+	// Writes are serialized by the server, and a single connection avoids locking between connections
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, false, err
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM meta").Scan(&n); err != nil {
+		db.Close()
+		return nil, false, err
+	}
+	return &sqliteStore{db: db}, n == 0, nil
+}
+
+func (s *sqliteStore) load() (*deviceCache, error) {
+	var meta storeMeta
+	var data string
+	err := s.db.QueryRow("SELECT data FROM meta WHERE id = 1").Scan(&data)
+	if err == nil {
+		if err := json.Unmarshal([]byte(data), &meta); err != nil {
+			return nil, err
+		}
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+	rows, err := s.db.Query("SELECT data FROM devices ORDER BY mac")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var devices []Device
+	for rows.Next() {
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var d Device
+		if err := json.Unmarshal([]byte(data), &d); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return meta.cache(devices), nil
+}
+
+// save writes the devices that changed from prev into next, and the rest of next, in a single transaction.
+func (s *sqliteStore) save(prev, next *deviceCache) error {
+	e, _ := prev.diff(next)
+	meta, err := json.Marshal(newStoreMeta(next))
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, d := range e.Devices {
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT OR REPLACE INTO devices (id, mac, data) VALUES (?, ?, ?)", d.ID, d.MACAddress, string(data)); err != nil {
+			return err
+		}
+	}
+	for _, id := range e.Removed {
+		if _, err := tx.Exec("DELETE FROM devices WHERE id = ?", id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO meta (id, data) VALUES (1, ?)", string(meta)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// compact does nothing, as SQLite checkpoints its write-ahead log by itself.
+func (s *sqliteStore) compact() error { return nil }
+
+func (s *sqliteStore) close() error { return s.db.Close() }
@@ -0,0 +1,14 @@
+//go:build !sqlite
+// +build !sqlite
+
+package http
+
+import "errors"
+
+// sqliteSupported is false as the SQLite store requires cgo, and is only built with the sqlite tag.
+const sqliteSupported = false
+
+// openSQLite fails, as the SQLite store is not built in.
+func openSQLite(path string) (store, bool, error) {
+	return nil, false, errors.New("sqlite store is not supported by this build, rebuild with -tags sqlite")
+}
@@ -0,0 +1,219 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// staleWarning is the warning sent when the last known devices are served because the store is unavailable.
+const staleWarning = `110 wakeup "Response is stale"`
+
+// storeRetryAfter is the time clients are asked to wait before retrying requests failing because the store is
+// unavailable.
+const storeRetryAfter = 10 * time.Second
+
+// errStoreUnavailable matches errors reading or writing the store, which fail requests with 503 Service Unavailable.
+var errStoreUnavailable = errors.New("store unavailable")
+
+// storeError is an error reading or writing the store, keeping the message of its cause.
+type storeError struct{ err error }
+
+func (e *storeError) Error() string { return e.err.Error() }
+
+func (e *storeError) Unwrap() error { return e.err }
+
+func (e *storeError) Is(target error) bool { return target == errStoreUnavailable }
+
+// unavailable returns err, if any, as an error of the store.
+func unavailable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &storeError{err}
+}
+
+// storeFailure returns the error failing a request because of err. Errors of the store are served with Retry-After, as
+// the store may recover, while other errors are errors of the server.
+func storeFailure(err error) *Error {
+	if errors.Is(err, errStoreUnavailable) {
+		return &Error{err: err, Status: http.StatusServiceUnavailable, Message: "Store unavailable"}
+	}
+	return &Error{err: err, Status: http.StatusInternalServerError, Message: "Could not unmarshal JSON"}
+}
+
+// setRetryAfter asks the client to retry the request failing with err later, if it failed because of the store or the
+// wake cooldown of a device.
+func setRetryAfter(w http.ResponseWriter, err error) {
+	if w.Header().Get("Retry-After") != "" {
+		return
+	}
+	var cooldown *cooldownError
+	if errors.Is(err, errStoreUnavailable) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(storeRetryAfter.Seconds())))
+	} else if errors.As(err, &cooldown) {
+		w.Header().Set("Retry-After", seconds(cooldown.retry))
+	}
+}
+
+// Kinds of stores devices are stored in.
+const (
+	StoreFile   = "file"
+	StoreSQLite = "sqlite"
+	StoreBolt   = "bolt"
+)
+
+// store persists the device cache.
+type store interface {
+	// load returns the stored cache.
+	load() (*deviceCache, error)
+	// save stores next, which is the stored cache prev after it was changed.
+	save(prev, next *deviceCache) error
+	// compact reclaims space used by earlier versions of the cache, if the store keeps them.
+	compact() error
+	close() error
+}
+
+// fileStore stores the cache in a JSON file, and changes to it in a journal next to the file.
+type fileStore struct{ name string }
+
+func (f fileStore) close() error { return nil }
+
+// storeMeta holds everything in the cache but its devices, which databases store as a single record.
+type storeMeta struct {
+	Revision    int64        `json:"revision,omitempty"`
+	Changes     []change     `json:"changes,omitempty"`
+	SmartGroups []SmartGroup `json:"smartGroups,omitempty"`
+	Schedules   []Schedule   `json:"schedules,omitempty"`
+}
+
+func newStoreMeta(c *deviceCache) storeMeta {
+	return storeMeta{Revision: c.Revision, Changes: c.Changes, SmartGroups: c.SmartGroups, Schedules: c.Schedules}
+}
+
+func (m storeMeta) cache(devices []Device) *deviceCache {
+	if devices == nil {
+		devices = make([]Device, 0)
+	}
+	return &deviceCache{Devices: devices, Revision: m.Revision, Changes: m.Changes, SmartGroups: m.SmartGroups, Schedules: m.Schedules}
+}
+
+// backend returns the store of the server, which is the cache file unless the server was opened with another store.
+func (s *Server) backend() store {
+	if s.store != nil {
+		return s.store
+	}
+	return fileStore{s.cacheFile}
+}
+
+// Open creates a new server storing devices in a store of the given kind. Databases are stored at path, and are
+// populated from the cache file when they are created, so that existing devices are migrated. The cache file is not
+// used after that, and is left as is.
+func Open(kind, cacheFile, path string) (*Server, error) {
+	s := New(cacheFile)
+	var (
+		db      store
+		created bool
+		err     error
+	)
+	switch kind {
+	case StoreFile:
+		return s, nil
+	case StoreSQLite:
+		db, created, err = openSQLite(path)
+	case StoreBolt:
+		db, created, err = openBolt(path)
+	default:
+		return nil, fmt.Errorf("invalid store: %s", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		if err := migrate(db, fileStore{cacheFile}); err != nil {
+			db.close()
+			return nil, fmt.Errorf("could not migrate %s to %s: %w", cacheFile, path, err)
+		}
+	}
+	s.store = db
+	return s, nil
+}
+
+// migrate copies the cache in src, if it exists, into the empty store dst.
+func migrate(dst store, src fileStore) error {
+	if _, err := os.Stat(src.name); os.IsNotExist(err) {
+		return dst.save(&deviceCache{}, &deviceCache{})
+	}
+	i, err := src.load()
+	if err != nil {
+		return err
+	}
+	return dst.save(&deviceCache{}, i)
+}
+
+// Close closes the store of the server.
+func (s *Server) Close() error { return s.backend().close() }
+
+// storeResult records the outcome of reading or writing devices i. The last devices successfully read or written are
+// kept in memory, and served read-only while the store is unavailable.
+func (s *Server) storeResult(i *deviceCache, err error) {
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
+	if err != nil {
+		if s.storeErr == nil {
+			s.storeErrSince = time.Now()
+		}
+		s.storeErr = err
+		return
+	}
+	s.storeErr = nil
+	last := *i
+	last.Devices = append([]Device(nil), i.Devices...)
+	last.Changes = nil
+	s.last = &last
+}
+
+// lastKnown returns a copy of the last devices successfully read or written.
+func (s *Server) lastKnown() (*deviceCache, bool) {
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
+	if s.last == nil {
+		return nil, false
+	}
+	last := *s.last
+	last.Devices = append([]Device(nil), s.last.Devices...)
+	return &last, true
+}
+
+func (s *Server) findLastKnown(macAddress string) (Device, bool) {
+	i, ok := s.lastKnown()
+	if !ok {
+		return Device{}, false
+	}
+	return i.find(macAddress)
+}
+
+// Readiness is the state of the store, as reported by /readyz.
+type Readiness struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Since  string `json:"since,omitempty"`
+}
+
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	s.mu.RLock()
+	_, err := s.readDevices()
+	s.mu.RUnlock()
+	if err == nil {
+		return Readiness{Status: "ok"}, nil
+	}
+	s.storeMu.Lock()
+	since := s.storeErrSince
+	s.storeMu.Unlock()
+	w.WriteHeader(http.StatusServiceUnavailable)
+	return Readiness{Status: "unavailable", Error: err.Error(), Since: since.UTC().Format(time.RFC3339)}, nil
+}
@@ -0,0 +1,158 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mpolden/wakeup/auth"
+	"github.com/mpolden/wakeup/event"
+)
+
+const (
+	// streamBuffer is the number of events buffered for each stream, beyond which events are lost.
+	streamBuffer = 100
+	// streamKeepAlive is the interval at which idle streams send a comment, so that proxies keep them open.
+	streamKeepAlive = 30 * time.Second
+)
+
+// writeEvent writes e as a server-sent event, identified by its ID as served by the event feed.
+func writeEvent(w http.ResponseWriter, e event.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", eventID(e), e.Type, data)
+	return err
+}
+
+// missedEvents returns the recent events following the one having ID id, oldest first. Nothing is returned if the
+// event is no longer recorded.
+func (s *Server) missedEvents(id string) []event.Event {
+	if s.Recent == nil || id == "" {
+		return nil
+	}
+	events := s.Recent.Events()
+	for j, e := range events {
+		if eventID(e) != id {
+			continue
+		}
+		missed := make([]event.Event, 0, j)
+		for k := j - 1; k >= 0; k-- {
+			missed = append(missed, events[k])
+		}
+		return missed
+	}
+	return nil
+}
+
+// streamHandler handles GET /api/v1/events, which streams events as they happen as server-sent events, e.g. devices
+// being added, removed, woken, or coming online or going offline. Events can be filtered by type with the type
+// parameter, which can be repeated. Users only receive events of the devices they have access to. Events are lost if
+// the client is not keeping up, in which case a lost event holding the number of events dropped is sent. Clients
+// reconnecting with the Last-Event-ID header receive the events they missed, if they are still recorded by the feed.
+// Streams end when the write timeout of the server passes, upon which clients are expected to reconnect.
+func (s *Server) streamHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if s.Events == nil {
+		return notFoundHandler(w, r)
+	}
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	types, _, e := parseEventFilter(r)
+	if e != nil {
+		return nil, e
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, &Error{Status: http.StatusInternalServerError, Message: "Streaming is not supported"}
+	}
+	// Users see the events of devices they have access to when the events happen, which changes as devices are added
+	var ids map[string]bool
+	u := userFrom(r.Context())
+	refresh := func() *Error {
+		if u == nil || u.HasRole(auth.RoleAdmin) {
+			return nil
+		}
+		s.mu.RLock()
+		i, err := s.readDevices()
+		s.mu.RUnlock()
+		if err != nil {
+			return storeFailure(err)
+		}
+		ids = make(map[string]bool)
+		for _, d := range visible(u, i.Devices) {
+			ids[d.ID] = true
+		}
+		return nil
+	}
+	if e := refresh(); e != nil {
+		return nil, e
+	}
+	allowed := func(e event.Event) bool {
+		if e.Type == event.Lost {
+			return true
+		}
+		if len(types) > 0 && !types[e.Type] {
+			return false
+		}
+		if ids == nil {
+			return true
+		}
+		if e.Type == event.Added {
+			refresh()
+		}
+		ok := ids[e.Device]
+		if e.Type == event.Removed {
+			delete(ids, e.Device)
+		}
+		return ok
+	}
+
+	events, cancel := s.Events.SubscribeMarked(streamBuffer)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Disable buffering of the stream by nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	replayed := make(map[string]bool)
+	for _, e := range s.missedEvents(r.Header.Get("Last-Event-ID")) {
+		if allowed(e) {
+			if err := writeEvent(w, e); err != nil {
+				return nil, nil
+			}
+		}
+		replayed[eventID(e)] = true
+	}
+	flusher.Flush()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil, nil
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return nil, nil
+			}
+		case e := <-events:
+			// Events published while missed events were replayed are received twice
+			if id := eventID(e); replayed[id] {
+				delete(replayed, id)
+				continue
+			}
+			if !allowed(e) {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				return nil, nil
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/mpolden/wakeup/power"
+	"github.com/mpolden/wakeup/prereq"
+)
+
+// Template holds the settings shared by a kind of device, such as a hardware model, which are applied to devices
+// created from it. Fields of the device take precedence over those of the template.
+type Template struct {
+	Name          string         `json:"name"`
+	Description   string         `json:"description,omitempty"`
+	Groups        []string       `json:"groups,omitempty"`
+	Notes         string         `json:"notes,omitempty"`
+	Prerequisites []prereq.Check `json:"prerequisites,omitempty"`
+	Essential     bool           `json:"essential,omitempty"`
+	Watts         float64        `json:"watts,omitempty"`
+	OnOnline      string         `json:"onOnline,omitempty"`
+	ProbePorts    []int          `json:"probePorts,omitempty"`
+	Platform      string         `json:"platform,omitempty"`
+	KeepAwake     *KeepAwake     `json:"keepAwake,omitempty"`
+	Shutdown      string         `json:"shutdown,omitempty"`
+	WakeAddress   string         `json:"wakeAddress,omitempty"`
+	WakePort      int            `json:"wakePort,omitempty"`
+	Transport     string         `json:"transport,omitempty"`
+}
+
+// Templates lists the templates devices can be created from.
+type Templates struct {
+	Templates []Template `json:"templates"`
+}
+
+func (t *Template) validate() *Error {
+	for _, err := range []*Error{
+		validateDescription(t.Description),
+		validateNotes(t.Notes),
+		validatePrerequisites(t.Prerequisites),
+		validateHook(t.OnOnline),
+		validateProbePorts(t.ProbePorts),
+		validatePlatform(t.Platform),
+		validateKeepAwake(t.KeepAwake),
+		validateWakeAddress(t.WakeAddress, t.WakePort),
+		validateTransport(t.Transport, ""),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	if t.Watts < 0 {
+		return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid watts: %v", t.Watts)}
+	}
+	return nil
+}
+
+// ReadTemplates reads device templates from the JSON file at name.
+func ReadTemplates(name string) ([]Template, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var c Templates
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(c.Templates))
+	for j, t := range c.Templates {
+		if t.Name == "" {
+			return nil, fmt.Errorf("template #%d: missing name", j)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("template %s: duplicate name", t.Name)
+		}
+		seen[t.Name] = true
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("template %s: %s", t.Name, err.Message)
+		}
+	}
+	return c.Templates, nil
+}
+
+// template returns the template named name.
+func (s *Server) template(name string) (Template, *Error) {
+	for _, t := range s.Templates {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return Template{}, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Template not found: %s", name)}
+}
+
+// resource returns a device resource holding the settings of the template, onto which a device is decoded.
+func (t *Template) resource() DeviceResource {
+	return *newDeviceResource(Device{
+		Description:   t.Description,
+		Groups:        t.Groups,
+		Notes:         t.Notes,
+		Prerequisites: t.Prerequisites,
+		Essential:     t.Essential,
+		Watts:         t.Watts,
+		OnOnline:      t.OnOnline,
+		ProbePorts:    t.ProbePorts,
+		Platform:      t.Platform,
+		KeepAwake:     t.KeepAwake,
+		Shutdown:      power.Preset(t.Shutdown),
+		WakeAddress:   t.WakeAddress,
+		WakePort:      t.WakePort,
+		Transport:     t.Transport,
+	})
+}
+
+// apply sets the fields of device which are unset to those of the template.
+func (t *Template) apply(device *Device) {
+	if device.Description == "" {
+		device.Description = t.Description
+	}
+	if len(device.Groups) == 0 {
+		device.Groups = t.Groups
+	}
+	if device.Notes == "" {
+		device.Notes = t.Notes
+	}
+	if len(device.Prerequisites) == 0 {
+		device.Prerequisites = t.Prerequisites
+	}
+	device.Essential = device.Essential || t.Essential
+	if device.Watts == 0 {
+		device.Watts = t.Watts
+	}
+	if device.OnOnline == "" {
+		device.OnOnline = t.OnOnline
+	}
+	if len(device.ProbePorts) == 0 {
+		device.ProbePorts = t.ProbePorts
+	}
+	if device.Platform == "" {
+		device.Platform = t.Platform
+	}
+	if device.KeepAwake == nil {
+		device.KeepAwake = t.KeepAwake
+	}
+	if device.Shutdown == "" {
+		device.Shutdown = power.Preset(t.Shutdown)
+	}
+	if device.WakeAddress == "" {
+		device.WakeAddress = t.WakeAddress
+	}
+	if device.WakePort == 0 {
+		device.WakePort = t.WakePort
+	}
+	if device.Transport == "" {
+		device.Transport = t.Transport
+	}
+}
+
+func (s *Server) templatesHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	if r.Method != http.MethodGet {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodGet),
+		}
+	}
+	templates := s.Templates
+	if templates == nil {
+		templates = make([]Template, 0)
+	}
+	return &Templates{Templates: templates}, nil
+}
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mpolden/wakeup/neigh"
+)
+
+// arpSource is the actor recording IP addresses observed in the ARP table or neighbour cache.
+const arpSource = "arp"
+
+// AddressResource is an IP address a device has been observed at, e.g. as reported by an agent running on the device.
+type AddressResource struct {
+	IPAddress string `json:"ipAddress"`
+	// MACAddress is the MAC address the device was observed using, if known. It is recorded as an alternate MAC
+	// address of the device if the device has no such MAC address.
+	MACAddress string `json:"macAddress,omitempty"`
+}
+
+// address returns the IP address device is reached at, which is the last IP address it was observed at, if any.
+func (d Device) address() string {
+	if d.LastKnownIP != "" {
+		return d.LastKnownIP
+	}
+	return d.IPAddress
+}
+
+// ObserveIP records that the device having MAC address mac was observed at ip, on behalf of a. Changes are recorded in
+// the history, like any other change of a device.
+func (s *Server) ObserveIP(mac, ip string, a actor) (Device, bool, error) {
+	return s.updateDevice(mac, a, func(d *Device) bool {
+		if d.address() == ip {
+			return false
+		}
+		d.LastKnownIP = ip
+		return true
+	})
+}
+
+// updateDevice applies update to the device having MAC address mac, and stores the device if update changed it.
+func (s *Server) updateDevice(mac string, a actor, update func(*Device) bool) (Device, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.readDevices()
+	if err != nil {
+		return Device{}, false, err
+	}
+	device, ok := i.findMAC(mac)
+	if !ok {
+		return Device{}, false, fmt.Errorf("device not found: %s", mac)
+	}
+	if !update(&device) {
+		return device, false, nil
+	}
+	i.update(device)
+	return device, true, s.writeCache(i, a)
+}
+
+// observed returns the IP address each MAC address in t was observed at. IPv4 addresses are preferred, and the first address
+// in sort order is chosen if a MAC address has several, so that the chosen address does not change needlessly.
+func observed(t neigh.Table) map[string]string {
+	ips := make(map[string]string)
+	for ip, hw := range t {
+		mac, ok := normalizeMAC(hw.String())
+		if !ok {
+			continue
+		}
+		if prev, ok := ips[mac]; !ok || betterIP(ip, prev) {
+			ips[mac] = ip
+		}
+	}
+	return ips
+}
+
+func betterIP(a, b string) bool {
+	a4, b4 := net.ParseIP(a).To4() != nil, net.ParseIP(b).To4() != nil
+	if a4 != b4 {
+		return a4
+	}
+	return a < b
+}
+
+// TrackIPs updates the last known IP address of devices from the ARP table and neighbour cache at every interval.
+func (s *Server) TrackIPs(interval time.Duration) {
+	for {
+		if err := s.trackIPs(); err != nil {
+			log.Printf("could not track ip addresses: %s", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (s *Server) trackIPs() error {
+	t, err := s.neighFunc()
+	if err != nil {
+		return err
+	}
+	ips := observed(t)
+	devices, err := s.Devices()
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		for _, mac := range d.macAddresses() {
+			ip, ok := ips[mac]
+			if !ok || ip == d.address() {
+				continue
+			}
+			if _, _, err := s.ObserveIP(mac, ip, actor{name: arpSource}); err != nil {
+				return err
+			}
+			log.Printf("Device with address %s observed at %s", d.MACAddress, ip)
+			break
+		}
+	}
+	return s.trackAlternates(t, ips)
+}
+
+func (s *Server) addressHandler(w http.ResponseWriter, r *http.Request, id string) (interface{}, *Error) {
+	ref, rerr := deviceRef(id)
+	if rerr != nil {
+		return nil, rerr
+	}
+	if r.Method != http.MethodPut {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPut),
+		}
+	}
+	var body AddressResource
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	if net.ParseIP(body.IPAddress) == nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf("Invalid IP address: %s", body.IPAddress)}
+	}
+	mac, _ := normalizeMAC(body.MACAddress)
+	if body.MACAddress != "" {
+		if err := validateTarget(body.MACAddress); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	device, ok := i.lookup(ref)
+	if !ok || access(userFrom(r.Context()), device) == "" {
+		return nil, &Error{Status: http.StatusNotFound, Message: fmt.Sprintf("Device not found: %s", ref)}
+	}
+	if !allows(access(userFrom(r.Context()), device), AccessManage) {
+		return nil, &Error{Status: http.StatusForbidden, Message: "Forbidden"}
+	}
+	device, _, err = s.ObserveIP(device.MACAddress, body.IPAddress, requestActor(r))
+	if err != nil {
+		return nil, storeFailure(err)
+	}
+	if mac != "" {
+		device, _, err = s.observeAlternate(device.MACAddress, mac, body.IPAddress, requestActor(r))
+		if err != nil {
+			return nil, storeFailure(err)
+		}
+	}
+	return newDeviceResource(device), nil
+}
@@ -0,0 +1,88 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/mpolden/wakeup/budget"
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/script"
+)
+
+const upsSource = "ups"
+
+// Policies for wakes of non-essential devices while the UPS is on battery.
+const (
+	UPSRefuse = "refuse"
+	UPSDefer  = "defer"
+)
+
+// DeferredWake is a wake that is sent when the UPS returns to line power.
+type DeferredWake struct {
+	MACAddress string `json:"macAddress"`
+	Reason     string `json:"reason"`
+	device     Device
+	src        net.IP
+}
+
+const reasonOnBattery = "UPS is on battery"
+
+// errOnBattery is returned when a wake is refused because the UPS is on battery.
+var errOnBattery = errors.New(reasonOnBattery)
+
+// onBattery returns whether the wake of device should be refused or deferred because the UPS is on battery.
+func (s *Server) onBattery(device Device) bool {
+	return s.UPS != nil && !device.Essential && s.UPS.OnBattery()
+}
+
+func (s *Server) deferWake(device Device, src net.IP) *DeferredWake {
+	s.deferMu.Lock()
+	defer s.deferMu.Unlock()
+	d := DeferredWake{MACAddress: device.MACAddress, Reason: reasonOnBattery, device: device, src: src}
+	for i, v := range s.deferred {
+		if v.MACAddress == device.MACAddress {
+			s.deferred[i] = d
+			return &d
+		}
+	}
+	s.deferred = append(s.deferred, d)
+	return &d
+}
+
+func (s *Server) deferredCount() int {
+	s.deferMu.Lock()
+	defer s.deferMu.Unlock()
+	return len(s.deferred)
+}
+
+// ResumeDeferred sends all wakes that were deferred while the UPS was on battery. Wakes are staggered, to avoid all
+// devices drawing power at once.
+func (s *Server) ResumeDeferred() {
+	s.deferMu.Lock()
+	wakes := s.deferred
+	s.deferred = nil
+	s.deferMu.Unlock()
+	for i, d := range wakes {
+		if i > 0 {
+			time.Sleep(s.Stagger)
+		}
+		if err := s.runScript(context.Background(), script.PreWake, d.device); err != nil {
+			log.Printf("ups: dropped deferred wake of %s: pre-wake script failed: %s", d.MACAddress, err)
+			continue
+		}
+		if !s.allow(context.Background(), budget.PriorityRetry, s.burst().packetsTo(d.device)) {
+			log.Printf("ups: dropped deferred wake of %s: %s", d.MACAddress, budget.ErrExceeded)
+			continue
+		}
+		if _, err := s.wakeAll(d.src, d.device); err != nil {
+			s.publishWake(actor{name: upsSource}, event.Event{Type: event.Failed, Device: d.device.ID, MACAddress: d.MACAddress, Name: d.device.Name, Error: err.Error()})
+			log.Printf("ups: failed to wake %s: %s", d.MACAddress, err)
+			continue
+		}
+		log.Printf("ups: woke %s after line power returned", d.MACAddress)
+		s.publishWake(actor{name: upsSource}, event.Event{Type: event.Wake, Device: d.device.ID, MACAddress: d.MACAddress, Name: d.device.Name})
+	}
+}
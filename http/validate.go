@@ -0,0 +1,71 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mpolden/wakeup/macaddr"
+)
+
+// MACValidation describes a MAC address validated by /api/v1/validate/mac.
+type MACValidation struct {
+	Input string `json:"input"`
+	// Valid is whether the input parses as a MAC address. The remaining fields are only set if it does, except for
+	// Message, which explains why it does not.
+	Valid   bool   `json:"valid"`
+	Message string `json:"message,omitempty"`
+	// MACAddress is the address in the notation stored by devices, and Format is the notation of the input.
+	MACAddress string `json:"macAddress,omitempty"`
+	Format     string `json:"format,omitempty"`
+	Vendor     string `json:"vendor,omitempty"`
+	// LocallyAdministered is whether the address was assigned locally, e.g. randomized by the operating system, and
+	// may therefore change.
+	LocallyAdministered bool `json:"locallyAdministered"`
+	// Multicast is whether the address is a group address, which no device can be woken by.
+	Multicast bool `json:"multicast"`
+	Wakeable  bool `json:"wakeable"`
+}
+
+type validateMACRequest struct {
+	MACAddress string `json:"macAddress"`
+}
+
+// validateMAC validates input as a MAC address.
+func validateMAC(input string) MACValidation {
+	v := MACValidation{Input: input}
+	hwAddr, format, err := macaddr.Parse(input)
+	if err != nil {
+		v.Message = fmt.Sprintf("Invalid MAC address: %s", input)
+		return v
+	}
+	v.Valid = true
+	v.MACAddress = macaddr.String(hwAddr)
+	v.Format = format
+	v.Vendor = macaddr.Vendor(hwAddr)
+	v.LocallyAdministered = macaddr.LocallyAdministered(hwAddr)
+	v.Multicast = macaddr.Multicast(hwAddr)
+	v.Wakeable = !v.Multicast
+	return v
+}
+
+// validateMACHandler handles POST /api/v1/validate/mac, which validates a MAC address as it is typed. Input which is
+// not a MAC address is reported in the validation, rather than as an error.
+func (s *Server) validateMACHandler(w http.ResponseWriter, r *http.Request) (interface{}, *Error) {
+	defer r.Body.Close()
+	if r.Method != http.MethodPost {
+		return nil, &Error{
+			Status:  http.StatusMethodNotAllowed,
+			Message: fmt.Sprintf("Invalid method %s, must be %s", r.Method, http.MethodPost),
+		}
+	}
+	var body validateMACRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Malformed JSON"}
+	}
+	if body.MACAddress == "" {
+		return nil, &Error{Status: http.StatusBadRequest, Message: "Missing MAC address"}
+	}
+	v := validateMAC(body.MACAddress)
+	return &v, nil
+}
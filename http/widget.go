@@ -0,0 +1,98 @@
+package http
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// widgetPage is a device card meant to be embedded in dashboards, such as Organizr, Heimdall or Home Assistant. The
+// wake button uses the regular wake API, so the card is subject to the same authentication and access as the API.
+var widgetPage = template.Must(template.New("widget").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>{{.Name}}</title>
+<style>
+body { margin: 0; font-family: sans-serif; }
+.card { display: flex; align-items: center; gap: 0.5em; padding: 0.5em; }
+.status { width: 0.75em; height: 0.75em; border-radius: 50%; background: #9f9f9f; }
+.online { background: #4c1; }
+.offline { background: #e05d44; }
+.waking { background: #dfb317; }
+.conflict { background: #fe7d37; }
+.name { flex: 1; }
+</style>
+</head>
+<body>
+<div class="card">
+<span class="status {{.Status}}" title="{{.Status}}"></span>
+<span class="name">{{.Name}}</span>
+<button id="wake"{{if eq .Status "online"}} disabled{{end}}>Wake</button>
+</div>
+<script>
+document.getElementById("wake").addEventListener("click", function(e) {
+  var button = e.target;
+  button.disabled = true;
+  button.textContent = "Waking";
+  fetch("/api/v1/wake", {
+    method: "POST",
+    credentials: "same-origin",
+    headers: {"Content-Type": "application/json"},
+    body: JSON.stringify({macAddress: {{.MACAddress}}})
+  }).then(function(res) {
+    button.textContent = res.ok ? "Woken" : "Failed";
+  }, function() {
+    button.textContent = "Failed";
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+type widget struct {
+	Name       string
+	MACAddress string
+	Status     string
+	Refresh    int
+}
+
+// widgetHandler serves the widget of a device at /widget/devices/{ref}.
+func (s *Server) widgetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ref, e := deviceRef(strings.TrimPrefix(r.URL.Path, "/widget/devices/"))
+	if e != nil {
+		http.Error(w, e.Message, e.Status)
+		return
+	}
+	s.mu.RLock()
+	i, err := s.readDevices()
+	s.mu.RUnlock()
+	if err != nil {
+		log.Print(err)
+		setRetryAfter(w, err)
+		http.Error(w, "Store unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	device, ok := i.lookup(ref)
+	if !ok || access(userFrom(r.Context()), device) == "" {
+		http.Error(w, "Device not found: "+ref, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	widgetPage.Execute(w, widget{
+		Name:       displayName(device),
+		MACAddress: device.MACAddress,
+		Status:     s.status(r.Context(), device, false),
+		Refresh:    int(s.statusInterval().Seconds()),
+	})
+}
@@ -0,0 +1,67 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+type sourceConfig struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	URL      string            `json:"url"`
+	Token    string            `json:"token"`
+	Records  string            `json:"records"`
+	Mapping  map[string]string `json:"mapping"`
+	Interval string            `json:"interval"`
+}
+
+// ReadConfig reads inventory sources from the JSON file at name.
+func ReadConfig(name string) ([]*Source, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var c struct {
+		Sources []sourceConfig `json:"sources"`
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	sources := make([]*Source, 0, len(c.Sources))
+	for i, sc := range c.Sources {
+		switch sc.Type {
+		case "csv", "json", "netbox", "phpipam":
+		default:
+			return nil, fmt.Errorf("source #%d: invalid type: %s", i, sc.Type)
+		}
+		if sc.Name == "" {
+			return nil, fmt.Errorf("source #%d: name is required", i)
+		}
+		interval := 15 * time.Minute
+		if sc.Interval != "" {
+			interval, err = time.ParseDuration(sc.Interval)
+			if err != nil {
+				return nil, fmt.Errorf("source #%d: %s", i, err)
+			}
+		}
+		for field := range sc.Mapping {
+			switch field {
+			case FieldName, FieldMACAddress, FieldIPAddress, FieldGroups:
+			default:
+				return nil, fmt.Errorf("source #%d: invalid field: %s", i, field)
+			}
+		}
+		sources = append(sources, &Source{
+			Name:     sc.Name,
+			Type:     sc.Type,
+			URL:      sc.URL,
+			Token:    sc.Token,
+			Records:  sc.Records,
+			Mapping:  sc.Mapping,
+			Interval: interval,
+		})
+	}
+	return sources, nil
+}
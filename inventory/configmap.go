@@ -0,0 +1,190 @@
+package inventory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfigMapSource is the source name used for devices defined in a ConfigMap.
+const ConfigMapSource = "configmap"
+
+// APIVersion is the version of resources read from a ConfigMap.
+const APIVersion = "wakeup/v1"
+
+type resource struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		MACAddress string   `json:"macAddress"`
+		IPAddress  string   `json:"ipAddress"`
+		Groups     []string `json:"groups"`
+	} `json:"spec"`
+	Items []resource `json:"items"`
+}
+
+// ConfigMapStatus is the state of the last sync of a ConfigMap.
+type ConfigMapStatus struct {
+	Dir      string `json:"dir"`
+	Checksum string `json:"checksum,omitempty"`
+	Files    int    `json:"files"`
+	Devices  int    `json:"devices"`
+	Synced   string `json:"synced,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ConfigMap reads declarative device definitions from JSON files in a directory, such as a ConfigMap mounted in a
+// Kubernetes pod. Each file contains a Device or a DeviceList resource:
+//
+//	{"apiVersion":"wakeup/v1","kind":"Device","metadata":{"name":"nas"},"spec":{"macAddress":"AB:CD:EF:12:34:56"}}
+type ConfigMap struct {
+	Dir      string
+	Interval time.Duration
+	mu       sync.Mutex
+	status   ConfigMapStatus
+}
+
+// NewConfigMap creates a new ConfigMap reading files in dir.
+func NewConfigMap(dir string) *ConfigMap {
+	return &ConfigMap{Dir: dir, Interval: 10 * time.Second, status: ConfigMapStatus{Dir: dir}}
+}
+
+// Status returns the status of the last sync.
+func (c *ConfigMap) Status() ConfigMapStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+func (r *resource) records(name string) ([]Record, error) {
+	if r.APIVersion != APIVersion {
+		return nil, fmt.Errorf("%s: invalid apiVersion: %q", name, r.APIVersion)
+	}
+	switch r.Kind {
+	case "Device":
+		hwAddr, err := net.ParseMAC(r.Spec.MACAddress)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: invalid macAddress: %q", name, r.Metadata.Name, r.Spec.MACAddress)
+		}
+		if r.Spec.IPAddress != "" && net.ParseIP(r.Spec.IPAddress) == nil {
+			return nil, fmt.Errorf("%s: %s: invalid ipAddress: %q", name, r.Metadata.Name, r.Spec.IPAddress)
+		}
+		return []Record{{
+			Name:       r.Metadata.Name,
+			MACAddress: strings.ToUpper(hwAddr.String()),
+			IPAddress:  r.Spec.IPAddress,
+			Groups:     r.Spec.Groups,
+		}}, nil
+	case "DeviceList":
+		var records []Record
+		for _, item := range r.Items {
+			if item.APIVersion == "" {
+				item.APIVersion = r.APIVersion
+			}
+			rs, err := item.records(name)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, rs...)
+		}
+		return records, nil
+	}
+	return nil, fmt.Errorf("%s: invalid kind: %q", name, r.Kind)
+}
+
+// Read reads all records from the directory, and returns them together with a checksum of the files. Files whose name
+// start with a dot are skipped, these are the timestamped directories and symlinks Kubernetes uses to update a ConfigMap
+// atomically.
+func (c *ConfigMap) Read() ([]Record, string, int, error) {
+	entries, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	var records []Record
+	seen := make(map[string]string)
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join(c.Dir, name))
+		if err != nil {
+			// Entry may be a symlink to a directory, or removed since it was listed
+			if info, err := os.Stat(filepath.Join(c.Dir, name)); err == nil && info.IsDir() {
+				continue
+			}
+			return nil, "", 0, err
+		}
+		h.Write([]byte(name))
+		h.Write(data)
+		var r resource
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, "", 0, fmt.Errorf("%s: %s", name, err)
+		}
+		rs, err := r.records(name)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		for _, rec := range rs {
+			if other, ok := seen[rec.MACAddress]; ok {
+				return nil, "", 0, fmt.Errorf("%s: duplicate macAddress %s, also defined in %s", name, rec.MACAddress, other)
+			}
+			seen[rec.MACAddress] = name
+		}
+		records = append(records, rs...)
+	}
+	return records, hex.EncodeToString(h.Sum(nil)), len(names), nil
+}
+
+// Sync reads the directory and applies its records if the files have changed since the last successful sync. An
+// invalid file stops the sync, so that a partial ConfigMap never removes devices.
+func (c *ConfigMap) Sync(apply func(source string, records []Record) (Result, error)) (Result, error) {
+	var r Result
+	records, sum, files, err := c.Read()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil && (sum != c.status.Checksum || c.status.Error != "") {
+		r, err = apply(ConfigMapSource, records)
+	}
+	if err != nil {
+		c.status.Error = err.Error()
+		return r, err
+	}
+	c.status.Checksum = sum
+	c.status.Files = files
+	c.status.Devices = len(records)
+	c.status.Synced = time.Now().UTC().Format(time.RFC3339)
+	c.status.Error = ""
+	return r, nil
+}
+
+// Watch syncs the directory every interval.
+func (c *ConfigMap) Watch(apply func(source string, records []Record) (Result, error)) {
+	for {
+		r, err := c.Sync(apply)
+		if err != nil {
+			log.Printf("configmap: %s: %s", c.Dir, err)
+		} else if r.Added > 0 || r.Updated > 0 || r.Removed > 0 {
+			log.Printf("configmap: %s: %d added, %d updated, %d removed", c.Dir, r.Added, r.Updated, r.Removed)
+		}
+		time.Sleep(c.Interval)
+	}
+}
@@ -0,0 +1,248 @@
+package inventory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Fields that can be mapped from a source.
+const (
+	FieldName       = "name"
+	FieldMACAddress = "macAddress"
+	FieldIPAddress  = "ipAddress"
+	FieldGroups     = "groups"
+)
+
+// Record is a device fetched from an inventory source.
+type Record struct {
+	Name       string
+	MACAddress string
+	IPAddress  string
+	Groups     []string
+}
+
+// Result summarizes the changes made when applying records.
+type Result struct {
+	Added   int
+	Updated int
+	Removed int
+}
+
+// Source is an external inventory, such as NetBox, phpIPAM or a CSV file served over HTTP.
+type Source struct {
+	Name string
+	// Type is one of csv, json, netbox or phpipam.
+	Type  string
+	URL   string
+	Token string
+	// Records is the dotted path to the list of records in a JSON response.
+	Records string
+	// Mapping maps device fields to CSV columns or dotted JSON paths.
+	Mapping  map[string]string
+	Interval time.Duration
+	client   *http.Client
+}
+
+var presets = map[string]struct {
+	path    string
+	records string
+	mapping map[string]string
+}{
+	"netbox": {
+		path:    "/api/dcim/interfaces/?limit=0&mac_address__empty=false",
+		records: "results",
+		mapping: map[string]string{FieldName: "device.name", FieldMACAddress: "mac_address"},
+	},
+	"phpipam": {
+		path:    "/addresses/",
+		records: "data",
+		mapping: map[string]string{FieldName: "hostname", FieldMACAddress: "mac", FieldIPAddress: "ip"},
+	},
+}
+
+func (s *Source) url() string {
+	if p, ok := presets[s.Type]; ok {
+		return strings.TrimSuffix(s.URL, "/") + p.path
+	}
+	return s.URL
+}
+
+func (s *Source) mapping(field string) string {
+	if v, ok := s.Mapping[field]; ok {
+		return v
+	}
+	if p, ok := presets[s.Type]; ok {
+		return p.mapping[field]
+	}
+	return field
+}
+
+// Fetch fetches all records from the source. Records with an invalid MAC address are skipped.
+func (s *Source) Fetch() ([]Record, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.Token != "" {
+		switch s.Type {
+		case "netbox":
+			req.Header.Set("Authorization", "Token "+s.Token)
+		case "phpipam":
+			req.Header.Set("token", s.Token)
+		default:
+			req.Header.Set("Authorization", "Bearer "+s.Token)
+		}
+	}
+	client := s.client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: got status %d", s.Name, res.StatusCode)
+	}
+	var records []Record
+	if s.Type == "csv" {
+		records, err = s.parseCSV(res.Body)
+	} else {
+		records, err = s.parseJSON(res.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	valid := records[:0]
+	for _, r := range records {
+		hwAddr, err := net.ParseMAC(r.MACAddress)
+		if err != nil {
+			continue
+		}
+		r.MACAddress = strings.ToUpper(hwAddr.String())
+		valid = append(valid, r)
+	}
+	return valid, nil
+}
+
+func (s *Source) parseCSV(r io.Reader) ([]Record, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	columns := make(map[string]int, len(rows[0]))
+	for i, name := range rows[0] {
+		columns[name] = i
+	}
+	value := func(row []string, field string) string {
+		if i, ok := columns[s.mapping(field)]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	records := make([]Record, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		r := Record{
+			Name:       value(row, FieldName),
+			MACAddress: value(row, FieldMACAddress),
+			IPAddress:  value(row, FieldIPAddress),
+		}
+		if groups := value(row, FieldGroups); groups != "" {
+			r.Groups = strings.Split(groups, ";")
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func lookup(v interface{}, path string) interface{} {
+	if path == "" {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+func lookupString(v interface{}, path string) string {
+	switch s := lookup(v, path).(type) {
+	case string:
+		// IP addresses are commonly given in CIDR notation
+		if ip, _, err := net.ParseCIDR(s); err == nil {
+			return ip.String()
+		}
+		return s
+	case float64:
+		return fmt.Sprint(s)
+	}
+	return ""
+}
+
+func (s *Source) parseJSON(r io.Reader) ([]Record, error) {
+	var doc interface{}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	recordsPath := s.Records
+	if recordsPath == "" {
+		recordsPath = presets[s.Type].records
+	}
+	items, ok := lookup(doc, recordsPath).([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: no records found at %q", s.Name, recordsPath)
+	}
+	records := make([]Record, 0, len(items))
+	for _, item := range items {
+		r := Record{
+			Name:       lookupString(item, s.mapping(FieldName)),
+			MACAddress: lookupString(item, s.mapping(FieldMACAddress)),
+			IPAddress:  lookupString(item, s.mapping(FieldIPAddress)),
+		}
+		switch groups := lookup(item, s.mapping(FieldGroups)).(type) {
+		case string:
+			r.Groups = []string{groups}
+		case []interface{}:
+			for _, g := range groups {
+				if name, ok := g.(string); ok {
+					r.Groups = append(r.Groups, name)
+				}
+			}
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// Run fetches records from source every interval, and applies them using apply.
+func Run(source *Source, apply func(source string, records []Record) (Result, error)) {
+	for {
+		records, err := source.Fetch()
+		if err == nil {
+			var r Result
+			r, err = apply(source.Name, records)
+			if err == nil && (r.Added > 0 || r.Updated > 0 || r.Removed > 0) {
+				log.Printf("inventory: %s: %d added, %d updated, %d removed", source.Name, r.Added, r.Updated, r.Removed)
+			}
+		}
+		if err != nil {
+			log.Printf("inventory: %s: %s", source.Name, err)
+		}
+		time.Sleep(source.Interval)
+	}
+}
@@ -0,0 +1,159 @@
+package inventory
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testSource(typ, body string) (*Source, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if typ == "netbox" && r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	return &Source{Name: "test", Type: typ, URL: server.URL, Token: "secret"}, server
+}
+
+func TestFetchCSV(t *testing.T) {
+	csv := "host,mac,ip,groups\nnas,ab:cd:ef:12:34:56,10.0.0.2,storage;lab\ninvalid,foo,,\n"
+	source, server := testSource("csv", csv)
+	defer server.Close()
+	source.Mapping = map[string]string{FieldName: "host", FieldMACAddress: "mac", FieldIPAddress: "ip"}
+
+	records, err := source.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Record{{Name: "nas", MACAddress: "AB:CD:EF:12:34:56", IPAddress: "10.0.0.2", Groups: []string{"storage", "lab"}}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("want %+v, got %+v", want, records)
+	}
+}
+
+func TestFetchNetBox(t *testing.T) {
+	body := `{"results":[{"name":"eth0","mac_address":"AB:CD:EF:12:34:56","device":{"name":"nas"},"ip":"10.0.0.2/24"}]}`
+	source, server := testSource("netbox", body)
+	defer server.Close()
+	source.Mapping = map[string]string{FieldIPAddress: "ip"}
+
+	records, err := source.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Record{{Name: "nas", MACAddress: "AB:CD:EF:12:34:56", IPAddress: "10.0.0.2"}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("want %+v, got %+v", want, records)
+	}
+
+	source.Token = ""
+	if _, err := source.Fetch(); err == nil {
+		t.Error("want error for unauthorized request")
+	}
+}
+
+func TestFetchJSONInvalidPath(t *testing.T) {
+	source, server := testSource("json", `{"items":[]}`)
+	defer server.Close()
+	source.Records = "devices"
+	if _, err := source.Fetch(); err == nil || err.Error() != `test: no records found at "devices"` {
+		t.Errorf("want error for missing records, got %v", err)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	conf := `{"sources":[{"name":"netbox","type":"netbox","url":"https://netbox.example.com","interval":"5m","mapping":{"ipAddress":"ip"}}]}`
+	if _, err := f.WriteString(conf); err != nil {
+		t.Fatal(err)
+	}
+	sources, err := ReadConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 || sources[0].Interval != 5*time.Minute || sources[0].mapping(FieldName) != "device.name" {
+		t.Errorf("got unexpected sources %+v", sources)
+	}
+}
+
+func TestConfigMapSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, data string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("nas.json", `{"apiVersion":"wakeup/v1","kind":"Device","metadata":{"name":"nas"},"spec":{"macAddress":"ab:cd:ef:12:34:56","groups":["lab"]}}`)
+	write("lab.json", `{"apiVersion":"wakeup/v1","kind":"DeviceList","items":[{"kind":"Device","metadata":{"name":"pc"},"spec":{"macAddress":"ab:cd:ef:12:34:57","ipAddress":"10.0.0.2"}}]}`)
+	write("README", "ignored")
+	if err := os.Mkdir(filepath.Join(dir, "..data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var applied [][]Record
+	apply := func(source string, records []Record) (Result, error) {
+		if source != ConfigMapSource {
+			t.Errorf("want source %q, got %q", ConfigMapSource, source)
+		}
+		applied = append(applied, records)
+		return Result{Added: len(records)}, nil
+	}
+	c := NewConfigMap(dir)
+	if _, err := c.Sync(apply); err != nil {
+		t.Fatal(err)
+	}
+	want := []Record{
+		{Name: "pc", MACAddress: "AB:CD:EF:12:34:57", IPAddress: "10.0.0.2"},
+		{Name: "nas", MACAddress: "AB:CD:EF:12:34:56", Groups: []string{"lab"}},
+	}
+	if len(applied) != 1 || !reflect.DeepEqual(applied[0], want) {
+		t.Errorf("want %+v, got %+v", want, applied)
+	}
+	if s := c.Status(); s.Files != 2 || s.Devices != 2 || s.Error != "" || s.Checksum == "" {
+		t.Errorf("unexpected status %+v", s)
+	}
+
+	// Unchanged files are not applied again
+	if _, err := c.Sync(apply); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 {
+		t.Errorf("want 1 apply, got %d", len(applied))
+	}
+
+	// Invalid files stop the sync, and keep the previous state
+	write("bad.json", `{"apiVersion":"wakeup/v1","kind":"Device","metadata":{"name":"bad"},"spec":{"macAddress":"ab:cd:ef:12:34:56"}}`)
+	if _, err := c.Sync(apply); err == nil {
+		t.Error("want error for duplicate MAC address")
+	}
+	if s := c.Status(); s.Devices != 2 || s.Error == "" {
+		t.Errorf("unexpected status %+v", s)
+	}
+	write("bad.json", `{"apiVersion":"v2","kind":"Device"}`)
+	if _, err := c.Sync(apply); err == nil {
+		t.Error("want error for invalid apiVersion")
+	}
+	os.Remove(filepath.Join(dir, "bad.json"))
+	if _, err := c.Sync(apply); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 || c.Status().Error != "" {
+		t.Errorf("want sync after error is resolved, got %d applies and status %+v", len(applied), c.Status())
+	}
+}
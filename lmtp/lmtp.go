@@ -0,0 +1,134 @@
+// Package lmtp accepts mail over LMTP, where a mail having a signed subject wakes a device. This allows waking devices
+// from networks where only email gets through.
+package lmtp
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// maxMessageSize is the maximum size of an accepted message.
+const maxMessageSize = 1 << 20
+
+// Server is an LMTP server waking devices from the subject of received mail.
+type Server struct {
+	// Wake wakes the device identified by ref.
+	Wake     func(ref string) error
+	verifier verifier
+	now      func() time.Time
+}
+
+// New creates a new server verifying subjects using secret.
+func New(secret string, wake func(ref string) error) *Server {
+	return &Server{Wake: wake, verifier: verifier{secret: secret, seen: make(map[string]time.Time)}, now: time.Now}
+}
+
+// ListenAndServe listens on the TCP address addr and serves LMTP sessions.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves LMTP sessions accepted by l.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+// deliver wakes the device of the message read from r, and returns the reply to each recipient.
+func (s *Server) deliver(r io.Reader) string {
+	msg, err := mail.ReadMessage(io.LimitReader(r, maxMessageSize))
+	if err != nil {
+		return "554 5.6.0 Malformed message"
+	}
+	io.Copy(ioutil.Discard, msg.Body)
+	ref, err := s.verifier.verify(msg.Header.Get("Subject"), s.now())
+	if err != nil {
+		log.Printf("lmtp: rejected message from %s: %s", msg.Header.Get("From"), err)
+		return "550 5.7.1 Rejected: " + err.Error()
+	}
+	if err := s.Wake(ref); err != nil {
+		log.Printf("lmtp: failed to wake %s: %s", ref, err)
+		return "451 4.3.0 Failed to wake " + ref
+	}
+	log.Printf("lmtp: woke %s", ref)
+	return "250 2.0.0 Woke " + ref
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) bool {
+		conn.SetWriteDeadline(time.Now().Add(time.Minute))
+		return tp.PrintfLine(format, args...) == nil
+	}
+	if !reply("220 wakeup LMTP ready") {
+		return
+	}
+	var (
+		from       bool
+		recipients int
+	)
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "LHLO":
+			reply("250-wakeup")
+			reply("250-PIPELINING")
+			reply("250 SIZE %d", maxMessageSize)
+		case "MAIL":
+			from, recipients = true, 0
+			reply("250 2.1.0 OK")
+		case "RCPT":
+			if !from {
+				reply("503 5.5.1 Need MAIL first")
+				continue
+			}
+			recipients++
+			reply("250 2.1.5 OK")
+		case "DATA":
+			if recipients == 0 {
+				reply("503 5.5.1 Need RCPT first")
+				continue
+			}
+			reply("354 Start mail input; end with <CRLF>.<CRLF>")
+			status := s.deliver(bufio.NewReader(tp.DotReader()))
+			// LMTP replies once for each recipient
+			for i := 0; i < recipients; i++ {
+				reply("%s", status)
+			}
+			from, recipients = false, 0
+		case "RSET":
+			from, recipients = false, 0
+			reply("250 2.0.0 OK")
+		case "NOOP":
+			reply("250 2.0.0 OK")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("500 5.5.2 Unknown command")
+		}
+	}
+}
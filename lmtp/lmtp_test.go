@@ -0,0 +1,92 @@
+package lmtp
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	var tests = []struct {
+		subject string
+		ref     string
+		err     error
+	}{
+		{Sign("secret", "AB:CD:EF:12:34:56", now), "AB:CD:EF:12:34:56", nil},
+		{Sign("secret", "AB:CD:EF:12:34:56", now), "", ErrReusedToken},
+		{Sign("secret", "AB:CD:EF:12:34:56", now.Add(-time.Minute)), "AB:CD:EF:12:34:56", nil},
+		{Sign("secret", "AB:CD:EF:12:34:56", now.Add(-time.Hour)), "", ErrExpiredToken},
+		{Sign("secret", "AB:CD:EF:12:34:56", now.Add(time.Hour)), "", ErrExpiredToken},
+		{Sign("other", "AB:CD:EF:12:34:57", now), "", ErrInvalidToken},
+		{"wake AB:CD:EF:12:34:56 1600000000 0123", "", ErrInvalidToken},
+		{"wake AB:CD:EF:12:34:56", "", ErrInvalidToken},
+		{"Re: hello", "", ErrInvalidToken},
+		{"", "", ErrInvalidToken},
+	}
+	v := verifier{secret: "secret", seen: make(map[string]time.Time)}
+	for i, tt := range tests {
+		ref, err := v.verify(tt.subject, now)
+		if err != tt.err {
+			t.Errorf("#%d: want err %v, got %v", i, tt.err, err)
+		}
+		if ref != tt.ref {
+			t.Errorf("#%d: want ref %q, got %q", i, tt.ref, ref)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	now := time.Now()
+	var woken []string
+	s := New("secret", func(ref string) error {
+		if ref == "11:22:33:44:55:66" {
+			return errors.New("failed")
+		}
+		woken = append(woken, ref)
+		return nil
+	})
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.serve(conn)
+	tp := textproto.NewConn(client)
+	expect := func(code int, cmd string) {
+		t.Helper()
+		if cmd != "" {
+			if err := tp.PrintfLine("%s", cmd); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, _, err := tp.ReadResponse(code); err != nil {
+			t.Fatalf("%q: %s", cmd, err)
+		}
+	}
+	send := func(subject string, codes ...int) {
+		t.Helper()
+		expect(250, "MAIL FROM:<alice@example.com>")
+		for range codes {
+			expect(250, "RCPT TO:<wake@example.com>")
+		}
+		expect(354, "DATA")
+		w := tp.DotWriter()
+		w.Write([]byte("From: alice@example.com\r\nSubject: " + subject + "\r\n\r\nWake up\r\n"))
+		w.Close()
+		for _, code := range codes {
+			expect(code, "")
+		}
+	}
+	expect(220, "")
+	expect(250, "LHLO example.com")
+	expect(503, "DATA")
+	send(Sign("secret", "AB:CD:EF:12:34:56", now), 250, 250)
+	send(Sign("secret", "AB:CD:EF:12:34:56", now), 550)
+	send(Sign("wrong", "AB:CD:EF:12:34:57", now), 550)
+	send(Sign("secret", "11:22:33:44:55:66", now), 451)
+	expect(250, "NOOP")
+	expect(221, "QUIT")
+	if want := []string{"AB:CD:EF:12:34:56"}; len(woken) != 1 || woken[0] != want[0] {
+		t.Errorf("want %v woken, got %v", want, woken)
+	}
+}
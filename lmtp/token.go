@@ -0,0 +1,76 @@
+package lmtp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxSkew is the maximum age of a token, and how far into the future its timestamp may be.
+const MaxSkew = 10 * time.Minute
+
+var (
+	// ErrInvalidToken is returned when a token is malformed or its signature does not match.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned when the timestamp of a token is too old or too far into the future.
+	ErrExpiredToken = errors.New("expired token")
+	// ErrReusedToken is returned when a token has already been used.
+	ErrReusedToken = errors.New("reused token")
+)
+
+func signature(secret, ref string, t int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "wake %s %d", ref, t)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign returns a subject which wakes the device identified by ref, i.e. its ID or MAC address, at time t. The subject
+// has the format "wake <ref> <unix time> <signature>", where the signature is the hex-encoded HMAC-SHA256 of
+// "wake <ref> <unix time>" using secret.
+func Sign(secret, ref string, t time.Time) string {
+	return fmt.Sprintf("wake %s %d %s", ref, t.Unix(), signature(secret, ref, t.Unix()))
+}
+
+// verifier verifies signed subjects, and rejects subjects which have already been used.
+type verifier struct {
+	secret string
+	mu     sync.Mutex
+	seen   map[string]time.Time
+}
+
+// verify verifies subject at time now, and returns the device referred to by it.
+func (v *verifier) verify(subject string, now time.Time) (string, error) {
+	fields := strings.Fields(subject)
+	if len(fields) != 4 || !strings.EqualFold(fields[0], "wake") {
+		return "", ErrInvalidToken
+	}
+	ref, sig := fields[1], strings.ToLower(fields[3])
+	t, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(v.secret, ref, t))) {
+		return "", ErrInvalidToken
+	}
+	if d := now.Sub(time.Unix(t, 0)); d > MaxSkew || d < -MaxSkew {
+		return "", ErrExpiredToken
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for s, at := range v.seen {
+		if now.Sub(at) > 2*MaxSkew {
+			delete(v.seen, s)
+		}
+	}
+	if _, ok := v.seen[sig]; ok {
+		return "", ErrReusedToken
+	}
+	v.seen[sig] = now
+	return ref, nil
+}
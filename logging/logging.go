@@ -0,0 +1,164 @@
+// Package logging provides structured logging with levels, in logfmt or JSON.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log record.
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	}
+	return strconv.Itoa(int(l))
+}
+
+// ParseLevel parses a level, one of debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for l := Debug; l <= Error; l++ {
+		if s == l.String() {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level: %s", s)
+}
+
+const (
+	// FormatLogfmt writes each record as a line of key=value pairs.
+	FormatLogfmt = "logfmt"
+	// FormatJSON writes each record as a JSON object on its own line.
+	FormatJSON = "json"
+)
+
+// Logger writes log records at or above its level.
+type Logger struct {
+	level  Level
+	format string
+	mu     sync.Mutex
+	w      io.Writer
+	now    func() time.Time
+}
+
+// New creates a logger writing records at or above level to w, in format, which must be FormatLogfmt or FormatJSON.
+func New(w io.Writer, format string, level Level) (*Logger, error) {
+	switch format {
+	case FormatLogfmt, FormatJSON:
+	default:
+		return nil, fmt.Errorf("invalid log format: %s", format)
+	}
+	return &Logger{level: level, format: format, w: w, now: time.Now}, nil
+}
+
+// Enabled reports whether records at level are written.
+func (l *Logger) Enabled(level Level) bool { return level >= l.level }
+
+// Log writes a record holding msg at level, having the fields given as alternating keys and values in kv.
+func (l *Logger) Log(level Level, msg string, kv ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	fields := make([]interface{}, 0, 6+len(kv))
+	fields = append(fields, "time", l.now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg)
+	fields = append(fields, kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+	var buf bytes.Buffer
+	if l.format == FormatJSON {
+		writeJSON(&buf, fields)
+	} else {
+		writeLogfmt(&buf, fields)
+	}
+	buf.WriteByte('\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(buf.Bytes())
+}
+
+// Debug writes a record at level Debug.
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.Log(Debug, msg, kv...) }
+
+// Info writes a record at level Info.
+func (l *Logger) Info(msg string, kv ...interface{}) { l.Log(Info, msg, kv...) }
+
+// Warn writes a record at level Warn.
+func (l *Logger) Warn(msg string, kv ...interface{}) { l.Log(Warn, msg, kv...) }
+
+// Error writes a record at level Error.
+func (l *Logger) Error(msg string, kv ...interface{}) { l.Log(Error, msg, kv...) }
+
+// Write writes each line of p as a record at level Info, so that l can be the output of the standard logger.
+func (l *Logger) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		l.Info(line)
+	}
+	return len(p), nil
+}
+
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Duration:
+		return v.Seconds()
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+func writeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+		s := fmt.Sprint(value(fields[i+1]))
+		if s == "" || strings.ContainsAny(s, " =\"\\\n\t") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+}
+
+func writeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+		v, err := json.Marshal(value(fields[i+1]))
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(fields[i+1]))
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		format string
+		want   string
+	}{
+		{FormatLogfmt, `time=2020-01-01T12:00:00Z level=warn msg="Failed to wake" mac=AB:CD:EF:12:34:56 error="network is down" duration=0.25 status=500 quote="\"x\"" empty=""
+time=2020-01-01T12:00:00Z level=error msg=odd key=""
+`},
+		{FormatJSON, `{"time":"2020-01-01T12:00:00Z","level":"warn","msg":"Failed to wake","mac":"AB:CD:EF:12:34:56","error":"network is down","duration":0.25,"status":500,"quote":"\"x\"","empty":""}
+{"time":"2020-01-01T12:00:00Z","level":"error","msg":"odd","key":""}
+`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		l, err := New(&buf, tt.format, Warn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.now = func() time.Time { return now }
+		l.Info("Skipped")
+		l.Warn("Failed to wake", "mac", "AB:CD:EF:12:34:56", "error", errors.New("network is down"), "duration", 250*time.Millisecond,
+			"status", 500, "quote", `"x"`, "empty", "")
+		l.Error("odd", "key")
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s: want\n%s\ngot\n%s", tt.format, tt.want, got)
+		}
+	}
+	if _, err := New(nil, "xml", Info); err == nil {
+		t.Error("want error for invalid format")
+	}
+}
+
+func TestLoggerWrite(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, FormatLogfmt, Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.now = func() time.Time { return time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC) }
+	std := log.New(l, "", 0)
+	std.Print("Serving at http://0.0.0.0:8080")
+	if want := "time=2020-01-01T12:00:00Z level=info msg=\"Serving at http://0.0.0.0:8080\"\n"; buf.String() != want {
+		t.Errorf("want %q, got %q", want, buf.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, s := range []string{"debug", "info", "warn", "error"} {
+		l, err := ParseLevel(s)
+		if err != nil || l.String() != s {
+			t.Errorf("ParseLevel(%q) = %v, %v", s, l, err)
+		}
+	}
+	if _, err := ParseLevel("trace"); err == nil || err.Error() != "invalid log level: trace" {
+		t.Errorf("want error, got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+//go:build ignore
+// +build ignore
+
+// Command gen writes oui_table.go, embedding the registries of the IEEE registration authority. The registries are
+// downloaded, unless files holding them are given as arguments.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/mpolden/wakeup/macaddr"
+)
+
+func open(name string) (io.ReadCloser, error) {
+	if len(os.Args) > 1 {
+		return os.Open(name)
+	}
+	client := http.Client{Timeout: time.Minute}
+	req, err := http.NewRequest(http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "wakeup")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s: got status %d", name, res.StatusCode)
+	}
+	return res.Body, nil
+}
+
+func main() {
+	sources := macaddr.RegistryURLs
+	if len(os.Args) > 1 {
+		sources = os.Args[1:]
+	}
+	prefixes := make(map[string]string)
+	for _, name := range sources {
+		r, err := open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		v, err := macaddr.ParseRegistry(r)
+		r.Close()
+		if err != nil {
+			log.Fatalf("%s: %s", name, err)
+		}
+		for prefix, org := range v {
+			prefixes[prefix] = org
+		}
+	}
+	keys := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		keys = append(keys, prefix)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by gen.go; DO NOT EDIT.\n\npackage macaddr\n\n")
+	fmt.Fprintf(&buf, "// embeddedPrefixes maps prefixes to the organizations assigned them, as embedded at build time.\n")
+	fmt.Fprintf(&buf, "var embeddedPrefixes = map[string]string{\n")
+	for _, prefix := range keys {
+		fmt.Fprintf(&buf, "%q: %q,\n", prefix, prefixes[prefix])
+	}
+	fmt.Fprintf(&buf, "}\n")
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("oui_table.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,119 @@
+// Package macaddr parses MAC addresses as they are typed, in any of the common notations and with the digits, letters
+// and separators of other scripts and input methods, and describes the addresses parsed.
+package macaddr
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"unicode"
+)
+
+// Notations of MAC addresses.
+const (
+	// FormatColon is the IEEE notation used by most systems, e.g. AB:CD:EF:12:34:56.
+	FormatColon = "colon"
+	// FormatHyphen is the notation used by Windows, e.g. AB-CD-EF-12-34-56.
+	FormatHyphen = "hyphen"
+	// FormatDot is the notation used by Cisco, e.g. abcd.ef12.3456.
+	FormatDot = "dot"
+	// FormatBare is the notation without separators, e.g. abcdef123456.
+	FormatBare = "bare"
+)
+
+// zeros holds the zero digit of scripts whose digits are accepted in addition to ASCII digits.
+var zeros = []rune{
+	0x0660, // Arabic-Indic
+	0x06F0, // Extended Arabic-Indic
+	0x0966, // Devanagari
+	0x09E6, // Bengali
+	0x0E50, // Thai
+}
+
+// fold returns r as the ASCII character it stands for, or r if it stands for none.
+func fold(r rune) rune {
+	// Fullwidth forms, as typed with CJK input methods
+	if r >= 0xFF01 && r <= 0xFF5E {
+		return r - 0xFEE0
+	}
+	for _, zero := range zeros {
+		if r >= zero && r <= zero+9 {
+			return '0' + r - zero
+		}
+	}
+	switch r {
+	case 0x2010, 0x2011, 0x2012, 0x2013, 0x2014, 0x2015, 0x2212, 0xFE63: // Hyphens, dashes and minus signs
+		return '-'
+	case 0x02D0, 0x2236, 0xFE55: // Colon lookalikes
+		return ':'
+	case 0x3002, 0xFF61, 0x2024: // Ideographic and one dot leaders
+		return '.'
+	}
+	return r
+}
+
+// Normalize returns s with characters standing for digits, letters and separators replaced by their ASCII
+// equivalents, and with spaces and invisible characters removed.
+func Normalize(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if unicode.IsSpace(r) || unicode.Is(unicode.Cf, r) {
+			continue
+		}
+		sb.WriteRune(fold(r))
+	}
+	return sb.String()
+}
+
+// Parse parses s as a 48-bit MAC address after normalizing it, returning the address and the notation it was written
+// in.
+func Parse(s string) (net.HardwareAddr, string, error) {
+	n := Normalize(s)
+	format := FormatBare
+	switch {
+	case strings.Contains(n, ":"):
+		format = FormatColon
+	case strings.Contains(n, "-"):
+		format = FormatHyphen
+	case strings.Contains(n, "."):
+		format = FormatDot
+	}
+	if format == FormatBare {
+		if len(n) != 12 {
+			return nil, "", fmt.Errorf("invalid MAC address: %s", s)
+		}
+		parts := make([]string, 0, 6)
+		for i := 0; i < len(n); i += 2 {
+			parts = append(parts, n[i:i+2])
+		}
+		n = strings.Join(parts, ":")
+	}
+	hwAddr, err := net.ParseMAC(n)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid MAC address: %s", s)
+	}
+	if len(hwAddr) != 6 {
+		return nil, "", fmt.Errorf("invalid MAC address: %s: must have 6 octets, got %d", s, len(hwAddr))
+	}
+	return hwAddr, format, nil
+}
+
+// String returns hwAddr in the notation stored by devices, e.g. AB:CD:EF:12:34:56.
+func String(hwAddr net.HardwareAddr) string { return strings.ToUpper(hwAddr.String()) }
+
+// Multicast reports whether hwAddr is a group address, i.e. a multicast or the broadcast address, which no device
+// has as its own address and which can therefore never be woken.
+func Multicast(hwAddr net.HardwareAddr) bool { return len(hwAddr) > 0 && hwAddr[0]&0x01 != 0 }
+
+// LocallyAdministered reports whether hwAddr was assigned locally instead of by the manufacturer, as done by operating
+// systems randomizing MAC addresses.
+func LocallyAdministered(hwAddr net.HardwareAddr) bool { return len(hwAddr) > 0 && hwAddr[0]&0x02 != 0 }
+
+// Vendor returns the name of the manufacturer assigned the prefix of hwAddr in DefaultRegistry, or an empty string if
+// it is unknown. Locally administered addresses have no manufacturer.
+func Vendor(hwAddr net.HardwareAddr) string {
+	if len(hwAddr) < 3 || LocallyAdministered(hwAddr) {
+		return ""
+	}
+	return DefaultRegistry.Lookup(hwAddr)
+}
@@ -0,0 +1,126 @@
+package macaddr
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		in     string
+		out    string
+		format string
+		err    bool
+	}{
+		{"ab:cd:ef:12:34:56", "AB:CD:EF:12:34:56", FormatColon, false},
+		{"AB-CD-EF-12-34-56", "AB:CD:EF:12:34:56", FormatHyphen, false},
+		{"abcd.ef12.3456", "AB:CD:EF:12:34:56", FormatDot, false},
+		{"abcdef123456", "AB:CD:EF:12:34:56", FormatBare, false},
+		{" AB CD EF 12 34 56 ", "AB:CD:EF:12:34:56", FormatBare, false},
+		{"ＡＢ：ＣＤ：ＥＦ：１２：３４：５６", "AB:CD:EF:12:34:56", FormatColon, false},
+		{"AB‐CD–EF—12−34-56", "AB:CD:EF:12:34:56", FormatHyphen, false},
+		{"AB:CD:EF:١٢:٣٤:٥٦", "AB:CD:EF:12:34:56", FormatColon, false},
+		{"AB:CD:EF:12:34:56​", "AB:CD:EF:12:34:56", FormatColon, false},
+		{"AB:CD-EF:12:34:56", "", "", true},
+		{"abcdef12345", "", "", true},
+		{"00:00:00:00:fe:80:00:00", "", "", true},
+		{"foo", "", "", true},
+	}
+	for i, tt := range tests {
+		hwAddr, format, err := Parse(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("#%d: want error for %q", i, tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if got := String(hwAddr); got != tt.out || format != tt.format {
+			t.Errorf("#%d: want %s (%s), got %s (%s)", i, tt.out, tt.format, got, format)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	var tests = []struct {
+		in        string
+		vendor    string
+		local     bool
+		multicast bool
+	}{
+		{"00:1B:21:12:34:56", "Intel Corporate", false, false},
+		{"AB:CD:EF:12:34:56", "", true, true},
+		{"02:00:00:12:34:56", "", true, false},
+		{"01:00:5E:00:00:01", "", false, true},
+		{"FF:FF:FF:FF:FF:FF", "", true, true},
+	}
+	for i, tt := range tests {
+		hwAddr, err := net.ParseMAC(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Vendor(hwAddr); got != tt.vendor {
+			t.Errorf("#%d: want vendor %q, got %q", i, tt.vendor, got)
+		}
+		if got := LocallyAdministered(hwAddr); got != tt.local {
+			t.Errorf("#%d: want locally administered %t, got %t", i, tt.local, got)
+		}
+		if got := Multicast(hwAddr); got != tt.multicast {
+			t.Errorf("#%d: want multicast %t, got %t", i, tt.multicast, got)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	csv := "\ufeffRegistry,Assignment,Organization Name,Organization Address\n" +
+		"MA-L,70B3D5,IEEE Registration Authority,\"445 Hoes Lane Piscataway NJ US 08554\"\n" +
+		"MA-S,70B3D5123,\"Acme, Inc.\",Somewhere\n" +
+		"MA-L,zzzzzz,Invalid,\n"
+	prefixes, err := ParseRegistry(strings.NewReader(csv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefixes) != 2 || prefixes["70B3D5123"] != "Acme, Inc." {
+		t.Fatalf("unexpected prefixes %v", prefixes)
+	}
+	if _, err := ParseRegistry(strings.NewReader("foo,bar\n1,2\n")); err == nil {
+		t.Error("want error for missing columns")
+	}
+	if _, err := ParseRegistry(strings.NewReader("Assignment,Organization Name\n")); err == nil {
+		t.Error("want error for empty registry")
+	}
+	r := NewRegistry()
+	embedded := r.Len()
+	updated := time.Date(2019, 1, 2, 7, 45, 0, 0, time.UTC)
+	r.Replace(prefixes, updated)
+	var tests = []struct {
+		in     string
+		vendor string
+	}{
+		// The longest prefix wins
+		{"70:B3:D5:12:34:56", "Acme, Inc."},
+		{"70:B3:D5:45:67:89", "IEEE Registration Authority"},
+		{"00:1B:21:12:34:56", ""},
+	}
+	for i, tt := range tests {
+		hwAddr, err := net.ParseMAC(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Lookup(hwAddr); got != tt.vendor {
+			t.Errorf("#%d: want vendor %q, got %q", i, tt.vendor, got)
+		}
+	}
+	if !r.Updated().Equal(updated) {
+		t.Errorf("want updated %s, got %s", updated, r.Updated())
+	}
+	r.Reset()
+	if r.Len() != embedded || !r.Updated().IsZero() {
+		t.Errorf("want %d embedded prefixes, got %d", embedded, r.Len())
+	}
+}
@@ -0,0 +1,180 @@
+package macaddr
+
+//go:generate go run gen.go
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RegistryURLs are the locations of the registries of the IEEE registration authority, in CSV format, holding the
+// 24-bit (MA-L), 28-bit (MA-M) and 36-bit (MA-S) prefixes assigned to manufacturers.
+var RegistryURLs = []string{
+	"https://standards-oui.ieee.org/oui/oui.csv",
+	"https://standards-oui.ieee.org/oui28/mam.csv",
+	"https://standards-oui.ieee.org/oui36/oui36.csv",
+}
+
+// prefixLengths are the lengths in hex digits of the prefixes assigned, longest first.
+var prefixLengths = []int{9, 7, 6}
+
+// ParseRegistry parses a registry in the CSV format published by the IEEE registration authority, and returns the
+// name of the organization assigned each prefix, keyed by the prefix in upper-case hex digits, e.g. B827EB.
+func ParseRegistry(r io.Reader) (map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry: %w", err)
+	}
+	assignment, organization := -1, -1
+	for i, name := range header {
+		switch strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")) {
+		case "Assignment":
+			assignment = i
+		case "Organization Name":
+			organization = i
+		}
+	}
+	if assignment < 0 || organization < 0 {
+		return nil, errors.New("invalid registry: missing Assignment or Organization Name column")
+	}
+	prefixes := make(map[string]string)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid registry: %w", err)
+		}
+		if len(record) <= assignment || len(record) <= organization {
+			continue
+		}
+		prefix := strings.ToUpper(strings.TrimSpace(record[assignment]))
+		name := strings.TrimSpace(record[organization])
+		if !validPrefix(prefix) || name == "" {
+			continue
+		}
+		prefixes[prefix] = name
+	}
+	if len(prefixes) == 0 {
+		return nil, errors.New("invalid registry: no assignments")
+	}
+	return prefixes, nil
+}
+
+// WriteRegistry writes prefixes to w in the format read by ParseRegistry.
+func WriteRegistry(w io.Writer, prefixes map[string]string) error {
+	keys := make([]string, 0, len(prefixes))
+	for prefix := range prefixes {
+		keys = append(keys, prefix)
+	}
+	sort.Strings(keys)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Assignment", "Organization Name"})
+	for _, prefix := range keys {
+		cw.Write([]string{prefix, prefixes[prefix]})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func validPrefix(s string) bool {
+	switch len(s) {
+	case 6, 7, 9:
+	default:
+		return false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789ABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Registry holds the prefixes assigned to manufacturers. It starts out holding the prefixes embedded at build time,
+// and can be replaced by a registry loaded at run time.
+type Registry struct {
+	mu       sync.RWMutex
+	prefixes map[string]string
+	updated  time.Time
+}
+
+// DefaultRegistry is the registry used by Vendor.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry creates a registry holding the embedded prefixes.
+func NewRegistry() *Registry { return &Registry{prefixes: embeddedPrefixes} }
+
+// Lookup returns the name of the manufacturer assigned the longest prefix of hwAddr, or an empty string if it is
+// unknown.
+func (r *Registry) Lookup(hwAddr net.HardwareAddr) string {
+	digits := strings.ToUpper(hex.EncodeToString(hwAddr))
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, n := range prefixLengths {
+		if len(digits) < n {
+			continue
+		}
+		if name, ok := r.prefixes[digits[:n]]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// Replace replaces the prefixes of r, which were loaded at time updated.
+func (r *Registry) Replace(prefixes map[string]string, updated time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prefixes = prefixes
+	r.updated = updated
+}
+
+// Load replaces the prefixes of r with those of the registry in the file name.
+func (r *Registry) Load(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	prefixes, err := ParseRegistry(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	r.Replace(prefixes, fi.ModTime())
+	return nil
+}
+
+// Reset replaces the prefixes of r with the embedded prefixes.
+func (r *Registry) Reset() { r.Replace(embeddedPrefixes, time.Time{}) }
+
+// Len returns the number of prefixes in r.
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.prefixes)
+}
+
+// Updated returns the time the prefixes of r were loaded, or the zero time if they are the embedded prefixes.
+func (r *Registry) Updated() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.updated
+}
@@ -0,0 +1,28 @@
+package macaddr
+
+// embeddedPrefixes maps prefixes to the organizations assigned them, as embedded at build time. Only the prefixes of a
+// few common vendors are embedded by default, as the full registries span megabytes. The full registries are loaded at
+// runtime through POST /api/v1/admin/oui, or embedded by running go generate, e.g. through make oui or building the
+// image with EMBED_OUI=true, which replaces this file.
+var embeddedPrefixes = map[string]string{
+	"000393": "Apple, Inc.",
+	"000569": "VMware, Inc.",
+	"000C29": "VMware, Inc.",
+	"001132": "Synology Incorporated",
+	"001422": "Dell Inc.",
+	"00155D": "Microsoft Corporation",
+	"00163E": "Xensource, Inc.",
+	"001788": "Philips Lighting BV",
+	"001B21": "Intel Corporate",
+	"001C42": "Parallels, Inc.",
+	"001EC2": "Apple, Inc.",
+	"0024D7": "Intel Corporate",
+	"002590": "Super Micro Computer, Inc.",
+	"005056": "VMware, Inc.",
+	"00E04C": "Realtek Semiconductor Corp.",
+	"080027": "PCS Systemtechnik GmbH",
+	"AC1F6B": "Super Micro Computer, Inc.",
+	"B827EB": "Raspberry Pi Foundation",
+	"DCA632": "Raspberry Pi Trading Ltd",
+	"E45F01": "Raspberry Pi Trading Ltd",
+}
@@ -0,0 +1,370 @@
+// Package mqtt connects to an MQTT broker, where devices are woken by publishing to their wake topic, and the state of
+// devices is published as they come online and go offline. Devices are announced through Home Assistant MQTT discovery,
+// so that they show up as switches.
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+const (
+	timeout = 10 * time.Second
+	// maxBackoff is the maximum time waited before reconnecting to the broker.
+	maxBackoff = time.Minute
+)
+
+// Payloads of availability and state topics.
+const (
+	online  = "online"
+	offline = "offline"
+)
+
+// Device is a device published to the broker.
+type Device struct {
+	ID         string
+	Name       string
+	MACAddress string
+	// Observed is whether the device has been observed, i.e. whether Online is known.
+	Observed bool
+	Online   bool
+}
+
+// Client is a client of an MQTT broker, waking devices whose wake topic is published to and publishing the state of
+// devices. Topics are named <prefix>/<device>/wake and <prefix>/<device>/state, where <device> is the ID of a device
+// in published states and the ID, MAC address or name of a device in wake commands.
+type Client struct {
+	// Address is the host and port of the broker.
+	Address string
+	// TLS is the configuration of TLS connections to the broker, or nil to connect without TLS.
+	TLS      *tls.Config
+	Username string
+	Password string
+	ClientID string
+	// Prefix is the prefix of topics.
+	Prefix string
+	// DiscoveryPrefix is the prefix of Home Assistant discovery topics, or empty to not announce devices.
+	DiscoveryPrefix string
+	KeepAlive       time.Duration
+	// Wake wakes the device identified by ref, and Shutdown powers it off. Devices are not powered off if Shutdown is
+	// nil.
+	Wake     func(ref string) error
+	Shutdown func(ref string) error
+	// Devices returns the devices announced to the broker.
+	Devices func() ([]Device, error)
+
+	mu        sync.Mutex
+	conn      net.Conn
+	announced map[string]bool
+	dial      func() (net.Conn, error)
+}
+
+// New creates a client of the broker at the URL broker, whose scheme is mqtt or tcp for plain connections, and mqtts, ssl
+// or tls for TLS connections. Credentials are read from the URL, if it has any.
+func New(broker string, wake func(ref string) error, devices func() ([]Device, error)) (*Client, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		Prefix:          "wakeup",
+		DiscoveryPrefix: "homeassistant",
+		KeepAlive:       time.Minute,
+		Wake:            wake,
+		Devices:         devices,
+	}
+	port := "1883"
+	switch u.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl", "tls":
+		port = "8883"
+		c.TLS = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("invalid scheme of broker: %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid broker: %q", broker)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	c.Address = net.JoinHostPort(u.Hostname(), port)
+	if u.User != nil {
+		c.Username = u.User.Username()
+		c.Password, _ = u.User.Password()
+	}
+	hostname, _ := os.Hostname()
+	c.ClientID = strings.Trim("wakeup-"+hostname, "-")
+	return c, nil
+}
+
+func (c *Client) topic(parts ...string) string {
+	return strings.Join(append([]string{c.Prefix}, parts...), "/")
+}
+
+func (c *Client) connect() (net.Conn, error) {
+	if c.dial != nil {
+		return c.dial()
+	}
+	d := &net.Dialer{Timeout: timeout}
+	if c.TLS != nil {
+		return tls.DialWithDialer(d, "tcp", c.Address, c.TLS)
+	}
+	return d.Dial("tcp", c.Address)
+}
+
+// Run connects to the broker and publishes the state of devices changed by events, until events is closed. The client
+// reconnects with exponential backoff if the connection is lost.
+func (c *Client) Run(events <-chan event.Event) {
+	backoff := time.Second
+	for {
+		connected, err := c.session(events)
+		if err == nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+		log.Printf("mqtt: %s: %s, reconnecting in %s", c.Address, err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// session connects to the broker and serves the connection until it fails or events is closed. The returned bool is
+// true if the broker accepted the connection.
+func (c *Client) session(events <-chan event.Event) (bool, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(timeout))
+	// The broker marks the client unavailable if the connection is lost
+	will := message{topic: c.topic("status"), payload: []byte(offline), retain: true}
+	if err := writePacket(conn, connectPacket(c.ClientID, c.Username, c.Password, uint16(c.KeepAlive/time.Second), will)); err != nil {
+		return false, err
+	}
+	p, err := readPacket(r)
+	if err != nil {
+		return false, err
+	}
+	if err := connackError(p); err != nil {
+		return false, err
+	}
+	conn.SetDeadline(time.Time{})
+	c.mu.Lock()
+	c.conn = conn
+	c.announced = make(map[string]bool)
+	c.mu.Unlock()
+	errs := make(chan error, 1)
+	go func() { errs <- c.read(conn, r) }()
+	if err := c.write(subscribePacket(1, c.topic("+", "wake"))); err != nil {
+		return true, err
+	}
+	if err := c.announce(); err != nil {
+		return true, err
+	}
+	ping := time.NewTicker(c.KeepAlive / 2)
+	defer ping.Stop()
+	for {
+		select {
+		case err := <-errs:
+			return true, err
+		case e, ok := <-events:
+			if !ok {
+				c.publish(message{topic: c.topic("status"), payload: []byte(offline), retain: true})
+				c.write(packet{typ: typeDisconnect})
+				return true, nil
+			}
+			if err := c.handle(e); err != nil {
+				return true, err
+			}
+		case <-ping.C:
+			if err := c.write(packet{typ: typePingreq}); err != nil {
+				return true, err
+			}
+		}
+	}
+}
+
+func (c *Client) write(p packet) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	return writePacket(c.conn, p)
+}
+
+func (c *Client) publish(m message) error { return c.write(publishPacket(m)) }
+
+// read reads packets from the broker on conn, waking devices whose wake topic is published to.
+func (c *Client) read(conn net.Conn, r *bufio.Reader) error {
+	for {
+		// The broker answers pings sent every half keep-alive, so a silent broker has lost the connection
+		conn.SetReadDeadline(time.Now().Add(c.KeepAlive))
+		p, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+		switch p.typ {
+		case typePublish:
+			m, err := parsePublish(p)
+			if err != nil {
+				return err
+			}
+			if m.qos > 0 {
+				if err := c.write(packet{typ: typePuback, body: []byte{byte(m.id >> 8), byte(m.id)}}); err != nil {
+					return err
+				}
+			}
+			go c.command(m)
+		case typeSuback:
+			if n := len(p.body); n < 3 || p.body[n-1] == 0x80 {
+				return fmt.Errorf("subscription to %s refused", c.topic("+", "wake"))
+			}
+		}
+	}
+}
+
+// command wakes the device of the wake topic published to in m, or powers it off if the payload turns off its switch.
+func (c *Client) command(m message) {
+	parts := strings.Split(strings.TrimPrefix(m.topic, c.Prefix+"/"), "/")
+	if len(parts) != 2 || parts[1] != "wake" || parts[0] == "" {
+		return
+	}
+	if strings.EqualFold(strings.TrimSpace(string(m.payload)), "OFF") {
+		if c.Shutdown == nil {
+			return
+		}
+		if err := c.Shutdown(parts[0]); err != nil {
+			log.Printf("mqtt: could not power off %s: %s", parts[0], err)
+		}
+		return
+	}
+	if err := c.Wake(parts[0]); err != nil {
+		log.Printf("mqtt: could not wake %s: %s", parts[0], err)
+	}
+}
+
+// announce marks the client available, and announces all devices together with their state.
+func (c *Client) announce() error {
+	if err := c.publish(message{topic: c.topic("status"), payload: []byte(online), retain: true}); err != nil {
+		return err
+	}
+	devices, err := c.Devices()
+	if err != nil {
+		log.Printf("mqtt: could not announce devices: %s", err)
+		return nil
+	}
+	for _, d := range devices {
+		if err := c.discover(d); err != nil {
+			return err
+		}
+		if d.Observed {
+			if err := c.state(d.ID, d.Online); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handle publishes the state of the device changed by e, announcing the device first if it has not been announced.
+func (c *Client) handle(e event.Event) error {
+	if e.Device == "" || (e.Type != event.Online && e.Type != event.Offline) {
+		return nil
+	}
+	c.mu.Lock()
+	announced := c.announced[e.Device]
+	c.mu.Unlock()
+	if !announced {
+		if err := c.discover(Device{ID: e.Device, Name: e.Name, MACAddress: e.MACAddress}); err != nil {
+			return err
+		}
+	}
+	return c.state(e.Device, e.Type == event.Online)
+}
+
+func (c *Client) state(id string, isOnline bool) error {
+	payload := offline
+	if isOnline {
+		payload = online
+	}
+	return c.publish(message{topic: c.topic(id, "state"), payload: []byte(payload), retain: true})
+}
+
+type discovery struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	CommandTopic      string          `json:"command_topic"`
+	StateTopic        string          `json:"state_topic"`
+	StateOn           string          `json:"state_on"`
+	StateOff          string          `json:"state_off"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	Icon              string          `json:"icon"`
+	Device            discoveryDevice `json:"device"`
+}
+
+type discoveryDevice struct {
+	Identifiers []string   `json:"identifiers"`
+	Connections [][]string `json:"connections,omitempty"`
+	Name        string     `json:"name"`
+}
+
+// objectID returns id as a discovery object ID, which may only hold letters, digits, underscores and hyphens.
+func objectID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+// discover announces d as a switch to Home Assistant, unless discovery is disabled.
+func (c *Client) discover(d Device) error {
+	c.mu.Lock()
+	c.announced[d.ID] = true
+	c.mu.Unlock()
+	if c.DiscoveryPrefix == "" {
+		return nil
+	}
+	id := objectID(c.Prefix + "_" + d.ID)
+	name := d.Name
+	if name == "" {
+		name = d.MACAddress
+	}
+	config := discovery{
+		Name:              name,
+		UniqueID:          id,
+		CommandTopic:      c.topic(d.ID, "wake"),
+		StateTopic:        c.topic(d.ID, "state"),
+		StateOn:           online,
+		StateOff:          offline,
+		AvailabilityTopic: c.topic("status"),
+		Icon:              "mdi:power",
+		Device:            discoveryDevice{Identifiers: []string{id}, Name: name},
+	}
+	if d.MACAddress != "" {
+		config.Device.Connections = [][]string{{"mac", strings.ToLower(d.MACAddress)}}
+	}
+	payload, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return c.publish(message{topic: strings.Join([]string{c.DiscoveryPrefix, "switch", id, "config"}, "/"), payload: payload, retain: true})
+}
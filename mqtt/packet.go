@@ -0,0 +1,196 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Packet types of MQTT 3.1.1.
+const (
+	typeConnect    = 1
+	typeConnack    = 2
+	typePublish    = 3
+	typePuback     = 4
+	typeSubscribe  = 8
+	typeSuback     = 9
+	typePingreq    = 12
+	typePingresp   = 13
+	typeDisconnect = 14
+)
+
+// maxPacketSize is the maximum size of a received packet.
+const maxPacketSize = 1 << 20
+
+// packet is a control packet, holding the flags of its fixed header and its body.
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// connackErrors are the reasons given by the broker for refusing a connection.
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+func writePacket(w io.Writer, p packet) error {
+	buf := []byte{p.typ<<4 | p.flags}
+	// The remaining length is encoded in 7 bits per byte, where the high bit marks that more bytes follow
+	n := len(p.body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(buf, p.body...))
+	return err
+}
+
+func readPacket(r *bufio.Reader) (packet, error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	n, mul := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, errors.New("malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		n += int(b&0x7f) * mul
+		if b&0x80 == 0 {
+			break
+		}
+		mul *= 128
+	}
+	if n > maxPacketSize {
+		return packet{}, fmt.Errorf("packet of %d bytes exceeds maximum size", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{typ: h >> 4, flags: h & 0x0f, body: body}, nil
+}
+
+// message is a message published to a topic.
+type message struct {
+	topic   string
+	payload []byte
+	retain  bool
+	qos     byte
+	id      uint16
+}
+
+func connectPacket(clientID, username, password string, keepAlive uint16, will message) packet {
+	flags := byte(0x02) // Clean session
+	if will.topic != "" {
+		flags |= 0x04
+		if will.retain {
+			flags |= 0x20
+		}
+	}
+	if username != "" {
+		flags |= 0x80
+		if password != "" {
+			flags |= 0x40
+		}
+	}
+	b := appendString(nil, "MQTT")
+	b = append(b, 4, flags, byte(keepAlive>>8), byte(keepAlive))
+	b = appendString(b, clientID)
+	if will.topic != "" {
+		b = appendString(b, will.topic)
+		b = appendString(b, string(will.payload))
+	}
+	if username != "" {
+		b = appendString(b, username)
+		if password != "" {
+			b = appendString(b, password)
+		}
+	}
+	return packet{typ: typeConnect, body: b}
+}
+
+func connackError(p packet) error {
+	if p.typ != typeConnack || len(p.body) < 2 {
+		return fmt.Errorf("unexpected packet of type %d, want connack", p.typ)
+	}
+	if code := p.body[1]; code != 0 {
+		if reason, ok := connackErrors[code]; ok {
+			return fmt.Errorf("connection refused: %s", reason)
+		}
+		return fmt.Errorf("connection refused: code %d", code)
+	}
+	return nil
+}
+
+func publishPacket(m message) packet {
+	flags := m.qos << 1
+	if m.retain {
+		flags |= 0x01
+	}
+	b := appendString(nil, m.topic)
+	if m.qos > 0 {
+		b = append(b, byte(m.id>>8), byte(m.id))
+	}
+	return packet{typ: typePublish, flags: flags, body: append(b, m.payload...)}
+}
+
+func parsePublish(p packet) (message, error) {
+	m := message{retain: p.flags&0x01 != 0, qos: (p.flags >> 1) & 0x03}
+	topic, rest, err := readString(p.body)
+	if err != nil {
+		return m, err
+	}
+	m.topic = topic
+	if m.qos > 0 {
+		if len(rest) < 2 {
+			return m, io.ErrUnexpectedEOF
+		}
+		m.id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	m.payload = rest
+	return m, nil
+}
+
+func subscribePacket(id uint16, filter string) packet {
+	b := []byte{byte(id >> 8), byte(id)}
+	b = appendString(b, filter)
+	// Commands are received at most once, as a repeated wake is harmless but a late one is not useful
+	b = append(b, 0)
+	return packet{typ: typeSubscribe, flags: 0x02, body: b}
+}
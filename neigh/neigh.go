@@ -0,0 +1,101 @@
+// Package neigh looks up the link-layer addresses of neighbours of this host, i.e. the ARP table for IPv4 and the
+// neighbour discovery cache for IPv6.
+package neigh
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// arpTable is the ARP table of Linux.
+const arpTable = "/proc/net/arp"
+
+// arpComplete is the flag of a complete entry in the ARP table.
+const arpComplete = 0x2
+
+// Table maps IP addresses of neighbours to their MAC addresses.
+type Table map[string]net.HardwareAddr
+
+// Lookup returns the MAC address of ip, if ip is a known neighbour.
+func (t Table) Lookup(ip net.IP) (net.HardwareAddr, bool) {
+	mac, ok := t[ip.String()]
+	return mac, ok
+}
+
+// ParseARP parses the ARP table in the format of /proc/net/arp. Incomplete entries are ignored.
+func ParseARP(r io.Reader, t Table) error {
+	s := bufio.NewScanner(r)
+	s.Scan() // Header
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		flags, err := strconv.ParseInt(fields[2], 0, 64)
+		if err != nil || flags&arpComplete == 0 {
+			continue
+		}
+		add(t, fields[0], fields[3])
+	}
+	return s.Err()
+}
+
+// ParseNeigh parses the output of ip neigh show, e.g. "fe80::1 dev eth0 lladdr 52:54:00:12:34:56 REACHABLE". Failed
+// and incomplete entries, which lack a link-layer address, are ignored.
+func ParseNeigh(r io.Reader, t Table) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		for i := 1; i < len(fields)-1; i++ {
+			if fields[i] == "lladdr" {
+				add(t, fields[0], fields[i+1])
+				break
+			}
+		}
+	}
+	return s.Err()
+}
+
+func add(t Table, ip, mac string) {
+	addr := net.ParseIP(ip)
+	hw, err := net.ParseMAC(mac)
+	if addr == nil || err != nil || isZero(hw) {
+		return
+	}
+	t[addr.String()] = hw
+}
+
+func isZero(mac net.HardwareAddr) bool {
+	for _, b := range mac {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Read reads the neighbours of this host from the ARP table and, if the ip command is available, the IPv6 neighbour
+// cache.
+func Read() (Table, error) {
+	t := make(Table)
+	f, err := os.Open(arpTable)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := ParseARP(f, t); err != nil {
+		return nil, err
+	}
+	if out, err := exec.Command("ip", "-6", "neigh", "show").Output(); err == nil {
+		if err := ParseNeigh(bytes.NewReader(out), t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,44 @@
+package neigh
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	arp := `IP address       HW type     Flags       HW address            Mask     Device
+192.168.1.2      0x1         0x2         ab:cd:ef:12:34:56     *        eth0
+192.168.1.3      0x1         0x0         00:00:00:00:00:00     *        eth0
+192.168.1.4      0x1         0x6         AB:CD:EF:12:34:57     *        eth0
+`
+	neigh := `fe80::1 dev eth0 lladdr ab:cd:ef:12:34:58 router REACHABLE
+fe80::2 dev eth0  FAILED
+2001:db8::0:3 dev eth0 lladdr ab:cd:ef:12:34:59 STALE
+`
+	table := make(Table)
+	if err := ParseARP(strings.NewReader(arp), table); err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseNeigh(strings.NewReader(neigh), table); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		ip  string
+		mac string
+	}{
+		{"192.168.1.2", "ab:cd:ef:12:34:56"},
+		{"192.168.1.3", ""},
+		{"192.168.1.4", "ab:cd:ef:12:34:57"},
+		{"fe80::1", "ab:cd:ef:12:34:58"},
+		{"fe80::2", ""},
+		{"2001:db8::3", "ab:cd:ef:12:34:59"},
+		{"192.168.1.5", ""},
+	}
+	for i, tt := range tests {
+		mac, ok := table.Lookup(net.ParseIP(tt.ip))
+		if ok != (tt.mac != "") || (ok && mac.String() != tt.mac) {
+			t.Errorf("#%d: want %q for %s, got %q", i, tt.mac, tt.ip, mac)
+		}
+	}
+}
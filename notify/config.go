@@ -0,0 +1,113 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/mpolden/wakeup/query"
+)
+
+type policyConfig struct {
+	Mode       string `json:"mode"`
+	DigestAt   string `json:"digestAt"`
+	QuietHours string `json:"quietHours"`
+	MinOffline string `json:"minOffline"`
+	Dedup      string `json:"dedup"`
+	Filter     string `json:"filter"`
+}
+
+type sinkConfig struct {
+	Type   string       `json:"type"`
+	URL    string       `json:"url"`
+	Secret string       `json:"secret"`
+	Token  string       `json:"token"`
+	ChatID string       `json:"chatId"`
+	Policy policyConfig `json:"policy"`
+}
+
+type config struct {
+	Sinks []sinkConfig `json:"sinks"`
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (c policyConfig) policy() (Policy, error) {
+	var p Policy
+	switch c.Mode {
+	case "", "immediate":
+	case "digest":
+		p.Digest = true
+		at := c.DigestAt
+		if at == "" {
+			at = "08:00"
+		}
+		d, err := ParseTimeOfDay(at)
+		if err != nil {
+			return p, err
+		}
+		p.DigestAt = d
+	default:
+		return p, fmt.Errorf("invalid mode: %s", c.Mode)
+	}
+	if c.QuietHours != "" {
+		if err := p.ParseQuietHours(c.QuietHours); err != nil {
+			return p, err
+		}
+	}
+	var err error
+	if p.MinOffline, err = parseDuration(c.MinOffline); err != nil {
+		return p, err
+	}
+	if p.Dedup, err = parseDuration(c.Dedup); err != nil {
+		return p, err
+	}
+	if c.Filter != "" {
+		if p.Filter, err = query.Parse(c.Filter, EventSchema); err != nil {
+			return p, fmt.Errorf("invalid filter: %s", err)
+		}
+	}
+	return p, nil
+}
+
+// ReadConfig reads sinks and their policies from the JSON file at name, and returns a notifier using them.
+func ReadConfig(name string) (*Notifier, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	n := New()
+	for i, sc := range c.Sinks {
+		p, err := sc.Policy.policy()
+		if err != nil {
+			return nil, fmt.Errorf("sink #%d: %s", i, err)
+		}
+		var sink Sink
+		switch sc.Type {
+		case "webhook":
+			sink = &Webhook{URL: sc.URL, Secret: sc.Secret}
+		case "slack":
+			sink = &Slack{URL: sc.URL}
+		case "discord":
+			sink = &Discord{URL: sc.URL}
+		case "ntfy":
+			sink = &Ntfy{URL: sc.URL, Token: sc.Token}
+		case "telegram":
+			sink = &Telegram{Token: sc.Token, ChatID: sc.ChatID}
+		default:
+			return nil, fmt.Errorf("sink #%d: invalid type: %s", i, sc.Type)
+		}
+		n.Add(sink, p)
+	}
+	return n, nil
+}
@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+// Sink delivers notifications about events.
+type Sink interface {
+	Send(events []event.Event) error
+}
+
+// Delivery failing for a sink is retried with an exponential backoff between these.
+const (
+	minBackoff = time.Minute
+	maxBackoff = time.Hour
+)
+
+type sinkState struct {
+	sink       Sink
+	policy     Policy
+	delayed    map[string]event.Event
+	queue      []event.Event
+	lastSent   map[string]time.Time
+	lastDigest time.Time
+	failures   int
+	retryAt    time.Time
+}
+
+// backoff returns the time to wait before retrying delivery after n consecutive failures.
+func backoff(n int) time.Duration {
+	d := minBackoff
+	for j := 1; j < n && d < maxBackoff; j++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// Notifier delivers events to sinks according to their policies.
+type Notifier struct {
+	sinks []*sinkState
+	now   func() time.Time
+	mu    sync.Mutex
+}
+
+// New creates a new notifier without any sinks.
+func New() *Notifier { return &Notifier{now: time.Now} }
+
+// Add adds sink with policy to the notifier.
+func (n *Notifier) Add(sink Sink, policy Policy) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sinks = append(n.sinks, &sinkState{
+		sink:       sink,
+		policy:     policy,
+		delayed:    make(map[string]event.Event),
+		lastSent:   make(map[string]time.Time),
+		lastDigest: n.now(),
+	})
+}
+
+// Handle processes event e, delivering it to sinks whose policy allow immediate delivery.
+func (n *Notifier) Handle(e event.Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := n.now()
+	for _, s := range n.sinks {
+		s.handle(e, now)
+		s.deliver(now)
+	}
+}
+
+// Flush delivers any events that have become deliverable since they were handled, e.g. because quiet hours ended.
+func (n *Notifier) Flush() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := n.now()
+	for _, s := range n.sinks {
+		for mac, e := range s.delayed {
+			if now.Sub(e.Time) >= s.policy.MinOffline {
+				delete(s.delayed, mac)
+				s.enqueue(e, now)
+			}
+		}
+		s.deliver(now)
+	}
+}
+
+// Run handles events until the events channel is closed. Deliverable events are flushed every interval.
+func (n *Notifier) Run(events <-chan event.Event, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			n.Handle(e)
+		case <-ticker.C:
+			n.Flush()
+		}
+	}
+}
+
+func (s *sinkState) handle(e event.Event, now time.Time) {
+	if s.policy.Filter != nil && !s.policy.Filter.Match(eventRecord(e), now) {
+		return
+	}
+	if s.policy.MinOffline > 0 {
+		switch e.Type {
+		case event.Offline:
+			s.delayed[e.MACAddress] = e
+			return
+		case event.Online:
+			if _, ok := s.delayed[e.MACAddress]; ok {
+				// Device came back before we alerted on it going offline
+				delete(s.delayed, e.MACAddress)
+				return
+			}
+		}
+	}
+	s.enqueue(e, now)
+}
+
+func (s *sinkState) enqueue(e event.Event, now time.Time) {
+	key := e.Type + "/" + e.MACAddress
+	if last, ok := s.lastSent[key]; ok && now.Sub(last) < s.policy.Dedup {
+		return
+	}
+	s.lastSent[key] = now
+	s.queue = append(s.queue, e)
+}
+
+func (s *sinkState) deliver(now time.Time) {
+	if len(s.queue) == 0 || now.Before(s.retryAt) {
+		return
+	}
+	if s.policy.Digest {
+		digestAt := s.policy.DigestAt.on(now)
+		if now.Before(digestAt) || !s.lastDigest.Before(digestAt) {
+			return
+		}
+	} else if s.policy.quiet(now) {
+		return
+	}
+	if err := s.sink.Send(s.queue); err != nil {
+		// Keep events, delivery is retried on the first flush after backing off
+		s.failures++
+		s.retryAt = now.Add(backoff(s.failures))
+		log.Printf("notify: failed to deliver %d event(s), retrying in %s: %s", len(s.queue), backoff(s.failures), err)
+		return
+	}
+	s.queue = nil
+	s.lastDigest = now
+	s.failures, s.retryAt = 0, time.Time{}
+}
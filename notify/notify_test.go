@@ -0,0 +1,262 @@
+package notify
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/query"
+)
+
+type testSink struct {
+	sent [][]event.Event
+	err  error
+	n    int
+}
+
+func (s *testSink) Send(events []event.Event) error {
+	s.n++
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, append([]event.Event(nil), events...))
+	return nil
+}
+
+func newTestNotifier(policy Policy, now *time.Time) (*Notifier, *testSink) {
+	n := New()
+	n.now = func() time.Time { return *now }
+	sink := &testSink{}
+	n.Add(sink, policy)
+	return n, sink
+}
+
+func TestQuietHours(t *testing.T) {
+	now := time.Date(2019, 1, 1, 23, 0, 0, 0, time.UTC)
+	var p Policy
+	if err := p.ParseQuietHours("22:00-07:00"); err != nil {
+		t.Fatal(err)
+	}
+	n, sink := newTestNotifier(p, &now)
+
+	n.Handle(event.Event{Type: event.Offline, MACAddress: "AB:CD:EF:12:34:56", Time: now})
+	if len(sink.sent) != 0 {
+		t.Fatal("want no events delivered during quiet hours")
+	}
+	now = now.Add(8 * time.Hour)
+	n.Flush()
+	if len(sink.sent) != 1 || len(sink.sent[0]) != 1 {
+		t.Fatalf("want held back event delivered when quiet hours end, got %v", sink.sent)
+	}
+}
+
+func TestMinOffline(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	n, sink := newTestNotifier(Policy{MinOffline: 5 * time.Minute}, &now)
+
+	// Flapping device is not reported
+	n.Handle(event.Event{Type: event.Offline, MACAddress: "AB:CD:EF:12:34:56", Time: now})
+	now = now.Add(time.Minute)
+	n.Handle(event.Event{Type: event.Online, MACAddress: "AB:CD:EF:12:34:56", Time: now})
+	now = now.Add(10 * time.Minute)
+	n.Flush()
+	if len(sink.sent) != 0 {
+		t.Fatalf("want no events delivered, got %v", sink.sent)
+	}
+
+	n.Handle(event.Event{Type: event.Offline, MACAddress: "AB:CD:EF:12:34:56", Time: now})
+	now = now.Add(5 * time.Minute)
+	n.Flush()
+	if len(sink.sent) != 1 || sink.sent[0][0].Type != event.Offline {
+		t.Fatalf("want offline event delivered, got %v", sink.sent)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	n, sink := newTestNotifier(Policy{Dedup: 10 * time.Minute}, &now)
+	for i := 0; i < 3; i++ {
+		n.Handle(event.Event{Type: event.Wake, MACAddress: "AB:CD:EF:12:34:56", Time: now})
+		now = now.Add(time.Minute)
+	}
+	n.Handle(event.Event{Type: event.Wake, MACAddress: "AB:CD:EF:12:34:57", Time: now})
+	if len(sink.sent) != 2 {
+		t.Fatalf("want 2 deliveries, got %d", len(sink.sent))
+	}
+}
+
+func TestFilter(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	filter, err := query.Parse("type=offline AND name~nas", EventSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, sink := newTestNotifier(Policy{Filter: filter}, &now)
+	n.Handle(event.Event{Type: event.Offline, MACAddress: "AB:CD:EF:12:34:56", Name: "desktop", Time: now})
+	n.Handle(event.Event{Type: event.Online, MACAddress: "AB:CD:EF:12:34:57", Name: "nas", Time: now})
+	n.Handle(event.Event{Type: event.Offline, MACAddress: "AB:CD:EF:12:34:57", Name: "nas", Time: now})
+	if len(sink.sent) != 1 || sink.sent[0][0].Name != "nas" || sink.sent[0][0].Type != event.Offline {
+		t.Fatalf("want offline event of nas delivered, got %v", sink.sent)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	n, sink := newTestNotifier(Policy{Digest: true, DigestAt: 8 * 60}, &now)
+	n.Handle(event.Event{Type: event.Wake, MACAddress: "AB:CD:EF:12:34:56", Time: now})
+	n.Handle(event.Event{Type: event.Online, MACAddress: "AB:CD:EF:12:34:56", Time: now})
+	now = now.Add(12 * time.Hour)
+	n.Flush()
+	if len(sink.sent) != 0 {
+		t.Fatal("want no digest before digest time")
+	}
+	now = now.Add(8 * time.Hour)
+	n.Flush()
+	n.Flush()
+	if len(sink.sent) != 1 || len(sink.sent[0]) != 2 {
+		t.Fatalf("want 1 digest with 2 events, got %v", sink.sent)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	n, sink := newTestNotifier(Policy{}, &now)
+	sink.err = errors.New("unavailable")
+	n.Handle(event.Event{Type: event.Wake, MACAddress: "AB:CD:EF:12:34:56", Time: now})
+	for _, d := range []time.Duration{30 * time.Second, 30 * time.Second, time.Minute, 2 * time.Minute, time.Minute} {
+		now = now.Add(d)
+		n.Flush()
+	}
+	// Delivery failed at 0, and was retried after 1 and 3 minutes
+	if sink.n != 3 {
+		t.Errorf("want 3 attempts, got %d", sink.n)
+	}
+	sink.err = nil
+	now = now.Add(4 * time.Minute)
+	n.Handle(event.Event{Type: event.Online, MACAddress: "AB:CD:EF:12:34:56", Time: now})
+	if len(sink.sent) != 1 || len(sink.sent[0]) != 2 {
+		t.Fatalf("want events delivered after backing off, got %v", sink.sent)
+	}
+	if d := backoff(20); d != maxBackoff {
+		t.Errorf("want backoff capped at %s, got %s", maxBackoff, d)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	var (
+		header http.Header
+		body   string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		header, body = r.Header, string(data)
+	}))
+	defer server.Close()
+	events := []event.Event{{Type: event.Online, MACAddress: "AB:CD:EF:12:34:56", Name: "nas", Time: time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)}}
+	var tests = []struct {
+		sink   Sink
+		body   string
+		header string
+		value  string
+	}{
+		{&Webhook{URL: server.URL}, `{"events":[{"type":"online","macAddress":"AB:CD:EF:12:34:56","name":"nas","time":"2019-01-01T12:00:00Z"}]}`, "X-Wakeup-Signature", ""},
+		{&Webhook{URL: server.URL, Secret: "secret"}, `{"events":[{"type":"online","macAddress":"AB:CD:EF:12:34:56","name":"nas","time":"2019-01-01T12:00:00Z"}]}`, "X-Wakeup-Signature", "sha256=b94edc24cdbbd95dda22c69272aaa306acec4cfd0bc6c7a2049e294c707be5da"},
+		{&Slack{URL: server.URL}, `{"text":"2019-01-01 12:00: nas (AB:CD:EF:12:34:56) is online"}`, "Content-Type", "application/json"},
+		{&Discord{URL: server.URL}, `{"content":"2019-01-01 12:00: nas (AB:CD:EF:12:34:56) is online"}`, "Content-Type", "application/json"},
+		{&Ntfy{URL: server.URL, Token: "tk_1"}, "2019-01-01 12:00: nas (AB:CD:EF:12:34:56) is online", "Authorization", "Bearer tk_1"},
+	}
+	for i, tt := range tests {
+		if err := tt.sink.Send(events); err != nil {
+			t.Fatal(err)
+		}
+		if body != tt.body {
+			t.Errorf("#%d: want body %s, got %s", i, tt.body, body)
+		}
+		if got := header.Get(tt.header); got != tt.value {
+			t.Errorf("#%d: want %s %q, got %q", i, tt.header, tt.value, got)
+		}
+	}
+}
+
+func TestSinkErrorRedactsURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	sink := &Telegram{Token: "123:secret", ChatID: "1", apiURL: server.URL}
+	err := sink.Send([]event.Event{{Type: event.Online, MACAddress: "AB:CD:EF:12:34:56"}})
+	if want := server.URL + ": got status 401"; err == nil || err.Error() != want {
+		t.Errorf("want %q, got %v", want, err)
+	}
+	server.Close()
+	if err := sink.Send(nil); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("want error without token, got %v", err)
+	}
+}
+
+func TestMessage(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	events := []event.Event{
+		{Type: event.Wake, MACAddress: "AB:CD:EF:12:34:56", Name: "nas", Time: now},
+		{Type: event.Offline, MACAddress: "AB:CD:EF:12:34:57", Time: now},
+		{Type: event.Unknown, MACAddress: "AB:CD:EF:12:34:58", Time: now, Actor: "key:guest", Client: "192.0.2.1"},
+		{Type: event.Unknown, MACAddress: "AB:CD:EF:12:34:59", Time: now, Actor: "relay"},
+		{Type: event.Added, MACAddress: "AB:CD:EF:12:34:60", Name: "nas", Time: now, Actor: "admin"},
+	}
+	want := "2019-01-01 12:00: Woke nas (AB:CD:EF:12:34:56)\n2019-01-01 12:00: AB:CD:EF:12:34:57 is offline\n" +
+		"2019-01-01 12:00: Wake of unknown device AB:CD:EF:12:34:58 requested by key:guest from 192.0.2.1\n" +
+		"2019-01-01 12:00: Wake of unknown device AB:CD:EF:12:34:59 requested by relay\n" +
+		"2019-01-01 12:00: Added nas (AB:CD:EF:12:34:60) by admin"
+	if got := Message(events); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	conf := `{"sinks":[{"type":"telegram","token":"t","chatId":"1","policy":{"mode":"digest","digestAt":"07:30","quietHours":"22:00-07:00","minOffline":"5m","dedup":"10m"}}]}`
+	if _, err := f.WriteString(conf); err != nil {
+		t.Fatal(err)
+	}
+	n, err := ReadConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := n.sinks[0].policy
+	want := Policy{Digest: true, DigestAt: 7*60 + 30, Quiet: true, QuietStart: 22 * 60, QuietEnd: 7 * 60, MinOffline: 5 * time.Minute, Dedup: 10 * time.Minute}
+	if p != want {
+		t.Errorf("want %+v, got %+v", want, p)
+	}
+	if err := ioutil.WriteFile(f.Name(), []byte(`{"sinks":[{"type":"webhook","policy":{"filter":"kind=offline"}}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadConfig(f.Name()); err == nil || err.Error() != "sink #0: invalid filter: unknown field: kind" {
+		t.Errorf("want invalid filter, got %v", err)
+	}
+	conf = `{"sinks":[{"type":"webhook","url":"http://example.com","secret":"s"},{"type":"slack","url":"http://example.com"},{"type":"discord","url":"http://example.com"},{"type":"ntfy","url":"https://ntfy.sh/nas","token":"tk_1"}]}`
+	if err := ioutil.WriteFile(f.Name(), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = ReadConfig(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if w, ok := n.sinks[0].sink.(*Webhook); !ok || w.Secret != "s" {
+		t.Errorf("want webhook with secret, got %+v", n.sinks[0].sink)
+	}
+	if s, ok := n.sinks[3].sink.(*Ntfy); !ok || s.Token != "tk_1" {
+		t.Errorf("want ntfy with token, got %+v", n.sinks[3].sink)
+	}
+}
@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+	"github.com/mpolden/wakeup/query"
+)
+
+// TimeOfDay is a time of day, in minutes since midnight.
+type TimeOfDay int
+
+// ParseTimeOfDay parses a time of day on the form HH:MM.
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %s", s)
+	}
+	return TimeOfDay(t.Hour()*60 + t.Minute()), nil
+}
+
+func timeOfDay(t time.Time) TimeOfDay { return TimeOfDay(t.Hour()*60 + t.Minute()) }
+
+// on returns the time on the same day as t, at time of day d.
+func (d TimeOfDay) on(t time.Time) time.Time {
+	y, m, day := t.Date()
+	return time.Date(y, m, day, int(d)/60, int(d)%60, 0, 0, t.Location())
+}
+
+// Policy decides when events are delivered to a sink.
+type Policy struct {
+	// Digest collects events and delivers them once a day at DigestAt, instead of immediately.
+	Digest   bool
+	DigestAt TimeOfDay
+	// Events are held back during quiet hours, and delivered when quiet hours end.
+	Quiet      bool
+	QuietStart TimeOfDay
+	QuietEnd   TimeOfDay
+	// MinOffline is the time a device must stay offline before an offline event is delivered. If the device comes
+	// back online before that, both events are dropped.
+	MinOffline time.Duration
+	// Dedup is the window in which repeated events of the same type for the same device are dropped.
+	Dedup time.Duration
+	// Filter selects the events delivered to the sink, if set. Events are queried by the fields of EventSchema.
+	Filter *query.Query
+}
+
+// EventSchema holds the fields events are filtered by.
+var EventSchema = query.Schema{
+	"type":       query.String,
+	"device":     query.String,
+	"macAddress": query.String,
+	"name":       query.String,
+	"error":      query.String,
+	"synthetic":  query.Bool,
+	"actor":      query.String,
+	"client":     query.String,
+}
+
+// eventRecord is an event matched by a filter.
+type eventRecord event.Event
+
+func (e eventRecord) Field(name string) interface{} {
+	switch name {
+	case "type":
+		return e.Type
+	case "device":
+		return e.Device
+	case "macAddress":
+		return e.MACAddress
+	case "name":
+		return e.Name
+	case "error":
+		return e.Error
+	case "synthetic":
+		return e.Synthetic
+	case "actor":
+		return e.Actor
+	case "client":
+		return e.Client
+	}
+	return nil
+}
+
+// ParseQuietHours parses quiet hours on the form HH:MM-HH:MM.
+func (p *Policy) ParseQuietHours(s string) error {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid quiet hours: %s", s)
+	}
+	start, err := ParseTimeOfDay(parts[0])
+	if err != nil {
+		return err
+	}
+	end, err := ParseTimeOfDay(parts[1])
+	if err != nil {
+		return err
+	}
+	p.Quiet, p.QuietStart, p.QuietEnd = true, start, end
+	return nil
+}
+
+func (p *Policy) quiet(t time.Time) bool {
+	if !p.Quiet {
+		return false
+	}
+	d := timeOfDay(t)
+	if p.QuietStart <= p.QuietEnd {
+		return d >= p.QuietStart && d < p.QuietEnd
+	}
+	// Quiet hours span midnight
+	return d >= p.QuietStart || d < p.QuietEnd
+}
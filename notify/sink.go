@@ -0,0 +1,183 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// post posts body to target, with the content type and headers given by header. Errors only include the host of
+// target, as its path and query may hold credentials, such as the token of a Telegram bot.
+func post(target string, header http.Header, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid url for %s", redact(target))
+	}
+	req.Header = header
+	res, err := client.Do(req)
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return fmt.Errorf("%s: %s", redact(target), err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: got status %d", redact(target), res.StatusCode)
+	}
+	return nil
+}
+
+// redact returns the scheme and host of target.
+func redact(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return "<invalid>"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// postJSON posts v as JSON to url.
+func postJSON(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return post(url, http.Header{"Content-Type": {"application/json"}}, body)
+}
+
+// Webhook sends events as JSON to an URL. If Secret is set, requests are signed by the HMAC-SHA256 of their body,
+// which is sent hex-encoded in the X-Wakeup-Signature header as sha256=<signature>.
+type Webhook struct {
+	URL    string
+	Secret string
+}
+
+// Send posts events to the webhook URL.
+func (w *Webhook) Send(events []event.Event) error {
+	body, err := json.Marshal(struct {
+		Events []event.Event `json:"events"`
+	}{events})
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	if w.Secret != "" {
+		header.Set("X-Wakeup-Signature", "sha256="+Signature(w.Secret, body))
+	}
+	return post(w.URL, header, body)
+}
+
+// Signature returns the hex-encoded HMAC-SHA256 of body, keyed by secret.
+func Signature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Slack sends events as a message to a Slack incoming webhook.
+type Slack struct{ URL string }
+
+// Send posts events as a single message to the incoming webhook.
+func (s *Slack) Send(events []event.Event) error {
+	return postJSON(s.URL, struct {
+		Text string `json:"text"`
+	}{Message(events)})
+}
+
+// Discord sends events as a message to a Discord webhook.
+type Discord struct{ URL string }
+
+// Send posts events as a single message to the webhook.
+func (d *Discord) Send(events []event.Event) error {
+	return postJSON(d.URL, struct {
+		Content string `json:"content"`
+	}{Message(events)})
+}
+
+// Ntfy publishes events as a message to the ntfy topic at URL, e.g. https://ntfy.sh/mytopic. Token is the access
+// token of protected topics, if any.
+type Ntfy struct {
+	URL   string
+	Token string
+}
+
+// Send publishes events as a single message to the topic.
+func (n *Ntfy) Send(events []event.Event) error {
+	header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}, "Title": {"wakeup"}}
+	if n.Token != "" {
+		header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return post(n.URL, header, []byte(Message(events)))
+}
+
+// Telegram sends events as a message to a Telegram chat.
+type Telegram struct {
+	Token  string
+	ChatID string
+	apiURL string
+}
+
+// Message formats events as a human-readable message.
+func Message(events []event.Event) string {
+	var sb strings.Builder
+	for i, e := range events {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		name := e.MACAddress
+		if e.Name != "" {
+			name = e.Name + " (" + e.MACAddress + ")"
+		}
+		switch e.Type {
+		case event.Wake:
+			fmt.Fprintf(&sb, "%s: Woke %s", e.Time.Format("2006-01-02 15:04"), name)
+		case event.Failed:
+			fmt.Fprintf(&sb, "%s: Failed to wake %s: %s", e.Time.Format("2006-01-02 15:04"), name, e.Error)
+		case event.Unknown:
+			fmt.Fprintf(&sb, "%s: Wake of unknown device %s requested%s", e.Time.Format("2006-01-02 15:04"), name, requester(e))
+		case event.Added:
+			fmt.Fprintf(&sb, "%s: Added %s%s", e.Time.Format("2006-01-02 15:04"), name, requester(e))
+		case event.Removed:
+			fmt.Fprintf(&sb, "%s: Removed %s%s", e.Time.Format("2006-01-02 15:04"), name, requester(e))
+		default:
+			fmt.Fprintf(&sb, "%s: %s is %s", e.Time.Format("2006-01-02 15:04"), name, e.Type)
+		}
+	}
+	return sb.String()
+}
+
+// requester describes who requested the wake or change of event e.
+func requester(e event.Event) string {
+	switch {
+	case e.Actor != "" && e.Client != "":
+		return fmt.Sprintf(" by %s from %s", e.Actor, e.Client)
+	case e.Actor != "":
+		return " by " + e.Actor
+	case e.Client != "":
+		return " from " + e.Client
+	}
+	return ""
+}
+
+// Send sends events as a single message to the chat.
+func (t *Telegram) Send(events []event.Event) error {
+	apiURL := t.apiURL
+	if apiURL == "" {
+		apiURL = "https://api.telegram.org"
+	}
+	form := url.Values{"chat_id": {t.ChatID}, "text": {Message(events)}}
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	return post(apiURL+"/bot"+t.Token+"/sendMessage", header, []byte(form.Encode()))
+}
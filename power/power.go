@@ -0,0 +1,259 @@
+// Package power powers off devices, by running a command over SSH, calling a webhook or through IPMI, so that devices
+// can be switched off as well as woken.
+package power
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Types of actions.
+const (
+	TypeSSH  = "ssh"
+	TypeHTTP = "http"
+	TypeIPMI = "ipmi"
+)
+
+// Action is how a device is powered off. Actions are configured by the operator, and referred to by devices by their
+// name, so that users cannot make the server run commands or use its credentials against hosts of their choosing.
+type Action struct {
+	// Type is one of ssh, http or ipmi.
+	Type string `json:"type"`
+	// Address is the SSH server, as host or host:port, or the address of the BMC of the device for IPMI.
+	Address string `json:"address,omitempty"`
+	// URL is the webhook posted to for http.
+	URL string `json:"url,omitempty"`
+	// User is the SSH user, defaulting to root, or the IPMI user, defaulting to that of the controller.
+	User string `json:"user,omitempty"`
+	// Command is the command run over SSH, defaulting to a command shutting down the platform of the device.
+	Command string `json:"command,omitempty"`
+}
+
+// Validate returns an error if a is invalid.
+func (a *Action) Validate() error {
+	switch a.Type {
+	case TypeSSH:
+		if a.Address == "" {
+			return fmt.Errorf("address is required for %s", a.Type)
+		}
+		if a.URL != "" {
+			return fmt.Errorf("url is not allowed for %s", a.Type)
+		}
+	case TypeHTTP:
+		u, err := url.Parse(a.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url: %s", a.URL)
+		}
+		if a.Address != "" || a.User != "" || a.Command != "" {
+			return fmt.Errorf("only url is allowed for %s", a.Type)
+		}
+	case TypeIPMI:
+		if a.Address == "" {
+			return fmt.Errorf("address is required for %s", a.Type)
+		}
+		if a.URL != "" || a.Command != "" {
+			return fmt.Errorf("url and command are not allowed for %s", a.Type)
+		}
+	default:
+		return fmt.Errorf("invalid type: %q", a.Type)
+	}
+	if strings.ContainsAny(a.Address, " /") {
+		return fmt.Errorf("invalid address: %s", a.Address)
+	}
+	return nil
+}
+
+// Preset is the name of the action a device is powered off by.
+type Preset string
+
+// ReadActions reads actions by name from the JSON file at name.
+func ReadActions(name string) (map[string]Action, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var c struct {
+		Actions map[string]Action `json:"actions"`
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	for name, a := range c.Actions {
+		if err := a.Validate(); err != nil {
+			return nil, fmt.Errorf("action %s: %s", name, err)
+		}
+	}
+	return c.Actions, nil
+}
+
+// Target is the device powered off.
+type Target struct {
+	Name       string `json:"name,omitempty"`
+	MACAddress string `json:"macAddress"`
+	IPAddress  string `json:"ipAddress,omitempty"`
+	// Platform is the operating system of the device, one of windows, linux or macos.
+	Platform string `json:"platform,omitempty"`
+}
+
+// Command returns the command shutting down a device running platform.
+func Command(platform string) string {
+	if platform == "windows" {
+		return "shutdown /s /t 0"
+	}
+	return "shutdown -h now"
+}
+
+// Controller powers off devices.
+type Controller struct {
+	// Signer authenticates SSH sessions, and HostKeys verifies the keys of SSH servers.
+	Signer   ssh.Signer
+	HostKeys ssh.HostKeyCallback
+	// IPMIUser and IPMIPassword authenticate to BMCs. IPMITool is the path to ipmitool.
+	IPMIUser     string
+	IPMIPassword string
+	IPMITool     string
+	// Actions are the actions devices can be powered off by, by name.
+	Actions map[string]Action
+	Timeout time.Duration
+	run     func(ctx context.Context, name string, args, env []string) ([]byte, error)
+}
+
+// NewController creates a new controller.
+func NewController() *Controller {
+	return &Controller{IPMITool: "ipmitool", Timeout: 10 * time.Second, run: run}
+}
+
+func run(ctx context.Context, name string, args, env []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.CombinedOutput()
+}
+
+// Action returns the action named by p.
+func (c *Controller) Action(p Preset) (Action, bool) {
+	a, ok := c.Actions[string(p)]
+	return a, ok
+}
+
+// Off powers off t as given by a.
+func (c *Controller) Off(ctx context.Context, a Action, t Target) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	switch a.Type {
+	case TypeSSH:
+		return c.ssh(ctx, a, t)
+	case TypeHTTP:
+		return post(ctx, a.URL, t)
+	case TypeIPMI:
+		return c.ipmi(ctx, a)
+	}
+	return fmt.Errorf("invalid type: %q", a.Type)
+}
+
+func (c *Controller) ssh(ctx context.Context, a Action, t Target) error {
+	if c.Signer == nil || c.HostKeys == nil {
+		return errors.New("ssh is not configured")
+	}
+	addr := a.Address
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	user := a.User
+	if user == "" {
+		user = "root"
+	}
+	command := a.Command
+	if command == "" {
+		command = Command(t.Platform)
+	}
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(c.Signer)},
+		HostKeyCallback: c.HostKeys,
+		Timeout:         c.Timeout,
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	sc, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		return err
+	}
+	client := ssh.NewClient(sc, chans, reqs)
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	out, err := session.CombinedOutput(command)
+	// The connection may be closed by the shutdown before the command exits
+	var missing *ssh.ExitMissingError
+	if errors.As(err, &missing) {
+		return nil
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func post(ctx context.Context, url string, t Target) error {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("got status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (c *Controller) ipmi(ctx context.Context, a Action) error {
+	user := a.User
+	if user == "" {
+		user = c.IPMIUser
+	}
+	// The password is passed in the environment, so that it is not visible in the list of processes
+	args := []string{"-I", "lanplus", "-H", a.Address, "-U", user, "-E", "chassis", "power", "off"}
+	out, err := c.run(ctx, c.IPMITool, args, []string{"IPMI_PASSWORD=" + c.IPMIPassword})
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
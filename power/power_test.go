@@ -0,0 +1,228 @@
+package power
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		action Action
+		err    string
+	}{
+		{Action{Type: TypeSSH}, "address is required for ssh"},
+		{Action{Type: TypeSSH, Address: "10.0.0.2:2222", User: "admin", Command: "sudo poweroff"}, ""},
+		{Action{Type: TypeSSH, Address: "10.0.0.2", URL: "http://example.com"}, "url is not allowed for ssh"},
+		{Action{Type: TypeHTTP, URL: "https://example.com/off"}, ""},
+		{Action{Type: TypeHTTP, URL: "ftp://example.com"}, "invalid url: ftp://example.com"},
+		{Action{Type: TypeHTTP, URL: "http://example.com", User: "root"}, "only url is allowed for http"},
+		{Action{Type: TypeIPMI, Address: "10.0.1.2"}, ""},
+		{Action{Type: TypeIPMI}, "address is required for ipmi"},
+		{Action{Type: TypeIPMI, Address: "10.0.1.2 -o"}, "invalid address: 10.0.1.2 -o"},
+		{Action{Type: "wol"}, `invalid type: "wol"`},
+	}
+	for i, tt := range tests {
+		err := tt.action.Validate()
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.err {
+			t.Errorf("#%d: want error %q, got %q", i, tt.err, got)
+		}
+	}
+}
+
+func TestReadActions(t *testing.T) {
+	f, err := ioutil.TempFile("", "power")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	conf := `{"actions":{"nas":{"type":"ssh","address":"10.0.0.2","user":"admin"},"rack":{"type":"ipmi","address":"10.0.1.2"}}}`
+	if err := ioutil.WriteFile(f.Name(), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	actions, err := ReadActions(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewController()
+	c.Actions = actions
+	if a, ok := c.Action("nas"); !ok || a != (Action{Type: TypeSSH, Address: "10.0.0.2", User: "admin"}) {
+		t.Errorf("want ssh action, got %+v", a)
+	}
+	if _, ok := c.Action("desktop"); ok {
+		t.Error("want no action of desktop")
+	}
+	if err := ioutil.WriteFile(f.Name(), []byte(`{"actions":{"nas":{"type":"ssh"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadActions(f.Name()); err == nil || err.Error() != "action nas: address is required for ssh" {
+		t.Errorf("want invalid action, got %v", err)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	var got Target
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil || got.Name == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	c := NewController()
+	target := Target{Name: "nas", MACAddress: "AB:CD:EF:12:34:56"}
+	if err := c.Off(context.Background(), Action{Type: TypeHTTP, URL: server.URL}, target); err != nil {
+		t.Fatal(err)
+	}
+	if got != target {
+		t.Errorf("want %+v posted, got %+v", target, got)
+	}
+	if err := c.Off(context.Background(), Action{Type: TypeHTTP, URL: server.URL}, Target{Name: "fail"}); err == nil || err.Error() != "got status 500" {
+		t.Errorf("want status error, got %v", err)
+	}
+}
+
+func TestIPMI(t *testing.T) {
+	var cmd, env []string
+	c := NewController()
+	c.IPMIUser, c.IPMIPassword = "admin", "secret"
+	c.run = func(ctx context.Context, name string, args, e []string) ([]byte, error) {
+		cmd, env = append([]string{name}, args...), e
+		if args[3] == "10.0.1.3" {
+			return []byte("Error: Unable to establish IPMI v2 / RMCP+ session\n"), errors.New("exit status 1")
+		}
+		return []byte("Chassis Power Control: Down/Off\n"), nil
+	}
+	if err := c.Off(context.Background(), Action{Type: TypeIPMI, Address: "10.0.1.2"}, Target{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "ipmitool -I lanplus -H 10.0.1.2 -U admin -E chassis power off"; strings.Join(cmd, " ") != want {
+		t.Errorf("want command %q, got %q", want, strings.Join(cmd, " "))
+	}
+	if len(env) != 1 || env[0] != "IPMI_PASSWORD=secret" {
+		t.Errorf("want password in environment, got %v", env)
+	}
+	if err := c.Off(context.Background(), Action{Type: TypeIPMI, Address: "10.0.1.2", User: "operator"}, Target{}); err != nil || cmd[6] != "operator" {
+		t.Errorf("want user of action, got %v (%v)", cmd, err)
+	}
+	err := c.Off(context.Background(), Action{Type: TypeIPMI, Address: "10.0.1.3"}, Target{})
+	if want := "exit status 1: Error: Unable to establish IPMI v2 / RMCP+ session"; err == nil || err.Error() != want {
+		t.Errorf("want error %q, got %v", want, err)
+	}
+}
+
+// serveSSH serves SSH sessions accepted by l, sending each command run to commands and exiting with status 0, unless the
+// command is "hang up", which closes the connection without an exit status.
+func serveSSH(t *testing.T, l net.Listener, hostKey ssh.Signer, client ssh.PublicKey, commands chan<- string) {
+	config := &ssh.ServerConfig{PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if string(key.Marshal()) != string(client.Marshal()) {
+			return nil, errors.New("unauthorized")
+		}
+		return nil, nil
+	}}
+	config.AddHostKey(hostKey)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				return
+			}
+			defer sc.Close()
+			go ssh.DiscardRequests(reqs)
+			for nc := range chans {
+				ch, reqs, err := nc.Accept()
+				if err != nil {
+					return
+				}
+				for req := range reqs {
+					if req.Type != "exec" {
+						req.Reply(false, nil)
+						continue
+					}
+					command := string(req.Payload[4:])
+					req.Reply(true, nil)
+					commands <- sc.User() + ": " + command
+					if command == "hang up" {
+						return
+					}
+					status := make([]byte, 4)
+					binary.BigEndian.PutUint32(status, 0)
+					ch.SendRequest("exit-status", false, status)
+					ch.Close()
+				}
+			}
+		}()
+	}
+}
+
+func TestSSH(t *testing.T) {
+	hostKey, clientKey := newSigner(t), newSigner(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	commands := make(chan string, 10)
+	go serveSSH(t, l, hostKey, clientKey.PublicKey(), commands)
+	c := NewController()
+	if err := c.Off(context.Background(), Action{Type: TypeSSH, Address: l.Addr().String()}, Target{}); err == nil || err.Error() != "ssh is not configured" {
+		t.Errorf("want unconfigured ssh, got %v", err)
+	}
+	c.Signer = clientKey
+	c.HostKeys = ssh.FixedHostKey(hostKey.PublicKey())
+	var tests = []struct {
+		action Action
+		target Target
+		want   string
+	}{
+		{Action{Type: TypeSSH, Address: l.Addr().String()}, Target{Platform: "linux"}, "root: shutdown -h now"},
+		{Action{Type: TypeSSH, Address: l.Addr().String(), User: "Administrator"}, Target{Platform: "windows"}, "Administrator: shutdown /s /t 0"},
+		{Action{Type: TypeSSH, Address: l.Addr().String(), User: "pi", Command: "hang up"}, Target{}, "pi: hang up"},
+	}
+	for i, tt := range tests {
+		if err := c.Off(context.Background(), tt.action, tt.target); err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if got := <-commands; got != tt.want {
+			t.Errorf("#%d: want command %q, got %q", i, tt.want, got)
+		}
+	}
+	// The host key is verified
+	c.HostKeys = ssh.FixedHostKey(newSigner(t).PublicKey())
+	if err := c.Off(context.Background(), Action{Type: TypeSSH, Address: l.Addr().String()}, Target{}); err == nil {
+		t.Error("want error for unknown host key")
+	}
+}
@@ -0,0 +1,266 @@
+// Package prereq evaluates prerequisites that must hold before a device is woken, such as a switch port being up or the
+// load of an UPS being below a threshold.
+package prereq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Check types.
+const (
+	TypeHTTP = "http"
+	TypeTCP  = "tcp"
+	TypeSNMP = "snmp"
+)
+
+// Modes deciding what happens when a check fails.
+const (
+	ModeRefuse = "refuse"
+	ModeWarn   = "warn"
+)
+
+var operators = []string{"<=", ">=", "==", "!=", "<", ">"}
+
+// Check is a prerequisite of a device.
+type Check struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Target is an URL for http checks, HOST:PORT for tcp checks and HOST[:PORT] for snmp checks.
+	Target    string `json:"target"`
+	Community string `json:"community,omitempty"`
+	OID       string `json:"oid,omitempty"`
+	// Path is the dotted path of the value to compare in a JSON response.
+	Path string `json:"path,omitempty"`
+	// Condition compares the value of the check to a constant, e.g. "< 80" or "== 1". An http check without a
+	// condition passes on any 2xx status, and a tcp check passes if the target accepts connections.
+	Condition string `json:"condition,omitempty"`
+	Mode      string `json:"mode,omitempty"`
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	Name  string `json:"name"`
+	Mode  string `json:"mode"`
+	OK    bool   `json:"ok"`
+	Value string `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func (c *Check) mode() string {
+	if c.Mode == "" {
+		return ModeRefuse
+	}
+	return c.Mode
+}
+
+func parseCondition(s string) (string, string, error) {
+	s = strings.TrimSpace(s)
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return op, strings.TrimSpace(strings.TrimPrefix(s, op)), nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid condition: %q", s)
+}
+
+// Validate returns an error if c is invalid.
+func (c *Check) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("prerequisite must have a name")
+	}
+	switch c.Type {
+	case TypeHTTP, TypeTCP:
+	case TypeSNMP:
+		if c.OID == "" || c.Condition == "" {
+			return fmt.Errorf("%s: snmp prerequisite must have an oid and a condition", c.Name)
+		}
+	default:
+		return fmt.Errorf("%s: invalid type: %s", c.Name, c.Type)
+	}
+	if c.Target == "" {
+		return fmt.Errorf("%s: target is required", c.Name)
+	}
+	if c.Condition != "" {
+		if _, _, err := parseCondition(c.Condition); err != nil {
+			return fmt.Errorf("%s: %s", c.Name, err)
+		}
+	}
+	switch c.Mode {
+	case "", ModeRefuse, ModeWarn:
+	default:
+		return fmt.Errorf("%s: invalid mode: %s", c.Name, c.Mode)
+	}
+	return nil
+}
+
+// compare returns whether value satisfies condition. Values are compared as numbers if both sides are numeric, and as
+// strings otherwise, in which case only == and != are supported.
+func compare(value, condition string) (bool, error) {
+	op, want, err := parseCondition(condition)
+	if err != nil {
+		return false, err
+	}
+	a, errA := strconv.ParseFloat(value, 64)
+	b, errB := strconv.ParseFloat(want, 64)
+	if errA == nil && errB == nil {
+		switch op {
+		case "<":
+			return a < b, nil
+		case "<=":
+			return a <= b, nil
+		case ">":
+			return a > b, nil
+		case ">=":
+			return a >= b, nil
+		case "==":
+			return a == b, nil
+		case "!=":
+			return a != b, nil
+		}
+	}
+	switch op {
+	case "==":
+		return value == want, nil
+	case "!=":
+		return value != want, nil
+	}
+	return false, fmt.Errorf("cannot compare %q %s %s", value, op, want)
+}
+
+func lookup(v interface{}, path string) (string, error) {
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return "", fmt.Errorf("no value at %q", path)
+			}
+			v = m[key]
+		}
+	}
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(s), nil
+	}
+	return "", fmt.Errorf("no value at %q", path)
+}
+
+// Checker runs checks.
+type Checker struct {
+	Timeout time.Duration
+	client  *http.Client
+	dial    func(ctx context.Context, network, address string) (net.Conn, error)
+	snmpGet func(target, community, oid string, timeout time.Duration) (string, error)
+}
+
+// New creates a new checker where each check times out after timeout.
+func New(timeout time.Duration) *Checker {
+	var d net.Dialer
+	return &Checker{
+		Timeout: timeout,
+		client: &http.Client{
+			// A redirect is reported as the status of the check, instead of being followed
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		dial:    d.DialContext,
+		snmpGet: snmpGet,
+	}
+}
+
+func (c *Checker) value(ctx context.Context, check Check) (string, error) {
+	switch check.Type {
+	case TypeHTTP:
+		req, err := http.NewRequest(http.MethodGet, check.Target, nil)
+		if err != nil {
+			return "", err
+		}
+		res, err := c.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		if res.StatusCode/100 != 2 {
+			return "", fmt.Errorf("got status %d", res.StatusCode)
+		}
+		if check.Condition == "" {
+			return strconv.Itoa(res.StatusCode), nil
+		}
+		var doc interface{}
+		if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+			return "", err
+		}
+		return lookup(doc, check.Path)
+	case TypeTCP:
+		conn, err := c.dial(ctx, "tcp", check.Target)
+		if err != nil {
+			return "", err
+		}
+		conn.Close()
+		return "", nil
+	case TypeSNMP:
+		timeout := c.Timeout
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+		community := check.Community
+		if community == "" {
+			community = "public"
+		}
+		return c.snmpGet(check.Target, community, check.OID, timeout)
+	}
+	return "", fmt.Errorf("invalid type: %s", check.Type)
+}
+
+// Run runs all checks concurrently and returns their results in the same order.
+func (c *Checker) Run(ctx context.Context, checks []Check) []Result {
+	results := make([]Result, len(checks))
+	done := make(chan struct{}, len(checks))
+	for i, check := range checks {
+		go func(i int, check Check) {
+			defer func() { done <- struct{}{} }()
+			ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+			r := Result{Name: check.Name, Mode: check.mode()}
+			value, err := c.value(ctx, check)
+			if err == nil && check.Condition != "" {
+				r.Value = value
+				r.OK, err = compare(value, check.Condition)
+				if err == nil && !r.OK {
+					err = fmt.Errorf("%s does not satisfy %s", value, check.Condition)
+				}
+			} else if err == nil {
+				r.OK = true
+			}
+			if err != nil {
+				r.OK = false
+				r.Error = err.Error()
+			}
+			results[i] = r
+		}(i, check)
+	}
+	for range checks {
+		<-done
+	}
+	return results
+}
+
+// Failed returns the results that failed in given mode.
+func Failed(results []Result, mode string) []Result {
+	var failed []Result
+	for _, r := range results {
+		if !r.OK && r.Mode == mode {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,115 @@
+package prereq
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	var tests = []struct {
+		value     string
+		condition string
+		ok        bool
+		err       bool
+	}{
+		{"42", "< 80", true, false},
+		{"80", "< 80", false, false},
+		{"80", "<=80", true, false},
+		{"1", "== 1", true, false},
+		{"1.0", "== 1", true, false},
+		{"up", "== up", true, false},
+		{"down", "!= up", true, false},
+		{"up", "< 1", false, true},
+		{"1", "~ 1", false, true},
+	}
+	for i, tt := range tests {
+		ok, err := compare(tt.value, tt.condition)
+		if ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("#%d: compare(%q, %q) = (%t, %v), want (%t, error %t)", i, tt.value, tt.condition, ok, err, tt.ok, tt.err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		check Check
+		err   bool
+	}{
+		{Check{Name: "port", Type: TypeTCP, Target: "10.0.0.1:22"}, false},
+		{Check{Name: "port", Type: TypeTCP}, true},
+		{Check{Type: TypeTCP, Target: "10.0.0.1:22"}, true},
+		{Check{Name: "ups", Type: TypeSNMP, Target: "10.0.0.2", OID: ".1.3.6.1.2.1.33.1.4.4.1.5.1"}, true},
+		{Check{Name: "ups", Type: TypeSNMP, Target: "10.0.0.2", OID: ".1.3.6.1.2.1.33.1.4.4.1.5.1", Condition: "< 80"}, false},
+		{Check{Name: "ups", Type: "icmp", Target: "10.0.0.2"}, true},
+		{Check{Name: "ups", Type: TypeHTTP, Target: "http://ups", Condition: "80"}, true},
+		{Check{Name: "ups", Type: TypeHTTP, Target: "http://ups", Mode: "ignore"}, true},
+	}
+	for i, tt := range tests {
+		if err := tt.check.Validate(); (err != nil) != tt.err {
+			t.Errorf("#%d: want error %t, got %v", i, tt.err, err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, `{"ups":{"load":42}}`)
+	}))
+	defer server.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	c := New(time.Second)
+	c.snmpGet = func(target, community, oid string, timeout time.Duration) (string, error) {
+		if target != "10.0.0.3" || community != "public" {
+			return "", fmt.Errorf("unexpected target %s or community %s", target, community)
+		}
+		return "2", nil
+	}
+	checks := []Check{
+		{Name: "api", Type: TypeHTTP, Target: server.URL},
+		{Name: "load", Type: TypeHTTP, Target: server.URL, Path: "ups.load", Condition: "< 80"},
+		{Name: "overload", Type: TypeHTTP, Target: server.URL, Path: "ups.load", Condition: "< 10", Mode: ModeWarn},
+		{Name: "down", Type: TypeHTTP, Target: server.URL + "/down"},
+		{Name: "redirect", Type: TypeHTTP, Target: server.URL + "/redirect"},
+		{Name: "port", Type: TypeTCP, Target: listener.Addr().String()},
+		{Name: "switch", Type: TypeSNMP, Target: "10.0.0.3", OID: ".1.3.6.1.2.1.2.2.1.8.12", Condition: "== 1"},
+	}
+	results := c.Run(context.Background(), checks)
+	want := []Result{
+		{Name: "api", Mode: ModeRefuse, OK: true},
+		{Name: "load", Mode: ModeRefuse, OK: true, Value: "42"},
+		{Name: "overload", Mode: ModeWarn, Value: "42", Error: "42 does not satisfy < 10"},
+		{Name: "down", Mode: ModeRefuse, Error: "got status 503"},
+		{Name: "redirect", Mode: ModeRefuse, Error: "got status 302"},
+		{Name: "port", Mode: ModeRefuse, OK: true},
+		{Name: "switch", Mode: ModeRefuse, Value: "2", Error: "2 does not satisfy == 1"},
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("#%d: want %+v, got %+v", i, want[i], results[i])
+		}
+	}
+	if got := Failed(results, ModeRefuse); len(got) != 3 {
+		t.Errorf("want 3 refusing failures, got %+v", got)
+	}
+	if got := Failed(results, ModeWarn); len(got) != 1 {
+		t.Errorf("want 1 warning failure, got %+v", got)
+	}
+}
@@ -0,0 +1,47 @@
+package prereq
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+func snmpGet(target, community, oid string, timeout time.Duration) (string, error) {
+	host, port := target, uint16(161)
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return "", fmt.Errorf("invalid port: %s", p)
+		}
+		host, port = h, uint16(n)
+	}
+	client := &gosnmp.GoSNMP{
+		Target:    host,
+		Port:      port,
+		Community: community,
+		Version:   gosnmp.Version2c,
+		Timeout:   timeout,
+	}
+	if err := client.Connect(); err != nil {
+		return "", err
+	}
+	defer client.Conn.Close()
+	packet, err := client.Get([]string{oid})
+	if err != nil {
+		return "", err
+	}
+	if len(packet.Variables) != 1 {
+		return "", fmt.Errorf("%s: got %d variables", oid, len(packet.Variables))
+	}
+	v := packet.Variables[0]
+	switch v.Type {
+	case gosnmp.OctetString:
+		return string(v.Value.([]byte)), nil
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.Null:
+		return "", fmt.Errorf("%s: no such object", oid)
+	}
+	return gosnmp.ToBigInt(v.Value).String(), nil
+}
@@ -0,0 +1,399 @@
+// Package query parses and evaluates search expressions, such as state=offline AND tag=server AND lastWake<24h, which
+// filter records by their fields.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Kinds of fields.
+const (
+	// String fields are compared with =, != and ~, which matches a substring. Comparisons ignore case.
+	String Kind = iota
+	// Strings fields hold several strings, and match if any of them matches.
+	Strings
+	// Number fields are compared with =, !=, <, <=, > and >=.
+	Number
+	// Bool fields are compared with = and != to true or false.
+	Bool
+	// Time fields are compared by their age with <, <=, > and >= to a duration, e.g. 24h or 7d. A zero time is older than
+	// any duration.
+	Time
+)
+
+// Kind is the kind of a field, which decides how it is compared.
+type Kind int
+
+// Schema holds the kinds of the fields which can be queried, by name.
+type Schema map[string]Kind
+
+// Record is something matched by a query.
+type Record interface {
+	// Field returns the value of field name, which is a string, []string, float64, bool or time.Time according to the
+	// kind of the field. Fields are only looked up as needed to decide a match, so that they can be computed lazily.
+	Field(name string) interface{}
+}
+
+// Fields is a record holding the values of its fields.
+type Fields map[string]interface{}
+
+// Field returns the value of field name.
+func (f Fields) Field(name string) interface{} { return f[name] }
+
+// Query is a parsed expression.
+type Query struct {
+	expr node
+}
+
+// Match reports whether record r matches the query, at time now.
+func (q *Query) Match(r Record, now time.Time) bool { return q.expr.match(r, now) }
+
+// String returns the expression of the query.
+func (q *Query) String() string { return q.expr.String() }
+
+type node interface {
+	match(r Record, now time.Time) bool
+	String() string
+}
+
+type and struct{ left, right node }
+
+func (n *and) match(r Record, now time.Time) bool {
+	return n.left.match(r, now) && n.right.match(r, now)
+}
+
+func (n *and) String() string { return "(" + n.left.String() + " AND " + n.right.String() + ")" }
+
+type or struct{ left, right node }
+
+func (n *or) match(r Record, now time.Time) bool {
+	return n.left.match(r, now) || n.right.match(r, now)
+}
+
+func (n *or) String() string { return "(" + n.left.String() + " OR " + n.right.String() + ")" }
+
+type not struct{ node node }
+
+func (n *not) match(r Record, now time.Time) bool { return !n.node.match(r, now) }
+
+func (n *not) String() string { return "NOT " + n.node.String() }
+
+// term compares a field to a value.
+type term struct {
+	field string
+	op    string
+	value string
+	// number is the value of a Number term, and age of a Time term
+	number float64
+	age    time.Duration
+	bool   bool
+}
+
+func (t *term) String() string { return t.field + t.op + strconv.Quote(t.value) }
+
+func (t *term) match(r Record, now time.Time) bool {
+	switch v := r.Field(t.field).(type) {
+	case string:
+		return t.matchString(v)
+	case []string:
+		// A field having several values differs from the value if none of them equals it
+		if t.op == "!=" {
+			for _, s := range v {
+				if strings.EqualFold(s, t.value) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, s := range v {
+			if t.matchString(s) {
+				return true
+			}
+		}
+		return false
+	case float64:
+		return compare(t.op, v, t.number)
+	case bool:
+		return (v == t.bool) == (t.op == "=")
+	case time.Time:
+		if v.IsZero() {
+			return t.op == ">" || t.op == ">="
+		}
+		return compare(t.op, float64(now.Sub(v)), float64(t.age))
+	case nil:
+		// A missing field only differs from any value
+		return t.op == "!="
+	}
+	return false
+}
+
+func (t *term) matchString(s string) bool {
+	switch t.op {
+	case "=":
+		return strings.EqualFold(s, t.value)
+	case "!=":
+		return !strings.EqualFold(s, t.value)
+	case "~":
+		return strings.Contains(strings.ToLower(s), strings.ToLower(t.value))
+	}
+	return false
+}
+
+func compare(op string, a, b float64) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+// ops are the comparison operators, longest first.
+var ops = []string{"!=", "<=", ">=", "=", "<", ">", "~"}
+
+// token kinds
+const (
+	tokenEOF = iota
+	tokenWord
+	tokenString
+	tokenOp
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind  int
+	value string
+	pos   int
+}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenOpen, value: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenClose, value: ")", pos: i})
+			i++
+		case c == '"':
+			// Quoted strings allow spaces and operators in values
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenString, value: b.String(), pos: i})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range ops {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op != "" {
+				tokens = append(tokens, token{kind: tokenOp, value: op, pos: i})
+				i += len(op)
+				continue
+			}
+			j := i
+			for ; j < len(s) && !unicode.IsSpace(rune(s[j])) && !strings.ContainsRune("()\"!<>=~", rune(s[j])); j++ {
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected %c at position %d", s[i], i)
+			}
+			tokens = append(tokens, token{kind: tokenWord, value: s[i:j], pos: i})
+			i = j
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(s)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	schema Schema
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword reports whether the next token is the keyword kw, and consumes it if so.
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokenWord && strings.EqualFold(t.value, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &or{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = &and{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (node, error) {
+	if p.keyword("NOT") {
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &not{n}, nil
+	}
+	if p.peek().kind == tokenOpen {
+		p.next()
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenClose {
+			return nil, fmt.Errorf("expected ) at position %d", t.pos)
+		}
+		return n, nil
+	}
+	return p.term()
+}
+
+func (p *parser) term() (node, error) {
+	f := p.next()
+	if f.kind != tokenWord {
+		return nil, fmt.Errorf("expected field at position %d", f.pos)
+	}
+	kind, ok := p.schema[f.value]
+	if !ok {
+		return nil, fmt.Errorf("unknown field: %s", f.value)
+	}
+	op := p.next()
+	if op.kind != tokenOp {
+		return nil, fmt.Errorf("expected operator after %s at position %d", f.value, op.pos)
+	}
+	v := p.next()
+	if v.kind != tokenWord && v.kind != tokenString {
+		return nil, fmt.Errorf("expected value after %s%s at position %d", f.value, op.value, v.pos)
+	}
+	t := &term{field: f.value, op: op.value, value: v.value}
+	if !allowed(kind, t.op) {
+		return nil, fmt.Errorf("invalid operator %s for field %s", t.op, t.field)
+	}
+	var err error
+	switch kind {
+	case Number:
+		if t.number, err = strconv.ParseFloat(t.value, 64); err != nil {
+			return nil, fmt.Errorf("invalid number for field %s: %s", t.field, t.value)
+		}
+	case Bool:
+		if t.bool, err = strconv.ParseBool(t.value); err != nil {
+			return nil, fmt.Errorf("invalid boolean for field %s: %s", t.field, t.value)
+		}
+	case Time:
+		if t.age, err = ParseAge(t.value); err != nil {
+			return nil, fmt.Errorf("invalid duration for field %s: %s", t.field, t.value)
+		}
+	}
+	return t, nil
+}
+
+func allowed(kind Kind, op string) bool {
+	switch kind {
+	case String, Strings:
+		return op == "=" || op == "!=" || op == "~"
+	case Number:
+		return op != "~"
+	case Bool:
+		return op == "=" || op == "!="
+	case Time:
+		return op == "<" || op == "<=" || op == ">" || op == ">="
+	}
+	return false
+}
+
+// ParseAge parses a duration as time.ParseDuration does, which may also be given in whole days, e.g. 7d.
+func ParseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Parse parses the expression s, whose terms compare the fields of schema to values, e.g. name~nas. Terms are combined
+// with AND, OR and NOT, in order of decreasing precedence, and grouped by parentheses. Values having spaces or
+// operators are quoted, e.g. name="living room".
+func Parse(s string, schema Schema) (*Query, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, schema: schema}
+	if p.peek().kind == tokenEOF {
+		return nil, fmt.Errorf("empty query")
+	}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", t.value, t.pos)
+	}
+	return &Query{expr: n}, nil
+}
@@ -0,0 +1,107 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+var schema = Schema{
+	"name":      String,
+	"tag":       Strings,
+	"state":     String,
+	"watts":     Number,
+	"essential": Bool,
+	"lastWake":  Time,
+}
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+		err string
+	}{
+		{"name=nas", `name="nas"`, ""},
+		{`name = "living room"`, `name="living room"`, ""},
+		{"state=offline AND tag=server AND lastWake<24h", `((state="offline" AND tag="server") AND lastWake<"24h")`, ""},
+		{"name~a OR name~b and watts>=10", `(name~"a" OR (name~"b" AND watts>="10"))`, ""},
+		{"NOT (name=a OR name=b)", `NOT (name="a" OR name="b")`, ""},
+		{"", "", "empty query"},
+		{"foo=bar", "", "unknown field: foo"},
+		{"name", "", "expected operator after name at position 4"},
+		{"name=", "", "expected value after name= at position 5"},
+		{"name<a", "", "invalid operator < for field name"},
+		{"watts~1", "", "invalid operator ~ for field watts"},
+		{"watts>many", "", "invalid number for field watts: many"},
+		{"essential=maybe", "", "invalid boolean for field essential: maybe"},
+		{"lastWake=24h", "", "invalid operator = for field lastWake"},
+		{"lastWake<soon", "", "invalid duration for field lastWake: soon"},
+		{"(name=a", "", "expected ) at position 7"},
+		{"name=a name=b", "", "unexpected name at position 7"},
+		{`name="a`, "", "unterminated string at position 5"},
+		{"name!a", "", "unexpected ! at position 4"},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.in, schema)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Parse(%q): want error %q, got %v", tt.in, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %s", tt.in, err)
+			continue
+		}
+		if got := q.String(); got != tt.out {
+			t.Errorf("Parse(%q) = %s, want %s", tt.in, got, tt.out)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	nas := Fields{
+		"name":      "Office NAS",
+		"tag":       []string{"server", "office"},
+		"state":     "offline",
+		"watts":     float64(40),
+		"essential": true,
+		"lastWake":  now.Add(-2 * time.Hour),
+	}
+	desktop := Fields{"name": "desktop", "state": "online", "lastWake": time.Time{}}
+	var tests = []struct {
+		query   string
+		nas     bool
+		desktop bool
+	}{
+		{"state=offline AND tag=server AND lastWake<24h", true, false},
+		{"name~nas", true, false},
+		{"name=DESKTOP", false, true},
+		{"name!=desktop", true, false},
+		{"tag=office", true, false},
+		{"tag!=office", false, true},
+		{"tag~serv", true, false},
+		{"watts>=40", true, false},
+		{"watts<40", false, false},
+		{"watts!=40", false, true},
+		{"essential=true", true, false},
+		{"essential!=true", false, true},
+		{"lastWake<1h", false, false},
+		{"lastWake>1h", true, true},
+		{"lastWake>7d", false, true},
+		{"NOT state=online", true, false},
+		{"state=online OR tag=server", true, true},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query, schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := q.Match(nas, now); got != tt.nas {
+			t.Errorf("%q matches nas = %t, want %t", tt.query, got, tt.nas)
+		}
+		if got := q.Match(desktop, now); got != tt.desktop {
+			t.Errorf("%q matches desktop = %t, want %t", tt.query, got, tt.desktop)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package quota limits the number of wakes per hour and day for each user or API key.
+package quota
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is the maximum number of wakes in an hour and a day. Zero means unlimited.
+type Limit struct {
+	PerHour int `json:"perHour"`
+	PerDay  int `json:"perDay"`
+}
+
+// ParseLimit parses a limit in the format HOUR/DAY, e.g. 10/50.
+func ParseLimit(s string) (Limit, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("invalid limit: %s", s)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 {
+		return Limit{}, fmt.Errorf("invalid limit: %s", s)
+	}
+	day, err := strconv.Atoi(parts[1])
+	if err != nil || day < 0 {
+		return Limit{}, fmt.Errorf("invalid limit: %s", s)
+	}
+	return Limit{PerHour: hour, PerDay: day}, nil
+}
+
+// Usage is the number of wakes made by a subject in the last hour and day.
+type Usage struct {
+	Subject string `json:"subject"`
+	Hour    int    `json:"hour"`
+	Day     int    `json:"day"`
+	Limit   Limit  `json:"limit"`
+}
+
+// Quotas tracks wakes per subject.
+type Quotas struct {
+	Default Limit
+	mu      sync.Mutex
+	limits  map[string]Limit
+	wakes   map[string][]time.Time
+	now     func() time.Time
+}
+
+// New creates new quotas, where each subject has the limit def unless another limit is set.
+func New(def Limit) *Quotas {
+	return &Quotas{Default: def, limits: make(map[string]Limit), wakes: make(map[string][]time.Time), now: time.Now}
+}
+
+// Set sets the limit of subject.
+func (q *Quotas) Set(subject string, limit Limit) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.limits[subject] = limit
+}
+
+func (q *Quotas) limit(subject string) Limit {
+	if l, ok := q.limits[subject]; ok {
+		return l
+	}
+	return q.Default
+}
+
+// prune removes wakes older than a day, and returns the usage of subject.
+func (q *Quotas) prune(subject string, now time.Time) Usage {
+	wakes := q.wakes[subject]
+	i := 0
+	for i < len(wakes) && !wakes[i].After(now.Add(-24*time.Hour)) {
+		i++
+	}
+	wakes = wakes[i:]
+	if len(wakes) == 0 {
+		delete(q.wakes, subject)
+	} else {
+		q.wakes[subject] = wakes
+	}
+	u := Usage{Subject: subject, Day: len(wakes), Limit: q.limit(subject)}
+	for _, t := range wakes {
+		if t.After(now.Add(-time.Hour)) {
+			u.Hour++
+		}
+	}
+	return u
+}
+
+// Allow records a wake by subject and returns true if it is within its limit. Otherwise the wake is not recorded, and the
+// time until the subject can wake again is returned.
+func (q *Quotas) Allow(subject string) (bool, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now()
+	u := q.prune(subject, now)
+	wakes := q.wakes[subject]
+	if u.Limit.PerDay > 0 && u.Day >= u.Limit.PerDay {
+		return false, wakes[len(wakes)-u.Limit.PerDay].Add(24 * time.Hour).Sub(now)
+	}
+	if u.Limit.PerHour > 0 && u.Hour >= u.Limit.PerHour {
+		return false, wakes[len(wakes)-u.Limit.PerHour].Add(time.Hour).Sub(now)
+	}
+	q.wakes[subject] = append(wakes, now)
+	return true, 0
+}
+
+// Window is the state of a limit of a subject.
+type Window struct {
+	// Limit is the maximum number of wakes in the window. Zero means unlimited.
+	Limit     int
+	Remaining int
+	// Reset is the time until the oldest wake counted in the window expires.
+	Reset time.Duration
+}
+
+func window(wakes []time.Time, limit int, d time.Duration, now time.Time) Window {
+	w := Window{Limit: limit}
+	if limit == 0 {
+		return w
+	}
+	n := 0
+	for _, t := range wakes {
+		if t.After(now.Add(-d)) {
+			if n == 0 {
+				w.Reset = t.Add(d).Sub(now)
+			}
+			n++
+		}
+	}
+	if n < limit {
+		w.Remaining = limit - n
+	}
+	return w
+}
+
+// Windows returns the hourly and daily windows of subject.
+func (q *Quotas) Windows(subject string) (Window, Window) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now()
+	u := q.prune(subject, now)
+	wakes := q.wakes[subject]
+	return window(wakes, u.Limit.PerHour, time.Hour, now), window(wakes, u.Limit.PerDay, 24*time.Hour, now)
+}
+
+// Usage returns the usage of subject.
+func (q *Quotas) Usage(subject string) Usage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.prune(subject, q.now())
+}
+
+// All returns the usage of all subjects that have woken devices in the last day, or have a limit set.
+func (q *Quotas) All() []Usage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now()
+	subjects := make(map[string]bool)
+	for s := range q.wakes {
+		subjects[s] = true
+	}
+	for s := range q.limits {
+		subjects[s] = true
+	}
+	usage := make([]Usage, 0, len(subjects))
+	for s := range subjects {
+		u := q.prune(s, now)
+		if _, ok := q.limits[s]; ok || u.Day > 0 {
+			usage = append(usage, u)
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Subject < usage[j].Subject })
+	return usage
+}
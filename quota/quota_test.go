@@ -0,0 +1,84 @@
+package quota
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	q := New(Limit{PerHour: 2, PerDay: 3})
+	q.now = func() time.Time { return now }
+	q.Set("ci", Limit{PerHour: 1})
+
+	var tests = []struct {
+		subject string
+		after   time.Duration
+		ok      bool
+		retry   time.Duration
+	}{
+		{"alice", 0, true, 0},
+		{"alice", 10 * time.Minute, true, 0},
+		{"alice", 10 * time.Minute, false, 40 * time.Minute},
+		{"bob", 0, true, 0},
+		{"alice", 40 * time.Minute, true, 0},
+		{"alice", time.Hour, false, 22 * time.Hour},
+		{"ci", 0, true, 0},
+		{"ci", 0, false, time.Hour},
+		{"ci", time.Hour, true, 0},
+		{"alice", 23 * time.Hour, true, 0},
+	}
+	for i, tt := range tests {
+		now = now.Add(tt.after)
+		ok, retry := q.Allow(tt.subject)
+		if ok != tt.ok || retry != tt.retry {
+			t.Errorf("#%d: want (%t, %s), got (%t, %s)", i, tt.ok, tt.retry, ok, retry)
+		}
+	}
+	if u, want := q.Usage("alice"), (Usage{Subject: "alice", Hour: 1, Day: 1, Limit: Limit{PerHour: 2, PerDay: 3}}); u != want {
+		t.Errorf("want %+v, got %+v", want, u)
+	}
+	if all := q.All(); len(all) != 2 || all[0].Subject != "alice" || all[1].Subject != "ci" {
+		t.Errorf("unexpected usage %+v", all)
+	}
+}
+
+func TestWindows(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	q := New(Limit{PerHour: 2, PerDay: 3})
+	q.now = func() time.Time { return now }
+	q.Set("ci", Limit{PerDay: 1})
+	var tests = []struct {
+		subject   string
+		wakes     int
+		after     time.Duration
+		hour, day Window
+	}{
+		{"alice", 0, 0, Window{Limit: 2, Remaining: 2}, Window{Limit: 3, Remaining: 3}},
+		{"alice", 1, 0, Window{Limit: 2, Remaining: 1, Reset: time.Hour}, Window{Limit: 3, Remaining: 2, Reset: 24 * time.Hour}},
+		{"alice", 1, 30 * time.Minute, Window{Limit: 2, Remaining: 0, Reset: 30 * time.Minute}, Window{Limit: 3, Remaining: 1, Reset: 23*time.Hour + 30*time.Minute}},
+		{"alice", 0, time.Hour, Window{Limit: 2, Remaining: 2}, Window{Limit: 3, Remaining: 1, Reset: 22*time.Hour + 30*time.Minute}},
+		{"ci", 1, 0, Window{}, Window{Limit: 1, Remaining: 0, Reset: 24 * time.Hour}},
+	}
+	for i, tt := range tests {
+		now = now.Add(tt.after)
+		for j := 0; j < tt.wakes; j++ {
+			q.Allow(tt.subject)
+		}
+		hour, day := q.Windows(tt.subject)
+		if hour != tt.hour || day != tt.day {
+			t.Errorf("#%d: want (%+v, %+v), got (%+v, %+v)", i, tt.hour, tt.day, hour, day)
+		}
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	if l, err := ParseLimit("10/50"); err != nil || l != (Limit{PerHour: 10, PerDay: 50}) {
+		t.Errorf("unexpected limit %+v (%v)", l, err)
+	}
+	for _, s := range []string{"10", "a/1", "1/-1"} {
+		if _, err := ParseLimit(s); err == nil {
+			t.Errorf("want error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Package ratelimit limits the rate of requests made by each client.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often clients whose bucket has been refilled are forgotten.
+const pruneInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket for each client, allowing rate requests per second with bursts of up to burst requests.
+type Limiter struct {
+	rate    float64
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+	now     func() time.Time
+}
+
+// New creates a new limiter allowing each client rate requests per second, with bursts of up to burst requests.
+func New(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket), now: time.Now}
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+}
+
+// prune forgets clients whose bucket is full, as they are indistinguishable from new clients.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < pruneInterval {
+		return
+	}
+	l.pruned = now
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// Allow reports whether client can make a request now. If not, the time until it can is returned.
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.prune(now)
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	l.refill(b, now)
+	if b.tokens < 1 {
+		if l.rate <= 0 {
+			return false, 0
+		}
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Remaining returns the burst of the limiter, the number of requests client can currently make, and the time until it
+// can make a full burst again.
+func (l *Limiter) Remaining(client string) (int, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tokens := l.burst
+	if b, ok := l.buckets[client]; ok {
+		tokens = b.tokens + l.now().Sub(b.last).Seconds()*l.rate
+		if tokens > l.burst {
+			tokens = l.burst
+		}
+	}
+	var reset time.Duration
+	if l.rate > 0 {
+		reset = time.Duration((l.burst - tokens) / l.rate * float64(time.Second))
+	}
+	return int(l.burst), int(tokens), reset
+}
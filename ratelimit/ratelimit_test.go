@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Now()
+	l := New(2, 3)
+	l.now = func() time.Time { return now }
+
+	// Burst is allowed
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("192.0.2.1"); !ok {
+			t.Fatalf("#%d: want request allowed", i)
+		}
+	}
+	ok, retry := l.Allow("192.0.2.1")
+	if ok || retry != 500*time.Millisecond {
+		t.Fatalf("want request rejected for 500ms, got %t and %s", ok, retry)
+	}
+	// Clients are limited independently
+	if ok, _ := l.Allow("192.0.2.2"); !ok {
+		t.Fatal("want request of other client allowed")
+	}
+
+	// Tokens are refilled
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("192.0.2.1"); !ok {
+		t.Fatal("want request allowed after refill")
+	}
+	if ok, _ := l.Allow("192.0.2.1"); ok {
+		t.Fatal("want request rejected")
+	}
+
+	// Clients having full buckets are forgotten
+	now = now.Add(pruneInterval)
+	if ok, _ := l.Allow("192.0.2.3"); !ok {
+		t.Fatal("want request allowed")
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("want 1 client, got %d", len(l.buckets))
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	now := time.Now()
+	l := New(0.5, 3)
+	l.now = func() time.Time { return now }
+	var tests = []struct {
+		allow     int
+		after     time.Duration
+		remaining int
+		reset     time.Duration
+	}{
+		{0, 0, 3, 0},
+		{2, 0, 1, 4 * time.Second},
+		{0, time.Second, 1, 3 * time.Second},
+		{1, time.Second, 1, 4 * time.Second},
+		{0, time.Minute, 3, 0},
+	}
+	for i, tt := range tests {
+		for j := 0; j < tt.allow; j++ {
+			l.Allow("192.0.2.1")
+		}
+		now = now.Add(tt.after)
+		limit, remaining, reset := l.Remaining("192.0.2.1")
+		if limit != 3 || remaining != tt.remaining || reset != tt.reset {
+			t.Errorf("#%d: want 3/%d/%s, got %d/%d/%s", i, tt.remaining, tt.reset, limit, remaining, reset)
+		}
+	}
+	if _, remaining, _ := l.Remaining("192.0.2.2"); remaining != 3 {
+		t.Errorf("want full burst for unknown client, got %d", remaining)
+	}
+}
@@ -0,0 +1,70 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+type config struct {
+	SMTP struct {
+		Address  string `json:"address"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		From     string `json:"from"`
+	} `json:"smtp"`
+	To      []string `json:"to"`
+	Period  string   `json:"period"`
+	Weekday string   `json:"weekday"`
+	At      string   `json:"at"`
+}
+
+// ReadConfig reads the mailer and schedule of reports from the JSON file at name.
+func ReadConfig(name string) (*SMTP, Schedule, error) {
+	var s Schedule
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, s, err
+	}
+	var c config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, s, err
+	}
+	if c.SMTP.Address == "" || c.SMTP.From == "" || len(c.To) == 0 {
+		return nil, s, fmt.Errorf("smtp address, from and to are required")
+	}
+	s.Period = c.Period
+	switch s.Period {
+	case "":
+		s.Period = Daily
+	case Daily, Weekly:
+	default:
+		return nil, s, fmt.Errorf("invalid period: %s", c.Period)
+	}
+	if c.Weekday != "" {
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(d.String(), c.Weekday) {
+				s.Weekday, found = d, true
+			}
+		}
+		if !found {
+			return nil, s, fmt.Errorf("invalid weekday: %s", c.Weekday)
+		}
+	} else {
+		s.Weekday = time.Monday
+	}
+	at := c.At
+	if at == "" {
+		at = "08:00"
+	}
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return nil, s, fmt.Errorf("invalid time: %s", at)
+	}
+	s.Hour, s.Minute = t.Hour(), t.Minute()
+	mailer := &SMTP{Addr: c.SMTP.Address, Username: c.SMTP.Username, Password: c.SMTP.Password, From: c.SMTP.From, To: c.To}
+	return mailer, s, nil
+}
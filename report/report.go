@@ -0,0 +1,271 @@
+// Package report sends periodic status reports summarizing the uptime, wakes and failures of devices.
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+// Device states.
+const (
+	StateOnline  = "online"
+	StateOffline = "offline"
+	StateUnknown = "unknown"
+)
+
+// Periods.
+const (
+	Daily  = "daily"
+	Weekly = "weekly"
+)
+
+// Device is a device included in the report.
+type Device struct {
+	Name       string
+	MACAddress string
+}
+
+// DeviceStatus summarizes a device in a report.
+type DeviceStatus struct {
+	Device
+	State    string
+	Uptime   time.Duration
+	Wakes    int
+	Failures int
+}
+
+// Report summarizes devices over a period.
+type Report struct {
+	From    time.Time
+	To      time.Time
+	Devices []DeviceStatus
+}
+
+// Offline returns the devices which were offline at the end of the report period.
+func (r *Report) Offline() []DeviceStatus {
+	var offline []DeviceStatus
+	for _, d := range r.Devices {
+		if d.State == StateOffline {
+			offline = append(offline, d)
+		}
+	}
+	return offline
+}
+
+type stats struct {
+	online   bool
+	known    bool
+	since    time.Time
+	uptime   time.Duration
+	wakes    int
+	failures int
+}
+
+// Collector collects device statistics from events.
+type Collector struct {
+	mu      sync.Mutex
+	start   time.Time
+	devices map[string]*stats
+	now     func() time.Time
+}
+
+// NewCollector creates a new collector.
+func NewCollector() *Collector {
+	return &Collector{start: time.Now(), devices: make(map[string]*stats), now: time.Now}
+}
+
+func (c *Collector) stats(macAddress string) *stats {
+	key := strings.ToUpper(macAddress)
+	if hwAddr, err := net.ParseMAC(macAddress); err == nil {
+		key = strings.ToUpper(hwAddr.String())
+	}
+	s, ok := c.devices[key]
+	if !ok {
+		s = &stats{}
+		c.devices[key] = s
+	}
+	return s
+}
+
+// Handle records event e.
+func (c *Collector) Handle(e event.Event) {
+	switch e.Type {
+	case event.Unknown, event.Added, event.Removed:
+		// Wakes requested for unknown devices are counted by their wake events, while changes to the inventory are not
+		// reported
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	at := e.Time
+	if at.IsZero() {
+		at = c.now()
+	}
+	s := c.stats(e.MACAddress)
+	switch e.Type {
+	case event.Wake:
+		s.wakes++
+	case event.Failed:
+		s.failures++
+	case event.Online:
+		if !s.online {
+			s.online, s.since = true, at
+		}
+		s.known = true
+	case event.Offline:
+		if s.online {
+			s.uptime += at.Sub(s.since)
+		}
+		s.online, s.known = false, true
+	}
+}
+
+// Run handles events until the events channel is closed.
+func (c *Collector) Run(events <-chan event.Event) {
+	for e := range events {
+		c.Handle(e)
+	}
+}
+
+// Report returns the report of devices since the collector started, or since the last report if reset is true. A
+// reset clears wake and failure counts and uptime, but keeps the state of devices.
+func (c *Collector) Report(devices []Device, reset bool) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	r := Report{From: c.start, To: now, Devices: make([]DeviceStatus, 0, len(devices))}
+	for _, d := range devices {
+		s := c.stats(d.MACAddress)
+		status := DeviceStatus{Device: d, State: StateUnknown, Uptime: s.uptime, Wakes: s.wakes, Failures: s.failures}
+		if s.known {
+			status.State = StateOffline
+		}
+		if s.online {
+			status.State = StateOnline
+			since := s.since
+			if since.Before(c.start) {
+				since = c.start
+			}
+			status.Uptime += now.Sub(since)
+		}
+		r.Devices = append(r.Devices, status)
+	}
+	sort.Slice(r.Devices, func(i, j int) bool { return r.Devices[i].MACAddress < r.Devices[j].MACAddress })
+	if reset {
+		c.start = now
+		for _, s := range c.devices {
+			s.uptime, s.wakes, s.failures = 0, 0, 0
+			if s.online {
+				s.since = now
+			}
+		}
+	}
+	return r
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Minute).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif">
+<h2>Wake-on-LAN report</h2>
+<p>{{date .From}} to {{date .To}}</p>
+{{with .Offline}}<p><strong>{{len .}} offline:</strong> {{range $i, $d := .}}{{if $i}}, {{end}}{{or $d.Name $d.MACAddress}}{{end}}</p>{{end}}
+<table cellpadding="4" style="border-collapse: collapse">
+<tr><th align="left">Device</th><th align="left">MAC address</th><th align="left">State</th><th align="right">Uptime</th><th align="right">Wakes</th><th align="right">Failures</th></tr>
+{{range .Devices}}<tr><td>{{.Name}}</td><td><code>{{.MACAddress}}</code></td><td>{{.State}}</td><td align="right">{{duration .Uptime}}</td><td align="right">{{.Wakes}}</td><td align="right">{{.Failures}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// HTML renders the report as an HTML document.
+func (r *Report) HTML() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Subject returns the subject of the report email.
+func (r *Report) Subject() string {
+	var wakes, failures int
+	for _, d := range r.Devices {
+		wakes += d.Wakes
+		failures += d.Failures
+	}
+	return fmt.Sprintf("Wake-on-LAN report: %d wakes, %d failures, %d offline", wakes, failures, len(r.Offline()))
+}
+
+// Schedule is when reports are sent.
+type Schedule struct {
+	// Period is either daily or weekly.
+	Period string
+	// Weekday is the day weekly reports are sent.
+	Weekday time.Weekday
+	// Hour and Minute is the time of day reports are sent.
+	Hour   int
+	Minute int
+}
+
+// Next returns the next time a report is due after t.
+func (s Schedule) Next(t time.Time) time.Time {
+	next := time.Date(t.Year(), t.Month(), t.Day(), s.Hour, s.Minute, 0, 0, t.Location())
+	if s.Period == Weekly {
+		next = next.AddDate(0, 0, (int(s.Weekday)-int(next.Weekday())+7)%7)
+	}
+	for !next.After(t) {
+		if s.Period == Weekly {
+			next = next.AddDate(0, 0, 7)
+		} else {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next
+}
+
+// Reporter sends reports of devices to a mailer.
+type Reporter struct {
+	Collector *Collector
+	Mailer    Mailer
+	Schedule  Schedule
+	// Devices returns the devices to report on.
+	Devices func() ([]Device, error)
+}
+
+// Send sends a report of the period since the last report.
+func (r *Reporter) Send() error {
+	devices, err := r.Devices()
+	if err != nil {
+		return err
+	}
+	report := r.Collector.Report(devices, true)
+	body, err := report.HTML()
+	if err != nil {
+		return err
+	}
+	return r.Mailer.Send(report.Subject(), body)
+}
+
+// Run sends reports according to the schedule.
+func (r *Reporter) Run() {
+	for {
+		time.Sleep(time.Until(r.Schedule.Next(time.Now())))
+		if err := r.Send(); err != nil {
+			log.Printf("report: %s", err)
+		}
+	}
+}
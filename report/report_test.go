@@ -0,0 +1,122 @@
+package report
+
+import (
+	"io/ioutil"
+	"net/smtp"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+func TestReport(t *testing.T) {
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewCollector()
+	c.start = now
+	c.now = func() time.Time { return now }
+	events := []event.Event{
+		{Type: event.Wake, MACAddress: "AB:CD:EF:12:34:56", Time: now},
+		{Type: event.Online, MACAddress: "ab-cd-ef-12-34-56", Time: now.Add(time.Minute)},
+		{Type: event.Offline, MACAddress: "AB:CD:EF:12:34:56", Time: now.Add(3 * time.Hour)},
+		{Type: event.Failed, MACAddress: "AB:CD:EF:12:34:57", Time: now},
+		{Type: event.Online, MACAddress: "AB:CD:EF:12:34:57", Time: now.Add(20 * time.Hour)},
+	}
+	for _, e := range events {
+		c.Handle(e)
+	}
+	now = now.Add(24 * time.Hour)
+	devices := []Device{{Name: "pc", MACAddress: "AB:CD:EF:12:34:57"}, {Name: "nas", MACAddress: "AB:CD:EF:12:34:56"}, {MACAddress: "AB:CD:EF:12:34:58"}}
+	r := c.Report(devices, true)
+	want := []DeviceStatus{
+		{Device: devices[1], State: StateOffline, Uptime: 2*time.Hour + 59*time.Minute, Wakes: 1},
+		{Device: devices[0], State: StateOnline, Uptime: 4 * time.Hour, Failures: 1},
+		{Device: devices[2], State: StateUnknown},
+	}
+	for i, d := range r.Devices {
+		if d != want[i] {
+			t.Errorf("#%d: want %+v, got %+v", i, want[i], d)
+		}
+	}
+	if want := "Wake-on-LAN report: 1 wakes, 1 failures, 1 offline"; r.Subject() != want {
+		t.Errorf("want subject %q, got %q", want, r.Subject())
+	}
+	html, err := r.HTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<strong>1 offline:</strong> nas"; !strings.Contains(string(html), want) {
+		t.Errorf("want %q in %s", want, html)
+	}
+
+	// Counts are reset, while state is kept
+	now = now.Add(time.Hour)
+	r = c.Report(devices[:1], false)
+	if want := (DeviceStatus{Device: devices[0], State: StateOnline, Uptime: time.Hour}); r.Devices[0] != want {
+		t.Errorf("want %+v, got %+v", want, r.Devices[0])
+	}
+}
+
+func TestNext(t *testing.T) {
+	now := time.Date(2019, 1, 2, 9, 0, 0, 0, time.UTC) // Wednesday
+	var tests = []struct {
+		schedule Schedule
+		next     time.Time
+	}{
+		{Schedule{Period: Daily, Hour: 8}, time.Date(2019, 1, 3, 8, 0, 0, 0, time.UTC)},
+		{Schedule{Period: Daily, Hour: 10, Minute: 30}, time.Date(2019, 1, 2, 10, 30, 0, 0, time.UTC)},
+		{Schedule{Period: Weekly, Weekday: time.Monday, Hour: 8}, time.Date(2019, 1, 7, 8, 0, 0, 0, time.UTC)},
+		{Schedule{Period: Weekly, Weekday: time.Wednesday, Hour: 8}, time.Date(2019, 1, 9, 8, 0, 0, 0, time.UTC)},
+		{Schedule{Period: Weekly, Weekday: time.Wednesday, Hour: 10}, time.Date(2019, 1, 2, 10, 0, 0, 0, time.UTC)},
+	}
+	for i, tt := range tests {
+		if got := tt.schedule.Next(now); !got.Equal(tt.next) {
+			t.Errorf("#%d: want %s, got %s", i, tt.next, got)
+		}
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	var (
+		addr string
+		to   []string
+		msg  string
+	)
+	s := &SMTP{Addr: "smtp.example.com:587", Username: "u", Password: "p", From: "wakeup@example.com", To: []string{"a@example.com", "b@example.com"}}
+	s.sendMail = func(a string, auth smtp.Auth, from string, rcpt []string, m []byte) error {
+		addr, to, msg = a, rcpt, string(m)
+		return nil
+	}
+	if err := s.Send("Report", []byte("<p>ok</p>\n")); err != nil {
+		t.Fatal(err)
+	}
+	if addr != s.Addr || len(to) != 2 {
+		t.Errorf("want mail sent to %v through %s, got %v through %s", s.To, s.Addr, to, addr)
+	}
+	for _, want := range []string{"To: a@example.com, b@example.com\r\n", "Subject: Report\r\n", "Content-Type: text/html; charset=utf-8\r\n", "\r\n\r\n<p>ok</p>\r\n"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("want %q in %q", want, msg)
+		}
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"smtp":{"address":"smtp.example.com:587","from":"wakeup@example.com"},"to":["ops@example.com"],"period":"weekly","weekday":"friday","at":"17:30"}`)
+	f.Close()
+	mailer, schedule, err := ReadConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Schedule{Period: Weekly, Weekday: time.Friday, Hour: 17, Minute: 30}); schedule != want {
+		t.Errorf("want %+v, got %+v", want, schedule)
+	}
+	if mailer.Addr != "smtp.example.com:587" || mailer.To[0] != "ops@example.com" {
+		t.Errorf("unexpected mailer %+v", mailer)
+	}
+}
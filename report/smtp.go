@@ -0,0 +1,57 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends HTML email.
+type Mailer interface {
+	Send(subject string, html []byte) error
+}
+
+// SMTP sends email through an SMTP server. STARTTLS is used if the server supports it.
+type SMTP struct {
+	// Addr is the address of the server, e.g. smtp.example.com:587.
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func (s *SMTP) message(subject string, html []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.Write(bytes.Replace(html, []byte("\n"), []byte("\r\n"), -1))
+	return buf.Bytes()
+}
+
+// Send sends an email having subject and HTML body html.
+func (s *SMTP) Send(subject string, html []byte) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	sendMail := s.sendMail
+	if sendMail == nil {
+		sendMail = smtp.SendMail
+	}
+	return sendMail(s.Addr, auth, s.From, s.To, s.message(subject, html))
+}
@@ -0,0 +1,139 @@
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+)
+
+type scheduleConfig struct {
+	Name       string `json:"name"`
+	Device     string `json:"device"`
+	MACAddress string `json:"macAddress"`
+	Group      string `json:"group"`
+	At         string `json:"at"`
+	Days       string `json:"days"`
+	Cron       string `json:"cron"`
+	TimeZone   string `json:"timeZone"`
+	Window     string `json:"window"`
+	Optimize   string `json:"optimize"`
+	// SkipIfOnline is a pointer, so that the default of the server applies when omitted
+	SkipIfOnline *bool `json:"skipIfOnline"`
+}
+
+type sourceConfig struct {
+	Type    string             `json:"type"`
+	URL     string             `json:"url"`
+	Token   string             `json:"token"`
+	Records string             `json:"records"`
+	Start   string             `json:"start"`
+	Value   string             `json:"value"`
+	Table   map[string]float64 `json:"table"`
+}
+
+// parseWhen sets when s is due, from either a time of day at and optionally days, or a cron expression, evaluated in
+// the named time zone if set.
+func (s *Schedule) parseWhen(at, days, cron, timeZone string) error {
+	var err error
+	switch {
+	case cron != "" && (at != "" || days != ""):
+		return fmt.Errorf("cron cannot be combined with at or days")
+	case cron != "":
+		s.Cron, err = ParseCron(cron)
+	case days != "":
+		s.Cron, err = At(at, days)
+	default:
+		err = s.ParseTime(at)
+	}
+	if err != nil {
+		return err
+	}
+	if timeZone != "" {
+		if s.Location, err = time.LoadLocation(timeZone); err != nil {
+			return fmt.Errorf("invalid time zone: %s", timeZone)
+		}
+	}
+	return nil
+}
+
+// ReadConfig reads schedules and the sources they are optimized by from the JSON file at name.
+func ReadConfig(name string) ([]Schedule, map[string]Source, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	var c struct {
+		Schedules []scheduleConfig        `json:"schedules"`
+		Sources   map[string]sourceConfig `json:"sources"`
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, nil, err
+	}
+	sources := make(map[string]Source, len(c.Sources))
+	for target, sc := range c.Sources {
+		switch target {
+		case OptimizeCarbon, OptimizePrice:
+		default:
+			return nil, nil, fmt.Errorf("source %s: invalid target", target)
+		}
+		switch sc.Type {
+		case "table":
+			t := make(Table, len(sc.Table))
+			for h, v := range sc.Table {
+				hour, err := strconv.Atoi(h)
+				if err != nil || hour < 0 || hour > 23 {
+					return nil, nil, fmt.Errorf("source %s: invalid hour: %s", target, h)
+				}
+				t[hour] = v
+			}
+			sources[target] = t
+		case "http":
+			sources[target] = &HTTP{URL: sc.URL, Token: sc.Token, Records: sc.Records, Start: sc.Start, Value: sc.Value}
+		default:
+			return nil, nil, fmt.Errorf("source %s: invalid type: %s", target, sc.Type)
+		}
+	}
+	schedules := make([]Schedule, 0, len(c.Schedules))
+	for i, sc := range c.Schedules {
+		s := Schedule{Name: sc.Name, Device: sc.Device, MACAddress: sc.MACAddress, Group: sc.Group, Optimize: sc.Optimize, SkipIfOnline: sc.SkipIfOnline}
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("#%d", i)
+		}
+		n := 0
+		for _, v := range []string{s.Device, s.MACAddress, s.Group} {
+			if v != "" {
+				n++
+			}
+		}
+		if n != 1 {
+			return nil, nil, fmt.Errorf("schedule %s: must have one of device, macAddress or group", s.Name)
+		}
+		if s.MACAddress != "" {
+			if _, err := net.ParseMAC(s.MACAddress); err != nil {
+				return nil, nil, fmt.Errorf("schedule %s: invalid macAddress: %s", s.Name, s.MACAddress)
+			}
+		}
+		if err := s.parseWhen(sc.At, sc.Days, sc.Cron, sc.TimeZone); err != nil {
+			return nil, nil, fmt.Errorf("schedule %s: %s", s.Name, err)
+		}
+		if sc.Window != "" {
+			if s.Window, err = time.ParseDuration(sc.Window); err != nil {
+				return nil, nil, fmt.Errorf("schedule %s: %s", s.Name, err)
+			}
+		}
+		switch s.Optimize {
+		case "":
+		case OptimizeCarbon, OptimizePrice:
+			if _, ok := sources[s.Optimize]; !ok {
+				return nil, nil, fmt.Errorf("schedule %s: no %s source configured", s.Name, s.Optimize)
+			}
+		default:
+			return nil, nil, fmt.Errorf("schedule %s: invalid optimize: %s", s.Name, s.Optimize)
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, sources, nil
+}
@@ -0,0 +1,203 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression of five fields: minute, hour, day of month, month and day of week. Each field is
+// *, a value, a range such as mon-fri, or a comma-separated list of those, optionally followed by a step such as */15.
+// As in cron, a day matches if either day field matches when both are restricted.
+type Cron struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+type cronField struct {
+	min, max int
+	names    []string
+}
+
+var cronFields = [5]cronField{
+	{0, 59, nil},
+	{0, 23, nil},
+	{1, 31, nil},
+	{1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	// 7 is also Sunday
+	{0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// ParseCron parses the cron expression expr.
+func ParseCron(expr string) (*Cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression: %s: must have 5 fields", expr)
+	}
+	var sets [5]uint64
+	for j, f := range fields {
+		set, err := cronFields[j].parse(strings.ToLower(f))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression: %s: %s", expr, err)
+		}
+		sets[j] = set
+	}
+	c := &Cron{
+		expr:          strings.Join(fields, " "),
+		minute:        sets[0],
+		hour:          sets[1],
+		dom:           sets[2],
+		month:         sets[3],
+		dow:           sets[4],
+		domRestricted: fields[2] != "*",
+		dowRestricted: fields[4] != "*",
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	for j, name := range f.names {
+		if s == name {
+			return f.min + j, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("invalid value: %s", s)
+	}
+	return n, nil
+}
+
+func (f cronField) parse(s string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if j := strings.Index(part, "/"); j >= 0 {
+			n, err := strconv.Atoi(part[j+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step: %s", part)
+			}
+			step, part = n, part[:j]
+		}
+		lo, hi := f.min, f.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = f.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				hi = f.max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range: %s", part)
+			}
+		}
+		for n := lo; n <= hi; n += step {
+			set |= 1 << uint(n)
+		}
+	}
+	return set, nil
+}
+
+func (c *Cron) String() string { return c.expr }
+
+// At returns the cron expression of waking at the time of day at, in the format HH:MM, on days, which is a day of week
+// field such as mon-fri, weekdays, weekends, or empty for every day.
+func At(at, days string) (*Cron, error) {
+	var s Schedule
+	if err := s.ParseTime(at); err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(days) {
+	case "", "daily":
+		days = "*"
+	case "weekdays":
+		days = "mon-fri"
+	case "weekends":
+		days = "sat,sun"
+	}
+	return ParseCron(fmt.Sprintf("%d %d * * %s", s.Minute, s.Hour, days))
+}
+
+func (c *Cron) matchDay(t time.Time) bool {
+	if c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domRestricted && c.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// prev returns the most recent time matching c, at or before t. The zero time is returned if c has not matched in the
+// past years, e.g. for February 30.
+func (c *Cron) prev(t time.Time) time.Time {
+	for off := 0; off < 5*366; off++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()-off, 0, 0, 0, 0, t.Location())
+		if !c.matchDay(day) {
+			continue
+		}
+		maxHour := 23
+		if off == 0 {
+			maxHour = t.Hour()
+		}
+		for h := maxHour; h >= 0; h-- {
+			if c.hour&(1<<uint(h)) == 0 {
+				continue
+			}
+			maxMinute := 59
+			if off == 0 && h == t.Hour() {
+				maxMinute = t.Minute()
+			}
+			for m := maxMinute; m >= 0; m-- {
+				if c.minute&(1<<uint(m)) != 0 {
+					return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, t.Location())
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// next returns the earliest time matching c after t. The zero time is returned if c does not match in the coming years.
+func (c *Cron) next(t time.Time) time.Time {
+	start := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	for off := 0; off < 5*366; off++ {
+		day := time.Date(start.Year(), start.Month(), start.Day()+off, 0, 0, 0, 0, t.Location())
+		if !c.matchDay(day) {
+			continue
+		}
+		minHour := 0
+		if off == 0 {
+			minHour = start.Hour()
+		}
+		for h := minHour; h < 24; h++ {
+			if c.hour&(1<<uint(h)) == 0 {
+				continue
+			}
+			minMinute := 0
+			if off == 0 && h == start.Hour() {
+				minMinute = start.Minute()
+			}
+			for m := minMinute; m < 60; m++ {
+				if c.minute&(1<<uint(m)) != 0 {
+					return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, t.Location())
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
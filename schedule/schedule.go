@@ -0,0 +1,231 @@
+// Package schedule wakes devices at given times of the day, optionally shifted within a window to the hours having the
+// lowest carbon intensity or electricity price.
+package schedule
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Optimization targets.
+const (
+	OptimizeCarbon = "carbon"
+	OptimizePrice  = "price"
+)
+
+// Schedule wakes a device, or all devices in a group, every day or as given by its cron expression.
+type Schedule struct {
+	Name string
+	// Device is the ID of the device to wake. A device which is not stored can be woken by its MACAddress.
+	Device     string
+	MACAddress string
+	Group      string
+	// Hour and Minute is the time of day of the wake, unless Cron is set.
+	Hour   int
+	Minute int
+	Cron   *Cron
+	// Location is the time zone the schedule is evaluated in. Defaults to the time zone of the scheduler clock.
+	Location *time.Location
+	// Window is how long after the time of day the wake may be shifted to when optimizing.
+	Window time.Duration
+	// Optimize is the source used to shift the wake, one of carbon, price, or empty to never shift.
+	Optimize string
+	// SkipIfOnline overrides whether the wake is skipped for devices that are already online, if set.
+	SkipIfOnline *bool
+}
+
+// ParseTime sets the time of day of s from a string in the format HH:MM.
+func (s *Schedule) ParseTime(v string) error {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return fmt.Errorf("invalid time: %s", v)
+	}
+	s.Hour, s.Minute = t.Hour(), t.Minute()
+	return nil
+}
+
+// occurrence returns the most recent time s was due, at or before now.
+func (s *Schedule) occurrence(now time.Time) time.Time {
+	if s.Location != nil {
+		now = now.In(s.Location)
+	}
+	if s.Cron != nil {
+		return s.Cron.prev(now)
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), s.Hour, s.Minute, 0, 0, now.Location())
+	if t.After(now) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// Next returns the earliest time s is due after t, or the zero time if s is never due.
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.Location != nil {
+		t = t.In(s.Location)
+	}
+	if s.Cron != nil {
+		return s.Cron.next(t)
+	}
+	next := time.Date(t.Year(), t.Month(), t.Day(), s.Hour, s.Minute, 0, 0, t.Location())
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc is a function telling the current time.
+type ClockFunc func() time.Time
+
+// Now returns the current time.
+func (f ClockFunc) Now() time.Time { return f() }
+
+// SystemClock is the clock of the system.
+var SystemClock Clock = ClockFunc(time.Now)
+
+// Planned is the next planned wake of a schedule.
+type Planned struct {
+	Name string    `json:"name"`
+	Due  time.Time `json:"due"`
+	At   time.Time `json:"at"`
+}
+
+// Scheduler runs schedules. The state of each schedule is kept by its name, so names must be unique.
+type Scheduler struct {
+	Schedules []Schedule
+	// Dynamic returns further schedules, which may change between ticks, such as those stored with devices.
+	Dynamic func() ([]Schedule, error)
+	// Sources forecast values for each optimization target.
+	Sources map[string]Source
+	Wake    func(s Schedule) error
+	// Clock tells the time schedules are due by.
+	Clock Clock
+
+	mu      sync.Mutex
+	current []Schedule
+	planned map[string]Planned
+	done    map[string]time.Time
+}
+
+// New creates a new scheduler running schedules using wake.
+func New(schedules []Schedule, wake func(s Schedule) error) *Scheduler {
+	return &Scheduler{
+		Schedules: schedules,
+		Sources:   make(map[string]Source),
+		Wake:      wake,
+		planned:   make(map[string]Planned),
+		done:      make(map[string]time.Time),
+		Clock:     SystemClock,
+	}
+}
+
+// Plan returns the time s should wake devices for the occurrence due. The due time is used if s is not optimized, or if
+// its source fails.
+func (sc *Scheduler) Plan(s Schedule, due time.Time) time.Time {
+	if s.Optimize == "" || s.Window <= 0 {
+		return due
+	}
+	source, ok := sc.Sources[s.Optimize]
+	if !ok {
+		log.Printf("schedule: %s: no %s source configured", s.Name, s.Optimize)
+		return due
+	}
+	latest := due.Add(s.Window)
+	slots, err := source.Forecast(due, latest)
+	if err != nil {
+		log.Printf("schedule: %s: %s", s.Name, err)
+		return due
+	}
+	return Best(slots, due, latest)
+}
+
+// schedules returns the static and dynamic schedules. The schedules of the previous tick are kept if the dynamic
+// schedules cannot be read.
+func (sc *Scheduler) schedules() []Schedule {
+	if sc.Dynamic == nil {
+		return sc.Schedules
+	}
+	dynamic, err := sc.Dynamic()
+	if err != nil {
+		log.Printf("schedule: %s", err)
+		return sc.current
+	}
+	return append(append(make([]Schedule, 0, len(sc.Schedules)+len(dynamic)), sc.Schedules...), dynamic...)
+}
+
+// Tick wakes devices of all schedules whose planned time has passed. The planned time of each occurrence is decided once,
+// when the occurrence becomes due. Occurrences that were past their window when a schedule is first seen are skipped.
+func (sc *Scheduler) Tick() {
+	schedules := sc.schedules()
+	sc.mu.Lock()
+	now := sc.Clock.Now()
+	var due []Schedule
+	seen := make(map[string]bool, len(schedules))
+	for _, s := range schedules {
+		seen[s.Name] = true
+		occ := s.occurrence(now)
+		done, ok := sc.done[s.Name]
+		if !ok && occ.Add(s.Window).Before(now) {
+			done = occ
+			sc.done[s.Name] = done
+		}
+		if !done.Before(occ) {
+			continue
+		}
+		p, ok := sc.planned[s.Name]
+		if !ok || !p.Due.Equal(occ) {
+			p = Planned{Name: s.Name, Due: occ, At: sc.Plan(s, occ)}
+			sc.planned[s.Name] = p
+		}
+		if !now.Before(p.At) {
+			sc.done[s.Name] = occ
+			due = append(due, s)
+		}
+	}
+	// Forget removed schedules, so that a schedule added later under the same name starts afresh
+	for name := range sc.done {
+		if !seen[name] {
+			delete(sc.done, name)
+		}
+	}
+	for name := range sc.planned {
+		if !seen[name] {
+			delete(sc.planned, name)
+		}
+	}
+	sc.current = schedules
+	sc.mu.Unlock()
+	for _, s := range due {
+		if err := sc.Wake(s); err != nil {
+			log.Printf("schedule: %s: %s", s.Name, err)
+		}
+	}
+}
+
+// Planned returns the planned wakes of occurrences that are due but not yet woken.
+func (sc *Scheduler) Planned() []Planned {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	var planned []Planned
+	for _, s := range sc.current {
+		if p, ok := sc.planned[s.Name]; ok && sc.done[s.Name].Before(p.Due) {
+			planned = append(planned, p)
+		}
+	}
+	return planned
+}
+
+// Run ticks every interval. Occurrences that were due before Run is called are skipped.
+func (sc *Scheduler) Run(interval time.Duration) {
+	for {
+		sc.Tick()
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,190 @@
+package schedule
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBest(t *testing.T) {
+	start := time.Date(2019, 1, 1, 6, 30, 0, 0, time.UTC)
+	table := Table{6: 300, 7: 200, 8: 100, 9: 100, 10: 50}
+	var tests = []struct {
+		window time.Duration
+		want   time.Time
+	}{
+		{0, start},
+		{time.Hour, start.Add(30 * time.Minute)},
+		{3 * time.Hour, start.Add(90 * time.Minute)},
+		{4 * time.Hour, start.Add(210 * time.Minute)},
+	}
+	for i, tt := range tests {
+		slots, _ := table.Forecast(start, start.Add(tt.window))
+		if got := Best(slots, start, start.Add(tt.window)); !got.Equal(tt.want) {
+			t.Errorf("#%d: want %s, got %s", i, tt.want, got)
+		}
+	}
+	if got := Best(nil, start, start.Add(time.Hour)); !got.Equal(start) {
+		t.Errorf("want %s without slots, got %s", start, got)
+	}
+}
+
+func TestHTTPForecast(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"forecast":[`+
+			`{"datetime":"2019-01-01T08:00:00Z","carbonIntensity":120},`+
+			`{"datetime":"2019-01-01T06:00:00Z","carbonIntensity":300},`+
+			`{"datetime":"2019-01-01T07:00:00Z","carbonIntensity":"80"}]}`)
+	}))
+	defer server.Close()
+	h := &HTTP{URL: server.URL, Value: "carbonIntensity"}
+	from := time.Date(2019, 1, 1, 6, 30, 0, 0, time.UTC)
+	slots, err := h.Forecast(from, from.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 2 || slots[1].Value != 80 || !slots[0].End.Equal(slots[1].Start) {
+		t.Fatalf("unexpected slots %+v", slots)
+	}
+	if got, want := Best(slots, from, from.Add(time.Hour)), from.Add(30*time.Minute); !got.Equal(want) {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	now := time.Date(2019, 1, 1, 5, 0, 0, 0, time.UTC)
+	var woken []string
+	sc := New([]Schedule{
+		{Name: "charger", MACAddress: "AB:CD:EF:12:34:56", Hour: 6, Window: 4 * time.Hour, Optimize: OptimizePrice},
+		{Name: "nas", MACAddress: "AB:CD:EF:12:34:57", Hour: 6},
+	}, func(s Schedule) error {
+		woken = append(woken, fmt.Sprintf("%s@%s", s.Name, now.Format("15:04")))
+		return nil
+	})
+	sc.Sources[OptimizePrice] = Table{6: 0.4, 7: 0.3, 8: 0.1, 9: 0.2}
+	sc.Clock = ClockFunc(func() time.Time { return now })
+	// Occurrences that were due the previous day are skipped, as their window has passed when first seen
+	for i := 0; i < 6*60; i++ {
+		sc.Tick()
+		if now.Format("15:04") == "07:00" {
+			if p := sc.Planned(); len(p) != 1 || p[0].Name != "charger" || p[0].At.Hour() != 8 {
+				t.Errorf("want charger planned at 08:00, got %+v", p)
+			}
+		}
+		now = now.Add(time.Minute)
+	}
+	want := []string{"nas@06:00", "charger@08:00"}
+	if fmt.Sprint(woken) != fmt.Sprint(want) {
+		t.Errorf("want %v, got %v", want, woken)
+	}
+}
+
+func TestCron(t *testing.T) {
+	// 2019-01-02 is a Wednesday
+	now := time.Date(2019, 1, 2, 7, 45, 30, 0, time.UTC)
+	var tests = []struct {
+		expr string
+		want string
+	}{
+		{"30 7 * * *", "2019-01-02 07:30"},
+		{"30 7 * * mon-fri", "2019-01-02 07:30"},
+		{"30 8 * * mon-fri", "2019-01-01 08:30"},
+		{"0 9 * * SAT,sun", "2018-12-30 09:00"},
+		{"*/20 * * * *", "2019-01-02 07:40"},
+		{"0 0 1 * *", "2019-01-01 00:00"},
+		{"0 12 15 * 7", "2018-12-30 12:00"},
+		{"0 12 30 2 *", "0001-01-01 00:00"},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.prev(now).Format("2006-01-02 15:04"); got != tt.want {
+			t.Errorf("%q: want %s, got %s", tt.expr, tt.want, got)
+		}
+	}
+	for _, tt := range []struct {
+		expr string
+		want string
+	}{
+		{"30 7 * * *", "2019-01-03 07:30"},
+		{"45 7 * * *", "2019-01-03 07:45"},
+		{"46 7 * * *", "2019-01-02 07:46"},
+		{"*/20 * * * *", "2019-01-02 08:00"},
+		{"0 9 * * SAT,sun", "2019-01-05 09:00"},
+		{"0 0 1 * *", "2019-02-01 00:00"},
+		{"0 12 30 2 *", "0001-01-01 00:00"},
+	} {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.next(now).Format("2006-01-02 15:04"); got != tt.want {
+			t.Errorf("%q: want next %s, got %s", tt.expr, tt.want, got)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * * * fri-mon", "*/0 * * * *", "* * * foo *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%q: want error", expr)
+		}
+	}
+	if c, err := At("07:30", "weekdays"); err != nil || c.String() != "30 7 * * mon-fri" {
+		t.Errorf("want weekdays at 07:30, got %v, %v", c, err)
+	}
+}
+
+func TestSchedulerDynamic(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	now := time.Date(2019, 1, 2, 6, 0, 0, 0, time.UTC)
+	c, _ := At("07:30", "weekdays")
+	dynamic := []Schedule{{Name: "office-pc", Device: "7c55b74d-c43b-502f-9f33-68921ee0f0b8", Cron: c, Location: cet}}
+	var woken []string
+	sc := New(nil, func(s Schedule) error {
+		woken = append(woken, fmt.Sprintf("%s@%s", s.Name, now.Format("Mon 15:04")))
+		return nil
+	})
+	sc.Dynamic = func() ([]Schedule, error) { return dynamic, nil }
+	sc.Clock = ClockFunc(func() time.Time { return now })
+	for i := 0; i < 4*24*60; i++ {
+		if now.Format("Mon 15:04") == "Fri 00:01" {
+			// Removing a schedule forgets it, and adding it back skips the occurrence already past
+			dynamic = nil
+			sc.Tick()
+			dynamic = []Schedule{{Name: "office-pc", Hour: 0, Minute: 0}}
+		}
+		sc.Tick()
+		now = now.Add(time.Minute)
+	}
+	want := []string{"office-pc@Wed 06:30", "office-pc@Thu 06:30", "office-pc@Sat 00:00", "office-pc@Sun 00:00"}
+	if fmt.Sprint(woken) != fmt.Sprint(want) {
+		t.Errorf("want %v, got %v", want, woken)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "schedule")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"schedules":[{"name":"rig","group":"rigs","at":"22:00","window":"6h","optimize":"carbon","skipIfOnline":false},` +
+		`{"name":"office","device":"office-pc","at":"07:30","days":"weekdays","timeZone":"UTC"}],` +
+		`"sources":{"carbon":{"type":"table","table":{"2":100,"3":50}}}}`)
+	f.Close()
+	schedules, sources, err := ReadConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 2 || schedules[0].Hour != 22 || schedules[0].Window != 6*time.Hour || sources[OptimizeCarbon].(Table)[3] != 50 ||
+		schedules[0].SkipIfOnline == nil || *schedules[0].SkipIfOnline {
+		t.Errorf("unexpected config %+v %+v", schedules, sources)
+	}
+	if s := schedules[1]; s.Cron == nil || s.Cron.String() != "30 7 * * mon-fri" || s.Location != time.UTC {
+		t.Errorf("unexpected schedule %+v", s)
+	}
+}
@@ -0,0 +1,154 @@
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Slot is a period having a carbon intensity or electricity price.
+type Slot struct {
+	Start time.Time
+	End   time.Time
+	Value float64
+}
+
+// Source forecasts carbon intensity or electricity price.
+type Source interface {
+	Forecast(from, to time.Time) ([]Slot, error)
+}
+
+// Table is a static source mapping each hour of the day to a value. Hours missing from the table have the value 0.
+type Table map[int]float64
+
+// Forecast returns hourly slots covering from and to.
+func (t Table) Forecast(from, to time.Time) ([]Slot, error) {
+	var slots []Slot
+	for start := from.Truncate(time.Hour); start.Before(to); start = start.Add(time.Hour) {
+		slots = append(slots, Slot{Start: start, End: start.Add(time.Hour), Value: t[start.Hour()]})
+	}
+	return slots, nil
+}
+
+// HTTP is a source reading a forecast from a JSON API, such as Electricity Maps.
+type HTTP struct {
+	URL   string
+	Token string
+	// Records is the dotted path to the list of forecasted values in the response.
+	Records string
+	// Start and Value are the names of the fields holding the start time and value of each record.
+	Start  string
+	Value  string
+	client *http.Client
+}
+
+func lookup(v interface{}, path string) interface{} {
+	if path == "" {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// Forecast fetches the forecast, and returns the slots overlapping from and to. Each slot ends where the next one starts.
+func (h *HTTP) Forecast(from, to time.Time) ([]Slot, error) {
+	req, err := http.NewRequest(http.MethodGet, h.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	client := h.client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: got status %d", h.URL, res.StatusCode)
+	}
+	var doc interface{}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	recordsPath := orDefault(h.Records, "forecast")
+	items, ok := lookup(doc, recordsPath).([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: no forecast found at %q", h.URL, recordsPath)
+	}
+	var slots []Slot
+	for _, item := range items {
+		s, _ := lookup(item, orDefault(h.Start, "datetime")).(string)
+		start, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid start time: %q", h.URL, s)
+		}
+		var value float64
+		switch v := lookup(item, orDefault(h.Value, "value")).(type) {
+		case float64:
+			value = v
+		case string:
+			if value, err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, fmt.Errorf("%s: invalid value: %q", h.URL, v)
+			}
+		default:
+			return nil, fmt.Errorf("%s: missing value at %s", h.URL, s)
+		}
+		slots = append(slots, Slot{Start: start, Value: value})
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Start.Before(slots[j].Start) })
+	overlapping := slots[:0]
+	for i := range slots {
+		if i+1 < len(slots) {
+			slots[i].End = slots[i+1].Start
+		} else {
+			slots[i].End = slots[i].Start.Add(time.Hour)
+		}
+		if slots[i].End.After(from) && slots[i].Start.Before(to) {
+			overlapping = append(overlapping, slots[i])
+		}
+	}
+	return overlapping, nil
+}
+
+// Best returns the time in the window from earliest to latest that falls in the slot having the lowest value. Ties are
+// broken by the earliest time. If no slot overlaps the window, earliest is returned.
+func Best(slots []Slot, earliest, latest time.Time) time.Time {
+	best := earliest
+	found := false
+	var bestValue float64
+	for _, s := range slots {
+		if !s.End.After(earliest) || s.Start.After(latest) {
+			continue
+		}
+		at := s.Start
+		if at.Before(earliest) {
+			at = earliest
+		}
+		if !found || s.Value < bestValue || (s.Value == bestValue && at.Before(best)) {
+			best, bestValue, found = at, s.Value, true
+		}
+	}
+	return best
+}
@@ -0,0 +1,116 @@
+// Package script runs scripts configured by the operator on events of a device, such as before it is woken or after
+// it comes online.
+package script
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Script kinds.
+const (
+	PreWake     = "pre-wake"
+	PostOnline  = "post-online"
+	PostOffline = "post-offline"
+)
+
+// DefaultTimeout is the time a script may run before it is killed, unless configured.
+const DefaultTimeout = 30 * time.Second
+
+// maxOutput is the number of bytes of output kept from a script.
+const maxOutput = 4096
+
+// path is the PATH of scripts.
+const path = "/usr/local/bin:/usr/bin:/bin"
+
+// Hooks configures the scripts run on events of a device. A script which is empty is not run.
+type Hooks struct {
+	PreWake     string
+	PostOnline  string
+	PostOffline string
+	Timeout     time.Duration
+}
+
+// Device is the device passed to a script.
+type Device struct {
+	ID         string
+	Name       string
+	MACAddress string
+	IPAddress  string
+}
+
+// Result is the outcome of running a script.
+type Result struct {
+	Path string
+	// Output is the combined, possibly truncated, output of the script.
+	Output string
+	Err    error
+}
+
+// Path returns the script of given kind, if any.
+func (h *Hooks) Path(kind string) string {
+	switch kind {
+	case PreWake:
+		return h.PreWake
+	case PostOnline:
+		return h.PostOnline
+	case PostOffline:
+		return h.PostOffline
+	}
+	return ""
+}
+
+// Env returns the environment of a script of given kind run for device. Scripts do not inherit the environment of the
+// server, which may hold secrets.
+func Env(kind string, device Device) []string {
+	return []string{
+		"PATH=" + path,
+		"WAKEUP_EVENT=" + kind,
+		"WAKEUP_DEVICE=" + device.ID,
+		"WAKEUP_NAME=" + device.Name,
+		"WAKEUP_MAC_ADDRESS=" + device.MACAddress,
+		"WAKEUP_IP_ADDRESS=" + device.IPAddress,
+	}
+}
+
+// Run runs the script of given kind for device, killing it if it runs longer than the configured timeout. Run returns
+// false if no script of that kind is configured.
+func (h *Hooks) Run(ctx context.Context, kind string, device Device) (Result, bool) {
+	p := h.Path(kind)
+	if p == "" {
+		return Result{}, false
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// Output is written to a file, rather than a pipe which may be held open by children of a killed script
+	out, err := ioutil.TempFile("", "wakeup-script")
+	if err != nil {
+		return Result{Path: p, Err: err}, true
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	cmd := exec.CommandContext(ctx, p)
+	cmd.Env = Env(kind, device)
+	cmd.Dir = "/"
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	buf := make([]byte, maxOutput+1)
+	n, _ := out.ReadAt(buf, 0)
+	output := string(buf[:n])
+	if n > maxOutput {
+		output = output[:maxOutput] + "\n[output truncated]"
+	}
+	return Result{Path: p, Output: output, Err: err}, true
+}
@@ -0,0 +1,71 @@
+package script
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, dir, name, content string) string {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte("#!/bin/sh\n"+content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("WAKEUP_SECRET", "hunter2")
+	defer os.Unsetenv("WAKEUP_SECRET")
+	device := Device{ID: "id", Name: "nas", MACAddress: "AB:CD:EF:12:34:56", IPAddress: "10.0.0.2"}
+	h := Hooks{
+		PreWake:     writeScript(t, dir, "pre", `echo "$WAKEUP_EVENT $WAKEUP_NAME $WAKEUP_MAC_ADDRESS $WAKEUP_IP_ADDRESS secret=$WAKEUP_SECRET"; pwd`),
+		PostOnline:  writeScript(t, dir, "online", "echo failing >&2; exit 3"),
+		PostOffline: writeScript(t, dir, "offline", "sleep 5"),
+		Timeout:     100 * time.Millisecond,
+	}
+	var tests = []struct {
+		kind   string
+		output string
+		err    string
+	}{
+		{PreWake, "pre-wake nas AB:CD:EF:12:34:56 10.0.0.2 secret=\n/\n", ""},
+		{PostOnline, "failing\n", "exit status 3"},
+		{PostOffline, "", "timed out after 100ms"},
+	}
+	for i, tt := range tests {
+		r, ok := h.Run(context.Background(), tt.kind, device)
+		if !ok {
+			t.Fatalf("#%d: want script run", i)
+		}
+		if r.Output != tt.output {
+			t.Errorf("#%d: want output %q, got %q", i, tt.output, r.Output)
+		}
+		err := ""
+		if r.Err != nil {
+			err = r.Err.Error()
+		}
+		if err != tt.err {
+			t.Errorf("#%d: want error %q, got %q", i, tt.err, err)
+		}
+	}
+	if _, ok := (&Hooks{}).Run(context.Background(), PreWake, device); ok {
+		t.Error("want no script run")
+	}
+
+	// Output is truncated
+	h.PreWake = writeScript(t, dir, "noisy", "head -c 10000 /dev/zero")
+	r, _ := h.Run(context.Background(), PreWake, device)
+	if !strings.HasSuffix(r.Output, "[output truncated]") || len(r.Output) > maxOutput+20 {
+		t.Errorf("want truncated output, got %d bytes", len(r.Output))
+	}
+}
@@ -0,0 +1,206 @@
+// Package sshd provides an SSH server where authorized users wake devices by running commands, e.g.
+// "ssh wakeup@host wake nas".
+package sshd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const usage = "usage: wake <device ID or MAC address>...\n"
+
+// Server is an SSH server running wake commands.
+type Server struct {
+	// Wake wakes the device identified by ref on behalf of user, who is named by the comment of their key.
+	Wake   func(user, ref string) error
+	config *ssh.ServerConfig
+	keys   map[string]string
+}
+
+// New creates a new server identified by hostKey, and accepting users holding one of keys. The comment of each key
+// names its user, who is identified by the fingerprint of the key if it has no comment. The name given when
+// connecting is ignored, as it is chosen by the client.
+func New(hostKey ssh.Signer, keys []AuthorizedKey, wake func(user, ref string) error) *Server {
+	s := &Server{Wake: wake, keys: make(map[string]string)}
+	for _, k := range keys {
+		user := k.Comment
+		if user == "" {
+			user = ssh.FingerprintSHA256(k.Key)
+		}
+		s.keys[string(k.Key.Marshal())] = user
+	}
+	s.config = &ssh.ServerConfig{PublicKeyCallback: s.authorize}
+	s.config.AddHostKey(hostKey)
+	return s
+}
+
+// AuthorizedKey is a public key permitted to run commands.
+type AuthorizedKey struct {
+	Key     ssh.PublicKey
+	Comment string
+}
+
+// ParseAuthorizedKeys parses keys in the authorized_keys format of OpenSSH.
+func ParseAuthorizedKeys(b []byte) ([]AuthorizedKey, error) {
+	var keys []AuthorizedKey
+	for len(bytes.TrimSpace(b)) > 0 {
+		key, comment, _, rest, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, AuthorizedKey{Key: key, Comment: comment})
+		b = rest
+	}
+	return keys, nil
+}
+
+// ReadAuthorizedKeys reads keys from the authorized_keys file name.
+func ReadAuthorizedKeys(name string) ([]AuthorizedKey, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseAuthorizedKeys(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return keys, nil
+}
+
+// ReadHostKey reads the private host key from file name. A new ed25519 key is generated and written to name if the
+// file does not exist.
+func ReadHostKey(name string) (ssh.Signer, error) {
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		b = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := ioutil.WriteFile(name, b, 0600); err != nil {
+			return nil, err
+		}
+		log.Printf("ssh: generated host key %s", name)
+	} else if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(b)
+}
+
+func (s *Server) authorize(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	user, ok := s.keys[string(key.Marshal())]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s for %s", ssh.FingerprintSHA256(key), conn.User())
+	}
+	return &ssh.Permissions{Extensions: map[string]string{"user": user}}, nil
+}
+
+// ListenAndServe listens on the TCP address addr and serves SSH connections.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves SSH connections accepted by l.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(c net.Conn) {
+	conn, channels, requests, err := ssh.NewServerConn(c, s.config)
+	if err != nil {
+		log.Printf("ssh: handshake with %s failed: %s", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(requests)
+	user := conn.Permissions.Extensions["user"]
+	for ch := range channels {
+		if ch.ChannelType() != "session" {
+			ch.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := ch.Accept()
+		if err != nil {
+			log.Printf("ssh: %s", err)
+			continue
+		}
+		go s.session(user, channel, requests)
+	}
+}
+
+func (s *Server) session(user string, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		switch req.Type {
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			status := s.run(user, payload.Command, channel, channel.Stderr())
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+			return
+		case "shell":
+			// Interactive sessions are not supported
+			req.Reply(true, nil)
+			io.WriteString(channel.Stderr(), usage)
+			channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{2}))
+			return
+		case "pty-req", "env":
+			req.Reply(true, nil)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// run runs command on behalf of user, and returns its exit status.
+func (s *Server) run(user, command string, stdout, stderr io.Writer) uint32 {
+	args := strings.Fields(command)
+	if len(args) < 2 || args[0] != "wake" {
+		io.WriteString(stderr, usage)
+		return 2
+	}
+	var status uint32
+	for _, ref := range args[1:] {
+		if err := s.Wake(user, ref); err != nil {
+			log.Printf("ssh: %s failed to wake %s: %s", user, ref, err)
+			fmt.Fprintf(stderr, "failed to wake %s: %s\n", ref, err)
+			status = 1
+			continue
+		}
+		log.Printf("ssh: %s woke %s", user, ref)
+		fmt.Fprintf(stdout, "woke %s\n", ref)
+	}
+	return status
+}
@@ -0,0 +1,127 @@
+package sshd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	alice, bob := newSigner(t), newSigner(t)
+	b := append(ssh.MarshalAuthorizedKey(alice.PublicKey()), '\n')
+	b = append(b, []byte("# comment\n"+string(ssh.MarshalAuthorizedKey(bob.PublicKey())))...)
+	keys, err := ParseAuthorizedKeys(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("want 2 keys, got %d", len(keys))
+	}
+	if _, err := ParseAuthorizedKeys([]byte("foo\n")); err == nil {
+		t.Error("want error for invalid key")
+	}
+}
+
+func TestReadHostKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "host_key")
+	k1, err := ReadHostKey(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := ReadHostKey(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(k1.PublicKey().Marshal()) != string(k2.PublicKey().Marshal()) {
+		t.Error("want generated host key to be reused")
+	}
+}
+
+func TestServe(t *testing.T) {
+	user, stranger := newSigner(t), newSigner(t)
+	var woken []string
+	s := New(newSigner(t), []AuthorizedKey{{Key: user.PublicKey(), Comment: "alice"}}, func(user, ref string) error {
+		if ref == "foo" {
+			return errors.New("device not found")
+		}
+		woken = append(woken, user+":"+ref)
+		return nil
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	defer l.Close()
+	dial := func(signer ssh.Signer) (*ssh.Client, error) {
+		return ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+			User:            "wakeup",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+	}
+	if _, err := dial(stranger); err == nil {
+		t.Fatal("want error for unknown key")
+	}
+	client, err := dial(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var tests = []struct {
+		command string
+		out     string
+		status  int
+	}{
+		{"wake nas", "woke nas\n", 0},
+		{"wake  AB:CD:EF:12:34:56 foo", "woke AB:CD:EF:12:34:56\n", 1},
+		{"wake", "", 2},
+		{"reboot nas", "", 2},
+	}
+	for i, tt := range tests {
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := session.Output(tt.command)
+		status := 0
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			status = exitErr.ExitStatus()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tt.out {
+			t.Errorf("#%d: want output %q, got %q", i, tt.out, out)
+		}
+		if status != tt.status {
+			t.Errorf("#%d: want status %d, got %d", i, tt.status, status)
+		}
+	}
+	if want := []string{"alice:nas", "alice:AB:CD:EF:12:34:56"}; len(woken) != 2 || woken[0] != want[0] || woken[1] != want[1] {
+		t.Errorf("want %v woken, got %v", want, woken)
+	}
+}
@@ -2,15 +2,22 @@ var wol = wol || {};
 
 wol.state = {
   devices: [],
+  conflicts: [],
+  hints: {},
+  templates: [],
   toWake: {
     name: '',
     macAddress: '',
+    template: '',
     setName: function(v) {
       wol.state.toWake.name = v;
     },
     setMacAddress: function(v) {
       wol.state.toWake.macAddress = v;
     },
+    setTemplate: function(v) {
+      wol.state.toWake.template = v;
+    },
   },
   success: {
     timeout: null,
@@ -23,7 +30,8 @@ wol.state = {
     } else {
       // Copy the toWake object here to avoid input values binding
       wol.wakeDevice({name: wol.state.toWake.name,
-                      macAddress: wol.state.toWake.macAddress});
+                      macAddress: wol.state.toWake.macAddress,
+                      template: wol.state.toWake.template || undefined});
     }
   },
   remove: function (device) {
@@ -47,6 +55,7 @@ wol.state = {
     }
     wol.state.toWake.setName('');
     wol.state.toWake.setMacAddress('');
+    wol.state.toWake.setTemplate('');
     wol.state.error = {};
   },
   setSuccess: function (device) {
@@ -61,21 +70,93 @@ wol.state = {
   }
 };
 
+wol.cache = {
+  key: 'wol.devices',
+  load: function () {
+    try {
+      return JSON.parse(window.localStorage.getItem(wol.cache.key)) || {revision: 0, devices: []};
+    } catch (e) {
+      return {revision: 0, devices: []};
+    }
+  },
+  save: function (revision, devices) {
+    try {
+      window.localStorage.setItem(wol.cache.key, JSON.stringify({revision: revision, devices: devices}));
+    } catch (e) {
+      // Storage may be unavailable, e.g. in private browsing
+    }
+  }
+};
+
+// The UI expects bare responses, regardless of whether the server envelopes responses by default
+wol.headers = {Accept: 'application/json; envelope=false'};
+
 wol.getDevices = function() {
-  m.request({method: 'GET', url: '/api/v1/wake'})
+  // Render cached devices immediately and reconcile with changes from the server
+  var cached = wol.cache.load();
+  wol.state.devices = cached.devices;
+  m.request({headers: wol.headers, method: 'GET', url: '/api/v1/sync', data: {since: cached.revision}})
     .then(function (data) {
-      wol.state.devices = data.devices;
+      var devices = data.reset ? [] : wol.state.devices.filter(function (d) {
+        return data.removed.indexOf(d.macAddress) === -1 && !data.devices.some(function (c) {
+          return c.macAddress === d.macAddress;
+        });
+      });
+      wol.state.devices = devices.concat(data.devices).sort(function (a, b) {
+        return a.macAddress < b.macAddress ? -1 : (a.macAddress > b.macAddress ? 1 : 0);
+      });
+      wol.cache.save(data.revision, wol.state.devices);
       return data;
     }, function (data) {
       wol.state.error = data;
     });
+  wol.getConflicts();
+  wol.getHints();
+};
+
+wol.getHints = function() {
+  // Hints are keyed by MAC address, and unavailable if health tracking is disabled
+  m.request({headers: wol.headers, method: 'GET', url: '/api/v1/health'})
+    .then(function (data) {
+      var hints = {};
+      data.devices.forEach(function (d) {
+        if (d.hints) {
+          hints[d.macAddress] = d.hints;
+        }
+      });
+      wol.state.hints = hints;
+      return data;
+    }, function () {
+      wol.state.hints = {};
+    });
+};
+
+wol.getTemplates = function() {
+  m.request({headers: wol.headers, method: 'GET', url: '/api/v1/templates'})
+    .then(function (data) {
+      wol.state.templates = data.templates;
+      return data;
+    }, function () {
+      wol.state.templates = [];
+    });
+};
+
+wol.getConflicts = function() {
+  m.request({headers: wol.headers, method: 'GET', url: '/api/v1/conflicts'})
+    .then(function (data) {
+      wol.state.conflicts = data.conflicts;
+      return data;
+    }, function () {
+      wol.state.conflicts = [];
+    });
 };
 
 wol.wakeDevice = function(device) {
-  m.request({method: 'POST', url: '/api/v1/wake', data: device})
+  m.request({headers: wol.headers, method: 'POST', url: '/api/v1/wake', data: device})
     .then(function (data) {
       wol.state.add(device);
       wol.state.setSuccess(device);
+      wol.getConflicts();
       return data;
     }, function (data) {
       wol.state.error = data;
@@ -83,21 +164,70 @@ wol.wakeDevice = function(device) {
 };
 
 wol.removeDevice = function (device) {
-  m.request({method: 'DELETE', url: '/api/v1/wake', data: device})
+  m.request({headers: wol.headers, method: 'DELETE', url: '/api/v1/wake', data: device})
     .then(function (data) {
       wol.state.devices = wol.state.devices.filter(function (d) {
         return d.macAddress !== device.macAddress;
       });
+      wol.getConflicts();
       return data;
     }, function (data) {
       wol.state.error = data;
     });
 };
 
+// markdown renders a small subset of Markdown as vnodes. Text is never
+// interpreted as HTML, and only http, https and mailto links are rendered.
+wol.markdown = function (text) {
+  var inline = function (s) {
+    var nodes = [];
+    var re = /`([^`]+)`|\*\*([^*]+)\*\*|\*([^*]+)\*|\[([^\]]+)\]\(([^)\s]+)\)/g;
+    var last = 0;
+    var match;
+    while ((match = re.exec(s)) !== null) {
+      nodes.push(s.slice(last, match.index));
+      if (match[1]) {
+        nodes.push(m('code', match[1]));
+      } else if (match[2]) {
+        nodes.push(m('strong', match[2]));
+      } else if (match[3]) {
+        nodes.push(m('em', match[3]));
+      } else if (/^(https?:|mailto:)/i.test(match[5])) {
+        nodes.push(m('a', {href: match[5], rel: 'noopener noreferrer', target: '_blank'}, match[4]));
+      } else {
+        nodes.push(match[0]);
+      }
+      last = re.lastIndex;
+    }
+    nodes.push(s.slice(last));
+    return nodes;
+  };
+  return text.split(/\n\s*\n/).map(function (block) {
+    var lines = block.split('\n');
+    var isList = lines.every(function (l) { return /^\s*[-*] /.test(l); });
+    if (isList) {
+      return m('ul', lines.map(function (l) {
+        return m('li', inline(l.replace(/^\s*[-*] /, '')));
+      }));
+    }
+    var nodes = [];
+    lines.forEach(function (l, i) {
+      if (i > 0) {
+        nodes.push(m('br'));
+      }
+      nodes = nodes.concat(inline(l));
+    });
+    return m('p', nodes);
+  });
+};
+
 wol.alertView = function () {
   var e = wol.state.error;
   var isError = Object.keys(e).length !== 0;
   var text = isError ? e.message + ' (' + e.status + ')' : '';
+  if (e.hint) {
+    text += '. ' + e.hint;
+  }
   var cls = 'alert-danger' + (isError ? '' : ' hidden');
   return m('div.alert', {class: cls}, [
     m('span', {class: 'glyphicon glyphicon-exclamation-sign'}),
@@ -105,6 +235,19 @@ wol.alertView = function () {
   ]);
 };
 
+wol.conflictsView = function () {
+  var conflicts = wol.state.conflicts;
+  var cls = 'alert-warning' + (conflicts.length > 0 ? '' : ' hidden');
+  return m('div.alert', {class: cls}, [
+    m('span', {class: 'glyphicon glyphicon-warning-sign'}),
+    m('strong', ' Possible duplicates: '),
+    m('ul', conflicts.map(function (c) {
+      var kind = c.kind === 'macAddress' ? 'MAC address' : 'IP address';
+      return m('li', [c.devices.join(', '), ' share ', kind, ' ', m('code', c.value)]);
+    }))
+  ]);
+};
+
 wol.successView = function () {
   var device = wol.state.success.device;
   var isSuccess = Object.keys(device).length !== 0;
@@ -140,10 +283,25 @@ wol.devicesView = function () {
               m('span', {class: 'glyphicon glyphicon-off'}))),
     m('td')
   ]);
-  var rows = wol.state.devices.map(function (device) {
-    return m('tr', [
-      m('td', device.name || ''),
-      m('td', m('code', device.macAddress)),
+  var rows = [];
+  if (wol.state.templates.length > 0) {
+    // New devices can be created from a template, which fills in their settings
+    var options = [m('option', {value: ''}, 'No template')].concat(wol.state.templates.map(function (t) {
+      return m('option', {value: t.name}, t.name);
+    }));
+    rows.push(m('tr', m('td', {colspan: 4},
+      m('select', {'form': form.attrs.id,
+                   onchange: m.withAttr('value', wol.state.toWake.setTemplate),
+                   value: wol.state.toWake.template,
+                   class: 'form-control'}, options))));
+  }
+  wol.state.devices.forEach(function (device) {
+    rows.push(m('tr', [
+      m('td', [device.name || device.hostname || '',
+               device.description ? m('div', {class: 'small text-muted'}, device.description) : null]),
+      m('td', [m('code', device.macAddress),
+               device.ipAddress || device.lastKnownIP ?
+                 m('div', {class: 'small text-muted'}, device.lastKnownIP || device.ipAddress) : null]),
       m('td',
         m('button[type=button]', {class: 'btn btn-success btn-remove',
                                   onclick: function () { wol.state.wake(device); } },
@@ -156,7 +314,18 @@ wol.devicesView = function () {
            m('span', {class: 'glyphicon glyphicon-remove'})
          )
        )
-    ]);
+    ]));
+    if (device.notes) {
+      rows.push(m('tr', m('td', {colspan: 4, class: 'small text-muted'}, wol.markdown(device.notes))));
+    }
+    var hints = wol.state.hints[device.macAddress];
+    if (hints) {
+      rows.push(m('tr', m('td', {colspan: 4, class: 'small text-warning'}, [
+        m('span', {class: 'glyphicon glyphicon-warning-sign'}),
+        m('strong', ' Failing to come online: '),
+        m('ul', hints.map(function (h) { return m('li', h.message); }))
+      ])));
+    }
   });
   return [form,
           m('table.table', {class: ''},
@@ -170,7 +339,26 @@ wol.devicesView = function () {
            )];
 };
 
-wol.oncreate = wol.getDevices;
+wol.watch = function() {
+  // Devices added or removed elsewhere are synced, and hints updated as devices come online or go offline. Browsers
+  // reconnect the stream by themselves
+  if (typeof EventSource === 'undefined') {
+    return;
+  }
+  var events = new EventSource('/api/v1/events?type=added&type=removed&type=online&type=offline');
+  ['added', 'removed'].forEach(function (type) {
+    events.addEventListener(type, wol.getDevices);
+  });
+  ['online', 'offline'].forEach(function (type) {
+    events.addEventListener(type, wol.getHints);
+  });
+};
+
+wol.oncreate = function () {
+  wol.getDevices();
+  wol.getTemplates();
+  wol.watch();
+};
 
 wol.view = function() {
   return m('div.container', [
@@ -178,6 +366,7 @@ wol.view = function() {
       m('div.col-md-6', m('h1', m('span', {class: 'glyphicon glyphicon-flash'}), ' wake-on-lan'))
     ),
     m('div.row', m('div.col-md-6', wol.alertView())),
+    m('div.row', m('div.col-md-6', wol.conflictsView())),
     m('div.row', m('div.col-md-6', wol.successView())),
     m('div.row', m('div.col-md-6', wol.devicesView()))
   ]);
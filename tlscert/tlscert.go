@@ -0,0 +1,77 @@
+// Package tlscert provides the certificates of HTTPS servers from files. Certificates obtained from Let's Encrypt are
+// managed by golang.org/x/crypto/acme/autocert instead.
+package tlscert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeyPair serves the certificate and key in files, which are loaded again when they change, e.g. when the certificate
+// is renewed by another ACME client.
+type KeyPair struct {
+	CertFile string
+	KeyFile  string
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+// LoadKeyPair loads the certificate and key in the PEM encoded files certFile and keyFile.
+func LoadKeyPair(certFile, keyFile string) (*KeyPair, error) {
+	k := &KeyPair{CertFile: certFile, KeyFile: keyFile}
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// modified returns the time the last of the files of k was modified.
+func (k *KeyPair) modified() (time.Time, error) {
+	var t time.Time
+	for _, name := range []string{k.CertFile, k.KeyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t, nil
+}
+
+func (k *KeyPair) load() error {
+	modTime, err := k.modified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(k.CertFile, k.KeyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+	k.cert, k.modTime = &cert, modTime
+	return nil
+}
+
+// GetCertificate returns the certificate of k, loading it again if its files changed. The certificate last loaded is
+// returned if the files cannot be loaded, e.g. while they are being written.
+func (k *KeyPair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if modTime, err := k.modified(); err == nil && !modTime.Equal(k.modTime) {
+		if err := k.load(); err != nil {
+			log.Printf("could not reload certificate %s: %s", k.CertFile, err)
+		} else {
+			log.Printf("Reloaded certificate %s", k.CertFile)
+		}
+	}
+	return k.cert, nil
+}
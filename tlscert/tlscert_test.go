@@ -0,0 +1,100 @@
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSigned creates a certificate for name expiring at notAfter.
+func selfSigned(t *testing.T, name string, notAfter time.Time) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func writeKeyPair(t *testing.T, cert *tls.Certificate, certFile, keyFile string) {
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyPair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlscert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if _, err := LoadKeyPair(certFile, keyFile); err == nil {
+		t.Fatal("want error for missing files")
+	}
+	first := selfSigned(t, "wakeup.example.com", time.Now().Add(time.Hour))
+	writeKeyPair(t, first, certFile, keyFile)
+	kp, err := LoadKeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := kp.GetCertificate(nil)
+	if err != nil || !cert.Leaf.Equal(first.Leaf) {
+		t.Fatalf("want first certificate, got %v (%v)", cert, err)
+	}
+
+	// Changed files are loaded again
+	second := selfSigned(t, "wakeup.example.com", time.Now().Add(2*time.Hour))
+	writeKeyPair(t, second, certFile, keyFile)
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cert, err := kp.GetCertificate(nil); err != nil || !cert.Leaf.Equal(second.Leaf) {
+		t.Errorf("want second certificate, got %v (%v)", cert, err)
+	}
+
+	// The certificate last loaded is kept if files are invalid
+	if err := ioutil.WriteFile(certFile, []byte("foo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(certFile, later.Add(time.Minute), later.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if cert, err := kp.GetCertificate(nil); err != nil || !cert.Leaf.Equal(second.Leaf) {
+		t.Errorf("want second certificate, got %v (%v)", cert, err)
+	}
+}
@@ -0,0 +1,65 @@
+// Package totp implements time-based one-time passwords as specified by RFC 6238, compatible with common
+// authenticator apps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// Period is the time each code is valid for.
+	Period = 30 * time.Second
+	// Digits is the number of digits of each code.
+	Digits = 6
+)
+
+// Encode returns secret in the base32 encoding expected by authenticator apps.
+func Encode(secret []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// URI returns the otpauth URI of secret, which authenticator apps read from a QR code.
+func URI(secret []byte, issuer, account string) string {
+	v := url.Values{}
+	v.Set("secret", Encode(secret))
+	v.Set("issuer", issuer)
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(issuer), url.PathEscape(account), v.Encode())
+}
+
+// Step returns the time step of t.
+func Step(t time.Time) int64 { return t.Unix() / int64(Period/time.Second) }
+
+func code(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, n%1000000)
+}
+
+// Code returns the code of secret at time t.
+func Code(secret []byte, t time.Time) string { return code(secret, Step(t)) }
+
+// Verify reports whether c is a valid code of secret at time t, and returns the time step it is valid for. Codes of
+// the previous and next time step are accepted to allow for clock skew.
+func Verify(secret []byte, c string, t time.Time) (int64, bool) {
+	if len(c) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	for step := now - 1; step <= now+1; step++ {
+		if hmac.Equal([]byte(code(secret, step)), []byte(c)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,52 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	// Test vectors from RFC 6238, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	var tests = []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for i, tt := range tests {
+		if got := Code(secret, time.Unix(tt.unix, 0)); got != tt.code {
+			t.Errorf("#%d: want %s, got %s", i, tt.code, got)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	var tests = []struct {
+		code string
+		step int64
+		ok   bool
+	}{
+		{Code(secret, now), Step(now), true},
+		{Code(secret, now.Add(-Period)), Step(now) - 1, true},
+		{Code(secret, now.Add(Period)), Step(now) + 1, true},
+		{Code(secret, now.Add(-2*Period)), 0, false},
+		{"12345", 0, false},
+		{"", 0, false},
+	}
+	for i, tt := range tests {
+		step, ok := Verify(secret, tt.code, now)
+		if ok != tt.ok || step != tt.step {
+			t.Errorf("#%d: Verify(%q) = (%d, %t), want (%d, %t)", i, tt.code, step, ok, tt.step, tt.ok)
+		}
+	}
+	if got, want := URI([]byte("12345678901234567890"), "wakeup", "NAS"), "otpauth://totp/wakeup:NAS?issuer=wakeup&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"; got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,107 @@
+// Package trace records the results of probing devices and the events published by the server, so that they can be
+// replayed later, e.g. to reproduce the behaviour of alerts and clients in integration tests.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+// Probe is the result of probing a device.
+type Probe struct {
+	MACAddress string `json:"macAddress"`
+	IPAddress  string `json:"ipAddress,omitempty"`
+	Online     bool   `json:"online"`
+}
+
+// Record is a single observation in a trace, holding either a probe or an event.
+type Record struct {
+	Time  time.Time    `json:"time"`
+	Probe *Probe       `json:"probe,omitempty"`
+	Event *event.Event `json:"event,omitempty"`
+}
+
+// Writer writes records to a trace, with one JSON-encoded record per line.
+type Writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	now func() time.Time
+}
+
+// NewWriter creates a writer writing records to w.
+func NewWriter(w io.Writer) *Writer { return &Writer{w: w, now: time.Now} }
+
+// Write writes r to the trace. Records without a time are given the current time.
+func (w *Writer) Write(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = w.now().UTC()
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.w.Write(append(data, '\n'))
+	return err
+}
+
+// Read reads the records of the trace in r, ordered by time. Records having the same time keep their order. A record
+// interrupted by a crash is skipped, like in the history.
+func Read(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if rec.Probe == nil && rec.Event == nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+	return records, nil
+}
+
+// Player feeds the records of a trace to a handler, in order.
+type Player struct {
+	// Speed is how much faster than recorded the trace is played, e.g. 2 plays it twice as fast. Records are played
+	// without delay if Speed is zero.
+	Speed float64
+	sleep func(time.Duration)
+}
+
+// Play calls handle with each record in records, waiting between records as given by Speed. Playing stops at the
+// first error returned by handle.
+func (p *Player) Play(records []Record, handle func(Record) error) error {
+	sleep := p.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	for i, r := range records {
+		if i > 0 && p.Speed > 0 {
+			if d := r.Time.Sub(records[i-1].Time); d > 0 {
+				sleep(time.Duration(float64(d) / p.Speed))
+			}
+		}
+		if err := handle(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
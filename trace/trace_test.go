@@ -0,0 +1,73 @@
+package trace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mpolden/wakeup/event"
+)
+
+func TestReadWrite(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+	records := []Record{
+		{Probe: &Probe{MACAddress: "AB:CD:EF:12:34:56", IPAddress: "10.0.0.2", Online: true}},
+		{Time: now.Add(-time.Minute), Event: &event.Event{Type: event.Wake, MACAddress: "AB:CD:EF:12:34:56"}},
+		{Time: now.Add(time.Minute), Probe: &Probe{MACAddress: "AB:CD:EF:12:34:56", IPAddress: "10.0.0.2"}},
+	}
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An interrupted record is skipped
+	buf.WriteString(`{"time":"2020-01-01T12:02:00Z","probe":{"mac`)
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary []string
+	for _, r := range got {
+		s := r.Time.Format("15:04")
+		if r.Probe != nil {
+			s += " probe " + r.Probe.MACAddress
+			if r.Probe.Online {
+				s += " online"
+			}
+		} else {
+			s += " " + r.Event.Type
+		}
+		summary = append(summary, s)
+	}
+	want := "11:59 wake|12:00 probe AB:CD:EF:12:34:56 online|12:01 probe AB:CD:EF:12:34:56"
+	if s := strings.Join(summary, "|"); s != want {
+		t.Errorf("want %q, got %q", want, s)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: now, Probe: &Probe{}},
+		{Time: now.Add(10 * time.Second), Probe: &Probe{}},
+		{Time: now.Add(30 * time.Second), Probe: &Probe{}},
+	}
+	var slept []time.Duration
+	p := Player{Speed: 10, sleep: func(d time.Duration) { slept = append(slept, d) }}
+	n := 0
+	if err := p.Play(records, func(Record) error { n++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || len(slept) != 2 || slept[0] != time.Second || slept[1] != 2*time.Second {
+		t.Errorf("want 3 records played with delays of 1s and 2s, got %d records and delays %v", n, slept)
+	}
+	slept = nil
+	p.Speed = 0
+	if err := p.Play(records, func(Record) error { return nil }); err != nil || len(slept) != 0 {
+		t.Errorf("want no delays, got %v (%v)", slept, err)
+	}
+}
@@ -0,0 +1,172 @@
+// Package ups reads the status of an uninterruptible power supply from NUT or apcupsd.
+package ups
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is the status of an UPS.
+type Status struct {
+	OnBattery bool   `json:"onBattery"`
+	Raw       string `json:"raw"`
+}
+
+// Reader reads the status of an UPS.
+type Reader interface {
+	Status() (Status, error)
+}
+
+const timeout = 5 * time.Second
+
+// NUT reads status from a Network UPS Tools server.
+type NUT struct {
+	Address string
+	Name    string
+}
+
+// Status returns the status of the UPS.
+func (n *NUT) Status() (Status, error) {
+	conn, err := net.DialTimeout("tcp", n.Address, timeout)
+	if err != nil {
+		return Status{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := fmt.Fprintf(conn, "GET VAR %s ups.status\n", n.Name); err != nil {
+		return Status{}, err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return Status{}, err
+	}
+	line = strings.TrimSpace(line)
+	prefix := "VAR " + n.Name + " ups.status "
+	if !strings.HasPrefix(line, prefix) {
+		return Status{}, fmt.Errorf("%s: unexpected response: %q", n.Address, line)
+	}
+	raw := strings.Trim(strings.TrimPrefix(line, prefix), `"`)
+	// Status is a space-separated list of flags, where OB means on battery and OL means on line power
+	onBattery := false
+	for _, flag := range strings.Fields(raw) {
+		if flag == "OB" {
+			onBattery = true
+		}
+	}
+	return Status{OnBattery: onBattery, Raw: raw}, nil
+}
+
+// Apcupsd reads status from the network information server of apcupsd.
+type Apcupsd struct {
+	Address string
+}
+
+func writeRecord(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.BigEndian, uint16(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// Status returns the status of the UPS.
+func (a *Apcupsd) Status() (Status, error) {
+	conn, err := net.DialTimeout("tcp", a.Address, timeout)
+	if err != nil {
+		return Status{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := writeRecord(conn, "status"); err != nil {
+		return Status{}, err
+	}
+	r := bufio.NewReader(conn)
+	for {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return Status{}, err
+		}
+		if n == 0 {
+			break
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return Status{}, err
+		}
+		parts := strings.SplitN(string(buf), ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "STATUS" {
+			raw := strings.TrimSpace(parts[1])
+			return Status{OnBattery: strings.Contains(raw, "ONBATT"), Raw: raw}, nil
+		}
+	}
+	return Status{}, fmt.Errorf("%s: no status in response", a.Address)
+}
+
+// Monitor polls an UPS for changes in its status.
+type Monitor struct {
+	Reader   Reader
+	Interval time.Duration
+	mu       sync.RWMutex
+	status   Status
+	err      error
+}
+
+// NewMonitor creates a new monitor polling reader.
+func NewMonitor(reader Reader) *Monitor {
+	return &Monitor{Reader: reader, Interval: 10 * time.Second}
+}
+
+// OnBattery returns whether the UPS was on battery when it was last polled. The UPS is assumed to be on line power if its
+// status cannot be read, so that a failing UPS does not prevent wakes.
+func (m *Monitor) OnBattery() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status.OnBattery
+}
+
+// Status returns the status of the UPS when it was last polled, and the error from polling it, if any.
+func (m *Monitor) Status() (Status, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status, m.err
+}
+
+// Poll reads the status of the UPS, and returns whether it changed between line power and battery.
+func (m *Monitor) Poll() bool {
+	status, err := m.Reader.Status()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+	if err != nil {
+		status = Status{}
+	}
+	changed := status.OnBattery != m.status.OnBattery
+	m.status = status
+	return changed
+}
+
+// Run polls the UPS every interval, and calls onChange when the UPS switches between line power and battery.
+func (m *Monitor) Run(onChange func(onBattery bool)) {
+	for {
+		if m.Poll() {
+			onBattery := m.OnBattery()
+			if onBattery {
+				log.Printf("ups: on battery")
+			} else {
+				log.Printf("ups: on line power")
+			}
+			onChange(onBattery)
+		}
+		if _, err := m.Status(); err != nil {
+			log.Printf("ups: %s", err)
+		}
+		time.Sleep(m.Interval)
+	}
+}
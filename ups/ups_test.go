@@ -0,0 +1,108 @@
+package ups
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func serve(t *testing.T, handle func(net.Conn)) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			handle(conn)
+			conn.Close()
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func TestNUT(t *testing.T) {
+	status := "OL CHRG"
+	addr, stop := serve(t, func(conn net.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		if line != "GET VAR ups ups.status\n" {
+			fmt.Fprintf(conn, "ERR UNKNOWN-COMMAND\n")
+			return
+		}
+		fmt.Fprintf(conn, "VAR ups ups.status \"%s\"\n", status)
+	})
+	defer stop()
+	n := &NUT{Address: addr, Name: "ups"}
+	s, err := n.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Status{Raw: "OL CHRG"}); s != want {
+		t.Errorf("want %+v, got %+v", want, s)
+	}
+	status = "OB DISCHRG"
+	if s, err = n.Status(); err != nil || !s.OnBattery {
+		t.Errorf("want on battery, got %+v (%v)", s, err)
+	}
+	n.Name = "foo"
+	if _, err := n.Status(); err == nil {
+		t.Error("want error for unknown UPS")
+	}
+}
+
+func TestApcupsd(t *testing.T) {
+	addr, stop := serve(t, func(conn net.Conn) {
+		var n uint16
+		binary.Read(conn, binary.BigEndian, &n)
+		io.CopyN(ioutil.Discard, conn, int64(n))
+		for _, line := range []string{"APC      : 001,036,0877\n", "STATUS   : ONBATT \n", "LOADPCT  :  12.0 Percent\n"} {
+			writeRecord(conn, line)
+		}
+		writeRecord(conn, "")
+	})
+	defer stop()
+	s, err := (&Apcupsd{Address: addr}).Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Status{OnBattery: true, Raw: "ONBATT"}); s != want {
+		t.Errorf("want %+v, got %+v", want, s)
+	}
+}
+
+type testReader struct {
+	status Status
+	err    error
+}
+
+func (r *testReader) Status() (Status, error) { return r.status, r.err }
+
+func TestMonitor(t *testing.T) {
+	r := &testReader{}
+	m := NewMonitor(r)
+	if m.Poll() || m.OnBattery() {
+		t.Fatal("want no change")
+	}
+	r.status.OnBattery = true
+	if !m.Poll() || !m.OnBattery() {
+		t.Fatal("want change to battery")
+	}
+	if m.Poll() {
+		t.Fatal("want no change")
+	}
+	r.err = errors.New("connection refused")
+	if !m.Poll() || m.OnBattery() {
+		t.Fatal("want line power to be assumed on error")
+	}
+	if _, err := m.Status(); err == nil {
+		t.Fatal("want error")
+	}
+}
@@ -0,0 +1,105 @@
+package wait
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+)
+
+// ICMP message types.
+const (
+	icmpEchoRequest   = 8
+	icmpEchoReply     = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// errNoReply is returned when a ping does not receive a reply before its deadline.
+var errNoReply = errors.New("no echo reply")
+
+// ICMPProbe returns a probe pinging host. Pinging requires permission to open raw sockets, e.g. CAP_NET_RAW.
+func ICMPProbe(host string) Probe { return Probe{Network: "icmp", Address: host} }
+
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// echoRequest returns an ICMP echo request having id and seq. The checksum of ICMPv6 messages is computed by the
+// kernel.
+func echoRequest(v6 bool, id, seq uint16) []byte {
+	typ := byte(icmpEchoRequest)
+	if v6 {
+		typ = icmpv6EchoRequest
+	}
+	msg := []byte{typ, 0, 0, 0, byte(id >> 8), byte(id), byte(seq >> 8), byte(seq), 'w', 'a', 'k', 'e', 'u', 'p'}
+	if !v6 {
+		c := checksum(msg)
+		msg[2], msg[3] = byte(c>>8), byte(c)
+	}
+	return msg
+}
+
+// isEchoReply reports whether msg is the reply to the echo request having id and seq.
+func isEchoReply(msg []byte, v6 bool, id, seq uint16) bool {
+	typ := byte(icmpEchoReply)
+	if v6 {
+		typ = icmpv6EchoReply
+	}
+	return len(msg) >= 8 && msg[0] == typ && uint16(msg[4])<<8|uint16(msg[5]) == id && uint16(msg[6])<<8|uint16(msg[7]) == seq
+}
+
+// ping sends an ICMP echo request to host and waits for its reply until ctx is done.
+func ping(ctx context.Context, host string) error {
+	addr, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return err
+	}
+	v6 := addr.IP.To4() == nil
+	network := "ip4:icmp"
+	if v6 {
+		network = "ip6:ipv6-icmp"
+	}
+	conn, err := net.ListenPacket(network, "")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.SetDeadline(time.Now())
+	}()
+	id, seq := uint16(os.Getpid()), uint16(rand.Intn(1<<16))
+	if _, err := conn.WriteTo(echoRequest(v6, id, seq), addr); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return errNoReply
+			}
+			return err
+		}
+		// Raw sockets receive all ICMP messages of the host, so replies to other pings are skipped
+		if p, ok := peer.(*net.IPAddr); ok && p.IP.Equal(addr.IP) && isEchoReply(buf[:n], v6, id, seq) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package wait
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultPorts are the TCP ports probed when waiting for a host to come online.
+var DefaultPorts = []int{22, 80, 443, 445, 3389}
+
+const (
+	// StatusOnline indicates that the host responded to a probe.
+	StatusOnline = "online"
+	// StatusTimeout indicates that the deadline passed before the host responded.
+	StatusTimeout = "timeout"
+	// StatusCanceled indicates that waiting was canceled before the host responded.
+	StatusCanceled = "canceled"
+)
+
+// Probe is a network address that is dialed to determine whether a host is online.
+type Probe struct {
+	Network string
+	Address string
+}
+
+func (p Probe) String() string { return p.Network + ":" + p.Address }
+
+// TCPProbes returns TCP probes for each port on host.
+func TCPProbes(host string, ports []int) []Probe {
+	probes := make([]Probe, 0, len(ports))
+	for _, port := range ports {
+		probes = append(probes, Probe{Network: "tcp", Address: net.JoinHostPort(host, strconv.Itoa(port))})
+	}
+	return probes
+}
+
+// Result is the outcome of waiting for a host.
+type Result struct {
+	Status   string
+	Waited   time.Duration
+	Attempts int
+	Probes   []Probe
+	// Resends is the number of times the wake was resent while waiting, and ResendErr the last error resending it.
+	Resends   int
+	ResendErr error
+}
+
+// Waiter waits for hosts to come online.
+type Waiter struct {
+	Interval time.Duration
+	dial     func(ctx context.Context, network, address string) (net.Conn, error)
+	ping     func(ctx context.Context, host string) error
+}
+
+// New creates a new waiter which probes hosts every interval.
+func New(interval time.Duration) *Waiter {
+	var d net.Dialer
+	return &Waiter{Interval: interval, dial: d.DialContext, ping: ping}
+}
+
+// Wait runs probes until one of them succeeds or ctx is done. All probes are attempted concurrently in each round.
+func (w *Waiter) Wait(ctx context.Context, probes []Probe) Result {
+	start := time.Now()
+	r := Result{Probes: probes}
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		r.Attempts++
+		if w.probe(ctx, probes) {
+			r.Status = StatusOnline
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+			continue
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			r.Status = StatusTimeout
+		} else {
+			r.Status = StatusCanceled
+		}
+		break
+	}
+	r.Waited = time.Since(start)
+	return r
+}
+
+func (w *Waiter) probe(ctx context.Context, probes []Probe) bool {
+	ctx, cancel := context.WithTimeout(ctx, w.Interval)
+	defer cancel()
+	var wg sync.WaitGroup
+	online := make(chan bool, len(probes))
+	for _, p := range probes {
+		wg.Add(1)
+		go func(p Probe) {
+			defer wg.Done()
+			if p.Network == "icmp" {
+				online <- w.ping(ctx, p.Address) == nil
+				return
+			}
+			conn, err := w.dial(ctx, p.Network, p.Address)
+			if err == nil {
+				conn.Close()
+			}
+			// A refused connection means that the host is up, but nothing listens on the port
+			online <- err == nil || errors.Is(err, syscall.ECONNREFUSED)
+		}(p)
+	}
+	wg.Wait()
+	close(online)
+	for ok := range online {
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry waits for probes using wait, calling resend whenever the host has not come online within every, e.g. to resend
+// a wake packet that may have been lost. Errors from resend are returned in the result, but do not stop waiting.
+func Retry(ctx context.Context, wait func(context.Context, []Probe) Result, probes []Probe, every time.Duration, resend func() error) Result {
+	start := time.Now()
+	var r Result
+	for {
+		rctx, cancel := context.WithTimeout(ctx, every)
+		res := wait(rctx, probes)
+		cancel()
+		r.Attempts += res.Attempts
+		r.Probes = res.Probes
+		r.Status = res.Status
+		if res.Status == StatusOnline || ctx.Err() != nil {
+			break
+		}
+		r.Resends++
+		if err := resend(); err != nil {
+			r.ResendErr = err
+		}
+	}
+	r.Waited = time.Since(start)
+	return r
+}
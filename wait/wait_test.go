@@ -0,0 +1,138 @@
+package wait
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWaitOnline(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	w := New(10 * time.Millisecond)
+	probes := []Probe{{Network: "tcp", Address: l.Addr().String()}}
+	r := w.Wait(context.Background(), probes)
+	if r.Status != StatusOnline {
+		t.Errorf("want status %s, got %s", StatusOnline, r.Status)
+	}
+	if r.Attempts != 1 {
+		t.Errorf("want 1 attempt, got %d", r.Attempts)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	w := New(10 * time.Millisecond)
+	w.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, errors.New("i/o timeout")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := w.Wait(ctx, TCPProbes("192.0.2.1", []int{22}))
+	if r.Status != StatusTimeout {
+		t.Errorf("want status %s, got %s", StatusTimeout, r.Status)
+	}
+	if r.Attempts < 2 {
+		t.Errorf("want multiple attempts, got %d", r.Attempts)
+	}
+	if want := "tcp:192.0.2.1:22"; r.Probes[0].String() != want {
+		t.Errorf("want probe %s, got %s", want, r.Probes[0])
+	}
+}
+
+func TestWaitCanceled(t *testing.T) {
+	w := New(10 * time.Millisecond)
+	w.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("no route to host")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if r := w.Wait(ctx, TCPProbes("192.0.2.1", []int{22})); r.Status != StatusCanceled {
+		t.Errorf("want status %s, got %s", StatusCanceled, r.Status)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	req := echoRequest(false, 0x1234, 0xabcd)
+	if checksum(req) != 0 {
+		t.Errorf("invalid checksum of %x", req)
+	}
+	reply := append([]byte{icmpEchoReply, 0}, req[2:]...)
+	if !isEchoReply(reply, false, 0x1234, 0xabcd) {
+		t.Errorf("want %x to be reply", reply)
+	}
+	if isEchoReply(reply, false, 0x1234, 0xabce) || isEchoReply(reply, true, 0x1234, 0xabcd) || isEchoReply(req, false, 0x1234, 0xabcd) {
+		t.Errorf("want %x to not be reply", reply)
+	}
+}
+
+func TestWaitPing(t *testing.T) {
+	w := New(10 * time.Millisecond)
+	var pinged string
+	w.ping = func(ctx context.Context, host string) error {
+		pinged = host
+		return nil
+	}
+	w.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("i/o timeout")
+	}
+	probes := append([]Probe{ICMPProbe("192.0.2.1")}, TCPProbes("192.0.2.1", []int{22})...)
+	if r := w.Wait(context.Background(), probes); r.Status != StatusOnline {
+		t.Errorf("want status %s, got %s", StatusOnline, r.Status)
+	}
+	if pinged != "192.0.2.1" {
+		t.Errorf("want 192.0.2.1 pinged, got %q", pinged)
+	}
+	if want := "icmp:192.0.2.1"; probes[0].String() != want {
+		t.Errorf("want probe %s, got %s", want, probes[0])
+	}
+
+	// Ping the loopback address, if permitted to open raw sockets
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ping(ctx, "127.0.0.1"); err != nil && !errors.Is(err, syscall.EPERM) {
+		t.Errorf("ping of 127.0.0.1 failed: %s", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	w := New(10 * time.Millisecond)
+	resends := 0
+	w.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if resends < 2 {
+			<-ctx.Done()
+			return nil, errors.New("i/o timeout")
+		}
+		return nil, syscall.ECONNREFUSED
+	}
+	probes := TCPProbes("192.0.2.1", []int{22})
+	resend := func() error { resends++; return nil }
+	r := Retry(context.Background(), w.Wait, probes, 30*time.Millisecond, resend)
+	if r.Status != StatusOnline {
+		t.Errorf("want status %s, got %s", StatusOnline, r.Status)
+	}
+	if r.Resends != 2 {
+		t.Errorf("want 2 resends, got %d", r.Resends)
+	}
+	if r.Attempts < 3 {
+		t.Errorf("want at least 3 attempts, got %d", r.Attempts)
+	}
+
+	// Resending stops when waiting times out
+	resends = -100
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r = Retry(ctx, w.Wait, probes, 30*time.Millisecond, func() error { resends++; return errors.New("network is down") })
+	if r.Status != StatusTimeout {
+		t.Errorf("want status %s, got %s", StatusTimeout, r.Status)
+	}
+	if r.Resends < 2 || r.ResendErr == nil {
+		t.Errorf("want multiple failed resends, got %d (%v)", r.Resends, r.ResendErr)
+	}
+}
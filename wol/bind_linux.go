@@ -0,0 +1,22 @@
+package wol
+
+import "syscall"
+
+// bindControl returns a function binding sockets to the network interface named iface, if not empty.
+func bindControl(iface string) func(string, string, syscall.RawConn) error {
+	if iface == "" {
+		return nil
+	}
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		}); cerr != nil {
+			return cerr
+		}
+		if err != nil {
+			return &bindError{iface: iface, err: err}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package wol
+
+import "syscall"
+
+// bindControl returns nil, as sockets can only be bound to a network interface on Linux.
+func bindControl(iface string) func(string, string, syscall.RawConn) error { return nil }
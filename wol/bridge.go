@@ -2,42 +2,148 @@ package wol
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"time"
+
+	"github.com/mpolden/wakeup/budget"
+)
+
+var (
+	// ErrInvalidPacket is returned when a packet that is not a magic packet is received.
+	ErrInvalidPacket = errors.New("invalid magic packet")
+	// ErrFiltered is returned when a magic packet is not forwarded because of the filter of a bridge.
+	ErrFiltered = errors.New("magic packet filtered")
+	// ErrDuplicate is returned when a magic packet is not forwarded because one for the same MAC address was
+	// forwarded within the suppression window of a bridge.
+	ErrDuplicate = errors.New("duplicate magic packet")
+	// ErrHopLimit is returned when a magic packet is not forwarded because it has already passed the maximum number of
+	// relays.
+	ErrHopLimit = errors.New("magic packet exceeded hop limit")
 )
 
+// DefaultMaxHops is the default maximum number of relays a magic packet passes.
+const DefaultMaxHops = 4
+
+// relayTag starts the metadata appended to relayed magic packets, which is followed by a single byte holding the
+// number of relays the packet has passed. Network interfaces ignore data following the magic packet.
+var relayTag = []byte("WOLR")
+
+// withHops returns p followed by relay metadata holding hops.
+func withHops(p MagicPacket, hops int) MagicPacket {
+	if hops > 255 {
+		hops = 255
+	}
+	return append(append(p[:len(p):len(p)], relayTag...), byte(hops))
+}
+
+// splitHops splits b into a packet and the number of relays it has passed, according to its relay metadata.
+func splitHops(b []byte) ([]byte, int) {
+	n := len(b) - len(relayTag) - 1
+	if n < 0 || !bytes.Equal(b[n:n+len(relayTag)], relayTag) {
+		return b, 0
+	}
+	return b[:n], int(b[len(b)-1])
+}
+
+// BridgeStats holds the number of magic packets forwarded, and dropped for each reason, by a bridge.
+type BridgeStats struct {
+	Forwarded   uint64
+	Invalid     uint64
+	Filtered    uint64
+	Duplicates  uint64
+	HopLimited  uint64
+	RateLimited uint64
+}
+
 // Bridge represents a Wake-on-LAN bridge.
 type Bridge struct {
+	// Budget limits the rate of forwarded packets. Forwarded packets are considered automated.
+	Budget *budget.Budget
+	// Filter reports whether magic packets for hwAddr are forwarded. All magic packets are forwarded if nil.
+	Filter func(hwAddr net.HardwareAddr) bool
+	// Window is the time after forwarding a magic packet during which further magic packets for the same MAC address
+	// are dropped as duplicates, e.g. the rest of a burst, or copies arriving through other relays.
+	Window time.Duration
+	// MaxHops is the maximum number of relays a magic packet passes, including this one. Relays count the hops of the
+	// packets they forward in metadata appended to the packets, which prevents loops between relays.
+	MaxHops  int
 	conn     io.ReadCloser
 	lastSent MagicPacket
-	wakeFunc func(net.IP, net.HardwareAddr) error
+	sent     map[string]time.Time
+	stats    BridgeStats
+	now      func() time.Time
+	wakeFunc func(net.HardwareAddr, Options) error
 	mu       sync.Mutex
 }
 
+func newBridge(conn io.ReadCloser) *Bridge {
+	return &Bridge{conn: conn, MaxHops: DefaultMaxHops, sent: make(map[string]time.Time), now: time.Now, wakeFunc: WakeWith}
+}
+
 // Listen listens for magic packets on the given addr.
-func Listen(addr string) (*Bridge, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
-	if err != nil {
-		return nil, err
+func Listen(addr string) (*Bridge, error) { return ListenAll("udp4", addr) }
+
+// ListenAll listens for magic packets on all addrs using network, which must be one of "udp", "udp4" or "udp6".
+func ListenAll(network string, addrs ...string) (*Bridge, error) {
+	return ListenInterface(network, "", addrs...)
+}
+
+// ListenInterface is like ListenAll, but only receives magic packets arriving on the network interface named iface, if
+// not empty. This is only supported on Linux.
+func ListenInterface(network, iface string, addrs ...string) (*Bridge, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, fmt.Errorf("invalid network: %s", network)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no listen address given")
+	}
+	conns := make([]io.ReadCloser, 0, len(addrs))
+	for _, addr := range addrs {
+		conn, err := listenUDP(network, addr, iface)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
 	}
-	conn, err := net.ListenUDP("udp4", udpAddr)
+	if len(conns) == 1 {
+		return newBridge(conns[0]), nil
+	}
+	return newBridge(newMultiConn(conns)), nil
+}
+
+func listenUDP(network, addr, iface string) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: bindControl(iface)}
+	conn, err := lc.ListenPacket(context.Background(), network, addr)
 	if err != nil {
 		return nil, err
 	}
-	return &Bridge{conn: conn, wakeFunc: Wake}, nil
+	return conn.(*net.UDPConn), nil
 }
 
 // Close closes the connection.
 func Close(b *Bridge) error { return b.conn.Close() }
 
-// Forward reads a magic packet and writes it back to the network using src as the local address.
+// Forward reads a magic packet and writes it back to the network using src as the local address. A magic packet
+// dropped by the filter, as a duplicate or for exceeding the hop limit is returned together with ErrFiltered,
+// ErrDuplicate or ErrHopLimit, respectively.
 func (b *Bridge) Forward(src net.IP) (MagicPacket, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	mp, err := b.read()
+	mp, hops, err := b.read()
 	if err != nil {
+		if errors.Is(err, ErrInvalidPacket) {
+			b.stats.Invalid++
+		}
 		return nil, err
 	}
 	// Do not resend if we just sent this packet
@@ -45,22 +151,57 @@ func (b *Bridge) Forward(src net.IP) (MagicPacket, error) {
 		b.lastSent = nil
 		return nil, nil
 	}
-	if err := b.wakeFunc(src, mp.HardwareAddr()); err != nil {
+	if b.Filter != nil && !b.Filter(mp.HardwareAddr()) {
+		b.stats.Filtered++
+		return mp, ErrFiltered
+	}
+	if b.MaxHops > 0 && hops >= b.MaxHops {
+		b.stats.HopLimited++
+		return mp, ErrHopLimit
+	}
+	now := b.now()
+	mac := mp.HardwareAddr().String()
+	if t, ok := b.sent[mac]; ok && now.Sub(t) < b.Window {
+		b.stats.Duplicates++
+		return mp, ErrDuplicate
+	}
+	if b.Budget != nil && !b.Budget.Allow(true) {
+		b.stats.RateLimited++
+		return nil, budget.ErrExceeded
+	}
+	if err := b.wakeFunc(mp.HardwareAddr(), Options{Source: src, Password: mp.Password(), Hops: hops + 1}); err != nil {
 		return nil, err
 	}
+	for k, t := range b.sent {
+		if now.Sub(t) >= b.Window {
+			delete(b.sent, k)
+		}
+	}
+	if b.Window > 0 {
+		b.sent[mac] = now
+	}
+	b.stats.Forwarded++
 	b.lastSent = mp
 	return mp, nil
 }
 
-func (b *Bridge) read() (MagicPacket, error) {
+// Stats returns the number of magic packets forwarded and dropped by b.
+func (b *Bridge) Stats() BridgeStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+// read reads a magic packet, and returns it together with the number of relays it has passed.
+func (b *Bridge) read() (MagicPacket, int, error) {
 	buf := make([]byte, 4096)
 	n, err := b.conn.Read(buf)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	mp := buf[:n]
+	mp, hops := splitHops(buf[:n])
 	if !IsMagicPacket(mp) {
-		return nil, fmt.Errorf("invalid magic packet: %x", mp)
+		return nil, 0, fmt.Errorf("%w: %x", ErrInvalidPacket, buf[:n])
 	}
-	return mp, nil
+	return mp, hops, nil
 }
@@ -2,9 +2,12 @@ package wol
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"testing"
+	"time"
 )
 
 type mockConn struct{ io.Reader }
@@ -12,33 +15,40 @@ type mockConn struct{ io.Reader }
 func (c *mockConn) Close() error { return nil }
 
 func TestBridgeRead(t *testing.T) {
-	b := Bridge{conn: &mockConn{bytes.NewReader(magicPacket)}}
-	mp, err := b.read()
+	b := newBridge(&mockConn{bytes.NewReader(magicPacket)})
+	mp, hops, err := b.read()
 	if err != nil {
 		t.Fatal(err)
 	}
 	want := "65:ac:81:13:8d:3f"
-	if got := mp.HardwareAddr().String(); got != want {
-		t.Errorf("want %s, got %s", want, got)
+	if got := mp.HardwareAddr().String(); got != want || hops != 0 {
+		t.Errorf("want %s and 0 hops, got %s and %d hops", want, got, hops)
+	}
+
+	b.conn = &mockConn{bytes.NewReader(withHops(NewMagicPacket(net.HardwareAddr{1, 2, 3, 4, 5, 6}), 2))}
+	mp, hops, err = b.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mp.HardwareAddr().String(); got != "01:02:03:04:05:06" || hops != 2 || len(mp) != magicPacketLen {
+		t.Errorf("want 01:02:03:04:05:06 and 2 hops, got %s and %d hops", got, hops)
 	}
 
 	b.conn = &mockConn{bytes.NewReader([]byte{1, 2, 3})}
 	want = "invalid magic packet: 010203"
-	if _, err := b.read(); err.Error() != want {
+	if _, _, err := b.read(); err.Error() != want {
 		t.Errorf("got %q, want %q", err.Error(), want)
 	}
 }
 
 func TestBridgeForward(t *testing.T) {
 	var target net.HardwareAddr
-	wake := func(src net.IP, hwAddr net.HardwareAddr) error {
+	wake := func(hwAddr net.HardwareAddr, _ Options) error {
 		target = hwAddr
 		return nil
 	}
-	b := Bridge{
-		conn:     &mockConn{bytes.NewReader(magicPacket)},
-		wakeFunc: wake,
-	}
+	b := newBridge(&mockConn{bytes.NewReader(magicPacket)})
+	b.wakeFunc = wake
 	if _, err := b.Forward(nil); err != nil {
 		t.Fatal(err)
 	}
@@ -50,15 +60,13 @@ func TestBridgeForward(t *testing.T) {
 
 func TestBridgeForwardPreventsLoop(t *testing.T) {
 	n := 0
-	wake := func(src net.IP, hwAddr net.HardwareAddr) error {
+	wake := func(hwAddr net.HardwareAddr, _ Options) error {
 		n += 1
 		return nil
 	}
 	var buf bytes.Buffer
-	b := Bridge{
-		conn:     &mockConn{&buf},
-		wakeFunc: wake,
-	}
+	b := newBridge(&mockConn{&buf})
+	b.wakeFunc = wake
 	// Same magic packet is received a second time, this likely means that we sent it ourself
 	for i := 0; i < 2; i++ {
 		buf.Write(magicPacket)
@@ -70,3 +78,100 @@ func TestBridgeForwardPreventsLoop(t *testing.T) {
 		t.Errorf("want 1 wake up, got %d", n)
 	}
 }
+
+func TestBridgeForwardFilter(t *testing.T) {
+	n := 0
+	wake := func(hwAddr net.HardwareAddr, _ Options) error {
+		n++
+		return nil
+	}
+	var buf bytes.Buffer
+	b := newBridge(&mockConn{&buf})
+	b.wakeFunc = wake
+	b.Filter = func(hwAddr net.HardwareAddr) bool { return hwAddr.String() == "65:ac:81:13:8d:3f" }
+	buf.Write(magicPacket)
+	if _, err := b.Forward(nil); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(NewMagicPacket(net.HardwareAddr{1, 2, 3, 4, 5, 6}))
+	if mp, err := b.Forward(nil); err != ErrFiltered || mp.HardwareAddr().String() != "01:02:03:04:05:06" {
+		t.Errorf("want %v for 01:02:03:04:05:06, got %v (%v)", ErrFiltered, mp, err)
+	}
+	buf.Write([]byte{1, 2, 3})
+	if _, err := b.Forward(nil); !errors.Is(err, ErrInvalidPacket) {
+		t.Errorf("want %v, got %v", ErrInvalidPacket, err)
+	}
+	if n != 1 {
+		t.Errorf("want 1 wake up, got %d", n)
+	}
+}
+
+func TestBridgeForwardSuppression(t *testing.T) {
+	var hops []int
+	wake := func(hwAddr net.HardwareAddr, opts Options) error {
+		hops = append(hops, opts.Hops)
+		return nil
+	}
+	var buf bytes.Buffer
+	b := newBridge(&mockConn{&buf})
+	b.wakeFunc = wake
+	b.Window = time.Second
+	b.MaxHops = 2
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+	other := NewMagicPacket(net.HardwareAddr{1, 2, 3, 4, 5, 6})
+	var tests = []struct {
+		packet  []byte
+		advance time.Duration
+		err     error
+	}{
+		{magicPacket, 0, nil},
+		{other, 0, nil},
+		{withHops(magicPacket, 1), 500 * time.Millisecond, ErrDuplicate},
+		{magicPacket, 500 * time.Millisecond, nil},
+		{withHops(other, 2), time.Second, ErrHopLimit},
+		{withHops(other, 1), 0, nil},
+	}
+	for i, tt := range tests {
+		now = now.Add(tt.advance)
+		buf.Write(tt.packet)
+		if _, err := b.Forward(nil); err != tt.err {
+			t.Errorf("#%d: want %v, got %v", i, tt.err, err)
+		}
+	}
+	if want := []int{1, 1, 1, 2}; fmt.Sprint(hops) != fmt.Sprint(want) {
+		t.Errorf("want hops %v, got %v", want, hops)
+	}
+	if want := (BridgeStats{Forwarded: 4, Duplicates: 1, HopLimited: 1}); b.Stats() != want {
+		t.Errorf("want stats %+v, got %+v", want, b.Stats())
+	}
+}
+
+func TestListenAll(t *testing.T) {
+	if _, err := ListenAll("tcp", "127.0.0.1:0"); err == nil || err.Error() != "invalid network: tcp" {
+		t.Errorf("want invalid network error, got %v", err)
+	}
+	b, err := ListenAll("udp4", "127.0.0.1:0", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Close(b)
+	mc := b.conn.(*multiConn)
+	for _, c := range mc.conns {
+		conn, err := net.Dial("udp4", c.(*net.UDPConn).LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(magicPacket); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		mp, _, err := b.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(mp, magicPacket) {
+			t.Errorf("want %v, got %v", magicPacket, mp)
+		}
+	}
+}
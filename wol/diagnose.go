@@ -0,0 +1,56 @@
+package wol
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Diagnosis describes the likely cause of a failed wake and a hint on how to fix it.
+type Diagnosis struct {
+	Cause string
+	Hint  string
+}
+
+// inContainer reports whether we are running inside a Docker container.
+var inContainer = func() bool {
+	_, err := os.Stat("/.dockerenv")
+	return err == nil
+}
+
+// Diagnose classifies an error returned by Wake.
+func Diagnose(err error) Diagnosis {
+	var (
+		socketErr *socketError
+		bindErr   *bindError
+	)
+	switch {
+	case errors.As(err, &socketErr) && (errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM)):
+		return Diagnosis{Cause: "permission_denied", Hint: "Sending Ethernet frames requires CAP_NET_RAW. Run as root or grant the capability, e.g. with --cap-add=NET_RAW"}
+	case errors.As(err, &bindErr) && (errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM)):
+		return Diagnosis{Cause: "permission_denied", Hint: "Binding to a network interface requires CAP_NET_RAW on this kernel. Run as root or grant the capability, e.g. with --cap-add=NET_RAW"}
+	case errors.As(err, &bindErr) && errors.Is(err, syscall.ENODEV):
+		return Diagnosis{Cause: "no_such_interface", Hint: "The network interface does not exist. Check the configured interface"}
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		d := Diagnosis{Cause: "network_unreachable", Hint: "No route to the broadcast address. Check that the source address or interface is connected to the target network"}
+		if inContainer() {
+			d.Hint = "Container is on a bridge network; broadcasts cannot reach the LAN. Run the container with --net=host or attach it to a macvlan network"
+		}
+		return d
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return Diagnosis{Cause: "permission_denied", Hint: "Sending broadcast packets was denied. Check firewall rules for outgoing UDP broadcasts"}
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		d := Diagnosis{Cause: "address_not_available", Hint: "Source address is not assigned to any local interface. Check the bind address and configured routes"}
+		if inContainer() {
+			d.Hint += "; host addresses are only visible to containers using host networking"
+		}
+		return d
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EHOSTDOWN):
+		return Diagnosis{Cause: "host_unreachable", Hint: "The host at the wake address did not accept magic packets. Check that the relay or host at the wake address is up and listening on the wake port"}
+	case errors.Is(err, ErrNoRoute):
+		return Diagnosis{Cause: "no_route", Hint: "No interface or source address is configured for the destination. Configure the interface or bind address magic packets are sent from"}
+	case errors.Is(err, ErrInvalidMAC), errors.Is(err, ErrInvalidOptions):
+		return Diagnosis{Cause: "invalid_options", Hint: "Check the MAC address, SecureOn password, wake address and transport of the device"}
+	}
+	return Diagnosis{Cause: "unknown", Hint: "Check the server log for details"}
+}
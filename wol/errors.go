@@ -0,0 +1,56 @@
+package wol
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Errors of sending magic packets. An error returned by WakeWith, WakeString, ParsePassword, ParseDestination or
+// Routes.Source is one of these according to errors.Is, while errors.As and errors.Is find its underlying cause, e.g. a
+// syscall.Errno.
+var (
+	// ErrInvalidMAC is returned when a hardware address is not a 48-bit MAC address.
+	ErrInvalidMAC = errors.New("invalid mac address")
+	// ErrInvalidOptions is returned when a password, destination, source address or transport is invalid.
+	ErrInvalidOptions = errors.New("invalid options")
+	// ErrNoRoute is returned when packets cannot reach their destination, e.g. because the network is unreachable, the
+	// relay or host at the wake address refused them, or no interface or source address is configured for a
+	// link-local destination.
+	ErrNoRoute = errors.New("no route to destination")
+	// ErrSendFailed is returned when packets could not be sent for any other reason, e.g. missing permissions.
+	ErrSendFailed = errors.New("could not send magic packet")
+)
+
+// wakeError classifies its cause as one of the errors of this package, keeping the message of the cause.
+type wakeError struct {
+	kind error
+	err  error
+}
+
+func (e *wakeError) Error() string { return e.err.Error() }
+
+func (e *wakeError) Unwrap() error { return e.err }
+
+func (e *wakeError) Is(target error) bool { return target == e.kind }
+
+// classify returns err classified as kind, unless it is nil or already classified.
+func classify(kind, err error) error {
+	var we *wakeError
+	if err == nil || errors.As(err, &we) {
+		return err
+	}
+	return &wakeError{kind: kind, err: err}
+}
+
+// classifySend classifies err returned when sending a packet.
+func classifySend(err error) error {
+	var bindErr *bindError
+	switch {
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.EADDRNOTAVAIL),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EHOSTDOWN):
+		return classify(ErrNoRoute, err)
+	case errors.As(err, &bindErr) && errors.Is(err, syscall.ENODEV):
+		return classify(ErrNoRoute, err)
+	}
+	return classify(ErrSendFailed, err)
+}
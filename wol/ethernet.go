@@ -0,0 +1,47 @@
+package wol
+
+import "fmt"
+
+// Transports of magic packets.
+const (
+	// TransportUDP sends magic packets in UDP datagrams. This is the default.
+	TransportUDP = "udp"
+	// TransportEthernet sends magic packets directly in Ethernet frames of type EtherType, which some devices
+	// require. Frames are broadcast on Options.Interface, and require CAP_NET_RAW.
+	TransportEthernet = "ethernet"
+)
+
+// EtherType is the type of Ethernet frames carrying a magic packet.
+const EtherType = 0x0842
+
+// ParseTransport verifies that s is a transport, and returns it. An empty transport is TransportUDP.
+func ParseTransport(s string) (string, error) {
+	switch s {
+	case "":
+		return TransportUDP, nil
+	case TransportUDP, TransportEthernet:
+		return s, nil
+	}
+	return "", classify(ErrInvalidOptions, fmt.Errorf("invalid transport: %s", s))
+}
+
+// socketError is the error of opening a raw socket.
+type socketError struct{ err error }
+
+func (e *socketError) Error() string { return fmt.Sprintf("could not open raw socket: %s", e.err) }
+
+func (e *socketError) Unwrap() error { return e.err }
+
+// sendEthernet broadcasts the magic packet p in an Ethernet frame on the interface named iface.
+var sendEthernet = rawSend
+
+// ethernetInterface returns the interface Ethernet frames sent with opts are sent on.
+func (opts Options) ethernetInterface() (string, error) {
+	if opts.Interface != "" {
+		return opts.Interface, nil
+	}
+	if opts.Source == nil {
+		return "", fmt.Errorf("an interface or source address is required to send Ethernet frames")
+	}
+	return interfaceOf(opts.Source)
+}
@@ -0,0 +1,26 @@
+package wol
+
+import (
+	"net"
+	"syscall"
+)
+
+// htons converts the 16-bit integer v to network byte order.
+func htons(v uint16) uint16 { return v<<8 | v>>8 }
+
+func rawSend(iface string, p MagicPacket) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	// The socket only sends, so it is opened without a protocol to not receive any frames. The kernel adds the
+	// Ethernet header from the link-layer address.
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return &socketError{err}
+	}
+	defer syscall.Close(fd)
+	addr := &syscall.SockaddrLinklayer{Protocol: htons(EtherType), Ifindex: ifi.Index, Halen: uint8(len(bcastAddr))}
+	copy(addr.Addr[:], bcastAddr)
+	return syscall.Sendto(fd, p, 0, addr)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package wol
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func rawSend(iface string, p MagicPacket) error {
+	return fmt.Errorf("sending Ethernet frames is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,67 @@
+package wol
+
+import (
+	"io"
+	"sync"
+)
+
+type packet struct {
+	data []byte
+	err  error
+}
+
+// multiConn reads packets from several connections.
+type multiConn struct {
+	conns   []io.ReadCloser
+	packets chan packet
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newMultiConn(conns []io.ReadCloser) *multiConn {
+	c := &multiConn{conns: conns, packets: make(chan packet), done: make(chan struct{})}
+	for _, conn := range conns {
+		go c.read(conn)
+	}
+	return c
+}
+
+func (c *multiConn) read(conn io.Reader) {
+	for {
+		buf := make([]byte, 4096)
+		n, err := conn.Read(buf)
+		select {
+		case c.packets <- packet{data: buf[:n], err: err}:
+		case <-c.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *multiConn) Read(b []byte) (int, error) {
+	select {
+	case p := <-c.packets:
+		if p.err != nil {
+			return 0, p.err
+		}
+		return copy(b, p.data), nil
+	case <-c.done:
+		return 0, io.EOF
+	}
+}
+
+func (c *multiConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.done)
+		for _, conn := range c.conns {
+			if err1 := conn.Close(); err == nil {
+				err = err1
+			}
+		}
+	})
+	return err
+}
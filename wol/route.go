@@ -0,0 +1,114 @@
+package wol
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Route maps a target subnet to the source that should be used when waking devices in that subnet. The source is
+// either an IP address or the name of a network interface.
+type Route struct {
+	Subnet    *net.IPNet
+	SourceIP  net.IP
+	Interface string
+}
+
+// Routes is a list of routes.
+type Routes []Route
+
+// ParseRoute parses a route on the form SUBNET=SOURCE, e.g. 10.1.0.0/16=eth1 or 192.168.50.0/24=192.168.50.2.
+func ParseRoute(s string) (Route, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return Route{}, fmt.Errorf("invalid route: %s", s)
+	}
+	_, subnet, err := net.ParseCIDR(parts[0])
+	if err != nil {
+		return Route{}, fmt.Errorf("invalid route: %s: %s", s, err)
+	}
+	r := Route{Subnet: subnet}
+	if ip := net.ParseIP(parts[1]); ip != nil {
+		r.SourceIP = ip
+	} else {
+		r.Interface = parts[1]
+	}
+	return r, nil
+}
+
+// ParseRoutes parses each string in routes using ParseRoute.
+func ParseRoutes(routes []string) (Routes, error) {
+	rs := make(Routes, 0, len(routes))
+	for _, s := range routes {
+		r, err := ParseRoute(s)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+// Lookup returns the most specific route containing ip.
+func (rs Routes) Lookup(ip net.IP) (Route, bool) {
+	var match Route
+	matchLen := -1
+	for _, r := range rs {
+		if !r.Subnet.Contains(ip) {
+			continue
+		}
+		if n, _ := r.Subnet.Mask.Size(); n > matchLen {
+			match = r
+			matchLen = n
+		}
+	}
+	return match, matchLen >= 0
+}
+
+// Source returns the source IP of the most specific route containing ip. If no route matches, nil is returned.
+func (rs Routes) Source(ip net.IP) (net.IP, error) {
+	r, ok := rs.Lookup(ip)
+	if !ok {
+		return nil, nil
+	}
+	if r.SourceIP != nil {
+		return r.SourceIP, nil
+	}
+	ip, err := interfaceIP(r.Interface, isIPv6(ip))
+	return ip, classify(ErrNoRoute, err)
+}
+
+// interfaceIP returns an address of the interface name, of the IPv6 family if ipv6 is true, or else IPv4. Global IPv6
+// addresses are preferred over link-local ones.
+func interfaceIP(name string, ipv6 bool) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var linkLocal net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || isIPv6(ipNet.IP) != ipv6 {
+			continue
+		}
+		if ipv6 && ipNet.IP.IsLinkLocalUnicast() {
+			if linkLocal == nil {
+				linkLocal = ipNet.IP
+			}
+			continue
+		}
+		return ipNet.IP, nil
+	}
+	if linkLocal != nil {
+		return linkLocal, nil
+	}
+	family := "IPv4"
+	if ipv6 {
+		family = "IPv6"
+	}
+	return nil, fmt.Errorf("interface %s has no %s address", name, family)
+}
@@ -0,0 +1,65 @@
+package wol
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseRoute(t *testing.T) {
+	var tests = []struct {
+		in        string
+		subnet    string
+		sourceIP  string
+		iface     string
+		errString string
+	}{
+		{"10.1.0.0/16=eth1", "10.1.0.0/16", "", "eth1", ""},
+		{"192.168.50.0/24=192.168.50.2", "192.168.50.0/24", "192.168.50.2", "", ""},
+		{"10.1.0.0/16", "", "", "", "invalid route: 10.1.0.0/16"},
+		{"10.1.0.0/16=", "", "", "", "invalid route: 10.1.0.0/16="},
+		{"foo=eth0", "", "", "", "invalid route: foo=eth0: invalid CIDR address: foo"},
+	}
+	for i, tt := range tests {
+		r, err := ParseRoute(tt.in)
+		if err != nil {
+			if err.Error() != tt.errString {
+				t.Errorf("#%d: want error %q, got %q", i, tt.errString, err.Error())
+			}
+			continue
+		}
+		if got := r.Subnet.String(); got != tt.subnet {
+			t.Errorf("#%d: want subnet %s, got %s", i, tt.subnet, got)
+		}
+		if tt.sourceIP != "" && r.SourceIP.String() != tt.sourceIP {
+			t.Errorf("#%d: want source %s, got %s", i, tt.sourceIP, r.SourceIP)
+		}
+		if r.Interface != tt.iface {
+			t.Errorf("#%d: want interface %q, got %q", i, tt.iface, r.Interface)
+		}
+	}
+}
+
+func TestRoutesSource(t *testing.T) {
+	routes, err := ParseRoutes([]string{"10.0.0.0/8=10.0.0.1", "10.1.0.0/16=10.1.0.1", "fd00::/64=fd00::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{"10.2.3.4", "10.0.0.1"},
+		{"10.1.3.4", "10.1.0.1"},
+		{"192.168.1.1", "<nil>"},
+		{"fd00::2", "fd00::1"},
+	}
+	for i, tt := range tests {
+		src, err := routes.Source(net.ParseIP(tt.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := src.String(); got != tt.out {
+			t.Errorf("#%d: want %s, got %s", i, tt.out, got)
+		}
+	}
+}
@@ -5,10 +5,15 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strings"
+	"time"
 )
 
 const hwAddrN = 16
 
+// PasswordLen is the length of a SecureOn password.
+const PasswordLen = 6
+
 var (
 	bcastAddr    = []byte{255, 255, 255, 255, 255, 255}
 	bcastAddrOff = len(bcastAddr)
@@ -21,6 +26,17 @@ func (p MagicPacket) HardwareAddr() net.HardwareAddr {
 	return net.HardwareAddr(p[bcastAddrOff : bcastAddrOff*2])
 }
 
+// Password returns the SecureOn password of the packet, if any.
+func (p MagicPacket) Password() []byte {
+	if len(p) <= magicPacketLen {
+		return nil
+	}
+	return p[magicPacketLen:]
+}
+
+// magicPacketLen is the length of a magic packet without a password.
+const magicPacketLen = 102
+
 // Create a magic packet for the given hwAddr.
 func NewMagicPacket(hwAddr net.HardwareAddr) MagicPacket {
 	p := make([]byte, bcastAddrOff+(hwAddrN*len(hwAddr)))
@@ -29,54 +45,282 @@ func NewMagicPacket(hwAddr net.HardwareAddr) MagicPacket {
 	return p
 }
 
-// IsMagicPacket reports whether the byte array is a magic packet.
+// NewMagicPacketWithPassword creates a magic packet for the given hwAddr, followed by a SecureOn password. The packet
+// has no password if password is empty.
+func NewMagicPacketWithPassword(hwAddr net.HardwareAddr, password []byte) (MagicPacket, error) {
+	if len(password) != 0 && len(password) != PasswordLen {
+		return nil, classify(ErrInvalidOptions, fmt.Errorf("invalid password length: %d", len(password)))
+	}
+	return append(NewMagicPacket(hwAddr), password...), nil
+}
+
+// ParsePassword parses a SecureOn password written as six hexadecimal bytes, in the same formats as a MAC address,
+// e.g. 01:23:45:67:89:ab.
+func ParsePassword(s string) ([]byte, error) {
+	password, err := net.ParseMAC(s)
+	if err != nil || len(password) != PasswordLen {
+		return nil, classify(ErrInvalidOptions, fmt.Errorf("invalid password: %s", s))
+	}
+	return password, nil
+}
+
+// IsMagicPacket reports whether the byte array is a magic packet, optionally followed by a SecureOn password.
 func IsMagicPacket(b []byte) bool {
-	if len(b) != 102 {
+	if len(b) != magicPacketLen && len(b) != magicPacketLen+PasswordLen {
 		return false
 	}
 	if !bytes.Equal(b[:6], bcastAddr) {
 		return false
 	}
 	hwAddr := MagicPacket(b).HardwareAddr()
-	return bytes.Equal(b[bcastAddrOff:], bytes.Repeat(hwAddr, hwAddrN))
+	return bytes.Equal(b[bcastAddrOff:magicPacketLen], bytes.Repeat(hwAddr, hwAddrN))
 }
 
 // Wake sends a magic packet for hwAddr to the broadcast address. If src is not nil, it is used as the local address for
-// the broadcast.
-func Wake(src net.IP, hwAddr net.HardwareAddr) error {
-	var laddr *net.UDPAddr
-	if src != nil {
-		laddr = &net.UDPAddr{IP: src}
+// the broadcast. If password is not empty, it is sent as the SecureOn password of the packet.
+func Wake(src net.IP, hwAddr net.HardwareAddr, password []byte) error {
+	return WakeWith(hwAddr, Options{Source: src, Password: password})
+}
+
+// WakeN sends n magic packets for hwAddr to the broadcast address, waiting interval between them, as a single packet
+// is easily lost, e.g. by wireless bridges.
+func WakeN(src net.IP, hwAddr net.HardwareAddr, n int, interval time.Duration) error {
+	return WakeWith(hwAddr, Options{Source: src, Count: n, Interval: interval})
+}
+
+// DefaultPort is the UDP port magic packets are sent to, unless configured.
+const DefaultPort = 9
+
+// IPv6AllNodes is the link-local all-nodes multicast address, to which magic packets are sent over IPv6 unless
+// configured.
+var IPv6AllNodes = net.ParseIP("ff02::1")
+
+// Options configures how a magic packet is sent.
+type Options struct {
+	// Source is the local address of the packet, if set.
+	Source net.IP
+	// Destination is the address the packet is sent to, e.g. the directed broadcast address of another subnet, or
+	// the unicast address of a device whose router has a static ARP entry for it. Defaults to the limited broadcast
+	// address, or IPv6AllNodes if Source is an IPv6 address.
+	Destination net.IP
+	// Interface is the network interface the packet is sent on. On Linux, the socket is bound to it with
+	// SO_BINDTODEVICE, which requires CAP_NET_RAW on older kernels. Elsewhere, it only selects the interface of
+	// link-local packets, such as those sent to IPv6AllNodes, which defaults to the interface having the Source address.
+	Interface string
+	// Port is the UDP port the packet is sent to, commonly 7 or 9. Defaults to DefaultPort.
+	Port int
+	// Password is the SecureOn password of the packet, if any.
+	Password []byte
+	// Transport is how the packet is sent, one of TransportUDP or TransportEthernet. Defaults to TransportUDP. Only
+	// Interface and Source, from which the interface is found if Interface is unset, apply to TransportEthernet.
+	Transport string
+	// Count is the number of packets sent in a burst. Defaults to 1.
+	Count int
+	// Interval is the delay between the packets of a burst, and before each retry.
+	Interval time.Duration
+	// Retries is the number of times a burst is sent again if sending it fails.
+	Retries int
+	// Hops is the number of relays the packet has passed, which is appended to the packet as relay metadata if set.
+	Hops int
+}
+
+// sleep is the function used to wait between packets.
+var sleep = time.Sleep
+
+// WakeWith sends a magic packet for hwAddr as configured by opts. The error returned, if any, is one of the errors of
+// this package according to errors.Is.
+func WakeWith(hwAddr net.HardwareAddr, opts Options) error {
+	if len(hwAddr) != 6 {
+		return classify(ErrInvalidMAC, fmt.Errorf("invalid mac address: %s", hwAddr))
+	}
+	p, err := NewMagicPacketWithPassword(hwAddr, opts.Password)
+	if err != nil {
+		return err
+	}
+	if opts.Hops > 0 {
+		p = withHops(p, opts.Hops)
 	}
-	raddr := &net.UDPAddr{IP: net.IPv4bcast, Port: 9}
-	conn, err := net.DialUDP("udp", laddr, raddr)
+	transport, err := ParseTransport(opts.Transport)
 	if err != nil {
 		return err
 	}
-	p := NewMagicPacket(hwAddr)
-	n, err := conn.Write([]byte(p))
-	if err == nil && n < len(p) {
-		return io.ErrShortWrite
+	var send func() error
+	if transport == TransportEthernet {
+		iface, err := opts.ethernetInterface()
+		if err != nil {
+			return classify(ErrNoRoute, err)
+		}
+		send = func() error { return burst(opts.Count, opts.Interval, func() error { return sendEthernet(iface, p) }) }
+	} else {
+		laddr, raddr, err := opts.addrs()
+		if err != nil {
+			return classify(ErrNoRoute, err)
+		}
+		d := net.Dialer{Control: bindControl(opts.Interface)}
+		if laddr != nil {
+			d.LocalAddr = laddr
+		}
+		send = func() error { return sendUDP(d, raddr, p, opts.Count, opts.Interval) }
+	}
+	for retry := 0; ; retry++ {
+		if err = send(); err == nil || retry >= opts.Retries {
+			return classifySend(err)
+		}
+		sleep(opts.Interval)
+	}
+}
+
+// burst calls send n times, at least once, waiting interval between calls.
+func burst(n int, interval time.Duration, send func() error) error {
+	for i := 0; i == 0 || i < n; i++ {
+		if i > 0 {
+			sleep(interval)
+		}
+		if err := send(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendUDP sends a burst of n packets p to raddr, over a connection dialed by d.
+func sendUDP(d net.Dialer, raddr *net.UDPAddr, p MagicPacket, n int, interval time.Duration) error {
+	conn, err := d.Dial("udp", raddr.String())
+	if err != nil {
+		return err
 	}
-	if err1 := conn.Close(); err1 != nil {
+	err = burst(n, interval, func() error {
+		w, err := conn.Write([]byte(p))
+		if err == nil && w < len(p) {
+			err = io.ErrShortWrite
+		}
+		return err
+	})
+	if err1 := conn.Close(); err == nil {
 		err = err1
 	}
 	return err
 }
 
+func isIPv6(ip net.IP) bool { return ip != nil && ip.To4() == nil }
+
+// addrs returns the local and remote address of packets sent with opts.
+func (opts Options) addrs() (*net.UDPAddr, *net.UDPAddr, error) {
+	raddr := &net.UDPAddr{IP: opts.Destination, Port: opts.Port}
+	if raddr.IP == nil {
+		raddr.IP = net.IPv4bcast
+		if isIPv6(opts.Source) {
+			raddr.IP = IPv6AllNodes
+		}
+	}
+	if raddr.Port == 0 {
+		raddr.Port = DefaultPort
+	}
+	var laddr *net.UDPAddr
+	if opts.Source != nil {
+		laddr = &net.UDPAddr{IP: opts.Source}
+	}
+	linkLocal := isIPv6(raddr.IP) && (raddr.IP.IsLinkLocalMulticast() || raddr.IP.IsLinkLocalUnicast())
+	if linkLocal || (laddr != nil && isIPv6(laddr.IP) && laddr.IP.IsLinkLocalUnicast()) {
+		zone := opts.Interface
+		if zone == "" && opts.Source != nil {
+			var err error
+			if zone, err = interfaceOf(opts.Source); err != nil {
+				return nil, nil, err
+			}
+		}
+		if zone == "" {
+			return nil, nil, fmt.Errorf("an interface or source address is required to send to %s", raddr.IP)
+		}
+		raddr.Zone = zone
+		if laddr != nil && laddr.IP.IsLinkLocalUnicast() {
+			laddr.Zone = zone
+		}
+	}
+	return laddr, raddr, nil
+}
+
+// bindError is the error of binding a socket to a network interface.
+type bindError struct {
+	iface string
+	err   error
+}
+
+func (e *bindError) Error() string {
+	return fmt.Sprintf("could not bind to interface %s: %s", e.iface, e.err)
+}
+
+func (e *bindError) Unwrap() error { return e.err }
+
+// interfaceOf returns the name of the network interface having address ip.
+func interfaceOf(ip net.IP) (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no interface has address %s", ip)
+}
+
+// ParseDestination parses the destination of magic packets, which is either an IP address or a subnet in CIDR
+// notation, e.g. 192.168.2.0/24, whose directed broadcast address is returned. An IPv6 address may have a zone naming
+// the interface to send on, e.g. ff02::1%eth0, which is returned as well.
+func ParseDestination(s string) (net.IP, string, error) {
+	if !strings.Contains(s, "/") {
+		addr, zone := s, ""
+		if i := strings.LastIndex(s, "%"); i >= 0 {
+			addr, zone = s[:i], s[i+1:]
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil || (zone != "" && !isIPv6(ip)) || (zone == "" && addr != s) {
+			return nil, "", classify(ErrInvalidOptions, fmt.Errorf("invalid destination: %s", s))
+		}
+		return ip, zone, nil
+	}
+	_, subnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, "", classify(ErrInvalidOptions, fmt.Errorf("invalid destination: %s", s))
+	}
+	ip := subnet.IP.To4()
+	if ip == nil {
+		return nil, "", classify(ErrInvalidOptions, fmt.Errorf("invalid destination: %s: subnet must be IPv4", s))
+	}
+	bcast := make(net.IP, len(ip))
+	for i := range ip {
+		bcast[i] = ip[i] | ^subnet.Mask[i]
+	}
+	return bcast, "", nil
+}
+
 // WakeString sends a magic packet for macAddr to the broadcast address. If srcIP non-empty, it is used as the local
-// address for the broadcast.
-func WakeString(srcIP, macAddr string) error {
+// address for the broadcast. If password is non-empty, it is parsed by ParsePassword and sent as the SecureOn password.
+func WakeString(srcIP, macAddr, password string) error {
 	hwAddr, err := net.ParseMAC(macAddr)
 	if err != nil {
-		return err
+		return classify(ErrInvalidMAC, err)
+	}
+	var pw []byte
+	if password != "" {
+		if pw, err = ParsePassword(password); err != nil {
+			return err
+		}
 	}
 	var src net.IP
 	if srcIP != "" {
 		src = net.ParseIP(srcIP)
 		if src == nil {
-			return fmt.Errorf("invalid ip: %s", srcIP)
+			return classify(ErrInvalidOptions, fmt.Errorf("invalid ip: %s", srcIP))
 		}
 	}
-	return Wake(src, hwAddr)
+	return Wake(src, hwAddr, pw)
 }
@@ -2,8 +2,13 @@ package wol
 
 import (
 	"bytes"
+	"errors"
 	"net"
+	"os"
+	"runtime"
+	"syscall"
 	"testing"
+	"time"
 )
 
 var magicPacket = []byte{
@@ -37,6 +42,326 @@ func TestNewMagicPacket(t *testing.T) {
 	}
 }
 
+func TestNewMagicPacketWithPassword(t *testing.T) {
+	hwAddr, err := net.ParseMAC("65:ac:81:13:8d:3f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		password string
+		out      []byte
+		err      string
+	}{
+		{"", magicPacket, ""},
+		{"01:02:03:04:05:06", append(magicPacket[:len(magicPacket):len(magicPacket)], 1, 2, 3, 4, 5, 6), ""},
+		{"01-02-03-04-05-06", append(magicPacket[:len(magicPacket):len(magicPacket)], 1, 2, 3, 4, 5, 6), ""},
+		{"01:02:03:04", nil, "invalid password: 01:02:03:04"},
+		{"foo", nil, "invalid password: foo"},
+	}
+	for i, tt := range tests {
+		var password []byte
+		if tt.password != "" {
+			password, err = ParsePassword(tt.password)
+			if err != nil {
+				if err.Error() != tt.err {
+					t.Errorf("#%d: want error %q, got %q", i, tt.err, err)
+				}
+				continue
+			}
+		}
+		p, err := NewMagicPacketWithPassword(hwAddr, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p, tt.out) {
+			t.Errorf("#%d: want %v, got %v", i, tt.out, p)
+		}
+		if !bytes.Equal(p.Password(), password) {
+			t.Errorf("#%d: want password %v, got %v", i, password, p.Password())
+		}
+	}
+	if _, err := NewMagicPacketWithPassword(hwAddr, []byte{1, 2, 3}); err == nil {
+		t.Error("want error for short password")
+	}
+}
+
+func TestWakeWith(t *testing.T) {
+	hwAddr, err := net.ParseMAC("65:ac:81:13:8d:3f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback} {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+		if err != nil {
+			if isIPv6(ip) {
+				t.Logf("skipping IPv6: %s", err)
+				continue
+			}
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		addr := conn.LocalAddr().(*net.UDPAddr)
+		opts := Options{Source: ip, Destination: addr.IP, Port: addr.Port, Password: []byte{1, 2, 3, 4, 5, 6}}
+		if err := WakeWith(hwAddr, opts); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 256)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := MagicPacket(buf[:n])
+		if !IsMagicPacket(p) || p.HardwareAddr().String() != hwAddr.String() || !bytes.Equal(p.Password(), opts.Password) {
+			t.Errorf("want magic packet for %s with password %v, got %v", hwAddr, opts.Password, p)
+		}
+	}
+}
+
+func TestWakeWithInterface(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("binding to an interface is not supported on %s", runtime.GOOS)
+	}
+	hwAddr, err := net.ParseMAC("65:ac:81:13:8d:3f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	err = WakeWith(hwAddr, Options{Destination: addr.IP, Port: addr.Port, Interface: "lo"})
+	if errors.Is(err, syscall.EPERM) {
+		t.Skipf("binding to an interface requires privileges: %s", err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 256)
+	if n, err := conn.Read(buf); err != nil || !IsMagicPacket(buf[:n]) {
+		t.Errorf("want magic packet, got %v (%v)", buf[:n], err)
+	}
+	err = WakeWith(hwAddr, Options{Destination: addr.IP, Port: addr.Port, Interface: "nonexistent0"})
+	if d := Diagnose(err); d.Cause != "no_such_interface" && !errors.Is(err, syscall.EPERM) {
+		t.Errorf("want error diagnosed as no_such_interface, got %v (%s)", err, d.Cause)
+	}
+}
+
+func TestWakeEthernet(t *testing.T) {
+	defer func(f func(string, MagicPacket) error) { sendEthernet = f }(sendEthernet)
+	var sent []string
+	sendEthernet = func(iface string, p MagicPacket) error {
+		sent = append(sent, iface+" "+p.HardwareAddr().String())
+		return nil
+	}
+	hwAddr, err := net.ParseMAC("65:ac:81:13:8d:3f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WakeWith(hwAddr, Options{Transport: TransportEthernet, Interface: "eth1"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "eth1 65:ac:81:13:8d:3f"; len(sent) != 1 || sent[0] != want {
+		t.Errorf("want frame sent %s, got %v", want, sent)
+	}
+	var tests = []struct {
+		opts Options
+		err  string
+	}{
+		{Options{Transport: TransportEthernet}, "an interface or source address is required to send Ethernet frames"},
+		{Options{Transport: "foo"}, "invalid transport: foo"},
+	}
+	for _, tt := range tests {
+		if err := WakeWith(hwAddr, tt.opts); err == nil || err.Error() != tt.err {
+			t.Errorf("want error %q, got %v", tt.err, err)
+		}
+	}
+}
+
+func TestWakeErrors(t *testing.T) {
+	defer func(f func(string, MagicPacket) error) { sendEthernet = f }(sendEthernet)
+	sendEthernet = func(iface string, p MagicPacket) error {
+		if iface == "eth2" {
+			return &net.OpError{Op: "write", Err: os.NewSyscallError("sendto", syscall.ENETUNREACH)}
+		}
+		return &socketError{syscall.EPERM}
+	}
+	hwAddr, err := net.ParseMAC("65:ac:81:13:8d:3f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		hwAddr net.HardwareAddr
+		opts   Options
+		kind   error
+		err    string
+	}{
+		{net.HardwareAddr{1, 2, 3}, Options{}, ErrInvalidMAC, "invalid mac address: 01:02:03"},
+		{hwAddr, Options{Password: []byte{1}}, ErrInvalidOptions, "invalid password length: 1"},
+		{hwAddr, Options{Transport: "foo"}, ErrInvalidOptions, "invalid transport: foo"},
+		{hwAddr, Options{Destination: IPv6AllNodes}, ErrNoRoute, "an interface or source address is required to send to ff02::1"},
+		{hwAddr, Options{Transport: TransportEthernet, Interface: "eth2"}, ErrNoRoute, "write: sendto: network is unreachable"},
+		{hwAddr, Options{Transport: TransportEthernet, Interface: "eth1"}, ErrSendFailed, "could not open raw socket: operation not permitted"},
+	}
+	for i, tt := range tests {
+		err := WakeWith(tt.hwAddr, tt.opts)
+		if !errors.Is(err, tt.kind) || err.Error() != tt.err {
+			t.Errorf("#%d: want %q classified as %q, got %v", i, tt.err, tt.kind, err)
+		}
+	}
+	// The cause is kept
+	err = WakeWith(hwAddr, Options{Transport: TransportEthernet, Interface: "eth1"})
+	var socketErr *socketError
+	if !errors.Is(err, syscall.EPERM) || !errors.As(err, &socketErr) || errors.Is(err, ErrNoRoute) {
+		t.Errorf("want socket error caused by EPERM, got %v", err)
+	}
+	// Packets refused by the host at the destination cannot reach it
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	conn.Close()
+	defer func(f func(time.Duration)) { sleep = f }(sleep)
+	sleep = func(time.Duration) {}
+	err = WakeWith(hwAddr, Options{Destination: addr.IP, Port: addr.Port, Count: 3})
+	if !errors.Is(err, ErrNoRoute) || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("want connection refused classified as %q, got %v", ErrNoRoute, err)
+	}
+	if err := WakeString("", "foo", ""); !errors.Is(err, ErrInvalidMAC) {
+		t.Errorf("want %q, got %v", ErrInvalidMAC, err)
+	}
+	if _, _, err := ParseDestination("foo"); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("want %q, got %v", ErrInvalidOptions, err)
+	}
+}
+
+func TestWakeBurst(t *testing.T) {
+	defer func(f func(time.Duration)) { sleep = f }(sleep)
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	hwAddr, err := net.ParseMAC("65:ac:81:13:8d:3f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	if err := WakeWith(hwAddr, Options{Destination: addr.IP, Port: addr.Port, Count: 3, Interval: 50 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 256)
+	for i := 0; i < 3; i++ {
+		if n, err := conn.Read(buf); err != nil || !IsMagicPacket(buf[:n]) {
+			t.Fatalf("packet #%d: want magic packet, got %v (%v)", i, buf[:n], err)
+		}
+	}
+	if len(slept) != 2 || slept[0] != 50*time.Millisecond {
+		t.Errorf("want 2 waits of 50ms, got %v", slept)
+	}
+
+	// Bursts are sent again on failure
+	defer func(f func(string, MagicPacket) error) { sendEthernet = f }(sendEthernet)
+	var tests = []struct {
+		count, retries, failures int
+		sent                     int
+		err                      bool
+	}{
+		{0, 0, 0, 1, false},
+		{2, 0, 0, 2, false},
+		{2, 0, 1, 0, true},
+		{2, 2, 1, 2, false},
+		{2, 1, 2, 0, true},
+		{1, 3, 3, 1, false},
+	}
+	for i, tt := range tests {
+		sent, failures := 0, tt.failures
+		sendEthernet = func(iface string, p MagicPacket) error {
+			if failures > 0 {
+				failures--
+				return errors.New("network is down")
+			}
+			sent++
+			return nil
+		}
+		err := WakeWith(hwAddr, Options{Transport: TransportEthernet, Interface: "eth1", Count: tt.count, Retries: tt.retries})
+		if (err != nil) != tt.err {
+			t.Errorf("#%d: want error %t, got %v", i, tt.err, err)
+		}
+		if sent != tt.sent {
+			t.Errorf("#%d: want %d frames sent, got %d", i, tt.sent, sent)
+		}
+	}
+}
+
+func TestParseDestination(t *testing.T) {
+	var tests = []struct {
+		in  string
+		out string
+		err string
+	}{
+		{"192.168.2.255", "192.168.2.255", ""},
+		{"10.0.0.2", "10.0.0.2", ""},
+		{"192.168.2.0/24", "192.168.2.255", ""},
+		{"10.1.0.0/16", "10.1.255.255", ""},
+		{"10.1.2.3/8", "10.255.255.255", ""},
+		{"foo", "", "invalid destination: foo"},
+		{"10.0.0.0/33", "", "invalid destination: 10.0.0.0/33"},
+		{"2001:db8::/64", "", "invalid destination: 2001:db8::/64: subnet must be IPv4"},
+		{"2001:db8::2", "2001:db8::2", ""},
+		{"ff02::1%eth0", "ff02::1%eth0", ""},
+		{"ff02::1%", "", "invalid destination: ff02::1%"},
+		{"10.0.0.2%eth0", "", "invalid destination: 10.0.0.2%eth0"},
+	}
+	for i, tt := range tests {
+		ip, zone, err := ParseDestination(tt.in)
+		if err != nil {
+			if err.Error() != tt.err {
+				t.Errorf("#%d: want error %q, got %q", i, tt.err, err)
+			}
+			continue
+		}
+		if got := (&net.IPAddr{IP: ip, Zone: zone}).String(); got != tt.out {
+			t.Errorf("#%d: want %s, got %s", i, tt.out, got)
+		}
+	}
+}
+
+func TestOptionsAddrs(t *testing.T) {
+	var tests = []struct {
+		opts  Options
+		laddr string
+		raddr string
+		err   string
+	}{
+		{Options{}, "<nil>", "255.255.255.255:9", ""},
+		{Options{Source: net.ParseIP("10.0.0.2"), Port: 7}, "10.0.0.2:0", "255.255.255.255:7", ""},
+		{Options{Destination: net.ParseIP("2001:db8::2")}, "<nil>", "[2001:db8::2]:9", ""},
+		{Options{Interface: "eth0", Destination: IPv6AllNodes}, "<nil>", "[ff02::1%eth0]:9", ""},
+		{Options{Source: net.ParseIP("fe80::2"), Interface: "eth1"}, "[fe80::2%eth1]:0", "[ff02::1%eth1]:9", ""},
+		{Options{Destination: IPv6AllNodes}, "", "", "an interface or source address is required to send to ff02::1"},
+		{Options{Source: net.ParseIP("2001:db8::3")}, "", "", "no interface has address 2001:db8::3"},
+	}
+	for i, tt := range tests {
+		laddr, raddr, err := tt.opts.addrs()
+		if err != nil {
+			if err.Error() != tt.err {
+				t.Errorf("#%d: want error %q, got %q", i, tt.err, err)
+			}
+			continue
+		}
+		if got := laddr.String(); got != tt.laddr {
+			t.Errorf("#%d: want local address %s, got %s", i, tt.laddr, got)
+		}
+		if got := raddr.String(); got != tt.raddr {
+			t.Errorf("#%d: want remote address %s, got %s", i, tt.raddr, got)
+		}
+	}
+}
+
 func TestIsMagicPacket(t *testing.T) {
 	var tests = []struct {
 		in  []byte
@@ -45,6 +370,8 @@ func TestIsMagicPacket(t *testing.T) {
 		{[]byte{}, false},
 		{[]byte{1, 2, 3}, false},
 		{magicPacket, true},
+		{append(magicPacket[:len(magicPacket):len(magicPacket)], 1, 2, 3, 4, 5, 6), true},
+		{append(magicPacket[:len(magicPacket):len(magicPacket)], 1, 2, 3), false},
 	}
 	for i, tt := range tests {
 		if IsMagicPacket(tt.in) != tt.out {
@@ -52,3 +379,35 @@ func TestIsMagicPacket(t *testing.T) {
 		}
 	}
 }
+
+func TestDiagnose(t *testing.T) {
+	defer func(f func() bool) { inContainer = f }(inContainer)
+	var tests = []struct {
+		err       error
+		container bool
+		cause     string
+		hint      string
+	}{
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}, false, "network_unreachable", "No route to the broadcast address. Check that the source address or interface is connected to the target network"},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}, true, "network_unreachable", "Container is on a bridge network; broadcasts cannot reach the LAN. Run the container with --net=host or attach it to a macvlan network"},
+		{&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPERM)}, false, "permission_denied", "Sending broadcast packets was denied. Check firewall rules for outgoing UDP broadcasts"},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("bind", syscall.EADDRNOTAVAIL)}, false, "address_not_available", "Source address is not assigned to any local interface. Check the bind address and configured routes"},
+		{&net.OpError{Op: "dial", Err: &bindError{"eth1", syscall.EPERM}}, false, "permission_denied", "Binding to a network interface requires CAP_NET_RAW on this kernel. Run as root or grant the capability, e.g. with --cap-add=NET_RAW"},
+		{&net.OpError{Op: "dial", Err: &bindError{"eth1", syscall.ENODEV}}, false, "no_such_interface", "The network interface does not exist. Check the configured interface"},
+		{&socketError{syscall.EPERM}, true, "permission_denied", "Sending Ethernet frames requires CAP_NET_RAW. Run as root or grant the capability, e.g. with --cap-add=NET_RAW"},
+		{&net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.ECONNREFUSED)}, false, "host_unreachable", "The host at the wake address did not accept magic packets. Check that the relay or host at the wake address is up and listening on the wake port"},
+		{classify(ErrNoRoute, errors.New("an interface or source address is required to send to ff02::1")), false, "no_route", "No interface or source address is configured for the destination. Configure the interface or bind address magic packets are sent from"},
+		{classify(ErrInvalidOptions, errors.New("invalid transport: foo")), false, "invalid_options", "Check the MAC address, SecureOn password, wake address and transport of the device"},
+		{errors.New("foo"), false, "unknown", "Check the server log for details"},
+	}
+	for i, tt := range tests {
+		inContainer = func() bool { return tt.container }
+		d := Diagnose(tt.err)
+		if d.Cause != tt.cause {
+			t.Errorf("#%d: want cause %q, got %q", i, tt.cause, d.Cause)
+		}
+		if d.Hint != tt.hint {
+			t.Errorf("#%d: want hint %q, got %q", i, tt.hint, d.Hint)
+		}
+	}
+}